@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	_websocket "github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+// byzantineClient is a node connection which sends whatever it is told to, signed
+// with the chain key of the node.
+type byzantineClient struct {
+	conn   *websocket.Conn
+	signer wallet.Signer
+}
+
+func dialByzantine(url string, w wallet.Wallet) (*byzantineClient, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to connect to %s", url)
+	}
+	return &byzantineClient{conn: conn, signer: wallet.NewSigner(w)}, nil
+}
+
+func (c *byzantineClient) Close() {
+	c.conn.Close()
+}
+
+func (c *byzantineClient) Conn() *websocket.Conn {
+	return c.conn
+}
+
+// Sign signs the message the way an honest node would.
+func (c *byzantineClient) Sign(message _websocket.Message, body interface{}) (_websocket.Ping, error) {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return _websocket.Ping{}, errors.Wrapf(err, "Failed to marshal body of %s", message)
+	}
+	ping := _websocket.Ping{
+		Message: message,
+		Body:    raw,
+		Sender:  c.signer.Verifier(),
+	}
+	ping.Signature, err = c.signer.Sign(ping)
+	if err != nil {
+		return _websocket.Ping{}, errors.Wrapf(err, "Failed to sign %s", message)
+	}
+	return ping, nil
+}
+
+func (c *byzantineClient) Send(ping _websocket.Ping) error {
+	if err := c.conn.WriteJSON(ping); err != nil {
+		return errors.Wrapf(err, "Failed to send %s", ping.Message)
+	}
+	return nil
+}
+
+// SendRaw sends a text message of the given size, which is not even a
+// valid ping.
+func (c *byzantineClient) SendRaw(size int) error {
+	payload := make([]byte, size)
+	for i := range payload {
+		payload[i] = 'x'
+	}
+	if err := c.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+		return errors.Wrapf(err, "Failed to send %d bytes", size)
+	}
+	return nil
+}
+
+// Receive waits for the next message. It returns nil if none arrived in
+// time and an error if alfa closed the connection. The connection can't
+// be used anymore once waiting timed out.
+func (c *byzantineClient) Receive(wait time.Duration) (*_websocket.Ping, error) {
+	c.conn.SetReadDeadline(time.Now().Add(wait))
+	var ping _websocket.Ping
+	err := c.conn.ReadJSON(&ping)
+	if netErr, ok := errors.Cause(err).(net.Error); ok && netErr.Timeout() {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ping, nil
+}
+
+// Disconnected tells whether alfa closed the connection within wait,
+// skipping messages alfa sent meanwhile.
+func (c *byzantineClient) Disconnected(wait time.Duration) bool {
+	deadline := time.Now().Add(wait)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false
+		}
+		ping, err := c.Receive(remaining)
+		switch {
+		case err != nil:
+			return true
+		case ping == nil:
+			return false
+		}
+	}
+}
+
+// Rejected fails unless alfa answers the last message with an error or
+// closes the connection within wait. Messages sent by alfa meanwhile are
+// skipped.
+func (c *byzantineClient) Rejected(wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return errors.Errorf("Message is not rejected in %s", wait)
+		}
+		ping, err := c.Receive(remaining)
+		switch {
+		case err != nil:
+			return nil
+		case ping == nil:
+			return errors.Errorf("Message is not rejected in %s", wait)
+		case ping.Message == _websocket.ErrorMessage:
+			return nil
+		}
+	}
+}
+
+func randomID() []byte {
+	id := make([]byte, 32)
+	rand.Read(id)
+	return id
+}
+
+// newByzantineBlock creates a block with a valid hash on top of the block with the
+// header holding the transactions and a filler transaction, so every block
+// is different.
+func newByzantineBlock(prev blockchain.CompactHeader, transactions ...transaction.Transaction) (*blockchain.Block, error) {
+	filler := transaction.Transaction{
+		ID:        randomID(),
+		Timestamp: time.Now().Unix(),
+	}
+	return blockchain.NewBlock(prev.Algorithm.Normalized(), prev.Hash, append(transactions, filler))
+}
+
+// newFakeStakeTransaction looks like a stake transaction to alfa but spends an
+// output which doesn't exist.
+func newFakeStakeTransaction(sender, alfaKeyHash []byte) transaction.Transaction {
+	return transaction.Transaction{
+		ID: randomID(),
+		Inputs: transaction.Inputs{{
+			PublicKeyHash: sender,
+			TransactionID: randomID(),
+			Vout:          0,
+		}},
+		Outputs: transaction.Outputs{{
+			PublicKeyHash: alfaKeyHash,
+			Value:         transaction.VoteValue,
+		}},
+		Timestamp: time.Now().Unix(),
+	}
+}
+
+type forgedBody struct {
+	Height int              `json:"height"`
+	Block  blockchain.Block `json:"block"`
+}
+
+func (c *byzantineClient) SignForged(height int, block blockchain.Block) (_websocket.Ping, error) {
+	return c.Sign(_websocket.BlockForgedMessage, forgedBody{Height: height, Block: block})
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/apps/alfa"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/fraud"
+	"github.com/nebser/crypto-vote/internal/pkg/operations"
+	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/repository"
+	"github.com/nebser/crypto-vote/internal/pkg/round"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	_websocket "github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+// byzantineTarget is an alfa node started in-process. Node is the key of an
+// authorized node which misbehaves. Forger misbehaves in its round, which
+// another node announcing blocks at the same height would turn into fraud,
+// and Peer keeps quiet so that rounds are started. Voter is the key of a
+// voter with vote credit.
+type byzantineTarget struct {
+	socket      string
+	api         string
+	adminToken  string
+	db          *bolt.DB
+	node        wallet.Wallet
+	forger      wallet.Wallet
+	forgerID    string
+	peer        wallet.Wallet
+	peerID      string
+	alfaKeyHash []byte
+	voter       wallet.Wallet
+	wait        time.Duration
+	forgeWait   time.Duration
+}
+
+type byzantineScenario struct {
+	name        string
+	description string
+	run         func(byzantineTarget) error
+}
+
+// byzantineScenarios are in the order they are run. Equivocation is last
+// since the node is slashed and is not selected to forge anymore.
+var byzantineScenarios = []byzantineScenario{
+	{"oversized", "message larger than the read limit closes the connection", oversized},
+	{"forged-signature", "block with a signature of another message is dropped with the connection", forgedSignature},
+	{"invalid-block", "block without a stake transaction is rejected", invalidBlock},
+	{"replay", "block already in the blockchain announced again is rejected", replay},
+	{"conflicting-votes", "two votes of a voter sent at once never both spend the same credit", conflictingVotes},
+	{"rejected-forge", "invalid block forged in the node's round is scored as rejected", rejectedForge},
+	{"equivocation", "two blocks at the same height are proven as fraud and slashed", equivocation},
+}
+
+func TestByzantineNodes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Byzantine scenarios run an alfa node")
+	}
+	target, stop := startByzantineTarget(t)
+	defer stop()
+	for _, s := range byzantineScenarios {
+		s := s
+		t.Run(s.name, func(t *testing.T) {
+			if err := s.run(target); err != nil {
+				t.Fatalf("Expected %s: %s", s.description, err)
+			}
+		})
+	}
+}
+
+// startByzantineTarget runs a new election with three nodes and two voters
+// in a temporary directory. Rounds start every second and alfa selects the
+// forger, never the one of the previous round, so with two nodes registered
+// the forger is selected within two rounds.
+func startByzantineTarget(t *testing.T) (byzantineTarget, func()) {
+	dir, err := ioutil.TempDir("", "byzantine")
+	if err != nil {
+		t.Fatalf("Failed to create directory %s", err)
+	}
+	export := func(prefix string) wallet.Wallet {
+		w, err := wallet.New()
+		if err != nil {
+			t.Fatalf("Failed to create wallet %s", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(prefix), 0700); err != nil {
+			t.Fatalf("Failed to create directory %s", err)
+		}
+		if err := w.Export(prefix); err != nil {
+			t.Fatalf("Failed to export wallet %s", err)
+		}
+		return *w
+	}
+	master := export(filepath.Join(dir, "alfa/key"))
+	var nodes []wallet.Wallet
+	for i := 1; i <= 3; i++ {
+		nodes = append(nodes, export(filepath.Join(dir, fmt.Sprintf("nodes/n%d", i))))
+	}
+	voter := export(filepath.Join(dir, "clients/c0"))
+	export(filepath.Join(dir, "clients/c1"))
+	schedule := filepath.Join(dir, "schedule.json")
+	if err := ioutil.WriteFile(schedule, []byte(`{"forging": "1s"}`), 0600); err != nil {
+		t.Fatalf("Failed to write schedule %s", err)
+	}
+
+	fs := flag.NewFlagSet("alfa", flag.ContinueOnError)
+	o := registerOptions(fs, dir)
+	if err := fs.Parse([]string{"-new", "-schedule", schedule, "-forgerSelection", string(alfa.AlfaSelection)}); err != nil {
+		t.Fatalf("Failed to parse options %s", err)
+	}
+	e := startElection(*o)
+	adminToken, err := ioutil.ReadFile(filepath.Join(dir, "alfa/admin.token"))
+	if err != nil {
+		t.Fatalf("Failed to read the commissioner token %s", err)
+	}
+	socket := httptest.NewServer(e.socket)
+	api := httptest.NewServer(e.api)
+	target := byzantineTarget{
+		socket:      "ws" + strings.TrimPrefix(socket.URL, "http") + "/",
+		api:         api.URL,
+		adminToken:  strings.TrimSpace(string(adminToken)),
+		db:          e.db,
+		node:        nodes[2],
+		forger:      nodes[1],
+		forgerID:    "2",
+		peer:        nodes[0],
+		peerID:      "1",
+		alfaKeyHash: master.PublicKeyHash(),
+		voter:       voter,
+		wait:        5 * time.Second,
+		forgeWait:   10 * time.Second,
+	}
+	stop := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := e.scheduler.Shutdown(ctx); err != nil {
+			t.Errorf("Failed to stop scheduler %s", err)
+		}
+		if err := e.hub.Shutdown(ctx); err != nil {
+			t.Errorf("Failed to disconnect nodes %s", err)
+		}
+		socket.Close()
+		api.Close()
+		e.store.Close()
+		os.RemoveAll(dir)
+	}
+	return target, stop
+}
+
+func (t byzantineTarget) getJSON(path string, result interface{}) (int, error) {
+	request, err := http.NewRequest(http.MethodGet, t.api+path, nil)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Failed to create request of %s", path)
+	}
+	request.Header.Set("Authorization", "Bearer "+t.adminToken)
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Failed to reach %s", path)
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, errors.Wrapf(err, "Failed to read response of %s", path)
+	}
+	if resp.StatusCode != http.StatusOK || result == nil {
+		return resp.StatusCode, nil
+	}
+	if err := json.Unmarshal(raw, result); err != nil {
+		return resp.StatusCode, errors.Wrapf(err, "Failed to unmarshal response of %s %s", path, raw)
+	}
+	return resp.StatusCode, nil
+}
+
+type headersResponse struct {
+	Height  int                       `json:"height"`
+	Headers blockchain.CompactHeaders `json:"headers"`
+}
+
+// tip returns the height and the header of the tip.
+func (t byzantineTarget) tip() (int, blockchain.CompactHeader, error) {
+	var first headersResponse
+	if _, err := t.getJSON("/headers?from=1&count=1", &first); err != nil {
+		return 0, blockchain.CompactHeader{}, err
+	}
+	var last headersResponse
+	if _, err := t.getJSON(fmt.Sprintf("/headers?from=%d&count=1", first.Height), &last); err != nil {
+		return 0, blockchain.CompactHeader{}, err
+	}
+	if len(last.Headers) == 0 {
+		return 0, blockchain.CompactHeader{}, errors.Errorf("Header at height %d is missing", first.Height)
+	}
+	return first.Height, last.Headers[0], nil
+}
+
+// unchanged fails if the blockchain moved past height with hash as its tip.
+func (t byzantineTarget) unchanged(height int, hash []byte) error {
+	current, tip, err := t.tip()
+	if err != nil {
+		return err
+	}
+	if current != height || !bytes.Equal(tip.Hash, hash) {
+		return errors.Errorf("Blockchain moved from height %d to %d", height, current)
+	}
+	return nil
+}
+
+func (t byzantineTarget) dial() (*byzantineClient, error) {
+	return dialByzantine(t.socket, t.node)
+}
+
+func oversized(t byzantineTarget) error {
+	c, err := t.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err := c.SendRaw(_websocket.MaxMessageBytes + 1); err != nil {
+		return nil
+	}
+	if !c.Disconnected(t.wait) {
+		return errors.Errorf("Connection is still open after a message of %d bytes", _websocket.MaxMessageBytes+1)
+	}
+	return nil
+}
+
+func forgedSignature(t byzantineTarget) error {
+	height, tip, err := t.tip()
+	if err != nil {
+		return err
+	}
+	c, err := t.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	block, err := newByzantineBlock(tip, newFakeStakeTransaction(t.node.PublicKeyHash(), t.alfaKeyHash))
+	if err != nil {
+		return err
+	}
+	ping, err := c.SignForged(height+1, *block)
+	if err != nil {
+		return err
+	}
+	other, err := c.Sign(_websocket.GetBlockchainHeightMessage, struct{}{})
+	if err != nil {
+		return err
+	}
+	ping.Signature = other.Signature
+	if err := c.Send(ping); err != nil {
+		return err
+	}
+	if !c.Disconnected(t.wait) {
+		return errors.New("Connection is still open after a block with an invalid signature")
+	}
+	return t.unchanged(height, tip.Hash)
+}
+
+func invalidBlock(t byzantineTarget) error {
+	height, tip, err := t.tip()
+	if err != nil {
+		return err
+	}
+	c, err := t.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	block, err := newByzantineBlock(tip)
+	if err != nil {
+		return err
+	}
+	ping, err := c.SignForged(height+1, *block)
+	if err != nil {
+		return err
+	}
+	if err := c.Send(ping); err != nil {
+		return err
+	}
+	if err := c.Rejected(t.wait); err != nil {
+		return err
+	}
+	return t.unchanged(height, tip.Hash)
+}
+
+func replay(t byzantineTarget) error {
+	height, tip, err := t.tip()
+	if err != nil {
+		return err
+	}
+	c, err := t.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	block, err := operations.GetBlock(c.Conn())(tip.Hash)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get block %x", tip.Hash)
+	}
+	ping, err := c.SignForged(height, block)
+	if err != nil {
+		return err
+	}
+	if err := c.Send(ping); err != nil {
+		return err
+	}
+	if err := c.Rejected(t.wait); err != nil {
+		return err
+	}
+	return t.unchanged(height, tip.Hash)
+}
+
+type voteBody struct {
+	Sender    string `json:"sender"`
+	Recipient string `json:"recipient"`
+	Verifier  string `json:"verifier"`
+	Signature string `json:"signature"`
+}
+
+func (t byzantineTarget) vote(recipient string) ([]byte, error) {
+	body := voteBody{
+		Sender:    t.voter.Address,
+		Recipient: recipient,
+		Verifier:  base64.StdEncoding.EncodeToString(t.voter.PublicKey),
+	}
+	signature, err := wallet.Sign(transaction.NewVoteSignable(t.voter.PublicKeyHash(), wallet.ExtractPublicKeyHash(recipient)), t.voter.PrivateKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to sign vote")
+	}
+	body.Signature = base64.StdEncoding.EncodeToString(signature)
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to marshal vote")
+	}
+	resp, err := http.Post(t.api+"/vote", "application/json", bytes.NewReader(raw))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to send vote")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil
+	}
+	var receipt struct {
+		Transaction []byte `json:"transaction"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&receipt); err != nil {
+		return nil, errors.Wrap(err, "Failed to unmarshal vote receipt")
+	}
+	return receipt.Transaction, nil
+}
+
+func conflictingVotes(t byzantineTarget) error {
+	var parties party.Parties
+	if _, err := t.getJSON("/parties", &parties); err != nil {
+		return err
+	}
+	if len(parties) < 2 {
+		return errors.New("Election has less than two parties")
+	}
+	receipts := make([][]byte, 2)
+	errs := make([]error, 2)
+	wg := sync.WaitGroup{}
+	for i := range receipts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			receipts[i], errs[i] = t.vote(parties[i].Address)
+		}(i)
+	}
+	wg.Wait()
+	accepted := 0
+	for i, receipt := range receipts {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		if receipt == nil {
+			continue
+		}
+		accepted++
+		status, err := t.getJSON("/admin/conflicts/"+hex.EncodeToString(receipt), nil)
+		switch {
+		case err != nil:
+			return err
+		case status != http.StatusNotFound:
+			return errors.Errorf("Accepted vote %x competes with another transaction", receipt)
+		}
+	}
+	if accepted != 1 {
+		return errors.Errorf("Expected one of the votes to be accepted, %d were", accepted)
+	}
+	return nil
+}
+
+type roundsResponse struct {
+	Rounds round.Rounds `json:"rounds"`
+}
+
+func rejectedForge(t byzantineTarget) error {
+	peer, err := dialByzantine(t.socket, t.peer)
+	if err != nil {
+		return err
+	}
+	defer peer.Close()
+	if _, _, err := operations.Register(peer.Conn(), t.peer)(t.peerID); err != nil {
+		return errors.Wrapf(err, "Failed to register node %s", t.peerID)
+	}
+	c, err := dialByzantine(t.socket, t.forger)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if _, _, err := operations.Register(c.Conn(), t.forger)(t.forgerID); err != nil {
+		return errors.Wrapf(err, "Failed to register node %s", t.forgerID)
+	}
+	var forge _websocket.ForgeBlockBody
+	for deadline := time.Now().Add(t.forgeWait); ; {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return errors.Errorf("Node is not selected to forge in %s", t.forgeWait)
+		}
+		ping, err := c.Receive(remaining)
+		if err != nil {
+			return errors.Wrap(err, "Connection closed while waiting for a round")
+		}
+		if ping == nil {
+			return errors.Errorf("Node is not selected to forge in %s", t.forgeWait)
+		}
+		if ping.Message == _websocket.ForgeBlockMessage && json.Unmarshal(ping.Body, &forge) == nil {
+			break
+		}
+	}
+	if forge.Round == nil {
+		return errors.New("Forge command carries no round")
+	}
+	height, tip, err := t.tip()
+	if err != nil {
+		return err
+	}
+	block, err := newByzantineBlock(tip, newFakeStakeTransaction(t.forger.PublicKeyHash(), t.alfaKeyHash))
+	if err != nil {
+		return err
+	}
+	ping, err := c.SignForged(forge.Height, *block)
+	if err != nil {
+		return err
+	}
+	if err := c.Send(ping); err != nil {
+		return err
+	}
+	if err := c.Rejected(t.wait); err != nil {
+		return err
+	}
+	if err := t.unchanged(height, tip.Hash); err != nil {
+		return err
+	}
+	// Rounds of the peer may have started since.
+	var rounds roundsResponse
+	if _, err := t.getJSON("/admin/rounds?limit=10", &rounds); err != nil {
+		return err
+	}
+	for _, r := range rounds.Rounds {
+		if r.Number != forge.Round.Number {
+			continue
+		}
+		if r.Selected != t.forgerID {
+			return errors.Errorf("Round %d is recorded for node %s", r.Number, r.Selected)
+		}
+		if r.Outcome != round.Rejected {
+			return errors.Errorf("Round of the node is scored as %s", r.Outcome)
+		}
+		return nil
+	}
+	return errors.Errorf("Round %d of the node is not recorded", forge.Round.Number)
+}
+
+func equivocation(t byzantineTarget) error {
+	height, tip, err := t.tip()
+	if err != nil {
+		return err
+	}
+	c, err := t.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	for i := 0; i < 2; i++ {
+		block, err := newByzantineBlock(tip)
+		if err != nil {
+			return err
+		}
+		ping, err := c.SignForged(height+1, *block)
+		if err != nil {
+			return err
+		}
+		if err := c.Send(ping); err != nil {
+			return err
+		}
+	}
+	if !c.Disconnected(t.wait) {
+		return errors.New("Connection is still open after two blocks at the same height")
+	}
+	var records fraud.Records
+	if _, err := t.getJSON("/fraud", &records); err != nil {
+		return err
+	}
+	recorded := false
+	for _, r := range records {
+		if !bytes.Equal(r.Violation.Offender, t.node.PublicKey) || r.Violation.Height != height+1 {
+			continue
+		}
+		if len(r.Transaction) == 0 {
+			return errors.New("Fraud is recorded but no evidence is put on chain")
+		}
+		recorded = true
+	}
+	if !recorded {
+		return errors.Errorf("No fraud of the node is recorded at height %d", height+1)
+	}
+	slashed, err := repository.IsSlashed(t.db)(t.node.PublicKeyHash())
+	switch {
+	case err != nil:
+		return errors.Wrap(err, "Failed to check whether the node is slashed")
+	case !slashed:
+		return errors.New("Node is not slashed")
+	}
+	return t.unchanged(height, tip.Hash)
+}
@@ -1,25 +1,68 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
+	"github.com/nebser/crypto-vote/internal/pkg/access"
+	"github.com/nebser/crypto-vote/internal/pkg/address"
+	"github.com/nebser/crypto-vote/internal/pkg/analytics"
+	"github.com/nebser/crypto-vote/internal/pkg/anchor"
 	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/ballot"
+	"github.com/nebser/crypto-vote/internal/pkg/certification"
+	"github.com/nebser/crypto-vote/internal/pkg/descriptor"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	_election "github.com/nebser/crypto-vote/internal/pkg/election"
+	"github.com/nebser/crypto-vote/internal/pkg/eligibility"
+	"github.com/nebser/crypto-vote/internal/pkg/emergency"
+	"github.com/nebser/crypto-vote/internal/pkg/events"
+	"github.com/nebser/crypto-vote/internal/pkg/export"
+	"github.com/nebser/crypto-vote/internal/pkg/finalization"
+	"github.com/nebser/crypto-vote/internal/pkg/fraud"
+	"github.com/nebser/crypto-vote/internal/pkg/hooks"
+	"github.com/nebser/crypto-vote/internal/pkg/intake"
+	"github.com/nebser/crypto-vote/internal/pkg/signer"
+	"github.com/nebser/crypto-vote/internal/pkg/sortition"
+	"github.com/nebser/crypto-vote/internal/pkg/stake"
+	"github.com/nebser/crypto-vote/internal/pkg/tenant"
 	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/transport"
 
 	"github.com/gorilla/mux"
 
 	"github.com/nebser/crypto-vote/internal/pkg/keyfiles"
+	"github.com/nebser/crypto-vote/internal/pkg/mempool"
+	"github.com/nebser/crypto-vote/internal/pkg/mesh"
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
+	"github.com/nebser/crypto-vote/internal/pkg/mixer"
+	"github.com/nebser/crypto-vote/internal/pkg/network"
+	"github.com/nebser/crypto-vote/internal/pkg/observer"
+	"github.com/nebser/crypto-vote/internal/pkg/oidc"
+	"github.com/nebser/crypto-vote/internal/pkg/outbox"
+	"github.com/nebser/crypto-vote/internal/pkg/preflight"
+	"github.com/nebser/crypto-vote/internal/pkg/publish"
+	"github.com/nebser/crypto-vote/internal/pkg/redact"
 	"github.com/nebser/crypto-vote/internal/pkg/repository"
+	"github.com/nebser/crypto-vote/internal/pkg/research"
+	"github.com/nebser/crypto-vote/internal/pkg/results"
+	"github.com/nebser/crypto-vote/internal/pkg/rules"
+	"github.com/nebser/crypto-vote/internal/pkg/storage"
 	"github.com/nebser/crypto-vote/internal/pkg/wallet"
 	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/nebser/crypto-vote/internal/pkg/withdrawal"
 	"github.com/pkg/errors"
 
 	"github.com/nebser/crypto-vote/internal/apps/alfa"
@@ -27,14 +70,9 @@ import (
 
 	"github.com/boltdb/bolt"
 	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
-	"github.com/robfig/cron/v3"
 )
 
-const (
-	dbFileName = "db"
-)
-
-func getKeyFiles(keyDirectory string) (keyfiles.KeyFilesList, error) {
+func getKeyFiles(keyDirectory string, passphrase keyfiles.PassphraseFn) (keyfiles.KeyFilesList, error) {
 	files, err := ioutil.ReadDir(keyDirectory)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Failed to read key file directory %s", keyDirectory)
@@ -47,6 +85,7 @@ func getKeyFiles(keyDirectory string) (keyfiles.KeyFilesList, error) {
 		}
 		name := strings.Replace(f.Name(), "_pub", "", 1)
 		group := fileGroups[name]
+		group.Passphrase = passphrase
 		if strings.Contains(f.Name(), "pub") {
 			group.PublicKeyFile = fmt.Sprintf("%s/%s", keyDirectory, f.Name())
 		} else {
@@ -62,41 +101,375 @@ func getKeyFiles(keyDirectory string) (keyfiles.KeyFilesList, error) {
 	return result, nil
 }
 
-func main() {
-	newOption := flag.Bool("new", false, "Should initialize new blockchain")
-	privateKey := flag.String("private", "alfa/key.pem", "Private key file path")
-	publicKey := flag.String("public", "alfa/key_pub.pem", "Public key file path")
-	clientKeysDir := flag.String("clients", "clients", "Client key pair files directory")
-	nodeKeysDir := flag.String("nodes", "nodes", "Nodes key pair files directory")
+// options configure a single election, every tenant has its own.
+type options struct {
+	new                bool
+	dbFile             string
+	privateKey         string
+	publicKey          string
+	clientKeysDir      string
+	nodeKeysDir        string
+	mix                bool
+	anchorURL          string
+	anchorType         string
+	anchorInterval     time.Duration
+	blockCacheSize     int
+	scheduleFile       string
+	transportKeyFile   string
+	transportAlgorithm string
+	eligibilityType    string
+	eligibilitySource  string
+	stakeReturnMisses  int
+	kioskIssuerKey     string
+	signerSocket       string
+	ballotFile         string
+	signerSecret       string
+	passphraseEnv      string
+	electionEnd        string
+	drainTimeout       time.Duration
+	cosignQuorum       int
+	cosignTimeout      time.Duration
+	certificationDir   string
+	rulesFile          string
+	trusteesDir        string
+	trusteeQuorum      int
+	intakeWorkers      int
+	intakeQueue        int
+	intakeWait         time.Duration
+	maxConnsPerIP      int
+	heartbeat          websocket.Heartbeat
+	deadlines          websocket.Deadlines
+	handlerTimeouts    string
+	wire               string
+	forgerSelection    string
+	adminToken         string
+	insecureAdmin      bool
+	maxClockOffset     time.Duration
+	legacyUntil        string
+	compactWindow      string
+	publishTarget      string
+	publishType        string
+	analytics          bool
+	analyticsK         int
+	precinctsFile      string
+	oidcIssuer         string
+	oidcClientID       string
+	oidcClaim          string
+	credits            int
+	withdrawnVotes     string
+	observerLimits     string
+	network            string
+	hash               string
+	storage            string
+	db                 repository.Options
+	mempool            mempool.Options
+}
+
+// registerOptions registers the options of an election on the flag set.
+// Default paths of files are inside dir.
+func registerOptions(fs *flag.FlagSet, dir string) *options {
+	o := &options{}
+	fs.BoolVar(&o.new, "new", false, "Should initialize new blockchain")
+	fs.StringVar(&o.dbFile, "db", filepath.Join(dir, "db"), "Database file path")
+	fs.StringVar(&o.privateKey, "private", filepath.Join(dir, "alfa/key.pem"), "Private key file path")
+	fs.StringVar(&o.publicKey, "public", filepath.Join(dir, "alfa/key_pub.pem"), "Public key file path")
+	fs.StringVar(&o.clientKeysDir, "clients", filepath.Join(dir, "clients"), "Client key pair files directory")
+	fs.StringVar(&o.nodeKeysDir, "nodes", filepath.Join(dir, "nodes"), "Nodes key pair files directory")
+	fs.BoolVar(&o.mix, "mix", false, "Should require forged blocks to contain vote transactions in canonical order")
+	fs.StringVar(&o.anchorURL, "anchor", "", "URL of the external timestamping service used for anchoring the tip [anchoring is disabled if empty]")
+	fs.StringVar(&o.anchorType, "anchorType", "ots", "Type of the external timestamping service (ots or http)")
+	fs.DurationVar(&o.anchorInterval, "anchorInterval", 10*time.Minute, "Interval between two tip anchoring attempts")
+	fs.IntVar(&o.blockCacheSize, "blockCacheSize", 16<<20, "Maximum estimated size in bytes of recently accessed blocks kept in memory")
+	fs.StringVar(&o.scheduleFile, "schedule", "", "JSON file with job intervals, reloaded on SIGHUP [default intervals are used if empty]")
+	fs.StringVar(&o.transportKeyFile, "transportKey", "", "Key file used for signing websocket messages instead of the chain key, generated if missing [chain key is used if empty]")
+	fs.StringVar(&o.transportAlgorithm, "transportAlg", string(transport.Ed25519), "Signature algorithm of a newly generated transport key (ed25519 or p256)")
+	fs.StringVar(&o.eligibilityType, "eligibility", "", "Eligibility provider consulted on voter registration (csv or http) [registration is disabled if empty]")
+	fs.StringVar(&o.eligibilitySource, "eligibilitySource", "", "CSV file or URL of the eligibility provider")
+	fs.IntVar(&o.stakeReturnMisses, "stakeReturnMisses", 3, "Number of consecutive missed forging rounds after which the stake of a node is returned [stake is not returned if 0]")
+	fs.StringVar(&o.kioskIssuerKey, "kioskIssuer", "", "Public key file of the election staff issuing kiosk submission tokens [kiosk voting is disabled if empty]")
+	fs.StringVar(&o.signerSocket, "signer", "", "Socket of the signer holding the master key [private key file is used if empty]")
+	fs.StringVar(&o.ballotFile, "ballot", "", "JSON file with the questions of a new election [a single question answered with the party nodes if empty]")
+	fs.StringVar(&o.signerSecret, "signerSecret", filepath.Join(dir, "alfa/signer.secret"), "File with the secret shared with the signer")
+	fs.StringVar(&o.passphraseEnv, "passphraseEnv", "ALFA_PASSPHRASE", "Environment variable with the passphrase of encrypted private key files [prompted for if not set]")
+	fs.StringVar(&o.electionEnd, "end", "", "End of the election in RFC3339 format at which vote intake closes and the election is finalized [automatic finalization is disabled if empty]")
+	fs.DurationVar(&o.drainTimeout, "drainTimeout", 10*time.Minute, "How long pending votes are waited for after the end of the election before finalizing without them")
+	fs.IntVar(&o.cosignQuorum, "cosignQuorum", 0, "Number of party nodes which have to co-sign the finalized tip before the result is certified [majority of party nodes if 0]")
+	fs.DurationVar(&o.cosignTimeout, "cosignTimeout", 30*time.Minute, "How long co-signers are waited for after finalization before admins are alerted")
+	fs.StringVar(&o.certificationDir, "certification", filepath.Join(dir, "certification"), "Directory in which to write the certification bundle of the automatic finalization")
+	fs.StringVar(&o.rulesFile, "rules", "", "File with the rules every transaction of the election has to satisfy, committed to by the genesis block [no rules if empty]")
+	fs.StringVar(&o.trusteesDir, "trustees", "", "Directory with public keys of the trustees who can pause and resume the election [election can't be paused if empty]")
+	fs.IntVar(&o.trusteeQuorum, "trusteeQuorum", 0, "Number of trustees who have to sign a pause or a resume [majority of trustees if 0]")
+	fs.IntVar(&o.intakeWorkers, "intakeWorkers", 4, "Number of workers processing submitted votes")
+	fs.IntVar(&o.intakeQueue, "intakeQueue", 1000, "Number of submitted votes waiting for a worker after which new votes are refused")
+	fs.DurationVar(&o.intakeWait, "intakeWait", 2*time.Second, "How long a vote is waited for before the voter gets a tracking id instead")
+	fs.StringVar(&o.legacyUntil, "legacyAddressesUntil", "", "Time in RFC3339 format until which base64 encoded public key hashes are accepted in place of Base58Check addresses [accepted without a deadline if empty]")
+	fs.IntVar(&o.maxConnsPerIP, "maxConnsPerIP", 0, "Number of websocket connections accepted from a single IP address [not limited if 0]")
+	fs.DurationVar(&o.heartbeat.Interval, "heartbeatInterval", 10*time.Second, "Interval between two websocket pings sent to every connection, unacknowledged broadcasts are sent again as often [no heartbeats if 0]")
+	fs.DurationVar(&o.heartbeat.Timeout, "heartbeatTimeout", 30*time.Second, "How long a registered node may stay silent before it is disconnected and deregistered [never if 0]")
+	fs.IntVar(&o.heartbeat.Retries, "deliveryRetries", 3, "Number of times a broadcast not acknowledged by a node is sent again before the node is disconnected")
+	fs.DurationVar(&o.deadlines.Timeout, "handlerTimeout", 10*time.Second, "How long the handler of a read-only websocket message may run before the sender gets a timeout error and the handler is cancelled, messages changing state run without a deadline [read-only messages are handled in the reader without a deadline if 0]")
+	fs.StringVar(&o.handlerTimeouts, "handlerTimeouts", websocket.DefaultTimeouts.String(), "Timeouts of single read-only websocket messages overriding handlerTimeout as comma separated message=timeout pairs")
+	fs.IntVar(&o.deadlines.Workers, "handlerWorkers", 4, "Number of handlers of read-only websocket messages which may run at once for a single connection, including the ones past their deadline, further messages are refused with a busy error")
+	fs.StringVar(&o.wire, "wire", string(websocket.BinaryEncoding), "Encoding of websocket messages picked for nodes offering it when they register, binary or json; JSON is used with nodes which don't support the binary encoding")
+	fs.StringVar(&o.forgerSelection, "forgerSelection", string(alfa.SortitionSelection), "Who picks the forger of a round, sortition lets nodes select themselves with a VRF over the round seed, alfa selects the forger out of the stake weights")
+	fs.StringVar(&o.adminToken, "adminToken", filepath.Join(dir, "alfa/admin.token"), "File the token of a commissioner credential is written to when no commissioner has one, admin endpoints require credentials with a role permitting them")
+	fs.BoolVar(&o.insecureAdmin, "insecureAdmin", false, "Should leave admin endpoints and emergency submissions open to anyone, meant for development only")
+	fs.DurationVar(&o.maxClockOffset, "maxClockOffset", 2*time.Second, "How far the clock of a node, measured by heartbeats, may be off before the node is no longer selected to forge and the blocks it forges are annotated [clocks are not checked if 0]")
+	fs.StringVar(&o.compactWindow, "compactWindow", "", "Daily window in UTC in the format HH:MM-HH:MM in which the database is compacted when worthwhile [scheduled compaction is disabled if empty]")
+	fs.StringVar(&o.publishTarget, "publish", "", "Directory or object store URL to which signed static results are published after every block [publication is disabled if empty]")
+	fs.StringVar(&o.publishType, "publishType", "dir", "Type of the publication target (dir or http)")
+	fs.BoolVar(&o.analytics, "analytics", false, "Should serve the turnout over time on /analytics/turnout")
+	fs.IntVar(&o.analyticsK, "analyticsK", 10, "Smallest number of voters reported for an interval or a precinct of the turnout and of ballots sharing a precinct in research exports")
+	fs.StringVar(&o.oidcIssuer, "oidcIssuer", "", "Issuer URL of the OpenID Connect provider authenticating registering voters [voters register with member ids if empty]")
+	fs.StringVar(&o.oidcClientID, "oidcClientID", "", "Client id of the election at the OpenID Connect provider")
+	fs.StringVar(&o.oidcClaim, "oidcClaim", "sub", "Claim of the ID token whose hash identifies the voter on the eligibility roll")
+	fs.StringVar(&o.precinctsFile, "precincts", "", "CSV file with the precinct of every voter address used by the turnout and research exports [neither has precincts if empty]")
+	fs.IntVar(&o.credits, "credits", 1, "Number of credits every voter may split across the parties, has to stay the same for the whole election")
+	fs.StringVar(&o.withdrawnVotes, "withdrawnVotes", string(transaction.KeepPriorVotes), "What happens to votes a party got before it withdrew unless the withdrawal says otherwise [void|keep]")
+	fs.BoolVar(&o.db.NoSync, "dbNoSync", false, "Should skip syncing the database to disk after every commit, for benchmarks only as a crash may corrupt the database")
+	fs.DurationVar(&o.db.Timeout, "dbTimeout", 0, "How long to wait for the lock of the database file [waits indefinitely if 0]")
+	fs.StringVar(&o.db.MmapFlags, "dbMmapFlags", "", "Comma separated flags the database file is memory mapped with, populate is supported on Linux")
+	fs.IntVar(&o.db.InitialMmapSize, "dbInitialMmapSize", 0, "Initial size in bytes of the memory map of the database, readers don't block the writer until it is outgrown")
+	fs.DurationVar(&o.mempool.TTL, "mempoolTTL", 0, "How long a vote may stay pending before it is dropped [votes never expire if 0]")
+	fs.IntVar(&o.mempool.MaxCount, "mempoolMaxCount", 0, "Number of pending transactions above which new votes are refused [not limited if 0]")
+	fs.StringVar(&o.observerLimits, "observerLimits", observer.DefaultLimits.String(), "Requests per minute a party observer key may make for each scope as comma separated scope=requests pairs")
+	fs.StringVar(&o.hash, "hash", string(digest.SHA256), "Algorithm blocks, the Merkle trees over their transactions and the ids of new transactions are hashed with, picked by the genesis block of a new blockchain [sha256|blake3]")
+	fs.StringVar(&o.storage, "storage", "bolt", fmt.Sprintf("Storage backend of the election (%s), memory keeps it in a temporary database removed on exit", strings.Join(storage.Backends(), ", ")))
+	fs.StringVar(&o.network, "network", string(network.Production), "Type of the network committed to by the genesis block, a testnet serves the faucet on /faucet [production|testnet]")
+	return o
+}
 
+func main() {
+	preflightMode := len(os.Args) > 1 && os.Args[1] == "preflight"
+	if preflightMode {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+	if names := hooks.Registered(); len(names) > 0 {
+		log.Printf("Compiled in validation hooks %v", names)
+	}
+	tenantsFile := flag.String("tenants", "", "JSON file with the tenants hosted by the deployment, each running its own election [a single election is run if empty]")
+	metricsPull := flag.Bool("metricsPull", true, "Should serve metrics on /metrics for scraping")
+	pushGateway := flag.String("pushGateway", "", "URL of a Prometheus Pushgateway metrics are pushed to [metrics are not pushed if empty]")
+	statsdAddress := flag.String("statsd", "", "Address of a statsd server metrics are sent to over UDP [metrics are not sent if empty]")
+	metricsDump := flag.String("metricsDump", "", "File metrics are dumped to in the Prometheus text format [metrics are not dumped if empty]")
+	pushInterval := flag.Duration("pushInterval", 15*time.Second, "How often metrics are pushed, sent and dumped")
+	logRedaction := flag.String("logRedaction", "hash", "How voter addresses, public key hashes and signatures are logged: hash, truncate, omit or off; signatures are hashed even if off")
+	l := listeners{}
+	flag.StringVar(&l.api, "apiAddr", ":8000", "Address the http API listens on")
+	flag.StringVar(&l.socket, "socketAddr", ":10000", "Address the websocket server listens on")
+	flag.StringVar(&l.tlsCert, "tlsCert", "", "Certificate file of the websocket server, served as wss [plain ws if empty]")
+	flag.StringVar(&l.tlsKey, "tlsKey", "", "Private key file of the certificate of the websocket server")
+	flag.DurationVar(&l.shutdownTimeout, "shutdownTimeout", 30*time.Second, "How long requests, jobs and nodes are waited for on SIGINT and SIGTERM before the node stops anyway")
+	o := registerOptions(flag.CommandLine, "")
+	var preflightOptions preflight.Options
+	var preflightPeers *string
+	if preflightMode {
+		preflightOptions.Register(flag.CommandLine)
+		preflightPeers = flag.String("peers", "", "Comma separated URLs of party nodes whose reachability and clocks are checked [not checked if empty]")
+	}
 	flag.Parse()
-	if *newOption {
-		switch _, err := os.Stat(dbFileName); {
+	redaction, err := redact.ParsePolicy(*logRedaction)
+	if err != nil {
+		log.Fatal(err)
+	}
+	redact.Install(redaction)
+	if !*metricsPull {
+		metrics.DisablePull()
+	}
+	go metrics.Push(*pushInterval, metrics.Pushers(*pushGateway, "alfa", "", *statsdAddress, *metricsDump)...)
+	if (l.tlsCert == "") != (l.tlsKey == "") {
+		log.Fatal("TLS requires both a certificate and a private key")
+	}
+	if preflightMode {
+		elections := map[string]options{"": *o}
+		if *tenantsFile != "" {
+			tenants, err := tenant.Read(*tenantsFile)
+			if err != nil {
+				log.Fatalf("Failed to load tenants %s", err)
+			}
+			elections = map[string]options{}
+			for _, t := range tenants {
+				elections[t.ID] = tenantOptions(t)
+			}
+		}
+		os.Exit(alfaPreflight(l, elections, *preflightPeers, preflightOptions))
+	}
+	if *tenantsFile == "" {
+		e := startElection(*o)
+		if err := serve(l, e.socket, e.api, []election{e}); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	tenants, err := tenant.Read(*tenantsFile)
+	if err != nil {
+		log.Fatalf("Failed to load tenants %s", err)
+	}
+	sockets := tenant.NewRouter()
+	apis := tenant.NewRouter()
+	databases := map[string]string{}
+	elections := []election{}
+	for _, t := range tenants {
+		o := tenantOptions(t)
+		if other, ok := databases[o.dbFile]; ok {
+			log.Fatalf("Tenants %s and %s can't share database %s", other, t.ID, o.dbFile)
+		}
+		databases[o.dbFile] = t.ID
+		log.Printf("Starting election of tenant %s", t.ID)
+		e := startElection(o)
+		elections = append(elections, e)
+		sockets.Add(t, e.socket)
+		apis.Add(t, e.api)
+	}
+	if err := serve(l, sockets, apis, elections); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// tenantOptions parses the arguments of the tenant on top of the options
+// given on the command line.
+func tenantOptions(t tenant.Tenant) options {
+	fs := flag.NewFlagSet(t.ID, flag.ContinueOnError)
+	o := registerOptions(fs, t.ID)
+	flag.Visit(func(f *flag.Flag) {
+		if fs.Lookup(f.Name) != nil {
+			fs.Set(f.Name, f.Value.String())
+		}
+	})
+	if err := fs.Parse(t.Args); err != nil {
+		log.Fatalf("Failed to parse options of tenant %s %s", t.ID, err)
+	}
+	return *o
+}
+
+type election struct {
+	store     storage.Repository
+	db        *bolt.DB
+	socket    http.Handler
+	api       http.Handler
+	hub       *websocket.Hub
+	scheduler *alfa.Scheduler
+}
+
+// close stops the jobs of the election, disconnects its nodes and closes the
+// database once nothing uses it anymore.
+func (e election) close(ctx context.Context) {
+	if err := e.scheduler.Shutdown(ctx); err != nil {
+		log.Printf("Failed to stop scheduler %s", err)
+	}
+	if err := e.hub.Shutdown(ctx); err != nil {
+		log.Printf("Failed to disconnect nodes %s", err)
+	}
+	if err := e.store.Close(); err != nil {
+		log.Printf("Failed to close database %s %s", e.db.Path(), err)
+	}
+}
+
+// listeners are the addresses of the servers, shared by all tenants.
+type listeners struct {
+	api             string
+	socket          string
+	tlsCert         string
+	tlsKey          string
+	shutdownTimeout time.Duration
+}
+
+// serve runs the servers until SIGINT or SIGTERM, or until one of them
+// fails, and then shuts the servers and the elections down. Requests in
+// progress get the base context of the servers, which is cancelled once the
+// shutdown starts, so streams end instead of holding the shutdown up.
+func serve(l listeners, socket, api http.Handler, elections []election) error {
+	base, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	baseContext := func(net.Listener) context.Context { return base }
+	socketServer := &http.Server{Addr: l.socket, Handler: socket, BaseContext: baseContext}
+	apiServer := &http.Server{Addr: l.api, Handler: api, BaseContext: baseContext}
+	socketListener, err := net.Listen("tcp", l.socket)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to listen on %s", l.socket)
+	}
+	apiListener, err := net.Listen("tcp", l.api)
+	if err != nil {
+		socketListener.Close()
+		return errors.Wrapf(err, "Failed to listen on %s", l.api)
+	}
+	failed := make(chan error, 2)
+	go func() {
+		if l.tlsCert != "" {
+			failed <- socketServer.ServeTLS(socketListener, l.tlsCert, l.tlsKey)
+			return
+		}
+		failed <- socketServer.Serve(socketListener)
+	}()
+	go func() {
+		failed <- apiServer.Serve(apiListener)
+	}()
+	log.Printf("Serving the API on %s and websockets on %s", l.api, l.socket)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	var result error
+	select {
+	case sig := <-stop:
+		log.Printf("Received %s, shutting down", sig)
+	case err := <-failed:
+		result = errors.Wrap(err, "Server failed")
+		log.Printf("%s, shutting down", result)
+	}
+	cancel()
+	ctx, done := context.WithTimeout(context.Background(), l.shutdownTimeout)
+	defer done()
+	for _, s := range []*http.Server{apiServer, socketServer} {
+		if err := s.Shutdown(ctx); err != nil {
+			log.Printf("Failed to shut down server on %s %s", s.Addr, err)
+		}
+	}
+	for _, e := range elections {
+		e.close(ctx)
+	}
+	log.Println("Shut down")
+	return result
+}
+
+func startElection(o options) election {
+	if o.new && os.Getenv(alfa.KeepDatabaseEnv) != "" {
+		log.Printf("Restarted on compacted database %s, it is not initialized again", o.dbFile)
+		o.new = false
+	}
+	if o.new {
+		switch _, err := os.Stat(o.dbFile); {
 		case err == nil:
-			if err := os.Remove(dbFileName); err != nil {
-				log.Fatalf("Failed to remove file %s", dbFileName)
+			if err := os.Remove(o.dbFile); err != nil {
+				log.Fatalf("Failed to remove file %s", o.dbFile)
 			}
 		case err != nil && !os.IsNotExist(err):
-			log.Fatalf("Failed to read stat for file %s", dbFileName)
+			log.Fatalf("Failed to read stat for file %s", o.dbFile)
 		}
 	}
-	db, err := bolt.Open(dbFileName, 0600, nil)
+	store, err := storage.Open(o.storage, o.dbFile, o.db)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
-	masterWallet, err := wallet.Import(keyfiles.KeyFiles{
-		PublicKeyFile:  *publicKey,
-		PrivateKeyFile: *privateKey,
-	})
+	db, err := storage.DB(store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := repository.MigrateUTXOs(db, 500, func(bucket string, migrated, total int) {
+		log.Printf("Bucket %s: %d/%d records moved to one record per utxo", bucket, migrated, total)
+	}); err != nil {
+		log.Fatalf("Failed to migrate utxos %s", err)
+	}
+	passphrase := keyfiles.Unlock(o.passphraseEnv)
+	masterWallet, signers, err := setUpChainSigners(o.signerSocket, o.signerSecret, o.publicKey, o.privateKey, passphrase)
 	if err != nil {
 		log.Fatalf("Failed to load master wallet %s", err)
 	}
-	clientKeyFiles, err := getKeyFiles(*clientKeysDir)
+	clientKeyFiles, err := getKeyFiles(o.clientKeysDir, passphrase)
 	if err != nil {
 		log.Fatalf("Failed to load client key files directory %s", err)
 	}
-	nodeKeyFiles, err := getKeyFiles(*nodeKeysDir)
+	nodeKeyFiles, err := getKeyFiles(o.nodeKeysDir, passphrase)
 	if err != nil {
 		log.Fatalf("Failed to load node key files directory %s", err)
 	}
@@ -109,111 +482,1393 @@ func main() {
 		log.Fatalf("Failed to import node wallets %s", err)
 	}
 
-	if *newOption {
+	var electionRules *rules.Rules
+	if o.rulesFile != "" {
+		electionRules, err = rules.Read(o.rulesFile)
+		if err != nil {
+			log.Fatalf("Failed to load rules %s", err)
+		}
+	}
+	networkType, err := network.Parse(o.network)
+	if err != nil {
+		log.Fatal(err)
+	}
+	wire, err := websocket.ParseEncoding(o.wire)
+	if err != nil {
+		log.Fatal(err)
+	}
+	o.deadlines.Messages, err = websocket.ParseTimeouts(o.handlerTimeouts)
+	if err != nil {
+		log.Fatalf("Failed to parse handler timeouts %s", err)
+	}
+	forgerSelection, err := alfa.ParseForgerSelection(o.forgerSelection)
+	if err != nil {
+		log.Fatal(err)
+	}
+	hashAlgorithm, err := digest.Parse(o.hash)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if o.new {
+		definitions := ballot.Definitions{{}}
+		if o.ballotFile != "" {
+			definitions, err = ballot.ReadDefinitions(o.ballotFile)
+			if err != nil {
+				log.Fatalf("Failed to load ballot %s", err)
+			}
+		}
 		if err := alfa.Initialize(
+			signers.transaction,
 			*masterWallet,
 			nodeWallets,
 			clientWallets,
-			repository.AddBlock(db),
-			repository.SaveParty(db)); err != nil {
+			definitions,
+			o.credits,
+			electionRules.Hash(),
+			networkType,
+			hashAlgorithm,
+			store.AddBlock,
+			store.SaveParty); err != nil {
 			log.Fatal(err)
 		}
 	}
-	blockchain.PrintBlockchain(repository.GetTip(db), repository.GetBlock(db))
+	var anchorer anchor.Anchorer
+	if o.anchorURL != "" {
+		anchorer, err = anchor.New(o.anchorType, o.anchorURL)
+		if err != nil {
+			log.Fatalf("Failed to set up anchoring %s", err)
+		}
+	}
+	var provider eligibility.Provider
+	if o.eligibilityType != "" {
+		provider, err = eligibility.New(o.eligibilityType, o.eligibilitySource)
+		if err != nil {
+			log.Fatalf("Failed to set up eligibility provider %s", err)
+		}
+	}
+	parties, err := store.GetParties()
+	if err != nil {
+		log.Fatalf("Failed to retrieve parties %s", err)
+	}
+	questions := ballot.Group(parties)
+	switch {
+	case o.credits < 1:
+		log.Fatal("Voters need at least 1 credit")
+	case o.credits > 1 && len(questions) > 1:
+		log.Fatal("Cumulative voting is not supported in elections with several questions")
+	case o.credits > 1 && o.kioskIssuerKey != "":
+		log.Fatal("Kiosk voting is not supported in cumulative voting")
+	}
+	voterValue := o.credits * questions.Value()
+	withdrawnVotes, err := transaction.ParsePriorVotes(o.withdrawnVotes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	observerLimits, err := observer.ParseLimits(o.observerLimits)
+	if err != nil {
+		log.Fatalf("Failed to parse observer limits %s", err)
+	}
+	var authorizeAdmin access.AuthorizeFn
+	switch {
+	case o.insecureAdmin:
+		log.Println("WARNING: admin endpoints and emergency submissions are open to anyone")
+	case o.adminToken == "":
+		log.Fatal("Admin endpoints require the adminToken option, start with insecureAdmin to leave them open")
+	default:
+		token, err := access.Bootstrap(repository.GetAdminCredentials(db), alfa.CredentialIssuer(access.Issue(repository.SaveAdminCredential(db)), repository.RecordAudit(db)))
+		if err != nil {
+			log.Fatalf("Failed to bootstrap admin credentials %s", err)
+		}
+		if token != "" {
+			if err := ioutil.WriteFile(o.adminToken, []byte(token+"\n"), 0600); err != nil {
+				log.Fatalf("Failed to write commissioner token %s", err)
+			}
+			log.Printf("Issued a commissioner credential, its token is written to %s", o.adminToken)
+		}
+		authorizeAdmin = access.Authorize(repository.GetAdminCredential(db))
+	}
+	if err := repository.IndexCredits(db); err != nil {
+		log.Fatalf("Failed to index credits of voters %s", err)
+	}
+	var deadline *alfa.Deadline
+	if o.electionEnd != "" {
+		end, err := time.Parse(time.RFC3339, o.electionEnd)
+		if err != nil {
+			log.Fatalf("Failed to parse end of the election %s", err)
+		}
+		quorum := o.cosignQuorum
+		if quorum == 0 {
+			quorum = len(ballot.Nodes(parties))/2 + 1
+		}
+		deadline = &alfa.Deadline{
+			End:    end,
+			Drain:  o.drainTimeout,
+			Quorum: quorum,
+			Cosign: o.cosignTimeout,
+		}
+	}
+	var legacyUntil time.Time
+	if o.legacyUntil != "" {
+		if legacyUntil, err = time.Parse(time.RFC3339, o.legacyUntil); err != nil {
+			log.Fatalf("Failed to parse end of legacy addresses %s", err)
+		}
+	}
+	var compactWindow *alfa.Window
+	if o.compactWindow != "" {
+		if compactWindow, err = alfa.ParseWindow(o.compactWindow); err != nil {
+			log.Fatalf("Failed to parse compaction window %s", err)
+		}
+	}
+	if o.analyticsK < 1 {
+		log.Fatalf("Analytics k has to be at least 1")
+	}
+	precincts := analytics.Precincts{}
+	if o.precinctsFile != "" {
+		if precincts, err = analytics.ReadPrecincts(o.precinctsFile); err != nil {
+			log.Fatalf("Failed to read precincts %s", err)
+		}
+	}
+	var turnout *analytics.Turnout
+	if o.analytics {
+		turnout = analytics.NewTurnout(o.analyticsK, precincts, store.GetTip, store.GetBlock, store.GetParties)
+	}
+	var verifier *oidc.Verifier
+	if o.oidcIssuer != "" {
+		if provider == nil || o.oidcClientID == "" {
+			log.Fatal("OpenID Connect requires an eligibility provider and a client id")
+		}
+		verifier = oidc.NewVerifier(o.oidcIssuer, o.oidcClientID, o.oidcClaim)
+	}
+	maintenance := alfa.NewMaintenance()
+	compactor := alfa.NewCompactor(
+		maintenance,
+		repository.EstimateCompaction(db),
+		repository.CompactDatabase(db),
+		alfa.Restart,
+		o.dbFile,
+		repository.RecordAudit(db),
+	)
+	alfa.ReportCompaction(repository.GetLastCompaction(db), repository.EstimateCompaction(db))
+	var kioskIssuer []byte
+	if o.kioskIssuerKey != "" && len(questions) > 1 {
+		log.Fatal("Kiosk voting is not supported in elections with several questions")
+	}
+	if o.kioskIssuerKey != "" {
+		kioskIssuer, err = wallet.LoadPublicKey(o.kioskIssuerKey)
+		if err != nil {
+			log.Fatalf("Failed to load kiosk token issuer key %s", err)
+		}
+	}
+	blocks := blockchain.NewBlockCache(store.GetBlock, o.blockCacheSize)
+	blockchain.PrintBlockchain(store.GetTip, blocks.GetBlock)
+	if err := rules.VerifyGenesis(blockchain.FindBlock(store.GetTip, blocks.GetBlock), electionRules); err != nil {
+		log.Fatal(err)
+	}
+	if err := network.VerifyGenesis(blockchain.FindBlock(store.GetTip, blocks.GetBlock), networkType); err != nil {
+		log.Fatal(err)
+	}
+	if err := blockchain.VerifyAlgorithm(blockchain.FindBlock(store.GetTip, blocks.GetBlock), hashAlgorithm); err != nil {
+		log.Fatal(err)
+	}
+	choices := map[string]bool{}
+	for _, p := range parties {
+		choices[string(wallet.ExtractPublicKeyHash(p.Address))] = true
+	}
+	withdrawals, err := withdrawal.Load(blockchain.FindBlock(store.GetTip, blocks.GetBlock))
+	if err != nil {
+		log.Fatalf("Failed to load withdrawals of parties %s", err)
+	}
+	elections, err := _election.Load(repository.GetElections(db), repository.SaveElection(db), wallet.DecodeAddress)
+	if err != nil {
+		log.Fatalf("Failed to load elections %s", err)
+	}
+	exportBallots := research.Exporter(o.analyticsK, precincts, store.GetTip, blocks.GetBlock, store.GetParties, elections.List, masterWallet.PublicKeyHash())
+	validate := transaction.ValidateAll(
+		hooks.ValidateTransaction,
+		withdrawals.Validate(),
+		elections.Validate(masterWallet.PublicKeyHash()),
+		electionRules.Validator(masterWallet.PublicKeyHash()),
+		transaction.CreditCap(voterValue/transaction.VoteValue, repository.GetUsedCredits(db), func(keyHash []byte) bool {
+			return choices[string(keyHash)]
+		}, masterWallet.PublicKeyHash()),
+	)
+	var trustees *emergency.Trustees
+	if o.trusteesDir != "" {
+		trustees, err = emergency.ReadTrustees(o.trusteesDir, o.trusteeQuorum)
+		if err != nil {
+			log.Fatalf("Failed to load trustees %s", err)
+		}
+	}
+	brake, err := emergency.Load(blockchain.FindBlock(store.GetTip, blocks.GetBlock))
+	if err != nil {
+		log.Fatalf("Failed to load emergency state %s", err)
+	}
+	if brake.Paused() {
+		log.Printf("ALERT: election is paused by its trustees: %s", brake.State().Reason)
+	}
+	getChainID := repository.GetChainID(db)
+	getAlgorithm := repository.GetAlgorithm(db)
 	hub := websocket.NewHub()
-	startForgerChooser(db, *masterWallet, hub)
-	wg := sync.WaitGroup{}
-	wg.Add(2)
-	go runSocketServer(&wg, db, hub, *masterWallet)
-	go runAPIServer(&wg, db, hub)
-	wg.Wait()
-}
-
-func startForgerChooser(db *bolt.DB, masterWallet wallet.Wallet, hub *websocket.Hub) {
-	getTip := repository.GetTip(db)
-	getBlock := repository.GetBlock(db)
-	c := cron.New()
-	c.Schedule(
-		cron.Every(30*time.Second),
-		alfa.Runner(
-			hub.RegisteredNodes,
-			hub.RandomUnicast,
-			getTip,
-			getBlock,
+	hub.LimitPerIP(o.maxConnsPerIP)
+	hub.SetChain(getChainID())
+	hub.SetHeartbeat(o.heartbeat)
+	hub.SetDeadlines(o.deadlines)
+	hub.PreferEncoding(wire)
+	go hub.Monitor()
+	clocks := alfa.NewClocks(o.maxClockOffset, hub.ClockOffsets, repository.RecordAudit(db))
+	book := mesh.NewBook(mesh.AlfaID, "localhost:10000")
+	feed := events.NewFeed()
+	dispatch := alfa.OutboxDispatcher(
+		repository.GetPendingBroadcasts(db),
+		repository.RemoveBroadcast(db),
+		hub.Deliver,
+		100,
+	)
+	certificates := blockchain.NewCertificateIndex(store.GetTip, blocks.GetBlock)
+	transportSigner := setUpTransportSigner(
+		o.transportKeyFile,
+		transport.Algorithm(o.transportAlgorithm),
+		hashAlgorithm,
+		*masterWallet,
+		signers,
+		certificates.Find,
+		repository.SubmitTransaction(db),
+	)
+	release := alfa.StakeReleaser(
+		blockchain.FindBlock(store.GetTip, blocks.GetBlock),
+		store.GetTransactionUTXO,
+		store.GetTransactions,
+		transaction.ReturnStakeOnChain(getAlgorithm, getChainID, transaction.NewReturnStakeTransaction(getAlgorithm, signers.transaction, *masterWallet)),
+		repository.SubmitTransaction(db),
+		masterWallet.PublicKeyHash(),
+		repository.IsSlashed(db),
+		repository.IsStakeBurned(db),
+		repository.RecordAudit(db),
+	)
+	reportFraud := alfa.FraudReporter(
+		fraud.Verify(certificates.Find),
+		getAlgorithm,
+		signers.transaction,
+		masterWallet.PublicKey,
+		repository.SaveFraudProof(db),
+		repository.SubmitTransaction(db),
+		repository.RecordAudit(db),
+	)
+	queue := intake.NewQueue(o.intakeWorkers, o.intakeQueue, o.intakeWait)
+	isReturnStake := transaction.IsReturnStakeTransaction(masterWallet.PublicKeyHash())
+	essential := func(t transaction.Transaction) bool {
+		return t.IsCertification() || isReturnStake(t)
+	}
+	// Pending votes wait out a paused election, so the emergency state isn't
+	// checked.
+	restoration, err := mempool.Restore(
+		store.GetTransactions,
+		transaction.VerifyChain(getChainID, transaction.Validated(validate, transaction.VerifyStakeReturns(
+			transaction.VerifyRecoveries(
+				transaction.VerifyTransactions(store.GetTransactionUTXO, wallet.VerifySignature),
+				blockchain.FindTransaction(blockchain.FindBlock(store.GetTip, blocks.GetBlock)),
+				store.GetTransactionUTXO,
+			),
+			masterWallet.PublicKeyHash(),
+			blockchain.FindTransaction(blockchain.FindBlock(store.GetTip, blocks.GetBlock)),
+		))),
+		func() (bool, error) {
+			f, err := repository.GetFinalization(db)()
+			return f != nil, err
+		},
+		essential,
+		repository.DeleteTransaction(db),
+	)
+	if err != nil {
+		log.Fatalf("Failed to restore pending transactions %s", err)
+	}
+	if restoration.Restored+restoration.Discarded > 0 {
+		log.Printf("Restored %d pending transactions, discarded %d which can't be forged anymore", restoration.Restored, restoration.Discarded)
+		details := fmt.Sprintf("restored=%d discarded=%d", restoration.Restored, restoration.Discarded)
+		if err := repository.RecordAudit(db)("mempool restored", details); err != nil {
+			log.Fatalf("Failed to record restored pending transactions in audit log %s", err)
+		}
+	}
+	pool, err := mempool.Load(store.GetTransactions, o.mempool, essential)
+	if err != nil {
+		log.Fatalf("Failed to load mempool %s", err)
+	}
+	castValidate := transaction.ValidateAll(validate, pool.Reserve())
+	board, err := results.Load(store.GetTip, blocks.GetBlock, masterWallet.PublicKeyHash())
+	if err != nil {
+		log.Fatalf("Failed to load results %s", err)
+	}
+	addBlock := board.AddBlock(pool.AddBlock(withdrawals.AddBlock(brake.AddBlock(queue.AddBlock(store.GetTip, blocks.GetBlock, hooks.AddBlock(events.PublishBlock(
+		blocks.AddBlock(store.GetTip, store.AddBlock),
+		store.GetTip,
+		blocks.GetBlock,
+		store.GetParties,
+		feed,
+	)))))))
+	submitEmergency := alfa.EmergencyBrake(
+		brake,
+		trustees,
+		getAlgorithm,
+		store.GetTip,
+		blocks.GetBlock,
+		addBlock,
+		blockchain.Rewind(store.GetTip, blocks.GetBlock, board.Rollback(blocks.Rollback(repository.RollbackTip(db)))),
+		repository.DiscardRolledBack(db),
+		repository.InvalidateReceipts(db),
+		hub.Deliver,
+		repository.RecordAudit(db),
+	)
+	submitWithdrawal := alfa.PartyWithdrawer(
+		withdrawals,
+		getAlgorithm,
+		signers.transaction,
+		masterWallet.PublicKey,
+		store.GetParties,
+		repository.SubmitTransaction(db),
+		repository.RecordAudit(db),
+	)
+	if o.publishTarget != "" {
+		published, err := publish.New(o.publishType, o.publishTarget)
+		if err != nil {
+			log.Fatalf("Failed to set up publication %s", err)
+		}
+		getParties := store.GetParties
+		getUTXOs := store.GetUTXOsByPublicKey
+		alfa.NewPublisher(
+			published,
+			signers.message,
+			[]alfa.Artifact{
+				{Name: "tally.json", Read: handlers.GetTally(getParties, getUTXOs, withdrawals.Get)},
+				{Name: "parties.json", Read: handlers.GetParties(getParties, getUTXOs)},
+			},
+			handlers.GetHeaders(blockchain.GetHeaders(store.GetTip, blocks.GetBlock)),
+			store.GetTip,
+		).Start(feed)
+		log.Printf("Publishing results to %s", published.Name())
+	}
+	shared := services{
+		store:   store,
+		db:      db,
+		blocks:  blocks,
+		master:  *masterWallet,
+		signers: signers,
+		hub:     hub,
+		feed:    feed,
+		pool:    pool,
+		book:    book,
+		board:   board,
+		clocks:  clocks,
+	}
+	scheduler := startForgerChooser(shared, jobDeps{
+		dispatch:          dispatch,
+		addBlock:          addBlock,
+		paused:            brake.Paused,
+		release:           release,
+		castValidate:      castValidate,
+		anchorer:          anchorer,
+		anchorInterval:    o.anchorInterval,
+		registration:      provider != nil,
+		ballotValue:       voterValue,
+		stakeReturnMisses: o.stakeReturnMisses,
+		forgerSelection:   forgerSelection,
+		mix:               o.mix,
+		deadline:          deadline,
+		certificationDir:  o.certificationDir,
+		scheduleFile:      o.scheduleFile,
+		compactor:         compactor,
+		compactWindow:     compactWindow,
+	})
+	consensus := handlers.Consensus{
+		Network:              networkType,
+		HashAlgorithm:        hashAlgorithm,
+		BlockVersion:         blockchain.Version,
+		MagicNumber:          blockchain.MagicNumber,
+		MaxBlockBytes:        blockchain.MaxBlockBytes,
+		MaxTransactionsBytes: blockchain.MaxTransactionsBytes,
+		Mix:                  o.mix,
+		Credits:              o.credits,
+		MultiQuestion:        len(questions) > 1,
+		StakeReturnMisses:    o.stakeReturnMisses,
+		ForgerSelection:      string(forgerSelection),
+	}
+	var faucet alfa.FaucetFn
+	if networkType == network.Testnet {
+		faucet = alfa.Faucet(
+			store.GetUTXOsByPublicKey,
+			store.GetTransactions,
+			blockchain.FindBlock(store.GetTip, blocks.GetBlock),
+			signers.transaction,
+			*masterWallet,
+			getAlgorithm,
+			getChainID,
+			repository.SubmitValidated(db, pool.Reserve(), pool.Release),
+		)
+		log.Printf("Running a test network, the faucet is served on /faucet")
+	}
+	return election{
+		store:     store,
+		db:        db,
+		hub:       hub,
+		scheduler: scheduler,
+		socket: socketHandler(shared, socketDeps{
+			findCertificate: certificates.Find,
+			release:         release,
+			reportFraud:     reportFraud,
+			transportSigner: transportSigner,
+			mix:             o.mix,
+			validate:        validate,
+			brake:           brake,
+			trustees:        trustees,
+			queue:           queue,
+			withdrawals:     withdrawals,
+		}),
+		api: maintenance.Handler(
+			guarded(authorizeAdmin, apiHandler(shared, apiDeps{
+				dispatch:         dispatch,
+				reportFraud:      reportFraud,
+				multiQuestion:    len(questions) > 1,
+				cumulative:       o.credits > 1,
+				kioskIssuer:      kioskIssuer,
+				provider:         provider,
+				deadline:         deadline,
+				mix:              o.mix,
+				castValidate:     castValidate,
+				release:          pool.Release,
+				brake:            brake,
+				submitEmergency:  submitEmergency,
+				withdrawals:      withdrawals,
+				submitWithdrawal: submitWithdrawal,
+				withdrawnVotes:   withdrawnVotes,
+				elections:        elections,
+				observerLimits:   observerLimits,
+				queue:            queue,
+				parseAddress:     address.Parser(legacyUntil),
+				compactor:        compactor,
+				turnout:          turnout,
+				exportBallots:    exportBallots,
+				verifier:         verifier,
+				consensus:        consensus,
+				scheduler:        scheduler,
+				faucet:           faucet,
+				creditValue:      questions.Value(),
+				credentials:      authorizeAdmin != nil,
+			})),
+			"/events",
+			"/results/stream",
+			"/metrics",
 		),
+	}
+}
+
+// services are the parts of an election its jobs, its socket and its api
+// share.
+type services struct {
+	store   storage.Repository
+	db      *bolt.DB
+	blocks  *blockchain.BlockCache
+	master  wallet.Wallet
+	signers chainSigners
+	hub     *websocket.Hub
+	feed    *events.Feed
+	pool    *mempool.Pool
+	book    *mesh.Book
+	board   *results.Board
+	clocks  *alfa.Clocks
+}
+
+// jobDeps are what the scheduled jobs of an election need besides the
+// services. Optional jobs are added only if what they need is set:
+// anchoring with an anchorer, registration and provisional ballots with
+// registration, stake returns with stakeReturnMisses, finalization with a
+// deadline and compaction with a compactWindow.
+type jobDeps struct {
+	dispatch          alfa.RunnerFn
+	addBlock          blockchain.AddBlockFn
+	paused            emergency.PausedFn
+	release           stake.ReleaseFn
+	castValidate      transaction.ValidateFn
+	anchorer          anchor.Anchorer
+	anchorInterval    time.Duration
+	registration      bool
+	ballotValue       int
+	stakeReturnMisses int
+	forgerSelection   alfa.ForgerSelection
+	mix               bool
+	deadline          *alfa.Deadline
+	certificationDir  string
+	scheduleFile      string
+	compactor         *alfa.Compactor
+	compactWindow     *alfa.Window
+}
+
+func startForgerChooser(s services, d jobDeps) *alfa.Scheduler {
+	getTip := s.store.GetTip
+	getBlock := s.blocks.GetBlock
+	scheduler := alfa.NewScheduler(repository.RecordAudit(s.db))
+	eligibleNodes := s.clocks.Eligible(alfa.EligibleNodes(s.hub.RegisteredNodes, repository.GetNodes(s.db), repository.IsSlashed(s.db)))
+	stakeWeights := alfa.StakeWeights(repository.GetNodes(s.db), s.store.GetUTXOsByPublicKey)
+	forging := alfa.Sortition(
+		d.paused,
+		eligibleNodes,
+		stakeWeights,
+		s.hub.Broadcast,
+		getTip,
+		getBlock,
+		repository.GetLatestRound(s.db),
+		repository.StartRound(s.db),
 	)
-	c.Schedule(
-		cron.Every(time.Minute),
+	if d.forgerSelection == alfa.AlfaSelection {
+		forging = alfa.Runner(
+			d.paused,
+			eligibleNodes,
+			stakeWeights,
+			s.hub.Unicast,
+			getTip,
+			getBlock,
+			repository.GetLatestRound(s.db),
+			repository.StartRound(s.db),
+			repository.CompleteRound(s.db),
+		)
+	}
+	scheduler.Add(alfa.ForgingJob, 30*time.Second, forging)
+	scheduler.Add(
+		alfa.CleaningJob,
+		time.Minute,
 		alfa.Cleaner(
-			repository.GetTransactions(db),
-			transaction.IsReturnStakeTransaction(masterWallet.PublicKeyHash()),
+			d.paused,
+			s.store.GetTransactions,
+			transaction.IsReturnStakeTransaction(s.master.PublicKeyHash()),
 			getTip,
 			getBlock,
-			repository.AddBlock(db),
-			hub.Broadcast,
+			d.addBlock,
+			s.hub.Deliver,
 		),
 	)
-	c.Start()
+	scheduler.Add(alfa.OutboxJob, 5*time.Second, d.dispatch)
+	scheduler.Add(alfa.ClockJob, 30*time.Second, alfa.RunnerFn(s.clocks.Check))
+	scheduler.Add(alfa.MempoolJob, time.Minute, alfa.RunnerFn(s.pool.Sweep(s.store.GetTransactions, repository.DeleteTransaction(s.db))))
+	scheduler.Add(
+		alfa.PeerExchangeJob,
+		30*time.Second,
+		alfa.RunnerFn(s.book.Exchange(
+			func() (int, error) { return blockchain.GetHeight(getTip, getBlock) },
+			s.hub.RegisteredNodes,
+			s.hub.Broadcast,
+		)),
+	)
+	if d.anchorer != nil {
+		scheduler.Add(
+			alfa.AnchoringJob,
+			d.anchorInterval,
+			alfa.TipAnchorer(
+				d.anchorer,
+				getTip,
+				getBlock,
+				repository.GetAnchors(s.db),
+				repository.SaveAnchor(s.db),
+			),
+		)
+	}
+	if d.registration {
+		scheduler.Add(
+			alfa.RegistrationJob,
+			time.Minute,
+			alfa.Registrar(
+				repository.GetPendingRegistrations(s.db),
+				s.store.GetUTXOsByPublicKey,
+				s.store.GetTransactions,
+				blockchain.FindBlock(getTip, getBlock),
+				repository.GetAlgorithm(s.db),
+				s.signers.transaction,
+				s.master,
+				repository.FundRegistrations(s.db, s.pool.Reserve(), s.pool.Release),
+				d.ballotValue,
+				50,
+			),
+		)
+		scheduler.Add(
+			alfa.ProvisionalJob,
+			time.Minute,
+			alfa.ProvisionalCaster(
+				repository.GetFinalization(s.db),
+				repository.GetProvisionalBallots(s.db),
+				repository.CastProvisionalBallot(s.db, outputsOrder(d.mix), d.castValidate, s.pool.Release),
+				repository.RecordAudit(s.db),
+			),
+		)
+	}
+	if d.stakeReturnMisses > 0 {
+		scheduler.Add(
+			alfa.StakeJob,
+			time.Minute,
+			alfa.MissedRoundsWatcher(
+				repository.GetNodes(s.db),
+				repository.GetRounds(s.db),
+				d.release,
+				d.stakeReturnMisses,
+			),
+		)
+	}
+	if d.deadline != nil {
+		scheduler.Add(
+			alfa.FinalizationJob,
+			30*time.Second,
+			alfa.AutoFinalizer(
+				*d.deadline,
+				repository.GetFinalizationState(s.db),
+				repository.SaveFinalizationState(s.db),
+				s.store.GetTransactions,
+				transaction.IsStakeTransaction(s.master.PublicKeyHash()),
+				transaction.IsReturnStakeTransaction(s.master.PublicKeyHash()),
+				repository.GetFinalization(s.db),
+				func() (*finalization.Finalization, error) {
+					f, err := alfa.Finalize(getTip, getBlock, repository.SaveFinalization(s.db), repository.RecordAudit(s.db))
+					if err == nil {
+						s.board.Notify()
+					}
+					return f, err
+				},
+				repository.GetCosignatures(s.db),
+				s.hub.Broadcast,
+				func(f finalization.Finalization) (*certification.Bundle, error) {
+					return alfa.Certify(
+						d.certificationDir,
+						f,
+						getBlock,
+						s.store.GetParties,
+						s.store.GetUTXOsByPublicKey,
+						repository.GetAuditLog(s.db),
+						repository.GetCosignatures(s.db),
+						s.signers.certificate,
+						s.master.PublicKey,
+					)
+				},
+				repository.RecordAudit(s.db),
+			),
+		)
+	}
+	if d.compactWindow != nil {
+		log.Printf("Database is compacted within %s", d.compactWindow)
+		scheduler.Add(alfa.CompactionJob, 10*time.Minute, alfa.CompactionRunner(d.compactor, *d.compactWindow))
+	}
+	if d.scheduleFile != "" {
+		intervals, err := alfa.ReadIntervals(d.scheduleFile)
+		if err != nil {
+			log.Fatalf("Failed to load schedule %s", err)
+		}
+		if err := scheduler.Reschedule(intervals); err != nil {
+			log.Fatalf("Failed to apply schedule %s", err)
+		}
+	}
+	if err := scheduler.Start(); err != nil {
+		log.Printf("Failed to start scheduler %s", err)
+	}
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if d.scheduleFile == "" {
+				log.Println("No schedule file configured, nothing to reload")
+				continue
+			}
+			intervals, err := alfa.ReadIntervals(d.scheduleFile)
+			if err != nil {
+				log.Printf("Failed to reload schedule %s", err)
+				continue
+			}
+			if err := scheduler.Reschedule(intervals); err != nil {
+				log.Printf("Failed to apply reloaded schedule %s", err)
+			}
+		}
+	}()
+	return scheduler
+}
+
+// chainSigners sign with the master key for every purpose the signer
+// distinguishes.
+type chainSigners struct {
+	transaction wallet.Signer
+	certificate wallet.Signer
+	message     wallet.Signer
+}
+
+func setUpChainSigners(socket, secretFile, publicKeyFile, privateKeyFile string, passphrase keyfiles.PassphraseFn) (*wallet.Wallet, chainSigners, error) {
+	if socket == "" {
+		w, err := wallet.Import(keyfiles.KeyFiles{
+			PublicKeyFile:  publicKeyFile,
+			PrivateKeyFile: privateKeyFile,
+			Passphrase:     passphrase,
+		})
+		if err != nil {
+			return nil, chainSigners{}, err
+		}
+		local := wallet.NewSigner(*w)
+		return w, chainSigners{transaction: local, certificate: local, message: local}, nil
+	}
+	w, err := wallet.ImportPublic(publicKeyFile)
+	if err != nil {
+		return nil, chainSigners{}, err
+	}
+	secret, err := signer.LoadOrGenerateSecret(secretFile)
+	if err != nil {
+		return nil, chainSigners{}, err
+	}
+	client := signer.NewClient(socket, secret)
+	publicKey, err := client.PublicKey()
+	if err != nil {
+		return nil, chainSigners{}, errors.Wrap(err, "Failed to reach signer")
+	}
+	if !bytes.Equal(publicKey, w.PublicKey) {
+		return nil, chainSigners{}, errors.Errorf("Signer holds a key other than %s", publicKeyFile)
+	}
+	return w, chainSigners{
+		transaction: client.Signer(signer.PurposeTransaction, w.PublicKey),
+		certificate: client.Signer(signer.PurposeCertificate, w.PublicKey),
+		message:     client.Signer(signer.PurposeMessage, w.PublicKey),
+	}, nil
 }
 
-func runSocketServer(wg *sync.WaitGroup, db *bolt.DB, hub *websocket.Hub, w wallet.Wallet) {
-	defer wg.Done()
-	getTip := repository.GetTip(db)
-	getBlock := repository.GetBlock(db)
+func setUpTransportSigner(
+	keyFile string,
+	algorithm transport.Algorithm,
+	hashAlgorithm digest.Algorithm,
+	w wallet.Wallet,
+	signers chainSigners,
+	findCertificate transport.FindCertificateFn,
+	submitTransaction transaction.SaveTransaction,
+) wallet.Signer {
+	chainSigner := signers.message
+	if keyFile == "" {
+		return chainSigner
+	}
+	key, err := transport.LoadOrGenerate(keyFile, algorithm)
+	if err != nil {
+		log.Fatalf("Failed to load transport key %s", err)
+	}
+	signer := transport.NewCertifiedSigner(chainSigner, w.PublicKey, *key, findCertificate)
+	if signer.Certified() {
+		return signer
+	}
+	certificate, err := transport.NewCertificate(*key, signers.certificate, w.PublicKey)
+	if err != nil {
+		log.Fatalf("Failed to certify transport key %s", err)
+	}
+	certification, err := transaction.NewCertificationTransaction(hashAlgorithm, *certificate)
+	if err != nil {
+		log.Fatalf("Failed to create certification transaction %s", err)
+	}
+	if err := submitTransaction(*certification); err != nil {
+		log.Fatalf("Failed to submit certification transaction %s", err)
+	}
+	log.Printf("Transport key %s will be used once its certificate is forged into a block", key.Verifier())
+	return signer
+}
+
+// socketDeps are what the handlers of the node messages need besides the
+// services.
+type socketDeps struct {
+	findCertificate transport.FindCertificateFn
+	release         stake.ReleaseFn
+	reportFraud     fraud.ReportFn
+	transportSigner wallet.Signer
+	mix             bool
+	validate        transaction.ValidateFn
+	brake           *emergency.Switch
+	trustees        *emergency.Trustees
+	queue           *intake.Queue
+	withdrawals     *withdrawal.Registry
+}
+
+func socketHandler(s services, d socketDeps) http.Handler {
+	getTip := s.store.GetTip
+	getBlock := s.blocks.GetBlock
 	findBlock := blockchain.FindBlock(getTip, getBlock)
-	authorizer := blockchain.BlockchainAuthorizer(findBlock)
-	isStakeTransaction := transaction.IsStakeTransaction(w.PublicKeyHash())
-	router := websocket.Router{
-		websocket.GetBlockchainHeightMessage: handlers.GetHeightHandler(getTip, getBlock),
-		websocket.GetMissingBlocksMessage:    handlers.GetMissingBlocks(getTip, getBlock),
-		websocket.GetBlockMessage:            handlers.GetBlock(getBlock),
-		websocket.RegisterMessage:            handlers.Register(hub).Authorized(authorizer),
-		websocket.BlockForgedMessage: handlers.BlockForged(
-			getTip,
-			getBlock,
-			blockchain.VerfiyBlock(
+	authorizer := blockchain.BlockchainAuthorizer(findBlock, d.findCertificate)
+	isStakeTransaction := transaction.IsStakeTransaction(s.master.PublicKeyHash())
+	getChainID := repository.GetChainID(s.db)
+	getAlgorithm := repository.GetAlgorithm(s.db)
+	verifyBlock := sortition.VerifyBlock(getBlock, hooks.VerifyBlock(blockchain.VerfiyBlock(
+		transaction.VerifyChain(getChainID, emergency.VerifyTransactions(d.brake, d.trustees, transaction.Validated(d.validate, transaction.VerifyStakeReturns(
+			transaction.VerifyRecoveries(
 				transaction.VerifyTransactions(
-					repository.GetTransactionUTXO(db),
+					s.store.GetTransactionUTXO,
 					wallet.VerifySignature,
 				),
-				isStakeTransaction,
+				blockchain.FindTransaction(findBlock),
+				s.store.GetTransactionUTXO,
 			),
-			repository.AddNewBlock(db),
-			isStakeTransaction,
-			repository.SaveTransaction(db),
-			transaction.NewReturnStakeTransaction(w),
-			hub.Broadcast,
-		),
+			s.master.PublicKeyHash(),
+			blockchain.FindTransaction(findBlock),
+		)))),
+		isStakeTransaction,
+	)))
+	if d.mix {
+		verifyBlock = mixer.VerifyMixedBlock(verifyBlock)
+	}
+	reconstructor := blockchain.NewReconstructor(s.store.GetTransactions)
+	blockForged := handlers.BlockForged(
+		getTip,
+		getBlock,
+		d.findCertificate,
+		verifyBlock,
+		s.board.AddNewBlock(s.pool.AddNewBlock(d.withdrawals.AddNewBlock(d.brake.AddNewBlock(d.queue.AddNewBlock(getTip, getBlock, hooks.AddNewBlock(events.PublishNewBlock(
+			s.blocks.AddNewBlock(getTip, s.store.AddNewBlock),
+			getTip,
+			getBlock,
+			s.store.GetParties,
+			s.feed,
+		))))))),
+		isStakeTransaction,
+		s.store.SaveTransaction,
+		transaction.ReturnStakeOnChain(getAlgorithm, getChainID, transaction.NewReturnStakeTransaction(getAlgorithm, s.signers.transaction, s.master)),
+		alfa.StakeBurner(repository.BurnStake(s.db), repository.RecordAudit(s.db)),
+		s.hub.Deliver,
+		s.hub.NodeID,
+		repository.GetLatestRound(s.db),
+		repository.CompleteRound(s.db),
+		fraud.NewWitness().Observe,
+		d.reportFraud,
+		reconstructor.Expand,
+		s.clocks.Annotator(repository.SaveBlockAnnotation(s.db)),
+	)
+	router := websocket.Router{
+		websocket.GetBlockchainHeightMessage: handlers.GetHeightHandler(getTip, getBlock),
+		websocket.GetMissingBlocksMessage:    handlers.GetMissingBlocks(getTip, getBlock),
+		websocket.GetBlockMessage:            handlers.GetBlock(getBlock),
+		websocket.GetBlocksRangeMessage:      handlers.GetBlocksRange(getTip, getBlock, 500),
+		websocket.GetNodesMessage:            handlers.GetNodes(s.hub.RegisteredNodes),
+		websocket.PeerExchangeMessage:        s.book.Handler(s.hub.NodeID, s.hub.RegisteredNodes),
+		websocket.RegisterMessage: handlers.Register(
+			s.hub,
+			d.findCertificate,
+			repository.SaveNode(s.db),
+		).Authorized(authorizer),
+		websocket.DeregisterMessage: handlers.Deregister(d.findCertificate, d.release).Authorized(authorizer),
+		websocket.GetAccountMessage: handlers.GetAccount(d.findCertificate, alfa.StakeAccount(
+			findBlock,
+			s.store.GetUTXOsByPublicKey,
+			s.store.GetTransactionUTXO,
+			s.store.GetTransactions,
+			s.master.PublicKeyHash(),
+			repository.IsStakeBurned(s.db),
+		)).Authorized(authorizer),
+		websocket.BlockForgedMessage:       blockForged,
+		websocket.CompactBlockMessage:      blockForged,
+		websocket.BlockTransactionsMessage: handlers.BlockTransactions(reconstructor.Fetched, blockForged),
+		websocket.FraudProofMessage:        handlers.FraudProof(d.reportFraud),
+		websocket.FinalizationCosignedMessage: handlers.FinalizationCosigned(
+			repository.GetFinalization(s.db),
+			s.store.GetParties,
+			repository.SaveCosignature(s.db),
+		).Authorized(authorizer),
 	}
 	mux := http.NewServeMux()
-	mux.Handle("/", websocket.PingPongConnection(router, hub, wallet.NewSigner(w)))
-	http.ListenAndServe(":10000", mux)
+	mux.Handle("/", websocket.PingPongConnection(router, s.hub, d.transportSigner))
+	return mux
+}
+
+func outputsOrder(mix bool) transaction.OrderOutputsFn {
+	if mix {
+		return mixer.Outputs
+	}
+	return transaction.KeepOutputsOrder
+}
+
+// guarded requires admin credentials on the admin endpoints if authorize is
+// set.
+func guarded(authorize access.AuthorizeFn, h http.Handler) http.Handler {
+	if authorize == nil {
+		return h
+	}
+	return alfa.Guarded(authorize, alfa.AdminRules, h)
+}
+
+// apiDeps are what the handlers of the api need besides the services.
+// castValidate reserves room for casts in the mempool and release gives it
+// back if a cast fails. The kiosk, voter registration and faucet endpoints
+// are only served if kioskIssuer, provider and faucet are set.
+type apiDeps struct {
+	dispatch         alfa.RunnerFn
+	reportFraud      fraud.ReportFn
+	multiQuestion    bool
+	cumulative       bool
+	kioskIssuer      []byte
+	provider         eligibility.Provider
+	deadline         *alfa.Deadline
+	mix              bool
+	castValidate     transaction.ValidateFn
+	release          transaction.ReleaseFn
+	brake            *emergency.Switch
+	submitEmergency  emergency.SubmitFn
+	withdrawals      *withdrawal.Registry
+	submitWithdrawal withdrawal.SubmitFn
+	withdrawnVotes   transaction.PriorVotes
+	elections        *_election.Registry
+	observerLimits   observer.Limits
+	queue            *intake.Queue
+	parseAddress     address.ParseFn
+	compactor        *alfa.Compactor
+	turnout          *analytics.Turnout
+	exportBallots    research.ExportFn
+	verifier         *oidc.Verifier
+	consensus        handlers.Consensus
+	scheduler        *alfa.Scheduler
+	faucet           alfa.FaucetFn
+	creditValue      int
+	credentials      bool
 }
 
-func runAPIServer(wg *sync.WaitGroup, db *bolt.DB, hub *websocket.Hub) {
-	getTip := repository.GetTip(db)
-	getBlock := repository.GetBlock(db)
+func apiHandler(s services, d apiDeps) http.Handler {
+	getTip := s.store.GetTip
+	getBlock := s.blocks.GetBlock
 	findBlock := blockchain.FindBlock(getTip, getBlock)
+	getAlgorithm := repository.GetAlgorithm(s.db)
+	orderOutputs := outputsOrder(d.mix)
+	whileOpen := func(h api.Handler) api.Handler {
+		return handlers.WhileNotPaused(d.brake.Paused, handlers.WhileIntakeOpen(repository.GetFinalizationState(s.db), h))
+	}
+	queued := func(h api.Handler) api.Handler {
+		return whileOpen(d.queue.Handler(h))
+	}
 	httpRouter := mux.NewRouter()
-	httpRouter.
-		HandleFunc("/vote",
+	if !d.multiQuestion {
+		httpRouter.
+			HandleFunc("/vote",
+				api.NewHandleFunc(
+					queued(
+						handlers.Vote(
+							d.parseAddress,
+							findBlock,
+							repository.CastVote(s.db, orderOutputs, d.castValidate, d.release),
+							repository.CastAllocations(s.db, orderOutputs, d.castValidate, d.release),
+							outbox.DispatchFn(d.dispatch),
+						),
+					),
+				),
+			).Methods("POST")
+	}
+	// Ballots and provisional ballots spend the whole funding of a voter at
+	// once, voters with several credits split them on /vote.
+	if !d.cumulative {
+		httpRouter.
+			HandleFunc("/ballot",
+				api.NewHandleFunc(
+					queued(
+						handlers.CastBallot(
+							d.parseAddress,
+							findBlock,
+							s.store.GetParties,
+							repository.CastBallot(s.db, orderOutputs, d.castValidate, d.release),
+							outbox.DispatchFn(d.dispatch),
+						),
+					),
+				),
+			).Methods("POST")
+	}
+	httpRouter.HandleFunc("/vote/status/{id}",
+		api.NewHandleFunc(
+			handlers.GetVoteStatus(d.queue.Ticket),
+		),
+	).Methods("GET")
+	if d.kioskIssuer != nil {
+		httpRouter.
+			HandleFunc("/kiosk/vote",
+				api.NewHandleFunc(
+					whileOpen(
+						handlers.KioskVote(
+							d.parseAddress,
+							d.kioskIssuer,
+							findBlock,
+							repository.CastKioskVote(s.db, orderOutputs, d.castValidate, d.release, s.signers.transaction, s.master.PublicKey),
+							outbox.DispatchFn(d.dispatch),
+						),
+					),
+				),
+			).Methods("POST")
+	}
+	if d.provider != nil {
+		httpRouter.
+			HandleFunc("/voters",
+				api.NewHandleFunc(
+					whileOpen(
+						handlers.RegisterVoter(
+							d.provider,
+							repository.RegisterVoter(s.db),
+							getAlgorithm,
+							repository.SubmitTransaction(s.db),
+						),
+					),
+				),
+			).Methods("POST")
+		if d.verifier != nil {
+			httpRouter.
+				HandleFunc("/voters/oidc",
+					api.NewHandleFunc(
+						whileOpen(
+							handlers.RegisterOIDCVoter(
+								d.verifier,
+								d.provider,
+								repository.RegisterVoter(s.db),
+								getAlgorithm,
+								repository.SubmitTransaction(s.db),
+							),
+						),
+					),
+				).Methods("POST")
+		}
+		httpRouter.HandleFunc("/guardians/{address}",
+			api.NewHandleFunc(
+				handlers.GetGuardians(d.parseAddress, blockchain.FindGuardianship(findBlock)),
+			),
+		).Methods("GET")
+		httpRouter.
+			HandleFunc("/recovery",
+				api.NewHandleFunc(
+					whileOpen(
+						handlers.RecoverVote(
+							d.parseAddress,
+							blockchain.FindTransaction(findBlock),
+							s.store.GetUTXOsByPublicKey,
+							getAlgorithm,
+							repository.SubmitTransaction(s.db),
+						),
+					),
+				),
+			).Methods("POST")
+	}
+	// The faucet gives votes to anyone, so it is served only on chains whose
+	// genesis block names them a test network.
+	if d.faucet != nil && d.consensus.Network == network.Testnet {
+		httpRouter.
+			HandleFunc("/faucet",
+				api.NewHandleFunc(
+					whileOpen(handlers.Faucet(d.parseAddress, d.faucet, d.creditValue, d.consensus.Credits)),
+				),
+			).Methods("POST")
+		httpRouter.
+			HandleFunc("/faucet/keys",
+				api.NewHandleFunc(
+					whileOpen(handlers.ThrowawayKey(d.faucet, d.creditValue, d.consensus.Credits)),
+				),
+			).Methods("POST")
+	}
+	if d.provider != nil && !d.cumulative {
+		httpRouter.
+			HandleFunc("/provisional",
+				api.NewHandleFunc(
+					whileOpen(
+						handlers.SubmitProvisionalBallot(
+							d.parseAddress,
+							d.provider,
+							s.store.GetParties,
+							repository.SubmitProvisionalBallot(s.db),
+							repository.RecordAudit(s.db),
+						),
+					),
+				),
+			).Methods("POST")
+		httpRouter.HandleFunc("/admin/provisional",
+			api.NewHandleFunc(
+				handlers.GetProvisionalBallots(repository.GetProvisionalBallots(s.db)),
+			),
+		).Methods("GET")
+		httpRouter.HandleFunc("/admin/provisional",
 			api.NewHandleFunc(
-				handlers.Vote(
-					findBlock,
-					repository.CastVote(db),
-					hub.Broadcast,
+				handlers.AdjudicateProvisionalBallot(
+					repository.GetFinalization(s.db),
+					repository.AcceptProvisionalBallot(s.db),
+					repository.RejectProvisionalBallot(s.db),
+					repository.RecordAudit(s.db),
 				),
 			),
 		).Methods("POST")
+	}
 	httpRouter.HandleFunc("/parties",
-		api.NewHandleFunc(
+		api.NewSignedHandleFunc(
+			s.signers.message,
 			handlers.GetParties(
-				repository.GetParties(db),
-				repository.GetUTXOsByPublicKey(db),
+				s.store.GetParties,
+				s.store.GetUTXOsByPublicKey,
+			),
+		),
+	).Methods("GET")
+	httpRouter.HandleFunc("/tally",
+		api.NewSignedHandleFunc(
+			s.signers.message,
+			handlers.GetTally(
+				s.store.GetParties,
+				s.store.GetUTXOsByPublicKey,
+				d.withdrawals.Get,
+			),
+		),
+	).Methods("GET")
+	httpRouter.HandleFunc("/network-info",
+		api.NewSignedHandleFunc(
+			s.signers.message,
+			handlers.GetNetworkInfo(getTip, getBlock, d.consensus, d.scheduler.Intervals, d.deadline, repository.GetFinalizationState(s.db), s.master.PublicKey, s.master.Address),
+		),
+	).Methods("GET")
+	getResults := s.board.Get(s.store.GetParties, d.withdrawals.Get, repository.GetFinalization(s.db))
+	httpRouter.HandleFunc("/results",
+		api.NewSignedHandleFunc(
+			s.signers.message,
+			handlers.GetResults(getResults),
+		),
+	).Methods("GET")
+	httpRouter.Handle("/results/stream", results.StreamHandler(s.board, getResults)).Methods("GET")
+	httpRouter.HandleFunc("/withdrawals",
+		api.NewSignedHandleFunc(
+			s.signers.message,
+			handlers.GetWithdrawals(d.withdrawals.List),
+		),
+	).Methods("GET")
+	authorizeObserver := observer.Authorize(repository.GetObserverKey(s.db), observer.NewLimiter(d.observerLimits))
+	observerVotes := observer.Votes(getTip, getBlock, s.store.GetTransactions, s.master.PublicKeyHash())
+	httpRouter.HandleFunc("/observer/tally",
+		api.NewHandleFunc(
+			handlers.Observed(authorizeObserver, observer.Tally, handlers.ForAnyObserver(
+				handlers.GetTally(
+					s.store.GetParties,
+					s.store.GetUTXOsByPublicKey,
+					d.withdrawals.Get,
+				),
+			)),
+		),
+	).Methods("GET")
+	httpRouter.HandleFunc("/observer/inflow",
+		api.NewHandleFunc(
+			handlers.Observed(authorizeObserver, observer.Inflow, handlers.GetInflow(observer.InflowStats(observerVotes, s.master.PublicKeyHash()))),
+		),
+	).Methods("GET")
+	httpRouter.HandleFunc("/observer/conflicts",
+		api.NewHandleFunc(
+			handlers.Observed(authorizeObserver, observer.Conflicts, handlers.GetPartyConflicts(observer.PartyConflicts(observerVotes, repository.GetConflicts(s.db)))),
+		),
+	).Methods("GET")
+	httpRouter.HandleFunc("/elections",
+		api.NewSignedHandleFunc(
+			s.signers.message,
+			handlers.GetElections(d.elections.List),
+		),
+	).Methods("GET")
+	httpRouter.HandleFunc("/elections/{id}/parties",
+		api.NewSignedHandleFunc(
+			s.signers.message,
+			handlers.GetElectionParties(d.elections.Get, s.store.GetParties, s.store.GetUTXOsByPublicKey),
+		),
+	).Methods("GET")
+	if d.turnout != nil {
+		httpRouter.HandleFunc("/analytics/turnout",
+			api.NewSignedHandleFunc(
+				s.signers.message,
+				handlers.GetTurnout(d.turnout.Series),
+			),
+		).Methods("GET")
+	}
+	httpRouter.HandleFunc("/headers",
+		api.NewSignedHandleFunc(
+			s.signers.message,
+			handlers.GetHeaders(blockchain.GetHeaders(getTip, getBlock)),
+		),
+	).Methods("GET")
+	httpRouter.HandleFunc("/votes/{transactionId}/proof",
+		api.NewSignedHandleFunc(
+			s.signers.message,
+			handlers.GetInclusionProof(blockchain.ProveInclusion(getTip, getBlock), repository.GetInvalidation(s.db)),
+		),
+	).Methods("GET")
+	httpRouter.HandleFunc("/fraud",
+		api.NewHandleFunc(
+			handlers.SubmitFraudProof(d.reportFraud),
+		),
+	).Methods("POST")
+	httpRouter.HandleFunc("/fraud",
+		api.NewSignedHandleFunc(
+			s.signers.message,
+			handlers.GetFraudProofs(repository.GetFraudProofs(s.db)),
+		),
+	).Methods("GET")
+	httpRouter.HandleFunc("/emergency",
+		api.NewHandleFunc(
+			handlers.SubmitEmergency(d.submitEmergency),
+		),
+	).Methods("POST")
+	httpRouter.HandleFunc("/emergency",
+		api.NewSignedHandleFunc(
+			s.signers.message,
+			handlers.GetEmergency(d.brake.State),
+		),
+	).Methods("GET")
+	httpRouter.HandleFunc("/admin/withdrawals",
+		api.NewHandleFunc(
+			handlers.WithdrawParty(d.parseAddress, d.withdrawnVotes, d.submitWithdrawal),
+		),
+	).Methods("POST")
+	httpRouter.HandleFunc("/admin/observers",
+		api.NewHandleFunc(
+			handlers.IssueObserverKey(d.parseAddress, s.store.GetParties, alfa.ObserverKeyIssuer(observer.Issue(repository.SaveObserverKey(s.db)), repository.RecordAudit(s.db))),
+		),
+	).Methods("POST")
+	httpRouter.HandleFunc("/admin/observers",
+		api.NewHandleFunc(
+			handlers.GetObserverKeys(repository.GetObserverKeys(s.db)),
+		),
+	).Methods("GET")
+	httpRouter.HandleFunc("/admin/observers/{id}",
+		api.NewHandleFunc(
+			handlers.RevokeObserverKey(alfa.ObserverKeyRevoker(observer.Revoke(repository.GetObserverKey(s.db), repository.SaveObserverKey(s.db)), repository.RecordAudit(s.db))),
+		),
+	).Methods("DELETE")
+	httpRouter.HandleFunc("/research/requests",
+		api.NewHandleFunc(
+			handlers.SubmitResearchRequest(alfa.ResearchSubmitter(research.Submit(d.elections.Get, repository.SaveResearchRequest(s.db)), repository.RecordAudit(s.db))),
+		),
+	).Methods("POST")
+	httpRouter.HandleFunc("/research/ballots",
+		api.NewHandleFunc(
+			handlers.ExportBallots(research.Authorize(repository.GetResearchRequest(s.db)), alfa.ResearchExporter(d.exportBallots, repository.RecordAudit(s.db))),
+		),
+	).Methods("GET")
+	httpRouter.HandleFunc("/admin/research",
+		api.NewHandleFunc(
+			handlers.GetResearchRequests(repository.GetResearchRequests(s.db)),
+		),
+	).Methods("GET")
+	httpRouter.HandleFunc("/admin/research/{id}/approve",
+		api.NewHandleFunc(
+			handlers.DecideResearchRequest(alfa.ResearchDecider(research.Decide(repository.GetResearchRequest(s.db), repository.SaveResearchRequest(s.db)), repository.RecordAudit(s.db)), true),
+		),
+	).Methods("POST")
+	httpRouter.HandleFunc("/admin/research/{id}/reject",
+		api.NewHandleFunc(
+			handlers.DecideResearchRequest(alfa.ResearchDecider(research.Decide(repository.GetResearchRequest(s.db), repository.SaveResearchRequest(s.db)), repository.RecordAudit(s.db)), false),
+		),
+	).Methods("POST")
+	httpRouter.HandleFunc("/admin/elections",
+		api.NewHandleFunc(
+			handlers.CreateElection(alfa.ElectionCreator(d.elections.Create(d.parseAddress, s.store.GetParties), repository.RecordAudit(s.db))),
+		),
+	).Methods("POST")
+	httpRouter.HandleFunc("/admin/elections/{id}/open",
+		api.NewHandleFunc(
+			handlers.TransitionElection(alfa.ElectionTransition("election opened", d.elections.Open(), repository.RecordAudit(s.db))),
+		),
+	).Methods("POST")
+	httpRouter.HandleFunc("/admin/elections/{id}/close",
+		api.NewHandleFunc(
+			handlers.TransitionElection(alfa.ElectionTransition("election closed", d.elections.Close(), repository.RecordAudit(s.db))),
+		),
+	).Methods("POST")
+	httpRouter.HandleFunc("/admin/rounds",
+		api.NewHandleFunc(
+			handlers.GetRounds(repository.GetRounds(s.db)),
+		),
+	).Methods("GET")
+	if d.deadline != nil {
+		httpRouter.HandleFunc("/admin/finalization",
+			api.NewHandleFunc(
+				handlers.GetFinalization(
+					repository.GetFinalizationState(s.db),
+					repository.GetFinalization(s.db),
+					repository.GetCosignatures(s.db),
+					d.deadline.Quorum,
+				),
 			),
+		).Methods("GET")
+	}
+	httpRouter.HandleFunc("/admin/balance",
+		api.NewHandleFunc(
+			handlers.GetBalanceAt(d.parseAddress, repository.GetUTXOsByPublicKeyAt(s.db)),
+		),
+	).Methods("GET")
+	httpRouter.HandleFunc("/admin/descriptors",
+		api.NewHandleFunc(
+			handlers.GetDescriptors(d.parseAddress, descriptor.Exporter(
+				getTip,
+				getBlock,
+				s.store.GetParties,
+				repository.GetNodes(s.db),
+				s.master.PublicKeyHash(),
+			)),
+		),
+	).Methods("GET")
+	httpRouter.HandleFunc("/admin/undo/{block}",
+		api.NewHandleFunc(
+			handlers.GetUndo(repository.GetUndo(s.db)),
 		),
 	).Methods("GET")
+	httpRouter.HandleFunc("/admin/conflicts/{txid}",
+		api.NewHandleFunc(
+			handlers.GetConflicts(repository.GetConflicts(s.db)),
+		),
+	).Methods("GET")
+	httpRouter.HandleFunc("/admin/connections",
+		api.NewHandleFunc(
+			handlers.GetConnections(s.hub.Peers),
+		),
+	).Methods("GET")
+	httpRouter.HandleFunc("/admin/connections/{node}",
+		api.NewHandleFunc(
+			handlers.Disconnect(s.hub.Disconnect, repository.RecordAudit(s.db)),
+		),
+	).Methods("DELETE")
+	httpRouter.HandleFunc("/admin/nodes",
+		api.NewHandleFunc(
+			handlers.GetNodesHealth(s.hub.Peers, s.hub.Heartbeat),
+		),
+	).Methods("GET")
+	httpRouter.HandleFunc("/admin/annotations",
+		api.NewHandleFunc(
+			handlers.GetBlockAnnotations(repository.GetBlockAnnotations(s.db)),
+		),
+	).Methods("GET")
+	httpRouter.HandleFunc("/admin/mesh",
+		api.NewHandleFunc(
+			handlers.GetMesh(s.book.Report),
+		),
+	).Methods("GET")
+	httpRouter.HandleFunc("/admin/compaction",
+		api.NewHandleFunc(
+			handlers.GetCompaction(repository.EstimateCompaction(s.db), repository.GetLastCompaction(s.db), d.compactor.Running),
+		),
+	).Methods("GET")
+	httpRouter.HandleFunc("/admin/compaction",
+		api.NewHandleFunc(
+			handlers.Compact(d.compactor.Start, repository.RecordAudit(s.db)),
+		),
+	).Methods("POST")
+	httpRouter.HandleFunc("/admin/mempool",
+		api.NewHandleFunc(
+			handlers.GetMempool(s.store.GetTransactions),
+		),
+	).Methods("GET")
+	if d.credentials {
+		httpRouter.HandleFunc("/admin/credentials",
+			api.NewHandleFunc(
+				handlers.IssueCredential(alfa.CredentialIssuer(access.Issue(repository.SaveAdminCredential(s.db)), repository.RecordAudit(s.db))),
+			),
+		).Methods("POST")
+		httpRouter.HandleFunc("/admin/credentials",
+			api.NewHandleFunc(
+				handlers.GetCredentials(repository.GetAdminCredentials(s.db)),
+			),
+		).Methods("GET")
+		httpRouter.HandleFunc("/admin/credentials/{id}/roles",
+			api.NewHandleFunc(
+				handlers.AssignRoles(alfa.RoleAssigner(access.Assign(repository.GetAdminCredential(s.db), repository.GetAdminCredentials(s.db), repository.SaveAdminCredential(s.db)), repository.RecordAudit(s.db))),
+			),
+		).Methods("PUT")
+		httpRouter.HandleFunc("/admin/credentials/{id}",
+			api.NewHandleFunc(
+				handlers.RevokeCredential(alfa.CredentialRevoker(access.Revoke(repository.GetAdminCredential(s.db), repository.GetAdminCredentials(s.db), repository.SaveAdminCredential(s.db)), repository.RecordAudit(s.db))),
+			),
+		).Methods("DELETE")
+	}
+	httpRouter.Handle("/admin/export", export.CSVHandler(repository.Export(s.db, export.DefaultChunk))).Methods("GET")
+	httpRouter.Handle("/admin/snapshot", export.SnapshotHandler(repository.StreamSnapshot(s.db))).Methods("GET")
+	httpRouter.Handle("/events", events.Handler(s.feed)).Methods("GET")
+	httpRouter.Handle("/metrics", metrics.Handler()).Methods("GET")
 	serverMux := http.NewServeMux()
 	serverMux.Handle("/", httpRouter)
-	http.ListenAndServe(":8000", serverMux)
+	return serverMux
 }
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nebser/crypto-vote/internal/pkg/emergency"
+	"github.com/nebser/crypto-vote/internal/pkg/keyfiles"
+	"github.com/nebser/crypto-vote/internal/pkg/preflight"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+// alfaPreflight checks that the elections can start with their options and
+// returns the exit code, 1 if any check failed. Names of the checks of a
+// tenant's election start with the id of the tenant.
+func alfaPreflight(l listeners, elections map[string]options, peers string, o preflight.Options) int {
+	checks := []preflight.Check{
+		preflight.Bind("api", l.api),
+		preflight.Bind("socket", l.socket),
+		tlsCheck(l),
+	}
+	ids := make([]string, 0, len(elections))
+	for id := range elections {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		checks = append(checks, electionChecks(id, elections[id], o)...)
+	}
+	for _, peer := range strings.Split(peers, ",") {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			checks = append(checks,
+				preflight.Reachable("peer", peer),
+				preflight.Clock("peer clock", peer, o.ClockTolerance),
+			)
+		}
+	}
+	if peers == "" {
+		checks = append(checks, preflight.Skipped("peers", "no peers given"))
+	}
+	report := preflight.Run(checks)
+	if err := report.Print(os.Stdout, o.JSON); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print report %s\n", err)
+	}
+	if !report.Passed {
+		return 1
+	}
+	return 0
+}
+
+func tlsCheck(l listeners) preflight.Check {
+	if l.tlsCert == "" {
+		return preflight.Skipped("tls", "websocket server serves plain ws")
+	}
+	return preflight.Check{
+		Name: "tls",
+		Run: func() (string, error) {
+			if _, err := tls.LoadX509KeyPair(l.tlsCert, l.tlsKey); err != nil {
+				return "", errors.Wrapf(err, "Certificate %s can't be loaded", l.tlsCert)
+			}
+			return fmt.Sprintf("%s loads", l.tlsCert), nil
+		},
+	}
+}
+
+func electionChecks(id string, o options, po preflight.Options) []preflight.Check {
+	name := func(check string) string {
+		if id == "" {
+			return check
+		}
+		return id + " " + check
+	}
+	passphrase := keyfiles.Unlock(o.passphraseEnv)
+	checks := []preflight.Check{
+		{
+			Name: name("keys"),
+			Run: func() (string, error) {
+				if _, _, err := setUpChainSigners(o.signerSocket, o.signerSecret, o.publicKey, o.privateKey, passphrase); err != nil {
+					return "", errors.Wrap(err, "Chain key can't be loaded")
+				}
+				clients, err := importKeys(o.clientKeysDir, passphrase)
+				if err != nil {
+					return "", err
+				}
+				nodes, err := importKeys(o.nodeKeysDir, passphrase)
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("chain key, %d client keys and %d node keys load", clients, nodes), nil
+			},
+		},
+	}
+	if o.trusteesDir == "" {
+		checks = append(checks, preflight.Skipped(name("trustees"), "no trustees directory given"))
+	} else {
+		checks = append(checks, preflight.Check{
+			Name: name("trustees"),
+			Run: func() (string, error) {
+				trustees, err := emergency.ReadTrustees(o.trusteesDir, o.trusteeQuorum)
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("%d trustee keys load from %s", len(trustees.Keys), o.trusteesDir), nil
+			},
+		})
+	}
+	if o.storage != "bolt" {
+		return append(checks, preflight.Skipped(name("database"), fmt.Sprintf("election is kept by the %s storage backend", o.storage)))
+	}
+	database := preflight.Database(o.dbFile, o.new)
+	database.Name = name(database.Name)
+	disk := preflight.Disk(filepath.Dir(o.dbFile), po.Votes, po.VoteBytes)
+	disk.Name = name(disk.Name)
+	return append(checks, database, disk)
+}
+
+func importKeys(dir string, passphrase keyfiles.PassphraseFn) (int, error) {
+	files, err := getKeyFiles(dir, passphrase)
+	if err != nil {
+		return 0, err
+	}
+	wallets, err := wallet.ImportMultiple(files)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Failed to import keys of %s", dir)
+	}
+	return len(wallets), nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/apps/alfa"
+	"github.com/nebser/crypto-vote/internal/pkg/finalization"
+	"github.com/nebser/crypto-vote/internal/pkg/keyfiles"
+	"github.com/nebser/crypto-vote/internal/pkg/repository"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+)
+
+func main() {
+	dbFileName := flag.String("db", "db", "Database file of the alfa node")
+	privateKey := flag.String("private", "alfa/key.pem", "Private key file path of the alfa node signing the bundle")
+	publicKey := flag.String("public", "alfa/key_pub.pem", "Public key file path of the alfa node signing the bundle")
+	outDir := flag.String("out", "certification", "Directory in which to write the certification bundle")
+	finalizeOption := flag.Bool("finalize", false, "Should finalize the election at the current tip if it isn't finalized yet")
+	flag.Parse()
+
+	if _, err := os.Stat(*dbFileName); err != nil {
+		log.Fatalf("Failed to read stat for file %s", *dbFileName)
+	}
+	db, err := bolt.Open(*dbFileName, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		log.Fatalf("Failed to open database %s, make sure the alfa node is stopped. Error: %s", *dbFileName, err)
+	}
+	defer db.Close()
+	signer, err := wallet.Import(keyfiles.KeyFiles{
+		PrivateKeyFile: *privateKey,
+		PublicKeyFile:  *publicKey,
+	})
+	if err != nil {
+		log.Fatalf("Failed to import alfa wallet %s", err)
+	}
+
+	f, err := repository.GetFinalization(db)()
+	if err != nil {
+		log.Fatalf("Failed to retrieve finalization %s", err)
+	}
+	if f == nil {
+		if !*finalizeOption {
+			log.Fatalf("%s, run with -finalize to finalize it at the current tip", finalization.ErrNotFinalized)
+		}
+		f, err = alfa.Finalize(
+			repository.GetTip(db),
+			repository.GetBlock(db),
+			repository.SaveFinalization(db),
+			repository.RecordAudit(db),
+		)
+		if err != nil {
+			log.Fatalf("Failed to finalize election %s", err)
+		}
+		log.Printf("Election finalized at tip %x", f.TipHash)
+	}
+
+	bundle, err := alfa.Certify(
+		*outDir,
+		*f,
+		repository.GetBlock(db),
+		repository.GetParties(db),
+		repository.GetUTXOsByPublicKey(db),
+		repository.GetAuditLog(db),
+		repository.GetCosignatures(db),
+		wallet.NewSigner(*signer),
+		signer.PublicKey,
+	)
+	if err != nil {
+		log.Fatalf("Failed to certify election %s", err)
+	}
+	fmt.Print(bundle.Summary())
+	log.Printf("Certification bundle written to %s", *outDir)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/diff"
+	"github.com/nebser/crypto-vote/internal/pkg/storage"
+)
+
+func open(fileName string) storage.Repository {
+	if _, err := os.Stat(fileName); err != nil {
+		log.Fatalf("Failed to read stat for file %s", fileName)
+	}
+	db, err := bolt.Open(fileName, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		log.Fatalf("Failed to open database %s, make sure the node is stopped or diff a copy. Error: %s", fileName, err)
+	}
+	return storage.NewBolt(db)
+}
+
+func side(fileName string, store storage.Repository) diff.Side {
+	return diff.Side{
+		Name:            fileName,
+		GetTip:          store.GetTip,
+		GetBlock:        store.GetBlock,
+		GetUTXOs:        store.GetUTXOs,
+		GetTransactions: store.GetTransactions,
+	}
+}
+
+func main() {
+	a := flag.String("a", "db", "Database file of the first node, e.g. the alfa node")
+	b := flag.String("b", "db_1", "Database file of the second node")
+	limit := flag.Int("limit", 20, "Number of differing utxos and missing transactions listed of every kind")
+	flag.Parse()
+
+	dbA := open(*a)
+	defer dbA.Close()
+	dbB := open(*b)
+	defer dbB.Close()
+	report, err := diff.Compare(side(*a, dbA), side(*b, dbB))
+	if err != nil {
+		log.Fatalf("Failed to compare databases %s", err)
+	}
+	fmt.Print(report.Format(*limit))
+	if !report.Identical() {
+		os.Exit(1)
+	}
+}
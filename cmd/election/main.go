@@ -14,8 +14,11 @@ import (
 	"sync"
 	"time"
 
+	"github.com/nebser/crypto-vote/internal/pkg/ballot"
 	"github.com/nebser/crypto-vote/internal/pkg/keyfiles"
 	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/tenant"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
 	"github.com/nebser/crypto-vote/internal/pkg/wallet"
 	"github.com/pkg/errors"
 )
@@ -27,19 +30,6 @@ type body struct {
 	Signature string `json:"signature"`
 }
 
-func (b body) Signable() ([]byte, error) {
-	data := struct {
-		Sender    string `json:"sender"`
-		Recipient string `json:"recipient"`
-		Value     int    `json:"value"`
-	}{
-		Sender:    b.Sender,
-		Recipient: b.Recipient,
-		Value:     10,
-	}
-	return json.Marshal(data)
-}
-
 func getKeyFiles(keyDirectory string) (keyfiles.KeyFilesList, error) {
 	files, err := ioutil.ReadDir(keyDirectory)
 	if err != nil {
@@ -68,19 +58,18 @@ func getKeyFiles(keyDirectory string) (keyfiles.KeyFilesList, error) {
 	return result, nil
 }
 
-func process(wallets wallet.Wallets, parties party.Parties, wg *sync.WaitGroup) error {
+func process(alfaURL string, wallets wallet.Wallets, parties party.Parties, wg *sync.WaitGroup) error {
 	defer wg.Done()
-	url := "http://localhost:8000/vote"
+	url := alfaURL + "/vote"
 
 	for _, w := range wallets {
 		elected := parties[rand.Intn(len(parties))]
-		electedPKey := wallet.ExtractPublicKeyHash(elected.Address)
 		body := body{
-			Sender:    base64.StdEncoding.EncodeToString(w.PublicKeyHash()),
-			Recipient: base64.StdEncoding.EncodeToString(electedPKey),
+			Sender:    w.Address,
+			Recipient: elected.Address,
 			Verifier:  base64.StdEncoding.EncodeToString(w.PublicKey),
 		}
-		signature, err := wallet.Sign(body, w.PrivateKey)
+		signature, err := wallet.Sign(transaction.NewVoteSignable(w.PublicKeyHash(), wallet.ExtractPublicKeyHash(elected.Address)), w.PrivateKey)
 		if err != nil {
 			return errors.Wrapf(err, "Failed to sign request for %#v", body)
 		}
@@ -100,8 +89,51 @@ func process(wallets wallet.Wallets, parties party.Parties, wg *sync.WaitGroup)
 	return nil
 }
 
-func listParties() (party.Parties, error) {
-	response, err := http.Get("http://localhost:8000/parties")
+// processBallots answers every question with a random choice.
+func processBallots(alfaURL string, wallets wallet.Wallets, tally ballot.Tally, wg *sync.WaitGroup) error {
+	defer wg.Done()
+	url := alfaURL + "/ballot"
+
+	for _, w := range wallets {
+		var recipients [][]byte
+		var names []string
+		for _, q := range tally.Questions {
+			elected := q.Choices[rand.Intn(len(q.Choices))]
+			recipients = append(recipients, wallet.ExtractPublicKeyHash(elected.Address))
+			names = append(names, elected.Name)
+		}
+		body, err := ballot.NewBody(w, recipients, tally.Value)
+		if err != nil {
+			return err
+		}
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to marshal body %#v", body)
+		}
+		if _, err := http.Post(url, "application/json", bytes.NewReader(raw)); err != nil {
+			return errors.Wrap(err, "Failed to cast ballot")
+		}
+		log.Printf("Voting for %s\n", strings.Join(names, ", "))
+		time.Sleep(2 * time.Second)
+	}
+	return nil
+}
+
+func getTally(alfaURL string) (*ballot.Tally, error) {
+	response, err := http.Get(alfaURL + "/tally")
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to retrieve tally")
+	}
+	defer response.Body.Close()
+	var tally ballot.Tally
+	if err := json.NewDecoder(response.Body).Decode(&tally); err != nil {
+		return nil, errors.Wrap(err, "Failed to unmarshal tally")
+	}
+	return &tally, nil
+}
+
+func listParties(alfaURL string) (party.Parties, error) {
+	response, err := http.Get(alfaURL + "/parties")
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to retrieve parties")
 	}
@@ -116,7 +148,12 @@ func listParties() (party.Parties, error) {
 
 func main() {
 	clientKeysDir := flag.String("clients", "clients", "Client key pair files directory")
+	tenantID := flag.String("tenant", "", "ID of the tenant whose election to vote in on a multi-tenant alfa node [alfa node hosts a single election if empty]")
 	flag.Parse()
+	alfaURL := "http://localhost:8000"
+	if *tenantID != "" {
+		alfaURL += tenant.Prefix(*tenantID)
+	}
 	files, err := getKeyFiles(*clientKeysDir)
 	if err != nil {
 		log.Fatalf("Failed to import keys %s", err)
@@ -125,14 +162,22 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to import wallets %s", err)
 	}
-	parties, err := listParties()
+	parties, err := listParties(alfaURL)
 	if err != nil {
 		log.Fatalf("Failed to list parties %s", err)
 	}
+	tally, err := getTally(alfaURL)
+	if err != nil {
+		log.Fatalf("Failed to retrieve ballot %s", err)
+	}
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 	go func() {
-		if err := process(wallets, parties, &wg); err != nil {
+		run := func() error { return process(alfaURL, wallets, parties, &wg) }
+		if len(tally.Questions) > 1 {
+			run = func() error { return processBallots(alfaURL, wallets, *tally, &wg) }
+		}
+		if err := run(); err != nil {
 			log.Printf("Error occurred %s", err)
 		}
 	}()
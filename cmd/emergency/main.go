@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/emergency"
+	"github.com/nebser/crypto-vote/internal/pkg/keyfiles"
+	"github.com/nebser/crypto-vote/internal/pkg/tenant"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+)
+
+func getElection(alfaURL string) ([]byte, error) {
+	response, err := http.Get(alfaURL + "/emergency")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	var state emergency.State
+	if err := json.NewDecoder(response.Body).Decode(&state); err != nil {
+		return nil, err
+	}
+	return state.Election, nil
+}
+
+func submit(alfaURL string, tokenFile string, e transaction.Emergency) {
+	raw, err := json.Marshal(e)
+	if err != nil {
+		log.Fatalf("Failed to serialize emergency %s", err)
+	}
+	request, err := http.NewRequest(http.MethodPost, alfaURL+"/emergency", bytes.NewReader(raw))
+	if err != nil {
+		log.Fatalf("Failed to create request %s", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if tokenFile != "" {
+		token, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			log.Fatalf("Failed to read admin token %s", err)
+		}
+		request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		log.Fatalf("Failed to submit emergency %s", err)
+	}
+	defer response.Body.Close()
+	result, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		log.Fatalf("Failed to read response %s", err)
+	}
+	log.Printf("Received response %d %s", response.StatusCode, result)
+}
+
+func main() {
+	privateKey := flag.String("private", "", "Private key file path of the trustee signing the statement [required unless submitting]")
+	publicKey := flag.String("public", "", "Public key file path of the trustee signing the statement [required unless submitting]")
+	file := flag.String("file", "emergency.json", "File with the statement and the signatures collected so far, created by the first trustee")
+	action := flag.String("action", "", "Action of a new statement (pause, resume or rollback)")
+	reason := flag.String("reason", "", "Reason of a new statement")
+	checkpoint := flag.String("checkpoint", "", "Hash of the block in hex a new rollback returns to [required for a rollback]")
+	election := flag.String("election", "", "Hash of the genesis block of the election in hex [retrieved from the alfa node if empty]")
+	submitOption := flag.Bool("submit", false, "Should submit the signed statement to the alfa node instead of signing it")
+	tenantID := flag.String("tenant", "", "ID of the tenant whose election to pause, resume or roll back on a multi-tenant alfa node [alfa node hosts a single election if empty]")
+	tokenFile := flag.String("token", "", "File with the token of an admin credential with the emergency permission, sent when submitting [required if the alfa node requires admin credentials]")
+	flag.Parse()
+
+	alfaURL := "http://localhost:8000"
+	if *tenantID != "" {
+		alfaURL += tenant.Prefix(*tenantID)
+	}
+	var e transaction.Emergency
+	raw, err := ioutil.ReadFile(*file)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(raw, &e); err != nil {
+			log.Fatalf("Failed to parse statement %s %s", *file, err)
+		}
+	case os.IsNotExist(err) && !*submitOption:
+		if *action != string(transaction.Pause) && *action != string(transaction.Resume) && *action != string(transaction.Rollback) {
+			log.Fatal("Action of a new statement has to be pause, resume or rollback")
+		}
+		checkpointHash, err := hex.DecodeString(*checkpoint)
+		switch {
+		case err != nil:
+			log.Fatalf("Invalid checkpoint hash %s", err)
+		case *action == string(transaction.Rollback) && len(checkpointHash) == 0:
+			log.Fatal("Rollback needs the hash of the checkpoint block")
+		case *action != string(transaction.Rollback) && len(checkpointHash) > 0:
+			log.Fatal("Only a rollback has a checkpoint")
+		}
+		hash, err := hex.DecodeString(*election)
+		if err != nil {
+			log.Fatalf("Invalid election hash %s", err)
+		}
+		if len(hash) == 0 {
+			if hash, err = getElection(alfaURL); err != nil {
+				log.Fatalf("Failed to retrieve election hash %s", err)
+			}
+		}
+		e.Statement = transaction.Statement{
+			Action:     transaction.EmergencyAction(*action),
+			Reason:     *reason,
+			Election:   hash,
+			IssuedAt:   time.Now().Unix(),
+			Checkpoint: checkpointHash,
+		}
+	default:
+		log.Fatalf("Failed to read statement %s %s", *file, err)
+	}
+	if *submitOption {
+		submit(alfaURL, *tokenFile, e)
+		return
+	}
+
+	trustee, err := wallet.Import(keyfiles.KeyFiles{
+		PrivateKeyFile: *privateKey,
+		PublicKeyFile:  *publicKey,
+	})
+	if err != nil {
+		log.Fatalf("Failed to import trustee wallet %s", err)
+	}
+	for _, s := range e.Signatures {
+		if bytes.Equal(s.Verifier, trustee.PublicKey) {
+			log.Fatal("Trustee has already signed the statement")
+		}
+	}
+	if err := e.Sign(*trustee); err != nil {
+		log.Fatal(err)
+	}
+	raw, err = json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to serialize emergency %s", err)
+	}
+	if err := ioutil.WriteFile(*file, raw, 0600); err != nil {
+		log.Fatalf("Failed to write statement %s %s", *file, err)
+	}
+	if e.Statement.Action == transaction.Rollback {
+		log.Printf("Statement to roll back election %x to block %x signed by %d trustees", e.Statement.Election, e.Statement.Checkpoint, len(e.Signatures))
+		return
+	}
+	log.Printf("Statement to %s election %x signed by %d trustees", e.Statement.Action, e.Statement.Election, len(e.Signatures))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/inspect"
+	"github.com/nebser/crypto-vote/internal/pkg/storage"
+)
+
+func open(fileName string) storage.Repository {
+	if _, err := os.Stat(fileName); err != nil {
+		log.Fatalf("Failed to read stat for file %s", fileName)
+	}
+	db, err := bolt.Open(fileName, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		log.Fatalf("Failed to open database %s, make sure the node is stopped or inspect a copy. Error: %s", fileName, err)
+	}
+	return storage.NewBolt(db)
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] block <hash|tip> | tx <id>\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	dbFile := flag.String("db", "db", "Database file of the node whose blockchain is inspected")
+	rawFormat := flag.String("format", "text", "Output format: text, json or yaml")
+	flag.Usage = usage
+	flag.Parse()
+
+	format, err := inspect.ParseFormat(*rawFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if flag.NArg() != 2 {
+		usage()
+		os.Exit(2)
+	}
+	var id []byte
+	if flag.Arg(1) != "tip" || flag.Arg(0) != "block" {
+		if id, err = hex.DecodeString(flag.Arg(1)); err != nil || len(id) == 0 {
+			log.Fatalf("Invalid hex encoded %s %s", flag.Arg(0), flag.Arg(1))
+		}
+	}
+
+	db := open(*dbFile)
+	defer db.Close()
+	var view interface{}
+	switch flag.Arg(0) {
+	case "block":
+		view, err = inspect.InspectBlock(db.GetTip, db.GetBlock)(id)
+	case "tx":
+		view, err = inspect.InspectTransaction(db.GetTip, db.GetBlock, db.GetTransactions)(id)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("Failed to inspect %s %s. Error: %s", flag.Arg(0), flag.Arg(1), err)
+	}
+	if err := inspect.Render(os.Stdout, format, view); err != nil {
+		log.Fatalf("Failed to render %s %s. Error: %s", flag.Arg(0), flag.Arg(1), err)
+	}
+}
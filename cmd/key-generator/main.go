@@ -31,6 +31,9 @@ func main() {
 	nodesKeysDir := flag.String("nodes", "nodes", "Directory where to create node key pairs")
 	numOfClients := flag.Int("clientsNumber", 50, "Number of client key pairs to generate")
 	numOfNodes := flag.Int("nodesNumber", 5, "Number of node key pairs to generate")
+	staffKeyDir := flag.String("staff", "", "Directory where to create key pair for election staff issuing kiosk tokens [not created if empty]")
+	trusteesKeysDir := flag.String("trustees", "", "Directory where to create key pairs for trustees who can pause the election [not created if empty]")
+	numOfTrustees := flag.Int("trusteesNumber", 3, "Number of trustee key pairs to generate")
 	flag.Parse()
 
 	if err := exportMultiple(*clientKeysDir, "c", 0, *numOfClients); err != nil {
@@ -47,4 +50,19 @@ func main() {
 	if err := alfaWallet.Export(fmt.Sprintf("%s/key", *alfaKeyDir)); err != nil {
 		log.Fatal(err)
 	}
+
+	if *staffKeyDir != "" {
+		staffWallet, err := wallet.New()
+		if err != nil {
+			log.Fatalf("Failed to create wallet for election staff. Error %s", err)
+		}
+		if err := staffWallet.Export(fmt.Sprintf("%s/key", *staffKeyDir)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *trusteesKeysDir != "" {
+		if err := exportMultiple(*trusteesKeysDir, "t", 1, *numOfTrustees); err != nil {
+			log.Fatalf("Failed to generate keys for trustees %s", err)
+		}
+	}
 }
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/keyfiles"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+const usage = `Usage: keytool <command> [options]
+
+Commands:
+  generate  generate a key pair
+  address   print the address of a key pair
+  rotate    replace a key pair with a new one, keeping the old one aside
+  encrypt   encrypt the private key of a key pair with a passphrase
+  bulk      generate the key pairs of the alfa node, clients and nodes
+
+Run keytool <command> -h for the options of a command.
+`
+
+// newPassphrase returns the passphrase new private keys are encrypted with,
+// a prompted one has to be typed twice.
+func newPassphrase(env string) (string, error) {
+	if passphrase := os.Getenv(env); passphrase != "" {
+		return passphrase, nil
+	}
+	prompt := keyfiles.Prompt()
+	passphrase, err := prompt("the new keys")
+	if err != nil {
+		return "", err
+	}
+	confirmation, err := prompt("the new keys again")
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case passphrase == "":
+		return "", errors.New("Passphrase is empty")
+	case passphrase != confirmation:
+		return "", errors.New("Passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+func prefixOf(privateKeyFile string) string {
+	return strings.TrimSuffix(privateKeyFile, ".pem")
+}
+
+// export refuses to overwrite key files, so keys in use are never lost.
+func export(w wallet.Wallet, prefix, passphrase string) error {
+	for _, f := range []string{prefix + ".pem", prefix + "_pub.pem", prefix + "_address.txt"} {
+		if _, err := os.Stat(f); err == nil {
+			return errors.Errorf("Key file %s already exists", f)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(prefix), 0700); err != nil {
+		return errors.Wrapf(err, "Failed to create directory of %s", prefix)
+	}
+	if passphrase == "" {
+		return w.Export(prefix)
+	}
+	return w.ExportEncrypted(prefix, passphrase)
+}
+
+func generate(args []string) error {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	out := fs.String("out", "key", "Path of the key pair without the extension, e.g. nodes/n6")
+	encrypt := fs.Bool("encrypt", false, "Should encrypt the private key with a passphrase")
+	passphraseEnv := fs.String("passphraseEnv", "KEYTOOL_PASSPHRASE", "Environment variable with the passphrase [prompted for if not set]")
+	fs.Parse(args)
+
+	var passphrase string
+	if *encrypt {
+		var err error
+		if passphrase, err = newPassphrase(*passphraseEnv); err != nil {
+			return err
+		}
+	}
+	w, err := wallet.New()
+	if err != nil {
+		return err
+	}
+	if err := export(*w, *out, passphrase); err != nil {
+		return err
+	}
+	fmt.Println(w.Address)
+	return nil
+}
+
+func address(args []string) error {
+	fs := flag.NewFlagSet("address", flag.ExitOnError)
+	public := fs.String("public", "key_pub.pem", "Public key file of the key pair")
+	fs.Parse(args)
+
+	w, err := wallet.ImportPublic(*public)
+	if err != nil {
+		return err
+	}
+	fmt.Println(w.Address)
+	return nil
+}
+
+// rotate moves the old key pair into the rotated directory next to the
+// directory of the key pair, where the alfa node doesn't look for keys.
+func rotate(args []string) error {
+	fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+	private := fs.String("private", "", "Private key file of the key pair to rotate, e.g. nodes/n1.pem [required]")
+	encrypt := fs.Bool("encrypt", false, "Should encrypt the new private key with a passphrase")
+	passphraseEnv := fs.String("passphraseEnv", "KEYTOOL_PASSPHRASE", "Environment variable with the passphrase of the old and the new private key [prompted for if not set]")
+	fs.Parse(args)
+
+	if *private == "" {
+		return errors.New("Private key file is required")
+	}
+	prefix := prefixOf(*private)
+	old, err := wallet.Import(keyfiles.KeyFiles{
+		PrivateKeyFile: *private,
+		PublicKeyFile:  prefix + "_pub.pem",
+		Passphrase:     keyfiles.Unlock(*passphraseEnv),
+	})
+	if err != nil {
+		return errors.Wrap(err, "Failed to import the key pair to rotate")
+	}
+	var passphrase string
+	if *encrypt {
+		if passphrase, err = newPassphrase(*passphraseEnv); err != nil {
+			return err
+		}
+	}
+	w, err := wallet.New()
+	if err != nil {
+		return err
+	}
+	rotated := filepath.Clean(filepath.Dir(prefix)) + ".rotated"
+	if err := os.MkdirAll(rotated, 0700); err != nil {
+		return errors.Wrapf(err, "Failed to create directory %s", rotated)
+	}
+	backup := filepath.Join(rotated, fmt.Sprintf("%s-%d", filepath.Base(prefix), time.Now().Unix()))
+	for _, suffix := range []string{".pem", "_pub.pem", "_address.txt"} {
+		if err := os.Rename(prefix+suffix, backup+suffix); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "Failed to move %s aside", prefix+suffix)
+		}
+	}
+	if err := export(*w, prefix, passphrase); err != nil {
+		return err
+	}
+	log.Printf("Key pair %s rotated from %s to %s, the old one is kept at %s", prefix, old.Address, w.Address, backup) // redact:public
+	return nil
+}
+
+func encryptKey(args []string) error {
+	fs := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	private := fs.String("private", "", "Private key file to encrypt in place [required]")
+	passphraseEnv := fs.String("passphraseEnv", "KEYTOOL_PASSPHRASE", "Environment variable with the passphrase [prompted for if not set]")
+	fs.Parse(args)
+
+	if *private == "" {
+		return errors.New("Private key file is required")
+	}
+	w, err := wallet.Import(keyfiles.KeyFiles{
+		PrivateKeyFile: *private,
+		PublicKeyFile:  prefixOf(*private) + "_pub.pem",
+	})
+	if err != nil {
+		return err
+	}
+	passphrase, err := newPassphrase(*passphraseEnv)
+	if err != nil {
+		return err
+	}
+	encrypted, err := wallet.EncryptPrivateKey(w.PrivateKey, passphrase)
+	if err != nil {
+		return err
+	}
+	tmp := *private + ".tmp"
+	if err := ioutil.WriteFile(tmp, encrypted, 0600); err != nil {
+		return errors.Wrapf(err, "Failed to write %s", tmp)
+	}
+	if err := os.Rename(tmp, *private); err != nil {
+		return errors.Wrapf(err, "Failed to replace %s", *private)
+	}
+	log.Printf("Private key of %s encrypted", w.Address) // redact:public
+	return nil
+}
+
+func exportMultiple(directory, base string, start, num int, passphrase string) error {
+	for i := 0; i < num; i++ {
+		w, err := wallet.New()
+		if err != nil {
+			return err
+		}
+		if err := export(*w, fmt.Sprintf("%s/%s%d", directory, base, start+i), passphrase); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func bulk(args []string) error {
+	fs := flag.NewFlagSet("bulk", flag.ExitOnError)
+	alfaKeyDir := fs.String("alfa", "alfa", "Directory where to create the key pair of the alfa node [not created if empty]")
+	clientKeysDir := fs.String("clients", "clients", "Directory where to create client key pairs")
+	nodesKeysDir := fs.String("nodes", "nodes", "Directory where to create node key pairs")
+	numOfClients := fs.Int("clientsNumber", 50, "Number of client key pairs to generate")
+	numOfNodes := fs.Int("nodesNumber", 5, "Number of node key pairs to generate")
+	encrypt := fs.Bool("encrypt", false, "Should encrypt the private keys with a passphrase")
+	passphraseEnv := fs.String("passphraseEnv", "KEYTOOL_PASSPHRASE", "Environment variable with the passphrase [prompted for if not set]")
+	fs.Parse(args)
+
+	var passphrase string
+	if *encrypt {
+		var err error
+		if passphrase, err = newPassphrase(*passphraseEnv); err != nil {
+			return err
+		}
+	}
+	if err := exportMultiple(*clientKeysDir, "c", 0, *numOfClients, passphrase); err != nil {
+		return errors.Wrap(err, "Failed to generate keys for clients")
+	}
+	if err := exportMultiple(*nodesKeysDir, "n", 1, *numOfNodes, passphrase); err != nil {
+		return errors.Wrap(err, "Failed to generate keys for nodes")
+	}
+	if *alfaKeyDir == "" {
+		return nil
+	}
+	alfaWallet, err := wallet.New()
+	if err != nil {
+		return err
+	}
+	return export(*alfaWallet, filepath.Join(*alfaKeyDir, "key"), passphrase)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	commands := map[string]func([]string) error{
+		"generate": generate,
+		"address":  address,
+		"rotate":   rotate,
+		"encrypt":  encryptKey,
+		"bulk":     bulk,
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err := command(os.Args[2:]); err != nil {
+		log.Fatalf("Failed to %s %s", os.Args[1], err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/keyfiles"
+	"github.com/nebser/crypto-vote/internal/pkg/kiosk"
+	"github.com/nebser/crypto-vote/internal/pkg/redact"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+)
+
+func main() {
+	privateKey := flag.String("private", "staff/key.pem", "Private key file path of the election staff issuing the tokens")
+	publicKey := flag.String("public", "staff/key_pub.pem", "Public key file path of the election staff issuing the tokens")
+	clientKeysDir := flag.String("clients", "clients", "Directory of the voters public keys to issue tokens for")
+	validity := flag.Duration("validity", 24*time.Hour, "How long the issued tokens are valid")
+	submitURL := flag.String("url", "http://localhost:8000/kiosk/vote", "Kiosk vote submission URL the tokens are appended to")
+	flag.Parse()
+
+	issuer, err := wallet.Import(keyfiles.KeyFiles{
+		PrivateKeyFile: *privateKey,
+		PublicKeyFile:  *publicKey,
+	})
+	if err != nil {
+		log.Fatalf("Failed to import issuer wallet %s", err)
+	}
+	base, err := url.Parse(*submitURL)
+	if err != nil {
+		log.Fatalf("Invalid submission url %s", err)
+	}
+	files, err := ioutil.ReadDir(*clientKeysDir)
+	if err != nil {
+		log.Fatalf("Failed to read client key files directory %s", err)
+	}
+	expires := time.Now().Add(*validity)
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), "_pub.pem") {
+			continue
+		}
+		pkey, err := wallet.LoadPublicKey(fmt.Sprintf("%s/%s", *clientKeysDir, f.Name()))
+		if err != nil {
+			log.Fatalf("Failed to load public key %s %s", f.Name(), err)
+		}
+		address, err := wallet.ExtractAddress(pkey)
+		if err != nil {
+			log.Fatalf("Failed to extract address of %s %s", f.Name(), err)
+		}
+		token, err := kiosk.NewToken(wallet.ExtractPublicKeyHash(address), expires, *issuer)
+		if err != nil {
+			log.Fatalf("Failed to issue token for %s %s", redact.Address(address), err)
+		}
+		encoded, err := token.Encode()
+		if err != nil {
+			log.Fatalf("Failed to encode token for %s %s", redact.Address(address), err)
+		}
+		u := *base
+		query := u.Query()
+		query.Set("token", encoded)
+		u.RawQuery = query.Encode()
+		fmt.Printf("%s\t%s\n", address, u.String())
+	}
+}
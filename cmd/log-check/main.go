@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/nebser/crypto-vote/internal/pkg/redact"
+)
+
+func main() {
+	root := flag.String("root", ".", "Root directory of the sources to check")
+	flag.Parse()
+
+	findings, err := redact.Check(*root)
+	if err != nil {
+		log.Fatalf("Failed to check sources %s", err)
+	}
+	for _, f := range findings {
+		fmt.Println(f)
+	}
+	if len(findings) > 0 {
+		fmt.Printf("%d log calls leak sensitive values, wrap them with redact or mark public ones with // %s\n", len(findings), redact.Allow)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/repository"
+)
+
+func main() {
+	dbFileName := flag.String("db", "db", "Database file to migrate")
+	batchSize := flag.Int("batch", 500, "Number of records migrated in a single database transaction")
+	flag.Parse()
+
+	if _, err := os.Stat(*dbFileName); err != nil {
+		log.Fatalf("Failed to read stat for file %s", *dbFileName)
+	}
+	db, err := bolt.Open(*dbFileName, 0600, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	progress := func(bucket string, migrated, total int) {
+		log.Printf("Bucket %s: %d/%d records migrated", bucket, migrated, total)
+	}
+	if err := repository.MigrateToBinary(db, *batchSize, progress); err != nil {
+		log.Fatalf("Migration interrupted, run it again to resume. Error: %s", err)
+	}
+	if err := repository.MigrateUTXOs(db, *batchSize, progress); err != nil {
+		log.Fatalf("Migration interrupted, run it again to resume. Error: %s", err)
+	}
+	log.Println("Migration finished")
+}
@@ -1,57 +1,167 @@
 package main
 
 import (
-	"encoding/base64"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/nebser/crypto-vote/internal/apps/node"
 	"github.com/nebser/crypto-vote/internal/apps/node/handlers"
 	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/emergency"
+	"github.com/nebser/crypto-vote/internal/pkg/export"
+	"github.com/nebser/crypto-vote/internal/pkg/fraud"
+	"github.com/nebser/crypto-vote/internal/pkg/hooks"
+	"github.com/nebser/crypto-vote/internal/pkg/limits"
+	"github.com/nebser/crypto-vote/internal/pkg/mempool"
+	"github.com/nebser/crypto-vote/internal/pkg/mesh"
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
+	"github.com/nebser/crypto-vote/internal/pkg/mixer"
+	"github.com/nebser/crypto-vote/internal/pkg/redact"
+	"github.com/nebser/crypto-vote/internal/pkg/replay"
 	"github.com/nebser/crypto-vote/internal/pkg/repository"
+	"github.com/nebser/crypto-vote/internal/pkg/rules"
+	"github.com/nebser/crypto-vote/internal/pkg/sortition"
+	"github.com/nebser/crypto-vote/internal/pkg/storage"
+	"github.com/nebser/crypto-vote/internal/pkg/tenant"
 	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/transport"
 
-	"github.com/boltdb/bolt"
 	"github.com/gorilla/websocket"
 	"github.com/nebser/crypto-vote/internal/pkg/keyfiles"
 	"github.com/nebser/crypto-vote/internal/pkg/operations"
+	"github.com/nebser/crypto-vote/internal/pkg/preflight"
 	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/nebser/crypto-vote/internal/pkg/watchtower"
 	_websocket "github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/nebser/crypto-vote/internal/pkg/withdrawal"
 )
 
 func main() {
+	preflightMode := len(os.Args) > 1 && os.Args[1] == "preflight"
+	if preflightMode {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
 	nodeID := flag.Int("id", 0, "ID of the node [required]")
 	newOption := flag.Bool("new", false, "Should initialize new blockchain")
 	privateKeyOption := flag.String("private", "", "Private key file path [default is nodes/key_id.pem]")
 	publicKeyOption := flag.String("public", "", "Private key file path [default is nodes/key_id_pub.pem]")
+	blockCacheSize := flag.Int("blockCacheSize", 16<<20, "Maximum estimated size in bytes of recently accessed blocks kept in memory")
+	rangeSize := flag.Int("rangeSize", 10, "Number of blocks requested from a single peer at once during catch-up")
+	syncBatch := flag.Int("syncBatch", 100, "Number of blocks streamed from the alfa node at once during catch-up [blocks are downloaded from all peers by hash if 0]")
+	syncRetries := flag.Int("syncRetries", 5, "Number of times in a row catch-up resumes on a new connection after the connection to the alfa node drops")
+	passphraseEnv := flag.String("passphraseEnv", "NODE_PASSPHRASE", "Environment variable with the passphrase of an encrypted private key file [prompted for if not set]")
+	mixOption := flag.Bool("mix", false, "Should batch vote transactions and put them in canonical order before including them into a block")
+	mixBatch := flag.Int("mixBatch", 5, "Minimum number of vote transactions to mix into a single block")
+	mixDelay := flag.Duration("mixDelay", 2*time.Minute, "Maximum time a vote transaction waits for the mixing batch to fill up")
+	transportKeyFile := flag.String("transportKey", "", "Key file used for signing websocket messages instead of the chain key, generated if missing [chain key is used if empty]")
+	transportAlgorithm := flag.String("transportAlg", string(transport.Ed25519), "Signature algorithm of a newly generated transport key (ed25519 or p256)")
+	maxDBSize := flag.Int64("maxDbSize", 0, "Database size in bytes at which an alarm is raised [not limited if 0]")
+	maxMempoolSize := flag.Int64("maxMempoolSize", 32<<20, "Size in bytes of pending transactions above which the lowest priority ones are shed [not limited if 0]")
+	deregisterOption := flag.Bool("deregister", false, "Should deregister the node for good, returning its stake, and exit")
+	alarmRatio := flag.Float64("alarmRatio", 0.9, "Share of a resource limit at which a warning alarm is raised")
+	rulesFile := flag.String("rules", "", "File with the rules of the election, has to be the one the genesis block commits to [election has no rules if empty]")
+	trusteesDir := flag.String("trustees", "", "Directory with public keys of the trustees who can pause and resume the election, has to be the same as on the alfa node [pauses are rejected if empty]")
+	trusteeQuorum := flag.Int("trusteeQuorum", 0, "Number of trustees who have to sign a pause or a resume [majority of trustees if 0]")
+	maxConnsPerIP := flag.Int("maxConnsPerIP", 0, "Number of websocket connections accepted from a single IP address [not limited if 0]")
+	heartbeat := _websocket.Heartbeat{}
+	flag.DurationVar(&heartbeat.Interval, "heartbeatInterval", 10*time.Second, "Interval between two websocket pings sent to the alfa node and every peer [no heartbeats if 0]")
+	flag.DurationVar(&heartbeat.Timeout, "heartbeatTimeout", 30*time.Second, "How long the alfa node or a peer may stay silent before it is disconnected [never if 0]")
+	deadlines := _websocket.Deadlines{}
+	flag.DurationVar(&deadlines.Timeout, "handlerTimeout", 10*time.Second, "How long the handler of a read-only websocket message may run before the sender gets a timeout error and the handler is cancelled, messages changing state run without a deadline [read-only messages are handled in the reader without a deadline if 0]")
+	handlerTimeouts := flag.String("handlerTimeouts", _websocket.DefaultTimeouts.String(), "Timeouts of single read-only websocket messages overriding handlerTimeout as comma separated message=timeout pairs")
+	flag.IntVar(&deadlines.Workers, "handlerWorkers", 4, "Number of handlers of read-only websocket messages which may run at once for a single connection, including the ones past their deadline, further messages are refused with a busy error")
+	wireOption := flag.String("wire", string(_websocket.BinaryEncoding), "Encoding of websocket messages offered to the alfa node and peers, binary or json; JSON is used with the ones which don't support the binary encoding")
+	relayOption := flag.String("blockRelay", string(blockchain.CompactRelay), "How forged blocks are sent to the alfa node and peers, compact sends transaction ids which peers take from their pending transactions, full sends whole blocks")
+	recordFile := flag.String("record", "", "File every inbound websocket message is recorded to, so an incident can be replayed later; the database is snapshotted next to it with the .db suffix [messages are not recorded if empty]")
+	replayFile := flag.String("replay", "", "Recording to replay against the snapshot instead of joining the network [node runs normally if empty]")
+	snapshotFile := flag.String("snapshot", "", "Database snapshotted when the recording started, the recording is replayed on a copy of it [default is the recording with the .db suffix]")
+	breakHeights := flag.String("breakHeights", "", "Comma separated heights at which the replay stops for inspection")
+	breakTransactions := flag.String("breakTransactions", "", "Comma separated hex encoded ids of transactions before whose messages the replay stops for inspection")
+	metricsPull := flag.Bool("metricsPull", true, "Should serve metrics on /metrics for scraping")
+	pushGateway := flag.String("pushGateway", "", "URL of a Prometheus Pushgateway metrics are pushed to [metrics are not pushed if empty]")
+	statsdAddress := flag.String("statsd", "", "Address of a statsd server metrics are sent to over UDP [metrics are not sent if empty]")
+	metricsDump := flag.String("metricsDump", "", "File metrics are dumped to in the Prometheus text format [metrics are not dumped if empty]")
+	pushInterval := flag.Duration("pushInterval", 15*time.Second, "How often metrics are pushed, sent and dumped")
+	accountOption := flag.Bool("account", false, "Should print the balance, the locked stake and the last transaction of the node kept by the alfa node and exit")
+	logRedaction := flag.String("logRedaction", "hash", "How voter addresses, public key hashes and signatures are logged: hash, truncate, omit or off; signatures are hashed even if off")
+	storageBackend := flag.String("storage", "bolt", fmt.Sprintf("Storage backend of the blockchain (%s), memory keeps it in a temporary database removed on exit so the blockchain is downloaded on every start", strings.Join(storage.Backends(), ", ")))
+	var dbOptions repository.Options
+	flag.BoolVar(&dbOptions.NoSync, "dbNoSync", false, "Should skip syncing the database to disk after every commit, for benchmarks only as a crash may corrupt the database")
+	flag.DurationVar(&dbOptions.Timeout, "dbTimeout", 0, "How long to wait for the lock of the database file [waits indefinitely if 0]")
+	flag.StringVar(&dbOptions.MmapFlags, "dbMmapFlags", "", "Comma separated flags the database file is memory mapped with, populate is supported on Linux")
+	flag.IntVar(&dbOptions.InitialMmapSize, "dbInitialMmapSize", 0, "Initial size in bytes of the memory map of the database, readers don't block the writer until it is outgrown")
+	var mempoolOptions mempool.Options
+	flag.DurationVar(&mempoolOptions.TTL, "mempoolTTL", 0, "How long a vote may stay pending before it is dropped [votes never expire if 0]")
+	flag.IntVar(&mempoolOptions.MaxCount, "mempoolMaxCount", 0, "Number of pending transactions above which the lowest priority ones are evicted [not limited if 0]")
+	maxReorgDepth := flag.Int("maxReorgDepth", 100, "Maximum number of blocks the node rolls back to switch to a longer branch")
+	watchFile := flag.String("watch", "", "File with hex encoded ids of vote transactions, one per line, the node watches as a watchtower and alerts about when they are dropped from the blockchain or their inclusion proofs stop verifying [node doesn't watch votes if empty]")
+	watchInterval := flag.Duration("watchInterval", time.Minute, "How often the watched votes are checked against the blockchain")
+	watchWebhook := flag.String("watchWebhook", "", "URL alerts of the watchtower are posted to as JSON [alerts are not posted if empty]")
+	watchCommand := flag.String("watchCommand", "", "Command run for every alert of the watchtower with the alert as JSON on its standard input, e.g. a script sending an email [no command is run if empty]")
+	alfaURL := flag.String("alfa", "ws://localhost:10000/", "Websocket URL of the alfa node, wss if the alfa node serves TLS")
+	tenantID := flag.String("tenant", "", "ID of the tenant whose election the node takes part in on a multi-tenant alfa node, default paths of files are inside the directory named after it [alfa node hosts a single election if empty]")
+	var preflightOptions preflight.Options
+	if preflightMode {
+		preflightOptions.Register(flag.CommandLine)
+	}
 	flag.Parse()
+	redaction, err := redact.ParsePolicy(*logRedaction)
+	if err != nil {
+		log.Fatal(err)
+	}
+	redact.Install(redaction)
+	wire, err := _websocket.ParseEncoding(*wireOption)
+	if err != nil {
+		log.Fatal(err)
+	}
+	deadlines.Messages, err = _websocket.ParseTimeouts(*handlerTimeouts)
+	if err != nil {
+		log.Fatalf("Failed to parse handler timeouts %s", err)
+	}
+	relay, err := blockchain.ParseRelay(*relayOption)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if names := hooks.Registered(); len(names) > 0 {
+		log.Printf("Compiled in validation hooks %v", names)
+	}
 	if *nodeID <= 0 {
 		log.Fatal("NodeId must be provided and it must be greater than 0")
 	}
 	privateKey := *privateKeyOption
 	if privateKey == "" {
-		privateKey = fmt.Sprintf("nodes/n%d.pem", *nodeID)
+		privateKey = filepath.Join(*tenantID, fmt.Sprintf("nodes/n%d.pem", *nodeID))
 	}
 	publicKey := *publicKeyOption
 	if publicKey == "" {
-		publicKey = fmt.Sprintf("nodes/n%d_pub.pem", *nodeID)
+		publicKey = filepath.Join(*tenantID, fmt.Sprintf("nodes/n%d_pub.pem", *nodeID))
+	}
+	dbFileName := filepath.Join(*tenantID, fmt.Sprintf("db_%d", *nodeID))
+	if preflightMode {
+		os.Exit(nodePreflight(*nodeID, privateKey, publicKey, *passphraseEnv, *trusteesDir, *tenantID, *alfaURL, dbFileName, *storageBackend, preflightOptions))
 	}
-	dbFileName := fmt.Sprintf("db_%d", *nodeID)
 
-	masterWallet, err := wallet.Import(keyfiles.KeyFiles{PrivateKeyFile: privateKey, PublicKeyFile: publicKey})
+	masterWallet, err := wallet.Import(keyfiles.KeyFiles{
+		PrivateKeyFile: privateKey,
+		PublicKeyFile:  publicKey,
+		Passphrase:     keyfiles.Unlock(*passphraseEnv),
+	})
 	if err != nil {
 		log.Fatalf("Wallet could not be imported %s\n", err)
 	}
-	alfaPKey, err := wallet.LoadPublicKey("alfa/key_pub.pem")
+	alfaPKey, err := wallet.LoadPublicKey(filepath.Join(*tenantID, "alfa/key_pub.pem"))
 	if err != nil {
 		log.Fatalf("Failed to load public key %s", err)
 	}
-	encodedAlfaPkey := base64.StdEncoding.EncodeToString(alfaPKey)
 	if *newOption {
 		switch _, err := os.Stat(dbFileName); {
 		case err == nil:
@@ -67,94 +177,415 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to hash alfa public key %s", err)
 	}
-	db, err := bolt.Open(dbFileName, 0600, nil)
+	replaying := *replayFile != ""
+	if replaying {
+		snapshot := *snapshotFile
+		if snapshot == "" {
+			snapshot = *replayFile + ".db"
+		}
+		dbFileName = snapshot + ".replay"
+		if err := replay.CopySnapshot(snapshot, dbFileName); err != nil {
+			log.Fatal(err)
+		}
+		*transportKeyFile = ""
+		*storageBackend = "bolt"
+	}
+	store, err := storage.Open(*storageBackend, dbFileName, dbOptions)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
-
-	u := url.URL{
-		Scheme: "ws",
-		Host:   "localhost:10000",
-		Path:   "/",
-	}
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	defer store.Close()
+	db, err := storage.DB(store)
 	if err != nil {
-		log.Fatalf("Failed to connect to server: %s", err)
+		log.Fatal(err)
+	}
+	if err := repository.MigrateUTXOs(db, 500, func(bucket string, migrated, total int) {
+		log.Printf("Bucket %s: %d/%d records moved to one record per utxo", bucket, migrated, total)
+	}); err != nil {
+		log.Fatalf("Failed to migrate utxos %s", err)
 	}
 
-	getTip := repository.GetTip(db)
-	getBlock := repository.GetBlock(db)
-	if err := node.Initialize(
-		operations.GetHeight(conn),
-		operations.GetMissingBlocks(conn),
-		operations.GetBlock(conn),
-		getTip,
-		getBlock,
-		repository.AddBlock(db),
-	); err != nil {
-		log.Fatalf("Failed to initialize node %s", err)
+	getTip := store.GetTip
+	blocks := blockchain.NewBlockCache(store.GetBlock, *blockCacheSize)
+	getBlock := blocks.GetBlock
+	rollback := blocks.Rollback(repository.RollbackTip(db))
+	var conn *websocket.Conn
+	if !replaying {
+		u, err := url.Parse(*alfaURL)
+		if err != nil {
+			log.Fatalf("Failed to parse alfa node URL %s", err)
+		}
+		if *tenantID != "" {
+			u.Path = strings.TrimSuffix(u.Path, "/") + tenant.Prefix(*tenantID) + "/"
+		}
+		conn, _, err = websocket.DefaultDialer.Dial(u.String(), nil)
+		if err != nil {
+			log.Fatalf("Failed to connect to server: %s", err)
+		}
+		if *deregisterOption {
+			returned, err := operations.Deregister(conn, *masterWallet)()
+			if err != nil {
+				log.Fatalf("Failed to deregister %s", err)
+			}
+			log.Printf("Node deregistered, %d stakes are being returned", returned)
+			conn.Close()
+			return
+		}
+		if *accountOption {
+			account, err := operations.GetAccount(conn, *masterWallet)()
+			if err != nil {
+				log.Fatalf("Failed to retrieve account %s", err)
+			}
+			fmt.Printf("Balance %d, locked stake %d, last transaction %x at %d\n", account.Balance, account.Locked, account.LastTransaction, account.Nonce)
+			conn.Close()
+			return
+		}
+
+		addBlock := hooks.AddBlock(blocks.AddBlock(getTip, store.AddBlock))
+		closePeers := func() {}
+		var catchUp node.CatchUpFn
+		if *syncBatch > 0 {
+			catchUp = node.Stream(operations.Sync(
+				func() (*websocket.Conn, error) {
+					c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+					return c, err
+				},
+				getTip,
+				addBlock,
+				*syncBatch,
+				*syncRetries,
+			))
+		} else {
+			var peers []operations.GetBlockFn
+			peers, closePeers = dialPeers(conn)
+			catchUp = node.Download(node.ParallelDownload(peers, *rangeSize), addBlock)
+		}
+		if err := node.Initialize(
+			operations.GetHeight(conn),
+			operations.GetMissingBlocks(conn),
+			catchUp,
+			getTip,
+			getBlock,
+			rollback,
+		); err != nil {
+			log.Fatalf("Failed to initialize node %s", err)
+		}
+		closePeers()
 	}
 	blockchain.PrintBlockchain(getTip, getBlock)
-	nodes, err := operations.Register(conn, *masterWallet)(strconv.Itoa(*nodeID))
+	var electionRules *rules.Rules
+	if *rulesFile != "" {
+		electionRules, err = rules.Read(*rulesFile)
+		if err != nil {
+			log.Fatalf("Failed to load rules %s", err)
+		}
+	}
+	if err := rules.VerifyGenesis(blockchain.FindBlock(getTip, getBlock), electionRules); err != nil {
+		log.Fatal(err)
+	}
+	withdrawals, err := withdrawal.Load(blockchain.FindBlock(getTip, getBlock))
 	if err != nil {
-		log.Fatalf("Failed to register %s\n", err)
+		log.Fatalf("Failed to load withdrawals of parties %s", err)
+	}
+	validate := transaction.ValidateAll(hooks.ValidateTransaction, withdrawals.Validate(), electionRules.Validator(hashedAlfaPKey))
+	var trustees *emergency.Trustees
+	if *trusteesDir != "" {
+		trustees, err = emergency.ReadTrustees(*trusteesDir, *trusteeQuorum)
+		if err != nil {
+			log.Fatalf("Failed to load trustees %s", err)
+		}
 	}
+	brake, err := emergency.Load(blockchain.FindBlock(getTip, getBlock))
+	if err != nil {
+		log.Fatalf("Failed to load emergency state %s", err)
+	}
+	if brake.Paused() {
+		log.Printf("ALERT: election is paused by its trustees: %s", brake.State().Reason)
+	}
+	var nodes []string
+	alfaEncoding := _websocket.JSONEncoding
+	if !replaying {
+		nodes, alfaEncoding, err = operations.Register(conn, *masterWallet, wire)(strconv.Itoa(*nodeID))
+		if err != nil {
+			log.Fatalf("Failed to register %s\n", err)
+		}
+	}
+	getChainID := repository.GetChainID(db)
+	getAlgorithm := repository.GetAlgorithm(db)
 	hub := _websocket.NewHub()
+	hub.LimitPerIP(*maxConnsPerIP)
+	hub.SetChain(getChainID())
+	hub.SetHeartbeat(heartbeat)
+	hub.SetDeadlines(deadlines)
+	hub.PreferEncoding(wire)
+	go hub.Monitor()
+	findBlock := blockchain.FindBlock(getTip, getBlock)
+	findCertificate := blockchain.FindCertificate(getTip, getBlock)
 	signer := wallet.NewSigner(*masterWallet)
-	verifyTransactions := transaction.VerifyTransactions(repository.GetTransactionUTXO(db), wallet.VerifySignature)
+	transportSigner, certification := setUpTransportSigner(
+		*transportKeyFile,
+		transport.Algorithm(*transportAlgorithm),
+		getAlgorithm(),
+		*masterWallet,
+		signer,
+		findCertificate,
+		store.SaveTransaction,
+	)
+	verifyValidated := transaction.Validated(validate, transaction.VerifyStakeReturns(
+		transaction.VerifyRecoveries(
+			transaction.VerifyTransactions(store.GetTransactionUTXO, wallet.VerifySignature),
+			blockchain.FindTransaction(findBlock),
+			store.GetTransactionUTXO,
+		),
+		hashedAlfaPKey,
+		blockchain.FindTransaction(findBlock),
+	))
+	verifyTransactions := transaction.VerifyChain(getChainID, emergency.VerifyTransactions(brake, trustees, verifyValidated))
+	verifyBlock := sortition.VerifyBlock(getBlock, hooks.VerifyBlock(blockchain.VerfiyBlock(verifyTransactions, transaction.IsStakeTransaction(hashedAlfaPKey))))
+	orderTransactions := transaction.OrderTransactionsFn(transaction.KeepOrder)
+	isBatchReady := mixer.IsBatchReady(0, 0)
+	if *mixOption {
+		verifyBlock = mixer.VerifyMixedBlock(verifyBlock)
+		orderTransactions = mixer.Shuffle
+		isBatchReady = mixer.IsBatchReady(*mixBatch, *mixDelay)
+	}
+	isReturnStake := transaction.IsReturnStakeTransaction(hashedAlfaPKey)
+	essential := func(t transaction.Transaction) bool {
+		return t.IsCertification() || isReturnStake(t)
+	}
+	// Pending transactions are revalidated regardless of the emergency
+	// state, a paused election doesn't make them invalid.
+	restoration, err := mempool.Restore(
+		store.GetTransactions,
+		transaction.VerifyChain(getChainID, verifyValidated),
+		nil,
+		essential,
+		repository.DeleteTransaction(db),
+	)
+	if err != nil {
+		log.Fatalf("Failed to restore pending transactions %s", err)
+	}
+	if restoration.Restored+restoration.Discarded > 0 {
+		log.Printf("Restored %d pending transactions, discarded %d which can't be forged anymore", restoration.Restored, restoration.Discarded)
+	}
+	pool, err := mempool.Load(store.GetTransactions, mempoolOptions, essential)
+	if err != nil {
+		log.Fatalf("Failed to load mempool %s", err)
+	}
+	isReturnStakeBlock := blockchain.IsReturnStakeBlock(verifyTransactions, hashedAlfaPKey)
+	forks := blockchain.NewForkChoice(
+		getTip,
+		getBlock,
+		rollback,
+		func(b blockchain.Block, sender []byte) bool {
+			return isReturnStakeBlock(b, sender) || verifyBlock(b, sender)
+		},
+		pool.AddNewBlock(withdrawals.AddNewBlock(brake.AddNewBlock(hooks.AddNewBlock(blocks.AddNewBlock(getTip, store.AddNewBlock))))),
+		brake.Rewinds(trustees),
+		repository.DiscardRolledBack(db),
+		*maxReorgDepth,
+	)
+	monitor := limits.NewMonitor(*alarmRatio)
+	reportFraud := node.FraudRecorder(fraud.Verify(findCertificate), repository.SaveFraudProof(db))
+	shedOrder := node.ShedOrder(isReturnStake)
+	saveTransaction := node.LimitMempool(
+		transaction.SaveValidated(validate, pool.Save(store.SaveTransaction, repository.DeleteTransaction(db))),
+		repository.GetMempoolSize(db),
+		repository.ShedTransactions(db),
+		shedOrder,
+		*maxMempoolSize,
+		monitor,
+	)
+	reconstructor := blockchain.NewReconstructor(store.GetTransactions)
+	blockForged := handlers.BlockForged(
+		getTip,
+		getBlock,
+		findCertificate,
+		forks.Add,
+		fraud.NewWitness().Observe,
+		reportFraud,
+		hub.Broadcast,
+		reconstructor.Expand,
+	)
+	book := mesh.NewBook(strconv.Itoa(*nodeID), fmt.Sprintf("localhost:%d", 10000+*nodeID))
 	router := _websocket.Router{
+		_websocket.GetBlockMessage: handlers.GetBlock(getBlock),
 		_websocket.RegisterMessage: handlers.Register(hub).
 			Authorized(
-				blockchain.BlockchainAuthorizer(
-					blockchain.FindBlock(
-						repository.GetTip(db),
-						repository.GetBlock(db),
-					),
-				),
+				blockchain.BlockchainAuthorizer(findBlock, findCertificate),
 			),
-		_websocket.TransactionReceivedMessage: handlers.SaveTransaction(
-			repository.SaveTransaction(db),
-			wallet.VerifySignature,
-		),
-		_websocket.ForgeBlockMessage: handlers.ForgeBlock(
-			repository.GetTip(db),
-			repository.GetBlock(db),
-			repository.ForgeBlock(db),
-			repository.GetTransactions(db),
-			transaction.NewStakeTransaction(
-				repository.GetUTXOsByPublicKey(db),
+		_websocket.TransactionReceivedMessage: emergency.Halt(brake.Paused, handlers.SaveTransaction(
+			saveTransaction,
+			transport.VerifySignature(findCertificate),
+		)),
+		_websocket.ForgeBlockMessage: emergency.Halt(brake.Paused, handlers.ForgeBlock(
+			getTip,
+			getBlock,
+			repository.ForgeBlock(db, orderTransactions),
+			store.GetTransactions,
+			transaction.Prioritize(shedOrder),
+			getAlgorithm,
+			transaction.StakeOnChain(getAlgorithm, getChainID, transaction.NewStakeTransaction(
+				getAlgorithm,
+				store.GetUTXOsByPublicKey,
 				signer,
 				*masterWallet,
 				hashedAlfaPKey,
-			),
+			)),
 			transaction.IsReturnStakeTransaction(hashedAlfaPKey),
+			isBatchReady,
+			sortition.Prover(masterWallet.PrivateKey, strconv.Itoa(*nodeID)),
+			relay,
 			hub.Broadcast,
 		).
 			Authorized(
-				_websocket.PublicKeyAuthorizer(
-					encodedAlfaPkey,
-					wallet.VerifySignature,
-				),
+				blockchain.IdentityAuthorizer(alfaPKey, findCertificate),
+			)),
+		_websocket.BlockForgedMessage:          blockForged,
+		_websocket.CompactBlockMessage:         blockForged,
+		_websocket.BlockTransactionsMessage:    handlers.BlockTransactions(reconstructor.Fetched, blockForged),
+		_websocket.GetBlockTransactionsMessage: handlers.GetBlockTransactions(getBlock),
+		_websocket.FraudProofMessage:           handlers.FraudProof(reportFraud),
+		_websocket.PeerExchangeMessage:         book.Handler(hub.NodeID, hub.RegisteredNodes),
+		_websocket.CosignFinalizationMessage: handlers.CosignFinalization(
+			getTip,
+			getBlock,
+			signer,
+			masterWallet.PublicKey,
+		).
+			Authorized(
+				blockchain.IdentityAuthorizer(alfaPKey, findCertificate),
 			),
-		_websocket.BlockForgedMessage: handlers.BlockForged(
-			repository.GetTip(db),
-			repository.GetBlock(db),
-			blockchain.VerfiyBlock(verifyTransactions, transaction.IsStakeTransaction(hashedAlfaPKey)),
-			blockchain.IsReturnStakeBlock(verifyTransactions, hashedAlfaPKey),
-			repository.AddNewBlock(db),
-		),
 	}
-	go _websocket.MaintainConnection(conn, router, hub, "0", signer)
-	if err := connectToNodes(nodes, *masterWallet, router, hub, signer); err != nil {
+	if replaying {
+		breakpoints, err := replay.ParseBreakpoints(*breakHeights, *breakTransactions)
+		if err != nil {
+			log.Fatal(err)
+		}
+		recording, err := replay.Read(*replayFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := replay.Run(
+			*recording,
+			router,
+			getTip,
+			getBlock,
+			breakpoints,
+			replay.Console(os.Stdin, os.Stdout, getTip, getBlock, store.GetTransactions),
+		); err != nil {
+			log.Fatalf("Failed to replay %s %s", *replayFile, err)
+		}
+		return
+	}
+	if *recordFile != "" {
+		height, err := blockchain.GetHeight(getTip, getBlock)
+		if err != nil {
+			log.Fatalf("Failed to get height %s", err)
+		}
+		if err := repository.Snapshot(db)(*recordFile + ".db"); err != nil {
+			log.Fatal(err)
+		}
+		recorder, err := replay.NewRecorder(*recordFile, getTip(), height)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer recorder.Close()
+		router = recorder.Wrap(router)
+		log.Printf("Recording inbound messages to %s", *recordFile)
+	}
+	go _websocket.MaintainConnection(conn, router, hub, "0", transportSigner, alfaEncoding)
+	if err := connectToNodes(nodes, *masterWallet, router, hub, transportSigner, wire); err != nil {
 		log.Fatalf("Failed to connect to nodes %s", err)
 	}
-	log.Printf("Nodes %#v\n", nodes)
-	http.Handle("/", _websocket.PingPongConnection(router, hub, signer))
+	if certification != nil {
+		hub.Broadcast(_websocket.NewTransactionReceivedPong(*certification))
+	}
+	log.Printf("Connected to %d nodes", len(nodes))
+	go node.WatchResources(
+		monitor,
+		10*time.Second,
+		repository.GetDatabaseSize(db),
+		*maxDBSize,
+		repository.GetMempoolSize(db),
+		*maxMempoolSize,
+	)
+	go node.WatchMempool(pool.Sweep(store.GetTransactions, repository.DeleteTransaction(db)), time.Minute)
+	go node.ExchangePeers(book.Exchange(
+		func() (int, error) { return blockchain.GetHeight(getTip, getBlock) },
+		hub.RegisteredNodes,
+		hub.Broadcast,
+	), 30*time.Second)
+	if *watchFile != "" {
+		var notifiers []watchtower.Notifier
+		if *watchWebhook != "" {
+			notifiers = append(notifiers, watchtower.NewWebhook(*watchWebhook))
+		}
+		if *watchCommand != "" {
+			notifiers = append(notifiers, watchtower.NewCommand(*watchCommand))
+		}
+		tower := watchtower.New()
+		check := tower.Check(getTip, getBlock, watchtower.ReadReceipts(*watchFile), watchtower.All(notifiers...))
+		if err := check(); err != nil {
+			log.Fatalf("Failed to watch votes %s", err)
+		}
+		go node.WatchReceipts(check, *watchInterval)
+		http.Handle("/admin/watchtower", node.WatchtowerHandler(tower.Receipts))
+		log.Printf("Watching votes of %s", *watchFile)
+	}
+	if !*metricsPull {
+		metrics.DisablePull()
+	}
+	go metrics.Push(*pushInterval, metrics.Pushers(*pushGateway, "node", strconv.Itoa(*nodeID), *statsdAddress, *metricsDump)...)
+	http.Handle("/metrics", metrics.Handler())
+	http.Handle("/admin/alarms", monitor.Handler())
+	http.Handle("/admin/mempool", node.MempoolHandler(store.GetTransactions))
+	http.Handle("/admin/fraud", node.FraudHandler(repository.GetFraudProofs(db)))
+	http.Handle("/admin/emergency", node.EmergencyHandler(brake.State))
+	http.Handle("/admin/connections", node.ConnectionsHandler(hub.Peers, hub.Disconnect))
+	http.Handle("/admin/mesh", node.MeshHandler(book.Report))
+	http.Handle("/admin/export", export.CSVHandler(repository.Export(db, export.DefaultChunk)))
+	http.Handle("/admin/snapshot", export.SnapshotHandler(repository.StreamSnapshot(db)))
+	http.Handle("/", _websocket.PingPongConnection(router, hub, transportSigner))
 	http.ListenAndServe(fmt.Sprintf("localhost:%d", 10000+*nodeID), nil)
 }
 
-func connectToNodes(nodes []string, wallet wallet.Wallet, router _websocket.Router, hub *_websocket.Hub, signer wallet.Signer) error {
+func setUpTransportSigner(
+	keyFile string,
+	algorithm transport.Algorithm,
+	hashAlgorithm digest.Algorithm,
+	w wallet.Wallet,
+	chainSigner wallet.Signer,
+	findCertificate transport.FindCertificateFn,
+	saveTransaction transaction.SaveTransaction,
+) (wallet.Signer, *transaction.Transaction) {
+	if keyFile == "" {
+		return chainSigner, nil
+	}
+	key, err := transport.LoadOrGenerate(keyFile, algorithm)
+	if err != nil {
+		log.Fatalf("Failed to load transport key %s", err)
+	}
+	signer := transport.NewCertifiedSigner(chainSigner, w.PublicKey, *key, findCertificate)
+	if signer.Certified() {
+		return signer, nil
+	}
+	certificate, err := transport.NewCertificate(*key, chainSigner, w.PublicKey)
+	if err != nil {
+		log.Fatalf("Failed to certify transport key %s", err)
+	}
+	certification, err := transaction.NewCertificationTransaction(hashAlgorithm, *certificate)
+	if err != nil {
+		log.Fatalf("Failed to create certification transaction %s", err)
+	}
+	if err := saveTransaction(*certification); err != nil {
+		log.Fatalf("Failed to save certification transaction %s", err)
+	}
+	log.Printf("Transport key %s will be used once its certificate is forged into a block", key.Verifier())
+	return signer, certification
+}
+
+func connectToNodes(nodes []string, wallet wallet.Wallet, router _websocket.Router, hub *_websocket.Hub, signer wallet.Signer, wire _websocket.Encoding) error {
 	for _, node := range nodes {
 		i, err := strconv.Atoi(node)
 		if err != nil {
@@ -169,11 +600,45 @@ func connectToNodes(nodes []string, wallet wallet.Wallet, router _websocket.Rout
 		if err != nil {
 			return err
 		}
-		_, err = operations.Register(conn, wallet)(node)
+		_, encoding, err := operations.Register(conn, wallet, wire)(node)
 		if err != nil {
 			return err
 		}
-		go _websocket.MaintainConnection(conn, router, hub, node, signer)
+		go _websocket.MaintainConnection(conn, router, hub, node, signer, encoding)
 	}
 	return nil
 }
+
+func dialPeers(alfaConn *websocket.Conn) ([]operations.GetBlockFn, func()) {
+	peers := []operations.GetBlockFn{operations.GetBlock(alfaConn)}
+	conns := []*websocket.Conn{}
+	closePeers := func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+	nodes, err := operations.GetNodes(alfaConn)()
+	if err != nil {
+		log.Printf("Failed to retrieve registered nodes, downloading from alfa only. Error: %s", err)
+		return peers, closePeers
+	}
+	for _, node := range nodes {
+		i, err := strconv.Atoi(node)
+		if err != nil {
+			continue
+		}
+		u := url.URL{
+			Scheme: "ws",
+			Host:   fmt.Sprintf("localhost:%d", 10000+i),
+			Path:   "/",
+		}
+		conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		if err != nil {
+			log.Printf("Failed to connect to peer %s for download. Error: %s", node, err)
+			continue
+		}
+		conns = append(conns, conn)
+		peers = append(peers, operations.GetBlock(conn))
+	}
+	return peers, closePeers
+}
@@ -0,0 +1,9 @@
+package main
+
+// Plugins registering validation hooks (see internal/pkg/hooks) are
+// compiled in by importing them for their side effects here, e.g.
+//
+//	import _ "github.com/nebser/crypto-vote/plugins/votinghours"
+//
+// The alfa node and every client node have to compile in the same plugins,
+// otherwise they disagree on which blocks are valid.
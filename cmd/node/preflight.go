@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/nebser/crypto-vote/internal/pkg/emergency"
+	"github.com/nebser/crypto-vote/internal/pkg/keyfiles"
+	"github.com/nebser/crypto-vote/internal/pkg/operations"
+	"github.com/nebser/crypto-vote/internal/pkg/preflight"
+	"github.com/nebser/crypto-vote/internal/pkg/tenant"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+// nodePreflight checks that the node can start with the options it was
+// given and returns the exit code, 1 if any check failed.
+func nodePreflight(nodeID int, privateKey, publicKey, passphraseEnv, trusteesDir, tenantID, alfaURL, dbFileName, storageBackend string, o preflight.Options) int {
+	u, err := url.Parse(alfaURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse alfa node URL %s\n", err)
+		return 2
+	}
+	if tenantID != "" {
+		u.Path = strings.TrimSuffix(u.Path, "/") + tenant.Prefix(tenantID) + "/"
+	}
+	alfa := u.String()
+	checks := []preflight.Check{
+		{
+			Name: "keys",
+			Run: func() (string, error) {
+				if _, err := wallet.Import(keyfiles.KeyFiles{
+					PrivateKeyFile: privateKey,
+					PublicKeyFile:  publicKey,
+					Passphrase:     keyfiles.Unlock(passphraseEnv),
+				}); err != nil {
+					return "", errors.Wrap(err, "Chain key can't be loaded")
+				}
+				alfaKey := filepath.Join(tenantID, "alfa/key_pub.pem")
+				if _, err := wallet.LoadPublicKey(alfaKey); err != nil {
+					return "", errors.Wrapf(err, "Public key of the alfa node %s can't be loaded", alfaKey)
+				}
+				return fmt.Sprintf("%s and %s load", privateKey, alfaKey), nil
+			},
+		},
+		trusteesCheck(trusteesDir),
+		databaseCheck(dbFileName, storageBackend),
+		preflight.Bind("bind", fmt.Sprintf("localhost:%d", 10000+nodeID)),
+		preflight.Reachable("alfa", alfa),
+		preflight.Clock("alfa clock", alfa, o.ClockTolerance),
+	}
+	checks = append(checks, peerChecks(alfa, nodeID, o)...)
+	checks = append(checks, preflight.Disk(filepath.Dir(dbFileName), o.Votes, o.VoteBytes))
+	report := preflight.Run(checks)
+	if err := report.Print(os.Stdout, o.JSON); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print report %s\n", err)
+	}
+	if !report.Passed {
+		return 1
+	}
+	return 0
+}
+
+func databaseCheck(dbFileName, storageBackend string) preflight.Check {
+	if storageBackend != "bolt" {
+		return preflight.Skipped("database", fmt.Sprintf("blockchain is kept by the %s storage backend", storageBackend))
+	}
+	return preflight.Database(dbFileName, true)
+}
+
+func trusteesCheck(dir string) preflight.Check {
+	if dir == "" {
+		return preflight.Skipped("trustees", "no trustees directory given")
+	}
+	return preflight.Check{
+		Name: "trustees",
+		Run: func() (string, error) {
+			trustees, err := emergency.ReadTrustees(dir, 0)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d trustee keys load from %s", len(trustees.Keys), dir), nil
+		},
+	}
+}
+
+// peerChecks asks the alfa node for the registered nodes and checks that
+// every other one is reachable and has a clock close to this one.
+func peerChecks(alfa string, nodeID int, o preflight.Options) []preflight.Check {
+	conn, _, err := websocket.DefaultDialer.Dial(alfa, nil)
+	if err != nil {
+		return []preflight.Check{preflight.Skipped("peers", "the alfa node is not reachable")}
+	}
+	defer conn.Close()
+	nodes, err := operations.GetNodes(conn)()
+	if err != nil {
+		return []preflight.Check{preflight.Failed("peers", errors.Wrap(err, "Failed to retrieve registered nodes"))}
+	}
+	var result []preflight.Check
+	for _, node := range nodes {
+		i, err := strconv.Atoi(node)
+		if err != nil || i == nodeID {
+			continue
+		}
+		peer := fmt.Sprintf("ws://localhost:%d/", 10000+i)
+		result = append(result,
+			preflight.Reachable(fmt.Sprintf("peer %d", i), peer),
+			preflight.Clock(fmt.Sprintf("peer %d clock", i), peer, o.ClockTolerance),
+		)
+	}
+	if len(result) == 0 {
+		return []preflight.Check{preflight.Skipped("peers", "no other node is registered")}
+	}
+	return result
+}
@@ -2,23 +2,46 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"sync"
 	"time"
 
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
 	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/tenant"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
 	"github.com/pkg/errors"
 )
 
-func listParties() (party.Parties, error) {
-	response, err := http.Get("http://localhost:8000/parties")
+// get reads the response of the alfa node. Its signature is verified when
+// the public key of the alfa node is known.
+func get(url string, alfaKey []byte) ([]byte, error) {
+	response, err := http.Get(url)
 	if err != nil {
-		return nil, errors.Wrap(err, "Failed to retrieve parties")
+		return nil, err
 	}
 	defer response.Body.Close()
 	raw, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if alfaKey != nil {
+		if err := api.VerifyResponse(response.Header, raw, alfaKey); err != nil {
+			return nil, errors.Wrapf(err, "Response of %s is not signed by the alfa node", url)
+		}
+	}
+	return raw, nil
+}
+
+func listParties(alfaURL string, alfaKey []byte) (party.Parties, error) {
+	raw, err := get(alfaURL+"/parties", alfaKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to retrieve parties")
+	}
 	var parties party.Parties
 	if err := json.Unmarshal(raw, &parties); err != nil {
 		return nil, errors.Wrapf(err, "Failed to unmarshal response %s", raw)
@@ -26,16 +49,65 @@ func listParties() (party.Parties, error) {
 	return parties, nil
 }
 
-func process(wg *sync.WaitGroup) error {
+type headersResponse struct {
+	Height  int                       `json:"height"`
+	Headers blockchain.CompactHeaders `json:"headers"`
+}
+
+// syncHeaders extends the header chain with the headers the alfa node added
+// since the last poll. The last known header is requested again so that a
+// rewritten history is noticed.
+func syncHeaders(alfaURL string, alfaKey []byte, chain blockchain.HeaderChain) (blockchain.HeaderChain, error) {
+	for {
+		from := len(chain)
+		if from == 0 {
+			from = 1
+		}
+		raw, err := get(fmt.Sprintf("%s/headers?from=%d&count=2000", alfaURL, from), alfaKey)
+		if err != nil {
+			return chain, errors.Wrap(err, "Failed to retrieve headers")
+		}
+		var result headersResponse
+		if err := json.Unmarshal(raw, &result); err != nil {
+			return chain, errors.Wrapf(err, "Failed to unmarshal response %s", raw)
+		}
+		if result.Height < len(chain) {
+			return chain, errors.Wrapf(blockchain.ErrConflictingHeader, "Height %d is lower than the known height %d", result.Height, len(chain))
+		}
+		if chain, err = chain.Extend(result.Headers); err != nil {
+			return chain, err
+		}
+		if len(chain) >= result.Height || len(result.Headers) == 0 {
+			return chain, nil
+		}
+	}
+}
+
+func process(alfaURL string, alfaKey []byte, wg *sync.WaitGroup) error {
 	defer wg.Done()
+	chain := blockchain.HeaderChain{}
 	for {
-		parties, err := listParties()
+		synced, err := syncHeaders(alfaURL, alfaKey, chain)
+		switch {
+		case errors.Is(err, blockchain.ErrConflictingHeader):
+			fmt.Printf("WARNING: alfa node presented a conflicting history %s\n", err)
+		case err != nil:
+			return errors.Wrap(err, "Failed to sync headers")
+		default:
+			chain = synced
+			fmt.Printf("Header chain height %d\n", len(chain))
+		}
+		parties, err := listParties(alfaURL, alfaKey)
 		if err != nil {
 			return errors.Wrap(err, "Failed to list parties")
 		}
 		fmt.Println("START PARTY LIST")
 		for _, p := range parties {
-			fmt.Printf("%s:\t%d\n", p.Name, p.Balance/10)
+			name := p.Name
+			if p.Question != "" {
+				name = fmt.Sprintf("%s / %s", p.Question, p.Name)
+			}
+			fmt.Printf("%s:\t%d\n", name, p.Balance/10)
 		}
 		fmt.Println("END PARTY LIST")
 		time.Sleep(10 * time.Second)
@@ -43,10 +115,26 @@ func process(wg *sync.WaitGroup) error {
 }
 
 func main() {
+	tenantID := flag.String("tenant", "", "ID of the tenant whose election to follow on a multi-tenant alfa node [alfa node hosts a single election if empty]")
+	alfaKeyFile := flag.String("alfaKey", "", "Public key file of the alfa node with which responses have to be signed [signatures are not verified if empty]")
+	flag.Parse()
+	alfaURL := "http://localhost:8000"
+	if *tenantID != "" {
+		alfaURL += tenant.Prefix(*tenantID)
+	}
+	var alfaKey []byte
+	if *alfaKeyFile != "" {
+		w, err := wallet.ImportPublic(*alfaKeyFile)
+		if err != nil {
+			fmt.Printf("Failed to load public key of the alfa node %s\n", err)
+			return
+		}
+		alfaKey = w.PublicKey
+	}
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 	go func() {
-		if err := process(&wg); err != nil {
+		if err := process(alfaURL, alfaKey, &wg); err != nil {
 			fmt.Printf("Unexpected error occurred %s\n", err)
 		}
 	}()
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/nebser/crypto-vote/internal/pkg/keyfiles"
+	"github.com/nebser/crypto-vote/internal/pkg/signer"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+)
+
+func main() {
+	privateKey := flag.String("private", "alfa/key.pem", "Private key file path")
+	publicKey := flag.String("public", "alfa/key_pub.pem", "Public key file path")
+	socket := flag.String("socket", "alfa/signer.sock", "Unix socket on which sign requests are served")
+	secretFile := flag.String("secret", "alfa/signer.secret", "File with the secret shared with the alfa node, generated if missing")
+	allow := flag.String("allow", strings.Join([]string{signer.PurposeTransaction, signer.PurposeCertificate, signer.PurposeMessage}, ","), "Comma separated purposes the signer signs for")
+	flag.Parse()
+
+	w, err := wallet.Import(keyfiles.KeyFiles{
+		PublicKeyFile:  *publicKey,
+		PrivateKeyFile: *privateKey,
+	})
+	if err != nil {
+		log.Fatalf("Failed to load wallet %s", err)
+	}
+	secret, err := signer.LoadOrGenerateSecret(*secretFile)
+	if err != nil {
+		log.Fatalf("Failed to load secret %s", err)
+	}
+	if err := os.Remove(*socket); err != nil && !os.IsNotExist(err) {
+		log.Fatalf("Failed to remove stale socket %s", err)
+	}
+	listener, err := net.Listen("unix", *socket)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s %s", *socket, err)
+	}
+	if err := os.Chmod(*socket, 0600); err != nil {
+		log.Fatalf("Failed to restrict access to %s %s", *socket, err)
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-stop
+		listener.Close()
+	}()
+	log.Printf("Signing for %s with key %s on %s", *allow, w.Address, *socket) // redact:public
+	if err := signer.NewServer(*w, secret, strings.Split(*allow, ",")).Serve(listener); err != nil {
+		log.Println(err)
+	}
+}
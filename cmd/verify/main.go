@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/certification"
+	"github.com/nebser/crypto-vote/internal/pkg/repository"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+)
+
+func main() {
+	bundleDir := flag.String("bundle", "certification", "Directory of the certification bundle to verify")
+	publicKey := flag.String("public", "alfa/key_pub.pem", "Public key file path of the alfa node trusted to sign the bundle")
+	dbFileName := flag.String("db", "", "Database file of any node whose blockchain should contain the certified head [not checked if empty]")
+	flag.Parse()
+
+	trusted, err := wallet.LoadPublicKey(*publicKey)
+	if err != nil {
+		log.Fatalf("Failed to load public key %s", err)
+	}
+	manifest, err := certification.Verify(*bundleDir, trusted)
+	if err != nil {
+		log.Fatalf("Certification bundle is NOT valid: %s", err)
+	}
+	if *dbFileName != "" {
+		if _, err := os.Stat(*dbFileName); err != nil {
+			log.Fatalf("Failed to read stat for file %s", *dbFileName)
+		}
+		db, err := bolt.Open(*dbFileName, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+		if err != nil {
+			log.Fatalf("Failed to open database %s %s", *dbFileName, err)
+		}
+		defer db.Close()
+		block, err := repository.GetBlock(db)(manifest.Head.Hash)
+		if err != nil || block == nil || !bytes.Equal(block.Header.Hash, manifest.Head.Hash) {
+			log.Fatalf("Certified head %x is not part of the blockchain in %s", manifest.Head.Hash, *dbFileName)
+		}
+	}
+	log.Printf("Certification bundle is valid, head %x at height %d", manifest.Head.Hash, manifest.Head.Height)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/keystore"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+// bundle is handed to the voter, e.g. on a USB stick or by download, and is
+// useless without the PIN from the letter.
+type bundle struct {
+	MemberID string            `json:"memberId"`
+	Address  string            `json:"address"`
+	QR       string            `json:"qr"`
+	Keystore keystore.Keystore `json:"keystore"`
+}
+
+// letter is what the printing house puts on the PIN letter, it is sent to
+// the voter separately from the bundle.
+type letter struct {
+	MemberID string `json:"memberId"`
+	Address  string `json:"address"`
+	QR       string `json:"qr"`
+	PIN      string `json:"pin"`
+	IssuedAt int64  `json:"issuedAt"`
+}
+
+func readMembers(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open members file %s", path)
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	var result []string
+	seen := map[string]bool{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to parse members file %s", path)
+		}
+		if len(record) == 0 || record[0] == "" || strings.HasPrefix(record[0], "#") {
+			continue
+		}
+		if seen[record[0]] {
+			return nil, errors.Errorf("Member %s is listed twice", record[0])
+		}
+		seen[record[0]] = true
+		result = append(result, record[0])
+	}
+	return result, nil
+}
+
+func generatePIN(length int) (string, error) {
+	var b strings.Builder
+	for i := 0; i < length; i++ {
+		digit, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", errors.Wrap(err, "Failed to generate PIN")
+		}
+		b.WriteString(digit.String())
+	}
+	return b.String(), nil
+}
+
+func writeJSON(path string, value interface{}) error {
+	raw, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "Failed to marshal %s", path)
+	}
+	if err := ioutil.WriteFile(path, raw, 0600); err != nil {
+		return errors.Wrapf(err, "Failed to write %s", path)
+	}
+	return nil
+}
+
+func issue(memberID, dir string, pinLength int, qrScheme string) (string, error) {
+	w, err := wallet.New()
+	if err != nil {
+		return "", err
+	}
+	pin, err := generatePIN(pinLength)
+	if err != nil {
+		return "", err
+	}
+	k, err := keystore.Encrypt(*w, pin)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to encrypt key of member %s", memberID)
+	}
+	qr := qrScheme + ":" + w.Address
+	if err := writeJSON(filepath.Join(dir, "bundles", memberID+".json"), bundle{
+		MemberID: memberID,
+		Address:  w.Address,
+		QR:       qr,
+		Keystore: k,
+	}); err != nil {
+		return "", err
+	}
+	if err := writeJSON(filepath.Join(dir, "letters", memberID+".json"), letter{
+		MemberID: memberID,
+		Address:  w.Address,
+		QR:       qr,
+		PIN:      pin,
+		IssuedAt: time.Now().Unix(),
+	}); err != nil {
+		return "", err
+	}
+	return w.Address, nil
+}
+
+func main() {
+	count := flag.Int("count", 100, "Number of voters to generate bundles for, when members are not given")
+	membersFile := flag.String("members", "", "CSV file with the member ids of the voters in the first column [member ids are generated if empty]")
+	prefix := flag.String("prefix", "voter-", "Prefix of generated member ids")
+	outDir := flag.String("out", "bundles", "Directory in which to write the bundles, the PIN letters and the eligibility manifest")
+	pinLength := flag.Int("pinLength", 8, "Number of digits of the PIN encrypting a bundle")
+	qrScheme := flag.String("qrScheme", "crypto-vote", "URI scheme of the address encoded in the QR code")
+	workers := flag.Int("workers", 4, "Number of bundles generated at once")
+	flag.Parse()
+
+	if *pinLength < 6 {
+		log.Fatal("PIN must have at least 6 digits")
+	}
+	var members []string
+	if *membersFile != "" {
+		var err error
+		if members, err = readMembers(*membersFile); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		width := len(fmt.Sprint(*count))
+		for i := 1; i <= *count; i++ {
+			members = append(members, fmt.Sprintf("%s%0*d", *prefix, width, i))
+		}
+	}
+	for _, m := range members {
+		if strings.ContainsAny(m, `/\`) || m == "." || m == ".." {
+			log.Fatalf("Member id %s can't be used as a file name", m)
+		}
+	}
+	manifestPath := filepath.Join(*outDir, "members.csv")
+	if _, err := os.Stat(manifestPath); err == nil {
+		log.Fatalf("Eligibility manifest %s already exists, bundles are not generated twice", manifestPath)
+	}
+	for _, dir := range []string{"bundles", "letters"} {
+		if err := os.MkdirAll(filepath.Join(*outDir, dir), 0700); err != nil {
+			log.Fatalf("Failed to create directory %s %s", dir, err)
+		}
+	}
+
+	addresses := make([]string, len(members))
+	jobs := make(chan int)
+	errs := make(chan error, len(members))
+	wg := &sync.WaitGroup{}
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				address, err := issue(members[j], *outDir, *pinLength, *qrScheme)
+				if err != nil {
+					errs <- err
+					continue
+				}
+				addresses[j] = address
+			}
+		}()
+	}
+	for i := range members {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		log.Fatalf("Failed to generate bundles %s", err)
+	}
+
+	manifest, err := os.OpenFile(manifestPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatalf("Failed to create eligibility manifest %s", err)
+	}
+	writer := csv.NewWriter(manifest)
+	for i, m := range members {
+		if err := writer.Write([]string{m, addresses[i]}); err != nil {
+			log.Fatalf("Failed to write eligibility manifest %s", err)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Fatalf("Failed to write eligibility manifest %s", err)
+	}
+	if err := manifest.Close(); err != nil {
+		log.Fatalf("Failed to close eligibility manifest %s", err)
+	}
+	log.Printf("Generated bundles of %d voters in %s", len(members), *outDir)
+}
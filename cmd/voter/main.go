@@ -9,9 +9,14 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"path/filepath"
+	"strings"
 
+	"github.com/nebser/crypto-vote/internal/pkg/ballot"
 	"github.com/nebser/crypto-vote/internal/pkg/keyfiles"
 	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/tenant"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
 	"github.com/nebser/crypto-vote/internal/pkg/wallet"
 	"github.com/pkg/errors"
 )
@@ -23,39 +28,35 @@ type body struct {
 	Signature string `json:"signature"`
 }
 
-func (b body) Signable() ([]byte, error) {
-	data := struct {
-		Sender    string `json:"sender"`
-		Recipient string `json:"recipient"`
-		Value     int    `json:"value"`
-	}{
-		Sender:    b.Sender,
-		Recipient: b.Recipient,
-		Value:     10,
-	}
-	return json.Marshal(data)
-}
-
 func main() {
-	url := "http://localhost:8000/vote"
 	id := flag.Int("id", -1, "ID of the client that's voting")
 	choice := flag.Int("choice", -1, "ID of the choice to vote for")
+	answers := flag.String("answers", "", "Answers to every question of a ballot as question=choice pairs separated by ';' [a single vote for choice is cast if empty]")
+	tenantID := flag.String("tenant", "", "ID of the tenant whose election to vote in on a multi-tenant alfa node, key files are inside the directory named after it [alfa node hosts a single election if empty]")
 	flag.Parse()
+	alfaURL := "http://localhost:8000"
+	if *tenantID != "" {
+		alfaURL += tenant.Prefix(*tenantID)
+	}
 	if *id == -1 {
 		log.Fatalf("ID flag must be greater or equal to zero")
 	}
+	if *answers != "" {
+		castBallot(alfaURL, *tenantID, *id, *answers)
+		return
+	}
 	if *choice == -1 {
 		log.Fatalf("Choice flag must be greater or equal to zero")
 	}
 	keyfiles := keyfiles.KeyFiles{
-		PrivateKeyFile: fmt.Sprintf("clients/c%d.pem", *id),
-		PublicKeyFile:  fmt.Sprintf("clients/c%d_pub.pem", *id),
+		PrivateKeyFile: filepath.Join(*tenantID, fmt.Sprintf("clients/c%d.pem", *id)),
+		PublicKeyFile:  filepath.Join(*tenantID, fmt.Sprintf("clients/c%d_pub.pem", *id)),
 	}
 	w, err := wallet.Import(keyfiles)
 	if err != nil {
 		panic(err)
 	}
-	partyPub, err := wallet.LoadPublicKey(fmt.Sprintf("nodes/n%d_pub.pem", *choice))
+	partyPub, err := wallet.LoadPublicKey(filepath.Join(*tenantID, fmt.Sprintf("nodes/n%d_pub.pem", *choice)))
 	if err != nil {
 		panic(err)
 	}
@@ -64,11 +65,11 @@ func main() {
 		panic(err)
 	}
 	body := body{
-		Sender:    base64.StdEncoding.EncodeToString(w.PublicKeyHash()),
-		Recipient: base64.StdEncoding.EncodeToString(hashedPartyPub),
+		Sender:    w.Address,
+		Recipient: wallet.EncodeAddress(hashedPartyPub),
 		Verifier:  base64.StdEncoding.EncodeToString(w.PublicKey),
 	}
-	signature, err := wallet.Sign(body, w.PrivateKey)
+	signature, err := wallet.Sign(transaction.NewVoteSignable(w.PublicKeyHash(), hashedPartyPub), w.PrivateKey)
 	if err != nil {
 		panic(err)
 	}
@@ -78,7 +79,7 @@ func main() {
 		panic(err)
 	}
 	reader := bytes.NewReader(raw)
-	resp, err := http.Post(url, "application/json", reader)
+	resp, err := http.Post(alfaURL+"/vote", "application/json", reader)
 	if err != nil {
 		panic(err)
 	}
@@ -88,16 +89,73 @@ func main() {
 		panic(err)
 	}
 	log.Printf("Received response %s", result)
-	parties, err := listParties()
+	parties, err := listParties(alfaURL)
 	if err != nil {
 		log.Fatalf("Failed to list parties %s", err)
 	}
-	log.Printf("Parties %#v", parties)
+	log.Printf("Parties %v", parties)
 
 }
 
-func listParties() (party.Parties, error) {
-	response, err := http.Get("http://localhost:8000/parties")
+func castBallot(alfaURL, tenantID string, id int, answers string) {
+	w, err := wallet.Import(keyfiles.KeyFiles{
+		PrivateKeyFile: filepath.Join(tenantID, fmt.Sprintf("clients/c%d.pem", id)),
+		PublicKeyFile:  filepath.Join(tenantID, fmt.Sprintf("clients/c%d_pub.pem", id)),
+	})
+	if err != nil {
+		log.Fatalf("Failed to import wallet %s", err)
+	}
+	tally, err := getTally(alfaURL)
+	if err != nil {
+		log.Fatalf("Failed to retrieve ballot %s", err)
+	}
+	var recipients [][]byte
+	for _, answer := range strings.Split(answers, ";") {
+		parts := strings.SplitN(answer, "=", 2)
+		if len(parts) != 2 {
+			log.Fatalf("Answer %s is not a question=choice pair", answer)
+		}
+		c, ok := tally.Questions.Choice(parts[0], parts[1])
+		if !ok {
+			log.Fatalf("Choice %s of question %s does not exist", parts[1], parts[0])
+		}
+		recipients = append(recipients, wallet.ExtractPublicKeyHash(c.Address))
+	}
+	body, err := ballot.NewBody(*w, recipients, tally.Value)
+	if err != nil {
+		log.Fatalf("Failed to create ballot %s", err)
+	}
+	raw, err := json.Marshal(body)
+	if err != nil {
+		log.Fatalf("Failed to marshal ballot %s", err)
+	}
+	resp, err := http.Post(alfaURL+"/ballot", "application/json", bytes.NewReader(raw))
+	if err != nil {
+		log.Fatalf("Failed to cast ballot %s", err)
+	}
+	defer resp.Body.Close()
+	result, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatalf("Failed to read response %s", err)
+	}
+	log.Printf("Received response %s", result)
+}
+
+func getTally(alfaURL string) (*ballot.Tally, error) {
+	response, err := http.Get(alfaURL + "/tally")
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to retrieve tally")
+	}
+	defer response.Body.Close()
+	var tally ballot.Tally
+	if err := json.NewDecoder(response.Body).Decode(&tally); err != nil {
+		return nil, errors.Wrap(err, "Failed to unmarshal tally")
+	}
+	return &tally, nil
+}
+
+func listParties(alfaURL string) (party.Parties, error) {
+	response, err := http.Get(alfaURL + "/parties")
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to retrieve parties")
 	}
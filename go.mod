@@ -8,7 +8,9 @@ require (
 	github.com/google/uuid v1.1.1
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/websocket v1.4.2
+	github.com/klauspost/cpuid/v2 v2.0.11 // indirect
 	github.com/pkg/errors v0.9.1
 	github.com/robfig/cron/v3 v3.0.1
 	golang.org/x/crypto v0.0.0-20200709230013-948cd5f35899
+	lukechampine.com/blake3 v1.1.6
 )
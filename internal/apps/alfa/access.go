@@ -0,0 +1,139 @@
+package alfa
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/nebser/crypto-vote/internal/pkg/access"
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/audit"
+	"github.com/pkg/errors"
+)
+
+// AdminRules are the permissions of the admin endpoints. Admin endpoints
+// without a rule require the manage permission.
+var AdminRules = access.Rules{
+	{Method: "GET", Path: "/admin/rounds", Permission: access.View},
+	{Method: "GET", Path: "/admin/finalization", Permission: access.View},
+	{Method: "GET", Path: "/admin/connections", Permission: access.View},
+	{Method: "GET", Path: "/admin/nodes", Permission: access.View},
+	{Method: "GET", Path: "/admin/mesh", Permission: access.View},
+	{Method: "GET", Path: "/admin/compaction", Permission: access.View},
+	{Method: "GET", Path: "/admin/mempool", Permission: access.View},
+	{Method: "GET", Path: "/admin/annotations", Permission: access.Audit},
+	{Method: "GET", Path: "/admin/balance", Permission: access.Audit},
+	{Method: "GET", Path: "/admin/descriptors", Permission: access.Audit},
+	{Method: "GET", Path: "/admin/undo/{block}", Permission: access.Audit},
+	{Method: "GET", Path: "/admin/conflicts/{txid}", Permission: access.Audit},
+	{Method: "GET", Path: "/admin/export", Permission: access.Audit},
+	{Method: "GET", Path: "/admin/snapshot", Permission: access.Audit},
+	{Method: "GET", Path: "/admin/provisional", Permission: access.Audit},
+	{Method: "GET", Path: "/admin/observers", Permission: access.Audit},
+	{Method: "GET", Path: "/admin/research", Permission: access.Audit},
+	{Method: "GET", Path: "/admin/credentials", Permission: access.Audit},
+	{Method: "POST", Path: "/admin/compaction", Permission: access.Operate},
+	{Method: "DELETE", Path: "/admin/connections/{node}", Permission: access.Operate},
+	{Method: "POST", Path: "/admin/elections", Permission: access.Manage},
+	{Method: "POST", Path: "/admin/elections/{id}/open", Permission: access.Manage},
+	{Method: "POST", Path: "/admin/elections/{id}/close", Permission: access.Manage},
+	{Method: "POST", Path: "/admin/withdrawals", Permission: access.Manage},
+	{Method: "POST", Path: "/admin/provisional", Permission: access.Manage},
+	{Method: "POST", Path: "/admin/observers", Permission: access.Manage},
+	{Method: "DELETE", Path: "/admin/observers/{id}", Permission: access.Manage},
+	{Method: "POST", Path: "/admin/research/{id}/approve", Permission: access.Manage},
+	{Method: "POST", Path: "/admin/research/{id}/reject", Permission: access.Manage},
+	{Method: "POST", Path: "/admin/credentials", Permission: access.Grant},
+	{Method: "PUT", Path: "/admin/credentials/{id}/roles", Permission: access.Grant},
+	{Method: "DELETE", Path: "/admin/credentials/{id}", Permission: access.Grant},
+	{Method: "POST", Path: "/emergency", Permission: access.Emergency},
+}
+
+// adminToken takes the token from a bearer authorization or from the
+// X-API-Key header, like the token of an observer key.
+func adminToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+func refuse(w http.ResponseWriter, res api.Response) {
+	w.WriteHeader(res.Status)
+	json.NewEncoder(w).Encode(res.Body)
+}
+
+// Guarded serves requests to the admin endpoints, and to the other paths
+// the rules name, only if the credential they are made with has a role with
+// the permission the rules require.
+func Guarded(authorize access.AuthorizeFn, rules access.Rules, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		permission, ok := rules.Required(r.Method, r.URL.Path)
+		switch {
+		case ok:
+		case strings.HasPrefix(r.URL.Path, "/admin/"):
+			permission = access.Manage
+		default:
+			h.ServeHTTP(w, r)
+			return
+		}
+		c, err := authorize(adminToken(r), permission)
+		switch {
+		case errors.Is(err, access.ErrInvalidCredential):
+			refuse(w, api.UnauthorizedErrorResponse(err.Error()))
+			return
+		case errors.Is(err, access.ErrPermissionMissing):
+			log.Printf("Refused %s %s to admin credential %s with roles %v", r.Method, r.URL.Path, c.ID, c.Roles)
+			refuse(w, api.PermissionMissing(fmt.Sprintf("Roles %v don't have the %s permission", c.Roles, permission)))
+			return
+		case err != nil:
+			log.Printf("Failed to authorize admin request %s", err)
+			refuse(w, api.InternalServerErrorResponse())
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// CredentialIssuer records every admin credential issued in the audit log,
+// the token itself is never recorded.
+func CredentialIssuer(issue access.IssueFn, record audit.RecordFn) access.IssueFn {
+	return func(name string, roles []access.Role) (access.Credential, string, error) {
+		c, token, err := issue(name, roles)
+		if err != nil {
+			return access.Credential{}, "", err
+		}
+		if err := record("admin credential issued", fmt.Sprintf("credential=%s name=%q roles=%v", c.ID, c.Name, c.Roles)); err != nil {
+			return access.Credential{}, "", errors.Wrap(err, "Failed to record admin credential in audit log")
+		}
+		return c, token, nil
+	}
+}
+
+func RoleAssigner(assign access.AssignFn, record audit.RecordFn) access.AssignFn {
+	return func(id string, roles []access.Role) (access.Credential, error) {
+		c, err := assign(id, roles)
+		if err != nil {
+			return access.Credential{}, err
+		}
+		if err := record("admin roles assigned", fmt.Sprintf("credential=%s name=%q roles=%v", c.ID, c.Name, c.Roles)); err != nil {
+			return access.Credential{}, errors.Wrap(err, "Failed to record role assignment in audit log")
+		}
+		return c, nil
+	}
+}
+
+func CredentialRevoker(revoke access.RevokeFn, record audit.RecordFn) access.RevokeFn {
+	return func(id string) (access.Credential, error) {
+		c, err := revoke(id)
+		if err != nil {
+			return access.Credential{}, err
+		}
+		if err := record("admin credential revoked", fmt.Sprintf("credential=%s name=%q", c.ID, c.Name)); err != nil {
+			return access.Credential{}, errors.Wrap(err, "Failed to record revocation in audit log")
+		}
+		return c, nil
+	}
+}
@@ -3,8 +3,20 @@ package alfa
 import (
 	"fmt"
 	"log"
+	"sort"
+	"sync"
+	"time"
 
+	"github.com/nebser/crypto-vote/internal/pkg/anchor"
+	"github.com/nebser/crypto-vote/internal/pkg/ballot"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/emergency"
+	"github.com/nebser/crypto-vote/internal/pkg/network"
+	"github.com/nebser/crypto-vote/internal/pkg/outbox"
 	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/redact"
+	"github.com/nebser/crypto-vote/internal/pkg/round"
+	"github.com/nebser/crypto-vote/internal/pkg/rules"
 	"github.com/nebser/crypto-vote/internal/pkg/transaction"
 	"github.com/nebser/crypto-vote/internal/pkg/websocket"
 
@@ -13,12 +25,36 @@ import (
 	"github.com/pkg/errors"
 )
 
-func Initialize(masterWallet wallet.Wallet, nodeWallets, clientWallets wallet.Wallets, addBlock blockchain.AddBlockFn, saveParty party.SavePartyFn) error {
-	genesisTransaction, err := transaction.NewBaseTransaction(masterWallet, masterWallet.Address, 100*transaction.VoteValue)
+// Initialize creates the genesis block and funds every node with a vote and
+// every client with a vote for each question on the ballot, or with credits
+// votes in cumulative voting. The genesis block commits to the hash of the
+// election rules if there are any, and names the network type of test
+// networks. The blockchain is hashed with the algorithm from the genesis
+// block on.
+func Initialize(signer wallet.Signer, masterWallet wallet.Wallet, nodeWallets, clientWallets wallet.Wallets, definitions ballot.Definitions, credits int, rulesHash []byte, networkType network.Type, algorithm digest.Algorithm, addBlock blockchain.AddBlockFn, saveParty party.SavePartyFn) error {
+	if credits > 1 && len(definitions) > 1 {
+		return errors.New("Cumulative voting is not supported in elections with several questions")
+	}
+	genesisTransaction, err := transaction.NewBaseTransaction(algorithm, signer, masterWallet, masterWallet.Address, 100*transaction.VoteValue)
 	if err != nil {
 		return errors.Wrap(err, "Failed to generate genesis transaction")
 	}
-	genesisBlock, err := blockchain.NewBlock(nil, transaction.Transactions{*genesisTransaction})
+	genesisTransactions := transaction.Transactions{*genesisTransaction}
+	if rulesHash != nil {
+		commitment, err := rules.NewCommitment(algorithm, rulesHash)
+		if err != nil {
+			return errors.Wrap(err, "Failed to generate rules commitment")
+		}
+		genesisTransactions = append(genesisTransactions, *commitment)
+	}
+	if networkType != network.Production {
+		marker, err := network.NewMarker(algorithm, networkType)
+		if err != nil {
+			return errors.Wrap(err, "Failed to generate network marker")
+		}
+		genesisTransactions = append(genesisTransactions, *marker)
+	}
+	genesisBlock, err := blockchain.NewBlock(algorithm, nil, genesisTransactions)
 	if err != nil {
 		return errors.Wrap(err, "Failed to create genesis block")
 	}
@@ -26,24 +62,38 @@ func Initialize(masterWallet wallet.Wallet, nodeWallets, clientWallets wallet.Wa
 	if err != nil {
 		errors.Wrap(err, "Failed to initialize blockchain")
 	}
-	baseTransactions := transaction.Transactions{}
-	for _, w := range append(nodeWallets, clientWallets...) {
-		t, err := transaction.NewBaseTransaction(masterWallet, w.Address, transaction.VoteValue)
-		if err != nil {
-			return errors.Wrapf(err, "Failed to create transaction to wallet %#v", w)
-		}
-		baseTransactions = append(baseTransactions, *t)
-	}
+	nodes := party.Parties{}
 	for i, wallet := range nodeWallets {
-		p := party.Party{
+		nodes = append(nodes, party.Party{
 			Name:    fmt.Sprintf("Party Number: %d", i),
 			Address: wallet.Address,
-		}
+		})
+	}
+	parties, err := definitions.Parties(nodes)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create choices of the ballot")
+	}
+	for _, p := range parties {
 		if err := saveParty(p); err != nil {
 			return errors.Wrapf(err, "Failed to save party %#v", p)
 		}
 	}
-	block, err := blockchain.NewBlock(tip, baseTransactions)
+	baseTransactions := transaction.Transactions{}
+	for _, w := range nodeWallets {
+		t, err := transaction.NewBaseTransaction(algorithm, signer, masterWallet, w.Address, transaction.VoteValue)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to create transaction to wallet %s", w.Address)
+		}
+		baseTransactions = append(baseTransactions, *t)
+	}
+	for _, w := range clientWallets {
+		t, err := transaction.NewBaseTransaction(algorithm, signer, masterWallet, w.Address, credits*ballot.Group(parties).Value())
+		if err != nil {
+			return errors.Wrapf(err, "Failed to create transaction to wallet %s", redact.Address(w.Address))
+		}
+		baseTransactions = append(baseTransactions, *t)
+	}
+	block, err := blockchain.NewBlock(algorithm, tip, baseTransactions)
 	if err != nil {
 		return errors.Wrap(err, "Failed to create block of base transactions")
 	}
@@ -64,32 +114,179 @@ func (r RunnerFn) Run() {
 	log.Println("FINISHED RUNNER")
 }
 
-func Runner(registeredNodes websocket.RegisteredNodesFn, unicastRandomly websocket.RandomUnicastFn, getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn) RunnerFn {
+// Runner selects the next forger out of the registered nodes with a
+// probability proportional to their weight, avoiding the forger of the
+// previous round, and records the selection before sending the forge command
+// to it. The seed is derived from the tip, so the selection can be verified
+// by anyone holding the blockchain. No forger is selected while the election
+// is paused.
+func Runner(
+	paused emergency.PausedFn,
+	registeredNodes websocket.RegisteredNodesFn,
+	weigh round.WeighFn,
+	unicast websocket.UnicastFn,
+	getTip blockchain.GetTipFn,
+	getBlock blockchain.GetBlockFn,
+	getLatestRound round.GetLatestFn,
+	startRound round.StartFn,
+	completeRound round.CompleteFn,
+) RunnerFn {
 	return func() error {
-		if len(registeredNodes()) < 2 {
-			return errors.Errorf("Not enough nodes registered to perform block forging. Number of blocks %d\n", len(registeredNodes()))
+		if paused() {
+			log.Println("Election is paused, no forger is selected")
+			return nil
+		}
+		nodes := registeredNodes()
+		if len(nodes) < 2 {
+			return errors.Errorf("Not enough nodes registered to perform block forging. Number of blocks %d\n", len(nodes))
 		}
 		height, err := blockchain.GetHeight(getTip, getBlock)
 		if err != nil {
 			return errors.Errorf("Error occurred while trying to retrieve blockchain height %s", err)
 		}
+		latest, err := getLatestRound()
+		if err != nil {
+			return errors.Wrap(err, "Failed to retrieve latest round")
+		}
+		excluded := ""
+		previous := uint64(0)
+		if latest != nil {
+			excluded = latest.Selected
+			previous = latest.Number
+		}
+		weights, err := weigh(nodes)
+		if err != nil {
+			return errors.Wrap(err, "Failed to weigh nodes")
+		}
+		tip := getTip()
+		seed := round.Seed(tip, previous)
+		selected, candidates := round.SelectWeighted(weights, excluded, seed)
+		r, err := startRound(round.Round{
+			Seed:       seed,
+			Height:     height,
+			Prev:       tip,
+			Weights:    weights,
+			Candidates: candidates,
+			Excluded:   excluded,
+			Selected:   selected,
+			Outcome:    round.Pending,
+			StartedAt:  time.Now().Unix(),
+		})
+		if err != nil {
+			return errors.Wrap(err, "Failed to record round")
+		}
 		pong := websocket.Pong{
 			Message: websocket.ForgeBlockMessage,
 			Body: websocket.ForgeBlockBody{
 				Height: height,
+				Round:  r,
 			},
 		}
+		if err := unicast(selected, pong); err != nil {
+			if err := completeRound(selected, round.Failed); err != nil {
+				log.Printf("Failed to record outcome of round %d %s", r.Number, err)
+			}
+			return errors.Errorf("Failed to send forge block message %s", err)
+		}
+		log.Printf("Round %d: node %s selected to forge", r.Number, selected)
+		return nil
+	}
+}
+
+// ForgerSelection tells who picks the forger of a round.
+type ForgerSelection string
+
+const (
+	// SortitionSelection lets every node prove with a VRF whether it may
+	// forge, see Sortition.
+	SortitionSelection ForgerSelection = "sortition"
+	// AlfaSelection has alfa select the forger, see Runner.
+	AlfaSelection ForgerSelection = "alfa"
+)
+
+func ParseForgerSelection(raw string) (ForgerSelection, error) {
+	switch s := ForgerSelection(raw); s {
+	case SortitionSelection, AlfaSelection:
+		return s, nil
+	default:
+		return "", errors.Errorf("Unknown forger selection %s", raw)
+	}
+}
+
+// Sortition starts a round without selecting anyone and sends it to every
+// registered node. Each node evaluates its VRF over the seed of the round
+// and forges if the output falls under its share of the weights, so alfa
+// has no say in who forges. If several nodes forge the first block wins, if
+// none does the round is missed. No round is started while the election is
+// paused.
+func Sortition(
+	paused emergency.PausedFn,
+	registeredNodes websocket.RegisteredNodesFn,
+	weigh round.WeighFn,
+	broadcast websocket.BroadcastFn,
+	getTip blockchain.GetTipFn,
+	getBlock blockchain.GetBlockFn,
+	getLatestRound round.GetLatestFn,
+	startRound round.StartFn,
+) RunnerFn {
+	return func() error {
+		if paused() {
+			log.Println("Election is paused, no round is started")
+			return nil
+		}
+		nodes := registeredNodes()
+		if len(nodes) < 2 {
+			return errors.Errorf("Not enough nodes registered to perform block forging. Number of blocks %d\n", len(nodes))
+		}
+		height, err := blockchain.GetHeight(getTip, getBlock)
 		if err != nil {
-			return errors.Errorf("Failed to sign forge block message %s", err)
+			return errors.Errorf("Error occurred while trying to retrieve blockchain height %s", err)
 		}
-		if err := unicastRandomly(pong); err != nil {
-			return errors.Errorf("Failed to send forge block message %s", err)
+		latest, err := getLatestRound()
+		if err != nil {
+			return errors.Wrap(err, "Failed to retrieve latest round")
+		}
+		previous := uint64(0)
+		if latest != nil {
+			previous = latest.Number
+		}
+		weights, err := weigh(nodes)
+		if err != nil {
+			return errors.Wrap(err, "Failed to weigh nodes")
+		}
+		candidates := make([]string, 0, len(weights))
+		for c := range weights {
+			candidates = append(candidates, c)
 		}
+		sort.Strings(candidates)
+		tip := getTip()
+		r, err := startRound(round.Round{
+			Seed:       round.Seed(tip, previous),
+			Height:     height,
+			Prev:       tip,
+			Weights:    weights,
+			Candidates: candidates,
+			Sortition:  true,
+			Outcome:    round.Pending,
+			StartedAt:  time.Now().Unix(),
+		})
+		if err != nil {
+			return errors.Wrap(err, "Failed to record round")
+		}
+		sent := broadcast(websocket.Pong{
+			Message: websocket.ForgeBlockMessage,
+			Body: websocket.ForgeBlockBody{
+				Height: height,
+				Round:  r,
+			},
+		})
+		log.Printf("Round %d: sortition sent to %d nodes", r.Number, sent)
 		return nil
 	}
 }
 
 func Cleaner(
+	paused emergency.PausedFn,
 	getTransactions transaction.GetTransactionsFn,
 	isReturnStakeTransaction transaction.IsReturnStakeTransactionFn,
 	getTip blockchain.GetTipFn,
@@ -98,6 +295,10 @@ func Cleaner(
 	broadcast websocket.BroadcastFn,
 ) RunnerFn {
 	return func() error {
+		if paused() {
+			log.Println("Election is paused, no stake is returned")
+			return nil
+		}
 		txs, err := getTransactions()
 		if err != nil {
 			return errors.Wrap(err, "Failed to retrieve transactions")
@@ -112,7 +313,7 @@ func Cleaner(
 		if err != nil {
 			return errors.Wrap(err, "Failed to retrieve blockchain height")
 		}
-		block, err := blockchain.NewBlock(getTip(), transaction.Transactions{txs[0]})
+		block, err := blockchain.NewBlockOn(getBlock, getTip(), transaction.Transactions{txs[0]})
 		if err != nil {
 			return errors.Wrap(err, "Failed to create new block")
 		}
@@ -129,3 +330,73 @@ func Cleaner(
 		return nil
 	}
 }
+
+func TipAnchorer(
+	anchorer anchor.Anchorer,
+	getTip blockchain.GetTipFn,
+	getBlock blockchain.GetBlockFn,
+	getAnchors anchor.GetAnchorsFn,
+	saveAnchor anchor.SaveAnchorFn,
+) RunnerFn {
+	return func() error {
+		tip := getTip()
+		if tip == nil {
+			return nil
+		}
+		anchors, err := getAnchors(tip)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to retrieve anchors of block %x", tip)
+		}
+		for _, a := range anchors {
+			if a.Service == anchorer.Name() {
+				log.Printf("Tip %x is already anchored to %s", tip, a.Service)
+				return nil
+			}
+		}
+		height, err := blockchain.GetHeight(getTip, getBlock)
+		if err != nil {
+			return errors.Wrap(err, "Failed to retrieve blockchain height")
+		}
+		proof, err := anchorer.Anchor(tip)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to anchor tip %x, will retry on the next run", tip)
+		}
+		a := anchor.Anchor{
+			BlockHash: tip,
+			Height:    height,
+			Service:   anchorer.Name(),
+			Proof:     proof,
+			Timestamp: time.Now().Unix(),
+		}
+		if err := saveAnchor(a); err != nil {
+			return errors.Wrapf(err, "Failed to save anchor of block %x", tip)
+		}
+		log.Printf("Anchored tip %x at height %d to %s", tip, height, a.Service)
+		return nil
+	}
+}
+
+func OutboxDispatcher(getPending outbox.GetPendingFn, remove outbox.RemoveFn, broadcast websocket.BroadcastFn, batchSize int) RunnerFn {
+	lock := &sync.Mutex{}
+	return func() error {
+		lock.Lock()
+		defer lock.Unlock()
+		for {
+			entries, err := getPending(batchSize)
+			if err != nil {
+				return errors.Wrap(err, "Failed to retrieve pending broadcasts")
+			}
+			if len(entries) == 0 {
+				return nil
+			}
+			for _, entry := range entries {
+				if broadcast(entry.Pong()) == 0 {
+					return errors.Errorf("No registered nodes to deliver outbox entry %d to", entry.ID)
+				}
+				if err := remove(entry.ID); err != nil {
+					return errors.Wrapf(err, "Failed to remove delivered outbox entry %d", entry.ID)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package alfa
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/audit"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+)
+
+var (
+	clockDriftAlerts = metrics.NewCounter("clock_drift_alerts_total", "Number of times the clock of a node drifted beyond the tolerance")
+	driftingNodes    = metrics.NewGauge("clock_drifting_nodes", "Number of registered nodes whose clock is off by more than the tolerance")
+)
+
+// Clocks tells which registered nodes have a clock off the clock of the alfa
+// node by more than the tolerance, as heartbeats measure it. Such nodes are
+// not selected to forge, since the timestamps of their blocks can't be
+// trusted. Check alerts the operators whenever a node starts drifting.
+type Clocks struct {
+	lock      *sync.Mutex
+	tolerance time.Duration
+	offsets   websocket.ClockOffsetsFn
+	record    audit.RecordFn
+	drifting  map[string]time.Duration
+}
+
+func NewClocks(tolerance time.Duration, offsets websocket.ClockOffsetsFn, record audit.RecordFn) *Clocks {
+	return &Clocks{
+		lock:      &sync.Mutex{},
+		tolerance: tolerance,
+		offsets:   offsets,
+		record:    record,
+		drifting:  map[string]time.Duration{},
+	}
+}
+
+func (c *Clocks) off(offset time.Duration) bool {
+	return c.tolerance > 0 && (offset > c.tolerance || offset < -c.tolerance)
+}
+
+// Drift returns the offset of the clock of the node if it is beyond the
+// tolerance.
+func (c *Clocks) Drift(nodeID string) (time.Duration, bool) {
+	offset, ok := c.offsets()[nodeID]
+	if !ok || !c.off(offset) {
+		return 0, false
+	}
+	return offset, true
+}
+
+// Check compares the clocks of the registered nodes with the tolerance,
+// raising an alert for every node which started drifting since the last
+// check and noting the ones which are back within it.
+func (c *Clocks) Check() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	offsets := c.offsets()
+	drifting := map[string]time.Duration{}
+	ids := []string{}
+	for id, offset := range offsets {
+		if c.off(offset) {
+			drifting[id] = offset
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if _, ok := c.drifting[id]; ok {
+			continue
+		}
+		offset := drifting[id].Truncate(time.Millisecond)
+		clockDriftAlerts.Inc()
+		log.Printf("ALERT: clock of node %s is off by %s, beyond the tolerance of %s, it is not selected to forge", id, offset, c.tolerance)
+		if err := c.record("clock drift", fmt.Sprintf("node=%s offset=%s tolerance=%s", id, offset, c.tolerance)); err != nil {
+			log.Printf("Failed to record clock drift in audit log %s", err)
+		}
+	}
+	for id := range c.drifting {
+		if _, ok := drifting[id]; ok {
+			continue
+		}
+		if offset, ok := offsets[id]; ok {
+			log.Printf("Clock of node %s is back within the tolerance, off by %s", id, offset.Truncate(time.Millisecond))
+		}
+	}
+	c.drifting = drifting
+	driftingNodes.Set(float64(len(drifting)))
+	return nil
+}
+
+// Eligible leaves nodes whose clock drifted out of the registered ones, like
+// EligibleNodes leaves out the slashed ones.
+func (c *Clocks) Eligible(registeredNodes websocket.RegisteredNodesFn) websocket.RegisteredNodesFn {
+	return func() []string {
+		result := []string{}
+		for _, id := range registeredNodes() {
+			if _, drifting := c.Drift(id); !drifting {
+				result = append(result, id)
+			}
+		}
+		return result
+	}
+}
+
+// AnnotateFn notes how the block the node forged came about.
+type AnnotateFn func(nodeID string, block blockchain.Block, height int)
+
+// Annotator annotates blocks forged by a node whose clock drifted, e.g. one
+// which was selected before its clock was measured off.
+func (c *Clocks) Annotator(save blockchain.SaveAnnotationFn) AnnotateFn {
+	return func(nodeID string, block blockchain.Block, height int) {
+		offset, drifting := c.Drift(nodeID)
+		if !drifting {
+			return
+		}
+		a := blockchain.Annotation{
+			Block:       block.Header.Hash,
+			Height:      height,
+			NodeID:      nodeID,
+			Note:        fmt.Sprintf("clock of the forger was off by %s", offset.Truncate(time.Millisecond)),
+			ClockOffset: int64(offset / time.Millisecond),
+			At:          time.Now().Unix(),
+		}
+		if err := save(a); err != nil {
+			log.Printf("Failed to annotate block %x %s", block.Header.Hash, err)
+		}
+	}
+}
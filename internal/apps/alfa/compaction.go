@@ -0,0 +1,213 @@
+package alfa
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/audit"
+	"github.com/nebser/crypto-vote/internal/pkg/compaction"
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
+	"github.com/pkg/errors"
+)
+
+var (
+	databaseSize       = metrics.NewGauge("database_size_bytes", "Size of the database file")
+	compactionReclaim  = metrics.NewGauge("database_compaction_reclaimed_bytes", "Space reclaimed by the last database compaction")
+	compactionFinished = metrics.NewGauge("database_compaction_timestamp_seconds", "Time at which the last database compaction finished")
+)
+
+// KeepDatabaseEnv is set when alfa restarts itself on a compacted database
+// so the new option doesn't remove it.
+const KeepDatabaseEnv = "ALFA_KEEP_DATABASE"
+
+// Scheduled compactions only run when at least this part of the database
+// file would be reclaimed.
+const minReclaimRatio = 0.2
+
+// How long API requests in progress are waited for before compacting.
+const compactionDrain = 10 * time.Second
+
+// Window is a daily time span in UTC, it may wrap around midnight.
+type Window struct {
+	from time.Duration
+	to   time.Duration
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ParseWindow parses a window in the format HH:MM-HH:MM.
+func ParseWindow(s string) (*Window, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return nil, errors.Errorf("Window %s is not in the format HH:MM-HH:MM", s)
+	}
+	from, err := parseClock(parts[0])
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid start of window %s", s)
+	}
+	to, err := parseClock(parts[1])
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid end of window %s", s)
+	}
+	return &Window{from: from, to: to}, nil
+}
+
+func (w Window) Contains(t time.Time) bool {
+	t = t.UTC()
+	clock := t.Sub(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC))
+	if w.from <= w.to {
+		return clock >= w.from && clock < w.to
+	}
+	return clock >= w.from || clock < w.to
+}
+
+func (w Window) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", int(w.from.Hours()), int(w.from.Minutes())%60, int(w.to.Hours()), int(w.to.Minutes())%60)
+}
+
+func freeSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+// ReportCompaction publishes the last compaction in the metrics.
+func ReportCompaction(getLast compaction.GetLastFn, estimate compaction.EstimateFn) {
+	if e, err := estimate(); err == nil {
+		databaseSize.Set(float64(e.Size))
+	}
+	last, err := getLast()
+	switch {
+	case err != nil:
+		log.Printf("Failed to get last compaction %s", err)
+	case last != nil:
+		compactionReclaim.Set(float64(last.Reclaimed()))
+		compactionFinished.Set(float64(last.FinishedAt))
+	}
+}
+
+// Restart replaces the process with a new instance of alfa, which opens
+// the compacted database.
+func Restart(result compaction.Result) {
+	log.Printf("Database compacted from %d to %d bytes, restarting", result.SizeBefore, result.SizeAfter)
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatalf("Failed to find executable to restart %s", err)
+	}
+	env := append(os.Environ(), KeepDatabaseEnv+"=1")
+	if err := syscall.Exec(executable, os.Args, env); err != nil {
+		log.Fatalf("Failed to restart %s", err)
+	}
+}
+
+// Compactor compacts the database in maintenance mode. Only one compaction
+// runs at a time.
+type Compactor struct {
+	lock        sync.Mutex
+	running     bool
+	maintenance *Maintenance
+	estimate    compaction.EstimateFn
+	compact     compaction.CompactFn
+	swapped     compaction.SwappedFn
+	path        string
+	record      audit.RecordFn
+}
+
+func NewCompactor(maintenance *Maintenance, estimate compaction.EstimateFn, compact compaction.CompactFn, swapped compaction.SwappedFn, path string, record audit.RecordFn) *Compactor {
+	return &Compactor{
+		maintenance: maintenance,
+		estimate:    estimate,
+		compact:     compact,
+		swapped:     swapped,
+		path:        path,
+		record:      record,
+	}
+}
+
+func (c *Compactor) Running() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.running
+}
+
+func (c *Compactor) Estimate() (compaction.Estimate, error) {
+	return c.estimate()
+}
+
+// Start checks whether the compaction is safe and starts it in the
+// background. Unless forced, it is skipped when too little would be
+// reclaimed.
+func (c *Compactor) Start(force bool) (compaction.Estimate, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.running {
+		return compaction.Estimate{}, compaction.ErrRunning
+	}
+	e, err := c.estimate()
+	if err != nil {
+		return compaction.Estimate{}, errors.Wrap(err, "Failed to estimate compaction")
+	}
+	databaseSize.Set(float64(e.Size))
+	if !force && float64(e.Reclaimable) < minReclaimRatio*float64(e.Size) {
+		return e, compaction.ErrNothingToReclaim
+	}
+	free, err := freeSpace(c.path)
+	if err != nil {
+		return e, errors.Wrapf(err, "Failed to get free disk space of %s", c.path)
+	}
+	if free < e.Size {
+		return e, errors.Wrapf(compaction.ErrInsufficientSpace, "%d bytes free, %d needed", free, e.Size)
+	}
+	c.running = true
+	go c.run()
+	return e, nil
+}
+
+func (c *Compactor) run() {
+	defer func() {
+		c.lock.Lock()
+		c.running = false
+		c.lock.Unlock()
+	}()
+	if err := c.maintenance.Begin(compactionDrain); err != nil {
+		log.Printf("Failed to start maintenance for compaction %s", err)
+		return
+	}
+	defer c.maintenance.End()
+	err := c.compact(c.swapped)
+	log.Printf("Failed to compact database %s", err)
+	if err := c.record("database compaction failed", err.Error()); err != nil {
+		log.Printf("Failed to record failed compaction in audit log %s", err)
+	}
+}
+
+// CompactionRunner compacts the database when the scheduler runs it within
+// the window.
+func CompactionRunner(c *Compactor, window Window) RunnerFn {
+	return func() error {
+		if !window.Contains(time.Now()) {
+			return nil
+		}
+		switch e, err := c.Start(false); {
+		case errors.Is(err, compaction.ErrNothingToReclaim), errors.Is(err, compaction.ErrRunning):
+			log.Printf("Database compaction skipped %s, %d of %d bytes reclaimable", err, e.Reclaimable, e.Size)
+			return nil
+		case err != nil:
+			return err
+		}
+		return nil
+	}
+}
@@ -0,0 +1,40 @@
+package alfa
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nebser/crypto-vote/internal/pkg/audit"
+	"github.com/nebser/crypto-vote/internal/pkg/election"
+	"github.com/pkg/errors"
+)
+
+// ElectionCreator records every election created in the audit log.
+func ElectionCreator(create election.CreateFn, record audit.RecordFn) election.CreateFn {
+	return func(e election.Election) (election.Election, error) {
+		created, err := create(e)
+		if err != nil {
+			return election.Election{}, err
+		}
+		details := fmt.Sprintf("election=%s name=%q parties=%s startsAt=%d endsAt=%d", created.ID, created.Name, strings.Join(created.Parties, ","), created.StartsAt, created.EndsAt)
+		if err := record("election created", details); err != nil {
+			return election.Election{}, errors.Wrap(err, "Failed to record election in audit log")
+		}
+		return created, nil
+	}
+}
+
+// ElectionTransition records the action, opening or closing an election,
+// in the audit log.
+func ElectionTransition(action string, transition election.TransitionFn, record audit.RecordFn) election.TransitionFn {
+	return func(id string) (election.Election, error) {
+		e, err := transition(id)
+		if err != nil {
+			return election.Election{}, err
+		}
+		if err := record(action, fmt.Sprintf("election=%s status=%s", e.ID, e.Status)); err != nil {
+			return election.Election{}, errors.Wrapf(err, "Failed to record %s in audit log", action)
+		}
+		return e, nil
+	}
+}
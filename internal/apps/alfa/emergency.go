@@ -0,0 +1,113 @@
+package alfa
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/nebser/crypto-vote/internal/pkg/audit"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/emergency"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+// EmergencyBrake puts an emergency signed by a quorum of trustees on chain.
+// Forgers are not selected while the election is paused, so the alfa node
+// forges the block of the emergency itself, the same way it forges blocks
+// of returned stakes. A rollback first rewinds the blockchain to its
+// checkpoint, the block of the rollback is forged on the checkpoint and the
+// transactions of the rolled back blocks are discarded with their receipts.
+// If the block of the rollback can't be added, the rolled back blocks are
+// added again, so a failed rollback leaves the blockchain as it was.
+func EmergencyBrake(
+	s *emergency.Switch,
+	trustees *emergency.Trustees,
+	getAlgorithm digest.GetAlgorithmFn,
+	getTip blockchain.GetTipFn,
+	getBlock blockchain.GetBlockFn,
+	addBlock blockchain.AddBlockFn,
+	rewind blockchain.RewindFn,
+	discard transaction.DeleteTransaction,
+	invalidate emergency.InvalidateFn,
+	broadcast websocket.BroadcastFn,
+	record audit.RecordFn,
+) emergency.SubmitFn {
+	lock := &sync.Mutex{}
+	return func(e transaction.Emergency) (emergency.State, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if err := s.Check(trustees, e); err != nil {
+			return emergency.State{}, err
+		}
+		t, err := transaction.NewEmergencyTransaction(getAlgorithm(), e)
+		if err != nil {
+			return emergency.State{}, errors.Wrap(err, "Failed to create emergency transaction")
+		}
+		var rolledBack []blockchain.Block
+		if e.Statement.Action == transaction.Rollback {
+			if bytes.Equal(e.Statement.Checkpoint, getTip()) {
+				return emergency.State{}, errors.Wrapf(emergency.ErrInvalidEmergency, "Checkpoint %x is the tip, there is nothing to roll back", e.Statement.Checkpoint)
+			}
+			rolledBack, err = rewind(e.Statement.Checkpoint)
+			switch {
+			case errors.Is(err, blockchain.ErrUnknownParent):
+				return emergency.State{}, errors.Wrapf(emergency.ErrInvalidEmergency, "Checkpoint %x is not in the blockchain", e.Statement.Checkpoint)
+			case err != nil:
+				return emergency.State{}, restore(addBlock, rolledBack, errors.Wrapf(err, "Failed to roll back to checkpoint %x, %d blocks were rolled back", e.Statement.Checkpoint, len(rolledBack)))
+			}
+		}
+		height, err := blockchain.GetHeight(getTip, getBlock)
+		if err != nil {
+			return emergency.State{}, restore(addBlock, rolledBack, errors.Wrap(err, "Failed to retrieve blockchain height"))
+		}
+		block, err := blockchain.NewBlockOn(getBlock, getTip(), transaction.Transactions{*t})
+		if err != nil {
+			return emergency.State{}, restore(addBlock, rolledBack, errors.Wrap(err, "Failed to create block of emergency"))
+		}
+		if _, err := addBlock(*block); err != nil {
+			return emergency.State{}, restore(addBlock, rolledBack, errors.Wrap(err, "Failed to add block of emergency"))
+		}
+		var discarded [][]byte
+		for _, b := range rolledBack {
+			for _, rt := range b.Body.Transactions {
+				if err := discard(rt); err != nil {
+					return emergency.State{}, errors.Wrapf(err, "Failed to discard rolled back transaction %x", rt.ID)
+				}
+				discarded = append(discarded, rt.ID)
+			}
+		}
+		if err := invalidate(discarded, t.ID); err != nil {
+			return emergency.State{}, errors.Wrap(err, "Failed to invalidate receipts of rolled back transactions")
+		}
+		broadcast(websocket.Pong{
+			Message: websocket.BlockForgedMessage,
+			Body: websocket.BlockForgedBody{
+				Height: height + 1,
+				Block:  *block,
+			},
+		})
+		details := fmt.Sprintf("reason=%q issuedAt=%d signatures=%d transaction=%x", e.Statement.Reason, e.Statement.IssuedAt, len(e.Signatures), t.ID)
+		if e.Statement.Action == transaction.Rollback {
+			details += fmt.Sprintf(" checkpoint=%x blocks=%d transactions=%d", e.Statement.Checkpoint, len(rolledBack), len(discarded))
+		}
+		if err := record(fmt.Sprintf("election %s", e.Statement.Action), details); err != nil {
+			return emergency.State{}, errors.Wrap(err, "Failed to record emergency in audit log")
+		}
+		return s.State(), nil
+	}
+}
+
+// restore adds the rolled back blocks again after the emergency failed with
+// cause. They were on chain before, so they are added without another
+// verification.
+func restore(addBlock blockchain.AddBlockFn, rolledBack []blockchain.Block, cause error) error {
+	for _, b := range rolledBack {
+		if _, err := addBlock(b); err != nil {
+			return errors.Wrapf(err, "Failed to restore block %x after the rollback failed with %s", b.Header.Hash, cause)
+		}
+	}
+	return cause
+}
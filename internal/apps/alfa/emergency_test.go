@@ -0,0 +1,119 @@
+package alfa_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/apps/alfa"
+	"github.com/nebser/crypto-vote/internal/pkg/ballot"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/emergency"
+	"github.com/nebser/crypto-vote/internal/pkg/network"
+	"github.com/nebser/crypto-vote/internal/pkg/repository"
+	"github.com/nebser/crypto-vote/internal/pkg/storage"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func newWallet(t *testing.T) wallet.Wallet {
+	w, err := wallet.New()
+	if err != nil {
+		t.Fatalf("Failed to create wallet %s", err)
+	}
+	return *w
+}
+
+func TestEmergencyBrakeRestoresRolledBackBlocks(t *testing.T) {
+	store, err := storage.NewMemory()
+	if err != nil {
+		t.Fatalf("Failed to open memory storage %s", err)
+	}
+	defer store.Close()
+	db, err := storage.DB(store)
+	if err != nil {
+		t.Fatalf("Failed to get database %s", err)
+	}
+	master := newWallet(t)
+	nodes := wallet.Wallets{newWallet(t), newWallet(t)}
+	definitions := ballot.Definitions{{Name: "President"}}
+	err = alfa.Initialize(wallet.NewSigner(master), master, nodes, nil, definitions, 1, nil, network.Production, digest.SHA256, store.AddBlock, store.SaveParty)
+	if err != nil {
+		t.Fatalf("Failed to initialize election %s", err)
+	}
+	tip := store.GetTip()
+	base, err := store.GetBlock(tip)
+	if err != nil {
+		t.Fatalf("Failed to get tip %s", err)
+	}
+	utxos, err := store.GetUTXOs()
+	if err != nil {
+		t.Fatalf("Failed to get utxos %s", err)
+	}
+
+	brake, err := emergency.Load(blockchain.FindBlock(store.GetTip, store.GetBlock))
+	if err != nil {
+		t.Fatalf("Failed to load emergency state %s", err)
+	}
+	trustee := newWallet(t)
+	trustees := &emergency.Trustees{Keys: [][]byte{trustee.PublicKey}, Quorum: 1}
+	addBlock := func(b blockchain.Block) ([]byte, error) {
+		if _, ok := b.Body.Transactions.Find(transaction.Transaction.IsEmergency); ok {
+			return nil, errors.New("Disk is full")
+		}
+		return store.AddBlock(b)
+	}
+	submit := alfa.EmergencyBrake(
+		brake,
+		trustees,
+		repository.GetAlgorithm(db),
+		store.GetTip,
+		store.GetBlock,
+		addBlock,
+		blockchain.Rewind(store.GetTip, store.GetBlock, repository.RollbackTip(db)),
+		repository.DiscardRolledBack(db),
+		repository.InvalidateReceipts(db),
+		func(websocket.Pong) int { return 0 },
+		repository.RecordAudit(db),
+	)
+	e := transaction.Emergency{
+		Statement: transaction.Statement{
+			Action:     transaction.Rollback,
+			Reason:     "Compromised node",
+			Election:   brake.State().Election,
+			IssuedAt:   time.Now().Unix(),
+			Checkpoint: base.Header.Prev,
+		},
+	}
+	if err := e.Sign(trustee); err != nil {
+		t.Fatalf("Failed to sign emergency %s", err)
+	}
+
+	if _, err := submit(e); err == nil {
+		t.Fatal("Expected the emergency to fail")
+	}
+	if current := store.GetTip(); !bytes.Equal(current, tip) {
+		t.Errorf("Expected the rolled back tip %x to be restored, got %x", tip, current)
+	}
+	restored, err := store.GetUTXOs()
+	if err != nil {
+		t.Fatalf("Failed to get utxos %s", err)
+	}
+	if len(restored) != len(utxos) || restored.Sum() != utxos.Sum() {
+		t.Errorf("Expected %d utxos worth %d to be restored, got %d worth %d", len(utxos), utxos.Sum(), len(restored), restored.Sum())
+	}
+	if brake.Paused() {
+		t.Error("Expected the election to keep running")
+	}
+}
@@ -0,0 +1,69 @@
+package alfa
+
+import (
+	"log"
+	"sync"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/chain"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/redact"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+// ErrFaucetEmpty is returned while the funds of the master wallet are spent
+// or wait in pending transactions, change comes back once they are forged.
+var ErrFaucetEmpty = errors.New("Faucet has no funds available")
+
+// FaucetFn gives value to the public key hash and returns the funding
+// transaction.
+type FaucetFn func(publicKeyHash []byte, value int) (*transaction.Transaction, error)
+
+// Faucet funds addresses of a test network out of the master wallet. Only
+// one funding transaction is created at a time, so two of them never spend
+// the same utxo.
+func Faucet(
+	getUTXOs transaction.GetUTXOsByPublicKeyFn,
+	getTransactions transaction.GetTransactionsFn,
+	findBlock blockchain.FindBlockFn,
+	signer wallet.Signer,
+	w wallet.Wallet,
+	getAlgorithm digest.GetAlgorithmFn,
+	getChainID chain.GetIDFn,
+	submit transaction.SaveTransaction,
+) FaucetFn {
+	lock := &sync.Mutex{}
+	return func(publicKeyHash []byte, value int) (*transaction.Transaction, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		utxos, err := spendableUTXOs(w, getUTXOs, getTransactions, findBlock)
+		if err != nil {
+			return nil, err
+		}
+		used := transaction.UTXOs{}
+		for _, utxo := range utxos {
+			if used.Sum() >= value {
+				break
+			}
+			used = append(used, utxo)
+		}
+		if used.Sum() < value {
+			return nil, ErrFaucetEmpty
+		}
+		algorithm := getAlgorithm()
+		t, err := transaction.NewFundingTransaction(algorithm, signer, w, used, [][]byte{publicKeyHash}, value)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to create funding transaction")
+		}
+		if t, err = t.OnChain(algorithm, getChainID()); err != nil {
+			return nil, err
+		}
+		if err := submit(*t); err != nil {
+			return nil, errors.Wrap(err, "Failed to submit funding transaction")
+		}
+		log.Printf("Faucet gave %d to %s with transaction %x", value, redact.Key(publicKeyHash), t.ID)
+		return t, nil
+	}
+}
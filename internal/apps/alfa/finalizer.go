@@ -0,0 +1,208 @@
+package alfa
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/audit"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/certification"
+	"github.com/nebser/crypto-vote/internal/pkg/finalization"
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
+	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+var quorumMissing = metrics.NewGauge("finalization_quorum_missing", "Whether the finalized tip lacks the quorum of co-signers past the deadline (0 or 1)")
+
+// Finalize freezes the election at the current tip.
+func Finalize(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, save finalization.SaveFn, record audit.RecordFn) (*finalization.Finalization, error) {
+	height, err := blockchain.GetHeight(getTip, getBlock)
+	if err != nil {
+		return nil, err
+	}
+	f := finalization.Finalization{
+		TipHash:     getTip(),
+		Height:      height,
+		FinalizedAt: time.Now().Unix(),
+	}
+	if err := save(f); err != nil {
+		return nil, err
+	}
+	details := fmt.Sprintf("tip=%x height=%d", f.TipHash, f.Height)
+	if err := record("finalize", details); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Certify collects the certification bundle of the finalization and writes
+// it to dir.
+func Certify(
+	dir string,
+	f finalization.Finalization,
+	getBlock blockchain.GetBlockFn,
+	getParties party.GetPartiesFn,
+	getUTXOs transaction.GetUTXOsByPublicKeyFn,
+	getAuditLog audit.GetEntriesFn,
+	getCosignatures finalization.GetCosignaturesFn,
+	signer wallet.Signer,
+	publicKey []byte,
+) (*certification.Bundle, error) {
+	balance := func(address string) (int, error) {
+		utxos, err := getUTXOs(wallet.ExtractPublicKeyHash(address))
+		if err != nil {
+			return 0, err
+		}
+		return utxos.Sum(), nil
+	}
+	bundle, err := certification.Collect(f, getBlock, getParties, balance, getAuditLog, getCosignatures)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to collect certification bundle")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "Failed to create directory %s", dir)
+	}
+	if _, err := certification.Write(dir, *bundle, signer, publicKey); err != nil {
+		return nil, errors.Wrap(err, "Failed to write certification bundle")
+	}
+	return bundle, nil
+}
+
+// Deadline configures the automatic finalization of the election.
+type Deadline struct {
+	// End is the time at which vote intake closes.
+	End time.Time
+	// Drain is how long pending votes are waited for before finalizing
+	// without them.
+	Drain time.Duration
+	// Quorum is the number of party nodes which have to co-sign the
+	// finalized tip before the result is certified.
+	Quorum int
+	// Cosign is how long co-signers are waited for before admins are
+	// alerted.
+	Cosign time.Duration
+}
+
+// AutoFinalizer closes vote intake at the end of the election, finalizes
+// the election once pending votes are in blocks, collects co-signatures of
+// the party nodes and certifies the result when a quorum of them co-signed.
+func AutoFinalizer(
+	deadline Deadline,
+	getState finalization.GetStateFn,
+	saveState finalization.SaveStateFn,
+	getTransactions transaction.GetTransactionsFn,
+	isStakeTransaction transaction.IsStakeTransactionFn,
+	isReturnStakeTransaction transaction.IsReturnStakeTransactionFn,
+	getFinalization finalization.GetFn,
+	finalize func() (*finalization.Finalization, error),
+	getCosignatures finalization.GetCosignaturesFn,
+	broadcast websocket.BroadcastFn,
+	certify func(finalization.Finalization) (*certification.Bundle, error),
+	record audit.RecordFn,
+) RunnerFn {
+	return func() error {
+		now := time.Now()
+		if now.Before(deadline.End) {
+			return nil
+		}
+		state, err := getState()
+		if err != nil {
+			return errors.Wrap(err, "Failed to retrieve finalization state")
+		}
+		if state.CertifiedAt != 0 {
+			return nil
+		}
+		if state.ClosedAt == 0 {
+			state.ClosedAt = now.Unix()
+			if err := saveState(state); err != nil {
+				return errors.Wrap(err, "Failed to close vote intake")
+			}
+			if err := record("intake closed", fmt.Sprintf("end=%s", deadline.End.UTC().Format(time.RFC3339))); err != nil {
+				return errors.Wrap(err, "Failed to record closing of vote intake in audit log")
+			}
+			log.Println("Election has ended, vote intake is closed")
+		}
+		f, err := getFinalization()
+		if err != nil {
+			return errors.Wrap(err, "Failed to retrieve finalization")
+		}
+		if f == nil {
+			pending, err := getTransactions()
+			if err != nil {
+				return errors.Wrap(err, "Failed to retrieve pending transactions")
+			}
+			votes := 0
+			for _, t := range pending {
+				if !isStakeTransaction(t) && !isReturnStakeTransaction(t) {
+					votes++
+				}
+			}
+			if votes > 0 && now.Before(time.Unix(state.ClosedAt, 0).Add(deadline.Drain)) {
+				log.Printf("Waiting for %d pending transactions before finalizing", votes)
+				return nil
+			}
+			if votes > 0 {
+				details := fmt.Sprintf("pending=%d", votes)
+				if err := record("finalize without pending transactions", details); err != nil {
+					return errors.Wrap(err, "Failed to record pending transactions in audit log")
+				}
+				log.Printf("Finalizing without %d pending transactions", votes)
+			}
+			if f, err = finalize(); err != nil {
+				return errors.Wrap(err, "Failed to finalize election")
+			}
+			log.Printf("Election finalized at tip %x", f.TipHash)
+		}
+		cosignatures, err := getCosignatures()
+		if err != nil {
+			return errors.Wrap(err, "Failed to retrieve cosignatures")
+		}
+		cosigned := 0
+		for _, c := range cosignatures {
+			if c.Verify(*f) {
+				cosigned++
+			}
+		}
+		if cosigned < deadline.Quorum {
+			broadcast(websocket.Pong{
+				Message: websocket.CosignFinalizationMessage,
+				Body:    f.Head(),
+			})
+			if state.QuorumAlertedAt != 0 || now.Before(time.Unix(f.FinalizedAt, 0).Add(deadline.Cosign)) {
+				return nil
+			}
+			state.QuorumAlertedAt = now.Unix()
+			if err := saveState(state); err != nil {
+				return errors.Wrap(err, "Failed to save finalization state")
+			}
+			quorumMissing.Set(1)
+			details := fmt.Sprintf("tip=%x cosigned=%d quorum=%d", f.TipHash, cosigned, deadline.Quorum)
+			if err := record("cosigner quorum missing", details); err != nil {
+				return errors.Wrap(err, "Failed to record missing quorum in audit log")
+			}
+			log.Printf("ALERT: only %d of %d co-signers confirmed the finalized tip %x", cosigned, deadline.Quorum, f.TipHash)
+			return nil
+		}
+		bundle, err := certify(*f)
+		if err != nil {
+			return errors.Wrap(err, "Failed to certify election")
+		}
+		state.CertifiedAt = now.Unix()
+		if err := saveState(state); err != nil {
+			return errors.Wrap(err, "Failed to save finalization state")
+		}
+		quorumMissing.Set(0)
+		details := fmt.Sprintf("tip=%x cosigned=%d", f.TipHash, len(bundle.Cosignatures))
+		if err := record("certified", details); err != nil {
+			return errors.Wrap(err, "Failed to record certification in audit log")
+		}
+		log.Printf("Election certified with %d co-signers", len(bundle.Cosignatures))
+		return nil
+	}
+}
@@ -0,0 +1,97 @@
+package alfa
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/audit"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/fraud"
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
+	"github.com/nebser/crypto-vote/internal/pkg/stake"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+var fraudProofs = metrics.NewCounter("fraud_proofs_total", "Number of recorded proofs of forgers signing two blocks at the same height")
+
+// FraudReporter records a verified proof and puts the violation on chain
+// with an evidence transaction signed by the alfa node. Recorded forgers are
+// slashed, their stakes are no longer returned and they are not selected to
+// forge again.
+func FraudReporter(
+	verify fraud.VerifyFn,
+	getAlgorithm digest.GetAlgorithmFn,
+	signer wallet.Signer,
+	publicKey []byte,
+	save fraud.SaveFn,
+	submitTransaction transaction.SaveTransaction,
+	record audit.RecordFn,
+) fraud.ReportFn {
+	return func(p fraud.Proof) (*fraud.Record, error) {
+		violation, err := verify(p)
+		if err != nil {
+			return nil, err
+		}
+		proofHash, err := p.Hash()
+		if err != nil {
+			return nil, err
+		}
+		r := fraud.Record{
+			Violation:  *violation,
+			Proof:      p,
+			ProofHash:  proofHash,
+			ReportedAt: time.Now().Unix(),
+		}
+		evidence, err := transaction.NewEvidenceTransaction(getAlgorithm(), signer, r.Evidence(publicKey))
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to create evidence transaction")
+		}
+		r.Transaction = evidence.ID
+		if err := save(r); err != nil {
+			return nil, err
+		}
+		if err := submitTransaction(*evidence); err != nil {
+			return nil, errors.Wrapf(err, "Failed to submit evidence transaction %x", evidence.ID)
+		}
+		fraudProofs.Inc()
+		details := fmt.Sprintf("offender=%x height=%d blocks=%x,%x evidence=%x", violation.Offender, violation.Height, violation.Blocks[0], violation.Blocks[1], evidence.ID)
+		if err := record("fraud proof", details); err != nil {
+			log.Printf("Failed to record fraud proof in audit log %s", err)
+		}
+		log.Printf("ALERT: forger %x signed two blocks at height %d, evidence %x", violation.Offender, violation.Height, evidence.ID)
+		return &r, nil
+	}
+}
+
+// EligibleNodes leaves slashed nodes out of the registered ones so they are
+// never selected to forge.
+func EligibleNodes(registeredNodes websocket.RegisteredNodesFn, getNodes stake.GetNodesFn, isSlashed fraud.IsSlashedFn) websocket.RegisteredNodesFn {
+	return func() []string {
+		registered := registeredNodes()
+		nodes, err := getNodes()
+		if err != nil {
+			log.Printf("Failed to retrieve node keys, slashed nodes are not excluded %s", err)
+			return registered
+		}
+		result := []string{}
+		for _, id := range registered {
+			keyHash, ok := nodes[id]
+			if !ok {
+				result = append(result, id)
+				continue
+			}
+			switch slashed, err := isSlashed(keyHash); {
+			case err != nil:
+				log.Printf("Failed to check whether node %s is slashed %s", id, err)
+				result = append(result, id)
+			case !slashed:
+				result = append(result, id)
+			}
+		}
+		return result
+	}
+}
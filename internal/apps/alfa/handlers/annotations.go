@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/pkg/errors"
+)
+
+// GetBlockAnnotations lists the notes about blocks, e.g. the ones forged by
+// a node whose clock drifted.
+func GetBlockAnnotations(getAnnotations blockchain.GetAnnotationsFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		annotations, err := getAnnotations()
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to retrieve block annotations")
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   annotations,
+		}, nil
+	}
+}
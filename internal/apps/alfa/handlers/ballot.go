@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/pkg/address"
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/ballot"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/election"
+	"github.com/nebser/crypto-vote/internal/pkg/hooks"
+	"github.com/nebser/crypto-vote/internal/pkg/intake"
+	"github.com/nebser/crypto-vote/internal/pkg/mempool"
+	"github.com/nebser/crypto-vote/internal/pkg/outbox"
+	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/withdrawal"
+	"github.com/pkg/errors"
+)
+
+// CastBallot accepts a ballot answering every question of the election with
+// a single signature and casts it as a single transaction.
+func CastBallot(parseAddress address.ParseFn, findBlock blockchain.FindBlockFn, getParties party.GetPartiesFn, castBallot transaction.CastBallotFn, dispatch outbox.DispatchFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		var body ballot.Body
+		if err := json.Unmarshal(request.Body, &body); err != nil {
+			return api.InvalidDataErrorResponse(""), nil
+		}
+		verifier, err := base64.StdEncoding.DecodeString(body.Verifier)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid public key provided"), nil
+		}
+		signature, err := base64.StdEncoding.DecodeString(body.Signature)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid signature provided"), nil
+		}
+		sender, err := parseAddress(body.Sender)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid sender provided"), nil
+		}
+		recipients, err := parseAddress.ParseAll(body.Recipients)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid recipient provided"), nil
+		}
+		parties, err := getParties()
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to retrieve parties")
+		}
+		questions := ballot.Group(parties)
+		if err := questions.Validate(recipients); err != nil {
+			return api.InvalidDataErrorResponse(err.Error()), nil
+		}
+		signable := transaction.NewBallotSignable(sender, recipients, questions.Value())
+		if !transaction.VerifySignable(signable, signature, verifier) {
+			return api.UnauthorizedErrorResponse("Signature does not match the payload"), nil
+		}
+		criteria := func(b blockchain.Block) bool {
+			_, ok := b.Body.Transactions.FindTransactionTo(sender)
+			return ok
+		}
+		switch _, ok, err := findBlock(criteria); {
+		case err != nil:
+			return api.Response{}, errors.Errorf("Failed to find block. Error: %s", err)
+		case !ok:
+			return api.UnauthorizedErrorResponse(fmt.Sprintf("Sender %s does not exist", body.Sender)), nil
+		}
+		tr, err := castBallot(sender, recipients, questions.Value(), signature, verifier)
+		switch {
+		case errors.Is(err, transaction.ErrInsufficientVotes), errors.Is(err, mempool.ErrDoubleSpend), errors.Is(err, mempool.ErrDuplicate):
+			return api.UserAlreadyVoted(), nil
+		case errors.Is(err, mempool.ErrFull):
+			return api.MempoolFull(), nil
+		case errors.Is(err, withdrawal.ErrPartyWithdrawn):
+			return api.PartyWithdrawn(err.Error()), nil
+		case errors.Is(err, election.ErrElectionClosed), errors.Is(err, election.ErrOutsideWindow):
+			return api.ElectionClosed(err.Error()), nil
+		case errors.Is(err, hooks.ErrRejected):
+			return api.RejectedByPolicy(err.Error()), nil
+		case err != nil:
+			return api.Response{}, errors.Wrap(err, "Failed to cast ballot")
+		}
+		if err := dispatch(); err != nil {
+			log.Printf("Failed to broadcast transaction %x, it will be retried. Error: %s", tr.ID, err)
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   intake.Receipt{Transaction: tr.ID},
+		}, nil
+	}
+}
@@ -1,36 +1,53 @@
 package handlers
 
 import (
-	"encoding/base64"
-	"encoding/json"
 	"log"
 
+	"github.com/nebser/crypto-vote/internal/apps/alfa"
 	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/fraud"
+	"github.com/nebser/crypto-vote/internal/pkg/round"
+	"github.com/nebser/crypto-vote/internal/pkg/sortition"
+	"github.com/nebser/crypto-vote/internal/pkg/stake"
 	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/transport"
 	"github.com/nebser/crypto-vote/internal/pkg/wallet"
 	"github.com/nebser/crypto-vote/internal/pkg/websocket"
 	"github.com/pkg/errors"
 )
 
-type blockForgedBody struct {
-	Height int              `json:"height"`
-	Block  blockchain.Block `json:"block"`
-}
-
 func BlockForged(
 	getTip blockchain.GetTipFn,
 	getBlock blockchain.GetBlockFn,
+	findCertificate transport.FindCertificateFn,
 	verifyBlock blockchain.VerifyBlockFn,
 	addNewBlock blockchain.AddNewBlockFn,
 	isStakeTransaction transaction.IsStakeTransactionFn,
 	saveTransaction transaction.SaveTransaction,
 	newReturnStakeTransaction transaction.NewReturnStakeTransactionFn,
+	burn stake.BurnFn,
 	broadcast websocket.BroadcastFn,
+	nodeID websocket.NodeIDFn,
+	getLatestRound round.GetLatestFn,
+	completeRound round.CompleteFn,
+	observe fraud.ObserveFn,
+	report fraud.ReportFn,
+	expand blockchain.ExpandFn,
+	annotate alfa.AnnotateFn,
 ) websocket.Handler {
-	return func(ping websocket.Ping, _ string) (*websocket.Pong, error) {
-		var body blockForgedBody
-		if err := json.Unmarshal(ping.Body, &body); err != nil {
-			return nil, errors.Wrapf(err, "Failed to unmarsha block forged body %s", ping.Body)
+	return func(ping websocket.Ping, internalID string) (*websocket.Pong, error) {
+		complete := func(outcome round.Outcome) {
+			id, ok := nodeID(internalID)
+			if !ok {
+				return
+			}
+			if err := completeRound(id, outcome); err != nil {
+				log.Printf("Failed to record round outcome %s of node %s %s", outcome, id, err)
+			}
+		}
+		body, fetch, err := expand(ping)
+		if err != nil {
+			return nil, err
 		}
 		height, err := blockchain.GetHeight(getTip, getBlock)
 		if err != nil {
@@ -39,9 +56,25 @@ func BlockForged(
 		if height+1 < body.Height {
 			return nil, errors.Errorf("Blockchain height is too low %d", height)
 		}
-		sender, err := base64.StdEncoding.DecodeString(ping.Sender)
+		switch ok, err := transport.VerifySignature(findCertificate)(ping, ping.Signature, ping.Sender); {
+		case err != nil:
+			return nil, errors.Wrapf(err, "Failed to verify signature of sender %s", ping.Sender)
+		case !ok:
+			return websocket.NewDisconnectPong(), nil
+		}
+		sender, err := transport.Identity(findCertificate, ping.Sender)
 		if err != nil {
-			return nil, errors.Wrapf(err, "Failed to decode sender %s", ping.Sender)
+			return nil, errors.Wrapf(err, "Failed to resolve sender %s", ping.Sender)
+		}
+		if proof := observe(ping, sender, body.Height, body.Block.Header.Hash); proof != nil {
+			if _, err := report(*proof); err != nil && !errors.Is(err, fraud.ErrAlreadyRecorded) {
+				log.Printf("Failed to report fraud of %x at height %d %s", sender, body.Height, err)
+			}
+			return websocket.NewDisconnectPong(), nil
+		}
+		if fetch != nil {
+			log.Printf("Fetching transactions of compact block %x", body.Block.Header.Hash)
+			return fetch, nil
 		}
 		hashedSender, err := wallet.HashedPublicKey(sender)
 		if err != nil {
@@ -52,9 +85,13 @@ func BlockForged(
 		}
 		stakeTx := body.Block.Body.Transactions[0]
 		if !verifyBlock(body.Block, hashedSender) {
+			complete(round.Rejected)
 			if err := saveTransaction(stakeTx); err != nil {
 				return nil, errors.Wrapf(err, "Failed to save stake transaction %s", stakeTx)
 			}
+			if err := burn(stakeTx.ID, hashedSender, "block failed verification"); err != nil {
+				log.Printf("Failed to burn stake %x %s", stakeTx.ID, err)
+			}
 			broadcast(websocket.Pong{
 				Message: websocket.TransactionReceivedMessage,
 				Body: websocket.SaveTransactionBody{
@@ -63,12 +100,45 @@ func BlockForged(
 			})
 			return websocket.NewDisconnectPong(), nil
 		}
+		switch err := matchRound(getLatestRound, nodeID, internalID, stakeTx); {
+		case errors.Is(err, sortition.ErrStale):
+			if err := saveTransaction(stakeTx); err != nil {
+				return nil, errors.Wrapf(err, "Failed to save stale stake transaction %s", stakeTx)
+			}
+			broadcast(websocket.Pong{
+				Message: websocket.TransactionReceivedMessage,
+				Body: websocket.SaveTransactionBody{
+					Transaction: stakeTx,
+				},
+			})
+			log.Printf("Block %x is stale %s", body.Block.Header.Hash, err)
+			return websocket.NewNoActionPong(), nil
+		case errors.Is(err, sortition.ErrMismatch):
+			complete(round.Rejected)
+			if err := saveTransaction(stakeTx); err != nil {
+				return nil, errors.Wrapf(err, "Failed to save stake transaction %s", stakeTx)
+			}
+			if err := burn(stakeTx.ID, hashedSender, "sortition proof doesn't match the round"); err != nil {
+				log.Printf("Failed to burn stake %x %s", stakeTx.ID, err)
+			}
+			broadcast(websocket.Pong{
+				Message: websocket.TransactionReceivedMessage,
+				Body: websocket.SaveTransactionBody{
+					Transaction: stakeTx,
+				},
+			})
+			log.Println(err)
+			return websocket.NewDisconnectPong(), nil
+		case err != nil:
+			return nil, err
+		}
 		returnStakeTx, err := newReturnStakeTransaction(stakeTx)
 		if err != nil {
 			return nil, errors.Wrapf(err, "Failed to create return stake transaction out of %s", stakeTx)
 		}
 		switch err := addNewBlock(body.Block); {
-		case errors.Is(err, blockchain.ErrInvalidBlock):
+		case errors.Is(err, blockchain.ErrInvalidBlock), errors.Is(err, blockchain.ErrStaleBlock):
+			complete(round.Rejected)
 			if err := saveTransaction(stakeTx); err != nil {
 				return nil, errors.Wrapf(err, "Failed to save invalid stake transaction %s", stakeTx)
 			}
@@ -78,12 +148,25 @@ func BlockForged(
 					Transaction: stakeTx,
 				},
 			})
+			// A block forged on a tip which was replaced in the meantime
+			// lost the race, the forger did nothing wrong.
+			if errors.Is(err, blockchain.ErrStaleBlock) {
+				log.Printf("Block %x is stale %s", body.Block.Header.Hash, err)
+				return websocket.NewNoActionPong(), nil
+			}
+			if err := burn(stakeTx.ID, hashedSender, "block is invalid"); err != nil {
+				log.Printf("Failed to burn stake %x %s", stakeTx.ID, err)
+			}
 			log.Println("Block is invalid")
 			return websocket.NewDisconnectPong(), nil
 		case err != nil:
 			return nil, errors.Wrap(err, "Failed to add new block to blockchain")
 		default:
 			log.Println("New block added")
+			complete(round.Forged)
+			if id, ok := nodeID(internalID); ok {
+				annotate(id, body.Block, body.Height)
+			}
 			if err := saveTransaction(*returnStakeTx); err != nil {
 				return nil, errors.Wrapf(err, "Failed to save return stake transaction %s", stakeTx)
 			}
@@ -97,3 +180,18 @@ func BlockForged(
 		}
 	}
 }
+
+// matchRound checks the sortition proof of the stake transaction against the
+// latest round if it is a sortition round. Only alfa knows the weights of the
+// round, nodes take the ones the proof claims.
+func matchRound(getLatestRound round.GetLatestFn, nodeID websocket.NodeIDFn, internalID string, stakeTx transaction.Transaction) error {
+	latest, err := getLatestRound()
+	if err != nil {
+		return errors.Wrap(err, "Failed to retrieve latest round")
+	}
+	if latest == nil || !latest.Sortition {
+		return nil
+	}
+	id, _ := nodeID(internalID)
+	return sortition.Match(*latest, id, stakeTx.Sortition)
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+// BlockTransactions handles the compact block again once the transactions
+// it missed came.
+func BlockTransactions(fetched blockchain.FetchedFn, blockForged websocket.Handler) websocket.Handler {
+	return func(ping websocket.Ping, internalID string) (*websocket.Pong, error) {
+		var body blockchain.BlockTransactionsBody
+		if err := json.Unmarshal(ping.Body, &body); err != nil {
+			return nil, errors.Wrapf(err, "Failed to unmarshal block transactions body %s", ping.Body)
+		}
+		compact := fetched(body)
+		if compact == nil {
+			log.Printf("Block %x isn't waiting for transactions", body.Hash)
+			return websocket.NewNoActionPong(), nil
+		}
+		return blockForged(*compact, internalID)
+	}
+}
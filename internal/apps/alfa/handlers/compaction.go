@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/audit"
+	"github.com/nebser/crypto-vote/internal/pkg/compaction"
+	"github.com/pkg/errors"
+)
+
+type compactionStatus struct {
+	Size        int64              `json:"size"`
+	Reclaimable int64              `json:"reclaimable"`
+	Running     bool               `json:"running"`
+	Last        *compaction.Result `json:"last,omitempty"`
+}
+
+func GetCompaction(estimate compaction.EstimateFn, getLast compaction.GetLastFn, running compaction.RunningFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		e, err := estimate()
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to estimate compaction")
+		}
+		last, err := getLast()
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to retrieve last compaction")
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body: compactionStatus{
+				Size:        e.Size,
+				Reclaimable: e.Reclaimable,
+				Running:     running(),
+				Last:        last,
+			},
+		}, nil
+	}
+}
+
+// Compact starts a compaction regardless of how much it would reclaim.
+// The API is unavailable until alfa restarts on the compacted database.
+func Compact(start compaction.StartFn, record audit.RecordFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		e, err := start(true)
+		switch {
+		case errors.Is(err, compaction.ErrRunning), errors.Is(err, compaction.ErrInsufficientSpace):
+			return api.CompactionRefused(err.Error()), nil
+		case err != nil:
+			return api.Response{}, errors.Wrap(err, "Failed to start compaction")
+		}
+		details := fmt.Sprintf("size %d bytes, reclaimable %d bytes", e.Size, e.Reclaimable)
+		if err := record("database compaction started", details); err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to record compaction in audit log")
+		}
+		return api.Response{
+			Status: http.StatusAccepted,
+			Body:   e,
+		}, nil
+	}
+}
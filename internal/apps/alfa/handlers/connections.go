@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/audit"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+type disconnectResponse struct {
+	Closed int `json:"closed"`
+}
+
+func GetConnections(peers websocket.PeersFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   peers(),
+		}, nil
+	}
+}
+
+// Disconnect forcibly closes the connections of a node given by its id or
+// key. The node may connect again unless it is kept out otherwise.
+func Disconnect(disconnect websocket.DisconnectFn, record audit.RecordFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		node := request.Vars["node"]
+		closed := disconnect(node)
+		if closed == 0 {
+			return api.NotFoundErrorResponse(fmt.Sprintf("Node %s is not connected", node)), nil
+		}
+		if err := record("node disconnected", fmt.Sprintf("node=%s connections=%d", node, closed)); err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to record disconnect in audit log")
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   disconnectResponse{Closed: closed},
+		}, nil
+	}
+}
+
+// nodeHealth is a registered node as its heartbeats see it, a node is late
+// once it missed a heartbeat.
+type nodeHealth struct {
+	websocket.Peer
+	Silent string `json:"silent"`
+	Late   bool   `json:"late"`
+}
+
+type nodesHealthResponse struct {
+	Interval string       `json:"interval"`
+	Timeout  string       `json:"timeout"`
+	Retries  int          `json:"retries"`
+	Nodes    []nodeHealth `json:"nodes"`
+	Pending  int          `json:"pending"`
+}
+
+// GetNodesHealth reports when the registered nodes were last seen and how
+// many broadcasts they haven't acknowledged yet.
+func GetNodesHealth(peers websocket.PeersFn, heartbeat websocket.HeartbeatFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		h := heartbeat()
+		now := time.Now()
+		response := nodesHealthResponse{
+			Interval: h.Interval.String(),
+			Timeout:  h.Timeout.String(),
+			Retries:  h.Retries,
+			Nodes:    []nodeHealth{},
+		}
+		for _, p := range peers() {
+			if !p.Registered {
+				response.Pending++
+				continue
+			}
+			silent := now.Sub(time.Unix(p.LastSeen, 0))
+			response.Nodes = append(response.Nodes, nodeHealth{
+				Peer:   p,
+				Silent: silent.Truncate(time.Second).String(),
+				Late:   h.Interval > 0 && silent > 2*h.Interval,
+			})
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   response,
+		}, nil
+	}
+}
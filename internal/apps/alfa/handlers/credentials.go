@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/pkg/access"
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/pkg/errors"
+)
+
+type credentialBody struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+}
+
+type rolesBody struct {
+	Roles []string `json:"roles"`
+}
+
+type credentialResponse struct {
+	Credential access.Credential `json:"credential"`
+	Token      string            `json:"token"`
+}
+
+func parseRoles(raw []string) ([]access.Role, error) {
+	var result []access.Role
+	for _, r := range raw {
+		role, err := access.ParseRole(r)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, role)
+	}
+	return result, nil
+}
+
+func credentialError(err error) (api.Response, error) {
+	switch {
+	case errors.Is(err, access.ErrUnknownCredential):
+		return api.NotFoundErrorResponse(err.Error()), nil
+	case errors.Is(err, access.ErrInvalidRole), errors.Is(err, access.ErrMissingName), errors.Is(err, access.ErrLastCommissioner), errors.Is(err, access.ErrCredentialRevoked):
+		return api.InvalidDataErrorResponse(err.Error()), nil
+	}
+	return api.Response{}, err
+}
+
+func IssueCredential(issue access.IssueFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		var body credentialBody
+		if err := json.Unmarshal(request.Body, &body); err != nil {
+			return api.InvalidDataErrorResponse(""), nil
+		}
+		roles, err := parseRoles(body.Roles)
+		if err != nil {
+			return api.InvalidDataErrorResponse(err.Error()), nil
+		}
+		c, token, err := issue(body.Name, roles)
+		if err != nil {
+			return credentialError(err)
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   credentialResponse{Credential: c.Public(), Token: token},
+		}, nil
+	}
+}
+
+func GetCredentials(list access.ListFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		credentials, err := list()
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to retrieve admin credentials")
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   credentials,
+		}, nil
+	}
+}
+
+// AssignRoles replaces the roles of the credential given by its id.
+func AssignRoles(assign access.AssignFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		var body rolesBody
+		if err := json.Unmarshal(request.Body, &body); err != nil {
+			return api.InvalidDataErrorResponse(""), nil
+		}
+		roles, err := parseRoles(body.Roles)
+		if err != nil {
+			return api.InvalidDataErrorResponse(err.Error()), nil
+		}
+		c, err := assign(request.Vars["id"], roles)
+		if err != nil {
+			return credentialError(err)
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   c,
+		}, nil
+	}
+}
+
+func RevokeCredential(revoke access.RevokeFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		c, err := revoke(request.Vars["id"])
+		if err != nil {
+			return credentialError(err)
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   c,
+		}, nil
+	}
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"log"
+
+	"github.com/nebser/crypto-vote/internal/pkg/stake"
+	"github.com/nebser/crypto-vote/internal/pkg/transport"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+type deregisterResponse struct {
+	Returned int `json:"returned"`
+}
+
+// Deregister returns the stake of a node leaving the system for good.
+func Deregister(findCertificate transport.FindCertificateFn, release stake.ReleaseFn) websocket.Handler {
+	return func(ping websocket.Ping, _ string) (*websocket.Pong, error) {
+		sender, err := transport.Identity(findCertificate, ping.Sender)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to resolve sender %s", ping.Sender)
+		}
+		hashedSender, err := wallet.HashedPublicKey(sender)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to extract hashed public key")
+		}
+		returned, err := release(hashedSender)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to return stake of %x", hashedSender)
+		}
+		log.Printf("Node %x deregistered, returned %d stakes", hashedSender, returned)
+		return websocket.NewResponsePong(deregisterResponse{Returned: returned}), nil
+	}
+}
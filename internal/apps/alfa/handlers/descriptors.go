@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/nebser/crypto-vote/internal/pkg/address"
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/descriptor"
+	"github.com/pkg/errors"
+)
+
+// GetDescriptors exports the outputs of the key sets given by ?set=, and of
+// the keys given by ?address=, both may be repeated, at ?height= or at the
+// tip. Every set is exported if neither is given.
+func GetDescriptors(parseAddress address.ParseFn, export descriptor.ExportFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		r := descriptor.Request{Height: -1}
+		for _, raw := range request.Query["set"] {
+			s, err := descriptor.ParseSet(raw)
+			if err != nil {
+				return api.InvalidDataErrorResponse(err.Error()), nil
+			}
+			r.Sets = append(r.Sets, s)
+		}
+		addresses, err := parseAddress.ParseAll(request.Query["address"])
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid address provided"), nil
+		}
+		r.Addresses = addresses
+		if len(r.Sets) == 0 && len(r.Addresses) == 0 {
+			r.Sets = descriptor.Sets
+		}
+		if raw := request.Query.Get("height"); raw != "" {
+			if r.Height, err = strconv.Atoi(raw); err != nil || r.Height < 0 {
+				return api.InvalidDataErrorResponse("Invalid height provided"), nil
+			}
+		}
+		result, err := export(r)
+		switch {
+		case errors.Is(err, blockchain.ErrHeightNotReached):
+			return api.NotFoundErrorResponse(fmt.Sprintf("Blockchain has not reached height %d", r.Height)), nil
+		case err != nil:
+			return api.Response{}, errors.Wrap(err, "Failed to export descriptors")
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   result,
+		}, nil
+	}
+}
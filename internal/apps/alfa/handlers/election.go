@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/election"
+	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+// electionBody creates an election, the window is given in RFC 3339.
+type electionBody struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Parties  []string `json:"parties"`
+	StartsAt string   `json:"startsAt"`
+	EndsAt   string   `json:"endsAt"`
+}
+
+func electionResponse(e election.Election, err error) (api.Response, error) {
+	switch {
+	case errors.Is(err, election.ErrUnknownElection):
+		return api.NotFoundErrorResponse(err.Error()), nil
+	case errors.Is(err, election.ErrInvalidElection), errors.Is(err, election.ErrElectionExists):
+		return api.InvalidDataErrorResponse(err.Error()), nil
+	case err != nil:
+		return api.Response{}, err
+	}
+	return api.Response{
+		Status: http.StatusOK,
+		Body:   e,
+	}, nil
+}
+
+func CreateElection(create election.CreateFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		var body electionBody
+		if err := json.Unmarshal(request.Body, &body); err != nil {
+			return api.InvalidDataErrorResponse(""), nil
+		}
+		startsAt, err := time.Parse(time.RFC3339, body.StartsAt)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid start of the election provided"), nil
+		}
+		endsAt, err := time.Parse(time.RFC3339, body.EndsAt)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid end of the election provided"), nil
+		}
+		return electionResponse(create(election.Election{
+			ID:       body.ID,
+			Name:     body.Name,
+			Parties:  body.Parties,
+			StartsAt: startsAt.Unix(),
+			EndsAt:   endsAt.Unix(),
+		}))
+	}
+}
+
+// TransitionElection opens or closes the election given by its id.
+func TransitionElection(transition election.TransitionFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		return electionResponse(transition(request.Vars["id"]))
+	}
+}
+
+func GetElections(list election.ListFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		elections, err := list()
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to retrieve elections")
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   elections,
+		}, nil
+	}
+}
+
+// GetElectionParties lists the parties of the election with their balances,
+// like GetParties does for all of them.
+func GetElectionParties(get election.GetFn, getParties party.GetPartiesFn, getUTXOsByPublicKey transaction.GetUTXOsByPublicKeyFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		e, ok := get(request.Vars["id"])
+		if !ok {
+			return api.NotFoundErrorResponse("Election does not exist"), nil
+		}
+		scoped := func() (party.Parties, error) {
+			parties, err := getParties()
+			if err != nil {
+				return nil, err
+			}
+			listed := map[string]bool{}
+			for _, a := range e.Parties {
+				listed[a] = true
+			}
+			result := party.Parties{}
+			for _, p := range parties {
+				if listed[p.Address] {
+					result = append(result, p)
+				}
+			}
+			return result, nil
+		}
+		return GetParties(scoped, getUTXOsByPublicKey)(request)
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/emergency"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+// SubmitEmergency pauses or resumes the election with a statement signed by
+// a quorum of trustees.
+func SubmitEmergency(submit emergency.SubmitFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		var e transaction.Emergency
+		if err := json.Unmarshal(request.Body, &e); err != nil {
+			return api.InvalidDataErrorResponse(""), nil
+		}
+		state, err := submit(e)
+		switch {
+		case errors.Is(err, emergency.ErrInvalidEmergency):
+			return api.InvalidDataErrorResponse(err.Error()), nil
+		case err != nil:
+			return api.Response{}, errors.Wrap(err, "Failed to submit emergency")
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   state,
+		}, nil
+	}
+}
+
+func GetEmergency(getState emergency.GetStateFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   getState(),
+		}, nil
+	}
+}
+
+func WhileNotPaused(paused emergency.PausedFn, h api.Handler) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		if paused() {
+			return api.ElectionPaused(), nil
+		}
+		return h(request)
+	}
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/apps/alfa"
+	"github.com/nebser/crypto-vote/internal/pkg/address"
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/mempool"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+// faucetBody asks for credits votes to the address, a voter's credits if
+// credits is 0.
+type faucetBody struct {
+	Address string `json:"address"`
+	Credits int    `json:"credits"`
+}
+
+type faucetResponse struct {
+	Address     string `json:"address"`
+	Credits     int    `json:"credits"`
+	Transaction []byte `json:"transaction"`
+}
+
+// throwawayKeyResponse hands out the private key in plaintext, which is only
+// acceptable because the key votes on a test network.
+type throwawayKeyResponse struct {
+	faucetResponse
+	PublicKey  []byte `json:"publicKey"`
+	PrivateKey string `json:"privateKey"`
+}
+
+func credits(requested, maxCredits int) (int, bool) {
+	if requested == 0 {
+		return maxCredits, true
+	}
+	return requested, requested > 0 && requested <= maxCredits
+}
+
+// Faucet gives credits to any address, every credit is worth creditValue.
+func Faucet(parseAddress address.ParseFn, faucet alfa.FaucetFn, creditValue, maxCredits int) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		var body faucetBody
+		if err := json.Unmarshal(request.Body, &body); err != nil {
+			return api.InvalidDataErrorResponse(""), nil
+		}
+		publicKeyHash, err := parseAddress(body.Address)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid address provided"), nil
+		}
+		n, ok := credits(body.Credits, maxCredits)
+		if !ok {
+			return api.InvalidDataErrorResponse(fmt.Sprintf("Between 1 and %d credits can be given", maxCredits)), nil
+		}
+		t, err := faucet(publicKeyHash, n*creditValue)
+		switch {
+		case errors.Is(err, alfa.ErrFaucetEmpty):
+			return api.FaucetEmpty(), nil
+		case errors.Is(err, mempool.ErrFull):
+			return api.MempoolFull(), nil
+		case err != nil:
+			return api.Response{}, errors.Wrap(err, "Failed to fund address")
+		}
+		return api.Response{
+			Status: http.StatusAccepted,
+			Body: faucetResponse{
+				Address:     wallet.EncodeAddress(publicKeyHash),
+				Credits:     n,
+				Transaction: t.ID,
+			},
+		}, nil
+	}
+}
+
+// ThrowawayKey generates a new key pair and gives it a voter's credits.
+func ThrowawayKey(faucet alfa.FaucetFn, creditValue, maxCredits int) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		w, err := wallet.New()
+		if err != nil {
+			return api.Response{}, err
+		}
+		privateKey, err := w.PrivateKeyPEM()
+		if err != nil {
+			return api.Response{}, err
+		}
+		t, err := faucet(w.PublicKeyHash(), maxCredits*creditValue)
+		switch {
+		case errors.Is(err, alfa.ErrFaucetEmpty):
+			return api.FaucetEmpty(), nil
+		case errors.Is(err, mempool.ErrFull):
+			return api.MempoolFull(), nil
+		case err != nil:
+			return api.Response{}, errors.Wrap(err, "Failed to fund throwaway key")
+		}
+		return api.Response{
+			Status: http.StatusCreated,
+			Body: throwawayKeyResponse{
+				faucetResponse: faucetResponse{
+					Address:     w.Address,
+					Credits:     maxCredits,
+					Transaction: t.ID,
+				},
+				PublicKey:  w.PublicKey,
+				PrivateKey: string(privateKey),
+			},
+		}, nil
+	}
+}
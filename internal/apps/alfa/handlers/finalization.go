@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/ballot"
+	"github.com/nebser/crypto-vote/internal/pkg/finalization"
+	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+// FinalizationCosigned stores the cosignature of a party node confirming
+// the finalized tip.
+func FinalizationCosigned(getFinalization finalization.GetFn, getParties party.GetPartiesFn, save finalization.SaveCosignatureFn) websocket.Handler {
+	return func(ping websocket.Ping, _ string) (*websocket.Pong, error) {
+		var cosignature finalization.Cosignature
+		if err := json.Unmarshal(ping.Body, &cosignature); err != nil {
+			return websocket.NewErrorPong(websocket.NewInvalidDataError(websocket.FinalizationCosignedMessage.String())), nil
+		}
+		f, err := getFinalization()
+		switch {
+		case err != nil:
+			return nil, errors.Wrap(err, "Failed to retrieve finalization")
+		case f == nil || !cosignature.Verify(*f):
+			log.Printf("Ignoring cosignature of %x which doesn't match the finalization", cosignature.Verifier)
+			return websocket.NewNoActionPong(), nil
+		}
+		address, err := wallet.ExtractAddress(cosignature.Verifier)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to extract address")
+		}
+		parties, err := getParties()
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to retrieve parties")
+		}
+		if _, ok := ballot.Nodes(parties).Find(address); !ok {
+			log.Printf("Ignoring cosignature of %s which is not a party node", address) // redact:public
+			return websocket.NewNoActionPong(), nil
+		}
+		if err := save(cosignature); err != nil {
+			return nil, errors.Wrapf(err, "Failed to save cosignature of %s", address)
+		}
+		log.Printf("Party node %s co-signed the finalized tip %x", address, f.TipHash) // redact:public
+		return websocket.NewNoActionPong(), nil
+	}
+}
+
+type finalizationResponse struct {
+	State        finalization.State         `json:"state"`
+	Finalization *finalization.Finalization `json:"finalization"`
+	Cosignatures finalization.Cosignatures  `json:"cosignatures"`
+	Quorum       int                        `json:"quorum"`
+}
+
+// GetFinalization reports the progress of the automatic finalization.
+func GetFinalization(getState finalization.GetStateFn, getFinalization finalization.GetFn, getCosignatures finalization.GetCosignaturesFn, quorum int) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		state, err := getState()
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to retrieve finalization state")
+		}
+		f, err := getFinalization()
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to retrieve finalization")
+		}
+		cosignatures := finalization.Cosignatures{}
+		if f != nil {
+			all, err := getCosignatures()
+			if err != nil {
+				return api.Response{}, errors.Wrap(err, "Failed to retrieve cosignatures")
+			}
+			for _, c := range all {
+				if c.Verify(*f) {
+					cosignatures = append(cosignatures, c)
+				}
+			}
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body: finalizationResponse{
+				State:        state,
+				Finalization: f,
+				Cosignatures: cosignatures,
+				Quorum:       quorum,
+			},
+		}, nil
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/fraud"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+// FraudProof records a proof a node observed.
+func FraudProof(report fraud.ReportFn) websocket.Handler {
+	return func(ping websocket.Ping, _ string) (*websocket.Pong, error) {
+		var proof fraud.Proof
+		if err := json.Unmarshal(ping.Body, &proof); err != nil {
+			return websocket.NewErrorPong(websocket.NewInvalidDataError(websocket.FraudProofMessage.String())), nil
+		}
+		switch _, err := report(proof); {
+		case errors.Is(err, fraud.ErrInvalidProof):
+			log.Printf("Ignoring invalid fraud proof %s", err)
+			return websocket.NewErrorPong(websocket.NewInvalidDataError(websocket.FraudProofMessage.String())), nil
+		case errors.Is(err, fraud.ErrAlreadyRecorded):
+			return websocket.NewNoActionPong(), nil
+		case err != nil:
+			return nil, errors.Wrap(err, "Failed to report fraud")
+		default:
+			return websocket.NewNoActionPong(), nil
+		}
+	}
+}
+
+// SubmitFraudProof lets clients report two blocks the same forger signed at
+// the same height.
+func SubmitFraudProof(report fraud.ReportFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		var proof fraud.Proof
+		if err := json.Unmarshal(request.Body, &proof); err != nil {
+			return api.InvalidDataErrorResponse(""), nil
+		}
+		r, err := report(proof)
+		switch {
+		case errors.Is(err, fraud.ErrInvalidProof):
+			return api.InvalidDataErrorResponse(err.Error()), nil
+		case errors.Is(err, fraud.ErrAlreadyRecorded):
+			return api.FraudAlreadyRecorded(), nil
+		case err != nil:
+			return api.Response{}, errors.Wrap(err, "Failed to report fraud")
+		}
+		return api.Response{
+			Status: http.StatusAccepted,
+			Body:   r,
+		}, nil
+	}
+}
+
+func GetFraudProofs(getProofs fraud.GetFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		proofs, err := getProofs()
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to retrieve fraud proofs")
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   proofs,
+		}, nil
+	}
+}
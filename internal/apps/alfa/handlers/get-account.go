@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"github.com/nebser/crypto-vote/internal/pkg/stake"
+	"github.com/nebser/crypto-vote/internal/pkg/transport"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+// GetAccount answers a registered node with the balance, the locked stake
+// and the last transaction of its chain key.
+func GetAccount(findCertificate transport.FindCertificateFn, getAccount stake.GetAccountFn) websocket.Handler {
+	return func(ping websocket.Ping, _ string) (*websocket.Pong, error) {
+		sender, err := transport.Identity(findCertificate, ping.Sender)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to resolve sender %s", ping.Sender)
+		}
+		hashedSender, err := wallet.HashedPublicKey(sender)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to extract hashed public key")
+		}
+		account, err := getAccount(hashedSender)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to retrieve account of %x", hashedSender)
+		}
+		return websocket.NewResponsePong(account), nil
+	}
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/nebser/crypto-vote/internal/pkg/address"
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+type historicalUTXO struct {
+	TransactionID []byte `json:"transactionId"`
+	Vout          int    `json:"vout"`
+	Value         int    `json:"value"`
+}
+
+type balanceAtResponse struct {
+	Address string           `json:"address"`
+	Height  int              `json:"height"`
+	Block   []byte           `json:"block"`
+	Balance int              `json:"balance"`
+	UTXOs   []historicalUTXO `json:"utxos"`
+}
+
+// GetBalanceAt reports the balance and unspent outputs of an address as
+// they were right after the block at the height was added.
+func GetBalanceAt(parseAddress address.ParseFn, getUTXOsAt transaction.GetUTXOsByPublicKeyAtFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		addr := request.Query.Get("address")
+		publicKeyHash, err := parseAddress(addr)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid address provided"), nil
+		}
+		height, err := strconv.Atoi(request.Query.Get("height"))
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid height provided"), nil
+		}
+		utxos, block, err := getUTXOsAt(publicKeyHash, height)
+		switch {
+		case errors.Is(err, blockchain.ErrHeightNotReached):
+			return api.NotFoundErrorResponse(fmt.Sprintf("Blockchain has not reached height %d", height)), nil
+		case err != nil:
+			return api.Response{}, errors.Wrapf(err, "Failed to retrieve utxos of %s at height %d", addr, height)
+		}
+		result := balanceAtResponse{
+			Address: addr,
+			Height:  height,
+			Block:   block,
+			Balance: utxos.Sum(),
+			UTXOs:   []historicalUTXO{},
+		}
+		for _, u := range utxos {
+			result.UTXOs = append(result.UTXOs, historicalUTXO{
+				TransactionID: u.TransactionID,
+				Vout:          u.Vout,
+				Value:         u.Value,
+			})
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   result,
+		}, nil
+	}
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+// rangeBytes limits the encoded size of the blocks of a single response, so
+// it fits into a message with the overhead of JSON and base64.
+const rangeBytes = websocket.MaxMessageBytes / 2
+
+type getBlocksRangePayload struct {
+	After []byte `json:"after"`
+	Count int    `json:"count"`
+}
+
+type getBlocksRangeResponse struct {
+	Blocks    blockchain.Blocks `json:"blocks"`
+	Remaining int               `json:"remaining"`
+}
+
+// GetBlocksRange answers with up to count blocks following the block after,
+// from the genesis block if after is empty, and the number of blocks left
+// behind them. A batch is cut short once its blocks reach rangeBytes, but
+// holds at least one block.
+func GetBlocksRange(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, maxCount int) websocket.Handler {
+	return func(ping websocket.Ping, _ string) (*websocket.Pong, error) {
+		getBlock := getBlock.Within(ping.Context())
+		var payload getBlocksRangePayload
+		if err := json.Unmarshal(ping.Body, &payload); err != nil || payload.Count <= 0 {
+			return websocket.NewErrorPong(websocket.NewInvalidDataError(websocket.GetBlocksRangeMessage.String())), nil
+		}
+		if payload.Count > maxCount {
+			payload.Count = maxCount
+		}
+		fork, following, err := blockchain.FindFork(getTip, getBlock, [][]byte{payload.After})
+		if err != nil {
+			return nil, err
+		}
+		if len(payload.After) > 0 && fork == nil {
+			return websocket.NewErrorPong(websocket.NewBlockNotFoundError(payload.After)), nil
+		}
+		blocks := blockchain.Blocks{}
+		size := 0
+		for _, hash := range following {
+			if len(blocks) == payload.Count || (len(blocks) > 0 && size >= rangeBytes) {
+				break
+			}
+			block, err := getBlock(hash)
+			switch {
+			case err != nil:
+				return nil, errors.Wrapf(err, "Failed to get block %x", hash)
+			case block == nil:
+				return nil, errors.Errorf("Block %x is missing", hash)
+			}
+			size += block.Size()
+			blocks = append(blocks, *block)
+		}
+		return websocket.NewResponsePong(getBlocksRangeResponse{
+			Blocks:    blocks,
+			Remaining: len(following) - len(blocks),
+		}), nil
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+type competingTransaction struct {
+	ID        []byte `json:"id"`
+	Source    string `json:"source"`
+	Timestamp int64  `json:"timestamp"`
+	SeenAt    int64  `json:"seenAt"`
+	Included  bool   `json:"included"`
+	Block     []byte `json:"block,omitempty"`
+}
+
+type conflictResponse struct {
+	TransactionID []byte                 `json:"transactionId"`
+	Vout          int                    `json:"vout"`
+	Transactions  []competingTransaction `json:"transactions"`
+	Winner        []byte                 `json:"winner,omitempty"`
+}
+
+func conflictResponses(conflicts []transaction.Conflict) []conflictResponse {
+	result := []conflictResponse{}
+	for _, c := range conflicts {
+		r := conflictResponse{
+			TransactionID: c.TransactionID,
+			Vout:          c.Vout,
+			Transactions:  []competingTransaction{},
+		}
+		for _, s := range c.Spends {
+			r.Transactions = append(r.Transactions, competingTransaction{
+				ID:        s.Transaction,
+				Source:    s.Source,
+				Timestamp: s.Timestamp,
+				SeenAt:    s.SeenAt,
+				Included:  len(s.Block) > 0,
+				Block:     s.Block,
+			})
+		}
+		if winner, ok := c.Winner(); ok {
+			r.Winner = winner.Transaction
+		}
+		result = append(result, r)
+	}
+	return result
+}
+
+// GetConflicts reports the outputs the transaction with the hex encoded id
+// competed for and the transactions competing with it.
+func GetConflicts(getConflicts transaction.GetConflictsFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		id, err := hex.DecodeString(request.Vars["txid"])
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid transaction id provided"), nil
+		}
+		conflicts, err := getConflicts(id)
+		switch {
+		case err != nil:
+			return api.Response{}, errors.Wrapf(err, "Failed to retrieve conflicts of transaction %x", id)
+		case len(conflicts) == 0:
+			return api.NotFoundErrorResponse(fmt.Sprintf("Transaction %x has no conflicts", id)), nil
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   conflictResponses(conflicts),
+		}, nil
+	}
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultHeadersCount = 100
+	maxHeadersCount     = 2000
+)
+
+type headersResponse struct {
+	Height  int                       `json:"height"`
+	Headers blockchain.CompactHeaders `json:"headers"`
+}
+
+// GetHeaders serves compact headers starting at the from height so that
+// light clients can keep their own header chain.
+func GetHeaders(getHeaders blockchain.GetHeadersFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		from, ok := queryInt(request, "from", 1)
+		if !ok || from == 0 {
+			return api.InvalidDataErrorResponse("From must be a height of at least 1"), nil
+		}
+		count, ok := queryInt(request, "count", defaultHeadersCount)
+		if !ok || count == 0 || count > maxHeadersCount {
+			return api.InvalidDataErrorResponse("Count must be between 1 and 2000"), nil
+		}
+		headers, height, err := getHeaders(from, count)
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to retrieve headers")
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body: headersResponse{
+				Height:  height,
+				Headers: headers,
+			},
+		}, nil
+	}
+}
@@ -11,8 +11,8 @@ type getHeightResponse struct {
 }
 
 func GetHeightHandler(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn) websocket.Handler {
-	return func(websocket.Ping, string) (*websocket.Pong, error) {
-		height, err := blockchain.GetHeight(getTip, getBlock)
+	return func(ping websocket.Ping, _ string) (*websocket.Pong, error) {
+		height, err := blockchain.GetHeight(getTip, getBlock.Within(ping.Context()))
 		if err != nil {
 			return nil, errors.Wrap(err, "Failed to get height")
 		}
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+func GetMempool(getTransactions transaction.GetTransactionsFn) api.Handler {
+	return func(_ api.Request) (api.Response, error) {
+		txs, err := getTransactions()
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to retrieve transactions")
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   transaction.NewMempool(txs),
+		}, nil
+	}
+}
@@ -1,51 +1,47 @@
 package handlers
 
 import (
-	"bytes"
 	"encoding/json"
 	"log"
 
 	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
 	"github.com/nebser/crypto-vote/internal/pkg/websocket"
-	"github.com/pkg/errors"
 )
 
 type getMissingBlocksResponse struct {
+	Fork   []byte   `json:"fork"`
 	Blocks [][]byte `json:"blocks"`
 }
 
+// getMissingBlocksPayload describes the blockchain of the node with a block
+// locator. Nodes which send only their last block are treated as if it was
+// the whole locator.
 type getMissingBlocksPayload struct {
-	LastBlock []byte `json:"lastBlock"`
-}
-
-func getMissingBlocks(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, current []byte, final []byte) ([][]byte, error) {
-	if len(current) == 0 || bytes.Compare(current, final) == 0 {
-		return nil, nil
-	}
-	block, err := getBlock(current)
-	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to retrieve block %x", current)
-	}
-	blocks, err := getMissingBlocks(getTip, getBlock, block.Header.Prev, final)
-	if err != nil {
-		return nil, err
-	}
-	return append(blocks, current), nil
+	Locator   [][]byte `json:"locator"`
+	LastBlock []byte   `json:"lastBlock"`
 }
 
+// GetMissingBlocks answers with the latest block the node shares with the
+// blockchain and the hashes of the blocks following it.
 func GetMissingBlocks(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn) websocket.Handler {
 	return func(ping websocket.Ping, _ string) (*websocket.Pong, error) {
+		getBlock := getBlock.Within(ping.Context())
 		var payload getMissingBlocksPayload
 		if err := json.Unmarshal(ping.Body, &payload); err != nil {
 			return websocket.NewErrorPong(websocket.NewInvalidDataError(websocket.GetMissingBlocksMessage.String())), nil
 		}
-		result, err := getMissingBlocks(getTip, getBlock, getTip(), payload.LastBlock)
+		locator := payload.Locator
+		if len(locator) == 0 && len(payload.LastBlock) > 0 {
+			locator = [][]byte{payload.LastBlock}
+		}
+		fork, result, err := blockchain.FindFork(getTip, getBlock, locator)
 		if err != nil {
 			return nil, err
 		}
-		log.Printf("Num of blocks %d", len(result))
+		log.Printf("Num of blocks %d following %x", len(result), fork)
 		return websocket.NewResponsePong(
 			getMissingBlocksResponse{
+				Fork:   fork,
 				Blocks: result,
 			},
 		), nil
@@ -0,0 +1,57 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nebser/crypto-vote/internal/apps/alfa/handlers"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+)
+
+type missingBlocks struct {
+	Fork   []byte   `json:"fork"`
+	Blocks [][]byte `json:"blocks"`
+}
+
+func TestGetMissingBlocks(t *testing.T) {
+	store := newElection(t, newWallets(t, 2))
+	tip := store.GetTip()
+	base, err := store.GetBlock(tip)
+	if err != nil {
+		t.Fatalf("Failed to get tip %s", err)
+	}
+	genesis := base.Header.Prev
+	cases := []struct {
+		name     string
+		payload  map[string]interface{}
+		expected missingBlocks
+	}{
+		{"locator", map[string]interface{}{"locator": [][]byte{genesis}}, missingBlocks{genesis, [][]byte{tip}}},
+		{"legacy last block", map[string]interface{}{"lastBlock": genesis}, missingBlocks{genesis, [][]byte{tip}}},
+		{"up to date", map[string]interface{}{"lastBlock": tip}, missingBlocks{tip, nil}},
+		{"empty blockchain", map[string]interface{}{}, missingBlocks{nil, [][]byte{genesis, tip}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			body, err := json.Marshal(c.payload)
+			if err != nil {
+				t.Fatalf("Failed to marshal payload %s", err)
+			}
+			pong, err := handlers.GetMissingBlocks(store.GetTip, store.GetBlock)(websocket.Ping{Body: body}, "")
+			if err != nil {
+				t.Fatalf("Failed to get missing blocks %s", err)
+			}
+			result, err := json.Marshal(pong.Body)
+			if err != nil {
+				t.Fatalf("Failed to marshal response %s", err)
+			}
+			expected, err := json.Marshal(c.expected)
+			if err != nil {
+				t.Fatalf("Failed to marshal expected response %s", err)
+			}
+			if string(result) != string(expected) {
+				t.Errorf("Expected %s, got %s", expected, result)
+			}
+		})
+	}
+}
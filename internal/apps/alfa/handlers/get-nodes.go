@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+)
+
+type getNodesResponse struct {
+	Nodes []string `json:"nodes"`
+}
+
+func GetNodes(registeredNodes websocket.RegisteredNodesFn) websocket.Handler {
+	return func(websocket.Ping, string) (*websocket.Pong, error) {
+		return websocket.NewResponsePong(
+			getNodesResponse{
+				Nodes: registeredNodes(),
+			},
+		), nil
+	}
+}
@@ -0,0 +1,101 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/nebser/crypto-vote/internal/apps/alfa"
+	"github.com/nebser/crypto-vote/internal/apps/alfa/handlers"
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/ballot"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/network"
+	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/storage"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+)
+
+func newWallets(t *testing.T, n int) wallet.Wallets {
+	result := wallet.Wallets{}
+	for i := 0; i < n; i++ {
+		w, err := wallet.New()
+		if err != nil {
+			t.Fatalf("Failed to create wallet %s", err)
+		}
+		result = append(result, *w)
+	}
+	return result
+}
+
+// newElection initializes an election of the nodes on the memory backend.
+func newElection(t *testing.T, nodes wallet.Wallets) storage.Repository {
+	return newElectionWithVoters(t, nodes, newWallets(t, 2))
+}
+
+// newElectionWithVoters initializes an election of the nodes funding the
+// voters.
+func newElectionWithVoters(t *testing.T, nodes, voters wallet.Wallets) storage.Repository {
+	store, err := storage.NewMemory()
+	if err != nil {
+		t.Fatalf("Failed to open memory storage %s", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	master := newWallets(t, 1)[0]
+	definitions := ballot.Definitions{{Name: "President"}}
+	err = alfa.Initialize(wallet.NewSigner(master), master, nodes, voters, definitions, 1, nil, network.Production, digest.SHA256, store.AddBlock, store.SaveParty)
+	if err != nil {
+		t.Fatalf("Failed to initialize election %s", err)
+	}
+	return store
+}
+
+func TestGetPartiesOnMemoryStorage(t *testing.T) {
+	nodes := newWallets(t, 3)
+	store := newElection(t, nodes)
+
+	response, err := handlers.GetParties(store.GetParties, store.GetUTXOsByPublicKey)(api.Request{})
+	if err != nil {
+		t.Fatalf("Failed to get parties %s", err)
+	}
+	if response.Status != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, response.Status)
+	}
+	parties, ok := response.Body.(party.Parties)
+	if !ok {
+		t.Fatalf("Expected parties, got %T", response.Body)
+	}
+	if len(parties) != len(nodes) {
+		t.Fatalf("Expected %d parties, got %d", len(nodes), len(parties))
+	}
+	addresses := map[string]bool{}
+	for _, n := range nodes {
+		addresses[n.Address] = true
+	}
+	for _, p := range parties {
+		if !addresses[p.Address] {
+			t.Errorf("Unexpected party %s", p.Name)
+		}
+		if p.Balance != transaction.VoteValue {
+			t.Errorf("Expected party %s to have balance %d, got %d", p.Name, transaction.VoteValue, p.Balance)
+		}
+	}
+}
+
+func TestGetHeightOnMemoryStorage(t *testing.T) {
+	store := newElection(t, newWallets(t, 2))
+
+	pong, err := handlers.GetHeightHandler(store.GetTip, store.GetBlock)(websocket.Ping{}, "")
+	if err != nil {
+		t.Fatalf("Failed to get height %s", err)
+	}
+	body, err := json.Marshal(pong.Body)
+	if err != nil {
+		t.Fatalf("Failed to marshal response %s", err)
+	}
+	if expected := `{"height":2}`; string(body) != expected {
+		t.Errorf("Expected the genesis and the base block %s, got %s", expected, body)
+	}
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/results"
+	"github.com/pkg/errors"
+)
+
+// GetResults reports the votes every party got in the blockchain, pending
+// votes are not counted.
+func GetResults(getResults results.GetFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		r, err := getResults()
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to compute results")
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   r,
+		}, nil
+	}
+}
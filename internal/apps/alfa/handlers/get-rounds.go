@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/round"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultRoundsLimit = 50
+	maxRoundsLimit     = 500
+)
+
+type roundsResponse struct {
+	Total  int          `json:"total"`
+	Offset int          `json:"offset"`
+	Limit  int          `json:"limit"`
+	Rounds round.Rounds `json:"rounds"`
+}
+
+func queryInt(request api.Request, name string, defaultValue int) (int, bool) {
+	raw := request.Query.Get(name)
+	if raw == "" {
+		return defaultValue, true
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		return 0, false
+	}
+	return value, true
+}
+
+func GetRounds(getRounds round.GetRoundsFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		offset, ok := queryInt(request, "offset", 0)
+		if !ok {
+			return api.InvalidDataErrorResponse("Invalid offset provided"), nil
+		}
+		limit, ok := queryInt(request, "limit", defaultRoundsLimit)
+		if !ok || limit == 0 || limit > maxRoundsLimit {
+			return api.InvalidDataErrorResponse("Limit must be between 1 and 500"), nil
+		}
+		rounds, total, err := getRounds(offset, limit)
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to retrieve rounds")
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body: roundsResponse{
+				Total:  total,
+				Offset: offset,
+				Limit:  limit,
+				Rounds: rounds,
+			},
+		}, nil
+	}
+}
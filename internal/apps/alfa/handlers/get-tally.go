@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/ballot"
+	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/nebser/crypto-vote/internal/pkg/withdrawal"
+	"github.com/pkg/errors"
+)
+
+// GetTally reports the result of every question separately, in credits
+// summed per party too, together with the value a voter needs to answer all
+// of them. Withdrawn parties are marked and votes they got are left out if
+// the withdrawal voided them.
+func GetTally(getParties party.GetPartiesFn, getUTXOsByPublicKey transaction.GetUTXOsByPublicKeyFn, getWithdrawal withdrawal.GetFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		parties, err := getParties()
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to retrieve parties")
+		}
+		for i, p := range parties {
+			keyHash := wallet.ExtractPublicKeyHash(p.Address)
+			utxos, err := getUTXOsByPublicKey(keyHash)
+			if err != nil {
+				return api.Response{}, errors.Wrapf(err, "Failed to enrich party with balance %#v", p)
+			}
+			parties[i].Balance = utxos.Sum()
+			if w, ok := getWithdrawal(keyHash); ok {
+				parties[i].Withdrawn = true
+				if w.Voided() {
+					parties[i].Balance = 0
+				}
+			}
+			parties[i].Credits = parties[i].Balance / transaction.VoteValue
+		}
+		questions := ballot.Group(parties)
+		for _, q := range questions {
+			sort.Sort(sort.Reverse(q.Choices))
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body: ballot.Tally{
+				Value:     questions.Value(),
+				Questions: questions,
+			},
+		}, nil
+	}
+}
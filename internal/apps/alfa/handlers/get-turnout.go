@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/pkg/analytics"
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/pkg/errors"
+)
+
+func GetTurnout(getTurnout analytics.GetTurnoutFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		series, err := getTurnout()
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to compute turnout")
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   series,
+		}, nil
+	}
+}
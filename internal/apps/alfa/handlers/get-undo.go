@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+type undoUTXO struct {
+	TransactionID []byte `json:"transactionId"`
+	Vout          int    `json:"vout"`
+	Address       string `json:"address"`
+	Value         int    `json:"value"`
+}
+
+type undoResponse struct {
+	Block   []byte     `json:"block"`
+	Height  int        `json:"height"`
+	Spent   []undoUTXO `json:"spent"`
+	Created []undoUTXO `json:"created"`
+}
+
+func toUndoUTXOs(utxos transaction.UTXOs) []undoUTXO {
+	result := []undoUTXO{}
+	for _, u := range utxos {
+		result = append(result, undoUTXO{
+			TransactionID: u.TransactionID,
+			Vout:          u.Vout,
+			Address:       wallet.EncodeAddress(u.PublicKeyHash),
+			Value:         u.Value,
+		})
+	}
+	return result
+}
+
+// GetUndo reports the unspent outputs the block with the hex encoded hash
+// consumed and created.
+func GetUndo(getUndo blockchain.GetUndoFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		hash, err := hex.DecodeString(request.Vars["block"])
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid block hash provided"), nil
+		}
+		undo, err := getUndo(hash)
+		switch {
+		case err != nil:
+			return api.Response{}, errors.Wrapf(err, "Failed to retrieve undo record of block %x", hash)
+		case undo == nil:
+			return api.NotFoundErrorResponse(fmt.Sprintf("Block %x has no undo record", hash)), nil
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body: undoResponse{
+				Block:   undo.Block,
+				Height:  undo.Height,
+				Spent:   toUndoUTXOs(undo.Spent),
+				Created: toUndoUTXOs(undo.Created),
+			},
+		}, nil
+	}
+}
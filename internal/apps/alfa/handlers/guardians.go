@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/pkg/address"
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/redact"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+// guardianshipBody designates guardians at registration. The signature is
+// the voter's signature of the guardianship, see transaction.Guardianship.
+type guardianshipBody struct {
+	Guardians [][]byte `json:"guardians"`
+	Quorum    int      `json:"quorum"`
+	Signature []byte   `json:"signature"`
+}
+
+func (b *guardianshipBody) guardianship(voter []byte) (*transaction.Guardianship, bool) {
+	if b == nil {
+		return nil, true
+	}
+	g := transaction.Guardianship{
+		Voter:     voter,
+		Guardians: b.Guardians,
+		Quorum:    b.Quorum,
+		Signature: b.Signature,
+	}
+	return &g, g.Verified()
+}
+
+// designateGuardians puts the guardianship of a registered voter on chain.
+// The voter stays registered if it fails, so it is only logged.
+func designateGuardians(getAlgorithm digest.GetAlgorithmFn, submit transaction.SaveTransaction, g *transaction.Guardianship, voter string) []byte {
+	if g == nil {
+		return nil
+	}
+	t, err := transaction.NewGuardianshipTransaction(getAlgorithm(), *g)
+	if err == nil {
+		err = submit(*t)
+	}
+	if err != nil {
+		log.Printf("Failed to designate guardians of voter %s %s", redact.Address(voter), err)
+		return nil
+	}
+	log.Printf("Designated %d guardians of voter %s with quorum %d", len(g.Guardians), redact.Address(voter), g.Quorum)
+	return t.ID
+}
+
+type guardiansResponse struct {
+	Guardianship []byte   `json:"guardianship"`
+	Guardians    [][]byte `json:"guardians"`
+	Quorum       int      `json:"quorum"`
+}
+
+// GetGuardians reports the guardians of the voter, together with the id of
+// the guardianship the guardians refer to in a recovery.
+func GetGuardians(parseAddress address.ParseFn, findGuardianship transaction.FindGuardianshipFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		addr := request.Vars["address"]
+		voter, err := parseAddress(addr)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid address provided"), nil
+		}
+		t, found, err := findGuardianship(voter)
+		switch {
+		case err != nil:
+			return api.Response{}, errors.Wrapf(err, "Failed to find guardianship of %s", addr)
+		case !found:
+			return api.NotFoundErrorResponse("Voter has no guardians"), nil
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body: guardiansResponse{
+				Guardianship: t.ID,
+				Guardians:    t.Guardianship.Guardians,
+				Quorum:       t.Guardianship.Quorum,
+			},
+		}, nil
+	}
+}
+
+type recoveryBody struct {
+	Voter        string                          `json:"voter"`
+	NewAddress   string                          `json:"newAddress"`
+	Guardianship []byte                          `json:"guardianship"`
+	Signatures   []transaction.GuardianSignature `json:"signatures"`
+}
+
+type recoveryResponse struct {
+	Transaction []byte `json:"transaction"`
+	Value       int    `json:"value"`
+}
+
+// RecoverVote moves the unspent vote credit of a voter to a new key once a
+// quorum of the voter's guardians signed the recovery statement.
+func RecoverVote(parseAddress address.ParseFn, findTransaction transaction.FindTransactionFn, getUTXOs transaction.GetUTXOsByPublicKeyFn, getAlgorithm digest.GetAlgorithmFn, submit transaction.SaveTransaction) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		var body recoveryBody
+		if err := json.Unmarshal(request.Body, &body); err != nil {
+			return api.InvalidDataErrorResponse(""), nil
+		}
+		voter, err := parseAddress(body.Voter)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid voter address provided"), nil
+		}
+		newKey, err := parseAddress(body.NewAddress)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid new address provided"), nil
+		}
+		recovery := transaction.Recovery{
+			Statement: transaction.RecoveryStatement{
+				Guardianship: body.Guardianship,
+				Voter:        voter,
+				NewKey:       newKey,
+			},
+			Signatures: body.Signatures,
+		}
+		_, err = transaction.CheckRecovery(findTransaction, recovery)
+		switch {
+		case errors.Is(err, transaction.ErrInvalidRecovery):
+			return api.UnauthorizedErrorResponse(err.Error()), nil
+		case err != nil:
+			return api.Response{}, errors.Wrapf(err, "Failed to check recovery of %s", body.Voter)
+		}
+		utxos, err := getUTXOs(voter)
+		if err != nil {
+			return api.Response{}, errors.Wrapf(err, "Failed to retrieve utxos of %s", body.Voter)
+		}
+		t, err := transaction.NewRecoveryTransaction(getAlgorithm(), recovery, utxos)
+		switch {
+		case errors.Is(err, transaction.ErrInvalidRecovery):
+			return api.InvalidDataErrorResponse(err.Error()), nil
+		case err != nil:
+			return api.Response{}, errors.Wrap(err, "Failed to create recovery transaction")
+		}
+		if err := submit(*t); err != nil {
+			return api.Response{}, errors.Wrapf(err, "Failed to submit recovery transaction %x", t.ID)
+		}
+		log.Printf("Recovered vote credit of %s to %s", redact.Address(body.Voter), redact.Address(wallet.EncodeAddress(newKey)))
+		return api.Response{
+			Status: http.StatusAccepted,
+			Body: recoveryResponse{
+				Transaction: t.ID,
+				Value:       t.Outputs[0].Value,
+			},
+		}, nil
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/emergency"
+	"github.com/pkg/errors"
+)
+
+// GetInclusionProof proves that the transaction with the hex encoded id, the
+// one from the receipt of a vote, is in the blockchain. Receipts of votes
+// removed by a rollback of the trustees are gone.
+func GetInclusionProof(prove blockchain.ProveInclusionFn, getInvalidation emergency.GetInvalidationFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		id, err := hex.DecodeString(request.Vars["transactionId"])
+		if err != nil || len(id) == 0 {
+			return api.InvalidDataErrorResponse("Invalid transaction id provided"), nil
+		}
+		proof, found, err := prove(id)
+		switch {
+		case errors.Is(err, blockchain.ErrNoInclusionProof):
+			return api.NotFoundErrorResponse(err.Error()), nil
+		case err != nil:
+			return api.Response{}, errors.Wrapf(err, "Failed to prove inclusion of transaction %x", id)
+		case !found:
+			rollback, err := getInvalidation(id)
+			if err != nil {
+				return api.Response{}, errors.Wrapf(err, "Failed to check whether transaction %x was rolled back", id)
+			}
+			if rollback != nil {
+				return api.ReceiptInvalidated(fmt.Sprintf("Transaction %x was removed from the blockchain by rollback %x, the vote has to be cast again", id, rollback)), nil
+			}
+			return api.NotFoundErrorResponse(fmt.Sprintf("Transaction %x is not in a block yet", id)), nil
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   proof,
+		}, nil
+	}
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/finalization"
+	"github.com/pkg/errors"
+)
+
+// WhileIntakeOpen rejects requests once vote intake has been closed at the
+// end of the election.
+func WhileIntakeOpen(getState finalization.GetStateFn, h api.Handler) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		state, err := getState()
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to retrieve finalization state")
+		}
+		if state.ClosedAt != 0 {
+			return api.IntakeClosed(), nil
+		}
+		return h(request)
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/address"
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/election"
+	"github.com/nebser/crypto-vote/internal/pkg/hooks"
+	"github.com/nebser/crypto-vote/internal/pkg/kiosk"
+	"github.com/nebser/crypto-vote/internal/pkg/mempool"
+	"github.com/nebser/crypto-vote/internal/pkg/outbox"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/withdrawal"
+	"github.com/pkg/errors"
+)
+
+type kioskVoteBody struct {
+	Token     string `json:"token"`
+	Recipient string `json:"recipient"`
+}
+
+func KioskVote(parseAddress address.ParseFn, issuer []byte, findBlock blockchain.FindBlockFn, castVote kiosk.CastVoteFn, dispatch outbox.DispatchFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		var body kioskVoteBody
+		if err := json.Unmarshal(request.Body, &body); err != nil {
+			return api.InvalidDataErrorResponse(""), nil
+		}
+		if body.Token == "" {
+			body.Token = request.Query.Get("token")
+		}
+		token, err := kiosk.Decode(body.Token)
+		if err != nil {
+			return api.UnauthorizedErrorResponse(err.Error()), nil
+		}
+		if err := token.Verify(issuer, time.Now()); err != nil {
+			return api.UnauthorizedErrorResponse(err.Error()), nil
+		}
+		receiver, err := parseAddress(body.Recipient)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid recipient provided"), nil
+		}
+		criteria := func(b blockchain.Block) bool {
+			_, ok := b.Body.Transactions.FindTransactionTo(token.VoterHash)
+			return ok
+		}
+		switch _, ok, err := findBlock(criteria); {
+		case err != nil:
+			return api.Response{}, errors.Errorf("Failed to find block. Error: %s", err)
+		case !ok:
+			return api.UnauthorizedErrorResponse("Voter the token was issued for does not exist"), nil
+		}
+		tr, err := castVote(*token, receiver)
+		switch {
+		case errors.Is(err, kiosk.ErrTokenBurned):
+			return api.TokenAlreadyUsed(), nil
+		case errors.Is(err, transaction.ErrInsufficientVotes), errors.Is(err, mempool.ErrDoubleSpend), errors.Is(err, mempool.ErrDuplicate):
+			return api.UserAlreadyVoted(), nil
+		case errors.Is(err, mempool.ErrFull):
+			return api.MempoolFull(), nil
+		case errors.Is(err, withdrawal.ErrPartyWithdrawn):
+			return api.PartyWithdrawn(err.Error()), nil
+		case errors.Is(err, election.ErrElectionClosed), errors.Is(err, election.ErrOutsideWindow):
+			return api.ElectionClosed(err.Error()), nil
+		case errors.Is(err, hooks.ErrRejected):
+			return api.RejectedByPolicy(err.Error()), nil
+		case err != nil:
+			return api.Response{}, errors.Wrap(err, "Failed to cast kiosk vote")
+		}
+		log.Printf("Kiosk vote cast with token %x", token.ID)
+		if err := dispatch(); err != nil {
+			log.Printf("Failed to broadcast transaction %x, it will be retried. Error: %s", tr.ID, err)
+		}
+		return api.Response{
+			Status: http.StatusOK,
+		}, nil
+	}
+}
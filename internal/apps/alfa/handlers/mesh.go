@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/mesh"
+)
+
+func GetMesh(report func() mesh.Report) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   report(),
+		}, nil
+	}
+}
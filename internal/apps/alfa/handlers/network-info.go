@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/apps/alfa"
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/chain"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/finalization"
+	"github.com/nebser/crypto-vote/internal/pkg/network"
+	"github.com/pkg/errors"
+)
+
+// ProtocolVersion is the version of the API and websocket protocol spoken by
+// the alfa node.
+const ProtocolVersion = 1
+
+// Consensus are the parameters blocks and votes of the election are checked
+// against, they don't change while the election runs.
+type Consensus struct {
+	Network              network.Type     `json:"network"`
+	HashAlgorithm        digest.Algorithm `json:"hashAlgorithm"`
+	BlockVersion         int              `json:"blockVersion"`
+	MagicNumber          int              `json:"magicNumber"`
+	MaxBlockBytes        int              `json:"maxBlockBytes"`
+	MaxTransactionsBytes int              `json:"maxTransactionsBytes"`
+	Mix                  bool             `json:"mix"`
+	Credits              int              `json:"credits"`
+	MultiQuestion        bool             `json:"multiQuestion"`
+	StakeReturnMisses    int              `json:"stakeReturnMisses"`
+	ForgerSelection      string           `json:"forgerSelection"`
+}
+
+type schedule struct {
+	Intervals   map[string]string `json:"intervals"`
+	End         int64             `json:"end,omitempty"`
+	Drain       string            `json:"drain,omitempty"`
+	ClosedAt    int64             `json:"closedAt,omitempty"`
+	CertifiedAt int64             `json:"certifiedAt,omitempty"`
+}
+
+type networkInfoResponse struct {
+	ChainID         chain.ID  `json:"chainId"`
+	Genesis         []byte    `json:"genesis"`
+	ProtocolVersion int       `json:"protocolVersion"`
+	Consensus       Consensus `json:"consensus"`
+	Height          int       `json:"height"`
+	Tip             []byte    `json:"tip"`
+	Schedule        schedule  `json:"schedule"`
+	AlfaPublicKey   []byte    `json:"alfaPublicKey"`
+	AlfaAddress     string    `json:"alfaAddress"`
+}
+
+// GetNetworkInfo describes the deployment so clients and nodes can configure
+// themselves from a single request.
+func GetNetworkInfo(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, consensus Consensus, intervals func() alfa.Intervals, deadline *alfa.Deadline, getState finalization.GetStateFn, alfaPublicKey []byte, alfaAddress string) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		tip := getTip()
+		genesis, height, err := blockchain.GetGenesis(func() []byte { return tip }, getBlock)
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to find genesis block")
+		}
+		state, err := getState()
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to get finalization state")
+		}
+		s := schedule{
+			Intervals:   map[string]string{},
+			ClosedAt:    state.ClosedAt,
+			CertifiedAt: state.CertifiedAt,
+		}
+		for name, interval := range intervals() {
+			s.Intervals[name] = interval.String()
+		}
+		if deadline != nil {
+			s.End = deadline.End.Unix()
+			s.Drain = deadline.Drain.String()
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body: networkInfoResponse{
+				ChainID:         chain.FromGenesis(genesis),
+				Genesis:         genesis,
+				ProtocolVersion: ProtocolVersion,
+				Consensus:       consensus,
+				Height:          height,
+				Tip:             tip,
+				Schedule:        s,
+				AlfaPublicKey:   alfaPublicKey,
+				AlfaAddress:     alfaAddress,
+			},
+		}, nil
+	}
+}
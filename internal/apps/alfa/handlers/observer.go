@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/nebser/crypto-vote/internal/pkg/address"
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/observer"
+	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+// observerKeyBody issues a key to the party at the address, every scope is
+// granted if scopes are empty.
+type observerKeyBody struct {
+	Party  string   `json:"party"`
+	Scopes []string `json:"scopes"`
+}
+
+type observerKeyResponse struct {
+	Key   observer.Key `json:"key"`
+	Token string       `json:"token"`
+}
+
+func IssueObserverKey(parseAddress address.ParseFn, getParties party.GetPartiesFn, issue observer.IssueFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		var body observerKeyBody
+		if err := json.Unmarshal(request.Body, &body); err != nil {
+			return api.InvalidDataErrorResponse(""), nil
+		}
+		keyHash, err := parseAddress(body.Party)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid party address provided"), nil
+		}
+		parties, err := getParties()
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to retrieve parties")
+		}
+		p, ok := parties.FindByKeyHash(keyHash)
+		if !ok {
+			return api.InvalidDataErrorResponse("Party does not exist"), nil
+		}
+		var scopes []observer.Scope
+		for _, raw := range body.Scopes {
+			s, err := observer.ParseScope(raw)
+			if err != nil {
+				return api.InvalidDataErrorResponse(err.Error()), nil
+			}
+			scopes = append(scopes, s)
+		}
+		k, token, err := issue(p.Address, scopes)
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to issue observer key")
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   observerKeyResponse{Key: k.Public(), Token: token},
+		}, nil
+	}
+}
+
+func GetObserverKeys(list observer.ListFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		keys, err := list()
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to retrieve observer keys")
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   keys,
+		}, nil
+	}
+}
+
+func RevokeObserverKey(revoke observer.RevokeFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		k, err := revoke(request.Vars["id"])
+		switch {
+		case errors.Is(err, observer.ErrInvalidKey):
+			return api.NotFoundErrorResponse(err.Error()), nil
+		case err != nil:
+			return api.Response{}, err
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   k,
+		}, nil
+	}
+}
+
+// observerToken takes the token from a bearer authorization or from the
+// X-API-Key header.
+func observerToken(request api.Request) string {
+	if auth := request.Headers.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return request.Headers.Get("X-API-Key")
+}
+
+// Observed serves the handler made for the key of the request only if the
+// key grants the scope and its rate limit isn't exceeded.
+func Observed(authorize observer.AuthorizeFn, scope observer.Scope, h func(observer.Key) api.Handler) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		k, err := authorize(observerToken(request), scope)
+		switch {
+		case errors.Is(err, observer.ErrInvalidKey):
+			return api.UnauthorizedErrorResponse("API key is not valid"), nil
+		case errors.Is(err, observer.ErrScopeMissing):
+			return api.ScopeMissing(err.Error()), nil
+		case errors.Is(err, observer.ErrRateLimited):
+			return api.RateLimited(err.Error()), nil
+		case err != nil:
+			return api.Response{}, errors.Wrap(err, "Failed to authorize observer")
+		}
+		return h(k)(request)
+	}
+}
+
+func GetInflow(stats observer.StatsFn) func(observer.Key) api.Handler {
+	return func(k observer.Key) api.Handler {
+		return func(request api.Request) (api.Response, error) {
+			result, err := stats(wallet.ExtractPublicKeyHash(k.Party))
+			if err != nil {
+				return api.Response{}, errors.Wrapf(err, "Failed to retrieve inflow of %s", k.Party)
+			}
+			return api.Response{
+				Status: http.StatusOK,
+				Body:   result,
+			}, nil
+		}
+	}
+}
+
+func GetPartyConflicts(conflicts observer.ConflictsFn) func(observer.Key) api.Handler {
+	return func(k observer.Key) api.Handler {
+		return func(request api.Request) (api.Response, error) {
+			result, err := conflicts(wallet.ExtractPublicKeyHash(k.Party))
+			if err != nil {
+				return api.Response{}, errors.Wrapf(err, "Failed to retrieve conflicts of %s", k.Party)
+			}
+			return api.Response{
+				Status: http.StatusOK,
+				Body:   conflictResponses(result),
+			}, nil
+		}
+	}
+}
+
+// ForAnyObserver serves the same handler to every key.
+func ForAnyObserver(h api.Handler) func(observer.Key) api.Handler {
+	return func(observer.Key) api.Handler {
+		return h
+	}
+}
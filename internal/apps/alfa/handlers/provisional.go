@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/address"
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/audit"
+	"github.com/nebser/crypto-vote/internal/pkg/ballot"
+	"github.com/nebser/crypto-vote/internal/pkg/eligibility"
+	"github.com/nebser/crypto-vote/internal/pkg/finalization"
+	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/provisional"
+	"github.com/nebser/crypto-vote/internal/pkg/redact"
+	"github.com/nebser/crypto-vote/internal/pkg/registration"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+type provisionalBallotBody struct {
+	registerVoterBody
+	Recipients      []string `json:"recipients"`
+	BallotSignature string   `json:"ballotSignature"`
+}
+
+type provisionalBallotResponse struct {
+	Address string             `json:"address"`
+	Status  provisional.Status `json:"status"`
+}
+
+// SubmitProvisionalBallot quarantines the ballot of a voter the eligibility
+// provider doesn't recognize until an admin adjudicates it.
+func SubmitProvisionalBallot(parseAddress address.ParseFn, provider eligibility.Provider, getParties party.GetPartiesFn, submit provisional.SubmitFn, record audit.RecordFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		var body provisionalBallotBody
+		if err := json.Unmarshal(request.Body, &body); err != nil || body.MemberID == "" {
+			return api.InvalidDataErrorResponse(""), nil
+		}
+		rawPublicKey, err := base64.StdEncoding.DecodeString(body.PublicKey)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid public key provided"), nil
+		}
+		rawSignature, err := base64.StdEncoding.DecodeString(body.Signature)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid signature provided"), nil
+		}
+		ballotSignature, err := base64.StdEncoding.DecodeString(body.BallotSignature)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid ballot signature provided"), nil
+		}
+		recipients, err := parseAddress.ParseAll(body.Recipients)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid recipient provided"), nil
+		}
+		if !wallet.Verify(body.registerVoterBody, rawSignature, rawPublicKey) {
+			return api.UnauthorizedErrorResponse("Signature does not match the payload"), nil
+		}
+		address, err := wallet.ExtractAddress(rawPublicKey)
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to extract address")
+		}
+		parties, err := getParties()
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to retrieve parties")
+		}
+		questions := ballot.Group(parties)
+		if err := questions.Validate(recipients); err != nil {
+			return api.InvalidDataErrorResponse(err.Error()), nil
+		}
+		signable := transaction.NewBallotSignable(wallet.ExtractPublicKeyHash(address), recipients, questions.Value())
+		if !transaction.VerifySignable(signable, ballotSignature, rawPublicKey) {
+			return api.UnauthorizedErrorResponse("Ballot signature does not match the payload"), nil
+		}
+		voter := eligibility.Voter{
+			MemberID: body.MemberID,
+			Address:  address,
+		}
+		switch eligible, err := provider.IsEligible(voter); {
+		case err != nil:
+			return api.Response{}, errors.Wrapf(err, "Failed to check eligibility of %s with %s provider", body.MemberID, provider.Name())
+		case eligible:
+			return api.InvalidDataErrorResponse("Voter is eligible, register on /voters and vote on /ballot"), nil
+		}
+		err = submit(provisional.Ballot{
+			Address:     address,
+			MemberID:    body.MemberID,
+			PublicKey:   rawPublicKey,
+			Recipients:  recipients,
+			Value:       questions.Value(),
+			Signature:   ballotSignature,
+			Status:      provisional.Pending,
+			SubmittedAt: time.Now().Unix(),
+		})
+		switch {
+		case errors.Is(err, registration.ErrAlreadyRegistered):
+			return api.VoterAlreadyRegistered(), nil
+		case errors.Is(err, provisional.ErrAlreadySubmitted):
+			return api.ProvisionalBallotAlreadySubmitted(), nil
+		case err != nil:
+			return api.Response{}, errors.Wrap(err, "Failed to submit provisional ballot")
+		}
+		if err := record("provisional ballot submitted", fmt.Sprintf("address=%s member=%s", address, body.MemberID)); err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to record provisional ballot in audit log")
+		}
+		log.Printf("Received provisional ballot of %s", redact.Address(address))
+		return api.Response{
+			Status: http.StatusAccepted,
+			Body: provisionalBallotResponse{
+				Address: address,
+				Status:  provisional.Pending,
+			},
+		}, nil
+	}
+}
+
+// GetProvisionalBallots lists provisional ballots, optionally only those in
+// the status given in the status query parameter.
+func GetProvisionalBallots(getBallots provisional.GetFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		status := provisional.Status(request.Query.Get("status"))
+		switch status {
+		case "", provisional.Pending, provisional.Accepted, provisional.Rejected, provisional.Cast:
+		default:
+			return api.InvalidDataErrorResponse(fmt.Sprintf("Unknown status %s", status)), nil
+		}
+		ballots, err := getBallots(status)
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to retrieve provisional ballots")
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   ballots,
+		}, nil
+	}
+}
+
+type adjudicationBody struct {
+	Address  string `json:"address"`
+	Decision string `json:"decision"`
+	Reason   string `json:"reason"`
+}
+
+// AdjudicateProvisionalBallot accepts or rejects a pending provisional
+// ballot. Decisions can't be made once the election is finalized.
+func AdjudicateProvisionalBallot(getFinalization finalization.GetFn, accept provisional.AcceptFn, reject provisional.RejectFn, record audit.RecordFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		var body adjudicationBody
+		if err := json.Unmarshal(request.Body, &body); err != nil || body.Address == "" {
+			return api.InvalidDataErrorResponse(""), nil
+		}
+		var decide func(address, reason string) (*provisional.Ballot, error)
+		switch body.Decision {
+		case "accept":
+			decide = accept
+		case "reject":
+			decide = reject
+		default:
+			return api.InvalidDataErrorResponse("Decision must be accept or reject"), nil
+		}
+		switch f, err := getFinalization(); {
+		case err != nil:
+			return api.Response{}, errors.Wrap(err, "Failed to retrieve finalization")
+		case f != nil:
+			return api.ElectionFinalized(), nil
+		}
+		ballot, err := decide(body.Address, body.Reason)
+		switch {
+		case errors.Is(err, provisional.ErrNotFound):
+			return api.NotFoundErrorResponse(fmt.Sprintf("Provisional ballot of %s does not exist", body.Address)), nil
+		case errors.Is(err, provisional.ErrAlreadyDecided):
+			return api.ProvisionalBallotAlreadyDecided(), nil
+		case errors.Is(err, registration.ErrAlreadyRegistered):
+			return api.VoterAlreadyRegistered(), nil
+		case err != nil:
+			return api.Response{}, errors.Wrapf(err, "Failed to %s provisional ballot", body.Decision)
+		}
+		details := fmt.Sprintf("address=%s member=%s status=%s reason=%q", ballot.Address, ballot.MemberID, ballot.Status, ballot.Reason)
+		if err := record("provisional ballot "+string(ballot.Status), details); err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to record adjudication in audit log")
+		}
+		log.Printf("Provisional ballot of %s %s", redact.Address(ballot.Address), ballot.Status)
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   ballot,
+		}, nil
+	}
+}
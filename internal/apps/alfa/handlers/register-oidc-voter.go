@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/eligibility"
+	"github.com/nebser/crypto-vote/internal/pkg/oidc"
+	"github.com/nebser/crypto-vote/internal/pkg/redact"
+	"github.com/nebser/crypto-vote/internal/pkg/registration"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+type registerOIDCVoterBody struct {
+	IDToken      string            `json:"idToken"`
+	PublicKey    string            `json:"publicKey"`
+	Signature    string            `json:"signature"`
+	Guardianship *guardianshipBody `json:"guardianship,omitempty"`
+}
+
+func (r registerOIDCVoterBody) Signable() ([]byte, error) {
+	data := struct {
+		IDToken   string `json:"idToken"`
+		PublicKey string `json:"publicKey"`
+	}{
+		IDToken:   r.IDToken,
+		PublicKey: r.PublicKey,
+	}
+	return json.Marshal(data)
+}
+
+// RegisterOIDCVoter registers the voting key of a voter authenticated by the
+// identity provider. The ID token has to be requested with the address of
+// the key as the nonce, so a leaked token can't register another key. The
+// hash of the identity is checked against the eligibility roll and kept as
+// the member id; the identity itself is not stored.
+func RegisterOIDCVoter(verifier *oidc.Verifier, provider eligibility.Provider, register registration.SaveFn, getAlgorithm digest.GetAlgorithmFn, submit transaction.SaveTransaction) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		var body registerOIDCVoterBody
+		if err := json.Unmarshal(request.Body, &body); err != nil || body.IDToken == "" {
+			return api.InvalidDataErrorResponse(""), nil
+		}
+		rawPublicKey, err := base64.StdEncoding.DecodeString(body.PublicKey)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid public key provided"), nil
+		}
+		rawSignature, err := base64.StdEncoding.DecodeString(body.Signature)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid signature provided"), nil
+		}
+		if !wallet.Verify(body, rawSignature, rawPublicKey) {
+			return api.UnauthorizedErrorResponse("Signature does not match the payload"), nil
+		}
+		address, err := wallet.ExtractAddress(rawPublicKey)
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to extract address")
+		}
+		guardianship, ok := body.Guardianship.guardianship(rawPublicKey)
+		if !ok {
+			return api.InvalidDataErrorResponse("Invalid guardianship provided"), nil
+		}
+		identity, err := verifier.Verify(body.IDToken)
+		switch {
+		case errors.Is(err, oidc.ErrInvalidToken):
+			return api.UnauthorizedErrorResponse(err.Error()), nil
+		case err != nil:
+			return api.Response{}, errors.Wrapf(err, "Failed to verify identity token issued by %s", verifier.Issuer())
+		case identity.Nonce != address:
+			return api.UnauthorizedErrorResponse("Identity token is not bound to the public key"), nil
+		}
+		voter := eligibility.Voter{
+			MemberID: identity.Hash,
+			Address:  address,
+		}
+		switch eligible, err := provider.IsEligible(voter); {
+		case err != nil:
+			return api.Response{}, errors.Wrapf(err, "Failed to check eligibility of %s with %s provider", address, provider.Name())
+		case !eligible:
+			return api.VoterNotEligible(), nil
+		}
+		err = register(registration.Registration{
+			Address:      address,
+			MemberID:     identity.Hash,
+			Provider:     "oidc+" + provider.Name(),
+			RegisteredAt: time.Now().Unix(),
+		})
+		switch {
+		case errors.Is(err, registration.ErrAlreadyRegistered):
+			return api.VoterAlreadyRegistered(), nil
+		case err != nil:
+			return api.Response{}, errors.Wrap(err, "Failed to register voter")
+		}
+		log.Printf("Registered voter %s authenticated by %s", redact.Address(address), verifier.Issuer())
+		return api.Response{
+			Status: http.StatusAccepted,
+			Body: registerVoterResponse{
+				Address:      address,
+				Guardianship: designateGuardians(getAlgorithm, submit, guardianship, address),
+			},
+		}, nil
+	}
+}
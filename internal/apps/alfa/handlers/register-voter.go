@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/eligibility"
+	"github.com/nebser/crypto-vote/internal/pkg/redact"
+	"github.com/nebser/crypto-vote/internal/pkg/registration"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+type registerVoterBody struct {
+	MemberID     string            `json:"memberId"`
+	PublicKey    string            `json:"publicKey"`
+	Signature    string            `json:"signature"`
+	Guardianship *guardianshipBody `json:"guardianship,omitempty"`
+}
+
+func (r registerVoterBody) Signable() ([]byte, error) {
+	data := struct {
+		MemberID  string `json:"memberId"`
+		PublicKey string `json:"publicKey"`
+	}{
+		MemberID:  r.MemberID,
+		PublicKey: r.PublicKey,
+	}
+	return json.Marshal(data)
+}
+
+type registerVoterResponse struct {
+	Address      string `json:"address"`
+	Guardianship []byte `json:"guardianship,omitempty"`
+}
+
+func RegisterVoter(provider eligibility.Provider, register registration.SaveFn, getAlgorithm digest.GetAlgorithmFn, submit transaction.SaveTransaction) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		var body registerVoterBody
+		if err := json.Unmarshal(request.Body, &body); err != nil || body.MemberID == "" {
+			return api.InvalidDataErrorResponse(""), nil
+		}
+		rawPublicKey, err := base64.StdEncoding.DecodeString(body.PublicKey)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid public key provided"), nil
+		}
+		rawSignature, err := base64.StdEncoding.DecodeString(body.Signature)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid signature provided"), nil
+		}
+		if !wallet.Verify(body, rawSignature, rawPublicKey) {
+			return api.UnauthorizedErrorResponse("Signature does not match the payload"), nil
+		}
+		address, err := wallet.ExtractAddress(rawPublicKey)
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to extract address")
+		}
+		guardianship, ok := body.Guardianship.guardianship(rawPublicKey)
+		if !ok {
+			return api.InvalidDataErrorResponse("Invalid guardianship provided"), nil
+		}
+		voter := eligibility.Voter{
+			MemberID: body.MemberID,
+			Address:  address,
+		}
+		switch eligible, err := provider.IsEligible(voter); {
+		case err != nil:
+			return api.Response{}, errors.Wrapf(err, "Failed to check eligibility of %s with %s provider", body.MemberID, provider.Name())
+		case !eligible:
+			return api.VoterNotEligible(), nil
+		}
+		err = register(registration.Registration{
+			Address:      address,
+			MemberID:     body.MemberID,
+			Provider:     provider.Name(),
+			RegisteredAt: time.Now().Unix(),
+		})
+		switch {
+		case errors.Is(err, registration.ErrAlreadyRegistered):
+			return api.VoterAlreadyRegistered(), nil
+		case err != nil:
+			return api.Response{}, errors.Wrap(err, "Failed to register voter")
+		}
+		log.Printf("Registered voter %s", redact.Address(address))
+		return api.Response{
+			Status: http.StatusAccepted,
+			Body: registerVoterResponse{
+				Address:      address,
+				Guardianship: designateGuardians(getAlgorithm, submit, guardianship, address),
+			},
+		}, nil
+	}
+}
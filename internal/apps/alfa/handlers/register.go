@@ -3,29 +3,52 @@ package handlers
 import (
 	"encoding/json"
 
+	"github.com/nebser/crypto-vote/internal/pkg/stake"
+	"github.com/nebser/crypto-vote/internal/pkg/transport"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
 	"github.com/nebser/crypto-vote/internal/pkg/websocket"
 	"github.com/pkg/errors"
 )
 
 type registerPayload struct {
-	NodeID string `json:"nodeId"`
+	NodeID    string               `json:"nodeId"`
+	Encodings []websocket.Encoding `json:"encodings"`
 }
 
 type registerResponse struct {
-	Nodes []string `json:"nodes"`
+	Nodes    []string           `json:"nodes"`
+	Encoding websocket.Encoding `json:"encoding"`
 }
 
-func Register(hub *websocket.Hub) websocket.Handler {
+func Register(hub websocket.Registrar, findCertificate transport.FindCertificateFn, saveNode stake.SaveNodeFn) websocket.Handler {
 	return func(ping websocket.Ping, internalID string) (*websocket.Pong, error) {
 		var p registerPayload
 		if err := json.Unmarshal(ping.Body, &p); err != nil {
 			return nil, errors.Wrapf(err, "Failed to unmarshal data %s into payload", ping.Body)
 		}
+		sender, err := transport.Identity(findCertificate, ping.Sender)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to resolve sender %s", ping.Sender)
+		}
+		hashedSender, err := wallet.HashedPublicKey(sender)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to extract hashed public key")
+		}
+		if err := saveNode(p.NodeID, hashedSender); err != nil {
+			return nil, errors.Wrapf(err, "Failed to save node %s", p.NodeID)
+		}
+		address, err := wallet.ExtractAddress(sender)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to extract address")
+		}
+		hub.Identify(internalID, address)
 		nodes := hub.RegisterAtomically(internalID, p.NodeID)
+		encoding := hub.Negotiate(internalID, p.Encodings)
 		return websocket.NewResponsePong(
 			registerResponse{
-				Nodes: nodes,
+				Nodes:    nodes,
+				Encoding: encoding,
 			},
-		), nil
+		).SwitchingTo(encoding), nil
 	}
 }
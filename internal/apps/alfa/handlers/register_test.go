@@ -0,0 +1,54 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/nebser/crypto-vote/internal/apps/alfa/handlers"
+	"github.com/nebser/crypto-vote/internal/pkg/transport"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket/websockettest"
+)
+
+func noCertificates(string) (*transport.Certificate, error) {
+	return nil, nil
+}
+
+func TestRegisterAnswersWithTheNodesRegisteredBefore(t *testing.T) {
+	hub := websockettest.NewHub()
+	hub.AddNode("conn-1", "n1")
+	hub.PreferEncoding(websocket.BinaryEncoding)
+	saved := map[string][]byte{}
+	saveNode := func(nodeID string, keyHash []byte) error {
+		saved[nodeID] = keyHash
+		return nil
+	}
+	router := websocket.Router{websocket.RegisterMessage: handlers.Register(hub, noCertificates, saveNode)}
+
+	w := newWallets(t, 1)[0]
+	peer := websockettest.NewPeer("conn-2", w)
+	pong, err := peer.Send(router, websocket.RegisterMessage, map[string]interface{}{
+		"nodeId":    "n2",
+		"encodings": []websocket.Encoding{websocket.JSONEncoding, websocket.BinaryEncoding},
+	})
+	if err != nil {
+		t.Fatalf("Failed to send register message %s", err)
+	}
+	body, err := json.Marshal(pong.Body)
+	if err != nil {
+		t.Fatalf("Failed to marshal response %s", err)
+	}
+	if expected := `{"nodes":["n1"],"encoding":"binary"}`; string(body) != expected {
+		t.Errorf("Expected response %s, got %s", expected, body)
+	}
+	if nodes := hub.RegisteredNodes(); len(nodes) != 2 || nodes[1] != "n2" {
+		t.Errorf("Expected nodes n1 and n2 to be registered, got %v", nodes)
+	}
+	if key := hub.Key("conn-2"); key != w.Address {
+		t.Errorf("Expected connection to be identified as %s, got %s", w.Address, key)
+	}
+	if !bytes.Equal(saved["n2"], w.PublicKeyHash()) {
+		t.Errorf("Expected node n2 to be saved with key hash %x, got %x", w.PublicKeyHash(), saved["n2"])
+	}
+}
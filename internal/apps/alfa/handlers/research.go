@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/research"
+	"github.com/pkg/errors"
+)
+
+// researchRequestBody asks for the ballots of the election, of the implicit
+// one if election is empty. Bucket is in seconds.
+type researchRequestBody struct {
+	Election   string         `json:"election"`
+	Researcher string         `json:"researcher"`
+	Purpose    string         `json:"purpose"`
+	Bucket     int64          `json:"bucket"`
+	Noise      research.Noise `json:"noise"`
+}
+
+type researchDecisionResponse struct {
+	Request research.Request `json:"request"`
+	Token   string           `json:"token,omitempty"`
+}
+
+func SubmitResearchRequest(submit research.SubmitFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		var body researchRequestBody
+		if err := json.Unmarshal(request.Body, &body); err != nil {
+			return api.InvalidDataErrorResponse(""), nil
+		}
+		r, err := submit(research.Request{
+			Election:   body.Election,
+			Researcher: body.Researcher,
+			Purpose:    body.Purpose,
+			Bucket:     body.Bucket,
+			Noise:      body.Noise,
+		})
+		switch {
+		case errors.Is(err, research.ErrInvalidRequest):
+			return api.InvalidDataErrorResponse(err.Error()), nil
+		case err != nil:
+			return api.Response{}, errors.Wrap(err, "Failed to submit research request")
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   r.Public(),
+		}, nil
+	}
+}
+
+func GetResearchRequests(list research.ListFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		requests, err := list()
+		if err != nil {
+			return api.Response{}, errors.Wrap(err, "Failed to retrieve research requests")
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   requests,
+		}, nil
+	}
+}
+
+func DecideResearchRequest(decide research.DecideFn, approve bool) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		r, token, err := decide(request.Vars["id"], approve)
+		switch {
+		case errors.Is(err, research.ErrUnknownRequest):
+			return api.NotFoundErrorResponse(err.Error()), nil
+		case errors.Is(err, research.ErrDecided):
+			return api.InvalidDataErrorResponse(err.Error()), nil
+		case err != nil:
+			return api.Response{}, err
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   researchDecisionResponse{Request: r, Token: token},
+		}, nil
+	}
+}
+
+// ExportBallots exports the ballots of the request approved with the token
+// of the request, sent like the token of an observer key.
+func ExportBallots(authorize research.AuthorizeFn, export research.ExportFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		r, err := authorize(observerToken(request))
+		switch {
+		case errors.Is(err, research.ErrInvalidToken):
+			return api.UnauthorizedErrorResponse(err.Error()), nil
+		case err != nil:
+			return api.Response{}, errors.Wrap(err, "Failed to authorize researcher")
+		}
+		result, err := export(r)
+		if err != nil {
+			return api.Response{}, errors.Wrapf(err, "Failed to export ballots of request %s", r.ID)
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   result,
+		}, nil
+	}
+}
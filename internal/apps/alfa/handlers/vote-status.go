@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/intake"
+)
+
+// GetVoteStatus returns the ticket a vote was given when it couldn't be
+// processed right away.
+func GetVoteStatus(getTicket intake.GetTicketFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		ticket, ok := getTicket(request.Vars["id"])
+		if !ok {
+			return api.NotFoundErrorResponse("Vote with the tracking id is not known"), nil
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   ticket,
+		}, nil
+	}
+}
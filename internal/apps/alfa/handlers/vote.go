@@ -7,35 +7,53 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/nebser/crypto-vote/internal/pkg/address"
 	"github.com/nebser/crypto-vote/internal/pkg/api"
 	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/election"
+	"github.com/nebser/crypto-vote/internal/pkg/hooks"
+	"github.com/nebser/crypto-vote/internal/pkg/intake"
+	"github.com/nebser/crypto-vote/internal/pkg/mempool"
+	"github.com/nebser/crypto-vote/internal/pkg/outbox"
 	"github.com/nebser/crypto-vote/internal/pkg/transaction"
 	"github.com/nebser/crypto-vote/internal/pkg/wallet"
-	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/nebser/crypto-vote/internal/pkg/withdrawal"
 	"github.com/pkg/errors"
 )
 
-type voteBody struct {
-	Sender    string `json:"sender"`
+type allocationBody struct {
 	Recipient string `json:"recipient"`
-	Verifier  string `json:"verifier"`
-	Signature string `json:"signature"`
+	Credits   int    `json:"credits"`
+}
+
+// voteBody gives a vote to the recipient or, in elections with several
+// credits per voter, splits credits across the recipients of allocations.
+type voteBody struct {
+	Sender      string           `json:"sender"`
+	Recipient   string           `json:"recipient,omitempty"`
+	Allocations []allocationBody `json:"allocations,omitempty"`
+	Verifier    string           `json:"verifier"`
+	Signature   string           `json:"signature"`
 }
 
-func (v voteBody) Signable() ([]byte, error) {
-	data := struct {
-		Sender    string `json:"sender"`
-		Recipient string `json:"recipient"`
-		Value     int    `json:"value"`
-	}{
-		Sender:    v.Sender,
-		Recipient: v.Recipient,
-		Value:     transaction.VoteValue,
+func parseAllocations(parseAddress address.ParseFn, bodies []allocationBody) (transaction.Allocations, error) {
+	var result transaction.Allocations
+	seen := map[string]bool{}
+	for _, b := range bodies {
+		recipient, err := parseAddress(b.Recipient)
+		if err != nil {
+			return nil, errors.Errorf("Invalid recipient %s provided", b.Recipient)
+		}
+		if b.Credits < 1 || seen[string(recipient)] {
+			return nil, errors.Errorf("Invalid allocation to %s provided", b.Recipient)
+		}
+		seen[string(recipient)] = true
+		result = append(result, transaction.Allocation{Recipient: recipient, Credits: b.Credits})
 	}
-	return json.Marshal(data)
+	return result, nil
 }
 
-func Vote(findBlock blockchain.FindBlockFn, castVote transaction.CastVote, broadcast websocket.BroadcastFn) api.Handler {
+func Vote(parseAddress address.ParseFn, findBlock blockchain.FindBlockFn, castVote transaction.CastVote, castAllocations transaction.CastAllocationsFn, dispatch outbox.DispatchFn) api.Handler {
 	return func(request api.Request) (api.Response, error) {
 		var body voteBody
 		if err := json.Unmarshal(request.Body, &body); err != nil {
@@ -49,16 +67,25 @@ func Vote(findBlock blockchain.FindBlockFn, castVote transaction.CastVote, broad
 		if err != nil {
 			return api.InvalidDataErrorResponse("Invalid signature provided"), nil
 		}
-		if !wallet.Verify(body, rawSignature, rawPublicKey) {
-			return api.UnauthorizedErrorResponse("Signature does not match the payload"), nil
-		}
-		sender, err := base64.StdEncoding.DecodeString(body.Sender)
+		sender, err := parseAddress(body.Sender)
 		if err != nil {
 			return api.InvalidDataErrorResponse("Invalid sender provided"), nil
 		}
-		receiver, err := base64.StdEncoding.DecodeString(body.Recipient)
-		if err != nil {
+		var signable wallet.Signable
+		var receiver []byte
+		var allocations transaction.Allocations
+		if len(body.Allocations) > 0 {
+			if allocations, err = parseAllocations(parseAddress, body.Allocations); err != nil {
+				return api.InvalidDataErrorResponse(err.Error()), nil
+			}
+			signable = transaction.NewAllocationSignable(sender, allocations)
+		} else if receiver, err = parseAddress(body.Recipient); err != nil {
 			return api.InvalidDataErrorResponse("Invalid recipient provided"), nil
+		} else {
+			signable = transaction.NewVoteSignable(sender, receiver)
+		}
+		if !transaction.VerifySignable(signable, rawSignature, rawPublicKey) {
+			return api.UnauthorizedErrorResponse("Signature does not match the payload"), nil
 		}
 
 		criteria := func(b blockchain.Block) bool {
@@ -75,24 +102,36 @@ func Vote(findBlock blockchain.FindBlockFn, castVote transaction.CastVote, broad
 		default:
 			log.Println("Authorized successfully")
 		}
-		tr, err := castVote(sender, receiver, rawSignature, rawPublicKey)
+		var tr transaction.Transaction
+		if allocations != nil {
+			tr, err = castAllocations(sender, allocations, rawSignature, rawPublicKey)
+		} else {
+			tr, err = castVote(sender, receiver, rawSignature, rawPublicKey)
+		}
 		switch {
-		case err != nil && errors.Is(err, transaction.ErrInsufficientVotes):
+		case errors.Is(err, transaction.ErrInsufficientVotes), errors.Is(err, transaction.ErrCreditsExceeded), errors.Is(err, mempool.ErrDoubleSpend), errors.Is(err, mempool.ErrDuplicate):
 			return api.UserAlreadyVoted(), nil
+		case errors.Is(err, mempool.ErrFull):
+			return api.MempoolFull(), nil
+		case errors.Is(err, withdrawal.ErrPartyWithdrawn):
+			return api.PartyWithdrawn(err.Error()), nil
+		case errors.Is(err, election.ErrElectionClosed), errors.Is(err, election.ErrOutsideWindow):
+			return api.ElectionClosed(err.Error()), nil
+		case errors.Is(err, hooks.ErrRejected):
+			return api.RejectedByPolicy(err.Error()), nil
 		case err != nil:
 			log.Printf("Error occurred while voting %s", err)
 			return api.Response{}, nil
 		}
 		log.Println("VOTED SUCCESSFULLY")
-		broadcast(websocket.Pong{
-			Message: websocket.TransactionReceivedMessage,
-			Body: websocket.SaveTransactionBody{
-				Transaction: tr,
-			},
-		})
-		log.Println("BROADCASTED SUCCESSFULLY")
+		if err := dispatch(); err != nil {
+			log.Printf("Failed to broadcast transaction %x, it will be retried. Error: %s", tr.ID, err)
+		} else {
+			log.Println("BROADCASTED SUCCESSFULLY")
+		}
 		return api.Response{
 			Status: http.StatusOK,
+			Body:   intake.Receipt{Transaction: tr.ID},
 		}, nil
 	}
 }
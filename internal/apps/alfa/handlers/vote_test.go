@@ -0,0 +1,107 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/apps/alfa"
+	"github.com/nebser/crypto-vote/internal/apps/alfa/handlers"
+	"github.com/nebser/crypto-vote/internal/pkg/address"
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/intake"
+	"github.com/nebser/crypto-vote/internal/pkg/mempool"
+	"github.com/nebser/crypto-vote/internal/pkg/outbox"
+	"github.com/nebser/crypto-vote/internal/pkg/repository"
+	"github.com/nebser/crypto-vote/internal/pkg/storage"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket/websockettest"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func voteRequest(t *testing.T, voter, party wallet.Wallet) api.Request {
+	signature, err := wallet.Sign(transaction.NewVoteSignable(voter.PublicKeyHash(), party.PublicKeyHash()), voter.PrivateKey)
+	if err != nil {
+		t.Fatalf("Failed to sign vote %s", err)
+	}
+	body, err := json.Marshal(map[string]string{
+		"sender":    voter.Address,
+		"recipient": party.Address,
+		"verifier":  base64.StdEncoding.EncodeToString(voter.PublicKey),
+		"signature": base64.StdEncoding.EncodeToString(signature),
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal vote %s", err)
+	}
+	return api.Request{Body: body}
+}
+
+func TestVoteIsBroadcastOnce(t *testing.T) {
+	nodes, voters := newWallets(t, 2), newWallets(t, 1)
+	store := newElectionWithVoters(t, nodes, voters)
+	db, err := storage.DB(store)
+	if err != nil {
+		t.Fatalf("Failed to get database %s", err)
+	}
+	hub := websockettest.NewHub()
+	hub.AddNode("conn-1", "n1")
+	pool, err := mempool.Load(store.GetTransactions, mempool.Options{}, func(transaction.Transaction) bool { return false })
+	if err != nil {
+		t.Fatalf("Failed to load mempool %s", err)
+	}
+	vote := handlers.Vote(
+		address.Parser(time.Time{}),
+		blockchain.FindBlock(store.GetTip, store.GetBlock),
+		repository.CastVote(db, transaction.KeepOutputsOrder, pool.Reserve(), pool.Release),
+		repository.CastAllocations(db, transaction.KeepOutputsOrder, pool.Reserve(), pool.Release),
+		outbox.DispatchFn(alfa.OutboxDispatcher(repository.GetPendingBroadcasts(db), repository.RemoveBroadcast(db), hub.Broadcast, 100)),
+	)
+
+	response, err := vote(voteRequest(t, voters[0], nodes[0]))
+	if err != nil {
+		t.Fatalf("Failed to vote %s", err)
+	}
+	receipt, ok := response.Body.(intake.Receipt)
+	if response.Status != http.StatusOK || !ok {
+		t.Fatalf("Expected status %d with a receipt, got %d %#v", http.StatusOK, response.Status, response.Body)
+	}
+	broadcasts := hub.Broadcasts()
+	if len(broadcasts) != 1 || broadcasts[0].Message != websocket.TransactionReceivedMessage {
+		t.Fatalf("Expected the vote to be broadcast, got %v", broadcasts)
+	}
+	raw, err := json.Marshal(broadcasts[0].Body)
+	if err != nil {
+		t.Fatalf("Failed to marshal broadcast %s", err)
+	}
+	var body websocket.SaveTransactionBody
+	if err := json.Unmarshal(raw, &body); err != nil {
+		t.Fatalf("Failed to unmarshal broadcast %s", err)
+	}
+	if !bytes.Equal(body.Transaction.ID, receipt.Transaction) {
+		t.Errorf("Expected transaction %x to be broadcast, got %x", receipt.Transaction, body.Transaction.ID)
+	}
+
+	response, err = vote(voteRequest(t, voters[0], nodes[1]))
+	if err != nil {
+		t.Fatalf("Failed to vote again %s", err)
+	}
+	if response.Status != http.StatusConflict {
+		t.Errorf("Expected the second vote to be refused with %d, got %d", http.StatusConflict, response.Status)
+	}
+	if broadcasts := hub.Broadcasts(); len(broadcasts) != 1 {
+		t.Errorf("Expected only the first vote to be broadcast, got %d broadcasts", len(broadcasts))
+	}
+}
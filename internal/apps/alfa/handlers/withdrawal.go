@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/pkg/address"
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/withdrawal"
+	"github.com/pkg/errors"
+)
+
+// withdrawalBody withdraws the party at the address. Prior votes follow the
+// rule of the election when prior is empty.
+type withdrawalBody struct {
+	Party  string                 `json:"party"`
+	Prior  transaction.PriorVotes `json:"prior"`
+	Reason string                 `json:"reason"`
+}
+
+func WithdrawParty(parseAddress address.ParseFn, prior transaction.PriorVotes, submit withdrawal.SubmitFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		var body withdrawalBody
+		if err := json.Unmarshal(request.Body, &body); err != nil {
+			return api.InvalidDataErrorResponse(""), nil
+		}
+		party, err := parseAddress(body.Party)
+		if err != nil {
+			return api.InvalidDataErrorResponse("Invalid party address provided"), nil
+		}
+		if body.Prior == "" {
+			body.Prior = prior
+		}
+		w, err := submit(party, body.Prior, body.Reason)
+		switch {
+		case errors.Is(err, withdrawal.ErrInvalidWithdrawal):
+			return api.InvalidDataErrorResponse(err.Error()), nil
+		case err != nil:
+			return api.Response{}, errors.Wrap(err, "Failed to withdraw party")
+		}
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   w,
+		}, nil
+	}
+}
+
+func GetWithdrawals(list withdrawal.ListFn) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		return api.Response{
+			Status: http.StatusOK,
+			Body:   list(),
+		}, nil
+	}
+}
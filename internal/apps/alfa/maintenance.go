@@ -0,0 +1,103 @@
+package alfa
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/pkg/errors"
+)
+
+// Maintenance refuses API requests while the database is being maintained.
+// Requests in progress are waited for before maintenance starts.
+type Maintenance struct {
+	lock     sync.Mutex
+	active   bool
+	inFlight int
+	drained  chan struct{}
+}
+
+func NewMaintenance() *Maintenance {
+	return &Maintenance{}
+}
+
+func (m *Maintenance) enter() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.active {
+		return false
+	}
+	m.inFlight++
+	return true
+}
+
+func (m *Maintenance) leave() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.inFlight--
+	if m.inFlight == 0 && m.drained != nil {
+		close(m.drained)
+		m.drained = nil
+	}
+}
+
+// Handler refuses requests to h during maintenance. Exempt paths, e.g.
+// long lived streams, are always served and not waited for.
+func (m *Maintenance) Handler(h http.Handler, exempt ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, path := range exempt {
+			if r.URL.Path == path {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		if !m.enter() {
+			res := api.UnderMaintenance()
+			w.WriteHeader(res.Status)
+			json.NewEncoder(w).Encode(res.Body)
+			return
+		}
+		defer m.leave()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Begin starts refusing requests and waits up to timeout for the ones in
+// progress to finish. Maintenance isn't started if they don't.
+func (m *Maintenance) Begin(timeout time.Duration) error {
+	m.lock.Lock()
+	if m.active {
+		m.lock.Unlock()
+		return errors.New("Maintenance is already in progress")
+	}
+	m.active = true
+	if m.inFlight == 0 {
+		m.lock.Unlock()
+		return nil
+	}
+	drained := make(chan struct{})
+	m.drained = drained
+	m.lock.Unlock()
+	select {
+	case <-drained:
+		return nil
+	case <-time.After(timeout):
+		m.End()
+		return errors.Errorf("Requests in progress did not finish within %s", timeout)
+	}
+}
+
+func (m *Maintenance) End() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.active = false
+	m.drained = nil
+}
+
+func (m *Maintenance) Active() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.active
+}
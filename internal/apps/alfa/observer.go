@@ -0,0 +1,37 @@
+package alfa
+
+import (
+	"fmt"
+
+	"github.com/nebser/crypto-vote/internal/pkg/audit"
+	"github.com/nebser/crypto-vote/internal/pkg/observer"
+	"github.com/pkg/errors"
+)
+
+// ObserverKeyIssuer records every key issued to a party observer in the
+// audit log, the token itself is never recorded.
+func ObserverKeyIssuer(issue observer.IssueFn, record audit.RecordFn) observer.IssueFn {
+	return func(party string, scopes []observer.Scope) (observer.Key, string, error) {
+		k, token, err := issue(party, scopes)
+		if err != nil {
+			return observer.Key{}, "", err
+		}
+		if err := record("observer key issued", fmt.Sprintf("key=%s party=%s scopes=%v", k.ID, k.Party, k.Scopes)); err != nil {
+			return observer.Key{}, "", errors.Wrap(err, "Failed to record observer key in audit log")
+		}
+		return k, token, nil
+	}
+}
+
+func ObserverKeyRevoker(revoke observer.RevokeFn, record audit.RecordFn) observer.RevokeFn {
+	return func(id string) (observer.Key, error) {
+		k, err := revoke(id)
+		if err != nil {
+			return observer.Key{}, err
+		}
+		if err := record("observer key revoked", fmt.Sprintf("key=%s party=%s", k.ID, k.Party)); err != nil {
+			return observer.Key{}, errors.Wrap(err, "Failed to record revocation in audit log")
+		}
+		return k, nil
+	}
+}
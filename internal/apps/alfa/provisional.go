@@ -0,0 +1,45 @@
+package alfa
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/nebser/crypto-vote/internal/pkg/audit"
+	"github.com/nebser/crypto-vote/internal/pkg/finalization"
+	"github.com/nebser/crypto-vote/internal/pkg/provisional"
+	"github.com/nebser/crypto-vote/internal/pkg/redact"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+// ProvisionalCaster converts accepted provisional ballots to regular
+// transactions once their voters have been funded.
+func ProvisionalCaster(getFinalization finalization.GetFn, getBallots provisional.GetFn, cast provisional.CastFn, record audit.RecordFn) RunnerFn {
+	return func() error {
+		switch f, err := getFinalization(); {
+		case err != nil:
+			return errors.Wrap(err, "Failed to retrieve finalization")
+		case f != nil:
+			return nil
+		}
+		ballots, err := getBallots(provisional.Accepted)
+		if err != nil {
+			return errors.Wrap(err, "Failed to retrieve accepted provisional ballots")
+		}
+		for _, b := range ballots {
+			cast, err := cast(b.Address)
+			switch {
+			case errors.Is(err, transaction.ErrInsufficientVotes):
+				continue
+			case err != nil:
+				return errors.Wrapf(err, "Failed to cast provisional ballot of %s", redact.Address(b.Address))
+			}
+			details := fmt.Sprintf("address=%s member=%s transaction=%x", cast.Address, cast.MemberID, cast.Transaction)
+			if err := record("provisional ballot cast", details); err != nil {
+				return errors.Wrap(err, "Failed to record provisional ballot in audit log")
+			}
+			log.Printf("Cast provisional ballot of %s with transaction %x", redact.Address(cast.Address), cast.Transaction)
+		}
+		return nil
+	}
+}
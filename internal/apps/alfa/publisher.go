@@ -0,0 +1,199 @@
+package alfa
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/events"
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
+	"github.com/nebser/crypto-vote/internal/pkg/publish"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+var (
+	publications       = metrics.NewCounter("publications_total", "Number of times the static artifacts were published")
+	publicationsFailed = metrics.NewCounter("publications_failed_total", "Number of failed publications of the static artifacts")
+)
+
+// Headers are published in pages of the most headers the API serves at
+// once. Complete pages never change, so they are published only once.
+const headersPage = 2000
+
+// Artifact is a public read published as a static file.
+type Artifact struct {
+	Name string
+	Read api.Handler
+}
+
+// Manifest is published last and lists every published file with the
+// digest of its body, so mirrors can tell whether they are up to date.
+type Manifest struct {
+	Height      int               `json:"height"`
+	Tip         []byte            `json:"tip"`
+	PublishedAt int64             `json:"publishedAt"`
+	Files       map[string]string `json:"files"`
+}
+
+// Publisher writes signed static copies of public reads to a store after
+// every block. Every file has a signature in a file with the .sig suffix.
+// Blocks added while a publication runs are covered by a single next one.
+type Publisher struct {
+	store     publish.Store
+	signer    wallet.Signer
+	artifacts []Artifact
+	headers   api.Handler
+	getTip    blockchain.GetTipFn
+	pending   chan struct{}
+	complete  int
+	files     map[string]string
+}
+
+func NewPublisher(store publish.Store, signer wallet.Signer, artifacts []Artifact, headers api.Handler, getTip blockchain.GetTipFn) *Publisher {
+	return &Publisher{
+		store:     store,
+		signer:    signer,
+		artifacts: artifacts,
+		headers:   headers,
+		getTip:    getTip,
+		pending:   make(chan struct{}, 1),
+		files:     map[string]string{},
+	}
+}
+
+// Start publishes the current state and then follows the blocks on feed.
+func (p *Publisher) Start(feed *events.Feed) {
+	subscription := feed.Subscribe(events.Filter{})
+	go func() {
+		for e := range subscription.C {
+			if e.Type == events.BlockEvent {
+				p.notify()
+			}
+		}
+	}()
+	go func() {
+		for range p.pending {
+			if err := p.publish(); err != nil {
+				publicationsFailed.Inc()
+				log.Printf("Failed to publish to %s %s", p.store.Name(), err)
+				continue
+			}
+			publications.Inc()
+		}
+	}()
+	p.notify()
+}
+
+func (p *Publisher) notify() {
+	select {
+	case p.pending <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Publisher) put(name string, body []byte) (api.Signature, error) {
+	signature, err := api.SignBody(p.signer, body)
+	if err != nil {
+		return api.Signature{}, errors.Wrapf(err, "Failed to sign %s", name)
+	}
+	rawSignature, err := json.Marshal(signature)
+	if err != nil {
+		return api.Signature{}, errors.Wrapf(err, "Failed to marshal signature of %s", name)
+	}
+	if err := p.store.Put(name, body); err != nil {
+		return api.Signature{}, err
+	}
+	if err := p.store.Put(name+".sig", rawSignature); err != nil {
+		return api.Signature{}, err
+	}
+	return signature, nil
+}
+
+func (p *Publisher) putListed(name string, body []byte) error {
+	signature, err := p.put(name, body)
+	if err != nil {
+		return err
+	}
+	p.files[name] = signature.Digest
+	return nil
+}
+
+func read(h api.Handler, query url.Values) ([]byte, error) {
+	response, err := h(api.Request{Query: query, Vars: map[string]string{}})
+	if err != nil {
+		return nil, err
+	}
+	if response.Status != http.StatusOK {
+		return nil, errors.Errorf("Read responded with status %d", response.Status)
+	}
+	return json.Marshal(response.Body)
+}
+
+type publishedHeaders struct {
+	Height  int               `json:"height"`
+	Headers []json.RawMessage `json:"headers"`
+}
+
+// publishHeaders publishes the pages of headers which changed since the
+// last publication and returns the height of the blockchain.
+func (p *Publisher) publishHeaders() (int, error) {
+	for from := p.complete*headersPage + 1; ; from += headersPage {
+		query := url.Values{
+			"from":  []string{strconv.Itoa(from)},
+			"count": []string{strconv.Itoa(headersPage)},
+		}
+		body, err := read(p.headers, query)
+		if err != nil {
+			return 0, errors.Wrapf(err, "Failed to read headers from %d", from)
+		}
+		var page publishedHeaders
+		if err := json.Unmarshal(body, &page); err != nil {
+			return 0, errors.Wrapf(err, "Failed to unmarshal headers from %d", from)
+		}
+		if len(page.Headers) == 0 {
+			return page.Height, nil
+		}
+		if err := p.putListed(fmt.Sprintf("headers/%d.json", from), body); err != nil {
+			return 0, err
+		}
+		if len(page.Headers) < headersPage {
+			return page.Height, nil
+		}
+		p.complete++
+	}
+}
+
+func (p *Publisher) publish() error {
+	tip := p.getTip()
+	for _, a := range p.artifacts {
+		body, err := read(a.Read, url.Values{})
+		if err != nil {
+			return errors.Wrapf(err, "Failed to read %s", a.Name)
+		}
+		if err := p.putListed(a.Name, body); err != nil {
+			return err
+		}
+	}
+	height, err := p.publishHeaders()
+	if err != nil {
+		return err
+	}
+	manifest, err := json.Marshal(Manifest{
+		Height:      height,
+		Tip:         tip,
+		PublishedAt: time.Now().Unix(),
+		Files:       p.files,
+	})
+	if err != nil {
+		return errors.Wrap(err, "Failed to marshal manifest")
+	}
+	_, err = p.put("latest.json", manifest)
+	return err
+}
@@ -0,0 +1,113 @@
+package alfa
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/registration"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+func outpoint(id []byte, vout int) string {
+	return fmt.Sprintf("%x:%d", id, vout)
+}
+
+// spendableUTXOs returns utxos of the wallet which no pending transaction
+// spends and which don't hold stakes of the forgers, i.e. utxos created by
+// transactions the wallet paid for itself.
+func spendableUTXOs(w wallet.Wallet, getUTXOs transaction.GetUTXOsByPublicKeyFn, getTransactions transaction.GetTransactionsFn, findBlock blockchain.FindBlockFn) (transaction.UTXOs, error) {
+	pending, err := getTransactions()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to retrieve pending transactions")
+	}
+	spent := map[string]bool{}
+	for _, t := range pending {
+		for _, in := range t.Inputs {
+			spent[outpoint(in.TransactionID, in.Vout)] = true
+		}
+	}
+	utxos, err := getUTXOs(w.PublicKeyHash())
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to retrieve utxos")
+	}
+	result := transaction.UTXOs{}
+	for _, utxo := range utxos {
+		if spent[outpoint(utxo.TransactionID, utxo.Vout)] {
+			continue
+		}
+		var origin transaction.Transaction
+		_, ok, err := findBlock(func(b blockchain.Block) bool {
+			t, found := b.Body.Transactions.Find(func(t transaction.Transaction) bool {
+				return bytes.Equal(t.ID, utxo.TransactionID)
+			})
+			origin = t
+			return found
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to find transaction %x", utxo.TransactionID)
+		}
+		if ok && origin.AreInputsFrom(w.PublicKeyHash()) {
+			result = append(result, utxo)
+		}
+	}
+	return result, nil
+}
+
+func Registrar(
+	getPending registration.GetPendingFn,
+	getUTXOs transaction.GetUTXOsByPublicKeyFn,
+	getTransactions transaction.GetTransactionsFn,
+	findBlock blockchain.FindBlockFn,
+	getAlgorithm digest.GetAlgorithmFn,
+	signer wallet.Signer,
+	w wallet.Wallet,
+	fund registration.FundFn,
+	value int,
+	batchSize int,
+) RunnerFn {
+	return func() error {
+		pending, err := getPending(batchSize)
+		if err != nil {
+			return errors.Wrap(err, "Failed to retrieve pending registrations")
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		utxos, err := spendableUTXOs(w, getUTXOs, getTransactions, findBlock)
+		if err != nil {
+			return err
+		}
+		used := transaction.UTXOs{}
+		for _, utxo := range utxos {
+			if used.Sum() >= len(pending)*value {
+				break
+			}
+			used = append(used, utxo)
+		}
+		funded := used.Sum() / value
+		if funded == 0 {
+			return errors.Errorf("No funds available for %d pending registrations", len(pending))
+		}
+		if funded < len(pending) {
+			pending = pending[:funded]
+		}
+		recipients := [][]byte{}
+		for _, r := range pending {
+			recipients = append(recipients, wallet.ExtractPublicKeyHash(r.Address))
+		}
+		t, err := transaction.NewFundingTransaction(getAlgorithm(), signer, w, used, recipients, value)
+		if err != nil {
+			return errors.Wrap(err, "Failed to create funding transaction")
+		}
+		if err := fund(*t, pending); err != nil {
+			return errors.Wrap(err, "Failed to fund registrations")
+		}
+		log.Printf("Funded %d registered voters with transaction %x", len(pending), t.ID)
+		return nil
+	}
+}
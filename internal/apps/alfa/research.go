@@ -0,0 +1,66 @@
+package alfa
+
+import (
+	"fmt"
+
+	"github.com/nebser/crypto-vote/internal/pkg/audit"
+	"github.com/nebser/crypto-vote/internal/pkg/research"
+	"github.com/pkg/errors"
+)
+
+func electionName(id string) string {
+	if id == "" {
+		return "implicit"
+	}
+	return id
+}
+
+// ResearchSubmitter records every request for the ballots of an election in
+// the audit log.
+func ResearchSubmitter(submit research.SubmitFn, record audit.RecordFn) research.SubmitFn {
+	return func(r research.Request) (research.Request, error) {
+		r, err := submit(r)
+		if err != nil {
+			return research.Request{}, err
+		}
+		details := fmt.Sprintf("request=%s election=%s researcher=%q bucket=%d epsilon=%g/%g", r.ID, electionName(r.Election), r.Researcher, r.Bucket, r.Noise.Choice, r.Noise.Precinct)
+		if err := record("research access requested", details); err != nil {
+			return research.Request{}, errors.Wrap(err, "Failed to record research request in audit log")
+		}
+		return r, nil
+	}
+}
+
+// ResearchDecider records approvals and rejections in the audit log, the
+// token itself is never recorded.
+func ResearchDecider(decide research.DecideFn, record audit.RecordFn) research.DecideFn {
+	return func(id string, approve bool) (research.Request, string, error) {
+		r, token, err := decide(id, approve)
+		if err != nil {
+			return research.Request{}, "", err
+		}
+		action := "research access rejected"
+		if approve {
+			action = "research access approved"
+		}
+		if err := record(action, fmt.Sprintf("request=%s election=%s researcher=%q", r.ID, electionName(r.Election), r.Researcher)); err != nil {
+			return research.Request{}, "", errors.Wrap(err, "Failed to record research decision in audit log")
+		}
+		return r, token, nil
+	}
+}
+
+// ResearchExporter records every export in the audit log before the
+// ballots are handed out.
+func ResearchExporter(export research.ExportFn, record audit.RecordFn) research.ExportFn {
+	return func(r research.Request) (research.Export, error) {
+		result, err := export(r)
+		if err != nil {
+			return research.Export{}, err
+		}
+		if err := record("research export", fmt.Sprintf("request=%s election=%s ballots=%d", r.ID, electionName(r.Election), len(result.Ballots))); err != nil {
+			return research.Export{}, errors.Wrap(err, "Failed to record research export in audit log")
+		}
+		return result, nil
+	}
+}
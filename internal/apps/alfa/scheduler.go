@@ -0,0 +1,202 @@
+package alfa
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/audit"
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	ForgingJob      = "forging"
+	CleaningJob     = "cleaning"
+	OutboxJob       = "outbox"
+	AnchoringJob    = "anchoring"
+	RegistrationJob = "registration"
+	StakeJob        = "stake"
+	ProvisionalJob  = "provisional"
+	FinalizationJob = "finalization"
+	CompactionJob   = "compaction"
+	MempoolJob      = "mempool"
+	PeerExchangeJob = "peer-exchange"
+	ClockJob        = "clock"
+)
+
+type Intervals map[string]time.Duration
+
+func (i Intervals) String() string {
+	names := []string{}
+	for name := range i {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := []string{}
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%s", name, i[name]))
+	}
+	return strings.Join(parts, ",")
+}
+
+// ReadIntervals loads job intervals from a JSON file mapping job names to
+// durations, e.g. {"forging": "30s", "cleaning": "1m"}.
+func ReadIntervals(path string) (Intervals, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read schedule file %s", path)
+	}
+	var durations map[string]string
+	if err := json.Unmarshal(raw, &durations); err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse schedule file %s", path)
+	}
+	result := Intervals{}
+	for name, value := range durations {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid interval %s for job %s", value, name)
+		}
+		if d <= 0 {
+			return nil, errors.Errorf("Interval of job %s has to be positive", name)
+		}
+		result[name] = d
+	}
+	return result, nil
+}
+
+type scheduledJob struct {
+	interval time.Duration
+	job      cron.Job
+}
+
+type Scheduler struct {
+	lock   *sync.Mutex
+	cron   *cron.Cron
+	jobs   map[string]scheduledJob
+	record audit.RecordFn
+}
+
+func NewScheduler(record audit.RecordFn) *Scheduler {
+	return &Scheduler{
+		lock:   &sync.Mutex{},
+		jobs:   make(map[string]scheduledJob),
+		record: record,
+	}
+}
+
+func (s *Scheduler) Add(name string, interval time.Duration, job cron.Job) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.jobs[name] = scheduledJob{interval: interval, job: job}
+}
+
+func (s *Scheduler) Intervals() Intervals {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.intervals()
+}
+
+func (s *Scheduler) Start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.cron != nil {
+		return nil
+	}
+	s.start()
+	return s.audit("scheduler started", s.intervals())
+}
+
+// Stop prevents new job runs and waits for the ones in progress to finish.
+func (s *Scheduler) Stop() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.cron == nil {
+		return nil
+	}
+	s.stop()
+	return s.audit("scheduler stopped", s.intervals())
+}
+
+// Shutdown stops the scheduler for good, like Stop it prevents new job runs
+// but waits for the ones in progress only until ctx is done.
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.cron == nil {
+		return nil
+	}
+	done := s.cron.Stop()
+	s.cron = nil
+	select {
+	case <-done.Done():
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "Failed to wait for running jobs")
+	}
+	return s.audit("scheduler stopped", s.intervals())
+}
+
+// Reschedule stops all jobs, applies the new intervals and starts the jobs
+// again. Jobs not mentioned in intervals keep their current interval.
+func (s *Scheduler) Reschedule(intervals Intervals) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for name := range intervals {
+		if _, ok := s.jobs[name]; !ok {
+			return errors.Errorf("Unknown job %s", name)
+		}
+	}
+	changed := Intervals{}
+	for name, interval := range intervals {
+		if j := s.jobs[name]; j.interval != interval {
+			j.interval = interval
+			s.jobs[name] = j
+			changed[name] = interval
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	running := s.cron != nil
+	if running {
+		s.stop()
+		s.start()
+	}
+	return s.audit("schedule changed", changed)
+}
+
+func (s *Scheduler) start() {
+	c := cron.New()
+	for _, j := range s.jobs {
+		c.Schedule(cron.Every(j.interval), j.job)
+	}
+	c.Start()
+	s.cron = c
+}
+
+func (s *Scheduler) stop() {
+	<-s.cron.Stop().Done()
+	s.cron = nil
+}
+
+func (s *Scheduler) intervals() Intervals {
+	result := Intervals{}
+	for name, j := range s.jobs {
+		result[name] = j.interval
+	}
+	return result
+}
+
+func (s *Scheduler) audit(action string, intervals Intervals) error {
+	log.Printf("Scheduler: %s %s", action, intervals)
+	if err := s.record(action, intervals.String()); err != nil {
+		return errors.Wrapf(err, "Failed to record %s in audit log", action)
+	}
+	return nil
+}
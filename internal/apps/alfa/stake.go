@@ -0,0 +1,259 @@
+package alfa
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/nebser/crypto-vote/internal/pkg/audit"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/fraud"
+	"github.com/nebser/crypto-vote/internal/pkg/round"
+	"github.com/nebser/crypto-vote/internal/pkg/stake"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+const missedRoundsWindow = 1000
+
+func isPendingSpend(pending transaction.Transactions, id []byte, vout int) bool {
+	for _, t := range pending {
+		for _, in := range t.Inputs {
+			if bytes.Equal(in.TransactionID, id) && in.Vout == vout {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// StakeReleaser returns the stakes of a node that are neither spent on chain
+// nor being returned by a pending transaction. Return transactions are
+// broadcasted through the outbox. Stakes of slashed nodes and burned stakes
+// are forfeited.
+func StakeReleaser(
+	findBlock blockchain.FindBlockFn,
+	getTransactionUTXO transaction.GetTransactionUTXO,
+	getTransactions transaction.GetTransactionsFn,
+	newReturnStakeTransaction transaction.NewReturnStakeTransactionFn,
+	submitTransaction transaction.SaveTransaction,
+	alfaKeyHash []byte,
+	isSlashed fraud.IsSlashedFn,
+	isBurned stake.IsBurnedFn,
+	record audit.RecordFn,
+) stake.ReleaseFn {
+	lock := &sync.Mutex{}
+	return func(stakeholder []byte) (int, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch slashed, err := isSlashed(stakeholder); {
+		case err != nil:
+			return 0, errors.Wrapf(err, "Failed to check whether %x is slashed", stakeholder)
+		case slashed:
+			log.Printf("Stakes of %x are forfeited for fraud", stakeholder)
+			return 0, nil
+		}
+		var stakes transaction.Transactions
+		_, _, err := findBlock(func(b blockchain.Block) bool {
+			for _, t := range b.Body.Transactions {
+				if _, ok := transaction.StakeOutput(t, alfaKeyHash); ok && t.AreInputsFrom(stakeholder) {
+					stakes = append(stakes, t)
+				}
+			}
+			return false
+		})
+		if err != nil {
+			return 0, errors.Wrap(err, "Failed to find stake transactions")
+		}
+		pending, err := getTransactions()
+		if err != nil {
+			return 0, errors.Wrap(err, "Failed to retrieve pending transactions")
+		}
+		released := 0
+		for _, t := range stakes {
+			vout, _ := transaction.StakeOutput(t, alfaKeyHash)
+			utxo, err := getTransactionUTXO(t.ID, vout)
+			if err != nil {
+				return released, errors.Wrapf(err, "Failed to retrieve utxo of stake %x", t.ID)
+			}
+			if utxo == nil || isPendingSpend(pending, t.ID, vout) {
+				continue
+			}
+			switch burned, err := isBurned(t.ID); {
+			case err != nil:
+				return released, errors.Wrapf(err, "Failed to check whether stake %x is burned", t.ID)
+			case burned:
+				continue
+			}
+			returned, err := newReturnStakeTransaction(t)
+			if err != nil {
+				return released, errors.Wrapf(err, "Failed to create return of stake %x", t.ID)
+			}
+			if err := submitTransaction(*returned); err != nil {
+				return released, errors.Wrapf(err, "Failed to submit return of stake %x", t.ID)
+			}
+			if err := record("stake-return", fmt.Sprintf("stakeholder=%x stake=%x return=%x", stakeholder, t.ID, returned.ID)); err != nil {
+				log.Printf("Failed to record return of stake %x %s", t.ID, err)
+			}
+			released++
+		}
+		return released, nil
+	}
+}
+
+// StakeAccount returns the balance of a node without the outputs pending
+// transactions spend, the stakes alfa still holds for it, burned ones aside,
+// and the newest of its transactions.
+func StakeAccount(
+	findBlock blockchain.FindBlockFn,
+	getUTXOs transaction.GetUTXOsByPublicKeyFn,
+	getTransactionUTXO transaction.GetTransactionUTXO,
+	getTransactions transaction.GetTransactionsFn,
+	alfaKeyHash []byte,
+	isBurned stake.IsBurnedFn,
+) stake.GetAccountFn {
+	return func(stakeholder []byte) (stake.Account, error) {
+		var account stake.Account
+		last := func(t transaction.Transaction) {
+			if t.AreInputsFrom(stakeholder) && t.Timestamp >= account.Nonce {
+				account.Nonce = t.Timestamp
+				account.LastTransaction = t.ID
+			}
+		}
+		var stakes transaction.Transactions
+		_, _, err := findBlock(func(b blockchain.Block) bool {
+			for _, t := range b.Body.Transactions {
+				last(t)
+				if _, ok := transaction.StakeOutput(t, alfaKeyHash); ok && t.AreInputsFrom(stakeholder) {
+					stakes = append(stakes, t)
+				}
+			}
+			return false
+		})
+		if err != nil {
+			return stake.Account{}, errors.Wrap(err, "Failed to find transactions")
+		}
+		pending, err := getTransactions()
+		if err != nil {
+			return stake.Account{}, errors.Wrap(err, "Failed to retrieve pending transactions")
+		}
+		for _, t := range pending {
+			last(t)
+		}
+		utxos, err := getUTXOs(stakeholder)
+		if err != nil {
+			return stake.Account{}, errors.Wrapf(err, "Failed to retrieve utxos of %x", stakeholder)
+		}
+		for _, utxo := range utxos {
+			if !isPendingSpend(pending, utxo.TransactionID, utxo.Vout) {
+				account.Balance += utxo.Value
+			}
+		}
+		for _, t := range stakes {
+			vout, _ := transaction.StakeOutput(t, alfaKeyHash)
+			utxo, err := getTransactionUTXO(t.ID, vout)
+			if err != nil {
+				return stake.Account{}, errors.Wrapf(err, "Failed to retrieve utxo of stake %x", t.ID)
+			}
+			if utxo == nil {
+				continue
+			}
+			burned, err := isBurned(t.ID)
+			if err != nil {
+				return stake.Account{}, errors.Wrapf(err, "Failed to check whether stake %x is burned", t.ID)
+			}
+			if !burned {
+				account.Locked += utxo.Value
+			}
+		}
+		return account, nil
+	}
+}
+
+// StakeBurner burns the stake of a forger whose block failed verification
+// and records it in the audit log.
+func StakeBurner(burn stake.BurnFn, record audit.RecordFn) stake.BurnFn {
+	return func(stakeID []byte, stakeholder []byte, reason string) error {
+		if err := burn(stakeID, stakeholder, reason); err != nil {
+			return err
+		}
+		log.Printf("Stake %x of %x is burned, %s", stakeID, stakeholder, reason)
+		if err := record("stake-burn", fmt.Sprintf("stakeholder=%x stake=%x reason=%s", stakeholder, stakeID, reason)); err != nil {
+			log.Printf("Failed to record burn of stake %x %s", stakeID, err)
+		}
+		return nil
+	}
+}
+
+// StakeWeights weighs every node with the value of its outputs, the funds
+// its stakes are drawn from. Nodes whose key is unknown weigh nothing.
+func StakeWeights(getNodes stake.GetNodesFn, getUTXOs transaction.GetUTXOsByPublicKeyFn) round.WeighFn {
+	return func(nodeIDs []string) (map[string]int, error) {
+		nodes, err := getNodes()
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to retrieve nodes")
+		}
+		result := make(map[string]int, len(nodeIDs))
+		for _, id := range nodeIDs {
+			result[id] = 0
+			keyHash, ok := nodes[id]
+			if !ok {
+				continue
+			}
+			utxos, err := getUTXOs(keyHash)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to retrieve utxos of node %s", id)
+			}
+			result[id] = utxos.Sum()
+		}
+		return result, nil
+	}
+}
+
+func consecutiveMisses(rounds round.Rounds) map[string]int {
+	misses := make(map[string]int)
+	done := make(map[string]bool)
+	for _, r := range rounds {
+		if done[r.Selected] {
+			continue
+		}
+		switch r.Outcome {
+		case round.Missed, round.Failed:
+			misses[r.Selected]++
+		case round.Pending:
+		default:
+			done[r.Selected] = true
+		}
+	}
+	return misses
+}
+
+// MissedRoundsWatcher returns the stake of every node that missed at least
+// misses consecutive rounds it was selected in.
+func MissedRoundsWatcher(getNodes stake.GetNodesFn, getRounds round.GetRoundsFn, release stake.ReleaseFn, misses int) RunnerFn {
+	return func() error {
+		rounds, _, err := getRounds(0, missedRoundsWindow)
+		if err != nil {
+			return errors.Wrap(err, "Failed to retrieve rounds")
+		}
+		nodes, err := getNodes()
+		if err != nil {
+			return errors.Wrap(err, "Failed to retrieve nodes")
+		}
+		for nodeID, count := range consecutiveMisses(rounds) {
+			keyHash, ok := nodes[nodeID]
+			if count < misses || !ok {
+				continue
+			}
+			released, err := release(keyHash)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to return stake of node %s", nodeID)
+			}
+			if released > 0 {
+				log.Printf("Node %s missed %d rounds, returned %d stakes", nodeID, count, released)
+			}
+		}
+		return nil
+	}
+}
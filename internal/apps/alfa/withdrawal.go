@@ -0,0 +1,81 @@
+package alfa
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/audit"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/nebser/crypto-vote/internal/pkg/withdrawal"
+	"github.com/pkg/errors"
+)
+
+// PartyWithdrawer signs a withdrawal of a party on behalf of the election
+// administrator and submits it. Votes to the party are rejected once the
+// withdrawal is in the blockchain.
+func PartyWithdrawer(
+	registry *withdrawal.Registry,
+	getAlgorithm digest.GetAlgorithmFn,
+	signer wallet.Signer,
+	publicKey []byte,
+	getParties party.GetPartiesFn,
+	submitTransaction transaction.SaveTransaction,
+	record audit.RecordFn,
+) withdrawal.SubmitFn {
+	lock := &sync.Mutex{}
+	submitted := map[string]bool{}
+	return func(partyKeyHash []byte, prior transaction.PriorVotes, reason string) (withdrawal.Withdrawn, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if _, err := transaction.ParsePriorVotes(string(prior)); err != nil {
+			return withdrawal.Withdrawn{}, errors.Wrap(withdrawal.ErrInvalidWithdrawal, err.Error())
+		}
+		parties, err := getParties()
+		if err != nil {
+			return withdrawal.Withdrawn{}, errors.Wrap(err, "Failed to retrieve parties")
+		}
+		var p *party.Party
+		for i := range parties {
+			if bytes.Equal(wallet.ExtractPublicKeyHash(parties[i].Address), partyKeyHash) {
+				p = &parties[i]
+			}
+		}
+		if p == nil {
+			return withdrawal.Withdrawn{}, errors.Wrapf(withdrawal.ErrInvalidWithdrawal, "%x is not a party", partyKeyHash)
+		}
+		if _, ok := registry.Get(partyKeyHash); ok || submitted[string(partyKeyHash)] {
+			return withdrawal.Withdrawn{}, errors.Wrapf(withdrawal.ErrInvalidWithdrawal, "Party %s already withdrew", p.Name)
+		}
+		w := transaction.Withdrawal{
+			Party:       partyKeyHash,
+			Prior:       prior,
+			Reason:      reason,
+			WithdrawnAt: time.Now().Unix(),
+			Signer:      publicKey,
+		}
+		t, err := transaction.NewWithdrawalTransaction(getAlgorithm(), signer, w)
+		if err != nil {
+			return withdrawal.Withdrawn{}, errors.Wrap(err, "Failed to create withdrawal transaction")
+		}
+		if err := submitTransaction(*t); err != nil {
+			return withdrawal.Withdrawn{}, errors.Wrapf(err, "Failed to submit withdrawal transaction %x", t.ID)
+		}
+		submitted[string(partyKeyHash)] = true
+		details := fmt.Sprintf("party=%q prior=%s reason=%q transaction=%x", p.Name, prior, reason, t.ID)
+		if err := record("party withdrawal", details); err != nil {
+			return withdrawal.Withdrawn{}, errors.Wrap(err, "Failed to record withdrawal in audit log")
+		}
+		return withdrawal.Withdrawn{
+			Party:       partyKeyHash,
+			Prior:       prior,
+			Reason:      reason,
+			WithdrawnAt: w.WithdrawnAt,
+			Transaction: t.ID,
+		}, nil
+	}
+}
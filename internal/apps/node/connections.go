@@ -0,0 +1,29 @@
+package node
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+)
+
+// ConnectionsHandler lists open connections and, on DELETE, forcibly closes
+// the connections of the node given by the node query parameter.
+func ConnectionsHandler(peers websocket.PeersFn, disconnect websocket.DisconnectFn) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(peers())
+		case http.MethodDelete:
+			node := r.URL.Query().Get("node")
+			if node == "" || disconnect(node) == 0 {
+				http.Error(w, "Node is not connected", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,115 @@
+package node
+
+import (
+	"bytes"
+	"log"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/operations"
+	"github.com/pkg/errors"
+)
+
+type DownloadBlocksFn func(hashes [][]byte) (blockchain.Blocks, error)
+
+type blockRange struct {
+	start  int
+	prev   []byte
+	hashes [][]byte
+}
+
+type rangeResult struct {
+	start  int
+	blocks blockchain.Blocks
+}
+
+func downloadRange(getBlock operations.GetBlockFn, r blockRange) (blockchain.Blocks, error) {
+	blocks := blockchain.Blocks{}
+	prev := r.prev
+	for _, hash := range r.hashes {
+		block, err := getBlock(hash)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to obtain block %x", hash)
+		}
+		if bytes.Compare(block.Header.Hash, hash) != 0 || !block.IsHashValid() {
+			return nil, errors.Errorf("Received invalid block for hash %x", hash)
+		}
+		if prev != nil && bytes.Compare(block.Header.Prev, prev) != 0 {
+			return nil, errors.Errorf("Block %x does not point to the previous block %x", hash, prev)
+		}
+		blocks = append(blocks, block)
+		prev = hash
+	}
+	return blocks, nil
+}
+
+func downloader(peer int, getBlock operations.GetBlockFn, ranges chan blockRange, results chan rangeResult, failures chan int, done chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case r := <-ranges:
+			blocks, err := downloadRange(getBlock, r)
+			if err != nil {
+				log.Printf("Peer %d failed to deliver blocks starting at %d. Error: %s", peer, r.start, err)
+				ranges <- r
+				select {
+				case failures <- peer:
+				case <-done:
+				}
+				return
+			}
+			select {
+			case results <- rangeResult{start: r.start, blocks: blocks}:
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+func ParallelDownload(peers []operations.GetBlockFn, rangeSize int) DownloadBlocksFn {
+	return func(hashes [][]byte) (blockchain.Blocks, error) {
+		if len(peers) == 0 {
+			return nil, errors.New("No peers to download blocks from")
+		}
+		if rangeSize <= 0 {
+			rangeSize = 1
+		}
+		ranges := make(chan blockRange, len(hashes)/rangeSize+1)
+		pending := 0
+		for start := 0; start < len(hashes); start += rangeSize {
+			end := start + rangeSize
+			if end > len(hashes) {
+				end = len(hashes)
+			}
+			r := blockRange{start: start, hashes: hashes[start:end]}
+			if start > 0 {
+				r.prev = hashes[start-1]
+			}
+			ranges <- r
+			pending++
+		}
+		results := make(chan rangeResult)
+		failures := make(chan int)
+		done := make(chan struct{})
+		defer close(done)
+		for i, getBlock := range peers {
+			go downloader(i, getBlock, ranges, results, failures, done)
+		}
+		blocks := make(blockchain.Blocks, len(hashes))
+		active := len(peers)
+		for pending > 0 {
+			select {
+			case r := <-results:
+				copy(blocks[r.start:], r.blocks)
+				pending--
+			case <-failures:
+				active--
+				if active == 0 {
+					return nil, errors.Errorf("All peers failed while %d block ranges were still missing", pending)
+				}
+			}
+		}
+		return blocks, nil
+	}
+}
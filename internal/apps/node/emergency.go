@@ -0,0 +1,16 @@
+package node
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nebser/crypto-vote/internal/pkg/emergency"
+)
+
+// EmergencyHandler reports whether the trustees paused the election.
+func EmergencyHandler(getState emergency.GetStateFn) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(getState())
+	})
+}
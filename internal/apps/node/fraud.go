@@ -0,0 +1,49 @@
+package node
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/fraud"
+)
+
+// FraudRecorder keeps verified proofs so operators of the node can inspect
+// them, only the alfa node puts them on chain.
+func FraudRecorder(verify fraud.VerifyFn, save fraud.SaveFn) fraud.ReportFn {
+	return func(p fraud.Proof) (*fraud.Record, error) {
+		violation, err := verify(p)
+		if err != nil {
+			return nil, err
+		}
+		proofHash, err := p.Hash()
+		if err != nil {
+			return nil, err
+		}
+		r := fraud.Record{
+			Violation:  *violation,
+			Proof:      p,
+			ProofHash:  proofHash,
+			ReportedAt: time.Now().Unix(),
+		}
+		if err := save(r); err != nil {
+			return nil, err
+		}
+		log.Printf("ALERT: forger %x signed two blocks at height %d", violation.Offender, violation.Height)
+		return &r, nil
+	}
+}
+
+// FraudHandler lists the recorded fraud proofs.
+func FraudHandler(getProofs fraud.GetFn) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		proofs, err := getProofs()
+		if err != nil {
+			http.Error(w, "Failed to retrieve fraud proofs", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(proofs)
+	})
+}
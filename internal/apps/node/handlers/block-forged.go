@@ -1,26 +1,21 @@
 package handlers
 
 import (
-	"encoding/base64"
-	"encoding/json"
 	"log"
 
 	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/fraud"
+	"github.com/nebser/crypto-vote/internal/pkg/transport"
 	"github.com/nebser/crypto-vote/internal/pkg/wallet"
 	"github.com/nebser/crypto-vote/internal/pkg/websocket"
 	"github.com/pkg/errors"
 )
 
-type blockForgedBody struct {
-	Height int              `json:"height"`
-	Block  blockchain.Block `json:"block"`
-}
-
-func BlockForged(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, verifyBlock blockchain.VerifyBlockFn, isReturnStakeBlock blockchain.IsReturnStakeBlockFn, addNewBlock blockchain.AddNewBlockFn) websocket.Handler {
+func BlockForged(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, findCertificate transport.FindCertificateFn, addForkedBlock blockchain.AddForkedBlockFn, observe fraud.ObserveFn, report fraud.ReportFn, broadcast websocket.BroadcastFn, expand blockchain.ExpandFn) websocket.Handler {
 	return func(ping websocket.Ping, _ string) (*websocket.Pong, error) {
-		var body blockForgedBody
-		if err := json.Unmarshal(ping.Body, &body); err != nil {
-			return nil, errors.Wrapf(err, "Failed to unmarsha block forged body %s", ping.Body)
+		body, fetch, err := expand(ping)
+		if err != nil {
+			return nil, err
 		}
 		height, err := blockchain.GetHeight(getTip, getBlock)
 		if err != nil {
@@ -29,22 +24,41 @@ func BlockForged(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, ver
 		if height+1 < body.Height {
 			return nil, errors.Errorf("Blockchain height is too low %d", height)
 		}
-		sender, err := base64.StdEncoding.DecodeString(ping.Sender)
+		switch ok, err := transport.VerifySignature(findCertificate)(ping, ping.Signature, ping.Sender); {
+		case err != nil:
+			return nil, errors.Wrapf(err, "Failed to verify signature of sender %s", ping.Sender)
+		case !ok:
+			return websocket.NewDisconnectPong(), nil
+		}
+		sender, err := transport.Identity(findCertificate, ping.Sender)
 		if err != nil {
-			return nil, errors.Wrapf(err, "Failed to decode sender %s", ping.Sender)
+			return nil, errors.Wrapf(err, "Failed to resolve sender %s", ping.Sender)
+		}
+		if proof := observe(ping, sender, body.Height, body.Block.Header.Hash); proof != nil {
+			if _, err := report(*proof); err != nil && !errors.Is(err, fraud.ErrAlreadyRecorded) {
+				log.Printf("Failed to record fraud of %x at height %d %s", sender, body.Height, err)
+			}
+			broadcast(websocket.Pong{
+				Message: websocket.FraudProofMessage,
+				Body:    *proof,
+			})
+			return websocket.NewNoActionPong(), nil
+		}
+		if fetch != nil {
+			log.Printf("Fetching transactions of compact block %x", body.Block.Header.Hash)
+			return fetch, nil
 		}
 		hashedSender, err := wallet.HashedPublicKey(sender)
 		if err != nil {
 			return nil, errors.Wrap(err, "Failed to extract hashed public key")
 		}
-		if !isReturnStakeBlock(body.Block, hashedSender) && !verifyBlock(body.Block, hashedSender) {
-			log.Println("Block is not verified 2")
-			return websocket.NewDisconnectPong(), nil
-		}
-		switch err := addNewBlock(body.Block); {
+		switch err := addForkedBlock(body.Block, hashedSender); {
 		case errors.Is(err, blockchain.ErrInvalidBlock):
-			log.Println("Block is invalid")
+			log.Printf("Block is invalid %s", err)
 			return websocket.NewDisconnectPong(), nil
+		case errors.Is(err, blockchain.ErrUnknownParent), errors.Is(err, blockchain.ErrForkTooDeep), errors.Is(err, blockchain.ErrStaleBlock):
+			log.Printf("Block %x is not added %s", body.Block.Header.Hash, err)
+			return websocket.NewNoActionPong(), nil
 		case err != nil:
 			return nil, errors.Wrap(err, "Failed to add new block to blockchain")
 		default:
@@ -0,0 +1,87 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/nebser/crypto-vote/internal/apps/node/handlers"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/fraud"
+	"github.com/nebser/crypto-vote/internal/pkg/transport"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket/websockettest"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func forged(hash string) blockchain.Forged {
+	return blockchain.Forged{
+		Height: 1,
+		Block:  blockchain.Block{Header: blockchain.Header{Hash: []byte(hash)}},
+	}
+}
+
+func TestBlockForgedBroadcastsProofOfTwoBlocksAtOneHeight(t *testing.T) {
+	hub := websockettest.NewHub()
+	hub.AddNode("conn-1", "n1")
+	var added, reported int
+	blockForged := handlers.BlockForged(
+		func() []byte { return nil },
+		func([]byte) (*blockchain.Block, error) { return nil, nil },
+		func(string) (*transport.Certificate, error) { return nil, nil },
+		func(blockchain.Block, []byte) error {
+			added++
+			return nil
+		},
+		fraud.NewWitness().Observe,
+		func(fraud.Proof) (*fraud.Record, error) {
+			reported++
+			return &fraud.Record{}, nil
+		},
+		hub.Broadcast,
+		blockchain.NewReconstructor(nil).Expand,
+	)
+	router := websocket.Router{websocket.BlockForgedMessage: blockForged}
+
+	w, err := wallet.New()
+	if err != nil {
+		t.Fatalf("Failed to create wallet %s", err)
+	}
+	forger := websockettest.NewPeer("conn-2", *w).
+		Then(websocket.BlockForgedMessage, forged("first")).
+		Then(websocket.BlockForgedMessage, forged("second"))
+	pongs, err := forger.Run(router)
+	if err != nil {
+		t.Fatalf("Failed to run forger %s", err)
+	}
+	for i, pong := range pongs {
+		if pong.Message != websocket.NoActionMessage {
+			t.Errorf("Expected no action for block %d, got %s", i, pong.Message)
+		}
+	}
+	if added != 1 || reported != 1 {
+		t.Errorf("Expected the first block to be added and the second reported, got %d added and %d reported", added, reported)
+	}
+	broadcasts := hub.Broadcasts()
+	if len(broadcasts) != 1 || broadcasts[0].Message != websocket.FraudProofMessage {
+		t.Fatalf("Expected a fraud proof to be broadcast, got %v", broadcasts)
+	}
+	raw, err := json.Marshal(broadcasts[0].Body)
+	if err != nil {
+		t.Fatalf("Failed to marshal broadcast %s", err)
+	}
+	var proof fraud.Proof
+	if err := json.Unmarshal(raw, &proof); err != nil {
+		t.Fatalf("Failed to unmarshal proof %s", err)
+	}
+	if proof.First.Sender != forger.Signer.Verifier() || proof.Second.Sender != forger.Signer.Verifier() {
+		t.Errorf("Expected both messages of the proof to be sent by %s, got %s and %s", forger.Signer.Verifier(), proof.First.Sender, proof.Second.Sender)
+	}
+}
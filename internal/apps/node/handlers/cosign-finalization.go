@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/finalization"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+// CosignFinalization co-signs the finalized tip with the chain key only if
+// the tip is in the node's own blockchain at the finalized height.
+func CosignFinalization(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, signer wallet.Signer, publicKey []byte) websocket.Handler {
+	return func(ping websocket.Ping, _ string) (*websocket.Pong, error) {
+		var head finalization.Head
+		if err := json.Unmarshal(ping.Body, &head); err != nil {
+			return nil, errors.Wrapf(err, "Failed to unmarshal finalization head %s", ping.Body)
+		}
+		_, ok, err := blockchain.FindBlock(getTip, getBlock)(func(b blockchain.Block) bool {
+			return bytes.Equal(b.Header.Hash, head.TipHash)
+		})
+		switch {
+		case err != nil:
+			return nil, errors.Wrapf(err, "Failed to find block %x", head.TipHash)
+		case !ok:
+			log.Printf("Finalized tip %x is not in the blockchain, refusing to co-sign", head.TipHash)
+			return websocket.NewNoActionPong(), nil
+		}
+		height, err := blockchain.GetHeight(func() []byte { return head.TipHash }, getBlock)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to get height of finalized tip")
+		}
+		if height != head.Height {
+			log.Printf("Finalized tip %x is at height %d instead of %d, refusing to co-sign", head.TipHash, height, head.Height)
+			return websocket.NewNoActionPong(), nil
+		}
+		signature, err := signer.SignRaw(head)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to co-sign finalization")
+		}
+		log.Printf("Co-signed finalized tip %x at height %d", head.TipHash, head.Height)
+		return &websocket.Pong{
+			Message: websocket.FinalizationCosignedMessage,
+			Body: finalization.Cosignature{
+				Head:      head,
+				Verifier:  publicKey,
+				Signature: signature,
+				SignedAt:  time.Now().Unix(),
+			},
+		}, nil
+	}
+}
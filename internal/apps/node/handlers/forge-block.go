@@ -5,6 +5,9 @@ import (
 	"log"
 
 	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/mixer"
+	"github.com/nebser/crypto-vote/internal/pkg/sortition"
 	"github.com/nebser/crypto-vote/internal/pkg/transaction"
 	"github.com/nebser/crypto-vote/internal/pkg/websocket"
 	"github.com/pkg/errors"
@@ -15,8 +18,13 @@ func ForgeBlock(
 	getBlock blockchain.GetBlockFn,
 	forgeBlock blockchain.ForgeBlockFn,
 	getTransactions transaction.GetTransactionsFn,
+	prioritize transaction.PrioritizeFn,
+	getAlgorithm digest.GetAlgorithmFn,
 	newStakeTransaction transaction.NewStakeTransactionFn,
 	isReturnStakeTransaction transaction.IsReturnStakeTransactionFn,
+	isBatchReady mixer.IsBatchReadyFn,
+	prove sortition.ProveFn,
+	relay blockchain.Relay,
 	broadcast websocket.BroadcastFn,
 ) websocket.Handler {
 	return func(ping websocket.Ping, _ string) (*websocket.Pong, error) {
@@ -24,6 +32,22 @@ func ForgeBlock(
 		if err := json.Unmarshal(ping.Body, &body); err != nil {
 			return nil, errors.Wrapf(err, "Failed to unmarshal forge block message body %s", ping.Body)
 		}
+		if body.Round != nil && !body.Round.Verify() {
+			log.Printf("WARNING: selection of round %d doesn't follow from its seed and weights", body.Round.Number)
+		}
+		var proof *transaction.Sortition
+		if body.Round != nil && body.Round.Sortition {
+			p, err := prove(*body.Round)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to prove eligibility in round %d", body.Round.Number)
+			}
+			if p == nil {
+				log.Printf("Not eligible to forge in round %d", body.Round.Number)
+				return websocket.NewNoActionPong(), nil
+			}
+			log.Printf("Eligible to forge in round %d with weight %d out of %d", body.Round.Number, p.Weight, p.Total)
+			proof = p
+		}
 		height, err := blockchain.GetHeight(getTip, getBlock)
 		if err != nil {
 			return nil, errors.Wrapf(err, "Failed to retrieve block height")
@@ -35,6 +59,11 @@ func ForgeBlock(
 		if err != nil {
 			return nil, errors.Wrapf(err, "Failed to create stake transaction")
 		}
+		if proof != nil {
+			if stake, err = stake.WithSortition(getAlgorithm(), *proof); err != nil {
+				return nil, errors.Wrap(err, "Failed to attach sortition proof to stake transaction")
+			}
+		}
 		log.Printf("Stake transaction %s", *stake)
 		transactions, err := getTransactions()
 		switch {
@@ -47,7 +76,17 @@ func ForgeBlock(
 			log.Println("Only return stake transaction found")
 			return websocket.NewNoActionPong(), nil
 		}
-		block, err := forgeBlock(append(transaction.Transactions{*stake}, transactions...))
+		votes := transaction.Transactions{}
+		for _, t := range transactions {
+			if !isReturnStakeTransaction(t) {
+				votes = append(votes, t)
+			}
+		}
+		if len(votes) > 0 && !isBatchReady(votes) {
+			log.Printf("Waiting for mixing batch to fill up. Pending transactions %d", len(votes))
+			return websocket.NewNoActionPong(), nil
+		}
+		block, err := forgeBlock(append(transaction.Transactions{*stake}, prioritize(transactions)...))
 		switch {
 		case err != nil:
 			return nil, errors.Wrap(err, "Failed to forge block")
@@ -60,13 +99,7 @@ func ForgeBlock(
 		if err != nil {
 			return nil, errors.Wrap(err, "Failed to return block")
 		}
-		broadcast(websocket.Pong{
-			Message: websocket.BlockForgedMessage,
-			Body: websocket.BlockForgedBody{
-				Height: height + 1,
-				Block:  *newBlock,
-			},
-		})
+		broadcast(relay.Announce(height+1, *newBlock))
 		log.Println("Sent forged block")
 		return websocket.NewNoActionPong(), nil
 	}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/nebser/crypto-vote/internal/pkg/fraud"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+// FraudProof records a proof relayed by a peer.
+func FraudProof(report fraud.ReportFn) websocket.Handler {
+	return func(ping websocket.Ping, _ string) (*websocket.Pong, error) {
+		var proof fraud.Proof
+		if err := json.Unmarshal(ping.Body, &proof); err != nil {
+			return websocket.NewErrorPong(websocket.NewInvalidDataError(websocket.FraudProofMessage.String())), nil
+		}
+		switch _, err := report(proof); {
+		case errors.Is(err, fraud.ErrInvalidProof):
+			log.Printf("Ignoring invalid fraud proof %s", err)
+			return websocket.NewNoActionPong(), nil
+		case errors.Is(err, fraud.ErrAlreadyRecorded):
+			return websocket.NewNoActionPong(), nil
+		case err != nil:
+			return nil, errors.Wrap(err, "Failed to record fraud proof")
+		default:
+			return websocket.NewNoActionPong(), nil
+		}
+	}
+}
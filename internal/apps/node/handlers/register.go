@@ -8,24 +8,28 @@ import (
 )
 
 type registerPayload struct {
-	NodeID string `json:"nodeId"`
+	NodeID    string               `json:"nodeId"`
+	Encodings []websocket.Encoding `json:"encodings"`
 }
 
 type registerResponse struct {
-	Nodes []string `json:"nodes"`
+	Nodes    []string           `json:"nodes"`
+	Encoding websocket.Encoding `json:"encoding"`
 }
 
-func Register(hub *websocket.Hub) websocket.Handler {
+func Register(hub websocket.Registrar) websocket.Handler {
 	return func(ping websocket.Ping, internalID string) (*websocket.Pong, error) {
 		var p registerPayload
 		if err := json.Unmarshal(ping.Body, &p); err != nil {
 			return nil, errors.Wrapf(err, "Failed to unmarshal data %s into payload", ping.Body)
 		}
 		nodes := hub.RegisterAtomically(internalID, p.NodeID)
+		encoding := hub.Negotiate(internalID, p.Encodings)
 		return websocket.NewResponsePong(
 			registerResponse{
-				Nodes: nodes,
+				Nodes:    nodes,
+				Encoding: encoding,
 			},
-		), nil
+		).SwitchingTo(encoding), nil
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"log"
 
+	"github.com/nebser/crypto-vote/internal/pkg/mempool"
 	"github.com/nebser/crypto-vote/internal/pkg/transaction"
 	"github.com/nebser/crypto-vote/internal/pkg/wallet"
 	"github.com/nebser/crypto-vote/internal/pkg/websocket"
@@ -23,8 +24,17 @@ func SaveTransaction(save transaction.SaveTransaction, verifier wallet.VerifierF
 		case !ok:
 			return websocket.NewErrorPong(websocket.NewInvalidTransactionError()), nil
 		}
+		if p.Transaction.IsCertification() && !p.Transaction.Certificate.Verified() {
+			return websocket.NewErrorPong(websocket.NewInvalidTransactionError()), nil
+		}
 		log.Println("TRANSACTION VERIFIED")
-		if err := save(p.Transaction); err != nil {
+		switch err := save(p.Transaction); {
+		case errors.Is(err, mempool.ErrDuplicate):
+			return websocket.NewNoActionPong(), nil
+		case errors.Is(err, mempool.ErrDoubleSpend), errors.Is(err, mempool.ErrFull):
+			log.Printf("Transaction is refused %s", err)
+			return websocket.NewNoActionPong(), nil
+		case err != nil:
 			return nil, errors.Wrapf(err, "Failed to save transaction %s", p.Transaction)
 		}
 		log.Println("SAVED TRANSACTION")
@@ -0,0 +1,83 @@
+package node
+
+import (
+	"log"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/limits"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+// ShedOrder sheds votes before the transactions the protocol depends on, the
+// most recent votes before the ones that have been waiting the longest and
+// larger transactions before smaller ones received at the same time. Since
+// votes carry no fees, the bytes a transaction takes are its only cost. The
+// reverse order is used for packing blocks.
+func ShedOrder(isReturnStakeTransaction transaction.IsReturnStakeTransactionFn) limits.LessFn {
+	essential := func(t transaction.Transaction) bool {
+		return t.IsCertification() || isReturnStakeTransaction(t)
+	}
+	return func(a, b transaction.Transaction) bool {
+		if essential(a) != essential(b) {
+			return essential(b)
+		}
+		if a.Timestamp != b.Timestamp {
+			return a.Timestamp > b.Timestamp
+		}
+		return a.Size() > b.Size()
+	}
+}
+
+// LimitMempool sheds the lowest priority transactions whenever a saved
+// transaction pushes the mempool over maxBytes. Mempool is not limited if
+// maxBytes is 0.
+func LimitMempool(
+	save transaction.SaveTransaction,
+	mempoolSize limits.UsageFn,
+	shed limits.ShedFn,
+	less limits.LessFn,
+	maxBytes int64,
+	monitor *limits.Monitor,
+) transaction.SaveTransaction {
+	return func(t transaction.Transaction) error {
+		if err := save(t); err != nil {
+			return err
+		}
+		size, err := mempoolSize()
+		if err != nil {
+			return errors.Wrap(err, "Failed to retrieve mempool size")
+		}
+		if monitor.Check(limits.Mempool, size, maxBytes) != limits.Exceeded {
+			return nil
+		}
+		removed, err := shed(maxBytes, less)
+		if err != nil {
+			return errors.Wrap(err, "Failed to shed mempool transactions")
+		}
+		log.Printf("Mempool exceeded %d bytes, shed %d transactions", maxBytes, len(removed))
+		size, err = mempoolSize()
+		if err != nil {
+			return errors.Wrap(err, "Failed to retrieve mempool size")
+		}
+		monitor.Check(limits.Mempool, size, maxBytes)
+		return nil
+	}
+}
+
+// WatchResources periodically checks resource usage so that alarms are also
+// cleared when blocks are forged or the database shrinks.
+func WatchResources(monitor *limits.Monitor, interval time.Duration, dbSize limits.UsageFn, maxDBSize int64, mempoolSize limits.UsageFn, maxMempoolSize int64) {
+	for range time.Tick(interval) {
+		if size, err := dbSize(); err != nil {
+			log.Printf("Failed to retrieve database size %s", err)
+		} else {
+			monitor.Check(limits.Database, size, maxDBSize)
+		}
+		if size, err := mempoolSize(); err != nil {
+			log.Printf("Failed to retrieve mempool size %s", err)
+		} else {
+			monitor.Check(limits.Mempool, size, maxMempoolSize)
+		}
+	}
+}
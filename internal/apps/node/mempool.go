@@ -0,0 +1,33 @@
+package node
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+)
+
+// MempoolHandler lists pending transactions with their size in bytes.
+func MempoolHandler(getTransactions transaction.GetTransactionsFn) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		txs, err := getTransactions()
+		if err != nil {
+			http.Error(w, "Failed to retrieve transactions", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(transaction.NewMempool(txs))
+	})
+}
+
+// WatchMempool periodically sweeps the mempool, dropping expired
+// transactions.
+func WatchMempool(sweep func() error, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := sweep(); err != nil {
+			log.Printf("Failed to sweep mempool %s", err)
+		}
+	}
+}
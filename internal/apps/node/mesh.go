@@ -0,0 +1,28 @@
+package node
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/mesh"
+)
+
+// MeshHandler reports the connectivity and the blockchain heights of the
+// nodes learned from peer exchanges.
+func MeshHandler(report func() mesh.Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report())
+	})
+}
+
+// ExchangePeers periodically sends the known nodes to the connected peers.
+func ExchangePeers(exchange func() error, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := exchange(); err != nil {
+			log.Printf("Failed to exchange peers %s", err)
+		}
+	}
+}
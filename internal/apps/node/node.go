@@ -1,18 +1,24 @@
 package node
 
 import (
+	"bytes"
+	"log"
+
 	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
 	"github.com/nebser/crypto-vote/internal/pkg/operations"
 	"github.com/pkg/errors"
 )
 
+// Initialize brings the blockchain of the node to the one of the alfa node.
+// Blocks the node has past the common ancestor, e.g. after a reorganization
+// it missed while offline, are rolled back first.
 func Initialize(
 	getHeight operations.GetHeightFn,
 	getMissingBlocks operations.GetMissingBlocksFn,
-	getBlock operations.GetBlockFn,
+	catchUp CatchUpFn,
 	getTip blockchain.GetTipFn,
 	getBlockchainBlock blockchain.GetBlockFn,
-	addBlock blockchain.AddBlockFn,
+	rollback blockchain.RollbackFn,
 ) error {
 	blockchainHeight, err := getHeight()
 	if err != nil {
@@ -22,29 +28,63 @@ func Initialize(
 	if err != nil {
 		return errors.Wrap(err, "Couldn't obtain local blockchain height")
 	}
-	if localHeight == blockchainHeight {
-		return nil
+	locator, err := blockchain.Locator(getTip, getBlockchainBlock)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create block locator")
 	}
-	tip := getTip()
-	blockHashes, err := getMissingBlocks(tip)
+	fork, blockHashes, err := getMissingBlocks(locator)
 	if err != nil {
-		return errors.Wrapf(err, "Failed to retrieve missing blocks since tip %x", tip)
+		return errors.Wrapf(err, "Failed to retrieve missing blocks since tip %x", getTip())
 	}
+	if len(fork) == 0 && len(getTip()) > 0 {
+		return errors.New("Local blockchain shares no block with the blockchain of the alfa node")
+	}
+	for rolledBack := 0; !bytes.Equal(getTip(), fork); rolledBack++ {
+		block, err := rollback()
+		if err != nil {
+			return errors.Wrapf(err, "Failed to roll back to common block %x after %d blocks", fork, rolledBack)
+		}
+		log.Printf("Rolled back block %x which is not in the blockchain of the alfa node", block.Header.Hash)
+	}
+	log.Printf("Local blockchain at height %d shares block %x with the blockchain at height %d, %d blocks are missing", localHeight, fork, blockchainHeight, len(blockHashes))
 	if len(blockHashes) == 0 {
 		return nil
 	}
-	blocks := blockchain.Blocks{}
-	for _, hash := range blockHashes {
-		block, err := getBlock(hash)
+	return catchUp(fork, blockHashes)
+}
+
+// CatchUpFn adds the blocks missing after the common block fork.
+type CatchUpFn func(fork []byte, missing [][]byte) error
+
+// Download downloads the missing blocks by their hashes and adds them once
+// all of them are there.
+func Download(downloadBlocks DownloadBlocksFn, addBlock blockchain.AddBlockFn) CatchUpFn {
+	return func(fork []byte, missing [][]byte) error {
+		blocks, err := downloadBlocks(missing)
 		if err != nil {
-			return errors.Wrapf(err, "Failed to obtain block %x", hash)
+			return errors.Wrapf(err, "Failed to download %d missing blocks", len(missing))
+		}
+		if !bytes.Equal(blocks[0].Header.Prev, fork) {
+			return errors.Errorf("First missing block %x does not point to the common block %x", blocks[0].Header.Hash, fork)
 		}
-		blocks = append(blocks, block)
+		for _, block := range blocks {
+			if _, err := addBlock(block); err != nil {
+				return errors.Wrap(err, "Failed to add block during initialization")
+			}
+		}
+		return nil
 	}
-	for _, block := range blocks {
-		if _, err := addBlock(block); err != nil {
-			return errors.Wrap(err, "Failed to add block during initialization")
+}
+
+// Stream catches up with sync, which adds the missing blocks batch by batch
+// as they arrive and also the ones forged in the meantime.
+func Stream(sync operations.SyncFn) CatchUpFn {
+	return func(_ []byte, missing [][]byte) error {
+		added, err := sync()
+		if err != nil {
+			return errors.Wrapf(err, "Failed to sync after adding %d of %d missing blocks", added, len(missing))
 		}
+		log.Printf("Synced %d blocks", added)
+		return nil
 	}
-	return nil
 }
@@ -0,0 +1,148 @@
+package node
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/pkg/errors"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// localChain is the blockchain of a node kept in a slice, the blocks carry
+// only their hashes.
+type localChain struct {
+	blocks []blockchain.Block
+}
+
+func newLocalChain(hashes ...string) *localChain {
+	c := &localChain{}
+	var prev []byte
+	for _, h := range hashes {
+		c.blocks = append(c.blocks, blockchain.Block{Header: blockchain.Header{Hash: []byte(h), Prev: prev}})
+		prev = []byte(h)
+	}
+	return c
+}
+
+func (c *localChain) getTip() []byte {
+	if len(c.blocks) == 0 {
+		return nil
+	}
+	return c.blocks[len(c.blocks)-1].Header.Hash
+}
+
+func (c *localChain) getBlock(hash []byte) (*blockchain.Block, error) {
+	for _, b := range c.blocks {
+		if bytes.Equal(b.Header.Hash, hash) {
+			b := b
+			return &b, nil
+		}
+	}
+	return nil, errors.Errorf("Block %s not found", hash)
+}
+
+func (c *localChain) rollback() (*blockchain.Block, error) {
+	if len(c.blocks) == 0 {
+		return nil, errors.New("Nothing to roll back")
+	}
+	tip := c.blocks[len(c.blocks)-1]
+	c.blocks = c.blocks[:len(c.blocks)-1]
+	return &tip, nil
+}
+
+func TestInitializeRollsBackToFork(t *testing.T) {
+	cases := []struct {
+		name       string
+		local      *localChain
+		fork       string
+		missing    []string
+		rolledBack int
+		err        bool
+	}{
+		{"fork", newLocalChain("g", "a", "b", "x", "y"), "b", []string{"c", "d", "e"}, 2, false},
+		{"behind", newLocalChain("g", "a"), "a", []string{"b", "c"}, 0, false},
+		{"up to date", newLocalChain("g", "a"), "a", nil, 0, false},
+		{"empty", newLocalChain(), "", []string{"g", "a"}, 0, false},
+		{"no common block", newLocalChain("h", "x"), "", []string{"g", "a"}, 0, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			height := len(c.local.blocks)
+			var locator [][]byte
+			getMissingBlocks := func(l [][]byte) ([]byte, [][]byte, error) {
+				locator = l
+				var missing [][]byte
+				for _, h := range c.missing {
+					missing = append(missing, []byte(h))
+				}
+				if c.fork == "" {
+					return nil, missing, nil
+				}
+				return []byte(c.fork), missing, nil
+			}
+			caughtUp := false
+			catchUp := func(fork []byte, missing [][]byte) error {
+				caughtUp = true
+				if tip := c.local.getTip(); !bytes.Equal(tip, fork) {
+					return errors.Errorf("Expected to catch up from the fork %s, the tip is %s", fork, tip)
+				}
+				if len(missing) != len(c.missing) {
+					return errors.Errorf("Expected %d missing blocks, got %d", len(c.missing), len(missing))
+				}
+				return nil
+			}
+			getHeight := func() (int, error) { return height - c.rolledBack + len(c.missing), nil }
+
+			err := Initialize(getHeight, getMissingBlocks, catchUp, c.local.getTip, c.local.getBlock, c.local.rollback)
+			switch {
+			case c.err && err == nil:
+				t.Fatal("Expected initialization to fail")
+			case c.err:
+				if len(c.local.blocks) != height {
+					t.Errorf("Expected no block to be rolled back, got %d", height-len(c.local.blocks))
+				}
+				return
+			case err != nil:
+				t.Fatalf("Failed to initialize %s", err)
+			}
+			if len(locator) != height {
+				t.Errorf("Expected a locator of all %d blocks, got %d", height, len(locator))
+			}
+			if rolledBack := height - len(c.local.blocks); rolledBack != c.rolledBack {
+				t.Errorf("Expected %d blocks to be rolled back, got %d", c.rolledBack, rolledBack)
+			}
+			if caughtUp != (len(c.missing) > 0) {
+				t.Errorf("Expected catching up to be %v, got %v", len(c.missing) > 0, caughtUp)
+			}
+			if tip := c.local.getTip(); !bytes.Equal(tip, []byte(c.fork)) {
+				t.Errorf("Expected tip %s, got %s", c.fork, tip)
+			}
+		})
+	}
+}
+
+func TestInitializeStopsOnFailedRollback(t *testing.T) {
+	local := newLocalChain("g", "a", "x")
+	getMissingBlocks := func([][]byte) ([]byte, [][]byte, error) {
+		return []byte("a"), [][]byte{[]byte("b")}, nil
+	}
+	rollback := func() (*blockchain.Block, error) {
+		return nil, errors.Errorf("Undo data of %s is missing", local.getTip())
+	}
+	catchUp := func([]byte, [][]byte) error {
+		t.Error("Expected no download after a failed rollback")
+		return nil
+	}
+	getHeight := func() (int, error) { return 3, nil }
+	if err := Initialize(getHeight, getMissingBlocks, catchUp, local.getTip, local.getBlock, rollback); err == nil {
+		t.Error("Expected initialization to fail")
+	}
+}
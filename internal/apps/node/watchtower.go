@@ -0,0 +1,28 @@
+package node
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/watchtower"
+)
+
+// WatchtowerHandler lists the watched vote receipts with their status.
+func WatchtowerHandler(receipts func() []watchtower.Receipt) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(receipts())
+	})
+}
+
+// WatchReceipts periodically checks the watched vote receipts against the
+// blockchain.
+func WatchReceipts(check func() error, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := check(); err != nil {
+			log.Printf("Failed to check receipts %s", err)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package access
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidCredential = errors.New("Admin credential is not valid")
+	ErrPermissionMissing = errors.New("Admin credential has no role with the permission")
+	ErrInvalidRole       = errors.New("Role is not known")
+	ErrLastCommissioner  = errors.New("The last commissioner can't be removed")
+	ErrUnknownCredential = errors.New("Admin credential does not exist")
+	ErrCredentialRevoked = errors.New("Admin credential is revoked")
+	ErrMissingName       = errors.New("Admin credential has no name")
+)
+
+// Permission is what an admin endpoint requires of the credential a request
+// is made with.
+type Permission string
+
+const (
+	// View is the state of the network, rounds, nodes, connections, the
+	// mempool and the progress of finalization and compaction.
+	View Permission = "view"
+	// Audit are the records, balances, undo records, conflicts, the exported
+	// database, observer keys, research requests, provisional ballots and
+	// admin credentials.
+	Audit Permission = "audit"
+	// Operate is keeping the node running, compacting the database and
+	// closing connections.
+	Operate Permission = "operate"
+	// Manage is running the election, elections, withdrawals, observer keys,
+	// research requests and provisional ballots.
+	Manage Permission = "manage"
+	// Emergency is submitting statements of the trustees, pauses, resumes
+	// and rollbacks.
+	Emergency Permission = "emergency"
+	// Grant is issuing and revoking admin credentials and assigning roles.
+	Grant Permission = "grant"
+)
+
+// Role is a set of permissions assigned to admin credentials.
+type Role string
+
+const (
+	Commissioner Role = "commissioner"
+	Operator     Role = "operator"
+	Auditor      Role = "auditor"
+	Observer     Role = "observer"
+)
+
+var Roles = []Role{Commissioner, Operator, Auditor, Observer}
+
+// Permissions of every role, the commissioner has all of them.
+var Permissions = map[Role][]Permission{
+	Commissioner: {View, Audit, Operate, Manage, Emergency, Grant},
+	Operator:     {View, Operate},
+	Auditor:      {View, Audit},
+	Observer:     {View},
+}
+
+func ParseRole(raw string) (Role, error) {
+	for _, r := range Roles {
+		if string(r) == raw {
+			return r, nil
+		}
+	}
+	return "", errors.Wrapf(ErrInvalidRole, "Role %s", raw)
+}
+
+// Credential authenticates requests to the admin API. Only the hash of the
+// secret is kept, the token is shown once when the credential is issued.
+type Credential struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Roles     []Role `json:"roles"`
+	Secret    []byte `json:"secret,omitempty"`
+	CreatedAt int64  `json:"createdAt"`
+	UpdatedAt int64  `json:"updatedAt,omitempty"`
+	RevokedAt int64  `json:"revokedAt,omitempty"`
+}
+
+type Credentials []Credential
+
+func (c Credential) Has(role Role) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Permits tells if any role of the credential has the permission.
+func (c Credential) Permits(permission Permission) bool {
+	for _, r := range c.Roles {
+		for _, p := range Permissions[r] {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Public is the credential as the admin API shows it, with its name, roles
+// and when it was issued, updated and revoked, but not the hash of the token
+// requests are authenticated with.
+func (c Credential) Public() Credential {
+	c.Secret = nil
+	return c
+}
+
+// commissioners counts the credentials in effect with the commissioner role.
+func (cs Credentials) commissioners() int {
+	count := 0
+	for _, c := range cs {
+		if c.RevokedAt == 0 && c.Has(Commissioner) {
+			count++
+		}
+	}
+	return count
+}
+
+type SaveFn func(Credential) error
+
+type GetFn func(id string) (*Credential, error)
+
+type ListFn func() (Credentials, error)
+
+// IssueFn issues a credential with the roles and returns it with its token.
+type IssueFn func(name string, roles []Role) (Credential, string, error)
+
+// AssignFn replaces the roles of a credential.
+type AssignFn func(id string, roles []Role) (Credential, error)
+
+type RevokeFn func(id string) (Credential, error)
+
+// AuthorizeFn returns the credential of the token if one of its roles has
+// the permission.
+type AuthorizeFn func(token string, permission Permission) (Credential, error)
+
+func hashSecret(secret []byte) []byte {
+	hash := sha256.Sum256(secret)
+	return hash[:]
+}
+
+func random(n int) ([]byte, error) {
+	result := make([]byte, n)
+	if _, err := rand.Read(result); err != nil {
+		return nil, errors.Wrap(err, "Failed to generate random bytes")
+	}
+	return result, nil
+}
+
+func validRoles(roles []Role) error {
+	if len(roles) == 0 {
+		return errors.Wrap(ErrInvalidRole, "At least one role has to be assigned")
+	}
+	for _, r := range roles {
+		if _, ok := Permissions[r]; !ok {
+			return errors.Wrapf(ErrInvalidRole, "Role %s", r)
+		}
+	}
+	return nil
+}
+
+// Issue creates a credential with a random id and secret. The token is the
+// id and the hex encoded secret joined with a dot.
+func Issue(save SaveFn) IssueFn {
+	return func(name string, roles []Role) (Credential, string, error) {
+		if strings.TrimSpace(name) == "" {
+			return Credential{}, "", ErrMissingName
+		}
+		if err := validRoles(roles); err != nil {
+			return Credential{}, "", err
+		}
+		id, err := random(8)
+		if err != nil {
+			return Credential{}, "", err
+		}
+		secret, err := random(32)
+		if err != nil {
+			return Credential{}, "", err
+		}
+		c := Credential{
+			ID:        hex.EncodeToString(id),
+			Name:      name,
+			Roles:     roles,
+			Secret:    hashSecret(secret),
+			CreatedAt: time.Now().Unix(),
+		}
+		if err := save(c); err != nil {
+			return Credential{}, "", errors.Wrapf(err, "Failed to save credential %s", c.ID)
+		}
+		return c, c.ID + "." + hex.EncodeToString(secret), nil
+	}
+}
+
+// Assign replaces the roles of a credential in effect, the commissioner role
+// is never taken from the last credential holding it.
+func Assign(get GetFn, list ListFn, save SaveFn) AssignFn {
+	return func(id string, roles []Role) (Credential, error) {
+		if err := validRoles(roles); err != nil {
+			return Credential{}, err
+		}
+		c, err := get(id)
+		switch {
+		case err != nil:
+			return Credential{}, errors.Wrapf(err, "Failed to retrieve credential %s", id)
+		case c == nil:
+			return Credential{}, errors.Wrapf(ErrUnknownCredential, "Credential %s", id)
+		case c.RevokedAt != 0:
+			return Credential{}, errors.Wrapf(ErrCredentialRevoked, "Credential %s", id)
+		}
+		if c.Has(Commissioner) && !(Credential{Roles: roles}).Has(Commissioner) {
+			if err := keepsCommissioner(list); err != nil {
+				return Credential{}, err
+			}
+		}
+		c.Roles = roles
+		c.UpdatedAt = time.Now().Unix()
+		if err := save(*c); err != nil {
+			return Credential{}, errors.Wrapf(err, "Failed to assign roles to credential %s", id)
+		}
+		return c.Public(), nil
+	}
+}
+
+// Revoke revokes a credential, but not the last one of a commissioner.
+func Revoke(get GetFn, list ListFn, save SaveFn) RevokeFn {
+	return func(id string) (Credential, error) {
+		c, err := get(id)
+		switch {
+		case err != nil:
+			return Credential{}, errors.Wrapf(err, "Failed to retrieve credential %s", id)
+		case c == nil:
+			return Credential{}, errors.Wrapf(ErrUnknownCredential, "Credential %s", id)
+		case c.RevokedAt != 0:
+			return c.Public(), nil
+		}
+		if c.Has(Commissioner) {
+			if err := keepsCommissioner(list); err != nil {
+				return Credential{}, err
+			}
+		}
+		c.RevokedAt = time.Now().Unix()
+		if err := save(*c); err != nil {
+			return Credential{}, errors.Wrapf(err, "Failed to revoke credential %s", id)
+		}
+		return c.Public(), nil
+	}
+}
+
+func keepsCommissioner(list ListFn) error {
+	all, err := list()
+	if err != nil {
+		return errors.Wrap(err, "Failed to retrieve credentials")
+	}
+	if all.commissioners() < 2 {
+		return ErrLastCommissioner
+	}
+	return nil
+}
+
+func Authorize(get GetFn) AuthorizeFn {
+	return func(token string, permission Permission) (Credential, error) {
+		parts := strings.SplitN(token, ".", 2)
+		if len(parts) != 2 {
+			return Credential{}, ErrInvalidCredential
+		}
+		secret, err := hex.DecodeString(parts[1])
+		if err != nil {
+			return Credential{}, ErrInvalidCredential
+		}
+		c, err := get(parts[0])
+		switch {
+		case err != nil:
+			return Credential{}, errors.Wrapf(err, "Failed to retrieve credential %s", parts[0])
+		case c == nil, c.RevokedAt != 0, subtle.ConstantTimeCompare(c.Secret, hashSecret(secret)) != 1:
+			return Credential{}, ErrInvalidCredential
+		case !c.Permits(permission):
+			return c.Public(), errors.Wrapf(ErrPermissionMissing, "Permission %s", permission)
+		}
+		return c.Public(), nil
+	}
+}
+
+// Bootstrap issues a commissioner credential when none is in effect, so
+// the admin API can't be locked out. The token is empty if there was one.
+func Bootstrap(list ListFn, issue IssueFn) (string, error) {
+	all, err := list()
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to retrieve credentials")
+	}
+	if all.commissioners() > 0 {
+		return "", nil
+	}
+	_, token, err := issue("bootstrap", []Role{Commissioner})
+	return token, err
+}
+
+// Rule is the permission requests with the method to the path require.
+// Segments of the path in braces, e.g. {id}, match any segment.
+type Rule struct {
+	Method     string
+	Path       string
+	Permission Permission
+}
+
+type Rules []Rule
+
+func (r Rule) matches(method, path string) bool {
+	if r.Method != method {
+		return false
+	}
+	want, got := strings.Split(r.Path, "/"), strings.Split(path, "/")
+	if len(want) != len(got) {
+		return false
+	}
+	for i, segment := range want {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") && got[i] != "" {
+			continue
+		}
+		if segment != got[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Required returns the permission of the first rule matching the request,
+// false if none does.
+func (rs Rules) Required(method, path string) (Permission, bool) {
+	for _, r := range rs {
+		if r.matches(method, path) {
+			return r.Permission, true
+		}
+	}
+	return "", false
+}
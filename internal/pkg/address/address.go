@@ -0,0 +1,52 @@
+package address
+
+import (
+	"encoding/base64"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+var legacy = metrics.NewCounter("legacy_addresses_total", "Number of addresses given as base64 encoded public key hashes instead of Base58Check")
+
+// Length of a public key hash, legacy addresses are its base64 encoding.
+const hashLength = 20
+
+// ParseFn returns the public key hash of an address given in an API request.
+type ParseFn func(string) ([]byte, error)
+
+// Parser accepts Base58Check addresses and, until legacyUntil, the base64
+// encoded public key hashes earlier versions of the API used. Legacy
+// addresses are accepted without a deadline if legacyUntil is zero.
+func Parser(legacyUntil time.Time) ParseFn {
+	return func(address string) ([]byte, error) {
+		hash, err := wallet.DecodeAddress(address)
+		if err == nil {
+			return hash, nil
+		}
+		if !legacyUntil.IsZero() && !time.Now().Before(legacyUntil) {
+			return nil, err
+		}
+		raw, legacyErr := base64.StdEncoding.DecodeString(address)
+		if legacyErr != nil || len(raw) != hashLength {
+			return nil, err
+		}
+		legacy.Inc()
+		return raw, nil
+	}
+}
+
+// ParseAll parses every address, failing on the first invalid one.
+func (parse ParseFn) ParseAll(addresses []string) ([][]byte, error) {
+	result := make([][]byte, 0, len(addresses))
+	for _, a := range addresses {
+		hash, err := parse(a)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to parse address %s", a)
+		}
+		result = append(result, hash)
+	}
+	return result, nil
+}
@@ -0,0 +1,235 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+// Bucket is the width of a turnout bucket. Buckets are reported only once
+// they are closed, so successive reads can't be diffed into single votes.
+const Bucket = 10 * time.Minute
+
+// Precincts maps the public key hash of a voter to the voter's precinct.
+type Precincts map[string]string
+
+// ReadPrecincts reads a CSV file with an address and a precinct per line.
+// Empty lines and lines starting with # are skipped.
+func ReadPrecincts(path string) (Precincts, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open precincts file %s", path)
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	result := Precincts{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to parse precincts file %s", path)
+		}
+		if len(record) == 0 || record[0] == "" || strings.HasPrefix(record[0], "#") {
+			continue
+		}
+		if len(record) < 2 || record[1] == "" {
+			return nil, errors.Errorf("Precinct is missing on line %d of %s", line, path)
+		}
+		hash, err := wallet.DecodeAddress(record[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid address on line %d of %s", line, path)
+		}
+		result[string(hash)] = record[1]
+	}
+	return result, nil
+}
+
+type Interval struct {
+	Start      int64          `json:"start"`
+	Votes      int            `json:"votes"`
+	Suppressed bool           `json:"suppressed,omitempty"`
+	Precincts  map[string]int `json:"precincts,omitempty"`
+	Withheld   int            `json:"withheld,omitempty"`
+}
+
+// Series is the turnout over time. Votes of an interval are suppressed if
+// fewer than K voters voted in it. Precincts with fewer than K voters are
+// withheld, together with the smallest reported one if a single count would
+// be missing and could be computed from the votes of the interval. Voters
+// without a precinct count only into the votes.
+type Series struct {
+	Bucket    int64      `json:"bucket"`
+	K         int        `json:"k"`
+	Intervals []Interval `json:"intervals"`
+}
+
+type GetTurnoutFn func() (Series, error)
+
+// Turnout counts voters per bucket and precinct from the blocks. Only the
+// counts are kept, voters are never stored nor logged. A voter is a distinct
+// sender of a transaction giving an output to a party.
+type Turnout struct {
+	lock       *sync.Mutex
+	k          int
+	precincts  Precincts
+	getTip     blockchain.GetTipFn
+	getBlock   blockchain.GetBlockFn
+	getParties party.GetPartiesFn
+	last       []byte
+	counts     map[int64]map[string]int
+}
+
+func NewTurnout(k int, precincts Precincts, getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, getParties party.GetPartiesFn) *Turnout {
+	return &Turnout{
+		lock:       &sync.Mutex{},
+		k:          k,
+		precincts:  precincts,
+		getTip:     getTip,
+		getBlock:   getBlock,
+		getParties: getParties,
+		counts:     map[int64]map[string]int{},
+	}
+}
+
+// update counts the blocks added since the last update. Counting starts
+// over if the last counted block was rolled back.
+func (t *Turnout) update() error {
+	tip := t.getTip()
+	if bytes.Equal(tip, t.last) {
+		return nil
+	}
+	var added []blockchain.Block
+	found := false
+	for current := tip; current != nil; {
+		if bytes.Equal(current, t.last) {
+			found = true
+			break
+		}
+		block, err := t.getBlock(current)
+		if err != nil || block == nil {
+			return errors.Errorf("Failed to get block %x %s", current, err)
+		}
+		added = append(added, *block)
+		current = block.Header.Prev
+	}
+	if !found {
+		t.counts = map[int64]map[string]int{}
+	}
+	parties, err := t.getParties()
+	if err != nil {
+		return errors.Wrap(err, "Failed to retrieve parties")
+	}
+	partyKeys := map[string]bool{}
+	for _, p := range parties {
+		partyKeys[string(wallet.ExtractPublicKeyHash(p.Address))] = true
+	}
+	for i := len(added) - 1; i >= 0; i-- {
+		t.count(added[i], partyKeys)
+	}
+	t.last = tip
+	return nil
+}
+
+func (t *Turnout) count(block blockchain.Block, partyKeys map[string]bool) {
+	start := time.Unix(block.Header.Timestamp, 0).Truncate(Bucket).Unix()
+	for _, tx := range block.Body.Transactions {
+		senders := map[string]bool{}
+		for _, in := range tx.Inputs {
+			if !partyKeys[string(in.PublicKeyHash)] {
+				senders[string(in.PublicKeyHash)] = true
+			}
+		}
+		vote := false
+		for _, out := range tx.Outputs {
+			if partyKeys[string(out.PublicKeyHash)] && !senders[string(out.PublicKeyHash)] {
+				vote = true
+			}
+		}
+		if !vote {
+			continue
+		}
+		if t.counts[start] == nil {
+			t.counts[start] = map[string]int{}
+		}
+		for sender := range senders {
+			t.counts[start][t.precincts[sender]]++
+		}
+	}
+}
+
+func (t *Turnout) interval(start int64, counts map[string]int) Interval {
+	result := Interval{Start: start}
+	for _, c := range counts {
+		result.Votes += c
+	}
+	if result.Votes < t.k {
+		return Interval{Start: start, Suppressed: true}
+	}
+	var reported []string
+	hidden := 0
+	for precinct, c := range counts {
+		switch {
+		case precinct == "":
+			hidden++
+		case c < t.k:
+			result.Withheld++
+			hidden++
+		default:
+			reported = append(reported, precinct)
+		}
+	}
+	sort.Slice(reported, func(i, j int) bool {
+		if counts[reported[i]] != counts[reported[j]] {
+			return counts[reported[i]] < counts[reported[j]]
+		}
+		return reported[i] < reported[j]
+	})
+	if hidden == 1 && len(reported) > 0 {
+		reported = reported[1:]
+		result.Withheld++
+	}
+	if len(reported) > 0 {
+		result.Precincts = map[string]int{}
+		for _, precinct := range reported {
+			result.Precincts[precinct] = counts[precinct]
+		}
+	}
+	return result
+}
+
+func (t *Turnout) Series() (Series, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if err := t.update(); err != nil {
+		return Series{}, err
+	}
+	closed := time.Now().Truncate(Bucket).Unix()
+	result := Series{
+		Bucket:    int64(Bucket / time.Second),
+		K:         t.k,
+		Intervals: []Interval{},
+	}
+	for start, counts := range t.counts {
+		if start < closed {
+			result.Intervals = append(result.Intervals, t.interval(start, counts))
+		}
+	}
+	sort.Slice(result.Intervals, func(i, j int) bool {
+		return result.Intervals[i].Start < result.Intervals[j].Start
+	})
+	return result, nil
+}
@@ -0,0 +1,116 @@
+package anchor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type Anchor struct {
+	BlockHash []byte `json:"blockHash"`
+	Height    int    `json:"height"`
+	Service   string `json:"service"`
+	Proof     []byte `json:"proof"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type Anchors []Anchor
+
+type Anchorer interface {
+	Name() string
+	Anchor(digest []byte) ([]byte, error)
+}
+
+type SaveAnchorFn func(Anchor) error
+
+type GetAnchorsFn func(blockHash []byte) (Anchors, error)
+
+type openTimestamps struct {
+	calendar string
+	client   *http.Client
+}
+
+func NewOpenTimestamps(calendar string) Anchorer {
+	return openTimestamps{
+		calendar: strings.TrimSuffix(calendar, "/"),
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (o openTimestamps) Name() string {
+	return fmt.Sprintf("opentimestamps:%s", o.calendar)
+}
+
+func (o openTimestamps) Anchor(digest []byte) ([]byte, error) {
+	response, err := o.client.Post(o.calendar+"/digest", "application/x-www-form-urlencoded", bytes.NewReader(digest))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to submit digest %x to calendar %s", digest, o.calendar)
+	}
+	defer response.Body.Close()
+	proof, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read calendar response")
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Calendar %s responded with status %d: %s", o.calendar, response.StatusCode, proof)
+	}
+	return proof, nil
+}
+
+type notary struct {
+	url    string
+	client *http.Client
+}
+
+type notaryRequest struct {
+	Hash string `json:"hash"`
+}
+
+func NewNotary(url string) Anchorer {
+	return notary{
+		url:    url,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (n notary) Name() string {
+	return fmt.Sprintf("notary:%s", n.url)
+}
+
+func (n notary) Anchor(digest []byte) ([]byte, error) {
+	raw, err := json.Marshal(notaryRequest{Hash: hex.EncodeToString(digest)})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to marshal notary request")
+	}
+	response, err := n.client.Post(n.url, "application/json", bytes.NewReader(raw))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to submit digest %x to notary %s", digest, n.url)
+	}
+	defer response.Body.Close()
+	proof, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read notary response")
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return nil, errors.Errorf("Notary %s responded with status %d: %s", n.url, response.StatusCode, proof)
+	}
+	return proof, nil
+}
+
+func New(kind, url string) (Anchorer, error) {
+	switch kind {
+	case "ots":
+		return NewOpenTimestamps(url), nil
+	case "http":
+		return NewNotary(url), nil
+	default:
+		return nil, errors.Errorf("Unknown anchor type %s", kind)
+	}
+}
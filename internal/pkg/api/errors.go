@@ -60,3 +60,267 @@ func UserAlreadyVoted() Response {
 		},
 	}
 }
+
+func TokenAlreadyUsed() Response {
+	return Response{
+		Status: http.StatusConflict,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: "Submission token has already been used",
+				Type:    "token-already-used",
+			},
+		},
+	}
+}
+
+func VoterNotEligible() Response {
+	return Response{
+		Status: http.StatusForbidden,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: "Voter is not eligible to vote",
+				Type:    "voter-not-eligible",
+			},
+		},
+	}
+}
+
+func VoterAlreadyRegistered() Response {
+	return Response{
+		Status: http.StatusConflict,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: "Voter is already registered",
+				Type:    "voter-already-registered",
+			},
+		},
+	}
+}
+
+func NotFoundErrorResponse(message string) Response {
+	return Response{
+		Status: http.StatusNotFound,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: message,
+				Type:    "not-found-error",
+			},
+		},
+	}
+}
+
+func ElectionFinalized() Response {
+	return Response{
+		Status: http.StatusConflict,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: "Election has already been finalized",
+				Type:    "election-finalized",
+			},
+		},
+	}
+}
+
+func ProvisionalBallotAlreadySubmitted() Response {
+	return Response{
+		Status: http.StatusConflict,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: "Provisional ballot has already been submitted",
+				Type:    "provisional-ballot-already-submitted",
+			},
+		},
+	}
+}
+
+func ProvisionalBallotAlreadyDecided() Response {
+	return Response{
+		Status: http.StatusConflict,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: "Provisional ballot has already been adjudicated",
+				Type:    "provisional-ballot-already-decided",
+			},
+		},
+	}
+}
+
+func IntakeClosed() Response {
+	return Response{
+		Status: http.StatusForbidden,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: "Election has ended, votes are no longer accepted",
+				Type:    "intake-closed",
+			},
+		},
+	}
+}
+
+func FraudAlreadyRecorded() Response {
+	return Response{
+		Status: http.StatusConflict,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: "Fraud of the forger at the height has already been recorded",
+				Type:    "fraud-already-recorded",
+			},
+		},
+	}
+}
+
+func RejectedByPolicy(message string) Response {
+	return Response{
+		Status: http.StatusForbidden,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: message,
+				Type:    "rejected-by-policy",
+			},
+		},
+	}
+}
+
+func ElectionPaused() Response {
+	return Response{
+		Status: http.StatusServiceUnavailable,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: "Election is paused by its trustees, votes are not accepted until it is resumed",
+				Type:    "election-paused",
+			},
+		},
+	}
+}
+
+func IntakeSaturated() Response {
+	return Response{
+		Status: http.StatusServiceUnavailable,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: "Too many votes are waiting to be processed, try again later",
+				Type:    "intake-saturated",
+			},
+		},
+	}
+}
+
+func UnderMaintenance() Response {
+	return Response{
+		Status: http.StatusServiceUnavailable,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: "Election is under maintenance, try again shortly",
+				Type:    "maintenance",
+			},
+		},
+	}
+}
+
+func CompactionRefused(message string) Response {
+	return Response{
+		Status: http.StatusConflict,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: message,
+				Type:    "compaction-refused",
+			},
+		},
+	}
+}
+
+func PartyWithdrawn(message string) Response {
+	return Response{
+		Status: http.StatusConflict,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: message,
+				Type:    "party-withdrawn",
+			},
+		},
+	}
+}
+
+func ElectionClosed(message string) Response {
+	return Response{
+		Status: http.StatusForbidden,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: message,
+				Type:    "election-closed",
+			},
+		},
+	}
+}
+
+func ScopeMissing(message string) Response {
+	return Response{
+		Status: http.StatusForbidden,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: message,
+				Type:    "scope-missing",
+			},
+		},
+	}
+}
+
+func RateLimited(message string) Response {
+	return Response{
+		Status: http.StatusTooManyRequests,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: message,
+				Type:    "rate-limited",
+			},
+		},
+	}
+}
+
+func MempoolFull() Response {
+	return Response{
+		Status: http.StatusServiceUnavailable,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: "Too many votes are waiting to be forged, try again later",
+				Type:    "mempool-full",
+			},
+		},
+	}
+}
+
+func FaucetEmpty() Response {
+	return Response{
+		Status: http.StatusServiceUnavailable,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: "Faucet has no funds available, try again after the next block",
+				Type:    "faucet-empty",
+			},
+		},
+	}
+}
+
+func ReceiptInvalidated(message string) Response {
+	return Response{
+		Status: http.StatusGone,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: message,
+				Type:    "receipt-invalidated",
+			},
+		},
+	}
+}
+
+func PermissionMissing(message string) Response {
+	return Response{
+		Status: http.StatusForbidden,
+		Body: Error{
+			Error: ErrorInformation{
+				Message: message,
+				Type:    "permission-missing",
+			},
+		},
+	}
+}
@@ -5,10 +5,15 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
 )
 
 type Request struct {
 	Headers http.Header
+	Query   url.Values
+	Vars    map[string]string
 	Body    []byte
 }
 
@@ -19,27 +24,30 @@ type Response struct {
 
 type Handler func(Request) (Response, error)
 
+// handle runs h on the request, unexpected errors are answered with an
+// internal server error.
+func handle(h Handler, r *http.Request) Response {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return InternalServerErrorResponse()
+	}
+	request := Request{
+		Headers: r.Header,
+		Query:   r.URL.Query(),
+		Vars:    mux.Vars(r),
+		Body:    body,
+	}
+	result, err := h(request)
+	if err != nil {
+		log.Printf("Unexpected error occurred %s", err)
+		return InternalServerErrorResponse()
+	}
+	return result
+}
+
 func NewHandleFunc(h Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		body, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			res := InternalServerErrorResponse()
-			w.WriteHeader(res.Status)
-			json.NewEncoder(w).Encode(res.Body)
-			return
-		}
-		request := Request{
-			Headers: r.Header,
-			Body:    body,
-		}
-		result, err := h(request)
-		if err != nil {
-			log.Printf("Unexpected error occurred %s", err)
-			res := InternalServerErrorResponse()
-			w.WriteHeader(res.Status)
-			json.NewEncoder(w).Encode(res.Body)
-			return
-		}
+		result := handle(h, r)
 		w.WriteHeader(result.Status)
 		json.NewEncoder(w).Encode(result.Body)
 	}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+const (
+	DigestHeader            = "Digest"
+	SignatureHeader         = "X-Signature"
+	SignatureVerifierHeader = "X-Signature-Verifier"
+)
+
+type digestSignable []byte
+
+func (d digestSignable) Signable() ([]byte, error) {
+	return d, nil
+}
+
+// Signature is a detached signature of a body.
+type Signature struct {
+	Digest    string `json:"digest"`
+	Signature string `json:"signature"`
+	Verifier  string `json:"verifier"`
+}
+
+func digestOf(body []byte) string {
+	digest := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// SignBody signs the SHA-256 hash of the body.
+func SignBody(signer wallet.Signer, body []byte) (Signature, error) {
+	signer = wallet.Current(signer)
+	signature, err := signer.Sign(digestSignable(body))
+	if err != nil {
+		return Signature{}, err
+	}
+	return Signature{
+		Digest:    digestOf(body),
+		Signature: signature,
+		Verifier:  signer.Verifier(),
+	}, nil
+}
+
+// responseSigner remembers the signature of the last body, reads are
+// mostly answered with the same body until the next block.
+type responseSigner struct {
+	signer wallet.Signer
+	lock   sync.Mutex
+	last   Signature
+}
+
+func (s *responseSigner) sign(body []byte) (Signature, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.last.Signature != "" && s.last.Digest == digestOf(body) {
+		return s.last, nil
+	}
+	signature, err := SignBody(s.signer, body)
+	if err != nil {
+		return Signature{}, err
+	}
+	s.last = signature
+	return signature, nil
+}
+
+// NewSignedHandleFunc is NewHandleFunc whose successful responses carry a
+// detached signature. The body is the compact JSON encoding of the
+// response, Digest holds its SHA-256 hash and X-Signature the signature of
+// the hash made with the key in X-Signature-Verifier.
+func NewSignedHandleFunc(signer wallet.Signer, h Handler) http.HandlerFunc {
+	s := &responseSigner{signer: signer}
+	return func(w http.ResponseWriter, r *http.Request) {
+		result := handle(h, r)
+		body, err := json.Marshal(result.Body)
+		if err != nil {
+			log.Printf("Failed to encode response %s", err)
+			result = InternalServerErrorResponse()
+			body, _ = json.Marshal(result.Body)
+		}
+		if result.Status >= http.StatusOK && result.Status < http.StatusMultipleChoices {
+			signature, err := s.sign(body)
+			if err != nil {
+				log.Printf("Failed to sign response %s", err)
+				result = InternalServerErrorResponse()
+				body, _ = json.Marshal(result.Body)
+			} else {
+				w.Header().Set(DigestHeader, signature.Digest)
+				w.Header().Set(SignatureHeader, signature.Signature)
+				w.Header().Set(SignatureVerifierHeader, signature.Verifier)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(result.Status)
+		w.Write(body)
+	}
+}
+
+// VerifyResponse checks that the body was signed with the public key.
+func VerifyResponse(header http.Header, body []byte, publicKey []byte) error {
+	if header.Get(DigestHeader) != digestOf(body) {
+		return errors.New("Digest does not match the body")
+	}
+	signature, err := base64.StdEncoding.DecodeString(header.Get(SignatureHeader))
+	if err != nil || len(signature) == 0 {
+		return errors.New("Response is not signed")
+	}
+	if !wallet.Verify(digestSignable(body), signature, publicKey) {
+		return errors.New("Signature does not match the body")
+	}
+	return nil
+}
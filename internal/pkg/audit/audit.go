@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+type Entry struct {
+	Sequence  uint64 `json:"sequence"`
+	Timestamp int64  `json:"timestamp"`
+	Action    string `json:"action"`
+	Details   string `json:"details"`
+	PrevHash  []byte `json:"prevHash"`
+	Hash      []byte `json:"hash"`
+}
+
+type Entries []Entry
+
+// ComputeHash chains the entry to its predecessor so that removing or
+// altering any entry breaks every hash that follows it.
+func (e Entry) ComputeHash() []byte {
+	header := make([]byte, 16)
+	binary.BigEndian.PutUint64(header[:8], e.Sequence)
+	binary.BigEndian.PutUint64(header[8:], uint64(e.Timestamp))
+	hash := sha256.Sum256(bytes.Join(
+		[][]byte{
+			e.PrevHash,
+			header,
+			[]byte(e.Action),
+			[]byte(e.Details),
+		},
+		[]byte{0},
+	))
+	return hash[:]
+}
+
+func (entries Entries) Verify() error {
+	var prev []byte
+	for _, e := range entries {
+		if !bytes.Equal(e.PrevHash, prev) {
+			return errors.Errorf("Audit entry %d is not linked to its predecessor", e.Sequence)
+		}
+		if !bytes.Equal(e.Hash, e.ComputeHash()) {
+			return errors.Errorf("Audit entry %d has been tampered with", e.Sequence)
+		}
+		prev = e.Hash
+	}
+	return nil
+}
+
+type RecordFn func(action, details string) error
+
+type GetEntriesFn func() (Entries, error)
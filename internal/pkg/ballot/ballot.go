@@ -0,0 +1,217 @@
+package ballot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+
+	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+// Definition describes a question of the election. A question without
+// choices is answered by voting for one of the party nodes.
+type Definition struct {
+	Name    string   `json:"name"`
+	Choices []string `json:"choices"`
+}
+
+type Definitions []Definition
+
+type Question struct {
+	Name    string        `json:"name"`
+	Choices party.Parties `json:"choices"`
+}
+
+type Questions []Question
+
+// Tally is the result of every question and the value a voter needs to
+// answer all of them.
+type Tally struct {
+	Value     int       `json:"value"`
+	Questions Questions `json:"questions"`
+}
+
+// ReadDefinitions reads the questions of the election from a JSON file.
+// Only a single question can be answered by voting for the party nodes.
+func ReadDefinitions(fileName string) (Definitions, error) {
+	raw, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read ballot %s", fileName)
+	}
+	var result Definitions
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse ballot %s", fileName)
+	}
+	names := map[string]bool{}
+	partyQuestions := 0
+	for _, d := range result {
+		if names[d.Name] {
+			return nil, errors.Errorf("Question %q is defined more than once", d.Name)
+		}
+		names[d.Name] = true
+		if len(d.Choices) == 0 {
+			partyQuestions++
+		}
+	}
+	switch {
+	case len(result) == 0:
+		return nil, errors.New("Ballot has no questions")
+	case partyQuestions > 1:
+		return nil, errors.New("Only one question can be answered with the party nodes")
+	}
+	return result, nil
+}
+
+// ChoiceAddress derives the address of a choice which is not a party node.
+// Nobody holds a key of the address so votes given to it can't be spent.
+func ChoiceAddress(question, choice string) (string, error) {
+	hashed := sha256.Sum256([]byte(question + "\x00" + choice))
+	return wallet.ExtractAddress(hashed[:])
+}
+
+// Parties returns the choices of every question. Choices of the question
+// without choices are the party nodes.
+func (definitions Definitions) Parties(nodes party.Parties) (party.Parties, error) {
+	result := party.Parties{}
+	for _, d := range definitions {
+		if len(d.Choices) == 0 {
+			for _, n := range nodes {
+				n.Question = d.Name
+				result = append(result, n)
+			}
+			continue
+		}
+		for _, c := range d.Choices {
+			address, err := ChoiceAddress(d.Name, c)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to create address of choice %s", c)
+			}
+			result = append(result, party.Party{
+				Name:     c,
+				Address:  address,
+				Question: d.Name,
+			})
+		}
+	}
+	return result, nil
+}
+
+// Nodes returns the parties which are party nodes, leaving out choices
+// whose addresses are derived with ChoiceAddress.
+func Nodes(parties party.Parties) party.Parties {
+	result := party.Parties{}
+	for _, p := range parties {
+		if address, err := ChoiceAddress(p.Question, p.Name); err == nil && address == p.Address {
+			continue
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// Group groups parties into questions sorted by name.
+func Group(parties party.Parties) Questions {
+	index := map[string]int{}
+	result := Questions{}
+	for _, p := range parties {
+		i, ok := index[p.Question]
+		if !ok {
+			i = len(result)
+			index[p.Question] = i
+			result = append(result, Question{Name: p.Question})
+		}
+		result[i].Choices = append(result[i].Choices, p)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Value is the number of votes a voter needs to answer every question.
+func (questions Questions) Value() int {
+	if len(questions) == 0 {
+		return transaction.VoteValue
+	}
+	return len(questions) * transaction.VoteValue
+}
+
+// Validate checks that the recipients answer every question with exactly
+// one of its choices.
+func (questions Questions) Validate(recipients [][]byte) error {
+	answered := map[string]bool{}
+	for _, r := range recipients {
+		q, ok := questions.find(r)
+		switch {
+		case !ok:
+			return errors.Errorf("Recipient %x is not a choice on the ballot", r)
+		case answered[q]:
+			return errors.Errorf("Question %q is answered more than once", q)
+		}
+		answered[q] = true
+	}
+	for _, q := range questions {
+		if !answered[q.Name] {
+			return errors.Errorf("Question %q is not answered", q.Name)
+		}
+	}
+	return nil
+}
+
+func (questions Questions) find(recipient []byte) (string, bool) {
+	for _, q := range questions {
+		for _, c := range q.Choices {
+			if bytes.Equal(wallet.ExtractPublicKeyHash(c.Address), recipient) {
+				return q.Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Body is the request a voter submits to cast a ballot.
+type Body struct {
+	Sender     string   `json:"sender"`
+	Recipients []string `json:"recipients"`
+	Verifier   string   `json:"verifier"`
+	Signature  string   `json:"signature"`
+}
+
+// NewBody signs a ballot giving a vote to every recipient out of the
+// voter's value.
+func NewBody(w wallet.Wallet, recipients [][]byte, value int) (*Body, error) {
+	signature, err := wallet.Sign(transaction.NewBallotSignable(w.PublicKeyHash(), recipients, value), w.PrivateKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to sign ballot")
+	}
+	body := Body{
+		Sender:    w.Address,
+		Verifier:  base64.StdEncoding.EncodeToString(w.PublicKey),
+		Signature: base64.StdEncoding.EncodeToString(signature),
+	}
+	for _, r := range recipients {
+		body.Recipients = append(body.Recipients, wallet.EncodeAddress(r))
+	}
+	return &body, nil
+}
+
+// Choice finds the choice of the question by its name.
+func (questions Questions) Choice(question, name string) (party.Party, bool) {
+	for _, q := range questions {
+		if q.Name != question {
+			continue
+		}
+		for _, c := range q.Choices {
+			if c.Name == name {
+				return c, true
+			}
+		}
+	}
+	return party.Party{}, false
+}
@@ -0,0 +1,19 @@
+package blockchain
+
+// Annotation notes something about how a block was forged which doesn't make
+// it invalid, e.g. that the clock of its forger was off. ClockOffset is in
+// milliseconds.
+type Annotation struct {
+	Block       []byte `json:"block"`
+	Height      int    `json:"height"`
+	NodeID      string `json:"nodeId"`
+	Note        string `json:"note"`
+	ClockOffset int64  `json:"clockOffset,omitempty"`
+	At          int64  `json:"at"`
+}
+
+type Annotations []Annotation
+
+type SaveAnnotationFn func(Annotation) error
+
+type GetAnnotationsFn func() (Annotations, error)
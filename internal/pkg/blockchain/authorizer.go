@@ -1,29 +1,36 @@
 package blockchain
 
 import (
-	"encoding/base64"
+	"bytes"
 	"fmt"
 	"log"
 
+	"github.com/nebser/crypto-vote/internal/pkg/transport"
 	"github.com/nebser/crypto-vote/internal/pkg/wallet"
 	"github.com/pkg/errors"
 
 	"github.com/nebser/crypto-vote/internal/pkg/websocket"
 )
 
-func BlockchainAuthorizer(findBlock FindBlockFn) websocket.Authorizer {
+func verifyPing(ping websocket.Ping, findCertificate transport.FindCertificateFn) error {
+	switch ok, err := transport.VerifySignature(findCertificate)(ping, ping.Signature, ping.Sender); {
+	case err != nil:
+		return websocket.ErrUnauthorized("Invalid signature")
+	case !ok:
+		return websocket.ErrUnauthorized("Signature does not match the payload")
+	default:
+		return nil
+	}
+}
+
+func BlockchainAuthorizer(findBlock FindBlockFn, findCertificate transport.FindCertificateFn) websocket.Authorizer {
 	return func(ping websocket.Ping) error {
-		rawPublicKey, err := base64.StdEncoding.DecodeString(ping.Sender)
-		if err != nil {
-			return websocket.ErrUnauthorized("Invalid public key")
+		if err := verifyPing(ping, findCertificate); err != nil {
+			return err
 		}
-		rawSignature, err := base64.StdEncoding.DecodeString(ping.Signature)
+		rawPublicKey, err := transport.Identity(findCertificate, ping.Sender)
 		if err != nil {
-			return websocket.ErrUnauthorized("Invalid signature")
-		}
-
-		if !wallet.Verify(ping, rawSignature, rawPublicKey) {
-			return websocket.ErrUnauthorized("Signature does not match the payload")
+			return websocket.ErrUnauthorized("Invalid public key")
 		}
 
 		publicKeyHashed, err := wallet.HashedPublicKey(rawPublicKey)
@@ -47,3 +54,21 @@ func BlockchainAuthorizer(findBlock FindBlockFn) websocket.Authorizer {
 		}
 	}
 }
+
+// IdentityAuthorizer accepts pings signed by the identity itself or by its
+// certified transport key.
+func IdentityAuthorizer(identity []byte, findCertificate transport.FindCertificateFn) websocket.Authorizer {
+	return func(ping websocket.Ping) error {
+		if err := verifyPing(ping, findCertificate); err != nil {
+			return err
+		}
+		switch sender, err := transport.Identity(findCertificate, ping.Sender); {
+		case err != nil:
+			return websocket.ErrUnauthorized("Invalid public key")
+		case !bytes.Equal(sender, identity):
+			return websocket.ErrUnauthorized(fmt.Sprintf("Node %s is not allowed to send %s", ping.Sender, ping.Message))
+		default:
+			return nil
+		}
+	}
+}
@@ -2,12 +2,14 @@ package blockchain
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/nebser/crypto-vote/internal/pkg/codec"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/merkle"
 	"github.com/nebser/crypto-vote/internal/pkg/transaction"
 	"github.com/pkg/errors"
 )
@@ -17,8 +19,12 @@ type Metadata struct {
 	Size        int
 }
 
+// Header names the Algorithm the block and the Merkle tree over its
+// transactions are hashed with from AlgorithmVersion on, it is the one of
+// the genesis block throughout the blockchain.
 type Header struct {
 	Version         int
+	Algorithm       digest.Algorithm `json:",omitempty"`
 	Prev            []byte
 	TransactionHash []byte
 	Hash            []byte
@@ -47,6 +53,7 @@ func (b Block) String() string {
 	builder.WriteString("-----BEGIN BLOCK-----\n")
 	builder.WriteString(fmt.Sprintf("Size: %d\n", b.Metadata.Size))
 	builder.WriteString(fmt.Sprintf("Hash: %x\n", b.Header.Hash))
+	builder.WriteString(fmt.Sprintf("Algorithm: %s\n", b.Header.Algorithm.Normalized()))
 	t := time.Unix(b.Header.Timestamp, 0)
 	builder.WriteString("Timestamp: ")
 	builder.WriteString(t.Format(time.RFC3339))
@@ -56,14 +63,70 @@ func (b Block) String() string {
 	return builder.String()
 }
 
-func NewBlock(previousBlock []byte, transactions transaction.Transactions) (*Block, error) {
-	transactionsHash := transactions.Hash()
+// Size returns the serialized size of the block in bytes.
+// Write appends the canonical binary encoding of the block.
+func (b Block) Write(w *codec.Writer) {
+	w.Int(int64(b.Metadata.MagicNumber)).
+		Int(int64(b.Metadata.Size)).
+		Int(int64(b.Header.Version))
+	if b.Header.Version >= AlgorithmVersion {
+		w.String(string(b.Header.Algorithm))
+	}
+	w.Bytes(b.Header.Prev).
+		Bytes(b.Header.TransactionHash).
+		Int(b.Header.Timestamp).
+		Int(int64(b.Body.TransactionsCount)).
+		Uint(uint64(len(b.Body.Transactions)))
+	for _, tx := range b.Body.Transactions {
+		tx.Write(w)
+	}
+	w.Bytes(b.Header.Hash)
+}
+
+// ReadBlock reads a block written by Write, errors are reported by the
+// reader.
+func ReadBlock(r *codec.Reader) Block {
+	var b Block
+	b.Metadata.MagicNumber = int(r.Int())
+	b.Metadata.Size = int(r.Int())
+	b.Header.Version = int(r.Int())
+	if b.Header.Version >= AlgorithmVersion {
+		b.Header.Algorithm = digest.Algorithm(r.String())
+	}
+	b.Header.Prev = r.Bytes()
+	b.Header.TransactionHash = r.Bytes()
+	b.Header.Timestamp = r.Int()
+	b.Body.TransactionsCount = int(r.Int())
+	count := r.Uint()
+	for i := uint64(0); i < count && r.Err() == nil; i++ {
+		b.Body.Transactions = append(b.Body.Transactions, transaction.Read(r))
+	}
+	b.Header.Hash = r.Bytes()
+	return b
+}
+
+func (b Block) Size() int {
+	w := codec.NewWriter()
+	b.Write(w)
+	return len(w.Result())
+}
+
+// NewBlock creates a block on the previous one hashed with the algorithm,
+// which has to be the one of the previous block.
+func NewBlock(algorithm digest.Algorithm, previousBlock []byte, transactions transaction.Transactions) (*Block, error) {
+	if !algorithm.Known() {
+		return nil, errors.Wrapf(digest.ErrUnknownAlgorithm, "Algorithm %s", algorithm)
+	}
+	algorithm = algorithm.Normalized()
+	transactionsHash := TransactionHash(Version, algorithm, transactions)
 	timestamp := time.Now().Unix()
-	blockHash, err := createHash(previousBlock, transactionsHash, timestamp)
+	blockHash, err := createHash(Version, algorithm, previousBlock, transactionsHash, timestamp)
 	if err != nil {
 		return nil, errors.New("Failed to create block hash")
 	}
 	header := Header{
+		Version:         Version,
+		Algorithm:       algorithm,
 		Prev:            previousBlock,
 		TransactionHash: transactionsHash,
 		Timestamp:       timestamp,
@@ -72,7 +135,7 @@ func NewBlock(previousBlock []byte, transactions transaction.Transactions) (*Blo
 	return &Block{
 		Header: header,
 		Metadata: Metadata{
-			MagicNumber: magicNumber,
+			MagicNumber: MagicNumber,
 			Size:        len(transactions),
 		},
 		Body: Body{
@@ -82,21 +145,75 @@ func NewBlock(previousBlock []byte, transactions transaction.Transactions) (*Blo
 	}, nil
 }
 
-func createHash(previousBlock, transactionsHash []byte, timestamp int64) ([]byte, error) {
+// NewBlockOn creates a block on the previous one hashed with its algorithm.
+func NewBlockOn(getBlock GetBlockFn, previousBlock []byte, transactions transaction.Transactions) (*Block, error) {
+	previous, err := getBlock(previousBlock)
+	switch {
+	case err != nil:
+		return nil, errors.Wrapf(err, "Failed to get block %x", previousBlock)
+	case previous == nil:
+		return nil, errors.Wrapf(ErrUnknownParent, "Block %x is missing", previousBlock)
+	}
+	return NewBlock(previous.HashAlgorithm(), previousBlock, transactions)
+}
+
+// TransactionHash returns the hash a block of the version commits its
+// transactions with.
+func TransactionHash(version int, algorithm digest.Algorithm, transactions transaction.Transactions) []byte {
+	if version < MerkleVersion {
+		return transactions.Hash()
+	}
+	return merkle.Root(merkleAlgorithm(version, algorithm), transactions.IDs())
+}
+
+func merkleAlgorithm(version int, algorithm digest.Algorithm) digest.Algorithm {
+	if version < AlgorithmVersion {
+		return digest.SHA256
+	}
+	return algorithm.Normalized()
+}
+
+// HashAlgorithm is the algorithm the block and the Merkle tree over its
+// transactions are hashed with.
+func (b Block) HashAlgorithm() digest.Algorithm {
+	return merkleAlgorithm(b.Header.Version, b.Header.Algorithm)
+}
+
+// createHash commits to the algorithm too from AlgorithmVersion on, so the
+// tag of a block can't be changed.
+func createHash(version int, algorithm digest.Algorithm, previousBlock, transactionsHash []byte, timestamp int64) ([]byte, error) {
 	timestampBytes, err := intToHex(timestamp)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Failed to convert timestamp %d to byte array", timestamp)
 	}
-	hashable := bytes.Join(
-		[][]byte{
-			previousBlock,
-			transactionsHash,
-			timestampBytes,
-		},
-		[]byte{},
-	)
-	hash := sha256.Sum256(hashable)
-	return hash[:], nil
+	parts := [][]byte{
+		previousBlock,
+		transactionsHash,
+		timestampBytes,
+	}
+	if version < AlgorithmVersion {
+		algorithm = digest.SHA256
+	} else {
+		parts = append(parts, []byte(algorithm))
+	}
+	if !algorithm.Known() {
+		return nil, errors.Wrapf(digest.ErrUnknownAlgorithm, "Algorithm %s", algorithm)
+	}
+	return algorithm.Sum(bytes.Join(parts, []byte{})), nil
+}
+
+// hash returns the hash the header of the block should have.
+func (b Block) hash() ([]byte, error) {
+	transactionHash := TransactionHash(b.Header.Version, b.Header.Algorithm, b.Body.Transactions)
+	return createHash(b.Header.Version, b.Header.Algorithm, b.Header.Prev, transactionHash, b.Header.Timestamp)
+}
+
+func (b Block) IsHashValid() bool {
+	blockHash, err := b.hash()
+	if err != nil {
+		return false
+	}
+	return bytes.Compare(b.Header.Hash, blockHash) == 0
 }
 
 func intToHex(num int64) ([]byte, error) {
@@ -114,7 +231,7 @@ func VerfiyBlock(verifyTransaction transaction.VerifyTransctionFn, isStakeTransa
 				return false
 			}
 		}
-		if len(block.Body.Transactions) == 0 {
+		if len(block.Body.Transactions) == 0 || block.Size() > MaxBlockBytes {
 			return false
 		}
 		if !isStakeTransaction(block.Body.Transactions[0]) {
@@ -123,8 +240,7 @@ func VerfiyBlock(verifyTransaction transaction.VerifyTransctionFn, isStakeTransa
 		if !block.Body.Transactions[0].AreInputsFrom(hashedSender) {
 			return false
 		}
-		transactionHash := block.Body.Transactions.Hash()
-		blockHash, err := createHash(block.Header.Prev, transactionHash, block.Header.Timestamp)
+		blockHash, err := block.hash()
 		if err != nil {
 			return false
 		}
@@ -132,9 +248,16 @@ func VerfiyBlock(verifyTransaction transaction.VerifyTransctionFn, isStakeTransa
 	}
 }
 
+// IsReturnStakeBlock accepts a block the alfa node forged itself out of a
+// single return stake transaction or a single emergency, which has to get on
+// chain while block production is paused.
 func IsReturnStakeBlock(verifyTransaction transaction.VerifyTransctionFn, alfaKeyHash []byte) IsReturnStakeBlockFn {
+	isReturnStakeTransaction := transaction.IsReturnStakeTransaction(alfaKeyHash)
 	return func(block Block, sender []byte) bool {
-		if len(block.Body.Transactions) != 1 || !transaction.IsReturnStakeTransaction(alfaKeyHash)(block.Body.Transactions[0]) {
+		if len(block.Body.Transactions) != 1 {
+			return false
+		}
+		if t := block.Body.Transactions[0]; !isReturnStakeTransaction(t) && !t.IsEmergency() {
 			return false
 		}
 		if bytes.Compare(alfaKeyHash, sender) != 0 {
@@ -143,8 +266,7 @@ func IsReturnStakeBlock(verifyTransaction transaction.VerifyTransctionFn, alfaKe
 		if !verifyTransaction(block.Body.Transactions[0]) {
 			return false
 		}
-		transactionHash := block.Body.Transactions.Hash()
-		blockHash, err := createHash(block.Header.Prev, transactionHash, block.Header.Timestamp)
+		blockHash, err := block.hash()
 		if err != nil {
 			return false
 		}
@@ -1,16 +1,31 @@
 package blockchain
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
 	"github.com/nebser/crypto-vote/internal/pkg/transaction"
 	"github.com/pkg/errors"
 )
 
 const (
-	magicNumber  = 0x100
-	version      = 0
-	MaxBlockSize = 256
+	// MagicNumber and Version are written into the metadata and the header
+	// of every forged block.
+	MagicNumber = 0x100
+	// MerkleVersion is the first version of blocks whose transaction hash is
+	// the root of a Merkle tree over the transaction ids, blocks before it
+	// hash the ids concatenated.
+	MerkleVersion = 1
+	// AlgorithmVersion is the first version of blocks naming the algorithm
+	// they are hashed with, blocks before it are hashed with SHA-256.
+	AlgorithmVersion = 2
+	Version          = AlgorithmVersion
+	// MaxBlockBytes limits the serialized size of a block and
+	// MaxTransactionsBytes leaves room for its header.
+	MaxBlockBytes        = 256 << 10
+	MaxTransactionsBytes = MaxBlockBytes - 1<<10
 )
 
 type GetTipFn func() []byte
@@ -23,6 +38,17 @@ type AddBlocksFn func(Blocks) ([]byte, error)
 
 type GetBlockFn func(hash []byte) (*Block, error)
 
+// Within stops a walk over the blockchain once the context is done, every
+// block read after it fails with the error of the context.
+func (g GetBlockFn) Within(ctx context.Context) GetBlockFn {
+	return func(hash []byte) (*Block, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return g(hash)
+	}
+}
+
 type FindBlockFn func(criteria func(Block) bool) (Block, bool, error)
 
 type ForgeBlockFn func(transaction.Transactions) (*Block, error)
@@ -31,6 +57,8 @@ type AddNewBlockFn func(Block) error
 
 var ErrInvalidBlock = errors.New("Block is not valid")
 
+var ErrHeightNotReached = errors.New("Blockchain has not reached the height")
+
 func GetHeight(getTip GetTipFn, getBlock GetBlockFn) (int, error) {
 	result := 0
 	for current := getTip(); current != nil; {
@@ -44,6 +72,44 @@ func GetHeight(getTip GetTipFn, getBlock GetBlockFn) (int, error) {
 	return result, nil
 }
 
+// VerifyAlgorithm makes sure the node hashes with the algorithm the genesis
+// block was hashed with. There is nothing to verify before the blockchain
+// has a genesis block.
+func VerifyAlgorithm(findBlock FindBlockFn, algorithm digest.Algorithm) error {
+	genesis, ok, err := findBlock(func(b Block) bool {
+		return len(b.Header.Prev) == 0
+	})
+	switch {
+	case err != nil:
+		return errors.Wrap(err, "Failed to find genesis block")
+	case !ok:
+		return nil
+	}
+	if committed := genesis.HashAlgorithm(); committed != algorithm.Normalized() {
+		return errors.Errorf("Blockchain is hashed with %s but the node runs %s", committed, algorithm)
+	}
+	return nil
+}
+
+// GetGenesis walks the blockchain from the tip and returns the hash of its
+// genesis block together with the height.
+func GetGenesis(getTip GetTipFn, getBlock GetBlockFn) ([]byte, int, error) {
+	var genesis []byte
+	height := 0
+	for current := getTip(); current != nil; height++ {
+		block, err := getBlock(current)
+		switch {
+		case err != nil:
+			return nil, 0, errors.Wrapf(err, "Failed to get block %x", current)
+		case block == nil:
+			return nil, 0, errors.Errorf("Block %x is missing", current)
+		}
+		genesis = current
+		current = block.Header.Prev
+	}
+	return genesis, height, nil
+}
+
 func FindBlock(getTip GetTipFn, getBlock GetBlockFn) FindBlockFn {
 	return func(criteria func(Block) bool) (Block, bool, error) {
 		for current := getTip(); current != nil; {
@@ -83,3 +149,42 @@ func printOne(hash []byte, getBlock GetBlockFn) error {
 	fmt.Printf("%s", *block)
 	return printOne(block.Header.Prev, getBlock)
 }
+
+func FindTransaction(findBlock FindBlockFn) transaction.FindTransactionFn {
+	return func(id []byte) (*transaction.Transaction, bool, error) {
+		var result transaction.Transaction
+		_, found, err := findBlock(func(b Block) bool {
+			t, ok := b.Body.Transactions.Find(func(t transaction.Transaction) bool {
+				return bytes.Equal(t.ID, id)
+			})
+			result = t
+			return ok
+		})
+		if err != nil || !found {
+			return nil, false, err
+		}
+		return &result, true, nil
+	}
+}
+
+// FindGuardianship finds the latest valid designation of the guardians of
+// the voter given by its public key hash.
+func FindGuardianship(findBlock FindBlockFn) transaction.FindGuardianshipFn {
+	return func(voter []byte) (*transaction.Transaction, bool, error) {
+		var result transaction.Transaction
+		_, found, err := findBlock(func(b Block) bool {
+			for i := len(b.Body.Transactions) - 1; i >= 0; i-- {
+				t := b.Body.Transactions[i]
+				if t.IsGuardianship() && t.Guardianship.Verified() && bytes.Equal(t.Guardianship.VoterHash(), voter) {
+					result = t
+					return true
+				}
+			}
+			return false
+		})
+		if err != nil || !found {
+			return nil, false, err
+		}
+		return &result, true, nil
+	}
+}
@@ -0,0 +1,161 @@
+package blockchain
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
+)
+
+var (
+	cacheHits      = metrics.NewCounter("block_cache_hits_total", "Number of blocks served from the block cache")
+	cacheMisses    = metrics.NewCounter("block_cache_misses_total", "Number of blocks loaded from the database because they were not cached")
+	cacheEvictions = metrics.NewCounter("block_cache_evictions_total", "Number of blocks evicted from the block cache")
+	cachePurges    = metrics.NewCounter("block_cache_purges_total", "Number of times the block cache was purged because of a reorganization")
+	cacheEntries   = metrics.NewGauge("block_cache_entries", "Number of blocks currently held in the block cache")
+	cacheBytes     = metrics.NewGauge("block_cache_bytes", "Estimated size of the blocks currently held in the block cache")
+)
+
+type cacheEntry struct {
+	hash  string
+	block Block
+	size  int
+}
+
+type BlockCache struct {
+	getBlock GetBlockFn
+	maxBytes int
+	size     int
+	entries  map[string]*list.Element
+	recent   *list.List
+	lock     *sync.Mutex
+}
+
+func NewBlockCache(getBlock GetBlockFn, maxBytes int) *BlockCache {
+	return &BlockCache{
+		getBlock: getBlock,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		recent:   list.New(),
+		lock:     &sync.Mutex{},
+	}
+}
+
+func blockSize(b Block) int {
+	size := len(b.Header.Hash) + len(b.Header.Prev) + len(b.Header.TransactionHash) + 64
+	for _, tx := range b.Body.Transactions {
+		size += len(tx.ID) + 8
+		for _, in := range tx.Inputs {
+			size += len(in.TransactionID) + len(in.PublicKeyHash) + len(in.Signature) + len(in.Verifier) + 8
+		}
+		for _, out := range tx.Outputs {
+			size += len(out.PublicKeyHash) + 8
+		}
+	}
+	return size
+}
+
+func (c *BlockCache) GetBlock(hash []byte) (*Block, error) {
+	c.lock.Lock()
+	if element, ok := c.entries[string(hash)]; ok {
+		c.recent.MoveToFront(element)
+		block := element.Value.(*cacheEntry).block
+		c.lock.Unlock()
+		cacheHits.Inc()
+		return &block, nil
+	}
+	c.lock.Unlock()
+	cacheMisses.Inc()
+	block, err := c.getBlock(hash)
+	if err != nil || block == nil {
+		return block, err
+	}
+	c.add(hash, *block)
+	return block, nil
+}
+
+func (c *BlockCache) add(hash []byte, block Block) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.entries[string(hash)]; ok {
+		return
+	}
+	entry := &cacheEntry{hash: string(hash), block: block, size: blockSize(block)}
+	if entry.size > c.maxBytes {
+		return
+	}
+	c.entries[entry.hash] = c.recent.PushFront(entry)
+	c.size += entry.size
+	for c.size > c.maxBytes {
+		oldest := c.recent.Back()
+		c.remove(oldest)
+		cacheEvictions.Inc()
+	}
+	c.updateGauges()
+}
+
+func (c *BlockCache) remove(element *list.Element) {
+	entry := element.Value.(*cacheEntry)
+	c.recent.Remove(element)
+	delete(c.entries, entry.hash)
+	c.size -= entry.size
+}
+
+func (c *BlockCache) updateGauges() {
+	cacheEntries.Set(float64(len(c.entries)))
+	cacheBytes.Set(float64(c.size))
+}
+
+func (c *BlockCache) Invalidate(hash []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if element, ok := c.entries[string(hash)]; ok {
+		c.remove(element)
+		c.updateGauges()
+	}
+}
+
+func (c *BlockCache) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.recent.Init()
+	c.size = 0
+	c.updateGauges()
+	cachePurges.Inc()
+}
+
+func (c *BlockCache) isReorg(getTip GetTipFn, block Block) bool {
+	tip := getTip()
+	return tip != nil && bytes.Compare(block.Header.Prev, tip) != 0
+}
+
+func (c *BlockCache) AddBlock(getTip GetTipFn, addBlock AddBlockFn) AddBlockFn {
+	return func(block Block) ([]byte, error) {
+		if c.isReorg(getTip, block) {
+			c.Purge()
+		}
+		return addBlock(block)
+	}
+}
+
+func (c *BlockCache) AddNewBlock(getTip GetTipFn, addNewBlock AddNewBlockFn) AddNewBlockFn {
+	return func(block Block) error {
+		if c.isReorg(getTip, block) {
+			c.Purge()
+		}
+		return addNewBlock(block)
+	}
+}
+
+func (c *BlockCache) Rollback(rollback RollbackFn) RollbackFn {
+	return func() (*Block, error) {
+		block, err := rollback()
+		if err != nil {
+			return nil, err
+		}
+		c.Invalidate(block.Header.Hash)
+		return block, nil
+	}
+}
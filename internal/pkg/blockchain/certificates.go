@@ -0,0 +1,93 @@
+package blockchain
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/nebser/crypto-vote/internal/pkg/transport"
+	"github.com/pkg/errors"
+)
+
+// CertificateIndex holds the certificates of the blockchain by verifier and
+// the verifier of the latest certificate of every identity, so a message
+// signed with an unknown key is refused without scanning the blockchain.
+// Blocks added since the last lookup are indexed on the next one, a tip
+// which doesn't descend from the indexed one, e.g. after a rollback or a
+// reorganization, rebuilds the index.
+type CertificateIndex struct {
+	getTip     GetTipFn
+	getBlock   GetBlockFn
+	lock       *sync.Mutex
+	tip        []byte
+	byVerifier map[string]transport.Certificate
+	latest     map[string]string
+}
+
+func NewCertificateIndex(getTip GetTipFn, getBlock GetBlockFn) *CertificateIndex {
+	return &CertificateIndex{
+		getTip:     getTip,
+		getBlock:   getBlock,
+		lock:       &sync.Mutex{},
+		byVerifier: map[string]transport.Certificate{},
+		latest:     map[string]string{},
+	}
+}
+
+func (x *CertificateIndex) add(b Block) {
+	for _, t := range b.Body.Transactions {
+		if c := t.Certificate; c != nil {
+			x.byVerifier[c.Verifier()] = *c
+			x.latest[string(c.Identity)] = c.Verifier()
+		}
+	}
+}
+
+func (x *CertificateIndex) sync() error {
+	tip := x.getTip()
+	if bytes.Equal(tip, x.tip) {
+		return nil
+	}
+	var added Blocks
+	current := tip
+	for len(current) > 0 && !bytes.Equal(current, x.tip) {
+		block, err := x.getBlock(current)
+		switch {
+		case err != nil:
+			return errors.Wrapf(err, "Failed to get block %x", current)
+		case block == nil:
+			return errors.Errorf("Block %x is missing", current)
+		}
+		added = append(added, *block)
+		current = block.Header.Prev
+	}
+	if len(current) == 0 {
+		x.byVerifier, x.latest = map[string]transport.Certificate{}, map[string]string{}
+	}
+	for i := len(added) - 1; i >= 0; i-- {
+		x.add(added[i])
+	}
+	x.tip = tip
+	return nil
+}
+
+// Find returns the certificate of the transport key only if it is the latest
+// one issued by its identity, so issuing a new certificate revokes the
+// previous transport key.
+func (x *CertificateIndex) Find(verifier string) (*transport.Certificate, error) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	if err := x.sync(); err != nil {
+		return nil, err
+	}
+	certificate, ok := x.byVerifier[verifier]
+	if !ok || x.latest[string(certificate.Identity)] != verifier {
+		return nil, nil
+	}
+	return &certificate, nil
+}
+
+// FindCertificate looks certificates up in an index of its own, processes
+// share one index with NewCertificateIndex instead.
+func FindCertificate(getTip GetTipFn, getBlock GetBlockFn) transport.FindCertificateFn {
+	return NewCertificateIndex(getTip, getBlock).Find
+}
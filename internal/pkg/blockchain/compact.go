@@ -0,0 +1,378 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/nebser/crypto-vote/internal/pkg/codec"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+var (
+	compactReceived      = metrics.NewCounter("compact_blocks_received_total", "Number of compact blocks received")
+	compactReconstructed = metrics.NewCounter("compact_blocks_reconstructed_total", "Number of compact blocks reconstructed without fetching transactions")
+	compactFetched       = metrics.NewCounter("compact_block_transactions_fetched_total", "Number of transactions of compact blocks fetched from the sender")
+	compactFallbacks     = metrics.NewCounter("compact_block_fallbacks_total", "Number of compact blocks requested in full because they couldn't be reconstructed")
+)
+
+// Relay tells how forged blocks are sent to peers. Compact blocks carry the
+// header and the transaction ids, peers take the transactions from their
+// pending ones and fetch only those they miss.
+type Relay string
+
+const (
+	CompactRelay Relay = "compact"
+	FullRelay    Relay = "full"
+)
+
+func ParseRelay(raw string) (Relay, error) {
+	switch r := Relay(raw); r {
+	case CompactRelay, FullRelay:
+		return r, nil
+	default:
+		return "", errors.Errorf("Unknown block relay %s", raw)
+	}
+}
+
+// Forged is the body of a forged block message.
+type Forged struct {
+	Height int   `json:"height"`
+	Block  Block `json:"block"`
+}
+
+// Announce returns the message the forged block is sent to peers with.
+func (r Relay) Announce(height int, b Block) websocket.Pong {
+	if r == CompactRelay {
+		return websocket.Pong{
+			Message: websocket.CompactBlockMessage,
+			Body:    compactForged{Height: height, Block: b.Compact()},
+		}
+	}
+	return websocket.Pong{
+		Message: websocket.BlockForgedMessage,
+		Body:    websocket.BlockForgedBody{Height: height, Block: b},
+	}
+}
+
+// Prefilled is a transaction sent within a compact block, with its index
+// in the block.
+type Prefilled struct {
+	Index       int                     `json:"index"`
+	Transaction transaction.Transaction `json:"transaction"`
+}
+
+// CompactBlock is a block with the ids of its transactions in place of
+// them. The header is complete, so its hash can be checked and a compact
+// block can serve in a fraud proof like a full one.
+type CompactBlock struct {
+	Metadata  Metadata
+	Header    Header
+	IDs       [][]byte
+	Prefilled []Prefilled
+}
+
+type compactForged struct {
+	Height int          `json:"height"`
+	Block  CompactBlock `json:"block"`
+}
+
+// Compact leaves out every transaction but the stake one, which no peer
+// holds before it gets the block.
+func (b Block) Compact() CompactBlock {
+	result := CompactBlock{
+		Metadata: b.Metadata,
+		Header:   b.Header,
+		IDs:      b.Body.Transactions.IDs(),
+	}
+	if len(b.Body.Transactions) > 0 {
+		result.Prefilled = []Prefilled{{Index: 0, Transaction: b.Body.Transactions[0]}}
+	}
+	return result
+}
+
+func (c CompactBlock) Write(w *codec.Writer) {
+	w.Int(int64(c.Metadata.MagicNumber)).
+		Int(int64(c.Metadata.Size)).
+		Int(int64(c.Header.Version))
+	if c.Header.Version >= AlgorithmVersion {
+		w.String(string(c.Header.Algorithm))
+	}
+	w.Bytes(c.Header.Prev).
+		Bytes(c.Header.TransactionHash).
+		Int(c.Header.Timestamp).
+		Bytes(c.Header.Hash).
+		Uint(uint64(len(c.IDs)))
+	for _, id := range c.IDs {
+		w.Bytes(id)
+	}
+	w.Uint(uint64(len(c.Prefilled)))
+	for _, p := range c.Prefilled {
+		w.Uint(uint64(p.Index))
+		p.Transaction.Write(w)
+	}
+}
+
+func ReadCompactBlock(r *codec.Reader) CompactBlock {
+	var c CompactBlock
+	c.Metadata.MagicNumber = int(r.Int())
+	c.Metadata.Size = int(r.Int())
+	c.Header.Version = int(r.Int())
+	if c.Header.Version >= AlgorithmVersion {
+		c.Header.Algorithm = digest.Algorithm(r.String())
+	}
+	c.Header.Prev = r.Bytes()
+	c.Header.TransactionHash = r.Bytes()
+	c.Header.Timestamp = r.Int()
+	c.Header.Hash = r.Bytes()
+	count := r.Uint()
+	for i := uint64(0); i < count && r.Err() == nil; i++ {
+		c.IDs = append(c.IDs, r.Bytes())
+	}
+	count = r.Uint()
+	for i := uint64(0); i < count && r.Err() == nil; i++ {
+		c.Prefilled = append(c.Prefilled, Prefilled{Index: int(r.Uint()), Transaction: transaction.Read(r)})
+	}
+	return c
+}
+
+// GetBlockTransactionsBody asks the sender of a compact block for the
+// transactions at the indexes, or for the whole block as a forged block
+// message if Full is set.
+type GetBlockTransactionsBody struct {
+	Hash    []byte `json:"hash"`
+	Height  int    `json:"height"`
+	Indexes []int  `json:"indexes,omitempty"`
+	Full    bool   `json:"full,omitempty"`
+}
+
+type BlockTransactionsBody struct {
+	Hash         []byte                   `json:"hash"`
+	Indexes      []int                    `json:"indexes"`
+	Transactions transaction.Transactions `json:"transactions"`
+}
+
+// ExpandFn returns the forged block of a forged or compact block message,
+// with the request for what is missing if it can't be reconstructed yet.
+type ExpandFn func(websocket.Ping) (Forged, *websocket.Pong, error)
+
+type FetchedFn func(BlockTransactionsBody) *websocket.Ping
+
+type reconstruction struct {
+	ping         websocket.Ping
+	compact      CompactBlock
+	transactions map[int]transaction.Transaction
+	fetched      bool
+}
+
+// maxReconstructions bounds the compact blocks waiting for transactions,
+// the oldest one is dropped when another arrives.
+const maxReconstructions = 16
+
+// Reconstructor turns compact blocks back into blocks out of the pending
+// transactions. Compact blocks missing transactions are kept until the
+// sender sends them.
+type Reconstructor struct {
+	lock            *sync.Mutex
+	getTransactions transaction.GetTransactionsFn
+	pending         map[string]*reconstruction
+	order           []string
+}
+
+func NewReconstructor(getTransactions transaction.GetTransactionsFn) *Reconstructor {
+	return &Reconstructor{
+		lock:            &sync.Mutex{},
+		getTransactions: getTransactions,
+		pending:         map[string]*reconstruction{},
+	}
+}
+
+// Expand returns the forged block of a forged or compact block message.
+// The block of a compact one which can't be reconstructed has only its
+// header, the returned request asks the sender for what is missing.
+func (r *Reconstructor) Expand(ping websocket.Ping) (Forged, *websocket.Pong, error) {
+	if ping.Message != websocket.CompactBlockMessage {
+		var body Forged
+		if err := json.Unmarshal(ping.Body, &body); err != nil {
+			return Forged{}, nil, errors.Wrapf(err, "Failed to unmarshal block forged body %s", ping.Body)
+		}
+		return body, nil, nil
+	}
+	var body compactForged
+	if err := json.Unmarshal(ping.Body, &body); err != nil {
+		return Forged{}, nil, errors.Wrapf(err, "Failed to unmarshal compact block body %s", ping.Body)
+	}
+	result := Forged{Height: body.Height, Block: Block{Metadata: body.Block.Metadata, Header: body.Block.Header}}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	key := string(body.Block.Header.Hash)
+	rec, ok := r.pending[key]
+	if !ok {
+		compactReceived.Inc()
+		rec = &reconstruction{ping: ping, compact: body.Block, transactions: map[int]transaction.Transaction{}}
+		for _, p := range body.Block.Prefilled {
+			rec.transactions[p.Index] = p.Transaction
+		}
+	}
+	missing, err := r.fill(rec)
+	if err != nil {
+		return Forged{}, nil, err
+	}
+	if len(missing) == 0 {
+		block, err := rec.block()
+		if err != nil {
+			r.drop(key)
+			compactFallbacks.Inc()
+			return result, request(GetBlockTransactionsBody{Hash: body.Block.Header.Hash, Height: body.Height, Full: true}), nil
+		}
+		r.drop(key)
+		if !rec.fetched {
+			compactReconstructed.Inc()
+		}
+		result.Block = *block
+		return result, nil, nil
+	}
+	if rec.fetched {
+		r.drop(key)
+		compactFallbacks.Inc()
+		return result, request(GetBlockTransactionsBody{Hash: body.Block.Header.Hash, Height: body.Height, Full: true}), nil
+	}
+	r.keep(key, rec)
+	return result, request(GetBlockTransactionsBody{Hash: body.Block.Header.Hash, Height: body.Height, Indexes: missing}), nil
+}
+
+func request(body GetBlockTransactionsBody) *websocket.Pong {
+	return &websocket.Pong{
+		Message: websocket.GetBlockTransactionsMessage,
+		Body:    body,
+	}
+}
+
+// fill takes the transactions the reconstruction lacks from the pending
+// ones and returns the indexes of those which aren't pending.
+func (r *Reconstructor) fill(rec *reconstruction) ([]int, error) {
+	var missing []int
+	var pending map[string]transaction.Transaction
+	for i, id := range rec.compact.IDs {
+		if _, ok := rec.transactions[i]; ok {
+			continue
+		}
+		if pending == nil {
+			txs, err := r.getTransactions()
+			if err != nil {
+				return nil, errors.Wrap(err, "Failed to retrieve pending transactions")
+			}
+			pending = map[string]transaction.Transaction{}
+			for _, t := range txs {
+				pending[string(t.ID)] = t
+			}
+		}
+		if t, ok := pending[string(id)]; ok {
+			rec.transactions[i] = t
+			continue
+		}
+		missing = append(missing, i)
+	}
+	return missing, nil
+}
+
+func (r *Reconstructor) drop(key string) {
+	delete(r.pending, key)
+	for i, k := range r.order {
+		if k == key {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			return
+		}
+	}
+}
+
+func (r *Reconstructor) keep(key string, rec *reconstruction) {
+	if _, ok := r.pending[key]; !ok {
+		r.order = append(r.order, key)
+	}
+	r.pending[key] = rec
+	for len(r.order) > maxReconstructions {
+		delete(r.pending, r.order[0])
+		r.order = r.order[1:]
+	}
+}
+
+// block checks that the transactions have the ids of the compact block
+// and hash to its header.
+func (rec *reconstruction) block() (*Block, error) {
+	transactions := make(transaction.Transactions, len(rec.compact.IDs))
+	for i, id := range rec.compact.IDs {
+		t := rec.transactions[i]
+		if !bytes.Equal(t.ID, id) {
+			return nil, errors.Errorf("Transaction %d of block %x has id %x instead of %x", i, rec.compact.Header.Hash, t.ID, id)
+		}
+		transactions[i] = t
+	}
+	block := Block{
+		Metadata: rec.compact.Metadata,
+		Header:   rec.compact.Header,
+		Body: Body{
+			TransactionsCount: len(transactions),
+			Transactions:      transactions,
+		},
+	}
+	if !block.IsHashValid() {
+		return nil, errors.Errorf("Transactions of block %x don't match its header", rec.compact.Header.Hash)
+	}
+	return &block, nil
+}
+
+// Fetched adds the transactions the sender sent for a compact block and
+// returns the compact block message, to be handled again. Nil is returned
+// if the block isn't waiting for transactions.
+func (r *Reconstructor) Fetched(body BlockTransactionsBody) *websocket.Ping {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	rec, ok := r.pending[string(body.Hash)]
+	if !ok || len(body.Indexes) != len(body.Transactions) {
+		return nil
+	}
+	for i, index := range body.Indexes {
+		if index >= 0 && index < len(rec.compact.IDs) {
+			rec.transactions[index] = body.Transactions[i]
+		}
+	}
+	compactFetched.Add(uint64(len(body.Transactions)))
+	rec.fetched = true
+	ping := rec.ping
+	return &ping
+}
+
+// Transactions answers a request for transactions of a block, with the
+// whole block as a forged block message if the request asks for it. Nil is
+// returned if the block isn't known.
+func Transactions(getBlock GetBlockFn, request GetBlockTransactionsBody) (*websocket.Pong, error) {
+	block, err := getBlock(request.Hash)
+	switch {
+	case err != nil:
+		return nil, errors.Wrapf(err, "Failed to retrieve block %x", request.Hash)
+	case block == nil:
+		return nil, nil
+	case request.Full:
+		return &websocket.Pong{
+			Message: websocket.BlockForgedMessage,
+			Body:    websocket.BlockForgedBody{Height: request.Height, Block: *block},
+		}, nil
+	}
+	result := BlockTransactionsBody{Hash: request.Hash, Indexes: []int{}, Transactions: transaction.Transactions{}}
+	for _, i := range request.Indexes {
+		if i < 0 || i >= len(block.Body.Transactions) {
+			return nil, errors.Errorf("Block %x has no transaction %d", request.Hash, i)
+		}
+		result.Indexes = append(result.Indexes, i)
+		result.Transactions = append(result.Transactions, block.Body.Transactions[i])
+	}
+	return &websocket.Pong{
+		Message: websocket.BlockTransactionsMessage,
+		Body:    result,
+	}, nil
+}
@@ -0,0 +1,282 @@
+package blockchain
+
+import (
+	"bytes"
+	"log"
+	"sync"
+
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrUnknownParent = errors.New("Parent of the block is not known")
+	ErrForkTooDeep   = errors.New("Fork is deeper than the blockchain may be reorganized")
+	ErrStaleBlock    = errors.New("Block does not extend the tip")
+)
+
+var (
+	reorgs        = metrics.NewCounter("reorgs_total", "Number of times the blockchain switched to a longer branch")
+	reorgOrphaned = metrics.NewCounter("reorg_orphaned_blocks_total", "Number of blocks rolled back because a longer branch won")
+	reorgRejected = metrics.NewCounter("reorg_rejected_branches_total", "Number of branches dropped because a block on them is not valid")
+	sideBlocks    = metrics.NewGauge("side_blocks", "Number of blocks kept on branches competing with the blockchain")
+	rewinds       = metrics.NewCounter("rollbacks_total", "Number of times the blockchain was rolled back to a checkpoint by its trustees")
+)
+
+// sideLimit is the number of side blocks kept, the oldest ones are dropped
+// first.
+const sideLimit = 256
+
+// AddForkedBlockFn adds a block forged by the sender to whichever branch it
+// extends, switching to that branch if it becomes the longest one.
+type AddForkedBlockFn func(block Block, sender []byte) error
+
+// IsRewindFn tells whether the block is authorized to roll the blockchain
+// back to its parent, however far below the tip the parent is.
+type IsRewindFn func(Block) bool
+
+// sideBlock is a block with its forger. Orphaned blocks were verified when
+// they were added first, so only their transactions are checked again.
+type sideBlock struct {
+	block    Block
+	sender   []byte
+	verified bool
+}
+
+// ForkChoice keeps blocks which don't extend the tip on side branches and
+// reorganizes the blockchain once a branch becomes longer than the chain
+// past the fork. Ties keep the current chain, so the chain only changes when
+// a branch has more blocks.
+type ForkChoice struct {
+	lock     *sync.Mutex
+	getTip   GetTipFn
+	getBlock GetBlockFn
+	rollback RollbackFn
+	verify   VerifyBlockFn
+	add      AddNewBlockFn
+	isRewind IsRewindFn
+	discard  transaction.DeleteTransaction
+	maxDepth int
+	side     map[string]sideBlock
+	order    []string
+}
+
+func NewForkChoice(getTip GetTipFn, getBlock GetBlockFn, rollback RollbackFn, verify VerifyBlockFn, add AddNewBlockFn, isRewind IsRewindFn, discard transaction.DeleteTransaction, maxDepth int) *ForkChoice {
+	return &ForkChoice{
+		lock:     &sync.Mutex{},
+		getTip:   getTip,
+		getBlock: getBlock,
+		rollback: rollback,
+		verify:   verify,
+		add:      add,
+		isRewind: isRewind,
+		discard:  discard,
+		maxDepth: maxDepth,
+		side:     make(map[string]sideBlock),
+	}
+}
+
+func (f *ForkChoice) apply(b sideBlock) error {
+	if !b.verified && !f.verify(b.block, b.sender) {
+		return errors.Wrapf(ErrInvalidBlock, "Block %x failed verification", b.block.Header.Hash)
+	}
+	return f.add(b.block)
+}
+
+// mainChain returns the depth of the latest blocks of the blockchain, the
+// tip being at depth 0.
+func (f *ForkChoice) mainChain() (map[string]int, error) {
+	result := map[string]int{}
+	current := f.getTip()
+	for depth := 0; depth <= f.maxDepth && len(current) > 0; depth++ {
+		result[string(current)] = depth
+		block, err := f.getBlock(current)
+		switch {
+		case err != nil:
+			return nil, errors.Wrapf(err, "Failed to get block %x", current)
+		case block == nil:
+			return nil, errors.Errorf("Block %x is missing", current)
+		}
+		current = block.Header.Prev
+	}
+	return result, nil
+}
+
+// branch returns the side blocks from the fork up to the block and the
+// depth of the fork point below the tip.
+func (f *ForkChoice) branch(b sideBlock, main map[string]int) ([]sideBlock, int, error) {
+	result := []sideBlock{b}
+	for current := b.block.Header.Prev; ; {
+		if depth, ok := main[string(current)]; ok {
+			return result, depth, nil
+		}
+		parent, ok := f.side[string(current)]
+		switch {
+		case !ok:
+			return nil, 0, errors.Wrapf(ErrUnknownParent, "Block %x extends unknown block %x", b.block.Header.Hash, current)
+		case len(result) >= f.maxDepth:
+			return nil, 0, errors.Wrapf(ErrForkTooDeep, "Block %x is more than %d blocks past the fork", b.block.Header.Hash, f.maxDepth)
+		}
+		result = append([]sideBlock{parent}, result...)
+		current = parent.block.Header.Prev
+	}
+}
+
+func (f *ForkChoice) keep(b sideBlock) {
+	hash := string(b.block.Header.Hash)
+	if _, ok := f.side[hash]; !ok {
+		f.order = append(f.order, hash)
+	}
+	f.side[hash] = b
+	for len(f.order) > sideLimit {
+		delete(f.side, f.order[0])
+		f.order = f.order[1:]
+	}
+	sideBlocks.Set(float64(len(f.side)))
+}
+
+func (f *ForkChoice) drop(blocks []sideBlock) {
+	for _, b := range blocks {
+		delete(f.side, string(b.block.Header.Hash))
+	}
+	order := f.order[:0]
+	for _, hash := range f.order {
+		if _, ok := f.side[hash]; ok {
+			order = append(order, hash)
+		}
+	}
+	f.order = order
+	sideBlocks.Set(float64(len(f.side)))
+}
+
+func (f *ForkChoice) Add(block Block, sender []byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	b := sideBlock{block: block, sender: sender}
+	if bytes.Equal(block.Header.Prev, f.getTip()) {
+		if err := f.apply(b); err != nil {
+			return err
+		}
+		f.drop([]sideBlock{b})
+		return nil
+	}
+	if f.isRewind(block) {
+		return f.rewind(b)
+	}
+	main, err := f.mainChain()
+	if err != nil {
+		return err
+	}
+	if _, ok := main[string(block.Header.Hash)]; ok {
+		return nil
+	}
+	if _, ok := f.side[string(block.Header.Hash)]; ok {
+		return nil
+	}
+	branch, depth, err := f.branch(b, main)
+	if err != nil {
+		return err
+	}
+	if len(branch) <= depth {
+		f.keep(b)
+		log.Printf("Block %x kept on a side branch %d blocks past the fork, the blockchain has %d", block.Header.Hash, len(branch), depth)
+		return nil
+	}
+	return f.reorganize(branch, depth)
+}
+
+// reorganize rolls the blockchain back to the fork and applies the branch.
+// Rolled back blocks are kept as a side branch, their transactions are
+// pending again until the branch includes them. If a block of the branch
+// isn't valid the previous chain is restored and the branch is dropped.
+func (f *ForkChoice) reorganize(branch []sideBlock, depth int) error {
+	var orphaned []sideBlock
+	for i := 0; i < depth; i++ {
+		block, err := f.rollback()
+		if err != nil {
+			return errors.Wrapf(err, "Failed to roll back to the fork at block %x", branch[0].block.Header.Prev)
+		}
+		orphaned = append([]sideBlock{{block: *block, verified: true}}, orphaned...)
+	}
+	for i, b := range branch {
+		err := f.apply(b)
+		if err == nil {
+			continue
+		}
+		if restoreErr := f.restore(i, orphaned); restoreErr != nil {
+			return errors.Wrapf(restoreErr, "Failed to restore the blockchain after block %x failed with %s", b.block.Header.Hash, err)
+		}
+		f.drop(branch[i:])
+		reorgRejected.Inc()
+		return err
+	}
+	f.drop(branch)
+	for _, o := range orphaned {
+		f.keep(o)
+	}
+	reorgs.Inc()
+	reorgOrphaned.Add(uint64(depth))
+	log.Printf("Blockchain reorganized at block %x, %d blocks rolled back and %d added", branch[0].block.Header.Prev, depth, len(branch))
+	return nil
+}
+
+// rewind rolls the blockchain back to the parent of the block and adds it.
+// Transactions of the rolled back blocks are discarded instead of being
+// pending again and the blocks aren't kept on a side branch, so the
+// blockchain never returns to them. If the block isn't valid the previous
+// chain is restored.
+func (f *ForkChoice) rewind(b sideBlock) error {
+	depth, ok, err := Depth(f.getTip, f.getBlock, b.block.Header.Prev)
+	switch {
+	case err != nil:
+		return err
+	case !ok:
+		return errors.Wrapf(ErrUnknownParent, "Block %x rolls back to unknown block %x", b.block.Header.Hash, b.block.Header.Prev)
+	}
+	var orphaned []sideBlock
+	for i := 0; i < depth; i++ {
+		block, err := f.rollback()
+		if err != nil {
+			return errors.Wrapf(err, "Failed to roll back to checkpoint %x", b.block.Header.Prev)
+		}
+		orphaned = append([]sideBlock{{block: *block, verified: true}}, orphaned...)
+	}
+	if err := f.apply(b); err != nil {
+		if restoreErr := f.restore(0, orphaned); restoreErr != nil {
+			return errors.Wrapf(restoreErr, "Failed to restore the blockchain after block %x failed with %s", b.block.Header.Hash, err)
+		}
+		return err
+	}
+	discarded := 0
+	for _, o := range orphaned {
+		for _, t := range o.block.Body.Transactions {
+			if err := f.discard(t); err != nil {
+				log.Printf("Failed to discard rolled back transaction %x %s", t.ID, err)
+				continue
+			}
+			discarded++
+		}
+	}
+	f.drop(append(orphaned, b))
+	rewinds.Inc()
+	log.Printf("ALERT: blockchain rolled back to checkpoint %x, %d blocks and %d transactions discarded", b.block.Header.Prev, depth, discarded)
+	return nil
+}
+
+// restore rolls back the applied blocks of a branch and adds the orphaned
+// blocks again. They were valid before, so they are added without another
+// verification.
+func (f *ForkChoice) restore(applied int, orphaned []sideBlock) error {
+	for i := 0; i < applied; i++ {
+		if _, err := f.rollback(); err != nil {
+			return errors.Wrap(err, "Failed to roll back the branch")
+		}
+	}
+	for _, o := range orphaned {
+		if err := f.add(o.block); err != nil {
+			return errors.Wrapf(err, "Failed to add block %x again", o.block.Header.Hash)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package blockchain
+
+import (
+	"bytes"
+
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+
+	"github.com/pkg/errors"
+)
+
+var ErrConflictingHeader = errors.New("Header conflicts with the known header chain")
+
+// CompactHeader is what a light client needs to follow the blockchain
+// without downloading transactions. Heights start with 1 for the genesis
+// block.
+type CompactHeader struct {
+	Height          int              `json:"height"`
+	Version         int              `json:"version"`
+	Algorithm       digest.Algorithm `json:"algorithm,omitempty"`
+	Hash            []byte           `json:"hash"`
+	Prev            []byte           `json:"prev"`
+	TransactionHash []byte           `json:"transactionHash"`
+	Timestamp       int64            `json:"timestamp"`
+	Transactions    int              `json:"transactions"`
+}
+
+type CompactHeaders []CompactHeader
+
+func (b Block) CompactHeader(height int) CompactHeader {
+	return CompactHeader{
+		Height:          height,
+		Version:         b.Header.Version,
+		Algorithm:       b.Header.Algorithm,
+		Hash:            b.Header.Hash,
+		Prev:            b.Header.Prev,
+		TransactionHash: b.Header.TransactionHash,
+		Timestamp:       b.Header.Timestamp,
+		Transactions:    b.Body.TransactionsCount,
+	}
+}
+
+// IsHashValid checks that the hash commits to the previous block, the
+// transactions, the timestamp and the algorithm.
+func (h CompactHeader) IsHashValid() bool {
+	hash, err := createHash(h.Version, h.Algorithm, h.Prev, h.TransactionHash, h.Timestamp)
+	return err == nil && bytes.Equal(hash, h.Hash)
+}
+
+type GetHeadersFn func(from, count int) (headers CompactHeaders, height int, err error)
+
+// GetHeaders returns at most count headers starting at height from, together
+// with the current height of the blockchain.
+func GetHeaders(getTip GetTipFn, getBlock GetBlockFn) GetHeadersFn {
+	return func(from, count int) (CompactHeaders, int, error) {
+		height, err := GetHeight(getTip, getBlock)
+		if err != nil {
+			return nil, 0, err
+		}
+		to := from + count - 1
+		if to > height {
+			to = height
+		}
+		result := make(CompactHeaders, 0, count)
+		current := getTip()
+		for h := height; h >= from && current != nil; h-- {
+			block, err := getBlock(current)
+			if err != nil {
+				return nil, 0, errors.Wrapf(err, "Failed to get block %x", current)
+			}
+			if h <= to {
+				result = append(CompactHeaders{block.CompactHeader(h)}, result...)
+			}
+			current = block.Header.Prev
+		}
+		return result, height, nil
+	}
+}
+
+// HeaderChain is the header chain kept by a light client, the header at
+// index i has height i+1.
+type HeaderChain CompactHeaders
+
+// Extend verifies headers against the chain and appends the new ones.
+// Headers at known heights have to match the chain, otherwise the server
+// presented a conflicting history and ErrConflictingHeader is returned.
+func (c HeaderChain) Extend(headers CompactHeaders) (HeaderChain, error) {
+	result := c
+	for _, h := range headers {
+		switch {
+		case !h.IsHashValid():
+			return c, errors.Errorf("Header %x at height %d has invalid hash", h.Hash, h.Height)
+		case h.Height < 1 || h.Height > len(result)+1:
+			return c, errors.Errorf("Header %x at height %d doesn't follow the chain of height %d", h.Hash, h.Height, len(result))
+		case h.Height <= len(result):
+			if !bytes.Equal(result[h.Height-1].Hash, h.Hash) {
+				return c, errors.Wrapf(ErrConflictingHeader, "Height %d has hash %x instead of %x", h.Height, h.Hash, result[h.Height-1].Hash)
+			}
+			continue
+		case h.Height == 1 && len(h.Prev) != 0:
+			return c, errors.Errorf("Genesis header %x has a predecessor", h.Hash)
+		case h.Height > 1 && !bytes.Equal(result[h.Height-2].Hash, h.Prev):
+			return c, errors.Wrapf(ErrConflictingHeader, "Header %x at height %d doesn't extend %x", h.Hash, h.Height, result[h.Height-2].Hash)
+		}
+		result = append(result, h)
+	}
+	return result, nil
+}
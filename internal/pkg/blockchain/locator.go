@@ -0,0 +1,74 @@
+package blockchain
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// denseLocator is the number of latest blocks a locator lists one by one
+// before the steps between them start doubling.
+const denseLocator = 10
+
+// Locator describes the blockchain to a peer by the hashes of blocks from
+// the tip back to the genesis block, the latest ones one by one and older
+// ones exponentially spaced, so the common ancestor of two forks is found
+// with a locator of logarithmic size.
+func Locator(getTip GetTipFn, getBlock GetBlockFn) ([][]byte, error) {
+	var result [][]byte
+	step := 1
+	next := 0
+	var last []byte
+	height := 0
+	for current := getTip(); len(current) > 0; height++ {
+		if height == next {
+			result = append(result, current)
+			if len(result) >= denseLocator {
+				step *= 2
+			}
+			next += step
+		}
+		last = current
+		block, err := getBlock(current)
+		switch {
+		case err != nil:
+			return nil, errors.Wrapf(err, "Failed to get block %x", current)
+		case block == nil:
+			return nil, errors.Errorf("Block %x is missing", current)
+		}
+		current = block.Header.Prev
+	}
+	if last != nil && !bytes.Equal(result[len(result)-1], last) {
+		result = append(result, last)
+	}
+	return result, nil
+}
+
+// FindFork walks back from the tip to the latest block the locator lists,
+// the common ancestor of the blockchain and the one the locator describes.
+// It returns the ancestor and the hashes of the blocks following it up to
+// the tip, oldest first. The ancestor is nil when the blockchains share no
+// block, the hashes go back to the genesis block then.
+func FindFork(getTip GetTipFn, getBlock GetBlockFn, locator [][]byte) ([]byte, [][]byte, error) {
+	known := map[string]bool{}
+	for _, hash := range locator {
+		known[string(hash)] = true
+	}
+	var following [][]byte
+	current := getTip()
+	for len(current) > 0 && !known[string(current)] {
+		following = append(following, current)
+		block, err := getBlock(current)
+		switch {
+		case err != nil:
+			return nil, nil, errors.Wrapf(err, "Failed to get block %x", current)
+		case block == nil:
+			return nil, nil, errors.Errorf("Block %x is missing", current)
+		}
+		current = block.Header.Prev
+	}
+	for i, j := 0, len(following)-1; i < j; i, j = i+1, j-1 {
+		following[i], following[j] = following[j], following[i]
+	}
+	return current, following, nil
+}
@@ -0,0 +1,164 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// chain is a blockchain kept in a map, the blocks carry only their hashes.
+type chain struct {
+	blocks map[string]*Block
+	hashes [][]byte
+}
+
+// newChain builds a blockchain of the blocks of base up to height shared
+// followed by n blocks of its own named after the prefix.
+func newChain(base *chain, shared int, prefix string, n int) *chain {
+	result := &chain{blocks: map[string]*Block{}}
+	if base != nil {
+		for _, hash := range base.hashes[:shared] {
+			result.add(hash)
+		}
+	}
+	for i := 0; i < n; i++ {
+		result.add([]byte(fmt.Sprintf("%s-%d", prefix, len(result.hashes))))
+	}
+	return result
+}
+
+func (c *chain) add(hash []byte) {
+	var prev []byte
+	if len(c.hashes) > 0 {
+		prev = c.hashes[len(c.hashes)-1]
+	}
+	c.blocks[string(hash)] = &Block{Header: Header{Hash: hash, Prev: prev}}
+	c.hashes = append(c.hashes, hash)
+}
+
+func (c *chain) getTip() []byte {
+	if len(c.hashes) == 0 {
+		return nil
+	}
+	return c.hashes[len(c.hashes)-1]
+}
+
+func (c *chain) getBlock(hash []byte) (*Block, error) {
+	return c.blocks[string(hash)], nil
+}
+
+func (c *chain) height(hash []byte) int {
+	for i, h := range c.hashes {
+		if bytes.Equal(h, hash) {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestFindFork(t *testing.T) {
+	remote := newChain(nil, 0, "remote", 100)
+	cases := []struct {
+		name string
+		// local describes the blockchain of the node asking for the fork.
+		local *chain
+		// locator overrides the locator of the local blockchain.
+		locator [][]byte
+		// fork is the expected height of the fork in the remote blockchain,
+		// -1 if they share no block.
+		fork int
+	}{
+		{
+			name:  "shared prefix",
+			local: newChain(remote, 95, "local", 3),
+			fork:  94,
+		},
+		{
+			name:  "behind",
+			local: newChain(remote, 60, "local", 0),
+			fork:  59,
+		},
+		{
+			// The local blockchain is 90 blocks high and forked 50 blocks
+			// below its tip, so the fork is past the dense part of the
+			// locator and the latest listed block below it, 71 blocks below
+			// the tip, is the common one.
+			name:  "fork below the dense range",
+			local: newChain(remote, 40, "local", 50),
+			fork:  18,
+		},
+		{
+			name:  "no common block",
+			local: newChain(nil, 0, "local", 30),
+			fork:  -1,
+		},
+		{
+			name:    "legacy last block",
+			locator: [][]byte{remote.hashes[41]},
+			fork:    41,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			locator := c.locator
+			if c.local != nil {
+				var err error
+				locator, err = Locator(c.local.getTip, c.local.getBlock)
+				if err != nil {
+					t.Fatalf("Failed to create locator %s", err)
+				}
+			}
+			fork, following, err := FindFork(remote.getTip, remote.getBlock, locator)
+			if err != nil {
+				t.Fatalf("Failed to find fork %s", err)
+			}
+			if c.fork < 0 && fork != nil {
+				t.Fatalf("Expected no fork, got %s", fork)
+			}
+			if c.fork >= 0 && !bytes.Equal(fork, remote.hashes[c.fork]) {
+				t.Fatalf("Expected fork %s, got %s", remote.hashes[c.fork], fork)
+			}
+			expected := remote.hashes[c.fork+1:]
+			if len(following) != len(expected) {
+				t.Fatalf("Expected %d following blocks, got %d", len(expected), len(following))
+			}
+			for i := range expected {
+				if !bytes.Equal(following[i], expected[i]) {
+					t.Errorf("Expected block %s at %d, got %s", expected[i], i, following[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLocator(t *testing.T) {
+	c := newChain(nil, 0, "block", 1000)
+	locator, err := Locator(c.getTip, c.getBlock)
+	if err != nil {
+		t.Fatalf("Failed to create locator %s", err)
+	}
+	for i := 0; i < denseLocator; i++ {
+		if expected := c.hashes[len(c.hashes)-1-i]; !bytes.Equal(locator[i], expected) {
+			t.Errorf("Expected block %s at %d, got %s", expected, i, locator[i])
+		}
+	}
+	if !bytes.Equal(locator[len(locator)-1], c.hashes[0]) {
+		t.Errorf("Expected the locator to end with the genesis block, got %s", locator[len(locator)-1])
+	}
+	if len(locator) > denseLocator+12 {
+		t.Errorf("Expected a locator of logarithmic size, got %d hashes", len(locator))
+	}
+	previous := len(c.hashes)
+	for _, hash := range locator {
+		height := c.height(hash)
+		if height < 0 || height >= previous {
+			t.Fatalf("Expected the locator to go from the tip to the genesis block, got %s after height %d", hash, previous)
+		}
+		previous = height
+	}
+
+	empty := newChain(nil, 0, "empty", 0)
+	if locator, err := Locator(empty.getTip, empty.getBlock); err != nil || len(locator) != 0 {
+		t.Errorf("Expected an empty locator of an empty blockchain, got %v %v", locator, err)
+	}
+}
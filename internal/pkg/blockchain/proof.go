@@ -0,0 +1,73 @@
+package blockchain
+
+import (
+	"bytes"
+
+	"github.com/nebser/crypto-vote/internal/pkg/merkle"
+	"github.com/pkg/errors"
+)
+
+var ErrNoInclusionProof = errors.New("Block predates Merkle trees over transactions")
+
+// InclusionProof shows that a transaction is in the block with the header,
+// by the path from the transaction id to the transaction hash of the header.
+type InclusionProof struct {
+	Transaction []byte        `json:"transaction"`
+	Index       int           `json:"index"`
+	Path        merkle.Path   `json:"path"`
+	Header      CompactHeader `json:"header"`
+}
+
+// Verify checks that the header hashes to its own hash and that the path
+// leads from the transaction to the transaction hash of the header.
+func (p InclusionProof) Verify() bool {
+	if p.Header.Version < MerkleVersion || !p.Header.IsHashValid() {
+		return false
+	}
+	return merkle.Verify(merkleAlgorithm(p.Header.Version, p.Header.Algorithm), p.Transaction, p.Path, p.Header.TransactionHash)
+}
+
+// ProveInclusionFn returns the proof that the transaction given by its id is
+// in the blockchain, false if it isn't in any block.
+type ProveInclusionFn func(id []byte) (*InclusionProof, bool, error)
+
+func ProveInclusion(getTip GetTipFn, getBlock GetBlockFn) ProveInclusionFn {
+	return func(id []byte) (*InclusionProof, bool, error) {
+		var found *Block
+		index := 0
+		depth := 0
+		for current := getTip(); len(current) > 0 && found == nil; depth++ {
+			block, err := getBlock(current)
+			switch {
+			case err != nil:
+				return nil, false, errors.Wrapf(err, "Failed to get block %x", current)
+			case block == nil:
+				return nil, false, errors.Errorf("Block %x is missing", current)
+			}
+			for i, t := range block.Body.Transactions {
+				if bytes.Equal(t.ID, id) {
+					found, index = block, i
+					break
+				}
+			}
+			current = block.Header.Prev
+		}
+		if found == nil {
+			return nil, false, nil
+		}
+		if found.Header.Version < MerkleVersion {
+			return nil, true, errors.Wrapf(ErrNoInclusionProof, "Transaction %x is in block %x of version %d", id, found.Header.Hash, found.Header.Version)
+		}
+		height, err := GetHeight(getTip, getBlock)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "Failed to get height")
+		}
+		path, _ := merkle.Prove(found.HashAlgorithm(), found.Body.Transactions.IDs(), index)
+		return &InclusionProof{
+			Transaction: id,
+			Index:       index,
+			Path:        path,
+			Header:      found.CompactHeader(height - depth + 1),
+		}, true, nil
+	}
+}
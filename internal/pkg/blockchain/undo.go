@@ -0,0 +1,69 @@
+package blockchain
+
+import (
+	"bytes"
+
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+// Undo holds what adding a block changed in the utxo set, so the block can
+// be rolled back without replaying the blockchain.
+type Undo struct {
+	Block   []byte
+	Height  int
+	Spent   transaction.UTXOs
+	Created transaction.UTXOs
+}
+
+type GetUndoFn func(hash []byte) (*Undo, error)
+
+// RollbackFn removes the tip from the blockchain and returns it.
+type RollbackFn func() (*Block, error)
+
+// RewindFn rolls the blockchain back until the block is the tip and
+// returns the rolled back blocks, the oldest first.
+type RewindFn func(to []byte) ([]Block, error)
+
+// Depth returns how many blocks are above the block in the blockchain, false
+// if the block isn't in it.
+func Depth(getTip GetTipFn, getBlock GetBlockFn, hash []byte) (int, bool, error) {
+	depth := 0
+	for current := getTip(); len(current) > 0; depth++ {
+		if bytes.Equal(current, hash) {
+			return depth, true, nil
+		}
+		block, err := getBlock(current)
+		switch {
+		case err != nil:
+			return 0, false, errors.Wrapf(err, "Failed to get block %x", current)
+		case block == nil:
+			return 0, false, errors.Errorf("Block %x is missing", current)
+		}
+		current = block.Header.Prev
+	}
+	return 0, false, nil
+}
+
+// Rewind rolls the blockchain back with the undo records. Nothing is rolled
+// back if the block isn't in the blockchain.
+func Rewind(getTip GetTipFn, getBlock GetBlockFn, rollback RollbackFn) RewindFn {
+	return func(to []byte) ([]Block, error) {
+		depth, ok, err := Depth(getTip, getBlock, to)
+		switch {
+		case err != nil:
+			return nil, err
+		case !ok:
+			return nil, errors.Wrapf(ErrUnknownParent, "Block %x is not in the blockchain", to)
+		}
+		var result []Block
+		for i := 0; i < depth; i++ {
+			block, err := rollback()
+			if err != nil {
+				return result, errors.Wrapf(err, "Failed to roll back to block %x", to)
+			}
+			result = append([]Block{*block}, result...)
+		}
+		return result, nil
+	}
+}
@@ -0,0 +1,55 @@
+package blockchain
+
+import (
+	"encoding/json"
+
+	"github.com/nebser/crypto-vote/internal/pkg/codec"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+// Forged blocks are the largest messages, over connections which negotiated
+// the binary encoding they are sent in the canonical encoding of blocks, and
+// so are compact ones.
+func init() {
+	websocket.RegisterBodyCodec(websocket.BlockForgedMessage, websocket.BodyCodec{
+		Encode: func(raw json.RawMessage) ([]byte, error) {
+			var body Forged
+			if err := json.Unmarshal(raw, &body); err != nil {
+				return nil, errors.Wrapf(err, "Failed to unmarshal block forged body %s", raw)
+			}
+			w := codec.NewWriter().Int(int64(body.Height))
+			body.Block.Write(w)
+			return w.Result(), nil
+		},
+		Decode: func(raw []byte) (json.RawMessage, error) {
+			r := codec.NewReader(raw)
+			body := Forged{Height: int(r.Int())}
+			body.Block = ReadBlock(r)
+			if r.Err() != nil {
+				return nil, errors.Wrap(r.Err(), "Failed to decode binary block")
+			}
+			return json.Marshal(body)
+		},
+	})
+	websocket.RegisterBodyCodec(websocket.CompactBlockMessage, websocket.BodyCodec{
+		Encode: func(raw json.RawMessage) ([]byte, error) {
+			var body compactForged
+			if err := json.Unmarshal(raw, &body); err != nil {
+				return nil, errors.Wrapf(err, "Failed to unmarshal compact block body %s", raw)
+			}
+			w := codec.NewWriter().Int(int64(body.Height))
+			body.Block.Write(w)
+			return w.Result(), nil
+		},
+		Decode: func(raw []byte) (json.RawMessage, error) {
+			r := codec.NewReader(raw)
+			body := compactForged{Height: int(r.Int())}
+			body.Block = ReadCompactBlock(r)
+			if r.Err() != nil {
+				return nil, errors.Wrap(r.Err(), "Failed to decode binary compact block")
+			}
+			return json.Marshal(body)
+		},
+	})
+}
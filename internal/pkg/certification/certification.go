@@ -0,0 +1,318 @@
+package certification
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/audit"
+	"github.com/nebser/crypto-vote/internal/pkg/ballot"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/finalization"
+	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+const (
+	ManifestFile     = "manifest.json"
+	TallyFile        = "tally.json"
+	ForgersFile      = "forgers.json"
+	HeadFile         = "head.json"
+	AuditFile        = "audit.json"
+	CosignaturesFile = "cosignatures.json"
+	SummaryFile      = "summary.txt"
+)
+
+// Forger identifies the node that forged a block by the stake transaction
+// it signed.
+type Forger struct {
+	Height        int    `json:"height"`
+	Block         []byte `json:"block"`
+	PublicKeyHash []byte `json:"publicKeyHash"`
+	Verifier      []byte `json:"verifier"`
+	Signature     []byte `json:"signature"`
+}
+
+type Forgers []Forger
+
+type Head struct {
+	Hash        []byte `json:"hash"`
+	Height      int    `json:"height"`
+	FinalizedAt int64  `json:"finalizedAt"`
+}
+
+type AuditDigest struct {
+	Entries  int    `json:"entries"`
+	LastHash []byte `json:"lastHash"`
+	Valid    bool   `json:"valid"`
+}
+
+type Bundle struct {
+	Tally        party.Parties
+	Forgers      Forgers
+	Head         Head
+	Audit        AuditDigest
+	Cosignatures finalization.Cosignatures
+}
+
+type File struct {
+	Name   string `json:"name"`
+	SHA256 []byte `json:"sha256"`
+}
+
+// Manifest lists the hash of every file in the bundle and is signed by the
+// key of the alfa node, so that the bundle can be checked without trusting
+// whoever distributed it.
+type Manifest struct {
+	Head      Head   `json:"head"`
+	Files     []File `json:"files"`
+	Signer    []byte `json:"signer"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+type signableManifest struct {
+	Head   Head   `json:"head"`
+	Files  []File `json:"files"`
+	Signer []byte `json:"signer"`
+}
+
+func (m Manifest) Signable() ([]byte, error) {
+	return json.Marshal(signableManifest{
+		Head:   m.Head,
+		Files:  m.Files,
+		Signer: m.Signer,
+	})
+}
+
+// Collect gathers the bundle for the finalized tip. Blocks forged after the
+// finalization and cosignatures of a different tip are ignored.
+func Collect(f finalization.Finalization, getBlock blockchain.GetBlockFn, getParties party.GetPartiesFn, balance func(address string) (int, error), getAuditLog audit.GetEntriesFn, getCosignatures finalization.GetCosignaturesFn) (*Bundle, error) {
+	parties, err := getParties()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to retrieve parties")
+	}
+	tally := make(party.Parties, 0, len(parties))
+	for _, p := range parties {
+		b, err := balance(p.Address)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to retrieve balance of party %s", p.Name)
+		}
+		p.Balance = b
+		tally = append(tally, p)
+	}
+	forgers := Forgers{}
+	height := f.Height
+	for current := f.TipHash; current != nil; height-- {
+		block, err := getBlock(current)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to get block %x", current)
+		}
+		if block == nil {
+			return nil, errors.Errorf("Block %x is missing", current)
+		}
+		if txs := block.Body.Transactions; len(txs) > 0 && len(txs[0].Inputs) > 0 {
+			in := txs[0].Inputs[0]
+			forgers = append(forgers, Forger{
+				Height:        height,
+				Block:         block.Header.Hash,
+				PublicKeyHash: in.PublicKeyHash,
+				Verifier:      in.Verifier,
+				Signature:     in.Signature,
+			})
+		}
+		current = block.Header.Prev
+	}
+	entries, err := getAuditLog()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to retrieve audit log")
+	}
+	digest := AuditDigest{
+		Entries: len(entries),
+		Valid:   entries.Verify() == nil,
+	}
+	if len(entries) > 0 {
+		digest.LastHash = entries[len(entries)-1].Hash
+	}
+	all, err := getCosignatures()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to retrieve cosignatures")
+	}
+	cosignatures := finalization.Cosignatures{}
+	for _, c := range all {
+		if c.Verify(f) {
+			cosignatures = append(cosignatures, c)
+		}
+	}
+	return &Bundle{
+		Tally:   tally,
+		Forgers: forgers,
+		Head: Head{
+			Hash:        f.TipHash,
+			Height:      f.Height,
+			FinalizedAt: f.FinalizedAt,
+		},
+		Audit:        digest,
+		Cosignatures: cosignatures,
+	}, nil
+}
+
+func (b Bundle) Summary() string {
+	builder := strings.Builder{}
+	builder.WriteString("Election result certification\n\n")
+	builder.WriteString(fmt.Sprintf("Finalized at: %s\n", time.Unix(b.Head.FinalizedAt, 0).UTC().Format(time.RFC3339)))
+	builder.WriteString(fmt.Sprintf("Chain head: %x\n", b.Head.Hash))
+	builder.WriteString(fmt.Sprintf("Chain height: %d\n\n", b.Head.Height))
+	builder.WriteString("Final tally:\n")
+	for _, q := range ballot.Group(b.Tally) {
+		indent := "\t"
+		if q.Name != "" {
+			builder.WriteString(fmt.Sprintf("\t%s:\n", q.Name))
+			indent = "\t\t"
+		}
+		for _, p := range q.Choices {
+			builder.WriteString(fmt.Sprintf("%s%s (%s): %d\n", indent, p.Name, p.Address, p.Balance))
+		}
+	}
+	keys := map[string]int{}
+	for _, f := range b.Forgers {
+		keys[fmt.Sprintf("%x", f.PublicKeyHash)]++
+	}
+	builder.WriteString("\nForging nodes:\n")
+	for key, count := range keys {
+		builder.WriteString(fmt.Sprintf("\t%s: %d blocks\n", key, count))
+	}
+	builder.WriteString(fmt.Sprintf("\nAudit log: %d entries, last hash %x, chain valid: %t\n", b.Audit.Entries, b.Audit.LastHash, b.Audit.Valid))
+	builder.WriteString(fmt.Sprintf("\nCo-signed by %d nodes:\n", len(b.Cosignatures)))
+	for _, c := range b.Cosignatures {
+		builder.WriteString(fmt.Sprintf("\t%s\n", base64.StdEncoding.EncodeToString(c.Verifier)))
+	}
+	return builder.String()
+}
+
+// Write stores the bundle in dir together with a manifest signed by signer
+// holding the key of publicKey.
+func Write(dir string, b Bundle, signer wallet.Signer, publicKey []byte) (*Manifest, error) {
+	contents := map[string]interface{}{
+		TallyFile:        b.Tally,
+		ForgersFile:      b.Forgers,
+		HeadFile:         b.Head,
+		AuditFile:        b.Audit,
+		CosignaturesFile: b.Cosignatures,
+	}
+	manifest := Manifest{
+		Head:   b.Head,
+		Signer: publicKey,
+	}
+	for _, name := range []string{TallyFile, ForgersFile, HeadFile, AuditFile, CosignaturesFile} {
+		raw, err := json.MarshalIndent(contents[name], "", "  ")
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to serialize %s", name)
+		}
+		file, err := writeFile(dir, name, raw)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Files = append(manifest.Files, *file)
+	}
+	file, err := writeFile(dir, SummaryFile, []byte(b.Summary()))
+	if err != nil {
+		return nil, err
+	}
+	manifest.Files = append(manifest.Files, *file)
+	signature, err := signer.SignRaw(manifest)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to sign manifest")
+	}
+	manifest.Signature = signature
+	raw, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to serialize manifest")
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ManifestFile), raw, 0644); err != nil {
+		return nil, errors.Wrap(err, "Failed to write manifest")
+	}
+	return &manifest, nil
+}
+
+func writeFile(dir, name string, raw []byte) (*File, error) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), raw, 0644); err != nil {
+		return nil, errors.Wrapf(err, "Failed to write %s", name)
+	}
+	hashed := sha256.Sum256(raw)
+	return &File{Name: name, SHA256: hashed[:]}, nil
+}
+
+// Verify checks the manifest signature against the trusted public key and
+// the hash of every file listed in it.
+func Verify(dir string, trusted []byte) (*Manifest, error) {
+	raw, err := ioutil.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read manifest")
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse manifest")
+	}
+	if !bytes.Equal(manifest.Signer, trusted) {
+		return nil, errors.New("Manifest is not signed by the trusted key")
+	}
+	if len(manifest.Signature) == 0 || !wallet.Verify(manifest, manifest.Signature, trusted) {
+		return nil, errors.New("Manifest signature is not valid")
+	}
+	listed := map[string]bool{}
+	for _, f := range manifest.Files {
+		listed[f.Name] = true
+		if filepath.Base(f.Name) != f.Name {
+			return nil, errors.Errorf("Manifest references file %s outside of the bundle", f.Name)
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, f.Name))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read %s", f.Name)
+		}
+		hashed := sha256.Sum256(content)
+		if !bytes.Equal(hashed[:], f.SHA256) {
+			return nil, errors.Errorf("File %s does not match the manifest", f.Name)
+		}
+	}
+	for _, name := range []string{TallyFile, ForgersFile, HeadFile, AuditFile} {
+		if !listed[name] {
+			return nil, errors.Errorf("Manifest does not list %s", name)
+		}
+	}
+	var head Head
+	content, err := ioutil.ReadFile(filepath.Join(dir, HeadFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read head")
+	}
+	if err := json.Unmarshal(content, &head); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse head")
+	}
+	if !bytes.Equal(head.Hash, manifest.Head.Hash) || head.Height != manifest.Head.Height {
+		return nil, errors.New("Head file does not match the manifest")
+	}
+	if !listed[CosignaturesFile] {
+		return &manifest, nil
+	}
+	content, err = ioutil.ReadFile(filepath.Join(dir, CosignaturesFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read cosignatures")
+	}
+	var cosignatures finalization.Cosignatures
+	if err := json.Unmarshal(content, &cosignatures); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse cosignatures")
+	}
+	f := finalization.Finalization{TipHash: head.Hash, Height: head.Height}
+	for _, c := range cosignatures {
+		if !c.Verify(f) {
+			return nil, errors.Errorf("Cosignature of %x is not valid", c.Verifier)
+		}
+	}
+	return &manifest, nil
+}
@@ -0,0 +1,37 @@
+package chain
+
+import (
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
+// idBytes is how many bytes of the genesis hash make up a chain id.
+const idBytes = 8
+
+// ID identifies the blockchain, and so the network, transactions and
+// messages belong to. It is the hex encoded beginning of the genesis hash,
+// so every node derives it from its own blockchain.
+type ID string
+
+var ErrOtherChain = errors.New("Material belongs to another chain")
+
+// FromGenesis derives the chain id out of the hash of the genesis block.
+func FromGenesis(genesis []byte) ID {
+	if len(genesis) > idBytes {
+		genesis = genesis[:idBytes]
+	}
+	return ID(hex.EncodeToString(genesis))
+}
+
+// Accepts tells whether material stamped with other can be used on the
+// chain. Material without a chain id, from clients and records written
+// before chain ids, and any material while the chain id isn't known yet is
+// accepted.
+func (id ID) Accepts(other ID) bool {
+	return id == "" || other == "" || id == other
+}
+
+// GetIDFn returns the id of the chain, empty while there is no genesis
+// block.
+type GetIDFn func() ID
@@ -0,0 +1,122 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+var ErrMalformed = errors.New("Malformed binary data")
+
+type Writer struct {
+	buffer bytes.Buffer
+}
+
+func NewWriter() *Writer {
+	return &Writer{}
+}
+
+func (w *Writer) Byte(b byte) *Writer {
+	w.buffer.WriteByte(b)
+	return w
+}
+
+func (w *Writer) Int(i int64) *Writer {
+	raw := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(raw, i)
+	w.buffer.Write(raw[:n])
+	return w
+}
+
+func (w *Writer) Uint(i uint64) *Writer {
+	raw := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(raw, i)
+	w.buffer.Write(raw[:n])
+	return w
+}
+
+func (w *Writer) Bytes(b []byte) *Writer {
+	w.Uint(uint64(len(b)))
+	w.buffer.Write(b)
+	return w
+}
+
+func (w *Writer) String(s string) *Writer {
+	return w.Bytes([]byte(s))
+}
+
+func (w *Writer) Result() []byte {
+	return w.buffer.Bytes()
+}
+
+type Reader struct {
+	reader *bytes.Reader
+	err    error
+}
+
+func NewReader(raw []byte) *Reader {
+	return &Reader{reader: bytes.NewReader(raw)}
+}
+
+func (r *Reader) Byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	b, err := r.reader.ReadByte()
+	if err != nil {
+		r.err = ErrMalformed
+	}
+	return b
+}
+
+func (r *Reader) Int() int64 {
+	if r.err != nil {
+		return 0
+	}
+	i, err := binary.ReadVarint(r.reader)
+	if err != nil {
+		r.err = ErrMalformed
+	}
+	return i
+}
+
+func (r *Reader) Uint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	i, err := binary.ReadUvarint(r.reader)
+	if err != nil {
+		r.err = ErrMalformed
+	}
+	return i
+}
+
+func (r *Reader) Bytes() []byte {
+	length := r.Uint()
+	if r.err != nil {
+		return nil
+	}
+	if length > uint64(r.reader.Len()) {
+		r.err = ErrMalformed
+		return nil
+	}
+	if length == 0 {
+		return nil
+	}
+	result := make([]byte, length)
+	r.reader.Read(result)
+	return result
+}
+
+func (r *Reader) String() string {
+	return string(r.Bytes())
+}
+
+func (r *Reader) Len() int {
+	return r.reader.Len()
+}
+
+func (r *Reader) Err() error {
+	return r.err
+}
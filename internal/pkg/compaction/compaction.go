@@ -0,0 +1,47 @@
+package compaction
+
+import "github.com/pkg/errors"
+
+var (
+	ErrRunning           = errors.New("Compaction is already running")
+	ErrNothingToReclaim  = errors.New("Compaction would not reclaim enough space")
+	ErrInsufficientSpace = errors.New("Not enough free disk space for the compacted copy")
+	ErrMismatch          = errors.New("Compacted copy does not match the database")
+)
+
+// Estimate is the size of the database file and how much of it a
+// compaction would reclaim.
+type Estimate struct {
+	Size        int64 `json:"size"`
+	Reclaimable int64 `json:"reclaimable"`
+}
+
+type Result struct {
+	StartedAt  int64 `json:"startedAt"`
+	FinishedAt int64 `json:"finishedAt"`
+	SizeBefore int64 `json:"sizeBefore"`
+	SizeAfter  int64 `json:"sizeAfter"`
+}
+
+func (r Result) Reclaimed() int64 {
+	return r.SizeBefore - r.SizeAfter
+}
+
+type EstimateFn func() (Estimate, error)
+
+// SwappedFn is called once the compacted copy replaced the database file.
+// It must not return, the open database still refers to the replaced file.
+type SwappedFn func(Result)
+
+// CompactFn copies the database into a compacted file, verifies the copy
+// and atomically swaps it in place of the database. Writes to the database
+// are blocked from the start of the copy until swapped is called.
+type CompactFn func(swapped SwappedFn) error
+
+type GetLastFn func() (*Result, error)
+
+// StartFn starts a compaction in the background. Unless forced, it is
+// refused when too little would be reclaimed.
+type StartFn func(force bool) (Estimate, error)
+
+type RunningFn func() bool
@@ -0,0 +1,343 @@
+package descriptor
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/stake"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidSet = errors.New("Key set is not known")
+
+// Set is a group of keys whose outputs are exported.
+type Set string
+
+const (
+	// Parties are the keys votes are given to.
+	Parties Set = "parties"
+	// Nodes are the keys of registered nodes, which stake to forge.
+	Nodes Set = "nodes"
+	// Treasury is the key of the alfa node, which issues the vote credits
+	// and holds the stakes.
+	Treasury Set = "treasury"
+)
+
+var Sets = []Set{Parties, Nodes, Treasury}
+
+func ParseSet(raw string) (Set, error) {
+	for _, s := range Sets {
+		if string(s) == raw {
+			return s, nil
+		}
+	}
+	return "", errors.Wrapf(ErrInvalidSet, "Set %s", raw)
+}
+
+// Kind tells where the value of an output came from, or where it went to
+// when the output was spent.
+type Kind string
+
+const (
+	// Issued outputs are vote credits created by the alfa node.
+	Issued Kind = "issued"
+	// Vote outputs give votes to a party.
+	Vote Kind = "vote"
+	// Change outputs return the rest of the inputs to their owner.
+	Change Kind = "change"
+	// Stake outputs are staked to the alfa node by a forger.
+	Stake Kind = "stake"
+	// Returned outputs give a stake back to its forger.
+	Returned Kind = "returned"
+	// Funded outputs are paid by the alfa node, e.g. by the faucet.
+	Funded Kind = "funded"
+	// Recovered outputs move a vote credit to a new key of its voter.
+	Recovered Kind = "recovered"
+)
+
+// Descriptor names the outputs a key controls, pkh(<address>) like the
+// output descriptors of wallets.
+func Descriptor(publicKeyHash []byte) string {
+	return fmt.Sprintf("pkh(%s)", wallet.EncodeAddress(publicKeyHash))
+}
+
+// Output is an output of a key with its provenance. From is the descriptor
+// of the sender if it is a key of a set, voters stay unnamed. Outputs which
+// are spent name the transaction spending them, the height of its block and
+// the kind of the output it created for someone else.
+type Output struct {
+	Outpoint string `json:"outpoint"`
+	Value    int    `json:"value"`
+	Kind     Kind   `json:"kind"`
+	From     string `json:"from,omitempty"`
+	Block    []byte `json:"block"`
+	Height   int    `json:"height"`
+	SpentBy  string `json:"spentBy,omitempty"`
+	SpentAt  int    `json:"spentAt,omitempty"`
+	SpentAs  Kind   `json:"spentAs,omitempty"`
+}
+
+// Key sums up the outputs of a key. Balance is what was received less what
+// was spent, the value of the unspent outputs.
+type Key struct {
+	Descriptor string       `json:"descriptor"`
+	Set        Set          `json:"set,omitempty"`
+	Name       string       `json:"name,omitempty"`
+	Received   int          `json:"received"`
+	Spent      int          `json:"spent"`
+	Balance    int          `json:"balance"`
+	ByKind     map[Kind]int `json:"byKind"`
+	Outputs    []Output     `json:"outputs"`
+	outputs    map[string]int
+}
+
+// Totals are over the whole blockchain, not only the exported keys. Every
+// credit issued is either unspent or was spent, so Issued equals Unspent
+// while no value is created or burned elsewhere.
+type Totals struct {
+	Issued   int `json:"issued"`
+	Staked   int `json:"staked"`
+	Returned int `json:"returned"`
+	Unspent  int `json:"unspent"`
+}
+
+// Export holds the outputs of the keys as they were right after the block at
+// the height was added.
+type Export struct {
+	Height int    `json:"height"`
+	Block  []byte `json:"block"`
+	Totals Totals `json:"totals"`
+	Keys   []Key  `json:"keys"`
+	index  map[string]int
+}
+
+// Request selects the keys of the sets and the keys of the addresses. The
+// export is at the tip if the height is negative, height 0 is the state
+// before the genesis block.
+type Request struct {
+	Sets      []Set
+	Addresses [][]byte
+	Height    int
+}
+
+type ExportFn func(Request) (Export, error)
+
+func outpoint(id []byte, vout int) string {
+	return fmt.Sprintf("%x:%d", id, vout)
+}
+
+// kindOf tells where the output of the transaction came from. Stakes are
+// looked up among the outputs created before.
+func kindOf(t transaction.Transaction, vout int, alfaKeyHash []byte, stakes map[string]bool) Kind {
+	out := t.Outputs[vout]
+	switch {
+	case len(t.Inputs) == 0 || (len(t.Inputs) == 1 && t.Inputs[0].Vout == -1):
+		return Issued
+	case t.IsRecovery():
+		return Recovered
+	}
+	if index, ok := transaction.StakeOutput(t, alfaKeyHash); ok && index == vout {
+		return Stake
+	}
+	sender := t.Inputs[0].PublicKeyHash
+	switch {
+	case bytes.Equal(out.PublicKeyHash, sender):
+		return Change
+	case bytes.Equal(sender, alfaKeyHash) && stakes[outpoint(t.Inputs[0].TransactionID, t.Inputs[0].Vout)]:
+		return Returned
+	case bytes.Equal(sender, alfaKeyHash):
+		return Funded
+	default:
+		return Vote
+	}
+}
+
+// spentAs tells what the transaction spending an output of the sender made
+// of it, the kind of its first output going to someone else.
+func spentAs(t transaction.Transaction, sender, alfaKeyHash []byte, stakes map[string]bool) Kind {
+	for i, out := range t.Outputs {
+		if !bytes.Equal(out.PublicKeyHash, sender) {
+			return kindOf(t, i, alfaKeyHash, stakes)
+		}
+	}
+	return Change
+}
+
+func chain(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn) (blockchain.Blocks, error) {
+	var result blockchain.Blocks
+	for current := getTip(); len(current) > 0; {
+		block, err := getBlock(current)
+		switch {
+		case err != nil:
+			return nil, errors.Wrapf(err, "Failed to get block %x", current)
+		case block == nil:
+			return nil, errors.Errorf("Block %x is missing", current)
+		}
+		result = append(result, *block)
+		current = block.Header.Prev
+	}
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result, nil
+}
+
+func (e *Export) add(set Set, name string, hash []byte) {
+	if _, ok := e.index[string(hash)]; ok {
+		return
+	}
+	e.index[string(hash)] = len(e.Keys)
+	e.Keys = append(e.Keys, Key{
+		Descriptor: Descriptor(hash),
+		Set:        set,
+		Name:       name,
+		ByKind:     map[Kind]int{},
+		Outputs:    []Output{},
+		outputs:    map[string]int{},
+	})
+}
+
+func (e Export) key(hash []byte) *Key {
+	if i, ok := e.index[string(hash)]; ok {
+		return &e.Keys[i]
+	}
+	return nil
+}
+
+// keys returns the keys of every set, the ones of the request are exported.
+func keys(r Request, getParties party.GetPartiesFn, getNodes stake.GetNodesFn, alfaKeyHash []byte) (Export, map[string]string, error) {
+	known := map[string]string{string(alfaKeyHash): Descriptor(alfaKeyHash)}
+	parties, err := getParties()
+	if err != nil {
+		return Export{}, nil, errors.Wrap(err, "Failed to retrieve parties")
+	}
+	nodes, err := getNodes()
+	if err != nil {
+		return Export{}, nil, errors.Wrap(err, "Failed to retrieve nodes")
+	}
+	ids := []string{}
+	for id, hash := range nodes {
+		known[string(hash)] = Descriptor(hash)
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, p := range parties {
+		known[string(wallet.ExtractPublicKeyHash(p.Address))] = Descriptor(wallet.ExtractPublicKeyHash(p.Address))
+	}
+	result := Export{Keys: []Key{}, index: map[string]int{}}
+	for _, s := range r.Sets {
+		switch s {
+		case Treasury:
+			result.add(Treasury, "alfa", alfaKeyHash)
+		case Parties:
+			for _, p := range parties {
+				result.add(Parties, p.Name, wallet.ExtractPublicKeyHash(p.Address))
+			}
+		case Nodes:
+			for _, id := range ids {
+				result.add(Nodes, id, nodes[id])
+			}
+		}
+	}
+	for _, hash := range r.Addresses {
+		result.add("", "", hash)
+	}
+	return result, known, nil
+}
+
+// Exporter replays the blockchain up to the height of the request, tracking
+// every output and the transaction spending it.
+func Exporter(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, getParties party.GetPartiesFn, getNodes stake.GetNodesFn, alfaKeyHash []byte) ExportFn {
+	return func(r Request) (Export, error) {
+		result, known, err := keys(r, getParties, getNodes, alfaKeyHash)
+		if err != nil {
+			return Export{}, err
+		}
+		blocks, err := chain(getTip, getBlock)
+		if err != nil {
+			return Export{}, err
+		}
+		if r.Height < 0 {
+			r.Height = len(blocks)
+		}
+		if r.Height > len(blocks) {
+			return Export{}, errors.Wrapf(blockchain.ErrHeightNotReached, "Height %d", r.Height)
+		}
+		unspent := map[string]int{}
+		stakes := map[string]bool{}
+		for i, block := range blocks[:r.Height] {
+			height := i + 1
+			for _, t := range block.Body.Transactions {
+				for _, in := range t.Inputs {
+					if in.Vout < 0 {
+						continue
+					}
+					spent := outpoint(in.TransactionID, in.Vout)
+					value, ok := unspent[spent]
+					if !ok {
+						continue
+					}
+					delete(unspent, spent)
+					k := result.key(in.PublicKeyHash)
+					if k == nil {
+						continue
+					}
+					if index, ok := k.outputs[spent]; ok && k.Outputs[index].SpentBy == "" {
+						k.Outputs[index].SpentBy = fmt.Sprintf("%x", t.ID)
+						k.Outputs[index].SpentAt = height
+						k.Outputs[index].SpentAs = spentAs(t, in.PublicKeyHash, alfaKeyHash, stakes)
+						k.Spent += value
+					}
+				}
+				for vout, out := range t.Outputs {
+					point := outpoint(t.ID, vout)
+					kind := kindOf(t, vout, alfaKeyHash, stakes)
+					unspent[point] = out.Value
+					switch kind {
+					case Issued:
+						result.Totals.Issued += out.Value
+					case Stake:
+						result.Totals.Staked += out.Value
+						stakes[point] = true
+					case Returned:
+						result.Totals.Returned += out.Value
+					}
+					k := result.key(out.PublicKeyHash)
+					if k == nil {
+						continue
+					}
+					o := Output{
+						Outpoint: point,
+						Value:    out.Value,
+						Kind:     kind,
+						Block:    block.Header.Hash,
+						Height:   height,
+					}
+					if len(t.Inputs) > 0 {
+						o.From = known[string(t.Inputs[0].PublicKeyHash)]
+					}
+					k.outputs[point] = len(k.Outputs)
+					k.Outputs = append(k.Outputs, o)
+					k.Received += out.Value
+					k.ByKind[kind] += out.Value
+				}
+			}
+		}
+		for _, value := range unspent {
+			result.Totals.Unspent += value
+		}
+		for i := range result.Keys {
+			result.Keys[i].Balance = result.Keys[i].Received - result.Keys[i].Spent
+		}
+		result.Height = r.Height
+		if r.Height > 0 {
+			result.Block = blocks[r.Height-1].Header.Hash
+		}
+		return result, nil
+	}
+}
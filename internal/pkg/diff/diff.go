@@ -0,0 +1,221 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+// Side is the state of a single node compared by the diff.
+type Side struct {
+	Name            string
+	GetTip          blockchain.GetTipFn
+	GetBlock        blockchain.GetBlockFn
+	GetUTXOs        transaction.GetUTXOsFn
+	GetTransactions transaction.GetTransactionsFn
+}
+
+type state struct {
+	chain   [][]byte
+	utxos   map[string]transaction.UTXO
+	located map[string]int
+}
+
+func outpoint(id []byte, vout int) string {
+	return fmt.Sprintf("%x:%d", id, vout)
+}
+
+func (s Side) load() (state, error) {
+	result := state{
+		utxos:   map[string]transaction.UTXO{},
+		located: map[string]int{},
+	}
+	var blocks []blockchain.Block
+	for current := s.GetTip(); current != nil; {
+		block, err := s.GetBlock(current)
+		if err != nil || block == nil {
+			return state{}, errors.Errorf("Failed to get block %x of %s %s", current, s.Name, err)
+		}
+		blocks = append(blocks, *block)
+		current = block.Header.Prev
+	}
+	for i := len(blocks) - 1; i >= 0; i-- {
+		height := len(blocks) - i
+		result.chain = append(result.chain, blocks[i].Header.Hash)
+		for _, t := range blocks[i].Body.Transactions {
+			result.located[string(t.ID)] = height
+		}
+	}
+	pending, err := s.GetTransactions()
+	if err != nil {
+		return state{}, errors.Wrapf(err, "Failed to retrieve pending transactions of %s", s.Name)
+	}
+	for _, t := range pending {
+		if _, ok := result.located[string(t.ID)]; !ok {
+			result.located[string(t.ID)] = 0
+		}
+	}
+	utxos, err := s.GetUTXOs()
+	if err != nil {
+		return state{}, errors.Wrapf(err, "Failed to retrieve utxos of %s", s.Name)
+	}
+	for _, u := range utxos {
+		result.utxos[outpoint(u.TransactionID, u.Vout)] = u
+	}
+	return result, nil
+}
+
+type Divergence struct {
+	Height int
+	A      []byte
+	B      []byte
+}
+
+type UTXODifference struct {
+	Outpoint string
+	A        *transaction.UTXO
+	B        *transaction.UTXO
+}
+
+// MissingTransaction is a transaction missing in one side. Height is the
+// height of the block holding it in the other side, 0 if it is pending.
+type MissingTransaction struct {
+	ID     []byte
+	Height int
+}
+
+// Report lists where two nodes disagree. Divergence is nil if one chain is
+// the prefix of the other. Missing transactions of a side are known to the
+// other side, in a block or pending, but not to it.
+type Report struct {
+	A          string
+	B          string
+	HeightA    int
+	HeightB    int
+	Divergence *Divergence
+	UTXOs      []UTXODifference
+	MissingA   []MissingTransaction
+	MissingB   []MissingTransaction
+}
+
+func (r Report) Identical() bool {
+	return r.HeightA == r.HeightB && r.Divergence == nil && len(r.UTXOs) == 0 && len(r.MissingA) == 0 && len(r.MissingB) == 0
+}
+
+func missing(from, in state) []MissingTransaction {
+	var result []MissingTransaction
+	for id, height := range from.located {
+		if _, ok := in.located[id]; !ok {
+			result = append(result, MissingTransaction{ID: []byte(id), Height: height})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Height != result[j].Height {
+			return result[i].Height < result[j].Height
+		}
+		return bytes.Compare(result[i].ID, result[j].ID) < 0
+	})
+	return result
+}
+
+// Compare diffs the blockchains, the utxo sets and the transactions known
+// to the two sides.
+func Compare(a, b Side) (Report, error) {
+	stateA, err := a.load()
+	if err != nil {
+		return Report{}, err
+	}
+	stateB, err := b.load()
+	if err != nil {
+		return Report{}, err
+	}
+	report := Report{
+		A:       a.Name,
+		B:       b.Name,
+		HeightA: len(stateA.chain),
+		HeightB: len(stateB.chain),
+	}
+	for i := 0; i < len(stateA.chain) && i < len(stateB.chain); i++ {
+		if !bytes.Equal(stateA.chain[i], stateB.chain[i]) {
+			report.Divergence = &Divergence{Height: i + 1, A: stateA.chain[i], B: stateB.chain[i]}
+			break
+		}
+	}
+	for key, u := range stateA.utxos {
+		inA := u
+		if other, ok := stateB.utxos[key]; !ok {
+			report.UTXOs = append(report.UTXOs, UTXODifference{Outpoint: key, A: &inA})
+		} else if other.Value != u.Value || !bytes.Equal(other.PublicKeyHash, u.PublicKeyHash) {
+			report.UTXOs = append(report.UTXOs, UTXODifference{Outpoint: key, A: &inA, B: &other})
+		}
+	}
+	for key, u := range stateB.utxos {
+		inB := u
+		if _, ok := stateA.utxos[key]; !ok {
+			report.UTXOs = append(report.UTXOs, UTXODifference{Outpoint: key, B: &inB})
+		}
+	}
+	sort.Slice(report.UTXOs, func(i, j int) bool {
+		return report.UTXOs[i].Outpoint < report.UTXOs[j].Outpoint
+	})
+	report.MissingA = missing(stateB, stateA)
+	report.MissingB = missing(stateA, stateB)
+	return report, nil
+}
+
+func describe(u *transaction.UTXO) string {
+	if u == nil {
+		return "missing"
+	}
+	return fmt.Sprintf("%d to %s", u.Value, wallet.EncodeAddress(u.PublicKeyHash))
+}
+
+// Format describes the report in a human readable form, listing at most
+// limit entries of every kind.
+func (r Report) Format(limit int) string {
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("Height of %s: %d\n", r.A, r.HeightA))
+	builder.WriteString(fmt.Sprintf("Height of %s: %d\n", r.B, r.HeightB))
+	if r.Identical() {
+		builder.WriteString("Databases are identical\n")
+		return builder.String()
+	}
+	if d := r.Divergence; d != nil {
+		builder.WriteString(fmt.Sprintf("First divergent block at height %d: %x in %s, %x in %s\n", d.Height, d.A, r.A, d.B, r.B))
+	} else {
+		builder.WriteString("No divergent block, the shorter blockchain is a prefix of the longer one\n")
+	}
+	builder.WriteString(fmt.Sprintf("Differing utxos: %d\n", len(r.UTXOs)))
+	for i, u := range r.UTXOs {
+		if i == limit {
+			builder.WriteString(fmt.Sprintf("\t... %d more\n", len(r.UTXOs)-limit))
+			break
+		}
+		builder.WriteString(fmt.Sprintf("\t%s: %s in %s, %s in %s\n", u.Outpoint, describe(u.A), r.A, describe(u.B), r.B))
+	}
+	for _, m := range []struct {
+		name    string
+		other   string
+		missing []MissingTransaction
+	}{{r.A, r.B, r.MissingA}, {r.B, r.A, r.MissingB}} {
+		builder.WriteString(fmt.Sprintf("Transactions missing in %s: %d\n", m.name, len(m.missing)))
+		for i, t := range m.missing {
+			if i == limit {
+				builder.WriteString(fmt.Sprintf("\t... %d more\n", len(m.missing)-limit))
+				break
+			}
+			if t.Height == 0 {
+				builder.WriteString(fmt.Sprintf("\t%x, pending in %s\n", t.ID, m.other))
+			} else {
+				builder.WriteString(fmt.Sprintf("\t%x, in block at height %d in %s\n", t.ID, t.Height, m.other))
+			}
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,70 @@
+package digest
+
+import (
+	"crypto/sha256"
+
+	"github.com/pkg/errors"
+	"lukechampine.com/blake3"
+)
+
+// Algorithm hashes blocks and the Merkle trees over their transactions. The
+// genesis block picks it for the whole blockchain and every block names the
+// one it is hashed with, so it can be verified without knowing the chain.
+type Algorithm string
+
+const (
+	SHA256 Algorithm = "sha256"
+	BLAKE3 Algorithm = "blake3"
+)
+
+var Algorithms = []Algorithm{SHA256, BLAKE3}
+
+var ErrUnknownAlgorithm = errors.New("Hash algorithm is not known")
+
+func Parse(raw string) (Algorithm, error) {
+	for _, a := range Algorithms {
+		if string(a) == raw {
+			return a, nil
+		}
+	}
+	return "", errors.Wrapf(ErrUnknownAlgorithm, "Algorithm %s", raw)
+}
+
+// Normalized is the algorithm data without a tag, written before there was a
+// choice, was hashed with.
+func (a Algorithm) Normalized() Algorithm {
+	if a == "" {
+		return SHA256
+	}
+	return a
+}
+
+// Known tells whether the algorithm can be used, data without a tag is
+// hashed with SHA-256.
+func (a Algorithm) Known() bool {
+	for _, known := range Algorithms {
+		if a.Normalized() == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Sum returns the 32 byte hash of the data. Unknown algorithms hash to nil,
+// so nothing they are supposed to hash ever verifies.
+func (a Algorithm) Sum(data []byte) []byte {
+	switch a.Normalized() {
+	case SHA256:
+		hash := sha256.Sum256(data)
+		return hash[:]
+	case BLAKE3:
+		hash := blake3.Sum256(data)
+		return hash[:]
+	default:
+		return nil
+	}
+}
+
+// GetAlgorithmFn returns the algorithm the genesis block picked, SHA-256
+// while there is no genesis block.
+type GetAlgorithmFn func() Algorithm
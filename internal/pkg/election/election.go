@@ -0,0 +1,285 @@
+package election
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/address"
+	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/redact"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrUnknownElection = errors.New("Election does not exist")
+	ErrElectionExists  = errors.New("Election already exists")
+	ErrInvalidElection = errors.New("Election is not valid")
+	ErrElectionClosed  = errors.New("Election is not open")
+	ErrOutsideWindow   = errors.New("Vote is outside the voting window of the election")
+)
+
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+type Status string
+
+const (
+	Draft  Status = "draft"
+	Open   Status = "open"
+	Closed Status = "closed"
+)
+
+// Election scopes a list of parties to a voting window. Votes for the
+// parties are accepted while the election is open and the window lasts,
+// parties which aren't on the list of any election belong to the implicit
+// election of the blockchain.
+type Election struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Parties   []string `json:"parties"`
+	StartsAt  int64    `json:"startsAt"`
+	EndsAt    int64    `json:"endsAt"`
+	Status    Status   `json:"status"`
+	CreatedAt int64    `json:"createdAt"`
+	OpenedAt  int64    `json:"openedAt,omitempty"`
+	ClosedAt  int64    `json:"closedAt,omitempty"`
+	// keyHashes are the public key hashes of the parties.
+	keyHashes [][]byte
+}
+
+type Elections []Election
+
+type GetFn func(id string) (*Election, bool)
+
+type ListFn func() (Elections, error)
+
+type SaveFn func(Election) error
+
+type CreateFn func(Election) (Election, error)
+
+type TransitionFn func(id string) (Election, error)
+
+func (e Election) Verify() error {
+	switch {
+	case !validID.MatchString(e.ID):
+		return errors.Wrapf(ErrInvalidElection, "Id %q may only contain lowercase letters, digits and dashes", e.ID)
+	case len(e.Parties) == 0:
+		return errors.Wrapf(ErrInvalidElection, "Election %s has no parties", e.ID)
+	case e.EndsAt <= e.StartsAt:
+		return errors.Wrapf(ErrInvalidElection, "Election %s has to end after it starts", e.ID)
+	}
+	return nil
+}
+
+// Accepts returns why a vote cast at the time isn't accepted, nil if it is.
+// Votes cast before the election was closed stay valid, so blocks forged
+// after closing can still carry them.
+func (e Election) Accepts(at int64) error {
+	switch {
+	case e.Status == Draft, at < e.OpenedAt, e.ClosedAt != 0 && at >= e.ClosedAt:
+		return errors.Wrapf(ErrElectionClosed, "Election %s", e.ID)
+	case at < e.StartsAt, at >= e.EndsAt:
+		return errors.Wrapf(ErrOutsideWindow, "Election %s runs from %s to %s", e.ID, time.Unix(e.StartsAt, 0).UTC().Format(time.RFC3339), time.Unix(e.EndsAt, 0).UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (e Election) has(keyHash []byte) bool {
+	for _, k := range e.keyHashes {
+		if bytes.Equal(k, keyHash) {
+			return true
+		}
+	}
+	return false
+}
+
+// Registry keeps the elections in memory so transactions can be validated
+// without reading the database.
+type Registry struct {
+	lock         *sync.RWMutex
+	elections    map[string]Election
+	save         SaveFn
+	parseAddress address.ParseFn
+}
+
+func Load(list ListFn, save SaveFn, parseAddress address.ParseFn) (*Registry, error) {
+	elections, err := list()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to retrieve elections")
+	}
+	r := &Registry{
+		lock:         &sync.RWMutex{},
+		elections:    make(map[string]Election),
+		save:         save,
+		parseAddress: parseAddress,
+	}
+	for _, e := range elections {
+		if e, err = r.resolve(e); err != nil {
+			return nil, err
+		}
+		r.elections[e.ID] = e
+	}
+	return r, nil
+}
+
+func (r *Registry) resolve(e Election) (Election, error) {
+	e.keyHashes = nil
+	for _, a := range e.Parties {
+		keyHash, err := r.parseAddress(a)
+		if err != nil {
+			return Election{}, errors.Wrapf(ErrInvalidElection, "Party %s of election %s is not valid", a, e.ID)
+		}
+		e.keyHashes = append(e.keyHashes, keyHash)
+	}
+	return e, nil
+}
+
+func (r *Registry) Get(id string) (*Election, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	e, ok := r.elections[id]
+	if !ok {
+		return nil, false
+	}
+	return &e, true
+}
+
+func (r *Registry) List() (Elections, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	result := Elections{}
+	for _, e := range r.elections {
+		result = append(result, e)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt < result[j].CreatedAt || result[i].CreatedAt == result[j].CreatedAt && result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+// Create saves a new election as a draft. Parties are given by any address
+// the API accepts and are kept by the addresses of the party list.
+func (r *Registry) Create(parseAddress address.ParseFn, getParties party.GetPartiesFn) CreateFn {
+	return func(e Election) (Election, error) {
+		if err := e.Verify(); err != nil {
+			return Election{}, err
+		}
+		parties, err := getParties()
+		if err != nil {
+			return Election{}, errors.Wrap(err, "Failed to retrieve parties")
+		}
+		listed := map[string]bool{}
+		for i, a := range e.Parties {
+			keyHash, err := parseAddress(a)
+			if err != nil {
+				return Election{}, errors.Wrapf(ErrInvalidElection, "Invalid party address %s", a)
+			}
+			p, ok := parties.FindByKeyHash(keyHash)
+			switch {
+			case !ok:
+				return Election{}, errors.Wrapf(ErrInvalidElection, "Party %s does not exist", a)
+			case listed[p.Address]:
+				return Election{}, errors.Wrapf(ErrInvalidElection, "Party %s is listed more than once", a)
+			}
+			listed[p.Address] = true
+			e.Parties[i] = p.Address
+		}
+		if e, err = r.resolve(e); err != nil {
+			return Election{}, err
+		}
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		if _, ok := r.elections[e.ID]; ok {
+			return Election{}, errors.Wrapf(ErrElectionExists, "Election %s", e.ID)
+		}
+		e.Status = Draft
+		e.CreatedAt = time.Now().Unix()
+		e.OpenedAt, e.ClosedAt = 0, 0
+		return e, r.put(e)
+	}
+}
+
+func (r *Registry) put(e Election) error {
+	if err := r.save(e); err != nil {
+		return errors.Wrapf(err, "Failed to save election %s", e.ID)
+	}
+	r.elections[e.ID] = e
+	return nil
+}
+
+// Open opens a draft election, votes are accepted from then on within its
+// window.
+func (r *Registry) Open() TransitionFn {
+	return r.transition(func(e *Election, now int64) error {
+		if e.Status != Draft {
+			return errors.Wrapf(ErrInvalidElection, "Election %s is %s", e.ID, e.Status)
+		}
+		e.Status, e.OpenedAt = Open, now
+		return nil
+	})
+}
+
+// Close closes an election for good, a draft election is closed without
+// ever accepting votes.
+func (r *Registry) Close() TransitionFn {
+	return r.transition(func(e *Election, now int64) error {
+		if e.Status == Closed {
+			return errors.Wrapf(ErrInvalidElection, "Election %s is already closed", e.ID)
+		}
+		if e.Status == Draft {
+			e.OpenedAt = now
+		}
+		e.Status, e.ClosedAt = Closed, now
+		return nil
+	})
+}
+
+func (r *Registry) transition(apply func(*Election, int64) error) TransitionFn {
+	return func(id string) (Election, error) {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		e, ok := r.elections[id]
+		if !ok {
+			return Election{}, errors.Wrapf(ErrUnknownElection, "Election %s", id)
+		}
+		if err := apply(&e, time.Now().Unix()); err != nil {
+			return Election{}, err
+		}
+		return e, r.put(e)
+	}
+}
+
+// Validate rejects transactions giving votes to parties of elections which
+// didn't accept votes at the time the transaction was created. A party on
+// the lists of several elections takes votes while any of them accepts.
+// Transactions of the alfa node, such as returned stakes, aren't votes.
+func (r *Registry) Validate(alfaKeyHash []byte) transaction.ValidateFn {
+	return func(t transaction.Transaction) error {
+		if len(t.Inputs) > 0 && t.AreInputsFrom(alfaKeyHash) {
+			return nil
+		}
+		r.lock.RLock()
+		defer r.lock.RUnlock()
+		for _, out := range t.Outputs {
+			if len(t.Inputs) > 0 && bytes.Equal(out.PublicKeyHash, t.Inputs[0].PublicKeyHash) {
+				continue
+			}
+			var rejection error
+			for _, e := range r.elections {
+				if !e.has(out.PublicKeyHash) {
+					continue
+				}
+				if rejection = e.Accepts(t.Timestamp); rejection == nil {
+					break
+				}
+			}
+			if rejection != nil {
+				return errors.Wrapf(rejection, "Transaction %x gives votes to %s", t.ID, redact.Key(out.PublicKeyHash))
+			}
+		}
+		return nil
+	}
+}
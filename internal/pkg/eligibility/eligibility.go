@@ -0,0 +1,155 @@
+package eligibility
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type Voter struct {
+	MemberID string `json:"memberId"`
+	Address  string `json:"address"`
+}
+
+// Provider decides whether a voter may register, so organizations can plug
+// in their member databases without changing the registration handler.
+type Provider interface {
+	Name() string
+	IsEligible(Voter) (bool, error)
+}
+
+type csvProvider struct {
+	path     string
+	lock     *sync.Mutex
+	modified time.Time
+	members  map[string]string
+}
+
+// NewCSV returns a provider backed by a CSV file whose rows hold a member id
+// and optionally the only address the member may register. The file is
+// read again whenever it changes.
+func NewCSV(path string) (Provider, error) {
+	p := &csvProvider{
+		path: path,
+		lock: &sync.Mutex{},
+	}
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *csvProvider) Name() string {
+	return "csv"
+}
+
+func (p *csvProvider) reload() error {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to read stat for file %s", p.path)
+	}
+	if p.members != nil && !info.ModTime().After(p.modified) {
+		return nil
+	}
+	f, err := os.Open(p.path)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to open eligibility file %s", p.path)
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	members := map[string]string{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "Failed to parse eligibility file %s", p.path)
+		}
+		if len(record) == 0 || record[0] == "" || strings.HasPrefix(record[0], "#") {
+			continue
+		}
+		address := ""
+		if len(record) > 1 {
+			address = record[1]
+		}
+		members[record[0]] = address
+	}
+	p.members = members
+	p.modified = info.ModTime()
+	return nil
+}
+
+func (p *csvProvider) IsEligible(v Voter) (bool, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if err := p.reload(); err != nil {
+		return false, err
+	}
+	address, ok := p.members[v.MemberID]
+	if !ok {
+		return false, nil
+	}
+	return address == "" || address == v.Address, nil
+}
+
+type httpProvider struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTP returns a provider which posts the voter as JSON to the url and
+// expects a JSON response of the form {"eligible": true}.
+func NewHTTP(url string) Provider {
+	return httpProvider{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p httpProvider) Name() string {
+	return "http"
+}
+
+func (p httpProvider) IsEligible(v Voter) (bool, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return false, errors.Wrapf(err, "Failed to serialize voter %#v", v)
+	}
+	resp, err := p.client.Post(p.url, "application/json", bytes.NewReader(raw))
+	if err != nil {
+		return false, errors.Wrapf(err, "Failed to reach eligibility service %s", p.url)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, errors.Errorf("Eligibility service %s responded with status %d", p.url, resp.StatusCode)
+	}
+	var result struct {
+		Eligible bool `json:"eligible"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, errors.Wrap(err, "Failed to parse eligibility service response")
+	}
+	return result.Eligible, nil
+}
+
+func New(kind, source string) (Provider, error) {
+	switch kind {
+	case "csv":
+		return NewCSV(source)
+	case "http":
+		return NewHTTP(source), nil
+	default:
+		return nil, errors.Errorf("Unknown eligibility provider %s", kind)
+	}
+}
@@ -0,0 +1,260 @@
+package emergency
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidEmergency = errors.New("Invalid emergency")
+
+var paused = metrics.NewGauge("election_paused", "Whether the election is paused by its trustees (0 or 1)")
+
+// State of the election set by the latest emergency in the blockchain.
+// Checkpoint is the block the election was rolled back to if the latest
+// emergency is a rollback.
+type State struct {
+	Election    []byte `json:"election"`
+	Paused      bool   `json:"paused"`
+	Reason      string `json:"reason,omitempty"`
+	IssuedAt    int64  `json:"issuedAt,omitempty"`
+	Transaction []byte `json:"transaction,omitempty"`
+	Checkpoint  []byte `json:"checkpoint,omitempty"`
+}
+
+type PausedFn func() bool
+
+type GetStateFn func() State
+
+// SubmitFn puts an emergency signed by the trustees on chain.
+type SubmitFn func(transaction.Emergency) (State, error)
+
+// InvalidateFn records the transactions a rollback removed from the
+// blockchain, their receipts are no longer valid.
+type InvalidateFn func(transactions [][]byte, rollback []byte) error
+
+// GetInvalidationFn returns the rollback which removed the transaction, nil
+// if none did.
+type GetInvalidationFn func(transaction []byte) ([]byte, error)
+
+// Trustees are the keys of the election commission, Quorum of them have to
+// sign an emergency.
+type Trustees struct {
+	Keys   [][]byte
+	Quorum int
+}
+
+// ReadTrustees loads public keys of the trustees from the directory. The
+// quorum is a majority of the trustees if 0.
+func ReadTrustees(dir string, quorum int) (*Trustees, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read trustees directory %s", dir)
+	}
+	result := &Trustees{Quorum: quorum}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), "_pub.pem") {
+			continue
+		}
+		key, err := wallet.LoadPublicKey(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to load trustee key %s", f.Name())
+		}
+		result.Keys = append(result.Keys, key)
+	}
+	if result.Quorum == 0 {
+		result.Quorum = len(result.Keys)/2 + 1
+	}
+	switch {
+	case len(result.Keys) == 0:
+		return nil, errors.Errorf("No trustee public keys in %s", dir)
+	case result.Quorum < 0 || result.Quorum > len(result.Keys):
+		return nil, errors.Errorf("Quorum %d is not possible with %d trustees", result.Quorum, len(result.Keys))
+	}
+	return result, nil
+}
+
+// Switch follows the state of the election as blocks are added.
+type Switch struct {
+	lock  sync.RWMutex
+	state State
+}
+
+// Load restores the state from the blockchain.
+func Load(findBlock blockchain.FindBlockFn) (*Switch, error) {
+	genesis, ok, err := findBlock(func(b blockchain.Block) bool {
+		return len(b.Header.Prev) == 0
+	})
+	switch {
+	case err != nil:
+		return nil, errors.Wrap(err, "Failed to find genesis block")
+	case !ok:
+		return nil, errors.New("Blockchain has no genesis block")
+	}
+	s := &Switch{state: State{Election: genesis.Header.Hash}}
+	latest, ok, err := findBlock(func(b blockchain.Block) bool {
+		_, found := b.Body.Transactions.Find(transaction.Transaction.IsEmergency)
+		return found
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to find latest emergency")
+	}
+	if ok {
+		s.apply(latest)
+	}
+	return s, nil
+}
+
+func (s *Switch) State() State {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.state
+}
+
+func (s *Switch) Paused() bool {
+	return s.State().Paused
+}
+
+func (s *Switch) apply(b blockchain.Block) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, t := range b.Body.Transactions {
+		if !t.IsEmergency() {
+			continue
+		}
+		statement := t.Emergency.Statement
+		s.state = State{
+			Election:    s.state.Election,
+			Paused:      statement.Action != transaction.Resume,
+			Reason:      statement.Reason,
+			IssuedAt:    statement.IssuedAt,
+			Transaction: t.ID,
+			Checkpoint:  statement.Checkpoint,
+		}
+		switch {
+		case statement.Action == transaction.Rollback:
+			paused.Set(1)
+			log.Printf("ALERT: election rolled back to block %x and paused by its trustees: %s", statement.Checkpoint, statement.Reason)
+		case s.state.Paused:
+			paused.Set(1)
+			log.Printf("ALERT: election paused by its trustees: %s", statement.Reason)
+		default:
+			paused.Set(0)
+			log.Printf("Election resumed by its trustees: %s", statement.Reason)
+		}
+	}
+}
+
+// Check makes sure the emergency is signed by a quorum of the trustees for
+// this election, changes the state and is newer than the emergency which
+// set the current state, so old statements can't be replayed. A rollback
+// pauses the election whatever its state, where it returns to is checked
+// against the blockchain by whoever adds it.
+func (s *Switch) Check(trustees *Trustees, e transaction.Emergency) error {
+	state := s.State()
+	statement := e.Statement
+	switch {
+	case trustees == nil:
+		return errors.Wrap(ErrInvalidEmergency, "No trustees are configured")
+	case statement.Action != transaction.Pause && statement.Action != transaction.Resume && statement.Action != transaction.Rollback:
+		return errors.Wrapf(ErrInvalidEmergency, "Unknown action %q", statement.Action)
+	case !bytes.Equal(statement.Election, state.Election):
+		return errors.Wrapf(ErrInvalidEmergency, "Statement is for election %x", statement.Election)
+	case statement.Action == transaction.Rollback && len(statement.Checkpoint) == 0:
+		return errors.Wrap(ErrInvalidEmergency, "Rollback has no checkpoint")
+	case statement.Action != transaction.Rollback && len(statement.Checkpoint) > 0:
+		return errors.Wrapf(ErrInvalidEmergency, "Only a rollback has a checkpoint, not a %s", statement.Action)
+	case statement.Action != transaction.Rollback && (statement.Action == transaction.Pause) == state.Paused:
+		return errors.Wrapf(ErrInvalidEmergency, "Election is already in the state of %s", statement.Action)
+	case statement.IssuedAt <= state.IssuedAt:
+		return errors.Wrapf(ErrInvalidEmergency, "Statement is not newer than the current state issued at %d", state.IssuedAt)
+	}
+	if signed := e.Signers(trustees.Keys); signed < trustees.Quorum {
+		return errors.Wrapf(ErrInvalidEmergency, "Only %d of %d required trustees signed", signed, trustees.Quorum)
+	}
+	return nil
+}
+
+// VerifyTransactions accepts an emergency checked against the trustees and,
+// while the election is paused, nothing else.
+func VerifyTransactions(s *Switch, trustees *Trustees, verify transaction.VerifyTransctionFn) transaction.VerifyTransctionFn {
+	return func(t transaction.Transaction) bool {
+		if t.IsEmergency() {
+			if len(t.Inputs) != 0 || len(t.Outputs) != 0 {
+				return false
+			}
+			if err := s.Check(trustees, *t.Emergency); err != nil {
+				log.Printf("Rejecting emergency %x %s", t.ID, err)
+				return false
+			}
+			return true
+		}
+		if s.Paused() {
+			log.Printf("Rejecting transaction %x while the election is paused", t.ID)
+			return false
+		}
+		return verify(t)
+	}
+}
+
+// Rewinds accepts blocks made of a single rollback, checked against the
+// trustees, whose parent is its checkpoint.
+func (s *Switch) Rewinds(trustees *Trustees) blockchain.IsRewindFn {
+	return func(b blockchain.Block) bool {
+		if len(b.Body.Transactions) != 1 || !b.Body.Transactions[0].IsRollback() {
+			return false
+		}
+		e := *b.Body.Transactions[0].Emergency
+		if !bytes.Equal(e.Statement.Checkpoint, b.Header.Prev) {
+			log.Printf("Rejecting rollback %x to %x in a block on %x", b.Body.Transactions[0].ID, e.Statement.Checkpoint, b.Header.Prev)
+			return false
+		}
+		if err := s.Check(trustees, e); err != nil {
+			log.Printf("Rejecting rollback %x %s", b.Body.Transactions[0].ID, err)
+			return false
+		}
+		return true
+	}
+}
+
+func (s *Switch) AddBlock(add blockchain.AddBlockFn) blockchain.AddBlockFn {
+	return func(b blockchain.Block) ([]byte, error) {
+		tip, err := add(b)
+		if err != nil {
+			return nil, err
+		}
+		s.apply(b)
+		return tip, nil
+	}
+}
+
+func (s *Switch) AddNewBlock(add blockchain.AddNewBlockFn) blockchain.AddNewBlockFn {
+	return func(b blockchain.Block) error {
+		if err := add(b); err != nil {
+			return err
+		}
+		s.apply(b)
+		return nil
+	}
+}
+
+// Halt ignores messages of the handler while the election is paused.
+func Halt(isPaused PausedFn, h websocket.Handler) websocket.Handler {
+	return func(ping websocket.Ping, internalID string) (*websocket.Pong, error) {
+		if isPaused() {
+			log.Printf("Ignoring %s while the election is paused", ping.Message)
+			return websocket.NewNoActionPong(), nil
+		}
+		return h(ping, internalID)
+	}
+}
@@ -0,0 +1,292 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
+	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+type Type string
+
+const (
+	BlockEvent     Type = "block"
+	VoteEvent      Type = "vote"
+	MilestoneEvent Type = "milestone"
+)
+
+type Event struct {
+	Type         Type     `json:"type"`
+	Height       int      `json:"height"`
+	Block        []byte   `json:"block"`
+	Size         int      `json:"size,omitempty"`
+	Transaction  []byte   `json:"transaction,omitempty"`
+	Party        string   `json:"party,omitempty"`
+	Question     string   `json:"question,omitempty"`
+	Value        int      `json:"value,omitempty"`
+	Addresses    [][]byte `json:"addresses,omitempty"`
+	partyAddress string
+}
+
+// MarshalJSON gives addresses touched by the event as Base58Check
+// addresses.
+func (e Event) MarshalJSON() ([]byte, error) {
+	type plain Event
+	addresses := make([]string, 0, len(e.Addresses))
+	for _, a := range e.Addresses {
+		addresses = append(addresses, wallet.EncodeAddress(a))
+	}
+	return json.Marshal(struct {
+		plain
+		Addresses []string `json:"addresses,omitempty"`
+	}{
+		plain:     plain(e),
+		Addresses: addresses,
+	})
+}
+
+type Events []Event
+
+// Filter selects the events delivered to an observer. An empty filter
+// receives all block and vote events. Addresses match blocks and votes
+// touching the address, parties match votes for the party given by its name
+// or address and milestone receives an event every milestone blocks.
+type Filter struct {
+	Addresses []string `json:"addresses"`
+	Parties   []string `json:"parties"`
+	Milestone int      `json:"milestone"`
+}
+
+type compiledFilter struct {
+	empty     bool
+	hashes    [][]byte
+	parties   map[string]bool
+	milestone int
+}
+
+func (f Filter) compile() compiledFilter {
+	result := compiledFilter{
+		empty:     len(f.Addresses) == 0 && len(f.Parties) == 0 && f.Milestone <= 0,
+		parties:   make(map[string]bool),
+		milestone: f.Milestone,
+	}
+	for _, address := range f.Addresses {
+		if hash, err := wallet.DecodeAddress(address); err == nil {
+			result.hashes = append(result.hashes, hash)
+		}
+	}
+	for _, p := range f.Parties {
+		result.parties[p] = true
+	}
+	return result
+}
+
+func (f compiledFilter) touches(addresses [][]byte) bool {
+	for _, hash := range f.hashes {
+		for _, address := range addresses {
+			if bytes.Equal(hash, address) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f compiledFilter) match(e Event) bool {
+	if f.empty {
+		return e.Type != MilestoneEvent
+	}
+	switch e.Type {
+	case BlockEvent:
+		return f.touches(e.Addresses)
+	case VoteEvent:
+		return f.parties[e.Party] || f.parties[e.partyAddress] || f.touches(e.Addresses)
+	case MilestoneEvent:
+		return f.milestone > 0 && e.Height%f.milestone == 0
+	default:
+		return false
+	}
+}
+
+// FromBlock creates the block event, a vote event for every output given to
+// a party and a milestone event for the block at the given height.
+func FromBlock(block blockchain.Block, height int, parties party.Parties) Events {
+	partyKeys := make(map[string]party.Party)
+	for _, p := range parties {
+		partyKeys[string(wallet.ExtractPublicKeyHash(p.Address))] = p
+	}
+	touched := map[string]bool{}
+	var addresses [][]byte
+	touch := func(address []byte) {
+		if !touched[string(address)] {
+			touched[string(address)] = true
+			addresses = append(addresses, address)
+		}
+	}
+	var votes Events
+	for _, tx := range block.Body.Transactions {
+		senders := map[string]bool{}
+		var txAddresses [][]byte
+		for _, in := range tx.Inputs {
+			touch(in.PublicKeyHash)
+			if !senders[string(in.PublicKeyHash)] {
+				senders[string(in.PublicKeyHash)] = true
+				txAddresses = append(txAddresses, in.PublicKeyHash)
+			}
+		}
+		for _, out := range tx.Outputs {
+			touch(out.PublicKeyHash)
+			p, ok := partyKeys[string(out.PublicKeyHash)]
+			if !ok || senders[string(out.PublicKeyHash)] {
+				continue
+			}
+			votes = append(votes, Event{
+				Type:         VoteEvent,
+				Height:       height,
+				Block:        block.Header.Hash,
+				Transaction:  tx.ID,
+				Size:         tx.Size(),
+				Party:        p.Name,
+				Question:     p.Question,
+				Value:        out.Value,
+				Addresses:    append(append([][]byte{}, txAddresses...), out.PublicKeyHash),
+				partyAddress: p.Address,
+			})
+		}
+	}
+	result := Events{{
+		Type:      BlockEvent,
+		Height:    height,
+		Block:     block.Header.Hash,
+		Size:      block.Size(),
+		Addresses: addresses,
+	}}
+	result = append(result, votes...)
+	return append(result, Event{
+		Type:   MilestoneEvent,
+		Height: height,
+		Block:  block.Header.Hash,
+	})
+}
+
+var droppedEvents = metrics.NewCounter("events_dropped_total", "Events not delivered to slow observers")
+
+type Subscription struct {
+	C      chan Event
+	lock   *sync.Mutex
+	filter compiledFilter
+}
+
+func (s *Subscription) SetFilter(f Filter) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.filter = f.compile()
+}
+
+func (s *Subscription) match(e Event) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.filter.match(e)
+}
+
+// Feed delivers published events to observers whose filters match them.
+// Events are dropped for observers that don't keep up, so that a slow
+// dashboard never holds up the blockchain.
+type Feed struct {
+	lock          *sync.RWMutex
+	subscriptions map[*Subscription]bool
+}
+
+func NewFeed() *Feed {
+	return &Feed{
+		lock:          &sync.RWMutex{},
+		subscriptions: make(map[*Subscription]bool),
+	}
+}
+
+func (f *Feed) Subscribe(filter Filter) *Subscription {
+	s := &Subscription{
+		C:      make(chan Event, 64),
+		lock:   &sync.Mutex{},
+		filter: filter.compile(),
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.subscriptions[s] = true
+	return s
+}
+
+func (f *Feed) Unsubscribe(s *Subscription) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.subscriptions[s] {
+		delete(f.subscriptions, s)
+		close(s.C)
+	}
+}
+
+func (f *Feed) Publish(events Events) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	for s := range f.subscriptions {
+		for _, e := range events {
+			if !s.match(e) {
+				continue
+			}
+			select {
+			case s.C <- e:
+			default:
+				droppedEvents.Inc()
+			}
+		}
+	}
+}
+
+func publishTip(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, getParties party.GetPartiesFn, feed *Feed) error {
+	height, err := blockchain.GetHeight(getTip, getBlock)
+	if err != nil {
+		return errors.Wrap(err, "Failed to get height")
+	}
+	tip, err := getBlock(getTip())
+	if err != nil || tip == nil {
+		return errors.Errorf("Failed to get tip block %s", err)
+	}
+	parties, err := getParties()
+	if err != nil {
+		return errors.Wrap(err, "Failed to retrieve parties")
+	}
+	feed.Publish(FromBlock(*tip, height, parties))
+	return nil
+}
+
+// PublishNewBlock publishes the events of every block added to the tip.
+// Failing to publish doesn't fail adding the block.
+func PublishNewBlock(addNewBlock blockchain.AddNewBlockFn, getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, getParties party.GetPartiesFn, feed *Feed) blockchain.AddNewBlockFn {
+	return func(block blockchain.Block) error {
+		if err := addNewBlock(block); err != nil {
+			return err
+		}
+		if err := publishTip(getTip, getBlock, getParties, feed); err != nil {
+			logFailure(block, err)
+		}
+		return nil
+	}
+}
+
+func PublishBlock(addBlock blockchain.AddBlockFn, getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, getParties party.GetPartiesFn, feed *Feed) blockchain.AddBlockFn {
+	return func(block blockchain.Block) ([]byte, error) {
+		tip, err := addBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		if err := publishTip(getTip, getBlock, getParties, feed); err != nil {
+			logFailure(block, err)
+		}
+		return tip, nil
+	}
+}
@@ -0,0 +1,57 @@
+package events
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+)
+
+func logFailure(block blockchain.Block, err error) {
+	log.Printf("Failed to publish events of block %x %s", block.Header.Hash, err)
+}
+
+func filterFromQuery(request *http.Request) Filter {
+	query := request.URL.Query()
+	milestone, _ := strconv.Atoi(query.Get("milestone"))
+	return Filter{
+		Addresses: query["address"],
+		Parties:   query["party"],
+		Milestone: milestone,
+	}
+}
+
+// Handler streams events to observers. The initial filter is taken from the
+// address, party and milestone query parameters and is replaced whenever the
+// observer sends a new filter.
+func Handler(feed *Feed) http.Handler {
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(*http.Request) bool { return true },
+	}
+	return http.HandlerFunc(func(resp http.ResponseWriter, request *http.Request) {
+		conn, err := upgrader.Upgrade(resp, request, nil)
+		if err != nil {
+			log.Printf("Failed to open events websocket %s", err)
+			return
+		}
+		defer conn.Close()
+		subscription := feed.Subscribe(filterFromQuery(request))
+		go func() {
+			defer feed.Unsubscribe(subscription)
+			for {
+				var filter Filter
+				if err := conn.ReadJSON(&filter); err != nil {
+					return
+				}
+				subscription.SetFilter(filter)
+			}
+		}()
+		for e := range subscription.C {
+			if err := conn.WriteJSON(e); err != nil {
+				feed.Unsubscribe(subscription)
+			}
+		}
+	})
+}
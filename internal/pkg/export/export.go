@@ -0,0 +1,87 @@
+package export
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
+)
+
+var exported = metrics.NewCounter("export_entries_total", "Number of database entries exported")
+
+// Entry is a key of a bucket, nested buckets are separated by a slash.
+type Entry struct {
+	Bucket string
+	Key    []byte
+	Value  []byte
+}
+
+type VisitFn func(Entry) error
+
+// ExportFn visits every entry of the buckets, all of them if none are
+// given. Entries are read in chunks, each chunk sees the data committed
+// when it was read, so writers never wait for the visitor.
+type ExportFn func(buckets []string, visit VisitFn) error
+
+// SnapshotFn writes a consistent copy of the whole database.
+type SnapshotFn func(w io.Writer) (int64, error)
+
+// Chunk bounds a single read, whichever limit is reached first ends it.
+type Chunk struct {
+	Entries  int
+	Duration time.Duration
+}
+
+var DefaultChunk = Chunk{Entries: 1000, Duration: 50 * time.Millisecond}
+
+// Done tells whether a read which started at start and read entries has
+// to stop. Every read gets at least one entry, so an export always ends.
+func (c Chunk) Done(entries int, start time.Time) bool {
+	return entries > 0 && (entries >= c.Entries || time.Since(start) >= c.Duration)
+}
+
+// CSVHandler streams the entries as CSV with the bucket, the hex encoded
+// key and the base64 encoded value, ?bucket=<name> may be repeated.
+func CSVHandler(export ExportFn) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		out := csv.NewWriter(w)
+		out.Write([]string{"bucket", "key", "value"})
+		count := 0
+		err := export(r.URL.Query()["bucket"], func(e Entry) error {
+			count++
+			exported.Inc()
+			return out.Write([]string{e.Bucket, hex.EncodeToString(e.Key), base64.StdEncoding.EncodeToString(e.Value)})
+		})
+		out.Flush()
+		if err == nil {
+			err = out.Error()
+		}
+		if err != nil {
+			// The status is already sent, the export is cut short instead.
+			log.Printf("Export of %s stopped after %d entries %s", strings.Join(r.URL.Query()["bucket"], ","), count, err)
+			return
+		}
+		log.Printf("Exported %d entries", count)
+	})
+}
+
+// SnapshotHandler streams a consistent copy of the database.
+func SnapshotHandler(snapshot SnapshotFn) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="snapshot.db"`)
+		n, err := snapshot(w)
+		if err != nil {
+			log.Printf("Snapshot stopped after %d bytes %s", n, err)
+			return
+		}
+		log.Printf("Streamed snapshot of %d bytes", n)
+	})
+}
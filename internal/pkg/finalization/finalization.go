@@ -0,0 +1,77 @@
+package finalization
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNotFinalized = errors.New("Election has not been finalized")
+	ErrIntakeClosed = errors.New("Vote intake is closed")
+)
+
+// Finalization freezes the result of the election at the given tip.
+type Finalization struct {
+	TipHash     []byte `json:"tipHash"`
+	Height      int    `json:"height"`
+	FinalizedAt int64  `json:"finalizedAt"`
+}
+
+type GetFn func() (*Finalization, error)
+
+type SaveFn func(Finalization) error
+
+// Head is what co-signers attest, the finalized tip at its height.
+type Head struct {
+	TipHash []byte `json:"tipHash"`
+	Height  int    `json:"height"`
+}
+
+func (h Head) Signable() ([]byte, error) {
+	return json.Marshal(h)
+}
+
+func (f Finalization) Head() Head {
+	return Head{
+		TipHash: f.TipHash,
+		Height:  f.Height,
+	}
+}
+
+// Cosignature is given by a party node confirming that the finalized tip is
+// in its own blockchain at the finalized height.
+type Cosignature struct {
+	Head      Head   `json:"head"`
+	Verifier  []byte `json:"verifier"`
+	Signature []byte `json:"signature"`
+	SignedAt  int64  `json:"signedAt"`
+}
+
+type Cosignatures []Cosignature
+
+func (c Cosignature) Verify(f Finalization) bool {
+	head := f.Head()
+	return bytes.Equal(c.Head.TipHash, head.TipHash) &&
+		c.Head.Height == head.Height &&
+		len(c.Verifier) > 0 &&
+		wallet.Verify(c.Head, c.Signature, c.Verifier)
+}
+
+type SaveCosignatureFn func(Cosignature) error
+
+type GetCosignaturesFn func() (Cosignatures, error)
+
+// State tracks the automatic finalization of the election. Zero times mean
+// the step hasn't happened yet.
+type State struct {
+	ClosedAt        int64 `json:"closedAt,omitempty"`
+	CertifiedAt     int64 `json:"certifiedAt,omitempty"`
+	QuorumAlertedAt int64 `json:"quorumAlertedAt,omitempty"`
+}
+
+type GetStateFn func() (State, error)
+
+type SaveStateFn func(State) error
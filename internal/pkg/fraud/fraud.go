@@ -0,0 +1,140 @@
+package fraud
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/transport"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidProof    = errors.New("Fraud proof is invalid")
+	ErrAlreadyRecorded = errors.New("Fraud at the height has already been recorded")
+)
+
+// Proof consists of two block-forged or compact-block messages exactly as
+// the offender signed them, announcing different blocks at the same height.
+type Proof struct {
+	First  websocket.Ping `json:"first"`
+	Second websocket.Ping `json:"second"`
+}
+
+func (p Proof) Hash() ([]byte, error) {
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to serialize fraud proof")
+	}
+	hash := sha256.Sum256(raw)
+	return hash[:], nil
+}
+
+// Violation is what a valid proof proves, Offender is the chain key of the
+// forger.
+type Violation struct {
+	Offender []byte   `json:"offender"`
+	Height   int      `json:"height"`
+	Blocks   [][]byte `json:"blocks"`
+}
+
+type VerifyFn func(Proof) (*Violation, error)
+
+type forged struct {
+	Height int              `json:"height"`
+	Block  blockchain.Block `json:"block"`
+}
+
+// Verify accepts a proof only if both messages are signed by the same chain
+// identity, directly or through a certified transport key, and announce
+// blocks with valid but different hashes at the same height.
+func Verify(findCertificate transport.FindCertificateFn) VerifyFn {
+	verifySignature := transport.VerifySignature(findCertificate)
+	open := func(ping websocket.Ping) ([]byte, *forged, error) {
+		if ping.Message != websocket.BlockForgedMessage && ping.Message != websocket.CompactBlockMessage {
+			return nil, nil, errors.Wrapf(ErrInvalidProof, "Message %s is not a forged block", ping.Message)
+		}
+		switch ok, err := verifySignature(ping, ping.Signature, ping.Sender); {
+		case err != nil:
+			return nil, nil, errors.Wrapf(err, "Failed to verify signature of %s", ping.Sender)
+		case !ok:
+			return nil, nil, errors.Wrapf(ErrInvalidProof, "Signature of %s is invalid", ping.Sender)
+		}
+		identity, err := transport.Identity(findCertificate, ping.Sender)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "Failed to resolve sender %s", ping.Sender)
+		}
+		var body forged
+		if err := json.Unmarshal(ping.Body, &body); err != nil {
+			return nil, nil, errors.Wrapf(ErrInvalidProof, "Failed to unmarshal forged block %s", err)
+		}
+		if !body.Block.CompactHeader(body.Height).IsHashValid() {
+			return nil, nil, errors.Wrapf(ErrInvalidProof, "Hash of block %x is invalid", body.Block.Header.Hash)
+		}
+		return identity, &body, nil
+	}
+	return func(p Proof) (*Violation, error) {
+		firstSigner, first, err := open(p.First)
+		if err != nil {
+			return nil, err
+		}
+		secondSigner, second, err := open(p.Second)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case !bytes.Equal(firstSigner, secondSigner):
+			return nil, errors.Wrap(ErrInvalidProof, "Blocks are signed by different forgers")
+		case first.Height != second.Height:
+			return nil, errors.Wrapf(ErrInvalidProof, "Blocks are at different heights %d and %d", first.Height, second.Height)
+		case bytes.Equal(first.Block.Header.Hash, second.Block.Header.Hash):
+			return nil, errors.Wrap(ErrInvalidProof, "Blocks are the same")
+		}
+		return &Violation{
+			Offender: firstSigner,
+			Height:   first.Height,
+			Blocks:   [][]byte{first.Block.Header.Hash, second.Block.Header.Hash},
+		}, nil
+	}
+}
+
+// Record is a verified proof as it is stored. Transaction is the evidence
+// transaction putting the violation on chain, nodes which only relay proofs
+// leave it empty.
+type Record struct {
+	Violation   Violation `json:"violation"`
+	Proof       Proof     `json:"proof"`
+	ProofHash   []byte    `json:"proofHash"`
+	ReportedAt  int64     `json:"reportedAt"`
+	Transaction []byte    `json:"transaction,omitempty"`
+}
+
+type Records []Record
+
+// Evidence is the on chain counterpart of the record.
+func (r Record) Evidence(signer []byte) transaction.Evidence {
+	return transaction.Evidence{
+		Offender:  r.Violation.Offender,
+		Height:    r.Violation.Height,
+		Blocks:    r.Violation.Blocks,
+		ProofHash: r.ProofHash,
+		Signer:    signer,
+	}
+}
+
+// SaveFn keeps a single record per offender and height, ErrAlreadyRecorded
+// is returned for any later one.
+type SaveFn func(Record) error
+
+type GetFn func() (Records, error)
+
+// IsSlashedFn tells whether fraud of the forger with the hashed chain key
+// has been recorded.
+type IsSlashedFn func(keyHash []byte) (bool, error)
+
+// ReportFn verifies and records the proof, putting it on chain where the
+// reporter is able to.
+type ReportFn func(Proof) (*Record, error)
@@ -0,0 +1,64 @@
+package fraud
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+)
+
+// witnessDepth is the number of heights below the highest seen one for
+// which forged blocks are remembered.
+const witnessDepth = 100
+
+type ObserveFn func(ping websocket.Ping, identity []byte, height int, hash []byte) *Proof
+
+type sighting struct {
+	ping   websocket.Ping
+	height int
+	hash   []byte
+}
+
+// Witness remembers the first block each forger announced at a height and
+// turns a different announcement at the same height into a proof.
+type Witness struct {
+	lock    sync.Mutex
+	seen    map[string]sighting
+	highest int
+}
+
+func NewWitness() *Witness {
+	return &Witness{seen: make(map[string]sighting)}
+}
+
+// Observe must be given messages whose signature is already verified.
+func (w *Witness) Observe(ping websocket.Ping, identity []byte, height int, hash []byte) *Proof {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if height <= w.highest-witnessDepth {
+		return nil
+	}
+	key := fmt.Sprintf("%x:%d", identity, height)
+	first, ok := w.seen[key]
+	if !ok {
+		w.seen[key] = sighting{ping: ping, height: height, hash: hash}
+		if height > w.highest {
+			w.highest = height
+			w.prune()
+		}
+		return nil
+	}
+	if bytes.Equal(first.hash, hash) {
+		return nil
+	}
+	return &Proof{First: first.ping, Second: ping}
+}
+
+func (w *Witness) prune() {
+	for key, s := range w.seen {
+		if s.height <= w.highest-witnessDepth {
+			delete(w.seen, key)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package hooks
+
+import (
+	"log"
+	"sync"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+var ErrRejected = errors.New("Rejected by validation policy")
+
+// ValidateTransactionHook vetoes a transaction by returning an error. It
+// sees every transaction, including stake, return stake and certification
+// transactions, and has to decide deterministically since every node
+// verifies the same blocks.
+type ValidateTransactionHook func(transaction.Transaction) error
+
+// ValidateBlockHook vetoes a block by returning an error.
+type ValidateBlockHook func(blockchain.Block) error
+
+// OnBlockAppliedHook is called after a block is added to the blockchain.
+type OnBlockAppliedHook func(blockchain.Block)
+
+type transactionHook struct {
+	name string
+	hook ValidateTransactionHook
+}
+
+type blockHook struct {
+	name string
+	hook ValidateBlockHook
+}
+
+type appliedHook struct {
+	name string
+	hook OnBlockAppliedHook
+}
+
+var (
+	lock         sync.RWMutex
+	names        []string
+	transactions []transactionHook
+	blocks       []blockHook
+	applied      []appliedHook
+)
+
+// claim has to be called with the lock held.
+func claim(name string, isNil bool) {
+	if isNil {
+		panic("hooks: hook " + name + " is nil")
+	}
+	for _, n := range names {
+		if n == name {
+			panic("hooks: hook " + name + " is registered twice")
+		}
+	}
+	names = append(names, name)
+}
+
+// RegisterValidateTransaction is meant to be called from init of a plugin
+// package compiled into the binaries.
+func RegisterValidateTransaction(name string, hook ValidateTransactionHook) {
+	lock.Lock()
+	defer lock.Unlock()
+	claim(name, hook == nil)
+	transactions = append(transactions, transactionHook{name: name, hook: hook})
+}
+
+func RegisterValidateBlock(name string, hook ValidateBlockHook) {
+	lock.Lock()
+	defer lock.Unlock()
+	claim(name, hook == nil)
+	blocks = append(blocks, blockHook{name: name, hook: hook})
+}
+
+func RegisterOnBlockApplied(name string, hook OnBlockAppliedHook) {
+	lock.Lock()
+	defer lock.Unlock()
+	claim(name, hook == nil)
+	applied = append(applied, appliedHook{name: name, hook: hook})
+}
+
+// Registered returns the names of all registered hooks in the order of
+// registration.
+func Registered() []string {
+	lock.RLock()
+	defer lock.RUnlock()
+	return append([]string{}, names...)
+}
+
+func ValidateTransaction(t transaction.Transaction) error {
+	lock.RLock()
+	hooks := append([]transactionHook{}, transactions...)
+	lock.RUnlock()
+	for _, h := range hooks {
+		if err := h.hook(t); err != nil {
+			return errors.Wrapf(ErrRejected, "Transaction %x rejected by %s: %s", t.ID, h.name, err)
+		}
+	}
+	return nil
+}
+
+func ValidateBlock(b blockchain.Block) error {
+	lock.RLock()
+	hooks := append([]blockHook{}, blocks...)
+	lock.RUnlock()
+	for _, h := range hooks {
+		if err := h.hook(b); err != nil {
+			return errors.Wrapf(ErrRejected, "Block %x rejected by %s: %s", b.Header.Hash, h.name, err)
+		}
+	}
+	return nil
+}
+
+// BlockApplied calls every OnBlockAppliedHook, a panicking hook is logged
+// and doesn't stop the others.
+func BlockApplied(b blockchain.Block) {
+	lock.RLock()
+	hooks := append([]appliedHook{}, applied...)
+	lock.RUnlock()
+	for _, h := range hooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("Hook %s panicked on block %x: %v", h.name, b.Header.Hash, r)
+				}
+			}()
+			h.hook(b)
+		}()
+	}
+}
+
+// VerifyBlock additionally requires blocks to pass every ValidateBlockHook.
+func VerifyBlock(verify blockchain.VerifyBlockFn) blockchain.VerifyBlockFn {
+	return func(b blockchain.Block, hashedSender []byte) bool {
+		if !verify(b, hashedSender) {
+			return false
+		}
+		if err := ValidateBlock(b); err != nil {
+			log.Println(err)
+			return false
+		}
+		return true
+	}
+}
+
+func AddNewBlock(add blockchain.AddNewBlockFn) blockchain.AddNewBlockFn {
+	return func(b blockchain.Block) error {
+		if err := add(b); err != nil {
+			return err
+		}
+		BlockApplied(b)
+		return nil
+	}
+}
+
+func AddBlock(add blockchain.AddBlockFn) blockchain.AddBlockFn {
+	return func(b blockchain.Block) ([]byte, error) {
+		tip, err := add(b)
+		if err != nil {
+			return nil, err
+		}
+		BlockApplied(b)
+		return tip, nil
+	}
+}
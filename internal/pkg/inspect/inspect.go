@@ -0,0 +1,359 @@
+package inspect
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/merkle"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+var ErrNotFound = errors.New("Not found")
+
+// Status tells whether a signature verifies. Unverified signatures need keys
+// the database doesn't hold, e.g. the ones of trustees, or the output they
+// spend is missing.
+type Status string
+
+const (
+	Valid      Status = "valid"
+	Invalid    Status = "invalid"
+	Unverified Status = "unverified"
+)
+
+// Input is an input with the address it spends from and Verifier, the
+// address of the key its signature is checked with. SignedAs names the
+// signable the signature verifies over.
+type Input struct {
+	Transaction string             `json:"transaction,omitempty"`
+	Vout        int                `json:"vout"`
+	Address     string             `json:"address"`
+	Value       int                `json:"value"`
+	Verifier    string             `json:"verifier"`
+	Signature   string             `json:"signature"`
+	Status      Status             `json:"status"`
+	SignedAs    transaction.Signed `json:"signedAs,omitempty"`
+	Reason      string             `json:"reason,omitempty"`
+}
+
+type Output struct {
+	Vout    int    `json:"vout"`
+	Address string `json:"address"`
+	Value   int    `json:"value"`
+}
+
+// Attestation is the signed payload of a transaction without inputs, e.g. a
+// certificate or evidence.
+type Attestation struct {
+	Kind   string `json:"kind"`
+	Status Status `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Step is a sibling on the path from the transaction to the transaction
+// hash of its block, Side tells on which side of the running hash it is.
+type Step struct {
+	Hash string `json:"hash"`
+	Side string `json:"side"`
+}
+
+// Proof is the Merkle path of a transaction in its block.
+type Proof struct {
+	Index     int    `json:"index"`
+	Algorithm string `json:"algorithm"`
+	Path      []Step `json:"path"`
+	Root      string `json:"root"`
+	Valid     bool   `json:"valid"`
+}
+
+// Transaction is either in the block at the height or pending in the
+// mempool. Blocks predating Merkle trees have no proofs.
+type Transaction struct {
+	ID          string       `json:"id"`
+	Kind        string       `json:"kind"`
+	Chain       string       `json:"chain,omitempty"`
+	Timestamp   string       `json:"timestamp,omitempty"`
+	Block       string       `json:"block,omitempty"`
+	Height      int          `json:"height,omitempty"`
+	Pending     bool         `json:"pending,omitempty"`
+	Inputs      []Input      `json:"inputs"`
+	Outputs     []Output     `json:"outputs"`
+	Attestation *Attestation `json:"attestation,omitempty"`
+	Proof       *Proof       `json:"proof,omitempty"`
+}
+
+type Block struct {
+	Hash                 string        `json:"hash"`
+	Prev                 string        `json:"prev"`
+	Height               int           `json:"height"`
+	Version              int           `json:"version"`
+	Algorithm            string        `json:"algorithm"`
+	Timestamp            string        `json:"timestamp"`
+	Size                 int           `json:"size"`
+	TransactionHash      string        `json:"transactionHash"`
+	HashValid            bool          `json:"hashValid"`
+	TransactionHashValid bool          `json:"transactionHashValid"`
+	Transactions         []Transaction `json:"transactions"`
+}
+
+// InspectBlockFn inspects the block given by its hash, the tip if the hash
+// is empty.
+type InspectBlockFn func(hash []byte) (*Block, error)
+
+type InspectTransactionFn func(id []byte) (*Transaction, error)
+
+func address(publicKeyHash []byte) string {
+	if len(publicKeyHash) == 0 {
+		return ""
+	}
+	return wallet.EncodeAddress(publicKeyHash)
+}
+
+func timestamp(unix int64) string {
+	if unix == 0 {
+		return ""
+	}
+	return time.Unix(unix, 0).UTC().Format(time.RFC3339)
+}
+
+// chain holds the blocks from the genesis block on and every transaction in
+// them, so the values of spent outputs can be looked up.
+type chain struct {
+	blocks       blockchain.Blocks
+	transactions map[string]transaction.Transaction
+}
+
+func load(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn) (chain, error) {
+	result := chain{transactions: map[string]transaction.Transaction{}}
+	for current := getTip(); len(current) > 0; {
+		block, err := getBlock(current)
+		switch {
+		case err != nil:
+			return chain{}, errors.Wrapf(err, "Failed to get block %x", current)
+		case block == nil:
+			return chain{}, errors.Errorf("Block %x is missing", current)
+		}
+		result.blocks = append(result.blocks, *block)
+		for _, t := range block.Body.Transactions {
+			result.transactions[string(t.ID)] = t
+		}
+		current = block.Header.Prev
+	}
+	for i, j := 0, len(result.blocks)-1; i < j; i, j = i+1, j-1 {
+		result.blocks[i], result.blocks[j] = result.blocks[j], result.blocks[i]
+	}
+	return result, nil
+}
+
+func kind(t transaction.Transaction) string {
+	switch {
+	case t.IsCertification():
+		return "certification"
+	case t.IsEvidence():
+		return "evidence"
+	case t.IsEmergency():
+		return "emergency"
+	case t.IsGuardianship():
+		return "guardianship"
+	case t.IsRecovery():
+		return "recovery"
+	case t.IsWithdrawal():
+		return "withdrawal"
+	case len(t.Inputs) == 0 || (len(t.Inputs) == 1 && t.Inputs[0].Vout == -1):
+		return "issue"
+	default:
+		return "transfer"
+	}
+}
+
+func attestation(t transaction.Transaction) *Attestation {
+	verified := func(kind string, ok bool) *Attestation {
+		if ok {
+			return &Attestation{Kind: kind, Status: Valid}
+		}
+		return &Attestation{Kind: kind, Status: Invalid, Reason: "signature doesn't verify"}
+	}
+	switch {
+	case t.IsCertification():
+		return verified("certificate", t.Certificate.Verified())
+	case t.IsEvidence():
+		return verified("evidence", t.Evidence.Verified())
+	case t.IsGuardianship():
+		return verified("guardianship", t.Guardianship.Verified())
+	case t.IsWithdrawal():
+		return verified("withdrawal", t.Withdrawal.Verified())
+	case t.IsEmergency():
+		return &Attestation{Kind: "emergency", Status: Unverified, Reason: fmt.Sprintf("%d signatures of trustees, verified against the trustees of the network", len(t.Emergency.Signatures))}
+	case t.IsRecovery():
+		return &Attestation{Kind: "recovery", Status: Unverified, Reason: fmt.Sprintf("%d signatures of guardians, verified against the guardianship of the voter", len(t.Recovery.Signatures))}
+	}
+	return nil
+}
+
+// value returns the value of the output the input spends, base
+// transactions are signed over the value of a vote.
+func (c chain) value(in transaction.Input) (int, bool) {
+	if in.Vout == -1 {
+		return transaction.VoteValue, true
+	}
+	spent, ok := c.transactions[string(in.TransactionID)]
+	if !ok || in.Vout < 0 || in.Vout >= len(spent.Outputs) {
+		return 0, false
+	}
+	return spent.Outputs[in.Vout].Value, true
+}
+
+func (c chain) input(t transaction.Transaction, index int, verifier wallet.VerifierFn) Input {
+	in := t.Inputs[index]
+	result := Input{
+		Vout:      in.Vout,
+		Address:   address(in.PublicKeyHash),
+		Signature: hex.EncodeToString(in.Signature),
+	}
+	if in.Vout >= 0 {
+		result.Transaction = hex.EncodeToString(in.TransactionID)
+	}
+	if hashed, err := wallet.HashedPublicKey(in.Verifier); err == nil && len(in.Verifier) > 0 {
+		result.Verifier = address(hashed)
+	}
+	if t.IsRecovery() {
+		result.Status, result.Reason = Unverified, "recoveries are signed by guardians"
+		return result
+	}
+	value, ok := c.value(in)
+	if !ok {
+		result.Status, result.Reason = Unverified, fmt.Sprintf("spent output %s:%d is not in the blockchain", result.Transaction, in.Vout)
+		return result
+	}
+	result.Value = value
+	if result.SignedAs = t.SignedAs(index, value, verifier); result.SignedAs == "" {
+		result.Status, result.Reason = Invalid, "signature verifies as neither a vote, a ballot nor an allocation"
+		return result
+	}
+	result.Status = Valid
+	return result
+}
+
+func proof(block blockchain.Block, index int) *Proof {
+	if block.Header.Version < blockchain.MerkleVersion {
+		return nil
+	}
+	algorithm := block.HashAlgorithm()
+	path, ok := merkle.Prove(algorithm, block.Body.Transactions.IDs(), index)
+	if !ok {
+		return nil
+	}
+	result := &Proof{
+		Index:     index,
+		Algorithm: string(algorithm.Normalized()),
+		Path:      []Step{},
+		Root:      hex.EncodeToString(block.Header.TransactionHash),
+		Valid:     merkle.Verify(algorithm, block.Body.Transactions[index].ID, path, block.Header.TransactionHash),
+	}
+	for _, s := range path {
+		side := "right"
+		if s.Left {
+			side = "left"
+		}
+		result.Path = append(result.Path, Step{Hash: hex.EncodeToString(s.Hash), Side: side})
+	}
+	return result
+}
+
+func (c chain) transaction(t transaction.Transaction) Transaction {
+	verifier := transaction.AcceptLegacy(wallet.VerifySignature)
+	result := Transaction{
+		ID:          hex.EncodeToString(t.ID),
+		Kind:        kind(t),
+		Chain:       string(t.ChainID),
+		Timestamp:   timestamp(t.Timestamp),
+		Inputs:      []Input{},
+		Outputs:     []Output{},
+		Attestation: attestation(t),
+	}
+	for i := range t.Inputs {
+		result.Inputs = append(result.Inputs, c.input(t, i, verifier))
+	}
+	for i, out := range t.Outputs {
+		result.Outputs = append(result.Outputs, Output{Vout: i, Address: address(out.PublicKeyHash), Value: out.Value})
+	}
+	return result
+}
+
+func (c chain) block(height int) *Block {
+	b := c.blocks[height-1]
+	result := &Block{
+		Hash:                 hex.EncodeToString(b.Header.Hash),
+		Prev:                 hex.EncodeToString(b.Header.Prev),
+		Height:               height,
+		Version:              b.Header.Version,
+		Algorithm:            string(b.HashAlgorithm().Normalized()),
+		Timestamp:            timestamp(b.Header.Timestamp),
+		Size:                 b.Metadata.Size,
+		TransactionHash:      hex.EncodeToString(b.Header.TransactionHash),
+		HashValid:            b.IsHashValid(),
+		TransactionHashValid: bytes.Equal(blockchain.TransactionHash(b.Header.Version, b.Header.Algorithm, b.Body.Transactions), b.Header.TransactionHash),
+		Transactions:         []Transaction{},
+	}
+	for i, t := range b.Body.Transactions {
+		view := c.transaction(t)
+		view.Block, view.Height, view.Proof = result.Hash, height, proof(b, i)
+		result.Transactions = append(result.Transactions, view)
+	}
+	return result
+}
+
+func InspectBlock(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn) InspectBlockFn {
+	return func(hash []byte) (*Block, error) {
+		c, err := load(getTip, getBlock)
+		if err != nil {
+			return nil, err
+		}
+		if len(hash) == 0 && len(c.blocks) > 0 {
+			return c.block(len(c.blocks)), nil
+		}
+		for i, b := range c.blocks {
+			if bytes.Equal(b.Header.Hash, hash) {
+				return c.block(i + 1), nil
+			}
+		}
+		return nil, errors.Wrapf(ErrNotFound, "Block %x is not in the blockchain", hash)
+	}
+}
+
+// InspectTransaction looks the transaction up in the blockchain and then
+// among the pending ones.
+func InspectTransaction(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, getPending transaction.GetTransactionsFn) InspectTransactionFn {
+	return func(id []byte) (*Transaction, error) {
+		c, err := load(getTip, getBlock)
+		if err != nil {
+			return nil, err
+		}
+		for i, b := range c.blocks {
+			for index, t := range b.Body.Transactions {
+				if bytes.Equal(t.ID, id) {
+					result := c.transaction(t)
+					result.Block, result.Height, result.Proof = hex.EncodeToString(b.Header.Hash), i+1, proof(b, index)
+					return &result, nil
+				}
+			}
+		}
+		pending, err := getPending()
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to retrieve pending transactions")
+		}
+		for _, t := range pending {
+			if bytes.Equal(t.ID, id) {
+				result := c.transaction(t)
+				result.Pending = true
+				return &result, nil
+			}
+		}
+		return nil, errors.Wrapf(ErrNotFound, "Transaction %x is neither in the blockchain nor pending", id)
+	}
+}
@@ -0,0 +1,152 @@
+package inspect
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidFormat = errors.New("Output format is not known")
+
+type Format string
+
+const (
+	// Text is meant to be read by people.
+	Text Format = "text"
+	JSON Format = "json"
+	// YAML holds the same fields as JSON in the same order.
+	YAML Format = "yaml"
+)
+
+var Formats = []Format{Text, JSON, YAML}
+
+func ParseFormat(raw string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == raw {
+			return f, nil
+		}
+	}
+	return "", errors.Wrapf(ErrInvalidFormat, "Format %s", raw)
+}
+
+// Render writes the block or transaction in the format.
+func Render(w io.Writer, format Format, v interface{}) error {
+	switch format {
+	case JSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	case YAML:
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return errors.Wrap(err, "Failed to marshal view")
+		}
+		result, err := ToYAML(raw)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(result)
+		return err
+	}
+	builder := &strings.Builder{}
+	switch view := v.(type) {
+	case *Block:
+		writeBlock(builder, *view)
+	case *Transaction:
+		writeTransaction(builder, *view, "")
+	default:
+		return errors.Errorf("Can't render %T as text", v)
+	}
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+func check(ok bool) string {
+	if ok {
+		return "valid"
+	}
+	return "INVALID"
+}
+
+func writeBlock(b *strings.Builder, block Block) {
+	b.WriteString("-----BEGIN BLOCK-----\n")
+	fmt.Fprintf(b, "Hash: %s (%s)\n", block.Hash, check(block.HashValid))
+	fmt.Fprintf(b, "Height: %d\n", block.Height)
+	fmt.Fprintf(b, "Prev: %s\n", block.Prev)
+	fmt.Fprintf(b, "Version: %d\n", block.Version)
+	fmt.Fprintf(b, "Algorithm: %s\n", block.Algorithm)
+	fmt.Fprintf(b, "Timestamp: %s\n", block.Timestamp)
+	fmt.Fprintf(b, "Size: %d\n", block.Size)
+	fmt.Fprintf(b, "Transaction hash: %s (%s)\n", block.TransactionHash, check(block.TransactionHashValid))
+	fmt.Fprintf(b, "Transactions: %d\n", len(block.Transactions))
+	for _, t := range block.Transactions {
+		b.WriteString("\n")
+		writeTransaction(b, t, "\t")
+	}
+	b.WriteString("-----END BLOCK-----\n")
+}
+
+func writeTransaction(b *strings.Builder, t Transaction, indent string) {
+	line := func(format string, args ...interface{}) {
+		b.WriteString(indent)
+		fmt.Fprintf(b, format, args...)
+		b.WriteString("\n")
+	}
+	line("ID: %s", t.ID)
+	line("Kind: %s", t.Kind)
+	if t.Chain != "" {
+		line("Chain: %s", t.Chain)
+	}
+	if t.Timestamp != "" {
+		line("Timestamp: %s", t.Timestamp)
+	}
+	switch {
+	case t.Pending:
+		line("Block: pending")
+	case indent == "":
+		line("Block: %s at height %d", t.Block, t.Height)
+	}
+	if len(t.Inputs) > 0 {
+		line("Inputs:")
+	}
+	for i, in := range t.Inputs {
+		if in.Transaction != "" {
+			line("\t%d. %s:%d", i, in.Transaction, in.Vout)
+		} else {
+			line("\t%d. issued", i)
+		}
+		line("\t\tFrom: %s", in.Address)
+		line("\t\tValue: %d", in.Value)
+		line("\t\tVerifier: %s", in.Verifier)
+		line("\t\tSignature: %s", in.Signature)
+		switch {
+		case in.Status == Valid:
+			line("\t\tStatus: valid %s signature", in.SignedAs)
+		default:
+			line("\t\tStatus: %s, %s", strings.ToUpper(string(in.Status)), in.Reason)
+		}
+	}
+	if len(t.Outputs) > 0 {
+		line("Outputs:")
+	}
+	for _, out := range t.Outputs {
+		line("\t%d. %s %d", out.Vout, out.Address, out.Value)
+	}
+	if a := t.Attestation; a != nil {
+		if a.Reason == "" {
+			line("Attestation: %s, %s", a.Kind, a.Status)
+		} else {
+			line("Attestation: %s, %s, %s", a.Kind, a.Status, a.Reason)
+		}
+	}
+	if p := t.Proof; p != nil {
+		line("Merkle proof: leaf %d, %s, %s", p.Index, p.Algorithm, check(p.Valid))
+		for _, s := range p.Path {
+			line("\t%-5s %s", s.Side, s.Hash)
+		}
+		line("\troot  %s", p.Root)
+	}
+}
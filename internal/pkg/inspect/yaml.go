@@ -0,0 +1,129 @@
+package inspect
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// value is a decoded JSON value which keeps the order of object keys, so
+// YAML lists fields in the order JSON does.
+type value struct {
+	keys   []string
+	values []value
+	object bool
+	array  bool
+	scalar string
+}
+
+func decode(decoder *json.Decoder) (value, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return value{}, err
+	}
+	switch t := token.(type) {
+	case json.Delim:
+		result := value{object: t == '{', array: t == '['}
+		for decoder.More() {
+			if result.object {
+				key, err := decoder.Token()
+				if err != nil {
+					return value{}, err
+				}
+				result.keys = append(result.keys, key.(string))
+			}
+			v, err := decode(decoder)
+			if err != nil {
+				return value{}, err
+			}
+			result.values = append(result.values, v)
+		}
+		if _, err := decoder.Token(); err != nil {
+			return value{}, err
+		}
+		return result, nil
+	case string:
+		return value{scalar: quote(t)}, nil
+	case json.Number:
+		return value{scalar: t.String()}, nil
+	case bool:
+		return value{scalar: strconv.FormatBool(t)}, nil
+	default:
+		return value{scalar: "null"}, nil
+	}
+}
+
+var plain = regexp.MustCompile(`^[A-Za-z_(][A-Za-z0-9_.()/+-]*$`)
+
+var reserved = map[string]bool{"true": true, "false": true, "null": true, "yes": true, "no": true, "on": true, "off": true, "y": true, "n": true}
+
+// quote leaves strings which YAML reads as strings unquoted, the rest are
+// quoted the way JSON quotes them.
+func quote(s string) string {
+	if plain.MatchString(s) && !reserved[strings.ToLower(s)] {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+func (v value) inline() (string, bool) {
+	switch {
+	case v.object && len(v.values) == 0:
+		return "{}", true
+	case v.array && len(v.values) == 0:
+		return "[]", true
+	case !v.object && !v.array:
+		return v.scalar, true
+	}
+	return "", false
+}
+
+// write writes the entries of an object or the items of an array, each on
+// its own line indented by indent. The first line of an array item nested in
+// an array continues the line of its parent.
+func (v value) write(b *bytes.Buffer, indent string, continued bool) {
+	for i, child := range v.values {
+		prefix := indent
+		if i == 0 && continued {
+			prefix = ""
+		}
+		b.WriteString(prefix)
+		if v.object {
+			b.WriteString(quote(v.keys[i]))
+			if s, ok := child.inline(); ok {
+				b.WriteString(": " + s + "\n")
+				continue
+			}
+			b.WriteString(":\n")
+			child.write(b, indent+"  ", false)
+			continue
+		}
+		b.WriteString("- ")
+		if s, ok := child.inline(); ok {
+			b.WriteString(s + "\n")
+			continue
+		}
+		child.write(b, indent+"  ", true)
+	}
+}
+
+// ToYAML converts the JSON document to YAML.
+func ToYAML(raw []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	v, err := decode(decoder)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to decode JSON")
+	}
+	b := &bytes.Buffer{}
+	if s, ok := v.inline(); ok {
+		b.WriteString(s + "\n")
+		return b.Bytes(), nil
+	}
+	v.write(b, "", false)
+	return b.Bytes(), nil
+}
@@ -0,0 +1,41 @@
+package intake
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
+)
+
+const callbackAttempts = 3
+
+var failedCallbacks = metrics.NewCounter("intake_callbacks_failed_total", "Number of confirmation callbacks which couldn't be delivered")
+
+var callbackClient = &http.Client{Timeout: 10 * time.Second}
+
+// notify posts the confirmed ticket to the callback URL given on submission,
+// retrying a few times before giving up. The ticket can still be polled.
+func notify(callback string, t Ticket) {
+	body, err := json.Marshal(t)
+	if err != nil {
+		log.Printf("Failed to marshal ticket %s %s", t.ID, err)
+		return
+	}
+	for attempt := 1; attempt <= callbackAttempts; attempt++ {
+		resp, err := callbackClient.Post(callback, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return
+			}
+			log.Printf("Callback of ticket %s responded with %d", t.ID, resp.StatusCode)
+		} else {
+			log.Printf("Failed to call back ticket %s %s", t.ID, err)
+		}
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+	failedCallbacks.Inc()
+}
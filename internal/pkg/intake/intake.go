@@ -0,0 +1,267 @@
+package intake
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/api"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
+)
+
+var (
+	depth     = metrics.NewGauge("intake_queue_depth", "Number of submitted votes waiting for an intake worker")
+	deferred  = metrics.NewCounter("intake_deferred_total", "Number of votes answered with a tracking id because they weren't processed in time")
+	saturated = metrics.NewCounter("intake_saturated_total", "Number of votes refused because the intake queue was full")
+	confirmed = metrics.NewCounter("intake_confirmed_total", "Number of tracked votes confirmed in a block")
+)
+
+// Tickets are forgotten retention after their last update.
+const retention = time.Hour
+
+type Status string
+
+const (
+	Queued    Status = "queued"
+	Processed Status = "processed"
+	Confirmed Status = "confirmed"
+)
+
+// Ticket tracks a vote submitted to the queue. Response is what the vote
+// endpoint would have answered, it is set once the vote is processed. A
+// cast vote is confirmed once its transaction is added to the blockchain.
+type Ticket struct {
+	ID          string      `json:"id"`
+	Status      Status      `json:"status"`
+	SubmittedAt int64       `json:"submittedAt"`
+	ProcessedAt int64       `json:"processedAt,omitempty"`
+	Code        int         `json:"code,omitempty"`
+	Response    interface{} `json:"response,omitempty"`
+	Transaction []byte      `json:"transaction,omitempty"`
+	ConfirmedAt int64       `json:"confirmedAt,omitempty"`
+	Height      int         `json:"height,omitempty"`
+	Block       []byte      `json:"block,omitempty"`
+	callback    string
+	updatedAt   time.Time
+}
+
+// Receipt is the body of a cast vote. Ticket lets the voter follow the vote
+// until it is confirmed.
+type Receipt struct {
+	Transaction []byte `json:"transaction"`
+	Ticket      string `json:"ticket,omitempty"`
+}
+
+type GetTicketFn func(id string) (Ticket, bool)
+
+type job struct {
+	ticket  string
+	request api.Request
+	handler api.Handler
+	done    chan api.Response
+}
+
+// Queue processes votes with a fixed number of workers. A vote processed
+// within the wait is answered as before, otherwise the client gets a ticket
+// to poll. When the buffer is full votes are refused right away.
+type Queue struct {
+	jobs    chan job
+	wait    time.Duration
+	lock    sync.RWMutex
+	tickets map[string]*Ticket
+	pending map[string]string
+}
+
+// NewQueue starts the workers of a queue buffering up to size votes.
+func NewQueue(workers, size int, wait time.Duration) *Queue {
+	q := &Queue{
+		jobs:    make(chan job, size),
+		wait:    wait,
+		tickets: map[string]*Ticket{},
+		pending: map[string]string{},
+	}
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	go q.expire()
+	return q
+}
+
+func newID() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+func (q *Queue) work() {
+	for j := range q.jobs {
+		depth.Set(float64(len(q.jobs)))
+		response, err := j.handler(j.request)
+		if err != nil {
+			log.Printf("Failed to process vote of ticket %s %s", j.ticket, err)
+			response = api.InternalServerErrorResponse()
+		}
+		receipt, cast := response.Body.(Receipt)
+		if cast {
+			receipt.Ticket = j.ticket
+			response.Body = receipt
+		}
+		q.lock.Lock()
+		if t, ok := q.tickets[j.ticket]; ok {
+			t.Status = Processed
+			t.ProcessedAt = time.Now().Unix()
+			t.Code = response.Status
+			t.Response = response.Body
+			t.updatedAt = time.Now()
+			if cast {
+				t.Transaction = receipt.Transaction
+				q.pending[string(receipt.Transaction)] = t.ID
+			}
+		}
+		q.lock.Unlock()
+		j.done <- response
+	}
+}
+
+func (q *Queue) expire() {
+	for range time.Tick(time.Minute) {
+		limit := time.Now().Add(-retention)
+		q.lock.Lock()
+		for id, t := range q.tickets {
+			if t.Status != Queued && t.updatedAt.Before(limit) {
+				delete(q.tickets, id)
+				delete(q.pending, string(t.Transaction))
+			}
+		}
+		q.lock.Unlock()
+	}
+}
+
+func validCallback(callback string) bool {
+	u, err := url.Parse(callback)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Handler runs h on one of the workers. The callback query parameter is a
+// URL the ticket is posted to once the vote is confirmed.
+func (q *Queue) Handler(h api.Handler) api.Handler {
+	return func(request api.Request) (api.Response, error) {
+		callback := request.Query.Get("callback")
+		if callback != "" && !validCallback(callback) {
+			return api.InvalidDataErrorResponse("Invalid callback URL provided"), nil
+		}
+		id, err := newID()
+		if err != nil {
+			return api.Response{}, err
+		}
+		j := job{
+			ticket:  id,
+			request: request,
+			handler: h,
+			done:    make(chan api.Response, 1),
+		}
+		ticket := &Ticket{
+			ID:          id,
+			Status:      Queued,
+			SubmittedAt: time.Now().Unix(),
+			callback:    callback,
+		}
+		q.lock.Lock()
+		q.tickets[id] = ticket
+		q.lock.Unlock()
+		select {
+		case q.jobs <- j:
+			depth.Set(float64(len(q.jobs)))
+		default:
+			q.lock.Lock()
+			delete(q.tickets, id)
+			q.lock.Unlock()
+			saturated.Inc()
+			return api.IntakeSaturated(), nil
+		}
+		select {
+		case response := <-j.done:
+			return response, nil
+		case <-time.After(q.wait):
+			deferred.Inc()
+			q.lock.RLock()
+			defer q.lock.RUnlock()
+			return api.Response{
+				Status: http.StatusAccepted,
+				Body:   *ticket,
+			}, nil
+		}
+	}
+}
+
+// Ticket returns the ticket of a submitted vote.
+func (q *Queue) Ticket(id string) (Ticket, bool) {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	t, ok := q.tickets[id]
+	if !ok {
+		return Ticket{}, false
+	}
+	return *t, true
+}
+
+// confirm marks tickets of the votes in the block as confirmed. The height
+// is only looked up when the block confirms a tracked vote.
+func (q *Queue) confirm(b blockchain.Block, getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	height := 0
+	for _, tx := range b.Body.Transactions {
+		id, ok := q.pending[string(tx.ID)]
+		if !ok {
+			continue
+		}
+		if height == 0 {
+			h, err := blockchain.GetHeight(getTip, getBlock)
+			if err != nil {
+				log.Printf("Failed to confirm votes of block %x %s", b.Header.Hash, err)
+				return
+			}
+			height = h
+		}
+		delete(q.pending, string(tx.ID))
+		t := q.tickets[id]
+		t.Status = Confirmed
+		t.ConfirmedAt = time.Now().Unix()
+		t.Height = height
+		t.Block = b.Header.Hash
+		t.updatedAt = time.Now()
+		confirmed.Inc()
+		if t.callback != "" {
+			go notify(t.callback, *t)
+		}
+	}
+}
+
+func (q *Queue) AddBlock(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, add blockchain.AddBlockFn) blockchain.AddBlockFn {
+	return func(b blockchain.Block) ([]byte, error) {
+		tip, err := add(b)
+		if err != nil {
+			return nil, err
+		}
+		q.confirm(b, getTip, getBlock)
+		return tip, nil
+	}
+}
+
+func (q *Queue) AddNewBlock(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, add blockchain.AddNewBlockFn) blockchain.AddNewBlockFn {
+	return func(b blockchain.Block) error {
+		if err := add(b); err != nil {
+			return err
+		}
+		q.confirm(b, getTip, getBlock)
+		return nil
+	}
+}
@@ -1,8 +1,11 @@
 package keyfiles
 
+// KeyFiles locate a key pair. Passphrase is asked for the passphrase only
+// if the private key is encrypted.
 type KeyFiles struct {
 	PrivateKeyFile string
 	PublicKeyFile  string
+	Passphrase     PassphraseFn
 }
 
 type KeyFilesList []KeyFiles
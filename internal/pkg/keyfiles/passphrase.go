@@ -0,0 +1,86 @@
+package keyfiles
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// PassphraseFn returns the passphrase of the encrypted private key file.
+type PassphraseFn func(privateKeyFile string) (string, error)
+
+// FromEnv reads the passphrase from the environment variable.
+func FromEnv(name string) PassphraseFn {
+	return func(string) (string, error) {
+		passphrase, ok := os.LookupEnv(name)
+		if !ok || passphrase == "" {
+			return "", errors.Errorf("Environment variable %s with the passphrase is not set", name)
+		}
+		return passphrase, nil
+	}
+}
+
+// Prompt asks for the passphrase on the terminal without echoing it.
+func Prompt() PassphraseFn {
+	return func(privateKeyFile string) (string, error) {
+		fmt.Fprintf(os.Stderr, "Passphrase of %s: ", privateKeyFile)
+		if err := stty("-echo"); err == nil {
+			defer stty("echo")
+		}
+		line, err := readLine(os.Stdin)
+		fmt.Fprintln(os.Stderr)
+		if err != nil && line == "" {
+			return "", errors.Wrap(err, "Failed to read passphrase")
+		}
+		return strings.TrimRight(line, "\r"), nil
+	}
+}
+
+// readLine reads byte by byte, so nothing after the line is consumed from
+// the input.
+func readLine(in io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := in.Read(b); err != nil {
+			return string(line), err
+		}
+		if b[0] == '\n' {
+			return string(line), nil
+		}
+		line = append(line, b[0])
+	}
+}
+
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+// Unlock reads the passphrase from the environment variable and prompts for
+// it if the variable is not set. The passphrase is asked for once and used
+// for every key file.
+func Unlock(name string) PassphraseFn {
+	lock := &sync.Mutex{}
+	var passphrase string
+	fromEnv, prompt := FromEnv(name), Prompt()
+	return func(privateKeyFile string) (string, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if passphrase != "" {
+			return passphrase, nil
+		}
+		var err error
+		if passphrase, err = fromEnv(privateKeyFile); err == nil {
+			return passphrase, nil
+		}
+		passphrase, err = prompt(privateKeyFile)
+		return passphrase, err
+	}
+}
@@ -0,0 +1,105 @@
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+var ErrWrongPassphrase = errors.New("Passphrase does not open the keystore")
+
+// Keystore holds a private key encrypted with AES-256-GCM under a key
+// derived from a passphrase with scrypt. The address is authenticated with
+// the key, so a keystore can't be passed off for another address.
+type Keystore struct {
+	Address    string `json:"address"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+const (
+	kdf     = "scrypt"
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+func (k Keystore) aead(passphrase string) (cipher.AEAD, error) {
+	if k.KDF != kdf {
+		return nil, errors.Errorf("Unknown key derivation function %s", k.KDF)
+	}
+	key, err := scrypt.Key([]byte(passphrase), k.Salt, k.N, k.R, k.P, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to derive key")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create cipher")
+	}
+	return cipher.NewGCM(block)
+}
+
+func Encrypt(w wallet.Wallet, passphrase string) (Keystore, error) {
+	k := Keystore{
+		Address: w.Address,
+		KDF:     kdf,
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+		Salt:    make([]byte, 16),
+	}
+	if _, err := rand.Read(k.Salt); err != nil {
+		return Keystore{}, errors.Wrap(err, "Failed to generate salt")
+	}
+	aead, err := k.aead(passphrase)
+	if err != nil {
+		return Keystore{}, err
+	}
+	k.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(k.Nonce); err != nil {
+		return Keystore{}, errors.Wrap(err, "Failed to generate nonce")
+	}
+	plain, err := x509.MarshalECPrivateKey(&w.PrivateKey)
+	if err != nil {
+		return Keystore{}, errors.Wrap(err, "Failed to encode private key")
+	}
+	k.Ciphertext = aead.Seal(nil, k.Nonce, plain, []byte(k.Address))
+	return k, nil
+}
+
+// Decrypt returns the wallet of the keystore, making sure the key belongs to
+// the address.
+func (k Keystore) Decrypt(passphrase string) (*wallet.Wallet, error) {
+	aead, err := k.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, k.Nonce, k.Ciphertext, []byte(k.Address))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	private, err := x509.ParseECPrivateKey(plain)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse private key")
+	}
+	private.Curve = elliptic.P256()
+	w, err := wallet.FromPrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	if w.Address != k.Address {
+		return nil, errors.Errorf("Keystore of %s holds the key of %s", k.Address, w.Address)
+	}
+	return w, nil
+}
@@ -0,0 +1,95 @@
+package kiosk
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidToken = errors.New("Invalid submission token")
+	ErrTokenExpired = errors.New("Submission token has expired")
+	ErrTokenBurned  = errors.New("Submission token has already been used")
+)
+
+// Token allows a single vote on behalf of the voter without the voter's
+// private key. Tokens are issued by election staff and burned on use.
+type Token struct {
+	ID        []byte `json:"id"`
+	VoterHash []byte `json:"voter"`
+	Expires   int64  `json:"expires"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+type signableToken struct {
+	ID        []byte `json:"id"`
+	VoterHash []byte `json:"voter"`
+	Expires   int64  `json:"expires"`
+}
+
+func (t Token) Signable() ([]byte, error) {
+	return json.Marshal(signableToken{
+		ID:        t.ID,
+		VoterHash: t.VoterHash,
+		Expires:   t.Expires,
+	})
+}
+
+func NewToken(voterHash []byte, expires time.Time, issuer wallet.Wallet) (*Token, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, errors.Wrap(err, "Failed to generate token id")
+	}
+	t := Token{
+		ID:        id,
+		VoterHash: voterHash,
+		Expires:   expires.Unix(),
+	}
+	signature, err := wallet.Sign(t, issuer.PrivateKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to sign token")
+	}
+	t.Signature = signature
+	return &t, nil
+}
+
+// Encode returns the token in a form that can be used as a URL query value.
+func (t Token) Encode() (string, error) {
+	raw, err := json.Marshal(t)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to serialize token %#v", t)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func Decode(encoded string) (*Token, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var t Token
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if len(t.ID) == 0 || len(t.VoterHash) == 0 {
+		return nil, ErrInvalidToken
+	}
+	return &t, nil
+}
+
+func (t Token) Verify(issuer []byte, now time.Time) error {
+	if !wallet.Verify(t, t.Signature, issuer) {
+		return ErrInvalidToken
+	}
+	if now.Unix() > t.Expires {
+		return ErrTokenExpired
+	}
+	return nil
+}
+
+type CastVoteFn func(token Token, to []byte) (transaction.Transaction, error)
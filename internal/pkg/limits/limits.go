@@ -0,0 +1,182 @@
+package limits
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+)
+
+type Resource string
+
+const (
+	Database Resource = "db"
+	Mempool  Resource = "mempool"
+)
+
+type Level string
+
+const (
+	OK       Level = "ok"
+	Warning  Level = "warning"
+	Exceeded Level = "exceeded"
+)
+
+const maxEvents = 100
+
+type Alarm struct {
+	Resource  Resource `json:"resource"`
+	Level     Level    `json:"level"`
+	Usage     int64    `json:"usage"`
+	Limit     int64    `json:"limit"`
+	Timestamp int64    `json:"timestamp"`
+}
+
+type Alarms []Alarm
+
+// UsageFn returns the current usage of a resource in bytes.
+type UsageFn func() (int64, error)
+
+// LessFn reports whether a has lower priority than b, so that a is shed
+// before b.
+type LessFn func(a, b transaction.Transaction) bool
+
+// ShedFn removes the lowest priority transactions from the mempool until it
+// fits into maxBytes and returns the removed transactions.
+type ShedFn func(maxBytes int64, less LessFn) (transaction.Transactions, error)
+
+type gauges struct {
+	usage *metrics.Gauge
+	limit *metrics.Gauge
+	alarm *metrics.Gauge
+}
+
+// Monitor keeps the alarm level of every resource. An alarm is raised when
+// the usage reaches warnRatio of the limit and every level change is kept
+// as an event.
+type Monitor struct {
+	lock      *sync.Mutex
+	warnRatio float64
+	alarms    map[Resource]Alarm
+	events    Alarms
+	gauges    map[Resource]gauges
+}
+
+func NewMonitor(warnRatio float64) *Monitor {
+	return &Monitor{
+		lock:      &sync.Mutex{},
+		warnRatio: warnRatio,
+		alarms:    make(map[Resource]Alarm),
+		gauges:    make(map[Resource]gauges),
+	}
+}
+
+func (m *Monitor) gaugesOf(resource Resource) gauges {
+	g, ok := m.gauges[resource]
+	if !ok {
+		g = gauges{
+			usage: metrics.NewGauge(fmt.Sprintf("node_%s_bytes", resource), fmt.Sprintf("Current %s usage in bytes", resource)),
+			limit: metrics.NewGauge(fmt.Sprintf("node_%s_limit_bytes", resource), fmt.Sprintf("Configured %s limit in bytes", resource)),
+			alarm: metrics.NewGauge(fmt.Sprintf("node_%s_alarm", resource), fmt.Sprintf("Alarm level of %s usage (0 ok, 1 warning, 2 exceeded)", resource)),
+		}
+		m.gauges[resource] = g
+	}
+	return g
+}
+
+func (m *Monitor) level(usage, limit int64) Level {
+	switch {
+	case limit <= 0:
+		return OK
+	case usage >= limit:
+		return Exceeded
+	case float64(usage) >= m.warnRatio*float64(limit):
+		return Warning
+	default:
+		return OK
+	}
+}
+
+func levelValue(l Level) float64 {
+	switch l {
+	case Warning:
+		return 1
+	case Exceeded:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// Check updates the alarm of the resource and returns its level.
+func (m *Monitor) Check(resource Resource, usage, limit int64) Level {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	level := m.level(usage, limit)
+	g := m.gaugesOf(resource)
+	g.usage.Set(float64(usage))
+	g.limit.Set(float64(limit))
+	g.alarm.Set(levelValue(level))
+	previous, ok := m.alarms[resource]
+	if ok && previous.Level == level || !ok && level == OK {
+		return level
+	}
+	alarm := Alarm{
+		Resource:  resource,
+		Level:     level,
+		Usage:     usage,
+		Limit:     limit,
+		Timestamp: time.Now().Unix(),
+	}
+	m.alarms[resource] = alarm
+	m.events = append(m.events, alarm)
+	if len(m.events) > maxEvents {
+		m.events = m.events[len(m.events)-maxEvents:]
+	}
+	log.Printf("Resource %s usage %d of %d bytes, alarm level %s", resource, usage, limit, level)
+	return level
+}
+
+// Alarms returns the currently raised alarms.
+func (m *Monitor) Alarms() Alarms {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	result := Alarms{}
+	for _, alarm := range m.alarms {
+		if alarm.Level != OK {
+			result = append(result, alarm)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Resource < result[j].Resource
+	})
+	return result
+}
+
+func (m *Monitor) Events() Alarms {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append(Alarms{}, m.events...)
+}
+
+func (m *Monitor) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		alarms := m.Alarms()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Alarm  bool   `json:"alarm"`
+			Alarms Alarms `json:"alarms"`
+			Events Alarms `json:"events"`
+		}{
+			Alarm:  len(alarms) > 0,
+			Alarms: alarms,
+			Events: m.Events(),
+		})
+	})
+}
@@ -0,0 +1,361 @@
+package mempool
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrDuplicate   = errors.New("Transaction is already pending")
+	ErrDoubleSpend = errors.New("Transaction spends an output a pending transaction already spends")
+	ErrFull        = errors.New("Mempool is full")
+)
+
+var (
+	pending   = metrics.NewGauge("mempool_transactions", "Number of pending transactions known to the mempool")
+	rejected  = metrics.NewCounter("mempool_rejected_total", "Number of transactions refused because they are already pending or spend an output a pending transaction spends")
+	evicted   = metrics.NewCounter("mempool_evicted_total", "Number of pending transactions evicted to make room for higher priority ones")
+	expired   = metrics.NewCounter("mempool_expired_total", "Number of pending transactions dropped because they waited longer than the TTL")
+	discarded = metrics.NewCounter("mempool_discarded_total", "Number of pending transactions discarded on start because they can't be forged anymore")
+)
+
+// reservationGrace is how long a reserved transaction is kept although it
+// isn't stored, since the database transaction that stores it may not have
+// committed yet.
+const reservationGrace = 10 * time.Second
+
+// Options limit the pending transactions. Transactions older than TTL are
+// dropped and at most MaxCount transactions are kept, 0 lifts the limit.
+type Options struct {
+	TTL      time.Duration
+	MaxCount int
+}
+
+// EssentialFn tells the transactions the protocol depends on, such as
+// returned stakes and certifications. They never expire and are never
+// evicted.
+type EssentialFn func(transaction.Transaction) bool
+
+type entry struct {
+	transaction transaction.Transaction
+	added       time.Time
+}
+
+// Pool indexes pending transactions by id and by the outputs they spend,
+// so a transaction already pending or one spending an output a pending
+// transaction spends is refused before it is stored. The transactions
+// themselves stay in the database, where forging reads them.
+type Pool struct {
+	lock      *sync.Mutex
+	options   Options
+	essential EssentialFn
+	entries   map[string]entry
+	spends    map[string]string
+}
+
+func Load(getTransactions transaction.GetTransactionsFn, options Options, essential EssentialFn) (*Pool, error) {
+	p := &Pool{
+		lock:      &sync.Mutex{},
+		options:   options,
+		essential: essential,
+		entries:   make(map[string]entry),
+		spends:    make(map[string]string),
+	}
+	txs, err := getTransactions()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to retrieve pending transactions")
+	}
+	now := time.Now()
+	for _, t := range txs {
+		if p.check(t) == nil {
+			p.add(t, now)
+		}
+	}
+	pending.Set(float64(len(p.entries)))
+	return p, nil
+}
+
+// Restoration counts the pending transactions stored before a restart which
+// were kept and the ones discarded because they can't be forged anymore.
+type Restoration struct {
+	Restored  int
+	Discarded int
+}
+
+// ClosedFn tells whether the election takes no more votes, so pending votes
+// would never be forged. A nil ClosedFn never closes.
+type ClosedFn func() (bool, error)
+
+// Restore revalidates the pending transactions stored before a restart
+// against the current state, before they are loaded. Transactions which
+// don't verify, e.g. since an input got spent by a forged block, and votes
+// of a closed election are removed. Essential transactions are only removed
+// if they don't verify.
+func Restore(getTransactions transaction.GetTransactionsFn, verify transaction.VerifyTransctionFn, closed ClosedFn, essential EssentialFn, remove transaction.DeleteTransaction) (Restoration, error) {
+	var r Restoration
+	txs, err := getTransactions()
+	if err != nil {
+		return r, errors.Wrap(err, "Failed to retrieve pending transactions")
+	}
+	isClosed := false
+	if closed != nil {
+		if isClosed, err = closed(); err != nil {
+			return r, errors.Wrap(err, "Failed to check whether the election is closed")
+		}
+	}
+	for _, t := range txs {
+		if verify(t) && (!isClosed || essential(t)) {
+			r.Restored++
+			continue
+		}
+		if err := remove(t); err != nil {
+			return r, errors.Wrapf(err, "Failed to discard pending transaction %x", t.ID)
+		}
+		r.Discarded++
+		discarded.Inc()
+	}
+	return r, nil
+}
+
+func outpoint(in transaction.Input) string {
+	return fmt.Sprintf("%x/%d", in.TransactionID, in.Vout)
+}
+
+func (p *Pool) check(t transaction.Transaction) error {
+	if _, ok := p.entries[string(t.ID)]; ok {
+		return errors.Wrapf(ErrDuplicate, "Transaction %x", t.ID)
+	}
+	for _, in := range t.Inputs {
+		if in.Vout < 0 {
+			continue
+		}
+		if spender, ok := p.spends[outpoint(in)]; ok {
+			return errors.Wrapf(ErrDoubleSpend, "Transaction %x spends %x %d which %x already spends", t.ID, in.TransactionID, in.Vout, spender)
+		}
+	}
+	return nil
+}
+
+func (p *Pool) add(t transaction.Transaction, at time.Time) {
+	p.entries[string(t.ID)] = entry{transaction: t, added: at}
+	for _, in := range t.Inputs {
+		if in.Vout >= 0 {
+			p.spends[outpoint(in)] = string(t.ID)
+		}
+	}
+}
+
+func (p *Pool) forget(t transaction.Transaction) {
+	delete(p.entries, string(t.ID))
+	for _, in := range t.Inputs {
+		if key := outpoint(in); p.spends[key] == string(t.ID) {
+			delete(p.spends, key)
+		}
+	}
+}
+
+func (p *Pool) full() bool {
+	return p.options.MaxCount > 0 && len(p.entries) >= p.options.MaxCount
+}
+
+// victim returns the pending transaction evicted in favour of the one given,
+// the most recent vote and the largest among votes received at the same
+// time. There is none if the given transaction has a lower priority than
+// every pending one.
+func (p *Pool) victim(t transaction.Transaction) (transaction.Transaction, bool) {
+	var result transaction.Transaction
+	found := false
+	for _, e := range p.entries {
+		c := e.transaction
+		if p.essential(c) {
+			continue
+		}
+		if !found || c.Timestamp > result.Timestamp || c.Timestamp == result.Timestamp && c.Size() > result.Size() {
+			result, found = c, true
+		}
+	}
+	if !found || !p.essential(t) && (t.Timestamp > result.Timestamp || t.Timestamp == result.Timestamp && t.Size() >= result.Size()) {
+		return transaction.Transaction{}, false
+	}
+	return result, true
+}
+
+func (p *Pool) admit(t transaction.Transaction) error {
+	if err := p.check(t); err != nil {
+		rejected.Inc()
+		return err
+	}
+	p.add(t, time.Now())
+	pending.Set(float64(len(p.entries)))
+	return nil
+}
+
+// Reserve admits the transaction to the pool while it is validated, so it
+// has to be the last of the validators a transaction is cast with, and a
+// transaction which isn't stored in the end has to be released with
+// Release. Votes are the most recent transactions and never evict others,
+// so they are refused once the pool is full.
+func (p *Pool) Reserve() transaction.ValidateFn {
+	return func(t transaction.Transaction) error {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		if p.full() && !p.essential(t) {
+			return errors.Wrapf(ErrFull, "Mempool holds %d transactions", len(p.entries))
+		}
+		return p.admit(t)
+	}
+}
+
+// Release drops a reserved transaction whose cast failed, so failed casts
+// don't take up room in the pool until the next sweep.
+func (p *Pool) Release(t transaction.Transaction) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if e, ok := p.entries[string(t.ID)]; ok {
+		p.forget(e.transaction)
+		pending.Set(float64(len(p.entries)))
+	}
+}
+
+// Save stores transactions received from peers, evicting the lowest
+// priority pending transaction if the pool is full.
+func (p *Pool) Save(save transaction.SaveTransaction, remove transaction.DeleteTransaction) transaction.SaveTransaction {
+	return func(t transaction.Transaction) error {
+		p.lock.Lock()
+		if err := p.check(t); err != nil {
+			p.lock.Unlock()
+			rejected.Inc()
+			return err
+		}
+		var victim *transaction.Transaction
+		if p.full() {
+			v, ok := p.victim(t)
+			if !ok {
+				p.lock.Unlock()
+				return errors.Wrapf(ErrFull, "Mempool holds %d transactions", len(p.entries))
+			}
+			p.forget(v)
+			victim = &v
+		}
+		p.add(t, time.Now())
+		pending.Set(float64(len(p.entries)))
+		p.lock.Unlock()
+		if victim != nil {
+			if err := remove(*victim); err != nil {
+				return errors.Wrapf(err, "Failed to evict transaction %x", victim.ID)
+			}
+			evicted.Inc()
+			log.Printf("Mempool is full, transaction %x evicted for %x", victim.ID, t.ID)
+		}
+		if err := save(t); err != nil {
+			p.lock.Lock()
+			p.forget(t)
+			p.lock.Unlock()
+			return err
+		}
+		return nil
+	}
+}
+
+func (p *Pool) include(b blockchain.Block) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, t := range b.Body.Transactions {
+		if _, ok := p.entries[string(t.ID)]; ok {
+			p.forget(t)
+			continue
+		}
+		// Pending transactions spending what the block spends can't
+		// be forged anymore.
+		for _, in := range t.Inputs {
+			if spender, ok := p.spends[outpoint(in)]; ok {
+				p.forget(p.entries[spender].transaction)
+			}
+		}
+	}
+	pending.Set(float64(len(p.entries)))
+}
+
+func (p *Pool) AddBlock(add blockchain.AddBlockFn) blockchain.AddBlockFn {
+	return func(b blockchain.Block) ([]byte, error) {
+		tip, err := add(b)
+		if err != nil {
+			return nil, err
+		}
+		p.include(b)
+		return tip, nil
+	}
+}
+
+func (p *Pool) AddNewBlock(add blockchain.AddNewBlockFn) blockchain.AddNewBlockFn {
+	return func(b blockchain.Block) error {
+		if err := add(b); err != nil {
+			return err
+		}
+		p.include(b)
+		return nil
+	}
+}
+
+// Sweep removes the transactions which waited longer than the TTL and
+// indexes the stored transactions again, picking up transactions returned
+// by rolled back blocks and forgetting the ones shed or forged elsewhere.
+func (p *Pool) Sweep(getTransactions transaction.GetTransactionsFn, remove transaction.DeleteTransaction) func() error {
+	return func() error {
+		started := time.Now()
+		txs, err := getTransactions()
+		if err != nil {
+			return errors.Wrap(err, "Failed to retrieve pending transactions")
+		}
+		stale := transaction.Transactions{}
+		if p.options.TTL > 0 {
+			kept := transaction.Transactions{}
+			for _, t := range txs {
+				if !p.essential(t) && started.Sub(time.Unix(t.Timestamp, 0)) > p.options.TTL {
+					stale = append(stale, t)
+					continue
+				}
+				kept = append(kept, t)
+			}
+			txs = kept
+		}
+		for _, t := range stale {
+			if err := remove(t); err != nil {
+				return errors.Wrapf(err, "Failed to remove expired transaction %x", t.ID)
+			}
+			expired.Inc()
+		}
+		if len(stale) > 0 {
+			log.Printf("Removed %d pending transactions older than %s", len(stale), p.options.TTL)
+		}
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		previous := p.entries
+		p.entries = make(map[string]entry)
+		p.spends = make(map[string]string)
+		for _, t := range txs {
+			if p.check(t) != nil {
+				continue
+			}
+			added := started
+			if e, ok := previous[string(t.ID)]; ok {
+				added = e.added
+			}
+			p.add(t, added)
+		}
+		for _, e := range previous {
+			if started.Sub(e.added) < reservationGrace && p.check(e.transaction) == nil {
+				p.add(e.transaction, e.added)
+			}
+		}
+		pending.Set(float64(len(p.entries)))
+		return nil
+	}
+}
@@ -0,0 +1,36 @@
+package mempool
+
+import (
+	"testing"
+
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+func vote(id string) transaction.Transaction {
+	return transaction.Transaction{
+		ID:     []byte(id),
+		Inputs: transaction.Inputs{{TransactionID: []byte("funding-" + id)}},
+	}
+}
+
+func TestReleaseGivesBackRoomOfFailedCasts(t *testing.T) {
+	pool, err := Load(func() (transaction.Transactions, error) { return nil, nil }, Options{MaxCount: 1}, func(transaction.Transaction) bool { return false })
+	if err != nil {
+		t.Fatalf("Failed to load mempool %s", err)
+	}
+	reserve := pool.Reserve()
+	if err := reserve(vote("a")); err != nil {
+		t.Fatalf("Failed to reserve vote %s", err)
+	}
+	if err := reserve(vote("b")); !errors.Is(err, ErrFull) {
+		t.Fatalf("Expected the full mempool to refuse the vote, got %v", err)
+	}
+	pool.Release(vote("a"))
+	if err := reserve(vote("b")); err != nil {
+		t.Errorf("Expected the vote to take the released room, got %s", err)
+	}
+	if err := reserve(vote("a")); !errors.Is(err, ErrFull) {
+		t.Errorf("Expected the reserved vote to keep its room, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package merkle
+
+import (
+	"bytes"
+
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+)
+
+// Leaves and inner nodes are hashed with different prefixes, so an inner
+// node can never be passed off as a leaf.
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// Step is a sibling on the way from a leaf to the root. Left tells whether
+// the sibling is hashed in front of the running hash.
+type Step struct {
+	Hash []byte `json:"hash"`
+	Left bool   `json:"left"`
+}
+
+type Path []Step
+
+func hashLeaf(algorithm digest.Algorithm, leaf []byte) []byte {
+	return algorithm.Sum(append([]byte{leafPrefix}, leaf...))
+}
+
+func hashNode(algorithm digest.Algorithm, left, right []byte) []byte {
+	data := make([]byte, 0, 1+len(left)+len(right))
+	data = append(data, nodePrefix)
+	data = append(data, left...)
+	data = append(data, right...)
+	return algorithm.Sum(data)
+}
+
+// level hashes pairs of nodes, a node left without a pair is carried to the
+// next level as it is.
+func level(algorithm digest.Algorithm, nodes [][]byte) [][]byte {
+	result := make([][]byte, 0, (len(nodes)+1)/2)
+	for i := 0; i < len(nodes); i += 2 {
+		if i+1 == len(nodes) {
+			result = append(result, nodes[i])
+			continue
+		}
+		result = append(result, hashNode(algorithm, nodes[i], nodes[i+1]))
+	}
+	return result
+}
+
+func leaves(algorithm digest.Algorithm, data [][]byte) [][]byte {
+	result := make([][]byte, len(data))
+	for i, leaf := range data {
+		result[i] = hashLeaf(algorithm, leaf)
+	}
+	return result
+}
+
+// Root returns the root of the tree over the leaves hashed with the
+// algorithm, the hash of nothing if there are none.
+func Root(algorithm digest.Algorithm, data [][]byte) []byte {
+	if len(data) == 0 {
+		return algorithm.Sum(nil)
+	}
+	nodes := leaves(algorithm, data)
+	for len(nodes) > 1 {
+		nodes = level(algorithm, nodes)
+	}
+	return nodes[0]
+}
+
+// Prove returns the path from the leaf at the index to the root, false if
+// there is no such leaf.
+func Prove(algorithm digest.Algorithm, data [][]byte, index int) (Path, bool) {
+	if index < 0 || index >= len(data) {
+		return nil, false
+	}
+	var result Path
+	nodes := leaves(algorithm, data)
+	for len(nodes) > 1 {
+		sibling := index ^ 1
+		if sibling < len(nodes) {
+			result = append(result, Step{Hash: nodes[sibling], Left: sibling < index})
+		}
+		nodes = level(algorithm, nodes)
+		index /= 2
+	}
+	return result, true
+}
+
+// Verify checks that the path leads from the leaf to the root.
+func Verify(algorithm digest.Algorithm, leaf []byte, path Path, root []byte) bool {
+	current := hashLeaf(algorithm, leaf)
+	for _, step := range path {
+		if step.Left {
+			current = hashNode(algorithm, step.Hash, current)
+		} else {
+			current = hashNode(algorithm, current, step.Hash)
+		}
+	}
+	return bytes.Equal(current, root)
+}
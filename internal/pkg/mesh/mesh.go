@@ -0,0 +1,149 @@
+package mesh
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+// AlfaID is the id nodes know the alfa node by.
+const AlfaID = "0"
+
+const (
+	// maxAge is how long a node is kept after it was last seen.
+	maxAge = 5 * time.Minute
+	// maxLag is how many blocks a node may be behind the highest known
+	// node before it is reported as lagging.
+	maxLag = 2
+)
+
+// Node is what is known about a node of the network: where it listens, the
+// height of its blockchain and the nodes it is connected to, as of SeenAt.
+type Node struct {
+	ID      string   `json:"id"`
+	Address string   `json:"address,omitempty"`
+	Height  int      `json:"height"`
+	Peers   []string `json:"peers"`
+	SeenAt  int64    `json:"seenAt"`
+}
+
+// ExchangeBody carries the nodes the sender knows, the sender included.
+type ExchangeBody struct {
+	Nodes []Node `json:"nodes"`
+}
+
+type HeightFn func() (int, error)
+
+// Book keeps the nodes learned from peer exchanges. An entry of a node is
+// replaced only by a more recent one and a node describes itself only over
+// its own connection, so peers can't pass off stale or forged entries of the
+// node they are talking to.
+type Book struct {
+	lock  *sync.Mutex
+	self  Node
+	nodes map[string]Node
+}
+
+func NewBook(id, address string) *Book {
+	return &Book{
+		lock:  &sync.Mutex{},
+		self:  Node{ID: id, Address: address, Peers: []string{}},
+		nodes: make(map[string]Node),
+	}
+}
+
+func (b *Book) prune(now time.Time) {
+	oldest := now.Add(-maxAge).Unix()
+	for id, n := range b.nodes {
+		if n.SeenAt < oldest {
+			delete(b.nodes, id)
+		}
+	}
+}
+
+// Nodes returns the known nodes ordered by id, the book's own node included.
+func (b *Book) Nodes() []Node {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.prune(time.Now())
+	result := []Node{b.self}
+	for _, n := range b.nodes {
+		result = append(result, n)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// Exchange refreshes the book's own node and sends the known nodes to all
+// connected peers.
+func (b *Book) Exchange(getHeight HeightFn, registeredNodes websocket.RegisteredNodesFn, broadcast websocket.BroadcastFn) func() error {
+	return func() error {
+		height, err := getHeight()
+		if err != nil {
+			return errors.Wrap(err, "Failed to get blockchain height")
+		}
+		peers := registeredNodes()
+		sort.Strings(peers)
+		b.lock.Lock()
+		b.self.Height = height
+		b.self.Peers = peers
+		b.self.SeenAt = time.Now().Unix()
+		b.lock.Unlock()
+		broadcast(websocket.Pong{
+			Message: websocket.PeerExchangeMessage,
+			Body:    ExchangeBody{Nodes: b.Nodes()},
+		})
+		return nil
+	}
+}
+
+// Handler merges the nodes sent by a registered peer. Entries of nodes
+// connected directly are taken only from the nodes themselves and entries
+// dated in the future are ignored, they would never be replaced.
+func (b *Book) Handler(nodeID websocket.NodeIDFn, registeredNodes websocket.RegisteredNodesFn) websocket.Handler {
+	return func(ping websocket.Ping, internalID string) (*websocket.Pong, error) {
+		sender, ok := nodeID(internalID)
+		if !ok {
+			log.Printf("Ignoring peer exchange of unregistered connection %s", internalID)
+			return websocket.NewNoActionPong(), nil
+		}
+		var body ExchangeBody
+		if err := json.Unmarshal(ping.Body, &body); err != nil {
+			return nil, errors.Wrapf(err, "Failed to unmarshal peer exchange body %s", ping.Body)
+		}
+		direct := map[string]bool{}
+		for _, id := range registeredNodes() {
+			direct[id] = true
+		}
+		now := time.Now()
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		for _, n := range body.Nodes {
+			switch {
+			case n.ID == "", n.ID == b.self.ID:
+				continue
+			case n.SeenAt > now.Add(time.Minute).Unix():
+				log.Printf("Ignoring entry of node %s from node %s dated in the future", n.ID, sender)
+				continue
+			case n.ID != sender && direct[n.ID]:
+				continue
+			}
+			if known, ok := b.nodes[n.ID]; ok && known.SeenAt >= n.SeenAt {
+				continue
+			}
+			if n.Peers == nil {
+				n.Peers = []string{}
+			}
+			b.nodes[n.ID] = n
+		}
+		b.prune(now)
+		return websocket.NewNoActionPong(), nil
+	}
+}
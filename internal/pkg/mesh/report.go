@@ -0,0 +1,84 @@
+package mesh
+
+import (
+	"sort"
+	"strconv"
+)
+
+// Report describes the health of the mesh as seen by a node. Heights counts
+// the nodes at each blockchain height, Lagging are the nodes more than
+// maxLag blocks behind the highest one. Components are the groups of nodes
+// which stay connected without the alfa node, a single one means the nodes
+// could keep exchanging blocks if alfa went away, Isolated are the nodes
+// with no peer but alfa.
+type Report struct {
+	Self       string         `json:"self"`
+	Nodes      []Node         `json:"nodes"`
+	Heights    map[string]int `json:"heights"`
+	MaxHeight  int            `json:"maxHeight"`
+	Lagging    []string       `json:"lagging"`
+	Components [][]string     `json:"components"`
+	Isolated   []string       `json:"isolated"`
+}
+
+func (b *Book) Report() Report {
+	nodes := b.Nodes()
+	result := Report{
+		Self:       b.self.ID,
+		Nodes:      nodes,
+		Heights:    map[string]int{},
+		Lagging:    []string{},
+		Components: [][]string{},
+		Isolated:   []string{},
+	}
+	for _, n := range nodes {
+		result.Heights[strconv.Itoa(n.Height)]++
+		if n.Height > result.MaxHeight {
+			result.MaxHeight = n.Height
+		}
+	}
+	links := map[string][]string{}
+	for _, n := range nodes {
+		if n.Height < result.MaxHeight-maxLag {
+			result.Lagging = append(result.Lagging, n.ID)
+		}
+		if n.ID == AlfaID {
+			continue
+		}
+		// Connections are reported by either end, the entry of the other
+		// one may be missing or stale.
+		for _, p := range n.Peers {
+			if p == AlfaID || p == n.ID {
+				continue
+			}
+			links[n.ID] = append(links[n.ID], p)
+			links[p] = append(links[p], n.ID)
+		}
+	}
+	visited := map[string]bool{}
+	for _, n := range nodes {
+		if n.ID == AlfaID || visited[n.ID] {
+			continue
+		}
+		component := []string{}
+		stack := []string{n.ID}
+		visited[n.ID] = true
+		for len(stack) > 0 {
+			current := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			component = append(component, current)
+			for _, p := range links[current] {
+				if !visited[p] {
+					visited[p] = true
+					stack = append(stack, p)
+				}
+			}
+		}
+		sort.Strings(component)
+		if len(component) == 1 {
+			result.Isolated = append(result.Isolated, component[0])
+		}
+		result.Components = append(result.Components, component)
+	}
+	return result
+}
@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+type metric interface {
+	Name() string
+	write(io.Writer)
+}
+
+type Counter struct {
+	name  string
+	help  string
+	value uint64
+}
+
+func (c *Counter) Name() string {
+	return c.name
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+func (c *Counter) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.Value())
+}
+
+type Gauge struct {
+	name string
+	help string
+	bits uint64
+}
+
+func (g *Gauge) Name() string {
+	return g.name
+}
+
+func (g *Gauge) Set(value float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(value))
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+func (g *Gauge) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", g.name, g.help, g.name, g.name, g.Value())
+}
+
+type Registry struct {
+	lock         *sync.Mutex
+	metrics      map[string]metric
+	pullDisabled int32
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		lock:    &sync.Mutex{},
+		metrics: make(map[string]metric),
+	}
+}
+
+var Default = NewRegistry()
+
+func (r *Registry) register(m metric) metric {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if existing, ok := r.metrics[m.Name()]; ok {
+		return existing
+	}
+	r.metrics[m.Name()] = m
+	return m
+}
+
+func (r *Registry) NewCounter(name, help string) *Counter {
+	if c, ok := r.register(&Counter{name: name, help: help}).(*Counter); ok {
+		return c
+	}
+	panic(fmt.Sprintf("Metric %s is already registered with a different type", name))
+}
+
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	if g, ok := r.register(&Gauge{name: name, help: help}).(*Gauge); ok {
+		return g
+	}
+	panic(fmt.Sprintf("Metric %s is already registered with a different type", name))
+}
+
+func (r *Registry) sorted() []metric {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, r.metrics[name])
+	}
+	return metrics
+}
+
+func (r *Registry) Write(w io.Writer) {
+	for _, m := range r.sorted() {
+		m.write(w)
+	}
+}
+
+// DisablePull makes the handler answer 404, for deployments which forbid
+// scraping and push the metrics instead.
+func (r *Registry) DisablePull() {
+	atomic.StoreInt32(&r.pullDisabled, 1)
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		if atomic.LoadInt32(&r.pullDisabled) == 1 {
+			http.NotFound(w, request)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.Write(w)
+	})
+}
+
+func NewCounter(name, help string) *Counter {
+	return Default.NewCounter(name, help)
+}
+
+func NewGauge(name, help string) *Gauge {
+	return Default.NewGauge(name, help)
+}
+
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+func DisablePull() {
+	Default.DisablePull()
+}
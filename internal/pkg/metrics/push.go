@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PushFn sends the metrics of the registry somewhere, for deployments where
+// nothing may connect to the nodes to scrape them.
+type PushFn func(r *Registry) error
+
+// PushGateway replaces the metrics of the job and the instance on a
+// Prometheus Pushgateway.
+func PushGateway(gateway, job, instance string) PushFn {
+	client := &http.Client{Timeout: 10 * time.Second}
+	target := fmt.Sprintf("%s/metrics/job/%s", strings.TrimSuffix(gateway, "/"), url.PathEscape(job))
+	if instance != "" {
+		target += "/instance/" + url.PathEscape(instance)
+	}
+	return func(r *Registry) error {
+		body := &bytes.Buffer{}
+		r.Write(body)
+		request, err := http.NewRequest(http.MethodPut, target, body)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to create request to %s", target)
+		}
+		request.Header.Set("Content-Type", "text/plain; version=0.0.4")
+		resp, err := client.Do(request)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to push metrics to %s", target)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+			return errors.Errorf("Pushgateway %s responded with status %d", target, resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// statsdPacket keeps datagrams below the usual MTU.
+const statsdPacket = 1400
+
+// StatsD sends gauges as they are and counters as the increase since the
+// previous push, over UDP.
+func StatsD(address, prefix string) PushFn {
+	last := map[string]uint64{}
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return func(r *Registry) error {
+		conn, err := net.Dial("udp", address)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to reach statsd %s", address)
+		}
+		defer conn.Close()
+		var lines []string
+		for _, m := range r.sorted() {
+			switch metric := m.(type) {
+			case *Counter:
+				value := metric.Value()
+				lines = append(lines, fmt.Sprintf("%s%s:%d|c", prefix, metric.name, value-last[metric.name]))
+				last[metric.name] = value
+			case *Gauge:
+				lines = append(lines, fmt.Sprintf("%s%s:%g|g", prefix, metric.name, metric.Value()))
+			}
+		}
+		packet := &bytes.Buffer{}
+		for i, line := range lines {
+			if packet.Len() > 0 {
+				packet.WriteByte('\n')
+			}
+			packet.WriteString(line)
+			if i+1 < len(lines) && packet.Len()+1+len(lines[i+1]) <= statsdPacket {
+				continue
+			}
+			if _, err := conn.Write(packet.Bytes()); err != nil {
+				return errors.Wrapf(err, "Failed to send metrics to statsd %s", address)
+			}
+			packet.Reset()
+		}
+		return nil
+	}
+}
+
+// Dump writes the metrics in the Prometheus text format to a file, which is
+// replaced atomically so it can be collected or carried out of an offline
+// room at any time.
+func Dump(path string) PushFn {
+	return func(r *Registry) error {
+		body := &bytes.Buffer{}
+		fmt.Fprintf(body, "# Dumped at %s\n", time.Now().UTC().Format(time.RFC3339))
+		r.Write(body)
+		tmp := path + ".tmp"
+		if err := ioutil.WriteFile(tmp, body.Bytes(), 0644); err != nil {
+			return errors.Wrapf(err, "Failed to write %s", tmp)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return errors.Wrapf(err, "Failed to replace %s", path)
+		}
+		return nil
+	}
+}
+
+// Push pushes the metrics every interval, failures are logged and the next
+// push is attempted anyway.
+func (r *Registry) Push(interval time.Duration, pushers ...PushFn) {
+	if len(pushers) == 0 {
+		return
+	}
+	for range time.Tick(interval) {
+		for _, push := range pushers {
+			if err := push(r); err != nil {
+				log.Printf("Failed to push metrics %s", err)
+			}
+		}
+	}
+}
+
+func Push(interval time.Duration, pushers ...PushFn) {
+	Default.Push(interval, pushers...)
+}
+
+// Pushers returns a pusher for every target given, a Pushgateway URL, a
+// statsd address and a dump file. Metrics are grouped under the job and the
+// instance, which also prefix the statsd names.
+func Pushers(gateway, job, instance, statsd, dumpFile string) []PushFn {
+	var result []PushFn
+	if gateway != "" {
+		result = append(result, PushGateway(gateway, job, instance))
+	}
+	if statsd != "" {
+		prefix := job
+		if instance != "" {
+			prefix += "." + instance
+		}
+		result = append(result, StatsD(statsd, prefix))
+	}
+	if dumpFile != "" {
+		result = append(result, Dump(dumpFile))
+	}
+	return result
+}
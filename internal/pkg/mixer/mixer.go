@@ -0,0 +1,122 @@
+package mixer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+)
+
+type IsBatchReadyFn func(transaction.Transactions) bool
+
+func IsBatchReady(size int, maxDelay time.Duration) IsBatchReadyFn {
+	return func(txs transaction.Transactions) bool {
+		if len(txs) == 0 {
+			return false
+		}
+		if len(txs) >= size {
+			return true
+		}
+		oldest := txs[0].Timestamp
+		for _, tx := range txs {
+			if tx.Timestamp < oldest {
+				oldest = tx.Timestamp
+			}
+		}
+		return maxDelay > 0 && time.Since(time.Unix(oldest, 0)) >= maxDelay
+	}
+}
+
+// Seed is derived from the previous block and the ids of the transactions,
+// there is nothing secret in it.
+func Seed(prev []byte, txs transaction.Transactions) []byte {
+	hashable := [][]byte{prev}
+	for _, tx := range sortedByID(txs) {
+		hashable = append(hashable, tx.ID)
+	}
+	hash := sha256.Sum256(bytes.Join(hashable, []byte{}))
+	return hash[:]
+}
+
+func sortedByID(txs transaction.Transactions) transaction.Transactions {
+	result := make(transaction.Transactions, len(txs))
+	copy(result, txs)
+	sort.Slice(result, func(i, j int) bool {
+		return bytes.Compare(result[i].ID, result[j].ID) < 0
+	})
+	return result
+}
+
+// Shuffle puts the transactions in the canonical order of the block extending
+// prev. The order depends only on prev and the set of transactions, so it
+// doesn't tell in which order the votes arrived, but anyone can recompute
+// it. It isn't a verifiable mix, a vote stays linked to the funding it spends.
+func Shuffle(prev []byte, txs transaction.Transactions) transaction.Transactions {
+	result := sortedByID(txs)
+	s := newStream(Seed(prev, txs))
+	for i := len(result) - 1; i > 0; i-- {
+		j := s.intn(i + 1)
+		result[i], result[j] = result[j], result[i]
+	}
+	return result
+}
+
+// IsShuffled tells whether the transactions are in the order Shuffle puts
+// them in.
+func IsShuffled(prev []byte, txs transaction.Transactions) bool {
+	expected := Shuffle(prev, txs)
+	for i := range txs {
+		if bytes.Compare(expected[i].ID, txs[i].ID) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func Outputs(outputs transaction.Outputs) transaction.Outputs {
+	result := make(transaction.Outputs, len(outputs))
+	copy(result, outputs)
+	sort.Slice(result, func(i, j int) bool {
+		return bytes.Compare(result[i].PublicKeyHash, result[j].PublicKeyHash) < 0
+	})
+	return result
+}
+
+func VerifyMixedBlock(verifyBlock blockchain.VerifyBlockFn) blockchain.VerifyBlockFn {
+	return func(block blockchain.Block, hashedSender []byte) bool {
+		if !verifyBlock(block, hashedSender) {
+			return false
+		}
+		return IsShuffled(block.Header.Prev, block.Body.Transactions[1:])
+	}
+}
+
+type stream struct {
+	seed    []byte
+	counter uint64
+}
+
+func newStream(seed []byte) *stream {
+	return &stream{seed: seed}
+}
+
+func (s *stream) next() uint64 {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, s.counter)
+	s.counter++
+	hash := sha256.Sum256(append(append([]byte{}, s.seed...), counter...))
+	return binary.BigEndian.Uint64(hash[:8])
+}
+
+func (s *stream) intn(n int) int {
+	limit := ^uint64(0) - ^uint64(0)%uint64(n)
+	for {
+		if v := s.next(); v < limit {
+			return int(v % uint64(n))
+		}
+	}
+}
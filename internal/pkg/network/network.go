@@ -0,0 +1,70 @@
+package network
+
+import (
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+// Type tells whether the blockchain runs a real election or is a test
+// network. It is committed to by the genesis block, so a production chain
+// can't be restarted as a test network.
+type Type string
+
+const (
+	// Production chains run real elections, votes are only given to
+	// registered voters.
+	Production Type = "production"
+	// Testnet chains hand out votes and keys to anyone asking the faucet.
+	Testnet Type = "testnet"
+)
+
+// label marks the genesis transaction naming the network type. It has two
+// outputs, so it is never taken for a rules commitment.
+var label = []byte("network")
+
+func Parse(raw string) (Type, error) {
+	switch t := Type(raw); t {
+	case Production, Testnet:
+		return t, nil
+	default:
+		return "", errors.Errorf("Unknown network type %s", raw)
+	}
+}
+
+// NewMarker returns the genesis transaction naming the network type.
+// Production chains have no marker, so chains started before network types
+// are production chains.
+func NewMarker(algorithm digest.Algorithm, t Type) (*transaction.Transaction, error) {
+	return transaction.NewTransaction(algorithm, nil, transaction.Outputs{{PublicKeyHash: label}, {PublicKeyHash: []byte(t)}})
+}
+
+// Of returns the network type the genesis block names.
+func Of(genesis blockchain.Block) Type {
+	for _, t := range genesis.Body.Transactions {
+		if len(t.Inputs) == 0 && len(t.Outputs) == 2 && t.Outputs[0].Value == 0 && t.Outputs[1].Value == 0 && string(t.Outputs[0].PublicKeyHash) == string(label) {
+			return Type(t.Outputs[1].PublicKeyHash)
+		}
+	}
+	return Production
+}
+
+// VerifyGenesis makes sure the node runs the network type the blockchain
+// was started with. There is nothing to verify before the blockchain has a
+// genesis block.
+func VerifyGenesis(findBlock blockchain.FindBlockFn, t Type) error {
+	genesis, ok, err := findBlock(func(b blockchain.Block) bool {
+		return len(b.Header.Prev) == 0
+	})
+	switch {
+	case err != nil:
+		return errors.Wrap(err, "Failed to find genesis block")
+	case !ok:
+		return nil
+	}
+	if committed := Of(genesis); committed != t {
+		return errors.Errorf("Blockchain is a %s network but the node runs a %s network", committed, t)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package observer
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+// Hour counts the votes cast in the hour starting at the unix time.
+type Hour struct {
+	Start int64 `json:"start"`
+	Votes int   `json:"votes"`
+}
+
+// Stats describe the votes a party received, confirmed ones are in the
+// blockchain and pending ones wait to be forged.
+type Stats struct {
+	Confirmed  int    `json:"confirmed"`
+	Pending    int    `json:"pending"`
+	LastVoteAt int64  `json:"lastVoteAt,omitempty"`
+	Hourly     []Hour `json:"hourly"`
+}
+
+type StatsFn func(party []byte) (Stats, error)
+
+// VotesFn returns the transactions giving votes to the party, the ones in
+// the blockchain and the pending ones.
+type VotesFn func(party []byte) (confirmed, pending transaction.Transactions, err error)
+
+// votes returns the value the transaction gives to the party, transactions
+// of the alfa node and of the party itself aren't votes.
+func votes(t transaction.Transaction, party, alfaKeyHash []byte) int {
+	if len(t.Inputs) == 0 || t.AreInputsFrom(alfaKeyHash) || t.AreInputsFrom(party) {
+		return 0
+	}
+	value := 0
+	for _, out := range t.Outputs {
+		if bytes.Equal(out.PublicKeyHash, party) {
+			value += out.Value
+		}
+	}
+	return value / transaction.VoteValue
+}
+
+func Votes(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, getPending transaction.GetTransactionsFn, alfaKeyHash []byte) VotesFn {
+	return func(party []byte) (transaction.Transactions, transaction.Transactions, error) {
+		var confirmed transaction.Transactions
+		for current := getTip(); len(current) > 0; {
+			block, err := getBlock(current)
+			switch {
+			case err != nil:
+				return nil, nil, errors.Wrapf(err, "Failed to get block %x", current)
+			case block == nil:
+				return nil, nil, errors.Errorf("Block %x is missing", current)
+			}
+			for _, t := range block.Body.Transactions {
+				if votes(t, party, alfaKeyHash) > 0 {
+					confirmed = append(confirmed, t)
+				}
+			}
+			current = block.Header.Prev
+		}
+		all, err := getPending()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "Failed to retrieve pending transactions")
+		}
+		var pending transaction.Transactions
+		for _, t := range all {
+			if votes(t, party, alfaKeyHash) > 0 {
+				pending = append(pending, t)
+			}
+		}
+		return confirmed, pending, nil
+	}
+}
+
+func InflowStats(getVotes VotesFn, alfaKeyHash []byte) StatsFn {
+	return func(party []byte) (Stats, error) {
+		confirmed, pending, err := getVotes(party)
+		if err != nil {
+			return Stats{}, err
+		}
+		result := Stats{Hourly: []Hour{}}
+		hours := map[int64]int{}
+		count := func(txs transaction.Transactions) int {
+			total := 0
+			for _, t := range txs {
+				v := votes(t, party, alfaKeyHash)
+				total += v
+				hours[t.Timestamp-t.Timestamp%3600] += v
+				if t.Timestamp > result.LastVoteAt {
+					result.LastVoteAt = t.Timestamp
+				}
+			}
+			return total
+		}
+		result.Confirmed = count(confirmed)
+		result.Pending = count(pending)
+		for start, v := range hours {
+			result.Hourly = append(result.Hourly, Hour{Start: start, Votes: v})
+		}
+		sort.Slice(result.Hourly, func(i, j int) bool {
+			return result.Hourly[i].Start < result.Hourly[j].Start
+		})
+		return result, nil
+	}
+}
+
+// ConflictsFn returns the conflicts the votes for the party are part of.
+type ConflictsFn func(party []byte) ([]transaction.Conflict, error)
+
+func PartyConflicts(getVotes VotesFn, getConflicts transaction.GetConflictsFn) ConflictsFn {
+	return func(party []byte) ([]transaction.Conflict, error) {
+		confirmed, pending, err := getVotes(party)
+		if err != nil {
+			return nil, err
+		}
+		result := []transaction.Conflict{}
+		seen := map[string]bool{}
+		for _, t := range append(confirmed, pending...) {
+			conflicts, err := getConflicts(t.ID)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to retrieve conflicts of transaction %x", t.ID)
+			}
+			for _, c := range conflicts {
+				key := fmt.Sprintf("%x/%d", c.TransactionID, c.Vout)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				result = append(result, c)
+			}
+		}
+		return result, nil
+	}
+}
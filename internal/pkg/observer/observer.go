@@ -0,0 +1,268 @@
+package observer
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidKey   = errors.New("API key is not valid")
+	ErrScopeMissing = errors.New("API key is not granted the scope")
+	ErrRateLimited  = errors.New("Rate limit of the scope is exceeded")
+	ErrInvalidScope = errors.New("Scope is not known")
+)
+
+// Scope is what an observer key grants read access to.
+type Scope string
+
+const (
+	// Tally is the live tally of the election.
+	Tally Scope = "tally"
+	// Inflow are the statistics of the votes the party receives.
+	Inflow Scope = "inflow"
+	// Conflicts are the double spends the votes for the party are part of.
+	Conflicts Scope = "conflicts"
+)
+
+var Scopes = []Scope{Tally, Inflow, Conflicts}
+
+func ParseScope(raw string) (Scope, error) {
+	for _, s := range Scopes {
+		if string(s) == raw {
+			return s, nil
+		}
+	}
+	return "", errors.Wrapf(ErrInvalidScope, "Scope %s", raw)
+}
+
+// Key is an API key of a party observer. Only the hash of the secret is
+// kept, the token is shown once when the key is issued.
+type Key struct {
+	ID        string  `json:"id"`
+	Party     string  `json:"party"`
+	Scopes    []Scope `json:"scopes"`
+	Secret    []byte  `json:"secret,omitempty"`
+	CreatedAt int64   `json:"createdAt"`
+	RevokedAt int64   `json:"revokedAt,omitempty"`
+}
+
+type Keys []Key
+
+func (k Key) Grants(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Public is the key as admins list it, with the party it observes, its
+// scopes and when it was issued and revoked, but not the hash of its token.
+func (k Key) Public() Key {
+	k.Secret = nil
+	return k
+}
+
+type SaveFn func(Key) error
+
+type GetFn func(id string) (*Key, error)
+
+type ListFn func() (Keys, error)
+
+// IssueFn issues a key to the party and returns it with its token.
+type IssueFn func(party string, scopes []Scope) (Key, string, error)
+
+type RevokeFn func(id string) (Key, error)
+
+// AuthorizeFn returns the key of the token if it grants the scope and the
+// rate limit of the scope isn't exceeded.
+type AuthorizeFn func(token string, scope Scope) (Key, error)
+
+func hashSecret(secret []byte) []byte {
+	hash := sha256.Sum256(secret)
+	return hash[:]
+}
+
+func random(n int) ([]byte, error) {
+	result := make([]byte, n)
+	if _, err := rand.Read(result); err != nil {
+		return nil, errors.Wrap(err, "Failed to generate random bytes")
+	}
+	return result, nil
+}
+
+// Issue creates a key with a random id and secret, all scopes are granted
+// if none are given. The token is the id and the hex encoded secret joined
+// with a dot.
+func Issue(save SaveFn) IssueFn {
+	return func(party string, scopes []Scope) (Key, string, error) {
+		if len(scopes) == 0 {
+			scopes = Scopes
+		}
+		id, err := random(8)
+		if err != nil {
+			return Key{}, "", err
+		}
+		secret, err := random(32)
+		if err != nil {
+			return Key{}, "", err
+		}
+		k := Key{
+			ID:        hex.EncodeToString(id),
+			Party:     party,
+			Scopes:    scopes,
+			Secret:    hashSecret(secret),
+			CreatedAt: time.Now().Unix(),
+		}
+		if err := save(k); err != nil {
+			return Key{}, "", errors.Wrapf(err, "Failed to save key %s", k.ID)
+		}
+		return k, k.ID + "." + hex.EncodeToString(secret), nil
+	}
+}
+
+func Revoke(get GetFn, save SaveFn) RevokeFn {
+	return func(id string) (Key, error) {
+		k, err := get(id)
+		switch {
+		case err != nil:
+			return Key{}, errors.Wrapf(err, "Failed to retrieve key %s", id)
+		case k == nil:
+			return Key{}, errors.Wrapf(ErrInvalidKey, "Key %s does not exist", id)
+		case k.RevokedAt != 0:
+			return k.Public(), nil
+		}
+		k.RevokedAt = time.Now().Unix()
+		if err := save(*k); err != nil {
+			return Key{}, errors.Wrapf(err, "Failed to revoke key %s", id)
+		}
+		return k.Public(), nil
+	}
+}
+
+// Limits are the requests a single key may make per minute for each scope,
+// scopes without a limit aren't limited.
+type Limits map[Scope]int
+
+var DefaultLimits = Limits{Tally: 60, Inflow: 30, Conflicts: 10}
+
+// ParseLimits parses comma separated scope=requests pairs, scopes which
+// aren't given keep their default limit.
+func ParseLimits(raw string) (Limits, error) {
+	result := Limits{}
+	for s, limit := range DefaultLimits {
+		result[s] = limit
+	}
+	for _, pair := range strings.Split(raw, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("Invalid limit %s", pair)
+		}
+		s, err := ParseScope(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, err
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || limit < 1 {
+			return nil, errors.Errorf("Invalid limit of scope %s", s)
+		}
+		result[s] = limit
+	}
+	return result, nil
+}
+
+func (l Limits) String() string {
+	parts := []string{}
+	for _, s := range Scopes {
+		if limit, ok := l[s]; ok {
+			parts = append(parts, fmt.Sprintf("%s=%d", s, limit))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Limiter keeps a token bucket for every key and scope, refilled with the
+// limit of the scope every minute.
+type Limiter struct {
+	lock    *sync.Mutex
+	limits  Limits
+	buckets map[string]*bucket
+}
+
+func NewLimiter(limits Limits) *Limiter {
+	return &Limiter{
+		lock:    &sync.Mutex{},
+		limits:  limits,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token of the key for the scope, false if there is none.
+func (l *Limiter) Allow(id string, scope Scope) bool {
+	limit, ok := l.limits[scope]
+	if !ok {
+		return true
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	name := id + "/" + string(scope)
+	b, ok := l.buckets[name]
+	if !ok {
+		b = &bucket{tokens: float64(limit), updated: now}
+		l.buckets[name] = b
+	}
+	b.tokens += now.Sub(b.updated).Minutes() * float64(limit)
+	if b.tokens > float64(limit) {
+		b.tokens = float64(limit)
+	}
+	b.updated = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func Authorize(get GetFn, limiter *Limiter) AuthorizeFn {
+	return func(token string, scope Scope) (Key, error) {
+		parts := strings.SplitN(token, ".", 2)
+		if len(parts) != 2 {
+			return Key{}, ErrInvalidKey
+		}
+		secret, err := hex.DecodeString(parts[1])
+		if err != nil {
+			return Key{}, ErrInvalidKey
+		}
+		k, err := get(parts[0])
+		switch {
+		case err != nil:
+			return Key{}, errors.Wrapf(err, "Failed to retrieve key %s", parts[0])
+		case k == nil, k.RevokedAt != 0, subtle.ConstantTimeCompare(k.Secret, hashSecret(secret)) != 1:
+			return Key{}, ErrInvalidKey
+		case !k.Grants(scope):
+			return Key{}, errors.Wrapf(ErrScopeMissing, "Scope %s", scope)
+		case !limiter.Allow(k.ID, scope):
+			return Key{}, errors.Wrapf(ErrRateLimited, "Scope %s allows %d requests per minute", scope, limiter.limits[scope])
+		}
+		return k.Public(), nil
+	}
+}
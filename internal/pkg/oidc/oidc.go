@@ -0,0 +1,296 @@
+package oidc
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidToken = errors.New("Invalid identity token")
+
+// leeway tolerates clock skew between the identity provider and alfa.
+const leeway = time.Minute
+
+// Identity is a verified identity. Hash is the hex encoded SHA-256 hash of
+// the value of the mapped claim, the only part of the identity kept.
+type Identity struct {
+	Issuer string
+	Hash   string
+	Nonce  string
+}
+
+// HashClaim hashes the value of a claim the way identities are mapped to
+// the eligibility roll.
+func HashClaim(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])
+}
+
+// Verifier verifies ID tokens issued by an OpenID Connect provider. Signing
+// keys are fetched from the provider's discovery document and fetched again
+// when a token is signed by an unknown key.
+type Verifier struct {
+	issuer   string
+	clientID string
+	claim    string
+	client   *http.Client
+	lock     *sync.Mutex
+	keys     map[string]crypto.PublicKey
+	fetched  time.Time
+}
+
+func NewVerifier(issuer, clientID, claim string) *Verifier {
+	return &Verifier{
+		issuer:   strings.TrimSuffix(issuer, "/"),
+		clientID: clientID,
+		claim:    claim,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		lock:     &sync.Mutex{},
+	}
+}
+
+func (v *Verifier) Issuer() string {
+	return v.issuer
+}
+
+func (v *Verifier) getJSON(url string, result interface{}) error {
+	resp, err := v.client.Get(url)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to reach identity provider %s", url)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("Identity provider %s responded with status %d", url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return errors.Wrapf(err, "Failed to parse response of identity provider %s", url)
+	}
+	return nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeInt(raw string) (*big.Int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(decoded), nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid modulus of key %s", k.Kid)
+		}
+		e, err := decodeInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.Errorf("Invalid exponent of key %s", k.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, errors.Errorf("Unsupported curve %s of key %s", k.Crv, k.Kid)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid x of key %s", k.Kid)
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid y of key %s", k.Kid)
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.Errorf("Key %s is not on curve P-256", k.Kid)
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	default:
+		return nil, errors.Errorf("Unsupported key type %s of key %s", k.Kty, k.Kid)
+	}
+}
+
+func (v *Verifier) fetchKeys() error {
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := v.getJSON(v.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != v.issuer {
+		return errors.Errorf("Identity provider reports issuer %s instead of %s", discovery.Issuer, v.issuer)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.getJSON(discovery.JWKSURI, &set); err != nil {
+		return err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	v.keys = keys
+	v.fetched = time.Now()
+	return nil
+}
+
+// key returns the signing key with the id. Keys are fetched at most once a
+// minute, so forged key ids can't make alfa flood the provider.
+func (v *Verifier) key(kid string) (crypto.PublicKey, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(v.fetched) < time.Minute {
+		return nil, errors.Wrapf(ErrInvalidToken, "Unknown signing key %s", kid)
+	}
+	if err := v.fetchKeys(); err != nil {
+		return nil, err
+	}
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errors.Wrapf(ErrInvalidToken, "Unknown signing key %s", kid)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) bool {
+	digest := sha256.Sum256(signed)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return alg == "RS256" && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		if alg != "ES256" || len(signature) != 64 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(k, digest[:], r, s)
+	default:
+		return false
+	}
+}
+
+type audience []string
+
+func (a *audience) UnmarshalJSON(raw []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+		return json.Unmarshal(raw, (*[]string)(a))
+	}
+	var single string
+	if err := json.Unmarshal(raw, &single); err != nil {
+		return err
+	}
+	*a = audience{single}
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify verifies the signature, issuer, audience and lifetime of the ID
+// token and returns the identity it asserts.
+func (v *Verifier) Verify(token string) (Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Identity{}, errors.Wrap(ErrInvalidToken, "Token is not a signed JWT")
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Identity{}, errors.Wrap(ErrInvalidToken, "Invalid token header")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return Identity{}, errors.Wrap(ErrInvalidToken, "Invalid token header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Identity{}, errors.Wrap(ErrInvalidToken, "Invalid token signature")
+	}
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return Identity{}, err
+	}
+	if !verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature) {
+		return Identity{}, errors.Wrap(ErrInvalidToken, "Token signature does not match")
+	}
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Identity{}, errors.Wrap(ErrInvalidToken, "Invalid token claims")
+	}
+	var claims struct {
+		Issuer    string   `json:"iss"`
+		Audience  audience `json:"aud"`
+		Expires   int64    `json:"exp"`
+		NotBefore int64    `json:"nbf"`
+		Nonce     string   `json:"nonce"`
+	}
+	var all map[string]interface{}
+	if err := json.Unmarshal(rawClaims, &claims); err != nil {
+		return Identity{}, errors.Wrap(ErrInvalidToken, "Invalid token claims")
+	}
+	if err := json.Unmarshal(rawClaims, &all); err != nil {
+		return Identity{}, errors.Wrap(ErrInvalidToken, "Invalid token claims")
+	}
+	now := time.Now()
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != v.issuer:
+		return Identity{}, errors.Wrapf(ErrInvalidToken, "Token is issued by %s", claims.Issuer)
+	case !claims.Audience.contains(v.clientID):
+		return Identity{}, errors.Wrap(ErrInvalidToken, "Token is not issued for the election")
+	case claims.Expires == 0 || now.Add(-leeway).Unix() > claims.Expires:
+		return Identity{}, errors.Wrap(ErrInvalidToken, "Token expired")
+	case claims.NotBefore != 0 && now.Add(leeway).Unix() < claims.NotBefore:
+		return Identity{}, errors.Wrap(ErrInvalidToken, "Token is not valid yet")
+	}
+	value, ok := all[v.claim]
+	if !ok {
+		return Identity{}, errors.Wrapf(ErrInvalidToken, "Token has no claim %s", v.claim)
+	}
+	return Identity{
+		Issuer: v.issuer,
+		Hash:   HashClaim(fmt.Sprint(value)),
+		Nonce:  claims.Nonce,
+	}, nil
+}
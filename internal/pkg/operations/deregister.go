@@ -0,0 +1,36 @@
+package operations
+
+import (
+	"encoding/base64"
+
+	"github.com/gorilla/websocket"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	_websocket "github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+type DeregisterFn func() (int, error)
+
+type deregisterResult struct {
+	Returned int `json:"returned"`
+}
+
+func Deregister(conn *websocket.Conn, w wallet.Wallet) DeregisterFn {
+	return func() (int, error) {
+		payload := operation{
+			Message: _websocket.DeregisterMessage,
+			Body:    struct{}{},
+			Sender:  base64.StdEncoding.EncodeToString(w.PublicKey),
+		}
+		rawSignature, err := wallet.Sign(payload, w.PrivateKey)
+		if err != nil {
+			return 0, errors.Wrap(err, "Failed to sign payload")
+		}
+		payload.Signature = base64.StdEncoding.EncodeToString(rawSignature)
+		var r deregisterResult
+		if err := call(conn, payload, &r); err != nil {
+			return 0, errors.Wrapf(err, "Failed to send operation %#v", payload)
+		}
+		return r.Returned, nil
+	}
+}
@@ -0,0 +1,33 @@
+package operations
+
+import (
+	"encoding/base64"
+
+	"github.com/gorilla/websocket"
+	"github.com/nebser/crypto-vote/internal/pkg/stake"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	_websocket "github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+type GetAccountFn func() (stake.Account, error)
+
+func GetAccount(conn *websocket.Conn, w wallet.Wallet) GetAccountFn {
+	return func() (stake.Account, error) {
+		payload := operation{
+			Message: _websocket.GetAccountMessage,
+			Body:    struct{}{},
+			Sender:  base64.StdEncoding.EncodeToString(w.PublicKey),
+		}
+		rawSignature, err := wallet.Sign(payload, w.PrivateKey)
+		if err != nil {
+			return stake.Account{}, errors.Wrap(err, "Failed to sign payload")
+		}
+		payload.Signature = base64.StdEncoding.EncodeToString(rawSignature)
+		var account stake.Account
+		if err := call(conn, payload, &account); err != nil {
+			return stake.Account{}, errors.Wrapf(err, "Failed to send operation %s", payload.Message)
+		}
+		return account, nil
+	}
+}
@@ -0,0 +1,35 @@
+package operations
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	_websocket "github.com/nebser/crypto-vote/internal/pkg/websocket"
+)
+
+// GetBlocksRangeFn returns up to count blocks following the block after, from
+// the genesis block if after is empty, and how many blocks follow them.
+type GetBlocksRangeFn func(after []byte, count int) (blockchain.Blocks, int, error)
+
+type getBlocksRangePayload struct {
+	After []byte `json:"after"`
+	Count int    `json:"count"`
+}
+
+type getBlocksRangeResult struct {
+	Blocks    blockchain.Blocks `json:"blocks"`
+	Remaining int               `json:"remaining"`
+}
+
+func GetBlocksRange(conn *websocket.Conn) GetBlocksRangeFn {
+	return func(after []byte, count int) (blockchain.Blocks, int, error) {
+		payload := operation{
+			Message: _websocket.GetBlocksRangeMessage,
+			Body:    getBlocksRangePayload{After: after, Count: count},
+		}
+		var r getBlocksRangeResult
+		if err := call(conn, payload, &r); err != nil {
+			return nil, 0, err
+		}
+		return r.Blocks, r.Remaining, nil
+	}
+}
@@ -5,26 +5,29 @@ import (
 	_websocket "github.com/nebser/crypto-vote/internal/pkg/websocket"
 )
 
-type GetMissingBlocksFn func(lastBlock []byte) ([][]byte, error)
+// GetMissingBlocksFn returns the latest block of the locator the alfa node
+// has in its blockchain and the hashes of the blocks following it.
+type GetMissingBlocksFn func(locator [][]byte) ([]byte, [][]byte, error)
 
 type getMissingBlocksPayload struct {
-	LastBlock []byte `json:"lastBlock"`
+	Locator [][]byte `json:"locator"`
 }
 
 type getMissingBlocksResult struct {
+	Fork   []byte   `json:"fork"`
 	Blocks [][]byte `json:"blocks"`
 }
 
 func GetMissingBlocks(conn *websocket.Conn) GetMissingBlocksFn {
-	return func(lastBlock []byte) ([][]byte, error) {
+	return func(locator [][]byte) ([]byte, [][]byte, error) {
 		payload := operation{
 			Message: _websocket.GetMissingBlocksMessage,
-			Body:    getMissingBlocksPayload{LastBlock: lastBlock},
+			Body:    getMissingBlocksPayload{Locator: locator},
 		}
 		var r getMissingBlocksResult
 		if err := call(conn, payload, &r); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		return r.Blocks, nil
+		return r.Fork, r.Blocks, nil
 	}
 }
@@ -0,0 +1,25 @@
+package operations
+
+import (
+	"github.com/gorilla/websocket"
+	_websocket "github.com/nebser/crypto-vote/internal/pkg/websocket"
+)
+
+type GetNodesFn func() ([]string, error)
+
+type getNodesResult struct {
+	Nodes []string `json:"nodes"`
+}
+
+func GetNodes(conn *websocket.Conn) GetNodesFn {
+	return func() ([]string, error) {
+		payload := operation{
+			Message: _websocket.GetNodesMessage,
+		}
+		var r getNodesResult
+		if err := call(conn, payload, &r); err != nil {
+			return nil, err
+		}
+		return r.Nodes, nil
+	}
+}
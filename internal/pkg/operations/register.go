@@ -9,34 +9,45 @@ import (
 	"github.com/pkg/errors"
 )
 
-type RegisterFn func(nodeID string) ([]string, error)
+// RegisterFn registers the node and returns the nodes registered before it
+// and the encoding negotiated for the connection.
+type RegisterFn func(nodeID string) ([]string, _websocket.Encoding, error)
 
 type registerPayload struct {
-	NodeID string `json:"nodeId"`
+	NodeID    string                `json:"nodeId"`
+	Encodings []_websocket.Encoding `json:"encodings,omitempty"`
 }
 
 type registerResult struct {
-	Nodes []string `json:"nodes"`
+	Nodes    []string            `json:"nodes"`
+	Encoding _websocket.Encoding `json:"encoding,omitempty"`
 }
 
-func Register(conn *websocket.Conn, w wallet.Wallet) RegisterFn {
-	return func(nodeID string) ([]string, error) {
+// Register offers the encodings besides JSON, which every node accepts. The
+// other end picks JSON if it doesn't answer with an encoding, as versions
+// before the binary encoding do.
+func Register(conn *websocket.Conn, w wallet.Wallet, encodings ..._websocket.Encoding) RegisterFn {
+	return func(nodeID string) ([]string, _websocket.Encoding, error) {
 		payload := operation{
 			Message: _websocket.RegisterMessage,
 			Body: registerPayload{
-				NodeID: nodeID,
+				NodeID:    nodeID,
+				Encodings: encodings,
 			},
 			Sender: base64.StdEncoding.EncodeToString(w.PublicKey),
 		}
 		rawSignature, err := wallet.Sign(payload, w.PrivateKey)
 		if err != nil {
-			return nil, errors.Wrap(err, "Failed to sign payload")
+			return nil, "", errors.Wrap(err, "Failed to sign payload")
 		}
 		payload.Signature = base64.StdEncoding.EncodeToString(rawSignature)
 		var r registerResult
 		if err := call(conn, payload, &r); err != nil {
-			return nil, errors.Wrapf(err, "Failed to send operation %#v", payload)
+			return nil, "", errors.Wrapf(err, "Failed to send operation %#v", payload)
 		}
-		return r.Nodes, nil
+		if r.Encoding == "" {
+			r.Encoding = _websocket.JSONEncoding
+		}
+		return r.Nodes, r.Encoding, nil
 	}
 }
@@ -0,0 +1,102 @@
+package operations
+
+import (
+	"bytes"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/pkg/errors"
+)
+
+// retryDelay is how long a sync waits before resuming on a new connection.
+const retryDelay = 2 * time.Second
+
+// DialFn opens a new connection to the alfa node.
+type DialFn func() (*websocket.Conn, error)
+
+// SyncFn catches up with the blockchain of the alfa node and returns the
+// number of added blocks.
+type SyncFn func() (int, error)
+
+// Sync downloads the blocks following the local tip in batches of batchSize
+// and adds every batch before asking for the next one, so the alfa node
+// never sends more than the node keeps up with. Every block has to point to
+// the block before it. When the connection drops a new one is dialed, up to
+// retries times in a row, and the sync resumes from the local tip; a node
+// restarted mid-sync resumes the same way.
+func Sync(dial DialFn, getTip blockchain.GetTipFn, addBlock blockchain.AddBlockFn, batchSize, retries int) SyncFn {
+	return func() (int, error) {
+		if batchSize <= 0 {
+			batchSize = 1
+		}
+		added, failures := 0, 0
+		for {
+			before := added
+			conn, err := dial()
+			if err != nil {
+				err = connectionError{errors.Wrap(err, "Failed to connect to the alfa node")}
+			} else {
+				err = syncBatches(GetBlocksRange(conn), getTip, addBlock, batchSize, &added)
+				conn.Close()
+			}
+			switch {
+			case err == nil:
+				return added, nil
+			case !isConnectionError(err):
+				return added, err
+			case added > before:
+				failures = 1
+			default:
+				failures++
+			}
+			if failures > retries {
+				return added, errors.Wrapf(err, "Sync failed after %d retries", retries)
+			}
+			log.Printf("Sync interrupted after %d blocks, resuming from tip %x in %s. Error: %s", added, getTip(), retryDelay, err)
+			time.Sleep(retryDelay)
+		}
+	}
+}
+
+// connectionError marks failures to get blocks from the alfa node, after
+// which the sync is resumed on a new connection.
+type connectionError struct {
+	error
+}
+
+func isConnectionError(err error) bool {
+	_, ok := errors.Cause(err).(connectionError)
+	return ok
+}
+
+func syncBatches(getRange GetBlocksRangeFn, getTip blockchain.GetTipFn, addBlock blockchain.AddBlockFn, batchSize int, added *int) error {
+	for {
+		tip := getTip()
+		blocks, remaining, err := getRange(tip, batchSize)
+		if err != nil {
+			return connectionError{err}
+		}
+		prev := tip
+		for _, block := range blocks {
+			if !block.IsHashValid() {
+				return errors.Errorf("Received block %x with an invalid hash", block.Header.Hash)
+			}
+			if !bytes.Equal(block.Header.Prev, prev) {
+				return errors.Errorf("Block %x does not point to the previous block %x", block.Header.Hash, prev)
+			}
+			if _, err := addBlock(block); err != nil {
+				return errors.Wrapf(err, "Failed to add block %x", block.Header.Hash)
+			}
+			prev = block.Header.Hash
+			*added++
+		}
+		if len(blocks) > 0 {
+			log.Printf("Synced %d blocks, %d remaining", *added, remaining)
+		}
+		if remaining == 0 || len(blocks) == 0 {
+			return nil
+		}
+	}
+}
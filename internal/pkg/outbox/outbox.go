@@ -0,0 +1,41 @@
+package outbox
+
+import (
+	"encoding/json"
+
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+)
+
+// Entry is a broadcast that was committed together with the state change
+// that caused it and has not been delivered yet.
+type Entry struct {
+	ID      uint64            `json:"-"`
+	Message websocket.Message `json:"message"`
+	Body    json.RawMessage   `json:"body"`
+}
+
+type Entries []Entry
+
+func (e Entry) Pong() websocket.Pong {
+	return websocket.Pong{
+		Message: e.Message,
+		Body:    e.Body,
+	}
+}
+
+func NewEntry(pong websocket.Pong) (*Entry, error) {
+	body, err := json.Marshal(pong.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &Entry{
+		Message: pong.Message,
+		Body:    body,
+	}, nil
+}
+
+type GetPendingFn func(limit int) (Entries, error)
+
+type RemoveFn func(id uint64) error
+
+type DispatchFn func() error
@@ -1,9 +1,22 @@
 package party
 
+import (
+	"bytes"
+
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+)
+
+// Party is a choice on a ballot. Choices of the same question share the
+// question name, which is empty in elections with a single question.
 type Party struct {
-	Name    string `json:"name"`
-	Address string `json:"address"`
-	Balance int    `json:"balance"`
+	Name     string `json:"name"`
+	Address  string `json:"address"`
+	Question string `json:"question,omitempty"`
+	Balance  int    `json:"balance"`
+	Credits  int    `json:"credits,omitempty"`
+	// Withdrawn is set once the party left the election, the balance of a
+	// party whose prior votes are void is not counted.
+	Withdrawn bool `json:"withdrawn,omitempty"`
 }
 
 type Parties []Party
@@ -27,6 +40,25 @@ func (p Parties) Less(i, j int) bool {
 	}
 }
 
+func (p Parties) Find(address string) (Party, bool) {
+	for _, party := range p {
+		if party.Address == address {
+			return party, true
+		}
+	}
+	return Party{}, false
+}
+
+// FindByKeyHash finds the party whose address holds the public key hash.
+func (p Parties) FindByKeyHash(keyHash []byte) (Party, bool) {
+	for _, party := range p {
+		if bytes.Equal(wallet.ExtractPublicKeyHash(party.Address), keyHash) {
+			return party, true
+		}
+	}
+	return Party{}, false
+}
+
 type GetPartyFn func(string) (*Party, error)
 
 type GetPartiesFn func() (Parties, error)
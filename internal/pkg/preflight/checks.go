@@ -0,0 +1,166 @@
+package preflight
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/repository"
+	"github.com/pkg/errors"
+)
+
+// requestTimeout bounds every request to a peer.
+const requestTimeout = 5 * time.Second
+
+// Database opens the database read only and checks its consistency. A
+// missing database passes if it will be created, when its directory can be
+// written to.
+func Database(path string, created bool) Check {
+	return Check{
+		Name: "database",
+		Run: func() (string, error) {
+			switch _, err := os.Stat(path); {
+			case os.IsNotExist(err) && created:
+				dir := filepath.Dir(path)
+				f, err := ioutil.TempFile(dir, ".preflight")
+				if err != nil {
+					return "", errors.Wrapf(err, "Database %s doesn't exist and can't be created", path)
+				}
+				f.Close()
+				os.Remove(f.Name())
+				return fmt.Sprintf("%s will be created in %s", filepath.Base(path), dir), nil
+			case err != nil:
+				return "", errors.Wrapf(err, "Failed to read stat for database %s", path)
+			}
+			db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+			switch {
+			case err == bolt.ErrTimeout:
+				return "", errors.Errorf("Database %s is locked, the node has to be stopped for the preflight", path)
+			case err != nil:
+				return "", errors.Wrapf(err, "Failed to open database %s", path)
+			}
+			defer db.Close()
+			c, err := repository.CheckConsistency(db)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s is consistent, %d blocks up to %x, %d KiB", path, c.Height, c.Tip, c.Size>>10), nil
+		},
+	}
+}
+
+// Bind checks that the address can be listened on.
+func Bind(name, address string) Check {
+	return Check{
+		Name: name,
+		Run: func() (string, error) {
+			l, err := net.Listen("tcp", address)
+			if err != nil {
+				return "", errors.Wrapf(err, "Address %s can't be bound", address)
+			}
+			l.Close()
+			return fmt.Sprintf("%s is free", address), nil
+		},
+	}
+}
+
+// httpURL turns a websocket URL into the URL of the same server, which
+// answers plain requests too.
+func httpURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", errors.Wrapf(err, "Invalid URL %s", raw)
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+	return u.String(), nil
+}
+
+// probe requests the URL and returns the response with the time halfway
+// through the request.
+func probe(raw string) (*http.Response, time.Time, error) {
+	target, err := httpURL(raw)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	client := http.Client{Timeout: requestTimeout}
+	start := time.Now()
+	response, err := client.Get(target)
+	if err != nil {
+		return nil, time.Time{}, errors.Wrapf(err, "%s is not reachable", raw)
+	}
+	response.Body.Close()
+	return response, start.Add(time.Since(start) / 2), nil
+}
+
+// Reachable checks that the peer answers, whatever the status.
+func Reachable(name, peer string) Check {
+	return Check{
+		Name: name,
+		Run: func() (string, error) {
+			start := time.Now()
+			response, _, err := probe(peer)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s answered %d in %s", peer, response.StatusCode, time.Since(start).Truncate(time.Millisecond)), nil
+		},
+	}
+}
+
+// Clock compares the time of the peer, the Date header of its answer, with
+// the local time. The header is in whole seconds, so the peer's time is
+// taken to be half a second after it.
+func Clock(name, peer string, tolerance time.Duration) Check {
+	return Check{
+		Name: name,
+		Run: func() (string, error) {
+			response, at, err := probe(peer)
+			if err != nil {
+				return "", err
+			}
+			date, err := http.ParseTime(response.Header.Get("Date"))
+			if err != nil {
+				return "", errors.Errorf("%s sent no valid Date header", peer)
+			}
+			offset := date.Add(500 * time.Millisecond).Sub(at).Truncate(time.Millisecond)
+			if offset > tolerance || offset < -tolerance {
+				return "", errors.Errorf("Clock of %s is off by %s, more than %s", peer, offset, tolerance)
+			}
+			return fmt.Sprintf("clock of %s is off by %s", peer, offset), nil
+		},
+	}
+}
+
+// Disk checks that the directory has room for the projected votes.
+func Disk(dir string, votes int64, voteBytes int64) Check {
+	return Check{
+		Name: "disk",
+		Run: func() (string, error) {
+			var stat syscall.Statfs_t
+			if err := syscall.Statfs(dir, &stat); err != nil {
+				return "", errors.Wrapf(err, "Failed to read free space of %s", dir)
+			}
+			free := int64(stat.Bavail) * int64(stat.Bsize)
+			need := votes * voteBytes
+			if free < need {
+				return "", errors.Errorf("%s has %d MiB free, %d votes need %d MiB", dir, free>>20, votes, need>>20)
+			}
+			if votes == 0 {
+				return fmt.Sprintf("%s has %d MiB free, no turnout projected", dir, free>>20), nil
+			}
+			return fmt.Sprintf("%s has %d MiB free, %d votes need %d MiB", dir, free>>20, votes, need>>20), nil
+		},
+	}
+}
@@ -0,0 +1,118 @@
+package preflight
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Options are shared by the preflight of every application.
+type Options struct {
+	Votes          int64
+	VoteBytes      int64
+	ClockTolerance time.Duration
+	JSON           bool
+}
+
+func (o *Options) Register(fs *flag.FlagSet) {
+	fs.Int64Var(&o.Votes, "votes", 0, "Projected number of votes the disk has to have room for [only free space is reported if 0]")
+	fs.Int64Var(&o.VoteBytes, "voteBytes", 8<<10, "Disk space in bytes a vote takes with its block, indexes and undo record")
+	fs.DurationVar(&o.ClockTolerance, "clockTolerance", 2*time.Second, "How far the clock may be off the clocks of peers")
+	fs.BoolVar(&o.JSON, "json", false, "Should print the report as JSON")
+}
+
+// Status is the outcome of a check, skipped checks don't fail the report.
+type Status string
+
+const (
+	Pass Status = "pass"
+	Fail Status = "fail"
+	Skip Status = "skip"
+)
+
+// ErrSkipped is returned by a check which doesn't apply to the node, its
+// message tells why.
+type ErrSkipped string
+
+func (e ErrSkipped) Error() string {
+	return string(e)
+}
+
+// Check returns what it found, or the error it failed with.
+type Check struct {
+	Name string
+	Run  func() (string, error)
+}
+
+// Skipped is a check which doesn't apply, e.g. with nothing to check.
+func Skipped(name, reason string) Check {
+	return Check{Name: name, Run: func() (string, error) {
+		return "", ErrSkipped(reason)
+	}}
+}
+
+// Failed is a check which failed before it could run.
+func Failed(name string, err error) Check {
+	return Check{Name: name, Run: func() (string, error) {
+		return "", err
+	}}
+}
+
+type Result struct {
+	Check    string `json:"check"`
+	Status   Status `json:"status"`
+	Detail   string `json:"detail"`
+	Duration int64  `json:"duration"`
+}
+
+// Report holds the results of the checks in the order they ran. Duration
+// of a result is in milliseconds.
+type Report struct {
+	Passed  bool     `json:"passed"`
+	At      int64    `json:"at"`
+	Results []Result `json:"results"`
+}
+
+// Run runs every check, a failing check doesn't stop the others.
+func Run(checks []Check) Report {
+	report := Report{Passed: true, At: time.Now().Unix()}
+	for _, c := range checks {
+		start := time.Now()
+		detail, err := c.Run()
+		result := Result{Check: c.Name, Status: Pass, Detail: detail}
+		switch e := err.(type) {
+		case nil:
+		case ErrSkipped:
+			result.Status, result.Detail = Skip, e.Error()
+		default:
+			result.Status, result.Detail = Fail, err.Error()
+			report.Passed = false
+		}
+		result.Duration = int64(time.Since(start) / time.Millisecond)
+		report.Results = append(report.Results, result)
+	}
+	return report
+}
+
+// Print writes a line for every check and the verdict, or the report as
+// JSON.
+func (r Report) Print(w io.Writer, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	}
+	for _, result := range r.Results {
+		if _, err := fmt.Fprintf(w, "%-4s  %-12s %s\n", result.Status, result.Check, result.Detail); err != nil {
+			return err
+		}
+	}
+	verdict := "PASSED"
+	if !r.Passed {
+		verdict = "FAILED"
+	}
+	_, err := fmt.Fprintf(w, "Preflight %s at %s\n", verdict, time.Unix(r.At, 0).UTC().Format(time.RFC3339))
+	return err
+}
@@ -0,0 +1,54 @@
+package provisional
+
+import "github.com/pkg/errors"
+
+var (
+	ErrAlreadySubmitted = errors.New("Provisional ballot has already been submitted")
+	ErrNotFound         = errors.New("Provisional ballot does not exist")
+	ErrAlreadyDecided   = errors.New("Provisional ballot has already been adjudicated")
+)
+
+type Status string
+
+const (
+	// Pending ballots are quarantined, they are not counted until an admin
+	// accepts them.
+	Pending  Status = "pending"
+	Accepted Status = "accepted"
+	Rejected Status = "rejected"
+	// Cast ballots have been converted to a regular transaction.
+	Cast Status = "cast"
+)
+
+// Ballot is a ballot of a voter whose eligibility is disputed. Recipients
+// and the signature are the same a voter submits on /ballot.
+type Ballot struct {
+	Address     string   `json:"address"`
+	MemberID    string   `json:"memberId"`
+	PublicKey   []byte   `json:"publicKey"`
+	Recipients  [][]byte `json:"recipients"`
+	Value       int      `json:"value"`
+	Signature   []byte   `json:"signature"`
+	Status      Status   `json:"status"`
+	SubmittedAt int64    `json:"submittedAt"`
+	DecidedAt   int64    `json:"decidedAt,omitempty"`
+	Reason      string   `json:"reason,omitempty"`
+	Transaction []byte   `json:"transaction,omitempty"`
+}
+
+type Ballots []Ballot
+
+type SubmitFn func(Ballot) error
+
+// GetFn returns ballots in the given status, or all of them when status is
+// empty.
+type GetFn func(status Status) (Ballots, error)
+
+// AcceptFn registers the voter of the ballot so that the voter gets funded
+// and marks the ballot as accepted.
+type AcceptFn func(address, reason string) (*Ballot, error)
+
+type RejectFn func(address, reason string) (*Ballot, error)
+
+// CastFn converts an accepted ballot to a regular transaction.
+type CastFn func(address string) (*Ballot, error)
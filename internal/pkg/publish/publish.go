@@ -0,0 +1,96 @@
+package publish
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Store keeps published JSON files under their names, e.g. a directory
+// served by a CDN or a bucket of an object store.
+type Store interface {
+	Name() string
+	Put(name string, body []byte) error
+}
+
+type directory struct {
+	dir string
+}
+
+func NewDirectory(dir string) Store {
+	return directory{dir: dir}
+}
+
+func (d directory) Name() string {
+	return fmt.Sprintf("dir:%s", d.dir)
+}
+
+// Put replaces the file atomically, so a mirror never serves half of it.
+func (d directory) Put(name string, body []byte) error {
+	path := filepath.Join(d.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrapf(err, "Failed to create directory of %s", path)
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, body, 0644); err != nil {
+		return errors.Wrapf(err, "Failed to write %s", tmp)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.Wrapf(err, "Failed to replace %s", path)
+	}
+	return nil
+}
+
+type objectStore struct {
+	url    string
+	client *http.Client
+}
+
+// NewObjectStore puts files with HTTP PUT requests to the URL followed by
+// the name of the file.
+func NewObjectStore(url string) Store {
+	return objectStore{
+		url:    strings.TrimSuffix(url, "/"),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (o objectStore) Name() string {
+	return fmt.Sprintf("http:%s", o.url)
+}
+
+func (o objectStore) Put(name string, body []byte) error {
+	request, err := http.NewRequest(http.MethodPut, o.url+"/"+name, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create request for %s", name)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := o.client.Do(request)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to put %s to %s", name, o.url)
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(response.Body)
+		return errors.Errorf("Object store %s responded with status %d: %s", o.url, response.StatusCode, message)
+	}
+	return nil
+}
+
+func New(kind, target string) (Store, error) {
+	switch kind {
+	case "dir":
+		return NewDirectory(target), nil
+	case "http":
+		return NewObjectStore(target), nil
+	default:
+		return nil, errors.Errorf("Unknown publication type %s", kind)
+	}
+}
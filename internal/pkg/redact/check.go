@@ -0,0 +1,174 @@
+package redact
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// sensitive are the names of variables, fields and functions holding voter
+// identifiers, private keys or signatures.
+var sensitive = map[string]bool{
+	"address":         true,
+	"addresses":       true,
+	"encodeaddress":   true,
+	"voter":           true,
+	"voters":          true,
+	"publickeyhash":   true,
+	"hashedpublickey": true,
+	"pkh":             true,
+	"privatekey":      true,
+	"signature":       true,
+	"signatures":      true,
+	"rawsignature":    true,
+}
+
+var logFunctions = map[string]bool{
+	"Print": true, "Printf": true, "Println": true,
+	"Fatal": true, "Fatalf": true, "Fatalln": true,
+	"Panic": true, "Panicf": true, "Panicln": true,
+}
+
+// Allow marks a log call whose sensitive looking arguments are public, e.g.
+// addresses of party nodes.
+const Allow = "redact:public"
+
+type Finding struct {
+	Position token.Position
+	Reason   string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s", f.Position, f.Reason)
+}
+
+func callee(call *ast.CallExpr) (string, string) {
+	selector, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return "", ""
+	}
+	pkg, ok := selector.X.(*ast.Ident)
+	if !ok {
+		return "", ""
+	}
+	return pkg.Name, selector.Sel.Name
+}
+
+// leak returns the first sensitive name the expression uses outside of a
+// call to this package or to len.
+func leak(expr ast.Expr) string {
+	found := ""
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if found != "" {
+			return false
+		}
+		switch node := n.(type) {
+		case *ast.CallExpr:
+			if pkg, _ := callee(node); pkg == "redact" {
+				return false
+			}
+			if fn, ok := node.Fun.(*ast.Ident); ok && fn.Name == "len" {
+				return false
+			}
+		case *ast.Ident:
+			if sensitive[strings.ToLower(node.Name)] {
+				found = node.Name
+			}
+		}
+		return true
+	})
+	return found
+}
+
+func dumpsValues(expr ast.Expr) bool {
+	literal, ok := expr.(*ast.BasicLit)
+	if !ok || literal.Kind != token.STRING {
+		return false
+	}
+	format, err := strconv.Unquote(literal.Value)
+	if err != nil {
+		return false
+	}
+	return strings.Contains(format, "%#v")
+}
+
+// CheckFile reports log calls which pass sensitive values without redacting
+// them or dump whole values with %#v, as those may carry keys and
+// signatures.
+func CheckFile(path string) ([]Finding, error) {
+	files := token.NewFileSet()
+	file, err := parser.ParseFile(files, path, nil, parser.ParseComments)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse %s", path)
+	}
+	allowed := map[int]bool{}
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			if strings.Contains(comment.Text, Allow) {
+				allowed[files.Position(comment.Pos()).Line] = true
+			}
+		}
+	}
+	var result []Finding
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		pkg, name := callee(call)
+		position := files.Position(call.Pos())
+		if allowed[position.Line] {
+			return true
+		}
+		if pkg != "log" || !logFunctions[name] {
+			return true
+		}
+		if dumpsValues(call.Args[0]) {
+			result = append(result, Finding{Position: position, Reason: "log format dumps whole values"})
+		}
+		for _, arg := range call.Args {
+			if field := leak(arg); field != "" {
+				result = append(result, Finding{Position: position, Reason: fmt.Sprintf("%s is logged without redaction", field)})
+				break
+			}
+		}
+		return true
+	})
+	return result, nil
+}
+
+// Check checks every go file under the root, skipping vendored code.
+func Check(root string) ([]Finding, error) {
+	var result []Finding
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if name := info.Name(); path != root && (name == "vendor" || strings.HasPrefix(name, ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") {
+			return nil
+		}
+		findings, err := CheckFile(path)
+		if err != nil {
+			return err
+		}
+		result = append(result, findings...)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to check %s", root)
+	}
+	return result, nil
+}
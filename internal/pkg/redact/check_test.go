@@ -0,0 +1,69 @@
+package redact
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestSourcesDontLeak guards the module the way the log-check command does.
+func TestSourcesDontLeak(t *testing.T) {
+	root, err := filepath.Abs("../../..")
+	if err != nil {
+		t.Fatalf("Failed to resolve module root %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "go.mod")); err != nil {
+		t.Fatalf("Module root %s has no go.mod %s", root, err)
+	}
+	findings, err := Check(root)
+	if err != nil {
+		t.Fatalf("Failed to check sources %s", err)
+	}
+	for _, f := range findings {
+		t.Errorf("%s, wrap it with redact or mark a public value with // %s", f, Allow)
+	}
+}
+
+func TestCheckFile(t *testing.T) {
+	source := `package sample
+
+import "log"
+
+func sample(address string, signature []byte, party string, v interface{}) {
+	log.Printf("Vote of %s", address)
+	log.Println("Signed", signature)
+	log.Printf("Vote of %s", redact.Address(address))
+	log.Printf("Signature of %d bytes", len(signature))
+	log.Printf("Party %s", address) // redact:public
+	log.Printf("Value %#v", v)
+	log.Printf("Party %s", party)
+}
+`
+	dir, err := ioutil.TempDir("", "redact")
+	if err != nil {
+		t.Fatalf("Failed to create directory %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sample.go")
+	if err := ioutil.WriteFile(path, []byte(source), 0600); err != nil {
+		t.Fatalf("Failed to write source %s", err)
+	}
+	findings, err := CheckFile(path)
+	if err != nil {
+		t.Fatalf("Failed to check file %s", err)
+	}
+	expected := map[int]string{
+		6:  "address is logged without redaction",
+		7:  "signature is logged without redaction",
+		11: "log format dumps whole values",
+	}
+	if len(findings) != len(expected) {
+		t.Fatalf("Expected %d findings, got %v", len(expected), findings)
+	}
+	for _, f := range findings {
+		if reason := expected[f.Position.Line]; reason != f.Reason {
+			t.Errorf("Expected %q at line %d, got %q", reason, f.Position.Line, f.Reason)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// Policy decides how sensitive values, voter addresses, public key hashes
+// and signatures, look in the logs.
+type Policy string
+
+const (
+	// Hash replaces the value with a short fingerprint, the same value always
+	// gets the same fingerprint so log lines can still be correlated.
+	Hash Policy = "hash"
+	// Truncate keeps the first characters of the value.
+	Truncate Policy = "truncate"
+	// Omit leaves the value out.
+	Omit Policy = "omit"
+	// Off logs identifiers in plaintext, signatures are still hashed.
+	Off Policy = "off"
+)
+
+const (
+	fingerprintBytes = 6
+	truncatedChars   = 8
+	omitted          = "[redacted]"
+)
+
+var policy atomic.Value
+
+func init() {
+	policy.Store(Hash)
+}
+
+func ParsePolicy(raw string) (Policy, error) {
+	switch p := Policy(strings.ToLower(raw)); p {
+	case Hash, Truncate, Omit, Off:
+		return p, nil
+	default:
+		return "", errors.Errorf("Unknown redaction policy %s", raw)
+	}
+}
+
+func SetPolicy(p Policy) {
+	policy.Store(p)
+}
+
+func Current() Policy {
+	return policy.Load().(Policy)
+}
+
+func apply(p Policy, value string, raw []byte) string {
+	switch p {
+	case Off:
+		return value
+	case Omit:
+		return omitted
+	case Truncate:
+		if len(value) <= truncatedChars {
+			return value
+		}
+		return value[:truncatedChars] + "…"
+	default:
+		sum := sha256.Sum256(raw)
+		return "h:" + hex.EncodeToString(sum[:fingerprintBytes])
+	}
+}
+
+// Address redacts an address or any other identifier of a voter.
+func Address(address string) string {
+	return apply(Current(), address, []byte(address))
+}
+
+// Key redacts a public key or a public key hash, printed in hex.
+func Key(key []byte) string {
+	return apply(Current(), hex.EncodeToString(key), key)
+}
+
+// Signature redacts a raw signature, which is never logged in plaintext.
+func Signature(signature []byte) string {
+	p := Current()
+	if p == Off {
+		p = Hash
+	}
+	return apply(p, hex.EncodeToString(signature), signature)
+}
+
+var (
+	privateKeyBlock = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)
+	signatureField  = regexp.MustCompile(`(?i)("?signature"?\s*[:=]\s*)("[^"]*"|[0-9a-f]{16,}|[A-Za-z0-9+/_-]{16,}={0,2})`)
+)
+
+// Scrub masks private keys in PEM and signature fields in JSON or in the
+// key: value form which made it into a line despite the helpers.
+func Scrub(line []byte) []byte {
+	line = privateKeyBlock.ReplaceAll(line, []byte("[private key redacted]"))
+	return signatureField.ReplaceAll(line, []byte("${1}"+omitted))
+}
+
+type writer struct {
+	out io.Writer
+}
+
+func (w writer) Write(p []byte) (int, error) {
+	if _, err := w.out.Write(Scrub(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Writer scrubs everything written to out.
+func Writer(out io.Writer) io.Writer {
+	return writer{out: out}
+}
+
+// Install sets the policy and scrubs the standard logger as a safety net.
+func Install(p Policy) {
+	SetPolicy(p)
+	log.SetOutput(Writer(os.Stderr))
+}
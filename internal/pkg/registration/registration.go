@@ -0,0 +1,30 @@
+package registration
+
+import (
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+var ErrAlreadyRegistered = errors.New("Voter is already registered")
+
+type Registration struct {
+	Address            string `json:"address"`
+	MemberID           string `json:"memberId"`
+	Provider           string `json:"provider"`
+	RegisteredAt       int64  `json:"registeredAt"`
+	FundingTransaction []byte `json:"fundingTransaction,omitempty"`
+}
+
+type Registrations []Registration
+
+func (r Registration) Funded() bool {
+	return len(r.FundingTransaction) > 0
+}
+
+type SaveFn func(Registration) error
+
+type GetPendingFn func(limit int) (Registrations, error)
+
+// FundFn submits the transaction giving a vote to every registration and
+// marks them as funded.
+type FundFn func(t transaction.Transaction, registrations Registrations) error
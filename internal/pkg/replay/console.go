@@ -0,0 +1,128 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+)
+
+const consoleHelp = `Commands:
+  c              continue to the next breakpoint
+  s              stop before the next message
+  q              quit the replay
+  m              print the message
+  p              print the answer to the message
+  tip            print the tip of the blockchain
+  block <height> print the block at the height
+  tx <id>        print the transaction with the hex encoded id
+`
+
+func printJSON(out io.Writer, v interface{}) {
+	raw, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintf(out, "Failed to print %s\n", err)
+		return
+	}
+	fmt.Fprintf(out, "%s\n", raw)
+}
+
+// Console lets the operator inspect the state of the node at a stop.
+func Console(in io.Reader, out io.Writer, getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, getTransactions transaction.GetTransactionsFn) InspectFn {
+	scanner := bufio.NewScanner(in)
+	findTransaction := blockchain.FindTransaction(blockchain.FindBlock(getTip, getBlock))
+	blockAt := func(height int) (*blockchain.Block, error) {
+		var blocks []blockchain.Block
+		for current := getTip(); current != nil; {
+			block, err := getBlock(current)
+			if err != nil || block == nil {
+				return nil, fmt.Errorf("Failed to get block %x %s", current, err)
+			}
+			blocks = append(blocks, *block)
+			current = block.Header.Prev
+		}
+		if height < 1 || height > len(blocks) {
+			return nil, fmt.Errorf("Blockchain has %d blocks", len(blocks))
+		}
+		return &blocks[len(blocks)-height], nil
+	}
+	return func(stop Stop) Action {
+		fmt.Fprintf(out, "Stopped at message %d %s from %s: %s, height %d\n", stop.Entry.Sequence, stop.Entry.Ping.Message, stop.Entry.Connection, stop.Reason, stop.Height)
+		for {
+			fmt.Fprint(out, "(replay) ")
+			if !scanner.Scan() {
+				return Quit
+			}
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 {
+				continue
+			}
+			switch fields[0] {
+			case "c":
+				return Continue
+			case "s":
+				return Step
+			case "q":
+				return Quit
+			case "m":
+				printJSON(out, stop.Entry.Ping)
+			case "p":
+				if stop.Pong == nil {
+					fmt.Fprintln(out, "Message is not handled yet or has no answer")
+				} else {
+					printJSON(out, stop.Pong)
+				}
+			case "tip":
+				fmt.Fprintf(out, "%x\n", getTip())
+			case "block":
+				height := 0
+				if len(fields) == 2 {
+					height, _ = strconv.Atoi(fields[1])
+				}
+				block, err := blockAt(height)
+				if err != nil {
+					fmt.Fprintln(out, err)
+					continue
+				}
+				printJSON(out, block)
+			case "tx":
+				if len(fields) != 2 {
+					fmt.Fprint(out, consoleHelp)
+					continue
+				}
+				id, err := hex.DecodeString(fields[1])
+				if err != nil {
+					fmt.Fprintln(out, "Invalid transaction id")
+					continue
+				}
+				if t, found, err := findTransaction(id); err == nil && found {
+					fmt.Fprintln(out, "In the blockchain")
+					printJSON(out, t)
+					continue
+				}
+				pending, err := getTransactions()
+				if err != nil {
+					fmt.Fprintf(out, "Failed to retrieve pending transactions %s\n", err)
+					continue
+				}
+				if t, ok := pending.Find(func(t transaction.Transaction) bool {
+					return bytes.Equal(t.ID, id)
+				}); ok {
+					fmt.Fprintln(out, "Pending")
+					printJSON(out, t)
+					continue
+				}
+				fmt.Fprintln(out, "Transaction is unknown")
+			default:
+				fmt.Fprint(out, consoleHelp)
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+// Header is the first line of a recording. Tip and height are the state of
+// the database the recording starts from, the snapshot it is replayed
+// against has to be at the same tip.
+type Header struct {
+	Tip       []byte `json:"tip"`
+	Height    int    `json:"height"`
+	StartedAt int64  `json:"startedAt"`
+}
+
+// Entry is an inbound message together with the internal id of the
+// connection it came over.
+type Entry struct {
+	Sequence   int            `json:"sequence"`
+	At         int64          `json:"at"`
+	Connection string         `json:"connection"`
+	Ping       websocket.Ping `json:"ping"`
+}
+
+type Recording struct {
+	Header  Header
+	Entries []Entry
+}
+
+// Recorder appends every routed inbound message to a file, one JSON object
+// per line. Messages are written before they are handled, so a message
+// which crashes the node is recorded too.
+type Recorder struct {
+	lock     *sync.Mutex
+	file     *os.File
+	encoder  *json.Encoder
+	sequence int
+}
+
+func NewRecorder(path string, tip []byte, height int) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create recording %s", path)
+	}
+	encoder := json.NewEncoder(file)
+	if err := encoder.Encode(Header{Tip: tip, Height: height, StartedAt: time.Now().Unix()}); err != nil {
+		file.Close()
+		return nil, errors.Wrapf(err, "Failed to write header of recording %s", path)
+	}
+	return &Recorder{
+		lock:    &sync.Mutex{},
+		file:    file,
+		encoder: encoder,
+	}, nil
+}
+
+func (r *Recorder) record(ping websocket.Ping, id string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.sequence++
+	entry := Entry{
+		Sequence:   r.sequence,
+		At:         time.Now().UnixNano(),
+		Connection: id,
+		Ping:       ping,
+	}
+	if err := r.encoder.Encode(entry); err != nil {
+		log.Printf("Failed to record message %d %s", r.sequence, err)
+	}
+}
+
+// Wrap records the messages of the router before handling them.
+func (r *Recorder) Wrap(router websocket.Router) websocket.Router {
+	result := websocket.Router{}
+	for message, handler := range router {
+		h := handler
+		result[message] = func(ping websocket.Ping, id string) (*websocket.Pong, error) {
+			r.record(ping, id)
+			return h(ping, id)
+		}
+	}
+	return result
+}
+
+func (r *Recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.file.Close()
+}
+
+// Read reads a recording made by the recorder.
+func Read(path string) (*Recording, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open recording %s", path)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), websocket.MaxMessageBytes*2)
+	var result Recording
+	var truncated error
+	for line := 1; scanner.Scan(); line++ {
+		if truncated != nil {
+			return nil, truncated
+		}
+		if line == 1 {
+			if err := json.Unmarshal(scanner.Bytes(), &result.Header); err != nil {
+				return nil, errors.Wrapf(err, "Failed to parse header of recording %s", path)
+			}
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			truncated = errors.Wrapf(err, "Failed to parse line %d of recording %s", line, path)
+			continue
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "Failed to read recording %s", path)
+	}
+	// The last line is cut short if the node crashed while writing it.
+	if truncated != nil {
+		log.Printf("Ignoring the last line of the recording %s", truncated)
+	}
+	return &result, nil
+}
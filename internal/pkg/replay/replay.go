@@ -0,0 +1,187 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+// Breakpoints stop the replay once the blockchain reaches one of the
+// heights and before a message carrying one of the transactions, given by
+// hex encoded ids, is handled.
+type Breakpoints struct {
+	Heights      map[int]bool
+	Transactions map[string]bool
+}
+
+// ParseBreakpoints parses comma separated heights and transaction ids.
+func ParseBreakpoints(heights, transactions string) (Breakpoints, error) {
+	result := Breakpoints{Heights: map[int]bool{}, Transactions: map[string]bool{}}
+	for _, raw := range strings.Split(heights, ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		height, err := strconv.Atoi(raw)
+		if err != nil || height < 1 {
+			return Breakpoints{}, errors.Errorf("Invalid breakpoint height %s", raw)
+		}
+		result.Heights[height] = true
+	}
+	for _, raw := range strings.Split(transactions, ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		if _, err := hex.DecodeString(raw); err != nil {
+			return Breakpoints{}, errors.Errorf("Invalid breakpoint transaction %s", raw)
+		}
+		result.Transactions[strings.ToLower(raw)] = true
+	}
+	return result, nil
+}
+
+// Transactions returns the transactions the message carries, the one
+// received, the ones of the forged block or the ones sent for a compact
+// block.
+func Transactions(ping websocket.Ping) transaction.Transactions {
+	switch ping.Message {
+	case websocket.TransactionReceivedMessage:
+		var body websocket.SaveTransactionBody
+		if json.Unmarshal(ping.Body, &body) == nil {
+			return transaction.Transactions{body.Transaction}
+		}
+	case websocket.BlockForgedMessage:
+		var body struct {
+			Block blockchain.Block `json:"block"`
+		}
+		if json.Unmarshal(ping.Body, &body) == nil {
+			return body.Block.Body.Transactions
+		}
+	case websocket.CompactBlockMessage:
+		var body struct {
+			Block blockchain.CompactBlock `json:"block"`
+		}
+		if json.Unmarshal(ping.Body, &body) == nil {
+			var result transaction.Transactions
+			for _, p := range body.Block.Prefilled {
+				result = append(result, p.Transaction)
+			}
+			return result
+		}
+	case websocket.BlockTransactionsMessage:
+		var body blockchain.BlockTransactionsBody
+		if json.Unmarshal(ping.Body, &body) == nil {
+			return body.Transactions
+		}
+	}
+	return nil
+}
+
+type Action int
+
+const (
+	Continue Action = iota
+	Step
+	Quit
+)
+
+// Stop is where the replay stopped. Pong is the answer to the entry if it
+// was already handled.
+type Stop struct {
+	Entry  Entry
+	Reason string
+	Height int
+	Pong   *websocket.Pong
+}
+
+type InspectFn func(Stop) Action
+
+// SnapshotFn copies the database of a running node to the path.
+type SnapshotFn func(path string) error
+
+// CopySnapshot copies the database the recording is replayed against, so
+// the snapshot stays as it is and the replay can be repeated.
+func CopySnapshot(snapshot, copy string) error {
+	source, err := os.Open(snapshot)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to open snapshot %s", snapshot)
+	}
+	defer source.Close()
+	target, err := os.OpenFile(copy, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create %s", copy)
+	}
+	defer target.Close()
+	if _, err := io.Copy(target, source); err != nil {
+		return errors.Wrapf(err, "Failed to copy snapshot %s to %s", snapshot, copy)
+	}
+	return nil
+}
+
+// Run handles the recorded messages one by one with the router. Answers
+// are printed instead of being sent and broadcasts go nowhere, so the state
+// of the database depends only on the snapshot and the recording.
+func Run(recording Recording, router websocket.Router, getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, breakpoints Breakpoints, inspect InspectFn) error {
+	if current := getTip(); !bytes.Equal(current, recording.Header.Tip) {
+		return errors.Errorf("Snapshot is at %x, the recording starts at %x", current, recording.Header.Tip)
+	}
+	height := recording.Header.Height
+	tip := recording.Header.Tip
+	stepping := false
+	for _, entry := range recording.Entries {
+		reason := ""
+		if stepping {
+			reason = "step"
+		}
+		for _, t := range Transactions(entry.Ping) {
+			if breakpoints.Transactions[hex.EncodeToString(t.ID)] {
+				reason = fmt.Sprintf("message carries transaction %x", t.ID)
+			}
+		}
+		if reason != "" {
+			switch inspect(Stop{Entry: entry, Reason: reason, Height: height}) {
+			case Quit:
+				return nil
+			case Step:
+				stepping = true
+			default:
+				stepping = false
+			}
+		}
+		log.Printf("Replaying message %d %s from %s", entry.Sequence, entry.Ping.Message, entry.Connection)
+		pong := router.Route(entry.Ping, entry.Connection)
+		if pong != nil && pong.Message != websocket.NoActionMessage {
+			log.Printf("Message %d answered with %s", entry.Sequence, pong.Message)
+		}
+		if bytes.Equal(getTip(), tip) {
+			continue
+		}
+		tip = getTip()
+		current, err := blockchain.GetHeight(getTip, getBlock)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to get height after message %d", entry.Sequence)
+		}
+		if current != height && breakpoints.Heights[current] {
+			stepping = false
+			switch inspect(Stop{Entry: entry, Reason: fmt.Sprintf("blockchain reached height %d", current), Height: current, Pong: pong}) {
+			case Quit:
+				return nil
+			case Step:
+				stepping = true
+			}
+		}
+		height = current
+	}
+	log.Printf("Replayed %d messages, blockchain is at height %d", len(recording.Entries), height)
+	return nil
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/access"
+	"github.com/pkg/errors"
+)
+
+func adminCredentialsBucket() []byte {
+	return []byte("admin_credentials")
+}
+
+func SaveAdminCredential(db *bolt.DB) access.SaveFn {
+	return func(c access.Credential) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists(adminCredentialsBucket())
+			if err != nil {
+				return errors.Wrapf(err, "Failed to create bucket %s", adminCredentialsBucket())
+			}
+			raw, err := json.Marshal(c)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to serialize admin credential %s", c.ID)
+			}
+			if err := b.Put([]byte(c.ID), raw); err != nil {
+				return errors.Wrapf(err, "Failed to save admin credential %s", c.ID)
+			}
+			return nil
+		})
+	}
+}
+
+func GetAdminCredential(db *bolt.DB) access.GetFn {
+	return func(id string) (*access.Credential, error) {
+		var result *access.Credential
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(adminCredentialsBucket())
+			if b == nil {
+				return nil
+			}
+			raw := b.Get([]byte(id))
+			if raw == nil {
+				return nil
+			}
+			var c access.Credential
+			if err := json.Unmarshal(raw, &c); err != nil {
+				return errors.Wrapf(err, "Failed to unmarshal admin credential %s", id)
+			}
+			result = &c
+			return nil
+		})
+		return result, err
+	}
+}
+
+func GetAdminCredentials(db *bolt.DB) access.ListFn {
+	return func() (access.Credentials, error) {
+		result := access.Credentials{}
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(adminCredentialsBucket())
+			if b == nil {
+				return nil
+			}
+			return b.ForEach(func(key, value []byte) error {
+				var c access.Credential
+				if err := json.Unmarshal(value, &c); err != nil {
+					return errors.Wrapf(err, "Failed to unmarshal admin credential %s", key)
+				}
+				result = append(result, c.Public())
+				return nil
+			})
+		})
+		return result, err
+	}
+}
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/anchor"
+	"github.com/pkg/errors"
+)
+
+func anchorsBucket() []byte {
+	return []byte("anchors")
+}
+
+func anchorKey(a anchor.Anchor) []byte {
+	return bytes.Join([][]byte{a.BlockHash, []byte(a.Service)}, []byte("|"))
+}
+
+func SaveAnchor(db *bolt.DB) anchor.SaveAnchorFn {
+	return func(a anchor.Anchor) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists(anchorsBucket())
+			if err != nil {
+				return errors.Wrapf(err, "Failed to create bucket %s", anchorsBucket())
+			}
+			raw, err := json.Marshal(a)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to serialize anchor %#v", a)
+			}
+			if err := b.Put(anchorKey(a), raw); err != nil {
+				return errors.Wrapf(err, "Failed to save anchor for block %x", a.BlockHash)
+			}
+			return nil
+		})
+	}
+}
+
+func GetAnchors(db *bolt.DB) anchor.GetAnchorsFn {
+	return func(blockHash []byte) (anchor.Anchors, error) {
+		result := anchor.Anchors{}
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(anchorsBucket())
+			if b == nil {
+				return nil
+			}
+			c := b.Cursor()
+			prefix := append(append([]byte{}, blockHash...), '|')
+			for key, value := c.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = c.Next() {
+				var a anchor.Anchor
+				if err := json.Unmarshal(value, &a); err != nil {
+					return errors.Wrapf(err, "Failed to unmarshal anchor %s", value)
+				}
+				result = append(result, a)
+			}
+			return nil
+		})
+		return result, err
+	}
+}
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/pkg/errors"
+)
+
+func blockAnnotationsBucket() []byte {
+	return []byte("block_annotations")
+}
+
+// SaveBlockAnnotation keeps the annotations of a block under its hash, a
+// block has at most one annotation of a node.
+func SaveBlockAnnotation(db *bolt.DB) blockchain.SaveAnnotationFn {
+	return func(a blockchain.Annotation) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists(blockAnnotationsBucket())
+			if err != nil {
+				return errors.Wrapf(err, "Failed to create bucket %s", blockAnnotationsBucket())
+			}
+			raw, err := json.Marshal(a)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to serialize annotation of block %x", a.Block)
+			}
+			key := append(append([]byte{}, a.Block...), []byte(a.NodeID)...)
+			if err := b.Put(key, raw); err != nil {
+				return errors.Wrapf(err, "Failed to save annotation of block %x", a.Block)
+			}
+			return nil
+		})
+	}
+}
+
+func GetBlockAnnotations(db *bolt.DB) blockchain.GetAnnotationsFn {
+	return func() (blockchain.Annotations, error) {
+		result := blockchain.Annotations{}
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(blockAnnotationsBucket())
+			if b == nil {
+				return nil
+			}
+			return b.ForEach(func(key, value []byte) error {
+				var a blockchain.Annotation
+				if err := json.Unmarshal(value, &a); err != nil {
+					return errors.Wrapf(err, "Failed to unmarshal annotation %x", key)
+				}
+				result = append(result, a)
+				return nil
+			})
+		})
+		return result, err
+	}
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/audit"
+	"github.com/pkg/errors"
+)
+
+func auditBucket() []byte {
+	return []byte("audit")
+}
+
+func RecordAudit(db *bolt.DB) audit.RecordFn {
+	return func(action, details string) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists(auditBucket())
+			if err != nil {
+				return errors.Wrapf(err, "Failed to create bucket %s", auditBucket())
+			}
+			var prevHash []byte
+			if _, last := b.Cursor().Last(); last != nil {
+				var prev audit.Entry
+				if err := json.Unmarshal(last, &prev); err != nil {
+					return errors.Wrap(err, "Failed to unmarshal last audit entry")
+				}
+				prevHash = prev.Hash
+			}
+			sequence, err := b.NextSequence()
+			if err != nil {
+				return errors.Wrap(err, "Failed to generate audit entry sequence")
+			}
+			entry := audit.Entry{
+				Sequence:  sequence,
+				Timestamp: time.Now().Unix(),
+				Action:    action,
+				Details:   details,
+				PrevHash:  prevHash,
+			}
+			entry.Hash = entry.ComputeHash()
+			raw, err := json.Marshal(entry)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to serialize audit entry %#v", entry)
+			}
+			if err := b.Put(sequenceKey(sequence), raw); err != nil {
+				return errors.Wrapf(err, "Failed to save audit entry %d", sequence)
+			}
+			return nil
+		})
+	}
+}
+
+func GetAuditLog(db *bolt.DB) audit.GetEntriesFn {
+	return func() (audit.Entries, error) {
+		result := audit.Entries{}
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(auditBucket())
+			if b == nil {
+				return nil
+			}
+			return b.ForEach(func(key, value []byte) error {
+				var entry audit.Entry
+				if err := json.Unmarshal(value, &entry); err != nil {
+					return errors.Wrapf(err, "Failed to unmarshal audit entry %x", key)
+				}
+				result = append(result, entry)
+				return nil
+			})
+		})
+		return result, err
+	}
+}
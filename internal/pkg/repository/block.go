@@ -2,6 +2,7 @@ package repository
 
 import (
 	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
 	"github.com/nebser/crypto-vote/internal/pkg/transaction"
 )
 
@@ -9,6 +10,7 @@ type block struct {
 	MagicNumber      int                      `json:"magicNumber"`
 	Size             int                      `json:"blockSize"`
 	Version          int                      `json:"versionNumber"`
+	Algorithm        digest.Algorithm         `json:"algorithm,omitempty"`
 	PrevBlock        []byte                   `json:"prevBlock"`
 	TransactionHash  []byte                   `json:"transactionHash"`
 	Timestamp        int64                    `json:"timestamp"`
@@ -29,6 +31,7 @@ func (b block) toBlock() blockchain.Block {
 			Timestamp:       b.Timestamp,
 			TransactionHash: b.TransactionHash,
 			Version:         b.Version,
+			Algorithm:       b.Algorithm,
 		},
 		Body: blockchain.Body{
 			Transactions:      b.Transactions,
@@ -42,6 +45,7 @@ func newBlock(b blockchain.Block) block {
 		MagicNumber:      b.Metadata.MagicNumber,
 		Size:             b.Metadata.Size,
 		Version:          b.Header.Version,
+		Algorithm:        b.Header.Algorithm,
 		PrevBlock:        b.Header.Prev,
 		TransactionHash:  b.Header.TransactionHash,
 		Timestamp:        b.Header.Timestamp,
@@ -1,10 +1,11 @@
 package repository
 
 import (
-	"encoding/json"
+	"bytes"
 
 	"github.com/boltdb/bolt"
 	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
 	"github.com/nebser/crypto-vote/internal/pkg/transaction"
 	"github.com/pkg/errors"
 )
@@ -17,11 +18,15 @@ func tipKey() []byte {
 	return []byte("l")
 }
 
+// GetTip copies the tip out of the database, bolt reuses the memory of a
+// value once the transaction which read it is over.
 func GetTip(db *bolt.DB) blockchain.GetTipFn {
 	return func() []byte {
 		var tip []byte
 		db.View(func(tx *bolt.Tx) error {
-			tip = getTip(tx)
+			if raw := getTip(tx); raw != nil {
+				tip = append([]byte{}, raw...)
+			}
 			return nil
 		})
 		return tip
@@ -44,16 +49,16 @@ func InitBlockchain(db *bolt.DB) blockchain.InitBlockchainFn {
 			if err != nil {
 				return errors.Wrap(err, "Failed to create blocks bucket")
 			}
-			rawBlock, err := json.Marshal(newBlock(genesis))
-			if err != nil {
-				return errors.Wrapf(err, "Failed to marshal block %#v", genesis)
-			}
+			rawBlock := encodeBlock(newBlock(genesis))
 			if err := b.Put(genesis.Header.Hash, rawBlock); err != nil {
 				return errors.Wrap(err, "Failed to put genesis block")
 			}
 			if err := b.Put(tipKey(), genesis.Header.Hash); err != nil {
 				return errors.Wrap(err, "Failed to update tip")
 			}
+			if _, err := indexHeight(tx, genesis.Header.Hash); err != nil {
+				return errors.Wrap(err, "Failed to index genesis block")
+			}
 			tip = genesis.Header.Hash
 			return nil
 		})
@@ -71,10 +76,16 @@ func addBlock(tx *bolt.Tx, block blockchain.Block) ([]byte, error) {
 		}
 		b = created
 	}
-	rawBlock, err := json.Marshal(newBlock(block))
-	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to marshal block %#v", block)
+	if parent := b.Get(block.Header.Prev); len(block.Header.Prev) > 0 && parent != nil {
+		algorithm, err := algorithmOf(parent)
+		if err != nil {
+			return nil, err
+		}
+		if algorithm != block.HashAlgorithm() {
+			return nil, errors.Wrapf(blockchain.ErrInvalidBlock, "Block %x is hashed with %s but its parent with %s", block.Header.Hash, block.HashAlgorithm(), algorithm)
+		}
 	}
+	rawBlock := encodeBlock(newBlock(block))
 	if err := b.Put(block.Header.Hash, rawBlock); err != nil {
 		return nil, errors.Wrapf(err, "Failed to put block %#v", block)
 	}
@@ -84,11 +95,30 @@ func addBlock(tx *bolt.Tx, block blockchain.Block) ([]byte, error) {
 	return block.Header.Hash, nil
 }
 
+// algorithmOf returns the algorithm the stored block is hashed with, every
+// block has to be hashed with the algorithm of its parent.
+func algorithmOf(raw []byte) (digest.Algorithm, error) {
+	serialized, err := decodeBlock(raw)
+	if err != nil {
+		return "", err
+	}
+	return serialized.toBlock().HashAlgorithm(), nil
+}
+
+// AddBlock and AddNewBlock go through bolt's Batch, so blocks arriving at once
+// are committed together. Batch runs a function again when another one in its
+// batch fails, so the functions only write through the bolt transaction, which
+// is rolled back, and set their results anew on every run.
 func AddBlock(db *bolt.DB) blockchain.AddBlockFn {
 	return func(block blockchain.Block) ([]byte, error) {
 		var tip []byte
-		err := db.Update(func(tx *bolt.Tx) error {
-			created, err := addBlockWithUTXO(tx, block)
+		err := db.Batch(func(tx *bolt.Tx) error {
+			tip = nil
+			spent, err := spentUTXOs(tx, block.Body.Transactions)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to get utxos spent by block %x", block.Header.Hash)
+			}
+			created, err := addBlockWithUTXO(tx, block, spent)
 			if err != nil {
 				return errors.Wrapf(err, "Failed to add block %s", block)
 			}
@@ -99,18 +129,36 @@ func AddBlock(db *bolt.DB) blockchain.AddBlockFn {
 	}
 }
 
-func addBlockWithUTXO(tx *bolt.Tx, block blockchain.Block) ([]byte, error) {
+// addBlockWithUTXO adds the block and its outputs to the utxo set. Spent are
+// the outputs the block consumed, they are kept in the undo record.
+func addBlockWithUTXO(tx *bolt.Tx, block blockchain.Block, spent transaction.UTXOs) ([]byte, error) {
 	tip, err := addBlock(tx, block)
 	if err != nil {
 		return nil, err
 	}
-	for _, transaction := range block.Body.Transactions {
-		if err := deleteTransaction(tx, transaction); err != nil {
+	for _, t := range block.Body.Transactions {
+		if err := deleteTransaction(tx, t); err != nil {
 			return nil, err
 		}
-		if err := saveUTXOs(tx, transaction.UTXOs()); err != nil {
+		if err := saveUTXOs(tx, t.UTXOs()); err != nil {
 			return nil, err
 		}
+		if err := recordSpends(tx, t, transaction.SourceBlock, block.Header.Hash); err != nil {
+			return nil, errors.Wrap(err, "Failed to record spends")
+		}
+	}
+	if err := indexCredits(tx, block.Body.Transactions, false); err != nil {
+		return nil, errors.Wrap(err, "Failed to index credits of voters")
+	}
+	height, err := indexHeight(tx, tip)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to index block height")
+	}
+	if err := snapshotUTXOs(tx, tip, height); err != nil {
+		return nil, errors.Wrap(err, "Failed to snapshot utxo set")
+	}
+	if err := saveUndo(tx, block, height, spent); err != nil {
+		return nil, err
 	}
 	return tip, nil
 }
@@ -127,9 +175,9 @@ func GetBlock(db *bolt.DB) blockchain.GetBlockFn {
 			if rawBlock == nil {
 				return nil
 			}
-			var serialized block
-			if err := json.Unmarshal(rawBlock, &serialized); err != nil {
-				return errors.Wrapf(err, "Failed to unmarshal serialized block %s", rawBlock)
+			serialized, err := decodeBlock(rawBlock)
+			if err != nil {
+				return err
 			}
 			bl := serialized.toBlock()
 			result = &bl
@@ -139,36 +187,47 @@ func GetBlock(db *bolt.DB) blockchain.GetBlockFn {
 	}
 }
 
-func verifyTransactions(tx *bolt.Tx, transactions transaction.Transactions) (transaction.Transactions, transaction.Transactions, error) {
+// verifyTransactions splits transactions into valid and invalid ones. Valid
+// transactions which would push the valid ones over maxBytes are in neither,
+// so they are left for the next block. The outputs spent by the valid
+// transactions are removed from the utxo set and returned.
+func verifyTransactions(tx *bolt.Tx, transactions transaction.Transactions, maxBytes int) (transaction.Transactions, transaction.Transactions, transaction.UTXOs, error) {
 	var valids transaction.Transactions
 	var invalids transaction.Transactions
+	var spent transaction.UTXOs
+	size := 0
 	for _, t := range transactions {
 		sum, err := getInputSum(tx, t)
 		switch {
 		case errors.Is(err, transaction.ErrUTXONotFound):
 			invalids = append(invalids, t)
 		case err != nil:
-			return nil, nil, errors.Wrapf(err, "Failed to get sum of inputs for transaction %s", t)
+			return nil, nil, nil, errors.Wrapf(err, "Failed to get sum of inputs for transaction %s", t)
 		case t.Outputs.Sum() != sum:
 			invalids = append(invalids, t)
+		case size+t.Size() > maxBytes:
+			continue
 		default:
 			valids = append(valids, t)
-			if err := deleteTransactionUTXOs(tx, t); err != nil {
-				return nil, nil, errors.Wrapf(err, "Failed to delete candidate transaction from utxo set %s", t)
+			size += t.Size()
+			consumed, err := spentUTXOs(tx, transaction.Transactions{t})
+			if err != nil {
+				return nil, nil, nil, errors.Wrapf(err, "Failed to get utxos spent by transaction %s", t)
 			}
-			if len(valids) == blockchain.MaxBlockSize {
-				break
+			spent = append(spent, consumed...)
+			if err := deleteTransactionUTXOs(tx, t); err != nil {
+				return nil, nil, nil, errors.Wrapf(err, "Failed to delete candidate transaction from utxo set %s", t)
 			}
 		}
 	}
-	return valids, invalids, nil
+	return valids, invalids, spent, nil
 }
 
-func ForgeBlock(db *bolt.DB) blockchain.ForgeBlockFn {
+func ForgeBlock(db *bolt.DB, order transaction.OrderTransactionsFn) blockchain.ForgeBlockFn {
 	return func(txs transaction.Transactions) (*blockchain.Block, error) {
 		var block *blockchain.Block
 		err := db.Update(func(tx *bolt.Tx) error {
-			valids, invalids, err := verifyTransactions(tx, txs)
+			valids, invalids, spent, err := verifyTransactions(tx, txs, blockchain.MaxTransactionsBytes)
 			if err != nil {
 				return err
 			}
@@ -179,11 +238,16 @@ func ForgeBlock(db *bolt.DB) blockchain.ForgeBlockFn {
 				return nil
 			}
 			tip := getTip(tx)
-			newBlock, err := blockchain.NewBlock(tip, valids)
+			algorithm, err := algorithmOf(tx.Bucket(blocksBucket()).Get(tip))
+			if err != nil {
+				return errors.Wrapf(err, "Failed to get algorithm of tip %x", tip)
+			}
+			ordered := append(transaction.Transactions{valids[0]}, order(tip, valids[1:])...)
+			newBlock, err := blockchain.NewBlock(algorithm, tip, ordered)
 			if err != nil {
 				return errors.Wrap(err, "Failed to set up new block")
 			}
-			if _, err := addBlockWithUTXO(tx, *newBlock); err != nil {
+			if _, err := addBlockWithUTXO(tx, *newBlock, spent); err != nil {
 				return errors.Wrap(err, "Failed to add block to database")
 			}
 			block = newBlock
@@ -195,15 +259,21 @@ func ForgeBlock(db *bolt.DB) blockchain.ForgeBlockFn {
 
 func AddNewBlock(db *bolt.DB) blockchain.AddNewBlockFn {
 	return func(block blockchain.Block) error {
-		return db.Update(func(tx *bolt.Tx) error {
-			_, invalids, err := verifyTransactions(tx, block.Body.Transactions)
+		if block.Size() > blockchain.MaxBlockBytes {
+			return blockchain.ErrInvalidBlock
+		}
+		return db.Batch(func(tx *bolt.Tx) error {
+			if tip := getTip(tx); !bytes.Equal(block.Header.Prev, tip) {
+				return errors.Wrapf(blockchain.ErrStaleBlock, "Block %x extends %x instead of the tip %x", block.Header.Hash, block.Header.Prev, tip)
+			}
+			_, invalids, spent, err := verifyTransactions(tx, block.Body.Transactions, blockchain.MaxBlockBytes)
 			if err != nil {
 				return err
 			}
 			if len(invalids) > 0 {
 				return blockchain.ErrInvalidBlock
 			}
-			if _, err := addBlockWithUTXO(tx, block); err != nil {
+			if _, err := addBlockWithUTXO(tx, block, spent); err != nil {
 				return errors.Wrapf(err, "Failed to add block to database")
 			}
 			return nil
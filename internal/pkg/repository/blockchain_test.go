@@ -0,0 +1,63 @@
+package repository_test
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+func TestConcurrentBlocksOnTheSameTip(t *testing.T) {
+	store, _ := newStore(t)
+	defer store.Close()
+	voter := []byte("voter")
+	genesis := addGenesis(t, store, digest.SHA256, voter)
+	funding := genesis.Body.Transactions[0]
+
+	// Every block spends the funding of the voter, the batch commits them
+	// together, so only the first one may extend the tip.
+	parties := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	blocks := make([]blockchain.Block, len(parties))
+	for i, party := range parties {
+		vote, err := transaction.NewTransaction(digest.SHA256, transaction.Inputs{{TransactionID: funding.ID, PublicKeyHash: voter}}, transaction.Outputs{{Value: transaction.VoteValue, PublicKeyHash: []byte(party)}})
+		if err != nil {
+			t.Fatalf("Failed to create transaction %s", err)
+		}
+		block, err := blockchain.NewBlock(digest.SHA256, genesis.Header.Hash, transaction.Transactions{*vote})
+		if err != nil {
+			t.Fatalf("Failed to create block %s", err)
+		}
+		blocks[i] = *block
+	}
+
+	errs := make([]error, len(blocks))
+	var wg sync.WaitGroup
+	for i := range blocks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = store.AddNewBlock(blocks[i])
+		}(i)
+	}
+	wg.Wait()
+
+	var added []blockchain.Block
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			added = append(added, blocks[i])
+		case !errors.Is(err, blockchain.ErrStaleBlock):
+			t.Errorf("Expected block %d to be stale, got %s", i, err)
+		}
+	}
+	if len(added) != 1 {
+		t.Fatalf("Expected exactly one block to be added, got %d", len(added))
+	}
+	if tip := store.GetTip(); !bytes.Equal(tip, added[0].Header.Hash) {
+		t.Errorf("Expected tip %x, got %x", added[0].Header.Hash, tip)
+	}
+}
@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"log"
+	"sync"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/chain"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+func genesisHash(tx *bolt.Tx) ([]byte, error) {
+	hashAt, height, err := chainHashes(tx)
+	if err != nil || height == 0 {
+		return nil, err
+	}
+	return hashAt(1), nil
+}
+
+func chainID(tx *bolt.Tx) (chain.ID, error) {
+	genesis, err := genesisHash(tx)
+	if err != nil || genesis == nil {
+		return "", err
+	}
+	return chain.FromGenesis(genesis), nil
+}
+
+// chainAlgorithm returns the algorithm the genesis block picked, SHA-256
+// while there is no genesis block.
+func chainAlgorithm(tx *bolt.Tx) (digest.Algorithm, error) {
+	genesis, err := genesisHash(tx)
+	if err != nil || genesis == nil {
+		return digest.SHA256, err
+	}
+	block, err := readBlock(tx, genesis)
+	if err != nil {
+		return "", err
+	}
+	return block.HashAlgorithm(), nil
+}
+
+// GetChainID returns the id of the chain, which is remembered once the
+// genesis block is there since it never changes.
+func GetChainID(db *bolt.DB) chain.GetIDFn {
+	lock := &sync.Mutex{}
+	var cached chain.ID
+	return func() chain.ID {
+		lock.Lock()
+		defer lock.Unlock()
+		if cached != "" {
+			return cached
+		}
+		err := db.View(func(tx *bolt.Tx) error {
+			id, err := chainID(tx)
+			cached = id
+			return err
+		})
+		if err != nil {
+			log.Printf("Failed to get chain id %s", err)
+		}
+		return cached
+	}
+}
+
+// GetAlgorithm returns the algorithm of the chain, which is remembered once
+// the genesis block is there since it never changes.
+func GetAlgorithm(db *bolt.DB) digest.GetAlgorithmFn {
+	lock := &sync.Mutex{}
+	var cached digest.Algorithm
+	return func() digest.Algorithm {
+		lock.Lock()
+		defer lock.Unlock()
+		if cached != "" {
+			return cached
+		}
+		err := db.View(func(tx *bolt.Tx) error {
+			genesis, err := genesisHash(tx)
+			if err != nil || genesis == nil {
+				return err
+			}
+			block, err := readBlock(tx, genesis)
+			if err != nil {
+				return err
+			}
+			cached = block.HashAlgorithm()
+			return nil
+		})
+		if err != nil {
+			log.Printf("Failed to get hash algorithm of the chain %s", err)
+		}
+		return cached.Normalized()
+	}
+}
+
+// newTransaction creates a transaction stamped with the id of the chain it
+// is saved to and hashed with the algorithm of the chain.
+func newTransaction(tx *bolt.Tx, inputs transaction.Inputs, outputs transaction.Outputs) (*transaction.Transaction, error) {
+	id, err := chainID(tx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get chain id")
+	}
+	algorithm, err := chainAlgorithm(tx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get hash algorithm of the chain")
+	}
+	t, err := transaction.NewTransaction(algorithm, inputs, outputs)
+	if err != nil {
+		return nil, err
+	}
+	return t.OnChain(algorithm, id)
+}
@@ -0,0 +1,71 @@
+package repository_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/repository"
+	"github.com/nebser/crypto-vote/internal/pkg/storage"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+)
+
+func newStore(t *testing.T) (storage.Repository, *bolt.DB) {
+	store, err := storage.NewMemory()
+	if err != nil {
+		t.Fatalf("Failed to open memory storage %s", err)
+	}
+	db, err := storage.DB(store)
+	if err != nil {
+		t.Fatalf("Failed to get database %s", err)
+	}
+	return store, db
+}
+
+// addGenesis funds the voter in a genesis block hashed with the algorithm.
+func addGenesis(t *testing.T, store storage.Repository, algorithm digest.Algorithm, voter []byte) blockchain.Block {
+	funding, err := transaction.NewTransaction(algorithm, nil, transaction.Outputs{{Value: transaction.VoteValue, PublicKeyHash: voter}})
+	if err != nil {
+		t.Fatalf("Failed to create transaction %s", err)
+	}
+	genesis, err := blockchain.NewBlock(algorithm, nil, transaction.Transactions{*funding})
+	if err != nil {
+		t.Fatalf("Failed to create genesis block %s", err)
+	}
+	if _, err := store.AddBlock(*genesis); err != nil {
+		t.Fatalf("Failed to add genesis block %s", err)
+	}
+	return *genesis
+}
+
+func TestTransactionsFollowGenesisAlgorithm(t *testing.T) {
+	store, db := newStore(t)
+	defer store.Close()
+	getAlgorithm := repository.GetAlgorithm(db)
+	if a := getAlgorithm(); a != digest.SHA256 {
+		t.Errorf("Expected %s without a genesis block, got %s", digest.SHA256, a)
+	}
+
+	// The genesis block arrives after the node started, as it does on a
+	// node syncing for the first time.
+	voter := []byte("voter")
+	addGenesis(t, store, digest.BLAKE3, voter)
+	if a := getAlgorithm(); a != digest.BLAKE3 {
+		t.Errorf("Expected %s once the genesis block is there, got %s", digest.BLAKE3, a)
+	}
+
+	cast := repository.CastVote(db, transaction.KeepOutputsOrder, func(transaction.Transaction) error { return nil }, func(transaction.Transaction) {})
+	vote, err := cast(voter, []byte("party"), nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to cast vote %s", err)
+	}
+	expected, err := transaction.Transaction{Inputs: vote.Inputs, Outputs: vote.Outputs}.OnChain(digest.BLAKE3, vote.ChainID)
+	if err != nil {
+		t.Fatalf("Failed to stamp transaction %s", err)
+	}
+	if vote.ChainID == "" || !bytes.Equal(vote.ID, expected.ID) {
+		t.Errorf("Expected vote on chain %s with %s id %x, got %x", vote.ChainID, digest.BLAKE3, expected.ID, vote.ID)
+	}
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"bytes"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// Consistency describes a database which passed the consistency checks.
+type Consistency struct {
+	Height int
+	Tip    []byte
+	Size   int64
+}
+
+// CheckConsistency verifies the pages of the database and walks the
+// blockchain from the tip to the genesis block, every block has to be
+// stored under its hash and its hash has to match its content.
+func CheckConsistency(db *bolt.DB) (Consistency, error) {
+	var result Consistency
+	err := db.View(func(tx *bolt.Tx) error {
+		result.Size = tx.Size()
+		var first error
+		count := 0
+		for err := range tx.Check() {
+			if first == nil {
+				first = err
+			}
+			count++
+		}
+		if first != nil {
+			return errors.Wrapf(first, "Database check found %d problems, the first is", count)
+		}
+		return nil
+	})
+	if err != nil {
+		return Consistency{}, err
+	}
+	result.Tip = GetTip(db)()
+	getBlock := GetBlock(db)
+	for current := result.Tip; len(current) > 0; {
+		block, err := getBlock(current)
+		switch {
+		case err != nil:
+			return Consistency{}, errors.Wrapf(err, "Failed to read block %x", current)
+		case block == nil:
+			return Consistency{}, errors.Errorf("Block %x is missing", current)
+		case !bytes.Equal(block.Header.Hash, current):
+			return Consistency{}, errors.Errorf("Block %x is stored under %x", block.Header.Hash, current)
+		case !block.IsHashValid():
+			return Consistency{}, errors.Errorf("Hash of block %x doesn't match its content", current)
+		}
+		result.Height++
+		current = block.Header.Prev
+	}
+	return result, nil
+}
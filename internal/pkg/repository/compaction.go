@@ -0,0 +1,299 @@
+package repository
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/codec"
+	"github.com/nebser/crypto-vote/internal/pkg/compaction"
+	"github.com/pkg/errors"
+)
+
+// Transactions of the compacted copy are committed after this many bytes
+// so copying a large database doesn't hold it all in memory.
+const compactionTxBytes = 64 << 20
+
+func compactionsBucket() []byte {
+	return []byte("compactions")
+}
+
+func lastCompactionKey() []byte {
+	return []byte("last")
+}
+
+func encodeCompaction(r compaction.Result) []byte {
+	return codec.NewWriter().
+		Byte(binaryFormat).
+		Int(r.StartedAt).
+		Int(r.FinishedAt).
+		Int(r.SizeBefore).
+		Int(r.SizeAfter).
+		Result()
+}
+
+func decodeCompaction(raw []byte) (*compaction.Result, error) {
+	r := codec.NewReader(raw)
+	r.Byte()
+	result := compaction.Result{
+		StartedAt:  r.Int(),
+		FinishedAt: r.Int(),
+		SizeBefore: r.Int(),
+		SizeAfter:  r.Int(),
+	}
+	if r.Err() != nil {
+		return nil, errors.Wrap(r.Err(), "Failed to decode compaction")
+	}
+	return &result, nil
+}
+
+// copier writes buckets into the compacted copy, committing every
+// compactionTxBytes. Buckets are resolved by their path because a commit
+// invalidates them.
+type copier struct {
+	db   *bolt.DB
+	tx   *bolt.Tx
+	size int
+}
+
+func (c *copier) bucket(path [][]byte) *bolt.Bucket {
+	b := c.tx.Bucket(path[0])
+	for _, name := range path[1:] {
+		b = b.Bucket(name)
+	}
+	b.FillPercent = 1
+	return b
+}
+
+func (c *copier) grow(size int) error {
+	if c.size+size <= compactionTxBytes {
+		c.size += size
+		return nil
+	}
+	if err := c.tx.Commit(); err != nil {
+		return errors.Wrap(err, "Failed to commit compacted copy")
+	}
+	tx, err := c.db.Begin(true)
+	if err != nil {
+		return errors.Wrap(err, "Failed to begin transaction of compacted copy")
+	}
+	c.tx = tx
+	c.size = size
+	return nil
+}
+
+func (c *copier) create(path [][]byte, sequence uint64) error {
+	if err := c.grow(len(path[len(path)-1])); err != nil {
+		return err
+	}
+	var b *bolt.Bucket
+	var err error
+	if len(path) == 1 {
+		b, err = c.tx.CreateBucket(path[0])
+	} else {
+		b, err = c.bucket(path[:len(path)-1]).CreateBucket(path[len(path)-1])
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create bucket %s", path[len(path)-1])
+	}
+	return b.SetSequence(sequence)
+}
+
+func (c *copier) walk(src *bolt.Bucket, path [][]byte) (int, error) {
+	count := 0
+	err := src.ForEach(func(k, v []byte) error {
+		count++
+		if v != nil {
+			if err := c.grow(len(k) + len(v)); err != nil {
+				return err
+			}
+			return c.bucket(path).Put(k, v)
+		}
+		nested := append(append([][]byte{}, path...), k)
+		b := src.Bucket(k)
+		if err := c.create(nested, b.Sequence()); err != nil {
+			return err
+		}
+		n, err := c.walk(b, nested)
+		count += n
+		return err
+	})
+	return count, err
+}
+
+func copyDatabase(tx *bolt.Tx, dst *bolt.DB) (int, error) {
+	dstTx, err := dst.Begin(true)
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to begin transaction of compacted copy")
+	}
+	c := &copier{db: dst, tx: dstTx}
+	count := 0
+	err = tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		count++
+		if err := c.create([][]byte{name}, b.Sequence()); err != nil {
+			return err
+		}
+		n, err := c.walk(b, [][]byte{name})
+		count += n
+		return err
+	})
+	if err != nil {
+		c.tx.Rollback()
+		return 0, err
+	}
+	if err := c.tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "Failed to commit compacted copy")
+	}
+	return count, nil
+}
+
+func countEntries(b *bolt.Bucket) int {
+	count := 0
+	b.ForEach(func(k, v []byte) error {
+		count++
+		if v == nil {
+			count += countEntries(b.Bucket(k))
+		}
+		return nil
+	})
+	return count
+}
+
+func countDatabase(db *bolt.DB) (int, error) {
+	count := 0
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(_ []byte, b *bolt.Bucket) error {
+			count += 1 + countEntries(b)
+			return nil
+		})
+	})
+	return count, err
+}
+
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func EstimateCompaction(db *bolt.DB) compaction.EstimateFn {
+	return func() (compaction.Estimate, error) {
+		size, err := fileSize(db.Path())
+		if err != nil {
+			return compaction.Estimate{}, errors.Wrapf(err, "Failed to stat database %s", db.Path())
+		}
+		stats := db.Stats()
+		free := int64(stats.FreePageN+stats.PendingPageN) * int64(db.Info().PageSize)
+		var used int64
+		err = db.View(func(tx *bolt.Tx) error {
+			used = tx.Size() - free
+			return nil
+		})
+		return compaction.Estimate{
+			Size:        size,
+			Reclaimable: size - used,
+		}, err
+	}
+}
+
+func CompactDatabase(db *bolt.DB) compaction.CompactFn {
+	return func(swapped compaction.SwappedFn) error {
+		result := compaction.Result{StartedAt: time.Now().Unix()}
+		path := db.Path()
+		target := path + ".compact"
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "Failed to remove stale copy %s", target)
+		}
+		size, err := fileSize(path)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to stat database %s", path)
+		}
+		result.SizeBefore = size
+		return db.Update(func(tx *bolt.Tx) error {
+			dst, err := bolt.Open(target, 0600, nil)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to open compacted copy %s", target)
+			}
+			copied, err := copyDatabase(tx, dst)
+			if err != nil {
+				dst.Close()
+				os.Remove(target)
+				return errors.Wrap(err, "Failed to copy database")
+			}
+			verified, err := countDatabase(dst)
+			if err != nil || verified != copied {
+				dst.Close()
+				os.Remove(target)
+				return errors.Wrapf(compaction.ErrMismatch, "%d of %d entries were copied", verified, copied)
+			}
+			result.FinishedAt = time.Now().Unix()
+			if result.SizeAfter, err = fileSize(target); err != nil {
+				dst.Close()
+				return errors.Wrapf(err, "Failed to stat compacted copy %s", target)
+			}
+			err = dst.Update(func(dstTx *bolt.Tx) error {
+				b, err := dstTx.CreateBucketIfNotExists(compactionsBucket())
+				if err != nil {
+					return errors.Wrapf(err, "Failed to create bucket %s", compactionsBucket())
+				}
+				return b.Put(lastCompactionKey(), encodeCompaction(result))
+			})
+			if err == nil {
+				details := fmt.Sprintf("size before %d bytes, after %d bytes", result.SizeBefore, result.SizeAfter)
+				err = RecordAudit(dst)("database compacted", details)
+			}
+			if closeErr := dst.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(target)
+				return errors.Wrap(err, "Failed to record compaction")
+			}
+			if err := os.Rename(target, path); err != nil {
+				os.Remove(target)
+				return errors.Wrapf(err, "Failed to swap %s with the compacted copy", path)
+			}
+			// The copy is in place, so the swap has to be completed even if
+			// the rename can't be made durable.
+			if err := syncDir(filepath.Dir(path)); err != nil {
+				log.Printf("Failed to sync directory of %s %s", path, err)
+			}
+			swapped(result)
+			return errors.New("Database was swapped but it was not reopened")
+		})
+	}
+}
+
+func GetLastCompaction(db *bolt.DB) compaction.GetLastFn {
+	return func() (*compaction.Result, error) {
+		var result *compaction.Result
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(compactionsBucket())
+			if b == nil {
+				return nil
+			}
+			raw := b.Get(lastCompactionKey())
+			if raw == nil {
+				return nil
+			}
+			r, err := decodeCompaction(raw)
+			result = r
+			return err
+		})
+		return result, err
+	}
+}
@@ -0,0 +1,216 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/codec"
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+var doubleSpends = metrics.NewCounter("double_spends_total", "Number of transactions seen spending an output another transaction spends")
+
+func spendsBucket() []byte {
+	return []byte("spends")
+}
+
+func conflictsBucket() []byte {
+	return []byte("conflicts")
+}
+
+func outpointKey(id []byte, vout int) []byte {
+	key := make([]byte, len(id)+8)
+	copy(key, id)
+	binary.BigEndian.PutUint64(key[len(id):], uint64(vout))
+	return key
+}
+
+func splitOutpointKey(key []byte) ([]byte, int) {
+	id := key[:len(key)-8]
+	return append([]byte{}, id...), int(binary.BigEndian.Uint64(key[len(id):]))
+}
+
+func encodeSpends(spends []transaction.Spend) []byte {
+	w := codec.NewWriter().
+		Byte(binaryFormat).
+		Uint(uint64(len(spends)))
+	for _, s := range spends {
+		w.Bytes(s.Transaction).
+			String(s.Source).
+			Int(s.Timestamp).
+			Int(s.SeenAt).
+			Bytes(s.Block)
+	}
+	return w.Result()
+}
+
+func decodeSpends(raw []byte) ([]transaction.Spend, error) {
+	r := codec.NewReader(raw)
+	r.Byte()
+	result := []transaction.Spend{}
+	count := r.Uint()
+	for i := uint64(0); i < count && r.Err() == nil; i++ {
+		s := transaction.Spend{
+			Transaction: r.Bytes(),
+			Source:      r.String(),
+			Timestamp:   r.Int(),
+			SeenAt:      r.Int(),
+			Block:       r.Bytes(),
+		}
+		if len(s.Block) == 0 {
+			s.Block = nil
+		}
+		result = append(result, s)
+	}
+	if r.Err() != nil {
+		return nil, errors.Wrap(r.Err(), "Failed to decode spends")
+	}
+	return result, nil
+}
+
+func getSpends(tx *bolt.Tx, key []byte) ([]transaction.Spend, error) {
+	b := tx.Bucket(spendsBucket())
+	if b == nil {
+		return nil, nil
+	}
+	raw := b.Get(key)
+	if raw == nil {
+		return nil, nil
+	}
+	return decodeSpends(raw)
+}
+
+// addConflict indexes the output under every competing transaction, so the
+// conflict can be looked up by any of them.
+func addConflict(tx *bolt.Tx, key []byte, spends []transaction.Spend) error {
+	b, err := tx.CreateBucketIfNotExists(conflictsBucket())
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create bucket %s", conflictsBucket())
+	}
+	for _, s := range spends {
+		keys, err := b.CreateBucketIfNotExists(s.Transaction)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to create conflicts of transaction %x", s.Transaction)
+		}
+		if err := keys.Put(key, []byte{}); err != nil {
+			return errors.Wrapf(err, "Failed to save conflict of transaction %x", s.Transaction)
+		}
+	}
+	return nil
+}
+
+// recordSpends remembers the transaction as a spender of its inputs. A
+// transaction spending an output another transaction already spends is
+// recorded as a double spend. Within a block the transaction is marked as
+// included.
+func recordSpends(tx *bolt.Tx, t transaction.Transaction, source string, block []byte) error {
+	b, err := tx.CreateBucketIfNotExists(spendsBucket())
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create bucket %s", spendsBucket())
+	}
+	for _, in := range t.Inputs {
+		if in.Vout < 0 {
+			continue
+		}
+		key := outpointKey(in.TransactionID, in.Vout)
+		spends, err := getSpends(tx, key)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to get spends of %x %d", in.TransactionID, in.Vout)
+		}
+		found := false
+		for i, s := range spends {
+			if bytes.Equal(s.Transaction, t.ID) {
+				found = true
+				if block != nil {
+					spends[i].Block = block
+				}
+			}
+		}
+		if !found {
+			spends = append(spends, transaction.Spend{
+				Transaction: t.ID,
+				Source:      source,
+				Timestamp:   t.Timestamp,
+				SeenAt:      time.Now().Unix(),
+				Block:       block,
+			})
+			if len(spends) > 1 {
+				doubleSpends.Inc()
+				log.Printf("Transaction %x spends %x %d which is already spent by %x", t.ID, in.TransactionID, in.Vout, spends[0].Transaction)
+				if err := addConflict(tx, key, spends); err != nil {
+					return err
+				}
+			}
+		}
+		if err := b.Put(key, encodeSpends(spends)); err != nil {
+			return errors.Wrapf(err, "Failed to save spends of %x %d", in.TransactionID, in.Vout)
+		}
+	}
+	return nil
+}
+
+// unrecordInclusion clears the block of the transaction's spends when the
+// block is rolled back.
+func unrecordInclusion(tx *bolt.Tx, t transaction.Transaction) error {
+	b := tx.Bucket(spendsBucket())
+	if b == nil {
+		return nil
+	}
+	for _, in := range t.Inputs {
+		if in.Vout < 0 {
+			continue
+		}
+		key := outpointKey(in.TransactionID, in.Vout)
+		spends, err := getSpends(tx, key)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to get spends of %x %d", in.TransactionID, in.Vout)
+		}
+		for i, s := range spends {
+			if bytes.Equal(s.Transaction, t.ID) {
+				spends[i].Block = nil
+			}
+		}
+		if spends == nil {
+			continue
+		}
+		if err := b.Put(key, encodeSpends(spends)); err != nil {
+			return errors.Wrapf(err, "Failed to save spends of %x %d", in.TransactionID, in.Vout)
+		}
+	}
+	return nil
+}
+
+func GetConflicts(db *bolt.DB) transaction.GetConflictsFn {
+	return func(id []byte) ([]transaction.Conflict, error) {
+		var result []transaction.Conflict
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(conflictsBucket())
+			if b == nil {
+				return nil
+			}
+			keys := b.Bucket(id)
+			if keys == nil {
+				return nil
+			}
+			return keys.ForEach(func(key, _ []byte) error {
+				spends, err := getSpends(tx, key)
+				if err != nil {
+					return err
+				}
+				txID, vout := splitOutpointKey(key)
+				result = append(result, transaction.Conflict{
+					TransactionID: txID,
+					Vout:          vout,
+					Spends:        spends,
+				})
+				return nil
+			})
+		})
+		return result, err
+	}
+}
@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"encoding/binary"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+// voterCreditsBucket is the voter index, credits every voter gave to the
+// choices of the ballot in the blockchain. Only the alfa node keeps the
+// parties, so the index stays empty on client nodes.
+func voterCreditsBucket() []byte {
+	return []byte("voter-credits")
+}
+
+func choiceKeyHashes(tx *bolt.Tx) map[string]bool {
+	result := map[string]bool{}
+	b := tx.Bucket(partiesBucket())
+	if b == nil {
+		return result
+	}
+	b.ForEach(func(address, _ []byte) error {
+		result[string(wallet.ExtractPublicKeyHash(string(address)))] = true
+		return nil
+	})
+	return result
+}
+
+func getUsedCredits(tx *bolt.Tx, voter []byte) int {
+	b := tx.Bucket(voterCreditsBucket())
+	if b == nil {
+		return 0
+	}
+	raw := b.Get(voter)
+	if len(raw) != 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(raw))
+}
+
+// indexCredits adds the credits the transactions of a block give to the
+// voter index, or takes them away when the block is rolled back.
+func indexCredits(tx *bolt.Tx, transactions transaction.Transactions, rollback bool) error {
+	choices := choiceKeyHashes(tx)
+	if len(choices) == 0 {
+		return nil
+	}
+	isChoice := func(keyHash []byte) bool {
+		return choices[string(keyHash)]
+	}
+	b, err := tx.CreateBucketIfNotExists(voterCreditsBucket())
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create bucket %s", voterCreditsBucket())
+	}
+	for _, t := range transactions {
+		voter, credits := t.Credits(isChoice)
+		if credits == 0 {
+			continue
+		}
+		used := getUsedCredits(tx, voter)
+		if rollback {
+			used -= credits
+		} else {
+			used += credits
+		}
+		if used <= 0 {
+			if err := b.Delete(voter); err != nil {
+				return errors.Wrapf(err, "Failed to delete credits of %x", voter)
+			}
+			continue
+		}
+		raw := make([]byte, 8)
+		binary.BigEndian.PutUint64(raw, uint64(used))
+		if err := b.Put(voter, raw); err != nil {
+			return errors.Wrapf(err, "Failed to save credits of %x", voter)
+		}
+	}
+	return nil
+}
+
+func GetUsedCredits(db *bolt.DB) transaction.GetUsedCreditsFn {
+	return func(voter []byte) (int, error) {
+		var result int
+		err := db.View(func(tx *bolt.Tx) error {
+			result = getUsedCredits(tx, voter)
+			return nil
+		})
+		return result, err
+	}
+}
+
+// IndexCredits builds the voter index of a database written before the
+// index was kept.
+func IndexCredits(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(voterCreditsBucket()) != nil || tx.Bucket(blocksBucket()) == nil {
+			return nil
+		}
+		if _, err := tx.CreateBucket(voterCreditsBucket()); err != nil {
+			return errors.Wrapf(err, "Failed to create bucket %s", voterCreditsBucket())
+		}
+		for hash := getTip(tx); len(hash) > 0; {
+			block, err := readBlock(tx, hash)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to read block %x", hash)
+			}
+			if err := indexCredits(tx, block.Body.Transactions, false); err != nil {
+				return errors.Wrapf(err, "Failed to index credits of block %x", hash)
+			}
+			hash = block.Header.Prev
+		}
+		return nil
+	})
+}
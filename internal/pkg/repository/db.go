@@ -1,6 +1,10 @@
 package repository
 
-import "github.com/boltdb/bolt"
+import (
+	"encoding/binary"
+
+	"github.com/boltdb/bolt"
+)
 
 func transactionsArray(transactions ...func(*bolt.Tx) error) func(*bolt.Tx) error {
 	return func(tx *bolt.Tx) error {
@@ -12,3 +16,9 @@ func transactionsArray(transactions ...func(*bolt.Tx) error) func(*bolt.Tx) erro
 		return nil
 	}
 }
+
+func sequenceKey(sequence uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, sequence)
+	return key
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/election"
+	"github.com/pkg/errors"
+)
+
+func electionsBucket() []byte {
+	return []byte("elections")
+}
+
+func SaveElection(db *bolt.DB) election.SaveFn {
+	return func(e election.Election) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists(electionsBucket())
+			if err != nil {
+				return errors.Wrapf(err, "Failed to create bucket %s", electionsBucket())
+			}
+			raw, err := json.Marshal(e)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to serialize election %s", e.ID)
+			}
+			if err := b.Put([]byte(e.ID), raw); err != nil {
+				return errors.Wrapf(err, "Failed to save election %s", e.ID)
+			}
+			return nil
+		})
+	}
+}
+
+func GetElections(db *bolt.DB) election.ListFn {
+	return func() (election.Elections, error) {
+		result := election.Elections{}
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(electionsBucket())
+			if b == nil {
+				return nil
+			}
+			return b.ForEach(func(key, value []byte) error {
+				var e election.Election
+				if err := json.Unmarshal(value, &e); err != nil {
+					return errors.Wrapf(err, "Failed to unmarshal election %s", key)
+				}
+				result = append(result, e)
+				return nil
+			})
+		})
+		return result, err
+	}
+}
@@ -0,0 +1,291 @@
+package repository
+
+import (
+	"encoding/json"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/chain"
+	"github.com/nebser/crypto-vote/internal/pkg/codec"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/transport"
+	"github.com/pkg/errors"
+)
+
+const (
+	// binaryFormatV1 is kept readable for records written before
+	// transactions could carry a transport key certificate.
+	binaryFormatV1 byte = 0xB1
+	// binaryFormatV2 is kept readable for records written before
+	// transactions could carry evidence of fraud.
+	binaryFormatV2 byte = 0xB2
+	// binaryFormatV3 is kept readable for records written before
+	// transactions could carry an emergency pause or resume.
+	binaryFormatV3 byte = 0xB3
+	// binaryFormatV4 is kept readable for records written before
+	// transactions could carry guardianships and recoveries.
+	binaryFormatV4 byte = 0xB4
+	// binaryFormatV5 is kept readable for records written before
+	// transactions could carry withdrawals of parties.
+	binaryFormatV5 byte = 0xB5
+	// binaryFormatV6 is kept readable for records written before
+	// transactions carried the id of their chain.
+	binaryFormatV6 byte = 0xB6
+	// binaryFormatV7 is kept readable for records written before stake
+	// transactions could carry a sortition proof.
+	binaryFormatV7 byte = 0xB7
+	binaryFormat   byte = 0xB8
+)
+
+func isBinary(raw []byte) bool {
+	return len(raw) > 0 && (raw[0] == binaryFormat || raw[0] == binaryFormatV7 || raw[0] == binaryFormatV6 || raw[0] == binaryFormatV5 || raw[0] == binaryFormatV4 || raw[0] == binaryFormatV3 || raw[0] == binaryFormatV2 || raw[0] == binaryFormatV1)
+}
+
+func writeTransaction(w *codec.Writer, t transaction.Transaction) {
+	t.Write(w)
+}
+
+// readTransaction reads transactions stored in any of the binary formats,
+// the current one is the canonical encoding of transactions.
+func readTransaction(r *codec.Reader, format byte) transaction.Transaction {
+	if format == binaryFormat {
+		return transaction.Read(r)
+	}
+	t := transaction.Transaction{ID: r.Bytes()}
+	inputs := r.Uint()
+	for i := uint64(0); i < inputs && r.Err() == nil; i++ {
+		t.Inputs = append(t.Inputs, transaction.Input{
+			TransactionID: r.Bytes(),
+			Vout:          int(r.Int()),
+			PublicKeyHash: r.Bytes(),
+			Signature:     r.Bytes(),
+			Verifier:      r.Bytes(),
+		})
+	}
+	outputs := r.Uint()
+	for i := uint64(0); i < outputs && r.Err() == nil; i++ {
+		t.Outputs = append(t.Outputs, transaction.Output{
+			Value:         int(r.Int()),
+			PublicKeyHash: r.Bytes(),
+		})
+	}
+	t.Timestamp = r.Int()
+	if format != binaryFormatV1 && r.Byte() == 1 {
+		t.Certificate = &transport.Certificate{
+			Algorithm:    transport.Algorithm(r.String()),
+			TransportKey: r.Bytes(),
+			Identity:     r.Bytes(),
+			Signature:    r.Bytes(),
+		}
+	}
+	if (format == binaryFormatV7 || format == binaryFormatV6 || format == binaryFormatV5 || format == binaryFormatV4 || format == binaryFormatV3) && r.Byte() == 1 {
+		e := transaction.Evidence{
+			Offender: r.Bytes(),
+			Height:   int(r.Int()),
+		}
+		blocks := r.Uint()
+		for i := uint64(0); i < blocks && r.Err() == nil; i++ {
+			e.Blocks = append(e.Blocks, r.Bytes())
+		}
+		e.ProofHash = r.Bytes()
+		e.Signer = r.Bytes()
+		e.Signature = r.Bytes()
+		t.Evidence = &e
+	}
+	if (format == binaryFormatV7 || format == binaryFormatV6 || format == binaryFormatV5 || format == binaryFormatV4) && r.Byte() == 1 {
+		e := transaction.Emergency{
+			Statement: transaction.Statement{
+				Action:   transaction.EmergencyAction(r.String()),
+				Reason:   r.String(),
+				Election: r.Bytes(),
+				IssuedAt: r.Int(),
+			},
+		}
+		signatures := r.Uint()
+		for i := uint64(0); i < signatures && r.Err() == nil; i++ {
+			e.Signatures = append(e.Signatures, transaction.TrusteeSignature{
+				Verifier:  r.Bytes(),
+				Signature: r.Bytes(),
+			})
+		}
+		t.Emergency = &e
+	}
+	if (format == binaryFormatV7 || format == binaryFormatV6 || format == binaryFormatV5) && r.Byte() == 1 {
+		g := transaction.Guardianship{Voter: r.Bytes()}
+		guardians := r.Uint()
+		for i := uint64(0); i < guardians && r.Err() == nil; i++ {
+			g.Guardians = append(g.Guardians, r.Bytes())
+		}
+		g.Quorum = int(r.Int())
+		g.Signature = r.Bytes()
+		t.Guardianship = &g
+	}
+	if (format == binaryFormatV7 || format == binaryFormatV6 || format == binaryFormatV5) && r.Byte() == 1 {
+		recovery := transaction.Recovery{
+			Statement: transaction.RecoveryStatement{
+				Guardianship: r.Bytes(),
+				Voter:        r.Bytes(),
+				NewKey:       r.Bytes(),
+			},
+		}
+		signatures := r.Uint()
+		for i := uint64(0); i < signatures && r.Err() == nil; i++ {
+			recovery.Signatures = append(recovery.Signatures, transaction.GuardianSignature{
+				Verifier:  r.Bytes(),
+				Signature: r.Bytes(),
+			})
+		}
+		t.Recovery = &recovery
+	}
+	if (format == binaryFormatV7 || format == binaryFormatV6) && r.Byte() == 1 {
+		t.Withdrawal = &transaction.Withdrawal{
+			Party:       r.Bytes(),
+			Prior:       transaction.PriorVotes(r.String()),
+			Reason:      r.String(),
+			WithdrawnAt: r.Int(),
+			Signer:      r.Bytes(),
+			Signature:   r.Bytes(),
+		}
+	}
+	if format == binaryFormatV7 {
+		t.ChainID = chain.ID(r.String())
+	}
+	return t
+}
+
+func encodeBlock(b block) []byte {
+	w := codec.NewWriter().
+		Byte(binaryFormat).
+		Int(int64(b.MagicNumber)).
+		Int(int64(b.Size)).
+		Int(int64(b.Version))
+	if b.Version >= blockchain.AlgorithmVersion {
+		w.String(string(b.Algorithm))
+	}
+	w.Bytes(b.PrevBlock).
+		Bytes(b.TransactionHash).
+		Int(b.Timestamp).
+		Int(int64(b.TransactionCount)).
+		Uint(uint64(len(b.Transactions)))
+	for _, t := range b.Transactions {
+		writeTransaction(w, t)
+	}
+	return w.Bytes(b.Hash).Result()
+}
+
+func decodeBlock(raw []byte) (block, error) {
+	var result block
+	if !isBinary(raw) {
+		if err := json.Unmarshal(raw, &result); err != nil {
+			return block{}, errors.Wrapf(err, "Failed to unmarshal serialized block %s", raw)
+		}
+		return result, nil
+	}
+	r := codec.NewReader(raw)
+	format := r.Byte()
+	result.MagicNumber = int(r.Int())
+	result.Size = int(r.Int())
+	result.Version = int(r.Int())
+	if result.Version >= blockchain.AlgorithmVersion {
+		result.Algorithm = digest.Algorithm(r.String())
+	}
+	result.PrevBlock = r.Bytes()
+	result.TransactionHash = r.Bytes()
+	result.Timestamp = r.Int()
+	result.TransactionCount = int(r.Int())
+	count := r.Uint()
+	for i := uint64(0); i < count && r.Err() == nil; i++ {
+		result.Transactions = append(result.Transactions, readTransaction(r, format))
+	}
+	result.Hash = r.Bytes()
+	if r.Err() != nil {
+		return block{}, errors.Wrap(r.Err(), "Failed to decode binary block")
+	}
+	return result, nil
+}
+
+func encodeTransaction(t transaction.Transaction) []byte {
+	w := codec.NewWriter().Byte(binaryFormat)
+	writeTransaction(w, t)
+	return w.Result()
+}
+
+func decodeTransaction(raw []byte) (transaction.Transaction, error) {
+	if !isBinary(raw) {
+		var t tx
+		if err := json.Unmarshal(raw, &t); err != nil {
+			return transaction.Transaction{}, errors.Wrapf(err, "Failed to unmarshal transaction %s", raw)
+		}
+		return t.toTransaction(), nil
+	}
+	r := codec.NewReader(raw)
+	t := readTransaction(r, r.Byte())
+	if r.Err() != nil {
+		return transaction.Transaction{}, errors.Wrap(r.Err(), "Failed to decode binary transaction")
+	}
+	return t, nil
+}
+
+func encodeUTXOs(utxos transaction.UTXOs) []byte {
+	w := codec.NewWriter().
+		Byte(binaryFormat).
+		Uint(uint64(len(utxos)))
+	for _, u := range utxos {
+		w.Bytes(u.PublicKeyHash).
+			Bytes(u.TransactionID).
+			Int(int64(u.Value)).
+			Int(int64(u.Vout))
+	}
+	return w.Result()
+}
+
+func encodeUTXO(u transaction.UTXO) []byte {
+	return codec.NewWriter().
+		Byte(binaryFormat).
+		Bytes(u.PublicKeyHash).
+		Bytes(u.TransactionID).
+		Int(int64(u.Value)).
+		Int(int64(u.Vout)).
+		Result()
+}
+
+func decodeUTXO(raw []byte) (transaction.UTXO, error) {
+	r := codec.NewReader(raw)
+	r.Byte()
+	u := transaction.UTXO{
+		PublicKeyHash: r.Bytes(),
+		TransactionID: r.Bytes(),
+		Value:         int(r.Int()),
+		Vout:          int(r.Int()),
+	}
+	if r.Err() != nil {
+		return transaction.UTXO{}, errors.Wrap(r.Err(), "Failed to decode binary utxo")
+	}
+	return u, nil
+}
+
+func decodeUTXOs(raw []byte) (transaction.UTXOs, error) {
+	if !isBinary(raw) {
+		var saved utxos
+		if err := json.Unmarshal(raw, &saved); err != nil {
+			return nil, errors.Wrap(err, "Failed to unmarshal utxos")
+		}
+		return saved.toUTXOs(), nil
+	}
+	r := codec.NewReader(raw)
+	r.Byte()
+	result := transaction.UTXOs{}
+	count := r.Uint()
+	for i := uint64(0); i < count && r.Err() == nil; i++ {
+		result = append(result, transaction.UTXO{
+			PublicKeyHash: r.Bytes(),
+			TransactionID: r.Bytes(),
+			Value:         int(r.Int()),
+			Vout:          int(r.Int()),
+		})
+	}
+	if r.Err() != nil {
+		return nil, errors.Wrap(r.Err(), "Failed to decode binary utxos")
+	}
+	return result, nil
+}
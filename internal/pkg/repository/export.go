@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/export"
+	"github.com/pkg/errors"
+)
+
+func clone(raw []byte) []byte {
+	return append([]byte{}, raw...)
+}
+
+func nestedEntries(b *bolt.Bucket, name string) []export.Entry {
+	var result []export.Entry
+	b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			result = append(result, nestedEntries(b.Bucket(k), name+"/"+string(k))...)
+			return nil
+		}
+		result = append(result, export.Entry{Bucket: name, Key: clone(k), Value: clone(v)})
+		return nil
+	})
+	return result
+}
+
+// readChunk reads the entries of the bucket following the key after, until
+// the chunk is done. Nested buckets are read whole with the key holding
+// them. It returns the last key read and whether the bucket was read to the
+// end.
+func readChunk(db *bolt.DB, name string, after []byte, chunk export.Chunk) ([]export.Entry, []byte, bool, error) {
+	var entries []export.Entry
+	last := after
+	finished := true
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(name))
+		if b == nil {
+			return errors.Errorf("Bucket %s does not exist", name)
+		}
+		start := time.Now()
+		c := b.Cursor()
+		k, v := c.First()
+		if after != nil {
+			if k, v = c.Seek(after); k != nil && bytes.Equal(k, after) {
+				k, v = c.Next()
+			}
+		}
+		for ; k != nil; k, v = c.Next() {
+			if chunk.Done(len(entries), start) {
+				finished = false
+				return nil
+			}
+			last = clone(k)
+			if v == nil {
+				entries = append(entries, nestedEntries(b.Bucket(k), name+"/"+string(k))...)
+				continue
+			}
+			entries = append(entries, export.Entry{Bucket: name, Key: clone(k), Value: clone(v)})
+		}
+		return nil
+	})
+	return entries, last, finished, err
+}
+
+func bucketNames(db *bolt.DB) ([]string, error) {
+	var result []string
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			result = append(result, string(name))
+			return nil
+		})
+	})
+	return result, err
+}
+
+// Export reads the buckets in a series of short read transactions instead
+// of a single one, which would keep bolt from remapping the growing file
+// and so stall block application for as long as the export runs. Entries
+// are visited between the reads. An entry changed during the export is
+// exported as it was when its chunk was read, a key added behind the last
+// read one is exported too.
+func Export(db *bolt.DB, chunk export.Chunk) export.ExportFn {
+	return func(buckets []string, visit export.VisitFn) error {
+		if len(buckets) == 0 {
+			all, err := bucketNames(db)
+			if err != nil {
+				return errors.Wrap(err, "Failed to list buckets")
+			}
+			buckets = all
+		}
+		for _, name := range buckets {
+			var after []byte
+			for {
+				entries, last, finished, err := readChunk(db, name, after, chunk)
+				if err != nil {
+					return errors.Wrapf(err, "Failed to read bucket %s", name)
+				}
+				for _, e := range entries {
+					if err := visit(e); err != nil {
+						return err
+					}
+				}
+				if finished {
+					break
+				}
+				after = last
+			}
+		}
+		return nil
+	}
+}
+
+// StreamSnapshot copies the database to a temporary file next to it first,
+// which takes as long as the local disk needs, and streams the copy after
+// the read transaction is closed, however slow the receiver is.
+func StreamSnapshot(db *bolt.DB) export.SnapshotFn {
+	return func(w io.Writer) (int64, error) {
+		tmp, err := ioutil.TempFile(filepath.Dir(db.Path()), filepath.Base(db.Path())+".snapshot-")
+		if err != nil {
+			return 0, errors.Wrap(err, "Failed to create temporary snapshot")
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		err = db.View(func(tx *bolt.Tx) error {
+			_, err := tx.WriteTo(tmp)
+			return err
+		})
+		if err != nil {
+			return 0, errors.Wrapf(err, "Failed to snapshot database to %s", tmp.Name())
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return 0, errors.Wrapf(err, "Failed to rewind %s", tmp.Name())
+		}
+		n, err := io.Copy(w, tmp)
+		if err != nil {
+			return n, errors.Wrap(err, "Failed to stream snapshot")
+		}
+		return n, nil
+	}
+}
@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/finalization"
+	"github.com/pkg/errors"
+)
+
+func finalizationBucket() []byte {
+	return []byte("finalization")
+}
+
+func finalizationKey() []byte {
+	return []byte("current")
+}
+
+func finalizationStateKey() []byte {
+	return []byte("state")
+}
+
+func cosignaturesBucket() []byte {
+	return []byte("cosignatures")
+}
+
+func getFinalization(tx *bolt.Tx) (*finalization.Finalization, error) {
+	b := tx.Bucket(finalizationBucket())
+	if b == nil {
+		return nil, nil
+	}
+	raw := b.Get(finalizationKey())
+	if raw == nil {
+		return nil, nil
+	}
+	var f finalization.Finalization
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, errors.Wrap(err, "Failed to unmarshal finalization")
+	}
+	return &f, nil
+}
+
+func GetFinalization(db *bolt.DB) finalization.GetFn {
+	return func() (*finalization.Finalization, error) {
+		var result *finalization.Finalization
+		err := db.View(func(tx *bolt.Tx) error {
+			f, err := getFinalization(tx)
+			result = f
+			return err
+		})
+		return result, err
+	}
+}
+
+func SaveFinalization(db *bolt.DB) finalization.SaveFn {
+	return func(f finalization.Finalization) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists(finalizationBucket())
+			if err != nil {
+				return errors.Wrapf(err, "Failed to create bucket %s", finalizationBucket())
+			}
+			raw, err := json.Marshal(f)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to serialize finalization %#v", f)
+			}
+			if err := b.Put(finalizationKey(), raw); err != nil {
+				return errors.Wrap(err, "Failed to save finalization")
+			}
+			return nil
+		})
+	}
+}
+
+func GetFinalizationState(db *bolt.DB) finalization.GetStateFn {
+	return func() (finalization.State, error) {
+		var result finalization.State
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(finalizationBucket())
+			if b == nil {
+				return nil
+			}
+			raw := b.Get(finalizationStateKey())
+			if raw == nil {
+				return nil
+			}
+			if err := json.Unmarshal(raw, &result); err != nil {
+				return errors.Wrap(err, "Failed to unmarshal finalization state")
+			}
+			return nil
+		})
+		return result, err
+	}
+}
+
+func SaveFinalizationState(db *bolt.DB) finalization.SaveStateFn {
+	return func(state finalization.State) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists(finalizationBucket())
+			if err != nil {
+				return errors.Wrapf(err, "Failed to create bucket %s", finalizationBucket())
+			}
+			raw, err := json.Marshal(state)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to serialize finalization state %#v", state)
+			}
+			if err := b.Put(finalizationStateKey(), raw); err != nil {
+				return errors.Wrap(err, "Failed to save finalization state")
+			}
+			return nil
+		})
+	}
+}
+
+// SaveCosignature keeps a single cosignature per co-signer, a later one
+// replaces the earlier.
+func SaveCosignature(db *bolt.DB) finalization.SaveCosignatureFn {
+	return func(c finalization.Cosignature) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists(cosignaturesBucket())
+			if err != nil {
+				return errors.Wrapf(err, "Failed to create bucket %s", cosignaturesBucket())
+			}
+			raw, err := json.Marshal(c)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to serialize cosignature %#v", c)
+			}
+			if err := b.Put(c.Verifier, raw); err != nil {
+				return errors.Wrap(err, "Failed to save cosignature")
+			}
+			return nil
+		})
+	}
+}
+
+func GetCosignatures(db *bolt.DB) finalization.GetCosignaturesFn {
+	return func() (finalization.Cosignatures, error) {
+		result := finalization.Cosignatures{}
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(cosignaturesBucket())
+			if b == nil {
+				return nil
+			}
+			return b.ForEach(func(key, value []byte) error {
+				var c finalization.Cosignature
+				if err := json.Unmarshal(value, &c); err != nil {
+					return errors.Wrapf(err, "Failed to unmarshal cosignature %x", key)
+				}
+				result = append(result, c)
+				return nil
+			})
+		})
+		return result, err
+	}
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/fraud"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+func fraudBucket() []byte {
+	return []byte("fraud")
+}
+
+// fraudKey orders records by offender and height so that every record of
+// an offender shares the prefix of its hashed chain key.
+func fraudKey(keyHash []byte, height int) []byte {
+	key := make([]byte, len(keyHash)+8)
+	copy(key, keyHash)
+	binary.BigEndian.PutUint64(key[len(keyHash):], uint64(height))
+	return key
+}
+
+func SaveFraudProof(db *bolt.DB) fraud.SaveFn {
+	return func(r fraud.Record) error {
+		keyHash, err := wallet.HashedPublicKey(r.Violation.Offender)
+		if err != nil {
+			return errors.Wrap(err, "Failed to hash offender public key")
+		}
+		return db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists(fraudBucket())
+			if err != nil {
+				return errors.Wrapf(err, "Failed to create bucket %s", fraudBucket())
+			}
+			key := fraudKey(keyHash, r.Violation.Height)
+			if b.Get(key) != nil {
+				return fraud.ErrAlreadyRecorded
+			}
+			raw, err := json.Marshal(r)
+			if err != nil {
+				return errors.Wrap(err, "Failed to serialize fraud proof")
+			}
+			if err := b.Put(key, raw); err != nil {
+				return errors.Wrapf(err, "Failed to save fraud proof of %x at %d", keyHash, r.Violation.Height)
+			}
+			return nil
+		})
+	}
+}
+
+func GetFraudProofs(db *bolt.DB) fraud.GetFn {
+	return func() (fraud.Records, error) {
+		result := fraud.Records{}
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(fraudBucket())
+			if b == nil {
+				return nil
+			}
+			return b.ForEach(func(key, value []byte) error {
+				var r fraud.Record
+				if err := json.Unmarshal(value, &r); err != nil {
+					return errors.Wrapf(err, "Failed to unmarshal fraud proof %x", key)
+				}
+				result = append(result, r)
+				return nil
+			})
+		})
+		return result, err
+	}
+}
+
+func IsSlashed(db *bolt.DB) fraud.IsSlashedFn {
+	return func(keyHash []byte) (bool, error) {
+		result := false
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(fraudBucket())
+			if b == nil {
+				return nil
+			}
+			key, _ := b.Cursor().Seek(keyHash)
+			result = key != nil && len(key) == len(keyHash)+8 && bytes.HasPrefix(key, keyHash)
+			return nil
+		})
+		return result, err
+	}
+}
@@ -0,0 +1,264 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/codec"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+// snapshotInterval is the number of blocks between two snapshots of the
+// whole utxo set. A historical query replays at most this many blocks.
+const snapshotInterval = 1000
+
+func blockHeightsBucket() []byte {
+	return []byte("block-heights")
+}
+
+func blocksByHeightBucket() []byte {
+	return []byte("blocks-by-height")
+}
+
+func utxoSnapshotsBucket() []byte {
+	return []byte("utxo-snapshots")
+}
+
+func heightKey(height int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+	return key
+}
+
+func readBlock(tx *bolt.Tx, hash []byte) (*blockchain.Block, error) {
+	b := tx.Bucket(blocksBucket())
+	if b == nil {
+		return nil, errors.New("Blocks bucket does not exist")
+	}
+	raw := b.Get(hash)
+	if raw == nil {
+		return nil, errors.Errorf("Block %x does not exist", hash)
+	}
+	serialized, err := decodeBlock(raw)
+	if err != nil {
+		return nil, err
+	}
+	result := serialized.toBlock()
+	return &result, nil
+}
+
+// indexHeight records the height of the block. Ancestors of the block are
+// indexed first if the blockchain was created before heights were indexed.
+func indexHeight(tx *bolt.Tx, hash []byte) (int, error) {
+	heights, err := tx.CreateBucketIfNotExists(blockHeightsBucket())
+	if err != nil {
+		return 0, errors.Wrapf(err, "Failed to create bucket %s", blockHeightsBucket())
+	}
+	byHeight, err := tx.CreateBucketIfNotExists(blocksByHeightBucket())
+	if err != nil {
+		return 0, errors.Wrapf(err, "Failed to create bucket %s", blocksByHeightBucket())
+	}
+	var unindexed [][]byte
+	height := 0
+	for current := hash; current != nil; {
+		if raw := heights.Get(current); raw != nil {
+			height = int(binary.BigEndian.Uint64(raw))
+			break
+		}
+		unindexed = append(unindexed, current)
+		block, err := readBlock(tx, current)
+		if err != nil {
+			return 0, errors.Wrapf(err, "Failed to read block %x", current)
+		}
+		current = block.Header.Prev
+	}
+	for i := len(unindexed) - 1; i >= 0; i-- {
+		height++
+		if err := heights.Put(unindexed[i], heightKey(height)); err != nil {
+			return 0, errors.Wrapf(err, "Failed to index height of block %x", unindexed[i])
+		}
+		if err := byHeight.Put(heightKey(height), unindexed[i]); err != nil {
+			return 0, errors.Wrapf(err, "Failed to index block at height %d", height)
+		}
+	}
+	return height, nil
+}
+
+// chainHashes returns a lookup of block hashes by height together with the
+// height of the tip. The index is used if the tip is indexed, otherwise the
+// blockchain is walked.
+func chainHashes(tx *bolt.Tx) (func(int) []byte, int, error) {
+	tip := getTip(tx)
+	heights, byHeight := tx.Bucket(blockHeightsBucket()), tx.Bucket(blocksByHeightBucket())
+	if heights != nil && byHeight != nil {
+		if raw := heights.Get(tip); raw != nil {
+			return func(height int) []byte {
+				return byHeight.Get(heightKey(height))
+			}, int(binary.BigEndian.Uint64(raw)), nil
+		}
+	}
+	var chain [][]byte
+	for current := tip; current != nil; {
+		chain = append([][]byte{current}, chain...)
+		block, err := readBlock(tx, current)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "Failed to read block %x", current)
+		}
+		current = block.Header.Prev
+	}
+	return func(height int) []byte {
+		if height < 1 || height > len(chain) {
+			return nil
+		}
+		return chain[height-1]
+	}, len(chain), nil
+}
+
+func utxoKey(id []byte, vout int) string {
+	return string(codec.NewWriter().Bytes(id).Int(int64(vout)).Result())
+}
+
+type utxoSet map[string]transaction.UTXO
+
+func (s utxoSet) apply(b blockchain.Block) {
+	for _, t := range b.Body.Transactions {
+		for _, in := range t.Inputs {
+			delete(s, utxoKey(in.TransactionID, in.Vout))
+		}
+		for _, u := range t.UTXOs() {
+			s[utxoKey(u.TransactionID, u.Vout)] = u
+		}
+	}
+}
+
+func (s utxoSet) sorted(criteria func(transaction.UTXO) bool) transaction.UTXOs {
+	result := transaction.UTXOs{}
+	for _, u := range s {
+		if criteria(u) {
+			result = append(result, u)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if c := bytes.Compare(result[i].TransactionID, result[j].TransactionID); c != 0 {
+			return c < 0
+		}
+		return result[i].Vout < result[j].Vout
+	})
+	return result
+}
+
+func encodeSnapshot(block []byte, utxos transaction.UTXOs) []byte {
+	return codec.NewWriter().Bytes(block).Bytes(encodeUTXOs(utxos)).Result()
+}
+
+func decodeSnapshot(raw []byte) ([]byte, transaction.UTXOs, error) {
+	r := codec.NewReader(raw)
+	block := r.Bytes()
+	rawUTXOs := r.Bytes()
+	if r.Err() != nil {
+		return nil, nil, errors.Wrap(r.Err(), "Failed to decode utxo snapshot")
+	}
+	utxos, err := decodeUTXOs(rawUTXOs)
+	return block, utxos, err
+}
+
+// latestSnapshot finds the latest snapshot at or below the height which was
+// taken of a block still in the blockchain.
+func latestSnapshot(tx *bolt.Tx, height int, hashAt func(int) []byte) (int, utxoSet, error) {
+	set := utxoSet{}
+	b := tx.Bucket(utxoSnapshotsBucket())
+	if b == nil {
+		return 0, set, nil
+	}
+	c := b.Cursor()
+	k, v := c.Seek(heightKey(height))
+	if k == nil || int(binary.BigEndian.Uint64(k)) > height {
+		k, v = c.Prev()
+	}
+	for ; k != nil; k, v = c.Prev() {
+		snapshotHeight := int(binary.BigEndian.Uint64(k))
+		block, utxos, err := decodeSnapshot(v)
+		if err != nil {
+			return 0, nil, errors.Wrapf(err, "Failed to decode snapshot at height %d", snapshotHeight)
+		}
+		if !bytes.Equal(block, hashAt(snapshotHeight)) {
+			continue
+		}
+		for _, u := range utxos {
+			set[utxoKey(u.TransactionID, u.Vout)] = u
+		}
+		return snapshotHeight, set, nil
+	}
+	return 0, set, nil
+}
+
+func utxoSetAt(tx *bolt.Tx, height int, hashAt func(int) []byte) (utxoSet, error) {
+	from, set, err := latestSnapshot(tx, height, hashAt)
+	if err != nil {
+		return nil, err
+	}
+	for h := from + 1; h <= height; h++ {
+		block, err := readBlock(tx, hashAt(h))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read block at height %d", h)
+		}
+		set.apply(*block)
+	}
+	return set, nil
+}
+
+// snapshotUTXOs takes a snapshot of the utxo set every snapshotInterval
+// blocks. The snapshot is computed from blocks only, pending transactions
+// don't affect it.
+func snapshotUTXOs(tx *bolt.Tx, hash []byte, height int) error {
+	if height%snapshotInterval != 0 {
+		return nil
+	}
+	hashAt, _, err := chainHashes(tx)
+	if err != nil {
+		return err
+	}
+	set, err := utxoSetAt(tx, height, hashAt)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to compute utxo set at height %d", height)
+	}
+	b, err := tx.CreateBucketIfNotExists(utxoSnapshotsBucket())
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create bucket %s", utxoSnapshotsBucket())
+	}
+	all := set.sorted(func(transaction.UTXO) bool { return true })
+	if err := b.Put(heightKey(height), encodeSnapshot(hash, all)); err != nil {
+		return errors.Wrapf(err, "Failed to save utxo snapshot at height %d", height)
+	}
+	return nil
+}
+
+func GetUTXOsByPublicKeyAt(db *bolt.DB) transaction.GetUTXOsByPublicKeyAtFn {
+	return func(publicKeyHash []byte, height int) (transaction.UTXOs, []byte, error) {
+		var result transaction.UTXOs
+		var block []byte
+		err := db.View(func(tx *bolt.Tx) error {
+			hashAt, tipHeight, err := chainHashes(tx)
+			switch {
+			case err != nil:
+				return err
+			case height < 0 || height > tipHeight:
+				return errors.Wrapf(blockchain.ErrHeightNotReached, "Height %d is not between 0 and %d", height, tipHeight)
+			}
+			set, err := utxoSetAt(tx, height, hashAt)
+			if err != nil {
+				return err
+			}
+			result = set.sorted(func(u transaction.UTXO) bool {
+				return bytes.Equal(u.PublicKeyHash, publicKeyHash)
+			})
+			block = append([]byte{}, hashAt(height)...)
+			return nil
+		})
+		return result, block, err
+	}
+}
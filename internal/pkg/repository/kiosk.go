@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/kiosk"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+func burnedTokensBucket() []byte {
+	return []byte("burned-tokens")
+}
+
+type burnedToken struct {
+	VoterHash     []byte `json:"voter"`
+	TransactionID []byte `json:"transactionId"`
+	BurnedAt      int64  `json:"burnedAt"`
+}
+
+// CastKioskVote casts the vote on behalf of the voter the token is bound to
+// and burns the token in the same database transaction. The vote input is
+// signed by the alfa node which vouches for the token.
+func CastKioskVote(db *bolt.DB, orderOutputs transaction.OrderOutputsFn, validate transaction.ValidateFn, release transaction.ReleaseFn, signer wallet.Signer, verifier []byte) kiosk.CastVoteFn {
+	return func(token kiosk.Token, to []byte) (transaction.Transaction, error) {
+		var result transaction.Transaction
+		err := updateValidated(db, validate, release, func(tx *bolt.Tx, validate transaction.ValidateFn) error {
+			b, err := tx.CreateBucketIfNotExists(burnedTokensBucket())
+			if err != nil {
+				return errors.Wrapf(err, "Failed to create bucket %s", burnedTokensBucket())
+			}
+			if b.Get(token.ID) != nil {
+				return kiosk.ErrTokenBurned
+			}
+			signature, err := transaction.SignVote(signer, token.VoterHash, to)
+			if err != nil {
+				return errors.Wrap(err, "Failed to sign kiosk vote")
+			}
+			tr, err := castVote(tx, token.VoterHash, to, signature, verifier, orderOutputs, validate)
+			if err != nil {
+				return err
+			}
+			raw, err := json.Marshal(burnedToken{
+				VoterHash:     token.VoterHash,
+				TransactionID: tr.ID,
+				BurnedAt:      time.Now().Unix(),
+			})
+			if err != nil {
+				return errors.Wrap(err, "Failed to serialize burned token")
+			}
+			if err := b.Put(token.ID, raw); err != nil {
+				return errors.Wrapf(err, "Failed to burn token %x", token.ID)
+			}
+			result = *tr
+			return nil
+		})
+		return result, err
+	}
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"sort"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/limits"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+func GetDatabaseSize(db *bolt.DB) limits.UsageFn {
+	return func() (int64, error) {
+		var result int64
+		err := db.View(func(tx *bolt.Tx) error {
+			result = tx.Size()
+			return nil
+		})
+		return result, err
+	}
+}
+
+func mempoolSize(tx *bolt.Tx) int64 {
+	b := tx.Bucket(transactionsBucket())
+	if b == nil {
+		return 0
+	}
+	var size int64
+	b.ForEach(func(key, value []byte) error {
+		size += int64(len(key) + len(value))
+		return nil
+	})
+	return size
+}
+
+// GetMempoolSize returns the size of the stored pending transactions.
+func GetMempoolSize(db *bolt.DB) limits.UsageFn {
+	return func() (int64, error) {
+		var result int64
+		err := db.View(func(tx *bolt.Tx) error {
+			result = mempoolSize(tx)
+			return nil
+		})
+		return result, err
+	}
+}
+
+func ShedTransactions(db *bolt.DB) limits.ShedFn {
+	return func(maxBytes int64, less limits.LessFn) (transaction.Transactions, error) {
+		var shed transaction.Transactions
+		err := db.Update(func(tx *bolt.Tx) error {
+			size := mempoolSize(tx)
+			if size <= maxBytes {
+				return nil
+			}
+			b := tx.Bucket(transactionsBucket())
+			type sized struct {
+				transaction transaction.Transaction
+				size        int64
+			}
+			var pending []sized
+			err := b.ForEach(func(key, value []byte) error {
+				t, err := decodeTransaction(value)
+				if err != nil {
+					return err
+				}
+				pending = append(pending, sized{transaction: t, size: int64(len(key) + len(value))})
+				return nil
+			})
+			if err != nil {
+				return errors.Wrap(err, "Failed to read pending transactions")
+			}
+			sort.SliceStable(pending, func(i, j int) bool {
+				return less(pending[i].transaction, pending[j].transaction)
+			})
+			for _, p := range pending {
+				if size <= maxBytes {
+					break
+				}
+				if err := deleteTransaction(tx, p.transaction); err != nil {
+					return err
+				}
+				size -= p.size
+				shed = append(shed, p.transaction)
+			}
+			return nil
+		})
+		return shed, err
+	}
+}
@@ -0,0 +1,256 @@
+package repository
+
+import (
+	"bytes"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/codec"
+	"github.com/pkg/errors"
+)
+
+type MigrationProgressFn func(bucket string, migrated, total int)
+
+type migration struct {
+	bucket  []byte
+	skip    func(key []byte) bool
+	convert func(raw []byte) ([]byte, error)
+}
+
+type migrationState struct {
+	lastKey  []byte
+	migrated int
+	done     bool
+}
+
+func migrationsBucket() []byte {
+	return []byte("migrations")
+}
+
+func binaryMigrationKey(bucket []byte) []byte {
+	return append([]byte("binary/"), bucket...)
+}
+
+func binaryMigrations() []migration {
+	noSkip := func([]byte) bool { return false }
+	return []migration{
+		{
+			bucket: blocksBucket(),
+			skip: func(key []byte) bool {
+				return bytes.Compare(key, tipKey()) == 0
+			},
+			convert: func(raw []byte) ([]byte, error) {
+				b, err := decodeBlock(raw)
+				if err != nil {
+					return nil, err
+				}
+				return encodeBlock(b), nil
+			},
+		},
+		{
+			bucket: transactionsBucket(),
+			skip:   noSkip,
+			convert: func(raw []byte) ([]byte, error) {
+				t, err := decodeTransaction(raw)
+				if err != nil {
+					return nil, err
+				}
+				return encodeTransaction(t), nil
+			},
+		},
+	}
+}
+
+func getMigrationState(tx *bolt.Tx, key []byte) (migrationState, error) {
+	b := tx.Bucket(migrationsBucket())
+	if b == nil {
+		return migrationState{}, nil
+	}
+	raw := b.Get(key)
+	if raw == nil {
+		return migrationState{}, nil
+	}
+	r := codec.NewReader(raw)
+	state := migrationState{
+		lastKey:  r.Bytes(),
+		migrated: int(r.Uint()),
+		done:     r.Byte() == 1,
+	}
+	if r.Err() != nil {
+		return migrationState{}, errors.Wrapf(r.Err(), "Failed to decode migration state %s", key)
+	}
+	return state, nil
+}
+
+func saveMigrationState(tx *bolt.Tx, key []byte, state migrationState) error {
+	b, err := tx.CreateBucketIfNotExists(migrationsBucket())
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create bucket %s", migrationsBucket())
+	}
+	done := byte(0)
+	if state.done {
+		done = 1
+	}
+	raw := codec.NewWriter().
+		Bytes(state.lastKey).
+		Uint(uint64(state.migrated)).
+		Byte(done).
+		Result()
+	return b.Put(key, raw)
+}
+
+func migrateBatch(tx *bolt.Tx, m migration, batchSize int) (migrationState, int, error) {
+	key := binaryMigrationKey(m.bucket)
+	state, err := getMigrationState(tx, key)
+	if err != nil {
+		return migrationState{}, 0, err
+	}
+	b := tx.Bucket(m.bucket)
+	if b == nil {
+		state.done = true
+		return state, 0, nil
+	}
+	if state.done {
+		return state, b.Stats().KeyN, nil
+	}
+	type record struct {
+		key   []byte
+		value []byte
+	}
+	batch := []record{}
+	c := b.Cursor()
+	k, v := c.First()
+	if state.lastKey != nil {
+		k, v = c.Seek(state.lastKey)
+		if k != nil && bytes.Compare(k, state.lastKey) == 0 {
+			k, v = c.Next()
+		}
+	}
+	visited := 0
+	for ; k != nil && visited < batchSize; k, v = c.Next() {
+		visited++
+		state.lastKey = append([]byte{}, k...)
+		state.migrated++
+		if m.skip(k) || v == nil || isBinary(v) {
+			continue
+		}
+		converted, err := m.convert(v)
+		if err != nil {
+			return migrationState{}, 0, errors.Wrapf(err, "Failed to convert record %x of bucket %s", k, m.bucket)
+		}
+		batch = append(batch, record{key: state.lastKey, value: converted})
+	}
+	state.done = k == nil
+	for _, r := range batch {
+		if err := b.Put(r.key, r.value); err != nil {
+			return migrationState{}, 0, errors.Wrapf(err, "Failed to save converted record %x", r.key)
+		}
+	}
+	if err := saveMigrationState(tx, key, state); err != nil {
+		return migrationState{}, 0, errors.Wrap(err, "Failed to save migration progress")
+	}
+	return state, b.Stats().KeyN, nil
+}
+
+// runMigration runs the steps until the migration is done, every step in its
+// own database transaction.
+func runMigration(db *bolt.DB, name string, step func(*bolt.Tx) (migrationState, int, error), progress MigrationProgressFn) error {
+	for {
+		var state migrationState
+		var total int
+		err := db.Update(func(tx *bolt.Tx) error {
+			s, t, err := step(tx)
+			state, total = s, t
+			return err
+		})
+		if err != nil {
+			return errors.Wrapf(err, "Failed to migrate bucket %s", name)
+		}
+		if total > 0 || state.migrated > 0 {
+			progress(name, state.migrated, total)
+		}
+		if state.done {
+			return nil
+		}
+	}
+}
+
+func MigrateToBinary(db *bolt.DB, batchSize int, progress MigrationProgressFn) error {
+	if batchSize <= 0 {
+		return errors.Errorf("Invalid batch size %d", batchSize)
+	}
+	for _, m := range binaryMigrations() {
+		m := m
+		step := func(tx *bolt.Tx) (migrationState, int, error) {
+			return migrateBatch(tx, m, batchSize)
+		}
+		if err := runMigration(db, string(m.bucket), step, progress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func utxoLayoutMigrationKey() []byte {
+	return []byte("layout/utxos")
+}
+
+// migrateUTXOBatch moves a batch of utxo arrays of the legacy transaction id
+// bucket into one record per utxo. The legacy public key bucket holds the
+// same utxos, both are deleted once the last batch is moved.
+func migrateUTXOBatch(tx *bolt.Tx, batchSize int) (migrationState, int, error) {
+	key := utxoLayoutMigrationKey()
+	state, err := getMigrationState(tx, key)
+	if err != nil {
+		return migrationState{}, 0, err
+	}
+	legacy := tx.Bucket(legacyUTXOByTxBucket())
+	if legacy == nil {
+		return migrationState{done: true}, 0, nil
+	}
+	total := legacy.Stats().KeyN
+	c := legacy.Cursor()
+	k, v := c.First()
+	if state.lastKey != nil {
+		k, v = c.Seek(state.lastKey)
+		if k != nil && bytes.Equal(k, state.lastKey) {
+			k, v = c.Next()
+		}
+	}
+	for visited := 0; k != nil && visited < batchSize; k, v = c.Next() {
+		visited++
+		state.lastKey = append([]byte{}, k...)
+		state.migrated++
+		utxos, err := decodeUTXOs(v)
+		if err != nil {
+			return migrationState{}, 0, errors.Wrapf(err, "Failed to decode utxos of transaction %x", k)
+		}
+		if err := saveUTXOs(tx, utxos); err != nil {
+			return migrationState{}, 0, err
+		}
+	}
+	state.done = k == nil
+	if state.done {
+		for _, name := range [][]byte{legacyUTXOByTxBucket(), legacyUTXOByPublicKeyBucket()} {
+			if err := tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+				return migrationState{}, 0, errors.Wrapf(err, "Failed to delete bucket %s", name)
+			}
+		}
+	}
+	if err := saveMigrationState(tx, key, state); err != nil {
+		return migrationState{}, 0, errors.Wrap(err, "Failed to save migration progress")
+	}
+	return state, total, nil
+}
+
+// MigrateUTXOs moves the utxos of a database written before one record per
+// utxo was kept into the current buckets. It does nothing once they are
+// moved, so it's safe to run on every start.
+func MigrateUTXOs(db *bolt.DB, batchSize int, progress MigrationProgressFn) error {
+	if batchSize <= 0 {
+		return errors.Errorf("Invalid batch size %d", batchSize)
+	}
+	step := func(tx *bolt.Tx) (migrationState, int, error) {
+		return migrateUTXOBatch(tx, batchSize)
+	}
+	return runMigration(db, string(legacyUTXOByTxBucket()), step, progress)
+}
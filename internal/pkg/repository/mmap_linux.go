@@ -0,0 +1,7 @@
+package repository
+
+import "syscall"
+
+var platformMmapFlags = map[string]int{
+	"populate": syscall.MAP_POPULATE,
+}
@@ -0,0 +1,6 @@
+//go:build !linux
+// +build !linux
+
+package repository
+
+var platformMmapFlags = map[string]int{}
@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/stake"
+	"github.com/pkg/errors"
+)
+
+func nodesBucket() []byte {
+	return []byte("nodes")
+}
+
+func SaveNode(db *bolt.DB) stake.SaveNodeFn {
+	return func(nodeID string, keyHash []byte) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists(nodesBucket())
+			if err != nil {
+				return errors.Wrapf(err, "Failed to create bucket %s", nodesBucket())
+			}
+			if err := b.Put([]byte(nodeID), keyHash); err != nil {
+				return errors.Wrapf(err, "Failed to save node %s", nodeID)
+			}
+			return nil
+		})
+	}
+}
+
+func GetNodes(db *bolt.DB) stake.GetNodesFn {
+	return func() (map[string][]byte, error) {
+		result := make(map[string][]byte)
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(nodesBucket())
+			if b == nil {
+				return nil
+			}
+			return b.ForEach(func(key, value []byte) error {
+				result[string(key)] = append([]byte{}, value...)
+				return nil
+			})
+		})
+		return result, err
+	}
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/observer"
+	"github.com/pkg/errors"
+)
+
+func observerKeysBucket() []byte {
+	return []byte("observer_keys")
+}
+
+func SaveObserverKey(db *bolt.DB) observer.SaveFn {
+	return func(k observer.Key) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists(observerKeysBucket())
+			if err != nil {
+				return errors.Wrapf(err, "Failed to create bucket %s", observerKeysBucket())
+			}
+			raw, err := json.Marshal(k)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to serialize observer key %s", k.ID)
+			}
+			if err := b.Put([]byte(k.ID), raw); err != nil {
+				return errors.Wrapf(err, "Failed to save observer key %s", k.ID)
+			}
+			return nil
+		})
+	}
+}
+
+func GetObserverKey(db *bolt.DB) observer.GetFn {
+	return func(id string) (*observer.Key, error) {
+		var result *observer.Key
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(observerKeysBucket())
+			if b == nil {
+				return nil
+			}
+			raw := b.Get([]byte(id))
+			if raw == nil {
+				return nil
+			}
+			var k observer.Key
+			if err := json.Unmarshal(raw, &k); err != nil {
+				return errors.Wrapf(err, "Failed to unmarshal observer key %s", id)
+			}
+			result = &k
+			return nil
+		})
+		return result, err
+	}
+}
+
+func GetObserverKeys(db *bolt.DB) observer.ListFn {
+	return func() (observer.Keys, error) {
+		result := observer.Keys{}
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(observerKeysBucket())
+			if b == nil {
+				return nil
+			}
+			return b.ForEach(func(key, value []byte) error {
+				var k observer.Key
+				if err := json.Unmarshal(value, &k); err != nil {
+					return errors.Wrapf(err, "Failed to unmarshal observer key %s", key)
+				}
+				result = append(result, k.Public())
+				return nil
+			})
+		})
+		return result, err
+	}
+}
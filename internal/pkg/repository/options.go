@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// Options configure how the database is opened. NoSync skips fsync after
+// every commit and is meant for benchmarks only, a crash may corrupt the
+// database.
+type Options struct {
+	NoSync          bool
+	Timeout         time.Duration
+	MmapFlags       string
+	InitialMmapSize int
+}
+
+// mmapFlags maps the names of supported mmap flags to their values on this
+// platform.
+func mmapFlags(raw string) (int, error) {
+	result := 0
+	for _, name := range strings.Split(raw, ",") {
+		if name = strings.TrimSpace(strings.ToLower(name)); name == "" {
+			continue
+		}
+		flag, ok := platformMmapFlags[name]
+		if !ok {
+			return 0, errors.Errorf("Mmap flag %s is not supported on this platform", name)
+		}
+		result |= flag
+	}
+	return result, nil
+}
+
+// Open opens the bolt database at the path with the options.
+func Open(path string, o Options) (*bolt.DB, error) {
+	flags, err := mmapFlags(o.MmapFlags)
+	if err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{
+		Timeout:         o.Timeout,
+		MmapFlags:       flags,
+		InitialMmapSize: o.InitialMmapSize,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open database %s", path)
+	}
+	if o.NoSync {
+		log.Printf("WARNING: database %s is not synced to disk after commits", path)
+		db.NoSync = true
+	}
+	return db, nil
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/outbox"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+func outboxBucket() []byte {
+	return []byte("outbox")
+}
+
+func saveOutboxEntry(tx *bolt.Tx, pong websocket.Pong) error {
+	b, err := tx.CreateBucketIfNotExists(outboxBucket())
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create bucket %s", outboxBucket())
+	}
+	entry, err := outbox.NewEntry(pong)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create outbox entry for message %d", pong.Message)
+	}
+	id, err := b.NextSequence()
+	if err != nil {
+		return errors.Wrap(err, "Failed to generate outbox entry id")
+	}
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to serialize outbox entry %#v", entry)
+	}
+	if err := b.Put(sequenceKey(id), raw); err != nil {
+		return errors.Wrapf(err, "Failed to save outbox entry %d", id)
+	}
+	return nil
+}
+
+func GetPendingBroadcasts(db *bolt.DB) outbox.GetPendingFn {
+	return func(limit int) (outbox.Entries, error) {
+		result := outbox.Entries{}
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(outboxBucket())
+			if b == nil {
+				return nil
+			}
+			c := b.Cursor()
+			for key, value := c.First(); key != nil && len(result) < limit; key, value = c.Next() {
+				var entry outbox.Entry
+				if err := json.Unmarshal(value, &entry); err != nil {
+					return errors.Wrapf(err, "Failed to unmarshal outbox entry %x", key)
+				}
+				entry.ID = binary.BigEndian.Uint64(key)
+				result = append(result, entry)
+			}
+			return nil
+		})
+		return result, err
+	}
+}
+
+func RemoveBroadcast(db *bolt.DB) outbox.RemoveFn {
+	return func(id uint64) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket(outboxBucket())
+			if b == nil {
+				return nil
+			}
+			if err := b.Delete(sequenceKey(id)); err != nil {
+				return errors.Wrapf(err, "Failed to remove outbox entry %d", id)
+			}
+			return nil
+		})
+	}
+}
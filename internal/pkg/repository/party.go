@@ -9,8 +9,9 @@ import (
 )
 
 type party struct {
-	Name    string `json:"name"`
-	Address string `json:"address"`
+	Name     string `json:"name"`
+	Address  string `json:"address"`
+	Question string `json:"question,omitempty"`
 }
 
 func partiesBucket() []byte {
@@ -19,15 +20,17 @@ func partiesBucket() []byte {
 
 func newParty(p _party.Party) party {
 	return party{
-		Address: p.Address,
-		Name:    p.Name,
+		Address:  p.Address,
+		Name:     p.Name,
+		Question: p.Question,
 	}
 }
 
 func (p party) toParty() _party.Party {
 	return _party.Party{
-		Address: p.Address,
-		Name:    p.Name,
+		Address:  p.Address,
+		Name:     p.Name,
+		Question: p.Question,
 	}
 }
 
@@ -0,0 +1,202 @@
+package repository
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/provisional"
+	"github.com/nebser/crypto-vote/internal/pkg/registration"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+// ProvisionalRegistrationProvider is the provider of registrations created
+// by accepting a provisional ballot.
+const ProvisionalRegistrationProvider = "provisional"
+
+func provisionalBucket() []byte {
+	return []byte("provisional")
+}
+
+func getProvisionalBallot(tx *bolt.Tx, address string) (*provisional.Ballot, error) {
+	b := tx.Bucket(provisionalBucket())
+	if b == nil {
+		return nil, provisional.ErrNotFound
+	}
+	raw := b.Get([]byte(address))
+	if raw == nil {
+		return nil, provisional.ErrNotFound
+	}
+	var result provisional.Ballot
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, errors.Wrapf(err, "Failed to unmarshal provisional ballot of %s", address)
+	}
+	return &result, nil
+}
+
+func saveProvisionalBallot(tx *bolt.Tx, ballot provisional.Ballot) error {
+	b, err := tx.CreateBucketIfNotExists(provisionalBucket())
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create bucket %s", provisionalBucket())
+	}
+	raw, err := json.Marshal(ballot)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to serialize provisional ballot %#v", ballot)
+	}
+	if err := b.Put([]byte(ballot.Address), raw); err != nil {
+		return errors.Wrapf(err, "Failed to save provisional ballot of %s", ballot.Address)
+	}
+	return nil
+}
+
+func getProvisionalBallots(tx *bolt.Tx, status provisional.Status) (provisional.Ballots, error) {
+	result := provisional.Ballots{}
+	b := tx.Bucket(provisionalBucket())
+	if b == nil {
+		return result, nil
+	}
+	err := b.ForEach(func(key, value []byte) error {
+		var ballot provisional.Ballot
+		if err := json.Unmarshal(value, &ballot); err != nil {
+			return errors.Wrapf(err, "Failed to unmarshal provisional ballot %s", key)
+		}
+		if status == "" || ballot.Status == status {
+			result = append(result, ballot)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// SubmitProvisionalBallot allows a single provisional ballot per address and
+// per member. Voters who are already registered vote on /ballot.
+func SubmitProvisionalBallot(db *bolt.DB) provisional.SubmitFn {
+	return func(ballot provisional.Ballot) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			if members := tx.Bucket(registeredMembersBucket()); members != nil && members.Get([]byte(ballot.MemberID)) != nil {
+				return registration.ErrAlreadyRegistered
+			}
+			if b := tx.Bucket(registrationsBucket()); b != nil && b.Get([]byte(ballot.Address)) != nil {
+				return registration.ErrAlreadyRegistered
+			}
+			ballots, err := getProvisionalBallots(tx, "")
+			if err != nil {
+				return err
+			}
+			for _, b := range ballots {
+				if b.Address == ballot.Address || b.MemberID == ballot.MemberID {
+					return provisional.ErrAlreadySubmitted
+				}
+			}
+			return saveProvisionalBallot(tx, ballot)
+		})
+	}
+}
+
+func GetProvisionalBallots(db *bolt.DB) provisional.GetFn {
+	return func(status provisional.Status) (provisional.Ballots, error) {
+		var result provisional.Ballots
+		err := db.View(func(tx *bolt.Tx) error {
+			ballots, err := getProvisionalBallots(tx, status)
+			result = ballots
+			return err
+		})
+		return result, err
+	}
+}
+
+func decideProvisionalBallot(tx *bolt.Tx, address, reason string, status provisional.Status) (*provisional.Ballot, error) {
+	ballot, err := getProvisionalBallot(tx, address)
+	switch {
+	case err != nil:
+		return nil, err
+	case ballot.Status != provisional.Pending:
+		return nil, provisional.ErrAlreadyDecided
+	}
+	ballot.Status = status
+	ballot.Reason = reason
+	ballot.DecidedAt = time.Now().Unix()
+	if err := saveProvisionalBallot(tx, *ballot); err != nil {
+		return nil, err
+	}
+	return ballot, nil
+}
+
+// AcceptProvisionalBallot registers the voter in the same transaction so the
+// registration job funds the voter like any other registered voter.
+func AcceptProvisionalBallot(db *bolt.DB) provisional.AcceptFn {
+	return func(address, reason string) (*provisional.Ballot, error) {
+		var result *provisional.Ballot
+		err := db.Update(func(tx *bolt.Tx) error {
+			ballot, err := decideProvisionalBallot(tx, address, reason, provisional.Accepted)
+			if err != nil {
+				return err
+			}
+			err = registerVoter(tx, registration.Registration{
+				Address:      ballot.Address,
+				MemberID:     ballot.MemberID,
+				Provider:     ProvisionalRegistrationProvider,
+				RegisteredAt: ballot.DecidedAt,
+			})
+			if err != nil {
+				return err
+			}
+			result = ballot
+			return nil
+		})
+		return result, err
+	}
+}
+
+func RejectProvisionalBallot(db *bolt.DB) provisional.RejectFn {
+	return func(address, reason string) (*provisional.Ballot, error) {
+		var result *provisional.Ballot
+		err := db.Update(func(tx *bolt.Tx) error {
+			ballot, err := decideProvisionalBallot(tx, address, reason, provisional.Rejected)
+			result = ballot
+			return err
+		})
+		return result, err
+	}
+}
+
+// CastProvisionalBallot spends the vote the accepted voter has been funded
+// with. It fails with transaction.ErrInsufficientVotes until the funding
+// transaction is in the blockchain.
+func CastProvisionalBallot(db *bolt.DB, orderOutputs transaction.OrderOutputsFn, validate transaction.ValidateFn, release transaction.ReleaseFn) provisional.CastFn {
+	return func(address string) (*provisional.Ballot, error) {
+		var result *provisional.Ballot
+		err := updateValidated(db, validate, release, func(tx *bolt.Tx, validate transaction.ValidateFn) error {
+			ballot, err := getProvisionalBallot(tx, address)
+			switch {
+			case err != nil:
+				return err
+			case ballot.Status != provisional.Accepted:
+				return errors.Errorf("Provisional ballot of %s is %s", address, ballot.Status)
+			}
+			tr, err := castBallot(
+				tx,
+				wallet.ExtractPublicKeyHash(address),
+				ballot.Recipients,
+				ballot.Value,
+				ballot.Signature,
+				ballot.PublicKey,
+				orderOutputs,
+				validate,
+			)
+			if err != nil {
+				return err
+			}
+			ballot.Status = provisional.Cast
+			ballot.Transaction = tr.ID
+			if err := saveProvisionalBallot(tx, *ballot); err != nil {
+				return err
+			}
+			result = ballot
+			return nil
+		})
+		return result, err
+	}
+}
@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/registration"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+func registrationsBucket() []byte {
+	return []byte("registrations")
+}
+
+func registeredMembersBucket() []byte {
+	return []byte("registered-members")
+}
+
+func saveRegistration(tx *bolt.Tx, r registration.Registration) error {
+	b, err := tx.CreateBucketIfNotExists(registrationsBucket())
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create bucket %s", registrationsBucket())
+	}
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to serialize registration %#v", r)
+	}
+	if err := b.Put([]byte(r.Address), raw); err != nil {
+		return errors.Wrapf(err, "Failed to save registration of %s", r.Address)
+	}
+	return nil
+}
+
+func registerVoter(tx *bolt.Tx, r registration.Registration) error {
+	members, err := tx.CreateBucketIfNotExists(registeredMembersBucket())
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create bucket %s", registeredMembersBucket())
+	}
+	if members.Get([]byte(r.MemberID)) != nil {
+		return registration.ErrAlreadyRegistered
+	}
+	if b := tx.Bucket(registrationsBucket()); b != nil && b.Get([]byte(r.Address)) != nil {
+		return registration.ErrAlreadyRegistered
+	}
+	if err := members.Put([]byte(r.MemberID), []byte(r.Address)); err != nil {
+		return errors.Wrapf(err, "Failed to save member %s", r.MemberID)
+	}
+	return saveRegistration(tx, r)
+}
+
+// RegisterVoter allows a single registration per address and per member.
+func RegisterVoter(db *bolt.DB) registration.SaveFn {
+	return func(r registration.Registration) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			return registerVoter(tx, r)
+		})
+	}
+}
+
+func GetPendingRegistrations(db *bolt.DB) registration.GetPendingFn {
+	return func(limit int) (registration.Registrations, error) {
+		result := registration.Registrations{}
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(registrationsBucket())
+			if b == nil {
+				return nil
+			}
+			c := b.Cursor()
+			for key, value := c.First(); key != nil && len(result) < limit; key, value = c.Next() {
+				var r registration.Registration
+				if err := json.Unmarshal(value, &r); err != nil {
+					return errors.Wrapf(err, "Failed to unmarshal registration %s", key)
+				}
+				if !r.Funded() {
+					result = append(result, r)
+				}
+			}
+			return nil
+		})
+		return result, err
+	}
+}
+
+func FundRegistrations(db *bolt.DB, validate transaction.ValidateFn, release transaction.ReleaseFn) registration.FundFn {
+	return func(t transaction.Transaction, registrations registration.Registrations) error {
+		return updateValidated(db, validate, release, func(tx *bolt.Tx, validate transaction.ValidateFn) error {
+			if err := validate(t); err != nil {
+				return err
+			}
+			if err := saveTransaction(tx, t); err != nil {
+				return errors.Wrap(err, "Failed to save funding transaction")
+			}
+			if err := saveOutboxEntry(tx, websocket.NewTransactionReceivedPong(t)); err != nil {
+				return errors.Wrap(err, "Failed to schedule funding transaction broadcast")
+			}
+			for _, r := range registrations {
+				r.FundingTransaction = t.ID
+				if err := saveRegistration(tx, r); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package repository
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/replay"
+	"github.com/pkg/errors"
+)
+
+// Snapshot copies the database consistently while the node keeps running.
+func Snapshot(db *bolt.DB) replay.SnapshotFn {
+	return func(path string) error {
+		err := db.View(func(tx *bolt.Tx) error {
+			return tx.CopyFile(path, 0600)
+		})
+		return errors.Wrapf(err, "Failed to snapshot database to %s", path)
+	}
+}
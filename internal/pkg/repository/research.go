@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/research"
+	"github.com/pkg/errors"
+)
+
+func researchRequestsBucket() []byte {
+	return []byte("research_requests")
+}
+
+func SaveResearchRequest(db *bolt.DB) research.SaveFn {
+	return func(r research.Request) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists(researchRequestsBucket())
+			if err != nil {
+				return errors.Wrapf(err, "Failed to create bucket %s", researchRequestsBucket())
+			}
+			raw, err := json.Marshal(r)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to serialize research request %s", r.ID)
+			}
+			if err := b.Put([]byte(r.ID), raw); err != nil {
+				return errors.Wrapf(err, "Failed to save research request %s", r.ID)
+			}
+			return nil
+		})
+	}
+}
+
+func GetResearchRequest(db *bolt.DB) research.GetFn {
+	return func(id string) (*research.Request, error) {
+		var result *research.Request
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(researchRequestsBucket())
+			if b == nil {
+				return nil
+			}
+			raw := b.Get([]byte(id))
+			if raw == nil {
+				return nil
+			}
+			var r research.Request
+			if err := json.Unmarshal(raw, &r); err != nil {
+				return errors.Wrapf(err, "Failed to unmarshal research request %s", id)
+			}
+			result = &r
+			return nil
+		})
+		return result, err
+	}
+}
+
+func GetResearchRequests(db *bolt.DB) research.ListFn {
+	return func() (research.Requests, error) {
+		result := research.Requests{}
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(researchRequestsBucket())
+			if b == nil {
+				return nil
+			}
+			return b.ForEach(func(key, value []byte) error {
+				var r research.Request
+				if err := json.Unmarshal(value, &r); err != nil {
+					return errors.Wrapf(err, "Failed to unmarshal research request %s", key)
+				}
+				result = append(result, r.Public())
+				return nil
+			})
+		})
+		return result, err
+	}
+}
@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"bytes"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/emergency"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+func rolledBackBucket() []byte {
+	return []byte("rolled_back_transactions")
+}
+
+func InvalidateReceipts(db *bolt.DB) emergency.InvalidateFn {
+	return func(transactions [][]byte, rollback []byte) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists(rolledBackBucket())
+			if err != nil {
+				return errors.Wrapf(err, "Failed to create bucket %s", rolledBackBucket())
+			}
+			for _, id := range transactions {
+				if err := b.Put(id, rollback); err != nil {
+					return errors.Wrapf(err, "Failed to invalidate receipt of transaction %x", id)
+				}
+			}
+			return nil
+		})
+	}
+}
+
+func GetInvalidation(db *bolt.DB) emergency.GetInvalidationFn {
+	return func(transaction []byte) ([]byte, error) {
+		var result []byte
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(rolledBackBucket())
+			if b == nil {
+				return nil
+			}
+			if raw := b.Get(transaction); raw != nil {
+				result = append([]byte{}, raw...)
+			}
+			return nil
+		})
+		return result, err
+	}
+}
+
+// forgetSpends removes the transaction from the spenders of its inputs, so
+// another transaction spending them isn't a double spend.
+func forgetSpends(tx *bolt.Tx, t transaction.Transaction) error {
+	b := tx.Bucket(spendsBucket())
+	if b == nil {
+		return nil
+	}
+	for _, in := range t.Inputs {
+		if in.Vout < 0 {
+			continue
+		}
+		key := outpointKey(in.TransactionID, in.Vout)
+		spends, err := getSpends(tx, key)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to get spends of %x %d", in.TransactionID, in.Vout)
+		}
+		kept := []transaction.Spend{}
+		for _, s := range spends {
+			if !bytes.Equal(s.Transaction, t.ID) {
+				kept = append(kept, s)
+			}
+		}
+		if len(kept) == 0 {
+			if err := b.Delete(key); err != nil {
+				return errors.Wrapf(err, "Failed to delete spends of %x %d", in.TransactionID, in.Vout)
+			}
+			continue
+		}
+		if err := b.Put(key, encodeSpends(kept)); err != nil {
+			return errors.Wrapf(err, "Failed to save spends of %x %d", in.TransactionID, in.Vout)
+		}
+	}
+	return nil
+}
+
+// DiscardRolledBack deletes a transaction a rollback returned to the pending
+// ones. The alfa node removes the inputs of a vote from the utxo set when
+// the vote is cast, so the outputs the transaction spent which are unspent
+// in the blockchain are restored and the voter can vote again.
+func DiscardRolledBack(db *bolt.DB) transaction.DeleteTransaction {
+	return func(t transaction.Transaction) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			if err := deleteTransaction(tx, t); err != nil {
+				return err
+			}
+			if err := forgetSpends(tx, t); err != nil {
+				return err
+			}
+			hashAt, height, err := chainHashes(tx)
+			if err != nil {
+				return err
+			}
+			set, err := utxoSetAt(tx, height, hashAt)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to compute utxo set at height %d", height)
+			}
+			var missing transaction.UTXOs
+			for _, in := range t.Inputs {
+				u, ok := set[utxoKey(in.TransactionID, in.Vout)]
+				if !ok {
+					continue
+				}
+				existing, err := getTransactionUTXO(tx, in.TransactionID, in.Vout)
+				if err != nil {
+					return errors.Wrapf(err, "Failed to get transaction utxo %x %d", in.TransactionID, in.Vout)
+				}
+				if existing == nil {
+					missing = append(missing, u)
+				}
+			}
+			if err := saveUTXOs(tx, missing); err != nil {
+				return errors.Wrapf(err, "Failed to restore utxos spent by transaction %x", t.ID)
+			}
+			return nil
+		})
+	}
+}
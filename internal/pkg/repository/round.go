@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/round"
+	"github.com/pkg/errors"
+)
+
+func roundsBucket() []byte {
+	return []byte("rounds")
+}
+
+func latestRound(b *bolt.Bucket) (*round.Round, error) {
+	_, raw := b.Cursor().Last()
+	if raw == nil {
+		return nil, nil
+	}
+	var r round.Round
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return nil, errors.Wrap(err, "Failed to unmarshal latest round")
+	}
+	return &r, nil
+}
+
+func saveRound(b *bolt.Bucket, r round.Round) error {
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to serialize round %#v", r)
+	}
+	if err := b.Put(sequenceKey(r.Number), raw); err != nil {
+		return errors.Wrapf(err, "Failed to save round %d", r.Number)
+	}
+	return nil
+}
+
+func StartRound(db *bolt.DB) round.StartFn {
+	return func(r round.Round) (*round.Round, error) {
+		err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists(roundsBucket())
+			if err != nil {
+				return errors.Wrapf(err, "Failed to create bucket %s", roundsBucket())
+			}
+			previous, err := latestRound(b)
+			if err != nil {
+				return err
+			}
+			if previous != nil && previous.Outcome == round.Pending {
+				previous.Outcome = round.Missed
+				previous.CompletedAt = r.StartedAt
+				if err := saveRound(b, *previous); err != nil {
+					return err
+				}
+			}
+			number, err := b.NextSequence()
+			if err != nil {
+				return errors.Wrap(err, "Failed to generate round number")
+			}
+			r.Number = number
+			return saveRound(b, r)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &r, nil
+	}
+}
+
+func CompleteRound(db *bolt.DB) round.CompleteFn {
+	return func(nodeID string, outcome round.Outcome) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket(roundsBucket())
+			if b == nil {
+				return nil
+			}
+			latest, err := latestRound(b)
+			if err != nil || latest == nil {
+				return err
+			}
+			switch {
+			case !latest.Sortition:
+				if latest.Outcome != round.Pending || latest.Selected != nodeID {
+					return nil
+				}
+			// Nobody is selected in sortition rounds, the node whose block
+			// came first is recorded unless a later one got on chain.
+			case latest.Outcome == round.Pending, latest.Outcome == round.Rejected && outcome == round.Forged:
+				latest.Selected = nodeID
+			default:
+				return nil
+			}
+			latest.Outcome = outcome
+			latest.CompletedAt = time.Now().Unix()
+			return saveRound(b, *latest)
+		})
+	}
+}
+
+func GetLatestRound(db *bolt.DB) round.GetLatestFn {
+	return func() (*round.Round, error) {
+		var result *round.Round
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(roundsBucket())
+			if b == nil {
+				return nil
+			}
+			latest, err := latestRound(b)
+			result = latest
+			return err
+		})
+		return result, err
+	}
+}
+
+func GetRounds(db *bolt.DB) round.GetRoundsFn {
+	return func(offset, limit int) (round.Rounds, int, error) {
+		result := round.Rounds{}
+		total := 0
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(roundsBucket())
+			if b == nil {
+				return nil
+			}
+			total = b.Stats().KeyN
+			c := b.Cursor()
+			skipped := 0
+			for key, value := c.Last(); key != nil && len(result) < limit; key, value = c.Prev() {
+				if skipped < offset {
+					skipped++
+					continue
+				}
+				var r round.Round
+				if err := json.Unmarshal(value, &r); err != nil {
+					return errors.Wrapf(err, "Failed to unmarshal round %x", key)
+				}
+				result = append(result, r)
+			}
+			return nil
+		})
+		return result, total, err
+	}
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/codec"
+	"github.com/nebser/crypto-vote/internal/pkg/stake"
+	"github.com/pkg/errors"
+)
+
+func burnedStakesBucket() []byte {
+	return []byte("burned-stakes")
+}
+
+func BurnStake(db *bolt.DB) stake.BurnFn {
+	return func(stakeID []byte, stakeholder []byte, reason string) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists(burnedStakesBucket())
+			if err != nil {
+				return errors.Wrapf(err, "Failed to create bucket %s", burnedStakesBucket())
+			}
+			raw := codec.NewWriter().
+				Bytes(stakeholder).
+				String(reason).
+				Int(time.Now().Unix()).
+				Result()
+			if err := b.Put(stakeID, raw); err != nil {
+				return errors.Wrapf(err, "Failed to burn stake %x", stakeID)
+			}
+			return nil
+		})
+	}
+}
+
+func IsStakeBurned(db *bolt.DB) stake.IsBurnedFn {
+	return func(stakeID []byte) (bool, error) {
+		result := false
+		err := db.View(func(tx *bolt.Tx) error {
+			if b := tx.Bucket(burnedStakesBucket()); b != nil {
+				result = b.Get(stakeID) != nil
+			}
+			return nil
+		})
+		return result, err
+	}
+}
@@ -2,11 +2,11 @@ package repository
 
 import (
 	"encoding/base64"
-	"encoding/json"
 	"sort"
 
 	"github.com/boltdb/bolt"
 	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
 	"github.com/pkg/errors"
 )
 
@@ -39,23 +39,6 @@ func (t tx) toTransaction() transaction.Transaction {
 	}
 }
 
-func newTX(transaction transaction.Transaction) tx {
-	inputs := []transactionInput{}
-	for _, in := range transaction.Inputs {
-		inputs = append(inputs, newTransactionInput(in))
-	}
-	outputs := []transactionOutput{}
-	for _, out := range transaction.Outputs {
-		outputs = append(outputs, newTransactionOutput(out))
-	}
-	return tx{
-		ID:        base64.StdEncoding.EncodeToString(transaction.ID),
-		Inputs:    inputs,
-		Outputs:   outputs,
-		Timestamp: transaction.Timestamp,
-	}
-}
-
 type transactionInput struct {
 	TransactionID string `json:"transactionId"`
 	Vout          int    `json:"vout"`
@@ -78,16 +61,6 @@ func (ti transactionInput) toInput() transaction.Input {
 	}
 }
 
-func newTransactionInput(input transaction.Input) transactionInput {
-	return transactionInput{
-		TransactionID: base64.StdEncoding.EncodeToString(input.TransactionID),
-		Vout:          input.Vout,
-		PublicKeyHash: base64.StdEncoding.EncodeToString(input.PublicKeyHash),
-		Signature:     base64.StdEncoding.EncodeToString(input.Signature),
-		Verifier:      base64.StdEncoding.EncodeToString(input.Verifier),
-	}
-}
-
 type transactionOutput struct {
 	Value         int    `json:"value"`
 	PublicKeyHash string `json:"publicKeyHash"`
@@ -101,52 +74,198 @@ func (to transactionOutput) toOutput() transaction.Output {
 	}
 }
 
-func newTransactionOutput(output transaction.Output) transactionOutput {
-	return transactionOutput{
-		Value:         output.Value,
-		PublicKeyHash: base64.StdEncoding.EncodeToString(output.PublicKeyHash),
+func castVote(tx *bolt.Tx, from, to, signature, verifier []byte, orderOutputs transaction.OrderOutputsFn, validate transaction.ValidateFn) (*transaction.Transaction, error) {
+	utxos, err := getUTXOsByPublicKey(tx, from)
+	switch {
+	case err != nil:
+		return nil, errors.Wrapf(err, "Failed to retrieve utxos for %x", from)
+	case len(utxos) == 0:
+		return nil, transaction.ErrInsufficientVotes
+	}
+	usedUTXO := utxos[0]
+	inputs := transaction.Inputs{
+		{
+			PublicKeyHash: from,
+			Signature:     signature,
+			TransactionID: usedUTXO.TransactionID,
+			Vout:          usedUTXO.Vout,
+			Verifier:      verifier,
+		},
+	}
+	outputs := transaction.Outputs{
+		transaction.Output{
+			PublicKeyHash: to,
+			Value:         transaction.VoteValue,
+		},
+	}
+	if usedUTXO.Value > transaction.VoteValue {
+		outputs = append(outputs, transaction.Output{
+			PublicKeyHash: from,
+			Value:         usedUTXO.Value - transaction.VoteValue,
+		})
+	}
+	tr, err := newTransaction(tx, inputs, orderOutputs(outputs))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create new transaction")
 	}
+	if err := validate(*tr); err != nil {
+		return nil, err
+	}
+	if err := saveTransaction(tx, *tr); err != nil {
+		return nil, errors.Wrap(err, "Failed to save transaction")
+	}
+	if err := recordSpends(tx, *tr, transaction.SourceVote, nil); err != nil {
+		return nil, errors.Wrap(err, "Failed to record spends")
+	}
+	if err := saveOutboxEntry(tx, websocket.NewTransactionReceivedPong(*tr)); err != nil {
+		return nil, errors.Wrap(err, "Failed to schedule transaction broadcast")
+	}
+	return tr, nil
 }
 
-func CastVote(db *bolt.DB) transaction.CastVote {
-	return func(from, to, signature, verifier []byte) (transaction.Transaction, error) {
+func castBallot(tx *bolt.Tx, from []byte, to [][]byte, value int, signature, verifier []byte, orderOutputs transaction.OrderOutputsFn, validate transaction.ValidateFn) (*transaction.Transaction, error) {
+	utxos, err := getUTXOsByPublicKey(tx, from)
+	switch {
+	case err != nil:
+		return nil, errors.Wrapf(err, "Failed to retrieve utxos for %x", from)
+	case len(utxos) == 0 || utxos[0].Value != value:
+		return nil, transaction.ErrInsufficientVotes
+	}
+	usedUTXO := utxos[0]
+	inputs := transaction.Inputs{
+		{
+			PublicKeyHash: from,
+			Signature:     signature,
+			TransactionID: usedUTXO.TransactionID,
+			Vout:          usedUTXO.Vout,
+			Verifier:      verifier,
+		},
+	}
+	outputs := transaction.Outputs{}
+	for _, recipient := range to {
+		outputs = append(outputs, transaction.Output{
+			PublicKeyHash: recipient,
+			Value:         transaction.VoteValue,
+		})
+	}
+	if outputs.Sum() != usedUTXO.Value {
+		return nil, errors.Errorf("Ballot gives %d out of %d", outputs.Sum(), usedUTXO.Value)
+	}
+	tr, err := newTransaction(tx, inputs, orderOutputs(outputs))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create new transaction")
+	}
+	if err := validate(*tr); err != nil {
+		return nil, err
+	}
+	if err := saveTransaction(tx, *tr); err != nil {
+		return nil, errors.Wrap(err, "Failed to save transaction")
+	}
+	if err := recordSpends(tx, *tr, transaction.SourceBallot, nil); err != nil {
+		return nil, errors.Wrap(err, "Failed to record spends")
+	}
+	if err := saveOutboxEntry(tx, websocket.NewTransactionReceivedPong(*tr)); err != nil {
+		return nil, errors.Wrap(err, "Failed to schedule transaction broadcast")
+	}
+	return tr, nil
+}
+
+// CastBallot casts a ballot with several questions as a single transaction
+// spending the whole utxo of the voter.
+func CastBallot(db *bolt.DB, orderOutputs transaction.OrderOutputsFn, validate transaction.ValidateFn, release transaction.ReleaseFn) transaction.CastBallotFn {
+	return func(from []byte, to [][]byte, value int, signature, verifier []byte) (transaction.Transaction, error) {
 		var result transaction.Transaction
-		err := db.Update(func(tx *bolt.Tx) error {
-			utxos, err := getUTXOsByPublicKey(tx, from)
-			switch {
-			case err != nil:
-				return errors.Wrapf(err, "Failed to retrieve utxos for %x", from)
-			case len(utxos) == 0:
-				return transaction.ErrInsufficientVotes
-			}
-			usedUTXO := utxos[0]
-			inputs := transaction.Inputs{
-				{
-					PublicKeyHash: from,
-					Signature:     signature,
-					TransactionID: usedUTXO.TransactionID,
-					Vout:          usedUTXO.Vout,
-					Verifier:      verifier,
-				},
-			}
-			outputs := transaction.Outputs{
-				transaction.Output{
-					PublicKeyHash: to,
-					Value:         transaction.VoteValue,
-				},
-			}
-			if usedUTXO.Value > transaction.VoteValue {
-				outputs = append(outputs, transaction.Output{
-					PublicKeyHash: from,
-					Value:         usedUTXO.Value - transaction.VoteValue,
-				})
+		err := updateValidated(db, validate, release, func(tx *bolt.Tx, validate transaction.ValidateFn) error {
+			tr, err := castBallot(tx, from, to, value, signature, verifier, orderOutputs, validate)
+			if err != nil {
+				return err
 			}
-			tr, err := transaction.NewTransaction(inputs, outputs)
+			result = *tr
+			return nil
+		})
+		return result, err
+	}
+}
+
+// castAllocations spends as many utxos of the voter as the allocations need,
+// every input carries the same signature of the allocations.
+func castAllocations(tx *bolt.Tx, from []byte, allocations transaction.Allocations, signature, verifier []byte, orderOutputs transaction.OrderOutputsFn, validate transaction.ValidateFn) (*transaction.Transaction, error) {
+	utxos, err := getUTXOsByPublicKey(tx, from)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to retrieve utxos for %x", from)
+	}
+	var inputs transaction.Inputs
+	sum := 0
+	for _, utxo := range utxos {
+		if sum >= allocations.Value() {
+			break
+		}
+		sum += utxo.Value
+		inputs = append(inputs, transaction.Input{
+			PublicKeyHash: from,
+			Signature:     signature,
+			TransactionID: utxo.TransactionID,
+			Vout:          utxo.Vout,
+			Verifier:      verifier,
+		})
+	}
+	if sum < allocations.Value() {
+		return nil, transaction.ErrInsufficientVotes
+	}
+	outputs := transaction.Outputs{}
+	for _, a := range allocations {
+		outputs = append(outputs, transaction.Output{
+			PublicKeyHash: a.Recipient,
+			Value:         a.Credits * transaction.VoteValue,
+		})
+	}
+	if sum > allocations.Value() {
+		outputs = append(outputs, transaction.Output{
+			PublicKeyHash: from,
+			Value:         sum - allocations.Value(),
+		})
+	}
+	tr, err := newTransaction(tx, inputs, orderOutputs(outputs))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create new transaction")
+	}
+	if err := validate(*tr); err != nil {
+		return nil, err
+	}
+	if err := saveTransaction(tx, *tr); err != nil {
+		return nil, errors.Wrap(err, "Failed to save transaction")
+	}
+	if err := recordSpends(tx, *tr, transaction.SourceVote, nil); err != nil {
+		return nil, errors.Wrap(err, "Failed to record spends")
+	}
+	if err := saveOutboxEntry(tx, websocket.NewTransactionReceivedPong(*tr)); err != nil {
+		return nil, errors.Wrap(err, "Failed to schedule transaction broadcast")
+	}
+	return tr, nil
+}
+
+func CastAllocations(db *bolt.DB, orderOutputs transaction.OrderOutputsFn, validate transaction.ValidateFn, release transaction.ReleaseFn) transaction.CastAllocationsFn {
+	return func(from []byte, allocations transaction.Allocations, signature, verifier []byte) (transaction.Transaction, error) {
+		var result transaction.Transaction
+		err := updateValidated(db, validate, release, func(tx *bolt.Tx, validate transaction.ValidateFn) error {
+			tr, err := castAllocations(tx, from, allocations, signature, verifier, orderOutputs, validate)
 			if err != nil {
-				return errors.Wrap(err, "Failed to create new transaction")
+				return err
 			}
-			if err := saveTransaction(tx, *tr); err != nil {
-				return errors.Wrap(err, "Failed to save transaction")
+			result = *tr
+			return nil
+		})
+		return result, err
+	}
+}
+
+func CastVote(db *bolt.DB, orderOutputs transaction.OrderOutputsFn, validate transaction.ValidateFn, release transaction.ReleaseFn) transaction.CastVote {
+	return func(from, to, signature, verifier []byte) (transaction.Transaction, error) {
+		var result transaction.Transaction
+		err := updateValidated(db, validate, release, func(tx *bolt.Tx, validate transaction.ValidateFn) error {
+			tr, err := castVote(tx, from, to, signature, verifier, orderOutputs, validate)
+			if err != nil {
+				return err
 			}
 			result = *tr
 			return nil
@@ -164,11 +283,7 @@ func saveTransaction(tx *bolt.Tx, transaction transaction.Transaction) error {
 		}
 		b = created
 	}
-	raw, err := json.Marshal(newTX(transaction))
-	if err != nil {
-		return errors.Wrapf(err, "Failed to serialize transaction %#v", transactionsBucket())
-	}
-	if err := b.Put(transaction.ID, raw); err != nil {
+	if err := b.Put(transaction.ID, encodeTransaction(transaction)); err != nil {
 		return errors.Wrapf(err, "Failed to save transaction %s", transaction)
 	}
 	return nil
@@ -191,9 +306,16 @@ func getInputSum(tx *bolt.Tx, tr transaction.Transaction) (int, error) {
 
 func SaveTransaction(db *bolt.DB) transaction.SaveTransaction {
 	return func(tr transaction.Transaction) error {
-		return db.Update(func(tx *bolt.Tx) error {
+		// A transaction spending a missing output is refused, but its spends
+		// are still recorded so double spends are detected.
+		var rejected error
+		err := db.Update(func(tx *bolt.Tx) error {
 			sum, err := getInputSum(tx, tr)
-			if err != nil {
+			switch {
+			case errors.Is(err, transaction.ErrUTXONotFound):
+				rejected = err
+				return recordSpends(tx, tr, transaction.SourcePeer, nil)
+			case err != nil:
 				return err
 			}
 			if sum != tr.Outputs.Sum() {
@@ -202,11 +324,74 @@ func SaveTransaction(db *bolt.DB) transaction.SaveTransaction {
 			if err := saveTransaction(tx, tr); err != nil {
 				return errors.Wrap(err, "Failed to save transaction")
 			}
+			if err := recordSpends(tx, tr, transaction.SourcePeer, nil); err != nil {
+				return errors.Wrap(err, "Failed to record spends")
+			}
 			return nil
 		})
+		if err != nil {
+			return err
+		}
+		return rejected
 	}
 }
 
+func submitTransaction(tx *bolt.Tx, tr transaction.Transaction) error {
+	if err := saveTransaction(tx, tr); err != nil {
+		return errors.Wrap(err, "Failed to save transaction")
+	}
+	if err := recordSpends(tx, tr, transaction.SourceAlfa, nil); err != nil {
+		return errors.Wrap(err, "Failed to record spends")
+	}
+	if err := saveOutboxEntry(tx, websocket.NewTransactionReceivedPong(tr)); err != nil {
+		return errors.Wrap(err, "Failed to schedule transaction broadcast")
+	}
+	return nil
+}
+
+// SubmitTransaction saves the transaction and schedules its broadcast
+// through the outbox in the same database transaction.
+func SubmitTransaction(db *bolt.DB) transaction.SaveTransaction {
+	return func(tr transaction.Transaction) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			return submitTransaction(tx, tr)
+		})
+	}
+}
+
+// SubmitValidated submits transactions validate doesn't veto, the way
+// SubmitTransaction does.
+func SubmitValidated(db *bolt.DB, validate transaction.ValidateFn, release transaction.ReleaseFn) transaction.SaveTransaction {
+	return func(tr transaction.Transaction) error {
+		return updateValidated(db, validate, release, func(tx *bolt.Tx, validate transaction.ValidateFn) error {
+			if err := validate(tr); err != nil {
+				return err
+			}
+			return submitTransaction(tx, tr)
+		})
+	}
+}
+
+// updateValidated runs update in a database transaction. A transaction
+// validate admitted is released if the database transaction fails, so it
+// doesn't hold on to a reservation in the mempool.
+func updateValidated(db *bolt.DB, validate transaction.ValidateFn, release transaction.ReleaseFn, update func(*bolt.Tx, transaction.ValidateFn) error) error {
+	var admitted *transaction.Transaction
+	err := db.Update(func(tx *bolt.Tx) error {
+		return update(tx, func(t transaction.Transaction) error {
+			if err := validate(t); err != nil {
+				return err
+			}
+			admitted = &t
+			return nil
+		})
+	})
+	if err != nil && admitted != nil {
+		release(*admitted)
+	}
+	return err
+}
+
 func GetTransactions(db *bolt.DB) transaction.GetTransactionsFn {
 	return func() (transaction.Transactions, error) {
 		var transactions transaction.Transactions
@@ -217,11 +402,11 @@ func GetTransactions(db *bolt.DB) transaction.GetTransactionsFn {
 			}
 			cursor := b.Cursor()
 			for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
-				var t tx
-				if err := json.Unmarshal(value, &t); err != nil {
-					return errors.Wrapf(err, "Failed to unmarshal transaction %s", value)
+				t, err := decodeTransaction(value)
+				if err != nil {
+					return err
 				}
-				transactions = append(transactions, t.toTransaction())
+				transactions = append(transactions, t)
 			}
 			sort.Sort(transactions)
 			return nil
@@ -242,6 +427,14 @@ func deleteTransaction(tx *bolt.Tx, transaction transaction.Transaction) error {
 	return nil
 }
 
+func DeleteTransaction(db *bolt.DB) transaction.DeleteTransaction {
+	return func(t transaction.Transaction) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			return deleteTransaction(tx, t)
+		})
+	}
+}
+
 func deleteTransactions(tx *bolt.Tx, transactions transaction.Transactions) error {
 	for _, transaction := range transactions {
 		if err := deleteTransaction(tx, transaction); err != nil {
@@ -0,0 +1,50 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/kiosk"
+	"github.com/nebser/crypto-vote/internal/pkg/repository"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+)
+
+func TestFailedCastReleasesReservation(t *testing.T) {
+	store, db := newStore(t)
+	defer store.Close()
+	voter := []byte("voter")
+	addGenesis(t, store, digest.SHA256, voter)
+	w, err := wallet.New()
+	if err != nil {
+		t.Fatalf("Failed to create wallet %s", err)
+	}
+
+	var reserved, released []transaction.Transaction
+	reserve := func(t transaction.Transaction) error {
+		reserved = append(reserved, t)
+		return nil
+	}
+	release := func(t transaction.Transaction) {
+		released = append(released, t)
+	}
+	// Burning a token without an id fails after the vote is validated.
+	cast := repository.CastKioskVote(db, transaction.KeepOutputsOrder, reserve, release, wallet.NewSigner(*w), w.PublicKey)
+	if _, err := cast(kiosk.Token{VoterHash: voter}, []byte("party")); err == nil {
+		t.Fatal("Expected the cast to fail")
+	}
+	if len(reserved) != 1 || len(released) != 1 || string(released[0].ID) != string(reserved[0].ID) {
+		t.Errorf("Expected the reserved vote to be released, reserved %d and released %d", len(reserved), len(released))
+	}
+	if txs, err := store.GetTransactions(); err != nil || len(txs) != 0 {
+		t.Errorf("Expected no pending transaction, got %d %v", len(txs), err)
+	}
+
+	reserved, released = nil, nil
+	if _, err := cast(kiosk.Token{ID: []byte("token"), VoterHash: voter}, []byte("party")); err != nil {
+		t.Fatalf("Failed to cast vote %s", err)
+	}
+	if len(reserved) != 1 || len(released) != 0 {
+		t.Errorf("Expected the cast vote to keep its reservation, reserved %d and released %d", len(reserved), len(released))
+	}
+}
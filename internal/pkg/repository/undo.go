@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/codec"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+func undoBucket() []byte {
+	return []byte("block-undo")
+}
+
+func encodeUndo(u blockchain.Undo) []byte {
+	return codec.NewWriter().
+		Byte(binaryFormat).
+		Bytes(u.Block).
+		Int(int64(u.Height)).
+		Bytes(encodeUTXOs(u.Spent)).
+		Bytes(encodeUTXOs(u.Created)).
+		Result()
+}
+
+func decodeUndo(raw []byte) (*blockchain.Undo, error) {
+	r := codec.NewReader(raw)
+	r.Byte()
+	u := blockchain.Undo{
+		Block:  r.Bytes(),
+		Height: int(r.Int()),
+	}
+	spent, created := r.Bytes(), r.Bytes()
+	if r.Err() != nil {
+		return nil, errors.Wrap(r.Err(), "Failed to decode undo record")
+	}
+	var err error
+	if u.Spent, err = decodeUTXOs(spent); err != nil {
+		return nil, errors.Wrap(err, "Failed to decode spent utxos")
+	}
+	if u.Created, err = decodeUTXOs(created); err != nil {
+		return nil, errors.Wrap(err, "Failed to decode created utxos")
+	}
+	return &u, nil
+}
+
+// spentUTXOs looks up the outputs the transactions spend. It has to be
+// called before they are deleted from the utxo set.
+func spentUTXOs(tx *bolt.Tx, transactions transaction.Transactions) (transaction.UTXOs, error) {
+	result := transaction.UTXOs{}
+	for _, t := range transactions {
+		for _, in := range t.Inputs {
+			if in.Vout < 0 {
+				continue
+			}
+			utxo, err := getTransactionUTXO(tx, in.TransactionID, in.Vout)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to get transaction utxo %x %d", in.TransactionID, in.Vout)
+			}
+			if utxo != nil {
+				result = append(result, *utxo)
+			}
+		}
+	}
+	return result, nil
+}
+
+func saveUndo(tx *bolt.Tx, block blockchain.Block, height int, spent transaction.UTXOs) error {
+	b, err := tx.CreateBucketIfNotExists(undoBucket())
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create bucket %s", undoBucket())
+	}
+	created := transaction.UTXOs{}
+	for _, t := range block.Body.Transactions {
+		created = append(created, t.UTXOs()...)
+	}
+	u := blockchain.Undo{
+		Block:   block.Header.Hash,
+		Height:  height,
+		Spent:   spent,
+		Created: created,
+	}
+	if err := b.Put(block.Header.Hash, encodeUndo(u)); err != nil {
+		return errors.Wrapf(err, "Failed to save undo record of block %x", block.Header.Hash)
+	}
+	return nil
+}
+
+func getUndo(tx *bolt.Tx, hash []byte) (*blockchain.Undo, error) {
+	b := tx.Bucket(undoBucket())
+	if b == nil {
+		return nil, nil
+	}
+	raw := b.Get(hash)
+	if raw == nil {
+		return nil, nil
+	}
+	return decodeUndo(raw)
+}
+
+func GetUndo(db *bolt.DB) blockchain.GetUndoFn {
+	return func(hash []byte) (*blockchain.Undo, error) {
+		var result *blockchain.Undo
+		err := db.View(func(tx *bolt.Tx) error {
+			u, err := getUndo(tx, hash)
+			result = u
+			return err
+		})
+		return result, err
+	}
+}
+
+// rollbackTip reverts the utxo set to the state before the tip was added
+// and makes its parent the tip. The block stays stored and its transactions
+// are pending again.
+func rollbackTip(tx *bolt.Tx) (*blockchain.Block, error) {
+	tip := getTip(tx)
+	block, err := readBlock(tx, tip)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read tip %x", tip)
+	}
+	if len(block.Header.Prev) == 0 {
+		return nil, errors.New("Genesis block can't be rolled back")
+	}
+	undo, err := getUndo(tx, tip)
+	switch {
+	case err != nil:
+		return nil, errors.Wrapf(err, "Failed to get undo record of block %x", tip)
+	case undo == nil:
+		return nil, errors.Errorf("Block %x has no undo record, it was added before undo records were kept", tip)
+	}
+	for _, u := range undo.Created {
+		if err := deleteUTXO(tx, u); err != nil {
+			return nil, errors.Wrapf(err, "Failed to delete utxo created by block %x", tip)
+		}
+	}
+	// Blocks received from alfa don't consume their inputs, so only the
+	// outputs missing from the utxo set are restored.
+	var missing transaction.UTXOs
+	for _, u := range undo.Spent {
+		existing, err := getTransactionUTXO(tx, u.TransactionID, u.Vout)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to get transaction utxo %x %d", u.TransactionID, u.Vout)
+		}
+		if existing == nil {
+			missing = append(missing, u)
+		}
+	}
+	if err := saveUTXOs(tx, missing); err != nil {
+		return nil, errors.Wrapf(err, "Failed to restore utxos spent by block %x", tip)
+	}
+	for _, t := range block.Body.Transactions {
+		if err := saveTransaction(tx, t); err != nil {
+			return nil, errors.Wrapf(err, "Failed to return transaction %x to pending transactions", t.ID)
+		}
+		if err := unrecordInclusion(tx, t); err != nil {
+			return nil, errors.Wrapf(err, "Failed to unrecord inclusion of transaction %x", t.ID)
+		}
+	}
+	if err := indexCredits(tx, block.Body.Transactions, true); err != nil {
+		return nil, errors.Wrapf(err, "Failed to unindex credits of block %x", tip)
+	}
+	for bucket, key := range map[string][]byte{
+		string(blockHeightsBucket()):   block.Header.Hash,
+		string(blocksByHeightBucket()): heightKey(undo.Height),
+		string(utxoSnapshotsBucket()):  heightKey(undo.Height),
+		string(undoBucket()):           block.Header.Hash,
+	} {
+		if b := tx.Bucket([]byte(bucket)); b != nil {
+			if err := b.Delete(key); err != nil {
+				return nil, errors.Wrapf(err, "Failed to delete %x from %s", key, bucket)
+			}
+		}
+	}
+	if err := tx.Bucket(blocksBucket()).Put(tipKey(), block.Header.Prev); err != nil {
+		return nil, errors.Wrap(err, "Failed to update tip")
+	}
+	return block, nil
+}
+
+func RollbackTip(db *bolt.DB) blockchain.RollbackFn {
+	return func() (*blockchain.Block, error) {
+		var result *blockchain.Block
+		err := db.Update(func(tx *bolt.Tx) error {
+			block, err := rollbackTip(tx)
+			result = block
+			return err
+		})
+		return result, err
+	}
+}
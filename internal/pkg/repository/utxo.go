@@ -3,9 +3,9 @@ package repository
 import (
 	"bytes"
 	"encoding/base64"
-	"encoding/json"
 
 	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/codec"
 	"github.com/nebser/crypto-vote/internal/pkg/transaction"
 	"github.com/pkg/errors"
 )
@@ -19,21 +19,41 @@ type utxo struct {
 
 type utxos []utxo
 
+// UTXOs are kept one record per output, keyed by public key hash, transaction
+// id and vout in one bucket and by transaction id and vout in the other, so
+// lookups are range scans of a key prefix.
 func utxoByPublicKeyBucket() []byte {
-	return []byte("utxos-by-pkey")
+	return []byte("utxos-by-pkey-txid-vout")
 }
 
 func utxoByTxBucket() []byte {
+	return []byte("utxos-by-txid-vout")
+}
+
+// Databases written before one record per utxo kept arrays of utxos under a
+// public key hash and under a transaction id in these buckets.
+func legacyUTXOByPublicKeyBucket() []byte {
+	return []byte("utxos-by-pkey")
+}
+
+func legacyUTXOByTxBucket() []byte {
 	return []byte("utxos-by-tx")
 }
 
-func newUTXO(u transaction.UTXO) utxo {
-	return utxo{
-		TransactionID: base64.StdEncoding.EncodeToString(u.TransactionID),
-		PublicKeyHash: base64.StdEncoding.EncodeToString(u.PublicKeyHash),
-		Value:         u.Value,
-		Vout:          u.Vout,
-	}
+func utxoPublicKeyPrefix(publicKeyHash []byte) []byte {
+	return codec.NewWriter().Bytes(publicKeyHash).Result()
+}
+
+func utxoTransactionPrefix(transactionID []byte) []byte {
+	return codec.NewWriter().Bytes(transactionID).Result()
+}
+
+func utxoPublicKeyKey(u transaction.UTXO) []byte {
+	return append(utxoPublicKeyPrefix(u.PublicKeyHash), utxoTransactionKey(u.TransactionID, u.Vout)...)
+}
+
+func utxoTransactionKey(transactionID []byte, vout int) []byte {
+	return append(utxoTransactionPrefix(transactionID), sequenceKey(uint64(vout))...)
 }
 
 func (u utxo) toUTXO() transaction.UTXO {
@@ -47,14 +67,6 @@ func (u utxo) toUTXO() transaction.UTXO {
 	}
 }
 
-func newUTXOs(ut transaction.UTXOs) utxos {
-	result := utxos{}
-	for _, u := range ut {
-		result = append(result, newUTXO(u))
-	}
-	return result
-}
-
 func (ut utxos) toUTXOs() transaction.UTXOs {
 	result := transaction.UTXOs{}
 	for _, u := range ut {
@@ -63,72 +75,39 @@ func (ut utxos) toUTXOs() transaction.UTXOs {
 	return result
 }
 
-func saveUTXOsByPublicKey(tx *bolt.Tx, utxos transaction.UTXOs) error {
-	b := tx.Bucket(utxoByPublicKeyBucket())
-	if b == nil {
-		created, err := tx.CreateBucket(utxoByPublicKeyBucket())
-		if err != nil {
-			return errors.Wrapf(err, "Failed to create bucket %s", utxoByPublicKeyBucket())
-		}
-		b = created
+func saveUTXOs(tx *bolt.Tx, utxos transaction.UTXOs) error {
+	byPublicKey, err := tx.CreateBucketIfNotExists(utxoByPublicKeyBucket())
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create bucket %s", utxoByPublicKeyBucket())
+	}
+	byTransaction, err := tx.CreateBucketIfNotExists(utxoByTxBucket())
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create bucket %s", utxoByTxBucket())
 	}
 	for _, u := range utxos {
-		var saved []utxo
-		raw := b.Get(u.PublicKeyHash)
-		if raw != nil {
-			if err := json.Unmarshal(raw, &saved); err != nil {
-				return errors.Wrap(err, "Failed to unmarshal into utxo array")
-			}
-		}
-		saved = append(saved, newUTXO(u))
-		serialized, err := json.Marshal(saved)
-		if err != nil {
-			return errors.Wrapf(err, "Failed to serialize %#v", saved)
+		raw := encodeUTXO(u)
+		if err := byPublicKey.Put(utxoPublicKeyKey(u), raw); err != nil {
+			return errors.Wrapf(err, "Failed to save utxo %x:%d by public key", u.TransactionID, u.Vout)
 		}
-		if err := b.Put(u.PublicKeyHash, serialized); err != nil {
-			return errors.Wrapf(err, "Failed to save utxo set for %x", u.PublicKeyHash)
+		if err := byTransaction.Put(utxoTransactionKey(u.TransactionID, u.Vout), raw); err != nil {
+			return errors.Wrapf(err, "Failed to save utxo %x:%d by transaction id", u.TransactionID, u.Vout)
 		}
 	}
 	return nil
 }
 
-func saveUTXOsByTransactionID(tx *bolt.Tx, utxos transaction.UTXOs) error {
-	b := tx.Bucket(utxoByTxBucket())
-	if b == nil {
-		created, err := tx.CreateBucket(utxoByTxBucket())
+// scanUTXOs decodes the utxos whose keys start with the prefix.
+func scanUTXOs(b *bolt.Bucket, prefix []byte) (transaction.UTXOs, error) {
+	var result transaction.UTXOs
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		u, err := decodeUTXO(v)
 		if err != nil {
-			return errors.Wrapf(err, "Failed to create bucket %s", utxoByTxBucket())
+			return nil, errors.Wrapf(err, "Failed to decode utxo %x", k)
 		}
-		b = created
+		result = append(result, u)
 	}
-	for _, u := range utxos {
-		var saved []utxo
-		raw := b.Get(u.TransactionID)
-		if raw != nil {
-			if err := json.Unmarshal(raw, &saved); err != nil {
-				return errors.Wrap(err, "Failed to unmarshal into utxo array")
-			}
-		}
-		saved = append(saved, newUTXO(u))
-		serialized, err := json.Marshal(saved)
-		if err != nil {
-			return errors.Wrapf(err, "Failed to serialize %#v", saved)
-		}
-		if err := b.Put(u.TransactionID, serialized); err != nil {
-			return errors.Wrapf(err, "Failed to save utxo set for tx id %x", u.TransactionID)
-		}
-	}
-	return nil
-}
-
-func saveUTXOs(tx *bolt.Tx, utxos transaction.UTXOs) error {
-	if err := saveUTXOsByPublicKey(tx, utxos); err != nil {
-		return errors.Wrap(err, "Failed to save utxo by public key")
-	}
-	if err := saveUTXOsByTransactionID(tx, utxos); err != nil {
-		return errors.Wrap(err, "Failed to save utxo by transaction id")
-	}
-	return nil
+	return result, nil
 }
 
 func getUTXOsByPublicKey(tx *bolt.Tx, publicKeyHash []byte) (transaction.UTXOs, error) {
@@ -136,31 +115,7 @@ func getUTXOsByPublicKey(tx *bolt.Tx, publicKeyHash []byte) (transaction.UTXOs,
 	if b == nil {
 		return nil, nil
 	}
-	raw := b.Get(publicKeyHash)
-	if raw == nil {
-		return nil, nil
-	}
-	var utxos utxos
-	if err := json.Unmarshal(raw, &utxos); err != nil {
-		return nil, errors.Wrap(err, "Failed to unmarshal utxos")
-	}
-	return utxos.toUTXOs(), nil
-}
-
-func getUTXOByTransactionID(tx *bolt.Tx, transactionID []byte) (transaction.UTXOs, error) {
-	b := tx.Bucket(utxoByTxBucket())
-	if b == nil {
-		return nil, nil
-	}
-	raw := b.Get(transactionID)
-	if raw == nil {
-		return nil, nil
-	}
-	var utxos utxos
-	if err := json.Unmarshal(raw, &utxos); err != nil {
-		return nil, errors.Wrap(err, "Failed to unmarshal utxos")
-	}
-	return utxos.toUTXOs(), nil
+	return scanUTXOs(b, utxoPublicKeyPrefix(publicKeyHash))
 }
 
 func getTransactionUTXO(tx *bolt.Tx, transactionID []byte, vout int) (*transaction.UTXO, error) {
@@ -168,73 +123,27 @@ func getTransactionUTXO(tx *bolt.Tx, transactionID []byte, vout int) (*transacti
 	if b == nil {
 		return nil, nil
 	}
-	raw := b.Get(transactionID)
+	raw := b.Get(utxoTransactionKey(transactionID, vout))
 	if raw == nil {
 		return nil, nil
 	}
-	var utxos utxos
-	if err := json.Unmarshal(raw, &utxos); err != nil {
-		return nil, errors.Wrap(err, "Failed to unmarshal utxos")
-	}
-	for _, utxo := range utxos {
-		if utxo.Vout == vout {
-			val := utxo.toUTXO()
-			return &val, nil
-		}
-	}
-	return nil, nil
-}
-
-func deleteUTXOByPublicKey(tx *bolt.Tx, utxo transaction.UTXO) error {
-	b := tx.Bucket(utxoByPublicKeyBucket())
-	if b == nil {
-		return nil
-	}
-	utxos, err := getUTXOsByPublicKey(tx, utxo.PublicKeyHash)
-	if err != nil {
-		return errors.Wrap(err, "Failed to retrieve utxo for deletion")
-	}
-	updated := utxos.Filter(func(u transaction.UTXO) bool {
-		return bytes.Compare(utxo.TransactionID, u.TransactionID) != 0
-	})
-	raw, err := json.Marshal(newUTXOs(updated))
+	u, err := decodeUTXO(raw)
 	if err != nil {
-		return errors.Wrapf(err, "Failed to marshal utxo %#v", utxos)
-	}
-	if err := b.Put(utxo.PublicKeyHash, raw); err != nil {
-		return errors.Wrapf(err, "Failed to store utxo %#v", utxos)
+		return nil, err
 	}
-	return nil
-}
-
-func deleteUTXOByTransactionID(tx *bolt.Tx, utxo transaction.UTXO) error {
-	b := tx.Bucket(utxoByPublicKeyBucket())
-	if b == nil {
-		return nil
-	}
-	utxos, err := getUTXOByTransactionID(tx, utxo.TransactionID)
-	if err != nil {
-		return errors.Wrap(err, "Failed to retrieve utxo for deletion")
-	}
-	updated := utxos.Filter(func(u transaction.UTXO) bool {
-		return u.Vout != utxo.Vout || bytes.Compare(utxo.PublicKeyHash, u.PublicKeyHash) != 0
-	})
-	raw, err := json.Marshal(newUTXOs(updated))
-	if err != nil {
-		return errors.Wrapf(err, "Failed to marshal utxo %#v", utxos)
-	}
-	if err := b.Put(utxo.TransactionID, raw); err != nil {
-		return errors.Wrapf(err, "Failed to store utxo %#v", utxos)
-	}
-	return nil
+	return &u, nil
 }
 
 func deleteUTXO(tx *bolt.Tx, utxo transaction.UTXO) error {
-	if err := deleteUTXOByPublicKey(tx, utxo); err != nil {
-		return errors.Wrap(err, "Failed to delete transaction by public key")
+	if b := tx.Bucket(utxoByPublicKeyBucket()); b != nil {
+		if err := b.Delete(utxoPublicKeyKey(utxo)); err != nil {
+			return errors.Wrap(err, "Failed to delete utxo by public key")
+		}
 	}
-	if err := deleteUTXOByTransactionID(tx, utxo); err != nil {
-		return errors.Wrap(err, "Failed to delete transaction by transaction id")
+	if b := tx.Bucket(utxoByTxBucket()); b != nil {
+		if err := b.Delete(utxoTransactionKey(utxo.TransactionID, utxo.Vout)); err != nil {
+			return errors.Wrap(err, "Failed to delete utxo by transaction id")
+		}
 	}
 	return nil
 }
@@ -276,6 +185,27 @@ func GetUTXOsByPublicKey(db *bolt.DB) transaction.GetUTXOsByPublicKeyFn {
 	}
 }
 
+func GetUTXOs(db *bolt.DB) transaction.GetUTXOsFn {
+	return func() (transaction.UTXOs, error) {
+		result := transaction.UTXOs{}
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(utxoByPublicKeyBucket())
+			if b == nil {
+				return nil
+			}
+			return b.ForEach(func(k, v []byte) error {
+				u, err := decodeUTXO(v)
+				if err != nil {
+					return errors.Wrapf(err, "Failed to decode utxo %x", k)
+				}
+				result = append(result, u)
+				return nil
+			})
+		})
+		return result, err
+	}
+}
+
 func GetTransactionUTXO(db *bolt.DB) transaction.GetTransactionUTXO {
 	return func(id []byte, vout int) (*transaction.UTXO, error) {
 		var tr *transaction.UTXO
@@ -0,0 +1,242 @@
+package research
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/analytics"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/election"
+	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+// Ballot is an anonymized vote. Choices are the names of the parties the
+// voter gave votes to, a party once for every vote. Bucket is the start of
+// the bucket of the timestamp of the block holding the vote. Precinct is
+// empty if fewer than K ballots of the export share it or the voter has
+// none.
+type Ballot struct {
+	Bucket   int64    `json:"bucket"`
+	Choices  []string `json:"choices"`
+	Precinct string   `json:"precinct,omitempty"`
+}
+
+// Export holds the ballots of an election, ordered by bucket, precinct and
+// choices so their order doesn't follow the blocks. Neither voters nor
+// transactions are exported.
+type Export struct {
+	Election string   `json:"election"`
+	Bucket   int64    `json:"bucket"`
+	K        int      `json:"k"`
+	Noise    Noise    `json:"noise"`
+	Ballots  []Ballot `json:"ballots"`
+}
+
+type ExportFn func(Request) (Export, error)
+
+// Exporter reads the ballots of the election of a request from the
+// blockchain. Transactions of the alfa node and of parties aren't votes.
+func Exporter(k int, precincts analytics.Precincts, getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, getParties party.GetPartiesFn, listElections election.ListFn, alfaKeyHash []byte) ExportFn {
+	return func(r Request) (Export, error) {
+		candidates, err := electionParties(r.Election, getParties, listElections)
+		if err != nil {
+			return Export{}, err
+		}
+		var ballots []Ballot
+		for current := getTip(); len(current) > 0; {
+			block, err := getBlock(current)
+			switch {
+			case err != nil:
+				return Export{}, errors.Wrapf(err, "Failed to get block %x", current)
+			case block == nil:
+				return Export{}, errors.Errorf("Block %x is missing", current)
+			}
+			bucket := time.Unix(block.Header.Timestamp, 0).Truncate(time.Duration(r.Bucket) * time.Second).Unix()
+			for _, t := range block.Body.Transactions {
+				b, ok := ballot(t, candidates, alfaKeyHash)
+				if !ok {
+					continue
+				}
+				b.Bucket = bucket
+				b.Precinct = precincts[string(t.Inputs[0].PublicKeyHash)]
+				ballots = append(ballots, b)
+			}
+			current = block.Header.Prev
+		}
+		if ballots, err = anonymize(ballots, k, candidates, r.Noise); err != nil {
+			return Export{}, err
+		}
+		return Export{
+			Election: r.Election,
+			Bucket:   r.Bucket,
+			K:        k,
+			Noise:    r.Noise,
+			Ballots:  ballots,
+		}, nil
+	}
+}
+
+// electionParties are the parties of the election, the ones which aren't
+// on the list of any election for the implicit one.
+func electionParties(id string, getParties party.GetPartiesFn, listElections election.ListFn) (party.Parties, error) {
+	parties, err := getParties()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to retrieve parties")
+	}
+	elections, err := listElections()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to retrieve elections")
+	}
+	listed, of := map[string]bool{}, map[string]bool{}
+	found := id == ""
+	for _, e := range elections {
+		if e.ID == id {
+			found = true
+		}
+		for _, a := range e.Parties {
+			listed[a] = true
+			of[a] = of[a] || e.ID == id
+		}
+	}
+	if !found {
+		return nil, errors.Wrapf(election.ErrUnknownElection, "Election %s", id)
+	}
+	var result party.Parties
+	for _, p := range parties {
+		if id == "" && !listed[p.Address] || id != "" && of[p.Address] {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+func ballot(t transaction.Transaction, candidates party.Parties, alfaKeyHash []byte) (Ballot, bool) {
+	if len(t.Inputs) == 0 || t.AreInputsFrom(alfaKeyHash) {
+		return Ballot{}, false
+	}
+	sender := t.Inputs[0].PublicKeyHash
+	if _, ok := candidates.FindByKeyHash(sender); ok {
+		return Ballot{}, false
+	}
+	result := Ballot{Choices: []string{}}
+	for _, out := range t.Outputs {
+		if bytes.Equal(out.PublicKeyHash, sender) {
+			continue
+		}
+		p, ok := candidates.FindByKeyHash(out.PublicKeyHash)
+		if !ok {
+			continue
+		}
+		for i := 0; i < out.Value/transaction.VoteValue; i++ {
+			result.Choices = append(result.Choices, p.Name)
+		}
+	}
+	return result, len(result.Choices) > 0
+}
+
+// anonymize withholds the precincts shared by fewer than k ballots, applies
+// the noise and sorts the ballots. Withheld precincts are never the outcome
+// of the noise.
+func anonymize(ballots []Ballot, k int, candidates party.Parties, noise Noise) ([]Ballot, error) {
+	counts := map[string]int{}
+	for _, b := range ballots {
+		counts[b.Precinct]++
+	}
+	var reported []string
+	for precinct, c := range counts {
+		if precinct != "" && c >= k {
+			reported = append(reported, precinct)
+		}
+	}
+	sort.Strings(reported)
+	questions := map[string][]string{}
+	question := map[string]string{}
+	for _, p := range candidates {
+		questions[p.Question] = append(questions[p.Question], p.Name)
+		question[p.Name] = p.Question
+	}
+	for i := range ballots {
+		b := &ballots[i]
+		if counts[b.Precinct] < k {
+			b.Precinct = ""
+		}
+		if b.Precinct != "" && noise.Precinct > 0 {
+			precinct, err := respond(b.Precinct, reported, noise.Precinct)
+			if err != nil {
+				return nil, err
+			}
+			b.Precinct = precinct
+		}
+		if noise.Choice > 0 {
+			for j, choice := range b.Choices {
+				randomized, err := respond(choice, questions[question[choice]], noise.Choice)
+				if err != nil {
+					return nil, err
+				}
+				b.Choices[j] = randomized
+			}
+		}
+		sort.Strings(b.Choices)
+	}
+	sort.Slice(ballots, func(i, j int) bool {
+		switch {
+		case ballots[i].Bucket != ballots[j].Bucket:
+			return ballots[i].Bucket < ballots[j].Bucket
+		case ballots[i].Precinct != ballots[j].Precinct:
+			return ballots[i].Precinct < ballots[j].Precinct
+		default:
+			return strings.Join(ballots[i].Choices, "\x00") < strings.Join(ballots[j].Choices, "\x00")
+		}
+	})
+	if ballots == nil {
+		ballots = []Ballot{}
+	}
+	return ballots, nil
+}
+
+// respond is the randomized response over the options, the value is kept
+// with probability e^ε/(e^ε+m-1) and replaced by any other of the m options
+// otherwise, which makes every answer ε-differentially private.
+func respond(value string, options []string, epsilon float64) (string, error) {
+	m := len(options)
+	if m < 2 {
+		return value, nil
+	}
+	keep := 1 / (1 + float64(m-1)*math.Exp(-epsilon))
+	u, err := uniform()
+	if err != nil {
+		return "", err
+	}
+	if u < keep {
+		return value, nil
+	}
+	var others []string
+	for _, o := range options {
+		if o != value {
+			others = append(others, o)
+		}
+	}
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(len(others))))
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to draw a response")
+	}
+	return others[i.Int64()], nil
+}
+
+// uniform draws a number in [0, 1) from the random source of the system, so
+// the noise can't be predicted from earlier exports.
+func uniform() (float64, error) {
+	var raw [8]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return 0, errors.Wrap(err, "Failed to draw noise")
+	}
+	return float64(binary.BigEndian.Uint64(raw[:])>>11) / (1 << 53), nil
+}
@@ -0,0 +1,200 @@
+package research
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/election"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidRequest = errors.New("Research request is not valid")
+	ErrUnknownRequest = errors.New("Research request does not exist")
+	ErrDecided        = errors.New("Research request is already decided")
+	ErrInvalidToken   = errors.New("Research token is not valid")
+)
+
+type Status string
+
+const (
+	Pending  Status = "pending"
+	Approved Status = "approved"
+	Rejected Status = "rejected"
+)
+
+// MinBucket is the narrowest timestamp bucket a request may ask for, the
+// width of the buckets of the turnout.
+const MinBucket = 10 * time.Minute
+
+const DefaultBucket = time.Hour
+
+// Noise are the epsilons of the randomized response applied to the choices
+// and to the precincts of the exported ballots, the smaller the epsilon the
+// more of them are replaced. A zero epsilon leaves the field as it is.
+type Noise struct {
+	Choice   float64 `json:"choice,omitempty"`
+	Precinct float64 `json:"precinct,omitempty"`
+}
+
+// Request asks for the anonymized ballots of an election, the implicit
+// election of the parties which aren't on the list of any election if
+// Election is empty. Ballots are exported only once an admin approved the
+// request, with the token issued on approval. Only the hash of its secret
+// is kept.
+type Request struct {
+	ID         string `json:"id"`
+	Election   string `json:"election"`
+	Researcher string `json:"researcher"`
+	Purpose    string `json:"purpose"`
+	Bucket     int64  `json:"bucket"`
+	Noise      Noise  `json:"noise"`
+	Status     Status `json:"status"`
+	Secret     []byte `json:"secret,omitempty"`
+	CreatedAt  int64  `json:"createdAt"`
+	DecidedAt  int64  `json:"decidedAt,omitempty"`
+}
+
+type Requests []Request
+
+// Public is the request as the researcher and the admins see it, with its
+// election, purpose, bucket, noise, status and when it was made and decided,
+// but not the hash of the token issued on approval.
+func (r Request) Public() Request {
+	r.Secret = nil
+	return r
+}
+
+func (r Request) Verify() error {
+	switch {
+	case strings.TrimSpace(r.Researcher) == "":
+		return errors.Wrap(ErrInvalidRequest, "Researcher is missing")
+	case strings.TrimSpace(r.Purpose) == "":
+		return errors.Wrap(ErrInvalidRequest, "Purpose is missing")
+	case r.Bucket < int64(MinBucket/time.Second):
+		return errors.Wrapf(ErrInvalidRequest, "Bucket has to be at least %d seconds", int64(MinBucket/time.Second))
+	case !validEpsilon(r.Noise.Choice), !validEpsilon(r.Noise.Precinct):
+		return errors.Wrap(ErrInvalidRequest, "Epsilons have to be finite and not negative")
+	}
+	return nil
+}
+
+func validEpsilon(e float64) bool {
+	return e >= 0 && !math.IsInf(e, 1)
+}
+
+type SaveFn func(Request) error
+
+type GetFn func(id string) (*Request, error)
+
+type ListFn func() (Requests, error)
+
+type SubmitFn func(Request) (Request, error)
+
+// DecideFn approves or rejects a request. The token is returned only when
+// the request is approved.
+type DecideFn func(id string, approve bool) (Request, string, error)
+
+// AuthorizeFn returns the approved request of the token.
+type AuthorizeFn func(token string) (Request, error)
+
+func hashSecret(secret []byte) []byte {
+	hash := sha256.Sum256(secret)
+	return hash[:]
+}
+
+func random(n int) ([]byte, error) {
+	result := make([]byte, n)
+	if _, err := rand.Read(result); err != nil {
+		return nil, errors.Wrap(err, "Failed to generate random bytes")
+	}
+	return result, nil
+}
+
+// Submit saves a pending request of an existing election, the bucket is an
+// hour if none is given.
+func Submit(getElection election.GetFn, save SaveFn) SubmitFn {
+	return func(r Request) (Request, error) {
+		if r.Bucket == 0 {
+			r.Bucket = int64(DefaultBucket / time.Second)
+		}
+		if err := r.Verify(); err != nil {
+			return Request{}, err
+		}
+		if _, ok := getElection(r.Election); r.Election != "" && !ok {
+			return Request{}, errors.Wrapf(ErrInvalidRequest, "Election %s does not exist", r.Election)
+		}
+		id, err := random(8)
+		if err != nil {
+			return Request{}, err
+		}
+		r.ID = hex.EncodeToString(id)
+		r.Status = Pending
+		r.Secret = nil
+		r.CreatedAt = time.Now().Unix()
+		r.DecidedAt = 0
+		if err := save(r); err != nil {
+			return Request{}, errors.Wrapf(err, "Failed to save research request %s", r.ID)
+		}
+		return r, nil
+	}
+}
+
+// Decide approves or rejects a pending request. An approved request may
+// still be rejected, which revokes its token. The token is the id and the
+// hex encoded secret joined with a dot.
+func Decide(get GetFn, save SaveFn) DecideFn {
+	return func(id string, approve bool) (Request, string, error) {
+		r, err := get(id)
+		switch {
+		case err != nil:
+			return Request{}, "", errors.Wrapf(err, "Failed to retrieve research request %s", id)
+		case r == nil:
+			return Request{}, "", errors.Wrapf(ErrUnknownRequest, "Request %s", id)
+		case r.Status == Rejected, approve && r.Status == Approved:
+			return Request{}, "", errors.Wrapf(ErrDecided, "Request %s is %s", id, r.Status)
+		}
+		r.DecidedAt = time.Now().Unix()
+		token := ""
+		if approve {
+			secret, err := random(32)
+			if err != nil {
+				return Request{}, "", err
+			}
+			r.Status, r.Secret = Approved, hashSecret(secret)
+			token = r.ID + "." + hex.EncodeToString(secret)
+		} else {
+			r.Status, r.Secret = Rejected, nil
+		}
+		if err := save(*r); err != nil {
+			return Request{}, "", errors.Wrapf(err, "Failed to save research request %s", id)
+		}
+		return r.Public(), token, nil
+	}
+}
+
+func Authorize(get GetFn) AuthorizeFn {
+	return func(token string) (Request, error) {
+		parts := strings.SplitN(token, ".", 2)
+		if len(parts) != 2 {
+			return Request{}, ErrInvalidToken
+		}
+		secret, err := hex.DecodeString(parts[1])
+		if err != nil {
+			return Request{}, ErrInvalidToken
+		}
+		r, err := get(parts[0])
+		switch {
+		case err != nil:
+			return Request{}, errors.Wrapf(err, "Failed to retrieve research request %s", parts[0])
+		case r == nil, r.Status != Approved, subtle.ConstantTimeCompare(r.Secret, hashSecret(secret)) != 1:
+			return Request{}, ErrInvalidToken
+		}
+		return *r, nil
+	}
+}
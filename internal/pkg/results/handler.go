@@ -0,0 +1,68 @@
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// keepAlive is how often a comment is sent on an idle stream, so proxies
+// don't close it between blocks.
+const keepAlive = 15 * time.Second
+
+// StreamHandler streams the results as server-sent events, the current ones
+// when the stream opens and updated ones after every block and once the
+// election is finalized.
+func StreamHandler(board *Board, getResults GetFn) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+		updates := board.Subscribe()
+		defer board.Unsubscribe(updates)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		send := func() error {
+			results, err := getResults()
+			if err != nil {
+				return err
+			}
+			raw, err := json.Marshal(results)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "event: results\nid: %d\ndata: %s\n\n", results.Height, raw); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		}
+		if err := send(); err != nil {
+			log.Printf("Failed to stream results %s", err)
+			return
+		}
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-updates:
+				if err := send(); err != nil {
+					log.Printf("Failed to stream results %s", err)
+					return
+				}
+			case <-ticker.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	})
+}
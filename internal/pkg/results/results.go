@@ -0,0 +1,214 @@
+package results
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/finalization"
+	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/nebser/crypto-vote/internal/pkg/withdrawal"
+	"github.com/pkg/errors"
+)
+
+// Total is the number of confirmed votes a party got. Votes of a withdrawn
+// party whose prior votes are void are not counted.
+type Total struct {
+	Name      string `json:"name"`
+	Address   string `json:"address"`
+	Question  string `json:"question,omitempty"`
+	Votes     int    `json:"votes"`
+	Withdrawn bool   `json:"withdrawn,omitempty"`
+}
+
+// Results are the totals of the parties in the blockchain up to the tip at
+// the height. Finalized is set once the election is frozen at the tip.
+type Results struct {
+	Height      int     `json:"height"`
+	Tip         []byte  `json:"tip"`
+	Finalized   bool    `json:"finalized"`
+	FinalizedAt int64   `json:"finalizedAt,omitempty"`
+	Parties     []Total `json:"parties"`
+}
+
+type GetFn func() (Results, error)
+
+// Board counts the votes in the blockchain as blocks are added, so results
+// never include pending transactions and are not computed from the whole
+// blockchain on every request. Subscribers are notified whenever the
+// results change.
+type Board struct {
+	lock        *sync.RWMutex
+	alfaKeyHash []byte
+	votes       map[string]int
+	height      int
+	tip         []byte
+	subscribers map[chan struct{}]bool
+}
+
+// Load counts the votes of the blockchain.
+func Load(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, alfaKeyHash []byte) (*Board, error) {
+	b := &Board{
+		lock:        &sync.RWMutex{},
+		alfaKeyHash: alfaKeyHash,
+		votes:       map[string]int{},
+		tip:         getTip(),
+		subscribers: map[chan struct{}]bool{},
+	}
+	_, _, err := blockchain.FindBlock(getTip, getBlock)(func(block blockchain.Block) bool {
+		b.count(block, 1)
+		b.height++
+		return false
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to count votes of the blockchain")
+	}
+	return b, nil
+}
+
+// count adds the value every transaction gives to others than its senders,
+// or takes it away with sign -1, transactions of the alfa node fund voters
+// and aren't votes.
+func (b *Board) count(block blockchain.Block, sign int) {
+	for _, t := range block.Body.Transactions {
+		if len(t.Inputs) == 0 || t.AreInputsFrom(b.alfaKeyHash) {
+			continue
+		}
+		for _, out := range t.Outputs {
+			if _, found := t.Inputs.Find(func(in transaction.Input) bool {
+				return bytes.Equal(in.PublicKeyHash, out.PublicKeyHash)
+			}); found {
+				continue
+			}
+			b.votes[string(out.PublicKeyHash)] += sign * out.Value
+		}
+	}
+}
+
+func (b *Board) apply(block blockchain.Block) {
+	b.lock.Lock()
+	b.count(block, 1)
+	b.height++
+	b.tip = block.Header.Hash
+	b.lock.Unlock()
+	b.Notify()
+}
+
+func (b *Board) AddBlock(add blockchain.AddBlockFn) blockchain.AddBlockFn {
+	return func(block blockchain.Block) ([]byte, error) {
+		tip, err := add(block)
+		if err != nil {
+			return nil, err
+		}
+		b.apply(block)
+		return tip, nil
+	}
+}
+
+func (b *Board) AddNewBlock(add blockchain.AddNewBlockFn) blockchain.AddNewBlockFn {
+	return func(block blockchain.Block) error {
+		if err := add(block); err != nil {
+			return err
+		}
+		b.apply(block)
+		return nil
+	}
+}
+
+// Rollback takes the votes of the rolled back tip away.
+func (b *Board) Rollback(rollback blockchain.RollbackFn) blockchain.RollbackFn {
+	return func() (*blockchain.Block, error) {
+		block, err := rollback()
+		if err != nil {
+			return nil, err
+		}
+		b.lock.Lock()
+		b.count(*block, -1)
+		b.height--
+		b.tip = block.Header.Prev
+		b.lock.Unlock()
+		b.Notify()
+		return block, nil
+	}
+}
+
+// Subscribe returns a channel receiving a signal whenever the results
+// change. Signals a subscriber hasn't taken yet are merged, it reads the
+// latest results anyway.
+func (b *Board) Subscribe() chan struct{} {
+	ch := make(chan struct{}, 1)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.subscribers[ch] = true
+	return ch
+}
+
+func (b *Board) Unsubscribe(ch chan struct{}) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.subscribers, ch)
+}
+
+// Notify signals the subscribers, e.g. when the election is finalized.
+func (b *Board) Notify() {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Get returns the totals of the parties, most voted first within every
+// question.
+func (b *Board) Get(getParties party.GetPartiesFn, getWithdrawal withdrawal.GetFn, getFinalization finalization.GetFn) GetFn {
+	return func() (Results, error) {
+		parties, err := getParties()
+		if err != nil {
+			return Results{}, errors.Wrap(err, "Failed to retrieve parties")
+		}
+		f, err := getFinalization()
+		if err != nil {
+			return Results{}, errors.Wrap(err, "Failed to retrieve finalization")
+		}
+		b.lock.RLock()
+		result := Results{
+			Height:  b.height,
+			Tip:     b.tip,
+			Parties: []Total{},
+		}
+		for _, p := range parties {
+			keyHash := wallet.ExtractPublicKeyHash(p.Address)
+			total := Total{
+				Name:     p.Name,
+				Address:  p.Address,
+				Question: p.Question,
+				Votes:    b.votes[string(keyHash)] / transaction.VoteValue,
+			}
+			if w, ok := getWithdrawal(keyHash); ok {
+				total.Withdrawn = true
+				if w.Voided() {
+					total.Votes = 0
+				}
+			}
+			result.Parties = append(result.Parties, total)
+		}
+		b.lock.RUnlock()
+		if f != nil {
+			result.Finalized = true
+			result.FinalizedAt = f.FinalizedAt
+		}
+		sort.SliceStable(result.Parties, func(i, j int) bool {
+			if result.Parties[i].Question != result.Parties[j].Question {
+				return result.Parties[i].Question < result.Parties[j].Question
+			}
+			return result.Parties[i].Votes > result.Parties[j].Votes
+		})
+		return result, nil
+	}
+}
@@ -0,0 +1,172 @@
+package round
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+	"math/rand"
+	"sort"
+)
+
+type Outcome string
+
+const (
+	Pending  Outcome = "pending"
+	Forged   Outcome = "forged"
+	Rejected Outcome = "rejected"
+	Missed   Outcome = "missed"
+	Failed   Outcome = "failed"
+)
+
+// Round is a single forger selection. The selected node can be recomputed
+// out of the seed and the candidates by Select, or out of the seed and the
+// weights by SelectWeighted in rounds which have weights. The seed of such a
+// round is derived from Prev, the tip when the round started, by Seed. In
+// sortition rounds nobody is selected up front, every node proves with a
+// VRF over Alpha whether it may forge and Selected is the node whose block
+// got on chain.
+type Round struct {
+	Number      uint64         `json:"number"`
+	Seed        int64          `json:"seed"`
+	Height      int            `json:"height"`
+	Prev        []byte         `json:"prev,omitempty"`
+	Weights     map[string]int `json:"weights,omitempty"`
+	Candidates  []string       `json:"candidates"`
+	Excluded    string         `json:"excluded,omitempty"`
+	Selected    string         `json:"selected"`
+	Sortition   bool           `json:"sortition,omitempty"`
+	Outcome     Outcome        `json:"outcome"`
+	StartedAt   int64          `json:"startedAt"`
+	CompletedAt int64          `json:"completedAt,omitempty"`
+}
+
+type Rounds []Round
+
+// Select sorts the candidates, removes the excluded one if any other
+// candidate remains and picks one using a generator seeded with seed.
+func Select(candidates []string, excluded string, seed int64) (string, []string) {
+	eligible := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		if c != excluded {
+			eligible = append(eligible, c)
+		}
+	}
+	if len(eligible) == 0 {
+		eligible = append(eligible, candidates...)
+	}
+	sort.Strings(eligible)
+	if len(eligible) == 0 {
+		return "", eligible
+	}
+	return eligible[rand.New(rand.NewSource(seed)).Intn(len(eligible))], eligible
+}
+
+// Seed derives the seed of the round following the previous one from the
+// hash of the tip, so anyone holding the blockchain can recompute it.
+func Seed(tip []byte, previous uint64) int64 {
+	raw := make([]byte, len(tip)+8)
+	copy(raw, tip)
+	binary.BigEndian.PutUint64(raw[len(tip):], previous)
+	hash := sha256.Sum256(raw)
+	return int64(binary.BigEndian.Uint64(hash[:8]))
+}
+
+// SelectWeighted picks a candidate with a probability proportional to its
+// weight using a generator seeded with seed. The excluded candidate is
+// removed if any other candidate remains. Candidates without weight are only
+// picked, uniformly, if no candidate has any.
+func SelectWeighted(weights map[string]int, excluded string, seed int64) (string, []string) {
+	candidates := make([]string, 0, len(weights))
+	for c := range weights {
+		candidates = append(candidates, c)
+	}
+	_, eligible := Select(candidates, excluded, seed)
+	total := int64(0)
+	for _, c := range eligible {
+		if weights[c] > 0 {
+			total += int64(weights[c])
+		}
+	}
+	if total == 0 {
+		return Select(candidates, excluded, seed)
+	}
+	pick := rand.New(rand.NewSource(seed)).Int63n(total)
+	for _, c := range eligible {
+		if weights[c] <= 0 {
+			continue
+		}
+		if pick < int64(weights[c]) {
+			return c, eligible
+		}
+		pick -= int64(weights[c])
+	}
+	return "", eligible
+}
+
+// Verify recomputes the selection of the round. Rounds without weights were
+// seeded randomly, only their selection out of the seed is checked. Nothing
+// is selected in sortition rounds, only their seed is checked.
+func (r Round) Verify() bool {
+	if r.Sortition {
+		return r.Number != 0 && Seed(r.Prev, r.Number-1) == r.Seed
+	}
+	if r.Weights == nil {
+		selected, _ := Select(r.Candidates, r.Excluded, r.Seed)
+		return selected == r.Selected
+	}
+	if r.Number == 0 || Seed(r.Prev, r.Number-1) != r.Seed {
+		return false
+	}
+	selected, _ := SelectWeighted(r.Weights, r.Excluded, r.Seed)
+	return selected == r.Selected
+}
+
+// Alpha is what nodes evaluate their VRF on in a sortition round.
+func (r Round) Alpha() []byte {
+	raw := make([]byte, 16)
+	binary.BigEndian.PutUint64(raw, uint64(r.Seed))
+	binary.BigEndian.PutUint64(raw[8:], r.Number)
+	return raw
+}
+
+// Total is the sum of the positive weights of the round.
+func (r Round) Total() int {
+	total := 0
+	for _, w := range r.Weights {
+		if w > 0 {
+			total += w
+		}
+	}
+	return total
+}
+
+// Eligible tells whether the VRF output lets a node with weight out of total
+// forge. That happens with probability weight/total, so one node is expected
+// to be eligible in a round, but there may be none or several.
+func Eligible(output []byte, weight, total int) bool {
+	if weight <= 0 || total <= 0 || weight > total {
+		return false
+	}
+	value := new(big.Int).SetBytes(output)
+	value.Mul(value, big.NewInt(int64(total)))
+	limit := new(big.Int).Lsh(big.NewInt(int64(weight)), uint(8*len(output)))
+	return value.Cmp(limit) < 0
+}
+
+// WeighFn returns the weight of every node in a forger selection.
+type WeighFn func(nodeIDs []string) (map[string]int, error)
+
+// StartFn saves a new round, marking the previous one as missed if it is
+// still pending, and returns the saved round.
+type StartFn func(Round) (*Round, error)
+
+// CompleteFn sets the outcome of the latest round if it is pending and
+// nodeID was selected in it. In sortition rounds it records nodeID as the
+// forger.
+type CompleteFn func(nodeID string, outcome Outcome) error
+
+type GetLatestFn func() (*Round, error)
+
+// GetRoundsFn returns rounds starting from the latest one and the total
+// number of rounds.
+type GetRoundsFn func(offset, limit int) (Rounds, int, error)
@@ -0,0 +1,424 @@
+package rules
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+type kind int
+
+const (
+	intKind kind = iota
+	stringKind
+	boolKind
+)
+
+func (k kind) String() string {
+	switch k {
+	case intKind:
+		return "int"
+	case stringKind:
+		return "string"
+	default:
+		return "bool"
+	}
+}
+
+type value struct {
+	i int64
+	s string
+	b bool
+}
+
+type env map[string]value
+
+type evalFn func(env) (value, error)
+
+type expression struct {
+	kind kind
+	eval evalFn
+}
+
+type token struct {
+	text   string
+	str    bool
+	offset int
+}
+
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")"}
+
+func tokenize(source string) ([]token, error) {
+	result := []token{}
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"':
+			end := strings.IndexByte(source[i+1:], '"')
+			if end < 0 {
+				return nil, errors.Errorf("Unterminated string at %d", i)
+			}
+			result = append(result, token{text: source[i+1 : i+1+end], str: true, offset: i})
+			i += end + 2
+		case unicode.IsDigit(c) || unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(source) && (unicode.IsDigit(rune(source[j])) || unicode.IsLetter(rune(source[j])) || source[j] == '_') {
+				j++
+			}
+			result = append(result, token{text: source[i:j], offset: i})
+			i = j
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(source[i:], op) {
+					result = append(result, token{text: op, offset: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, errors.Errorf("Unexpected character %q at %d", c, i)
+			}
+		}
+	}
+	return result, nil
+}
+
+type parser struct {
+	tokens    []token
+	pos       int
+	variables map[string]kind
+}
+
+// compile parses the source into an expression. Every variable has a fixed
+// kind, so type errors are found when rules are loaded instead of when a
+// transaction is validated.
+func compile(source string, variables map[string]kind) (*expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, variables: variables}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, errors.Errorf("Unexpected %q at %d", p.tokens[p.pos].text, p.tokens[p.pos].offset)
+	}
+	return e, nil
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *parser) accept(ops ...string) (string, bool) {
+	t, ok := p.peek()
+	if !ok || t.str {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func expect(e *expression, k kind, op string) error {
+	if e.kind != k {
+		return errors.Errorf("Operator %s expects %s, got %s", op, k, e.kind)
+	}
+	return nil
+}
+
+func (p *parser) or() (*expression, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("||"); !ok {
+			return left, nil
+		}
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		if err := expect(left, boolKind, "||"); err != nil {
+			return nil, err
+		}
+		if err := expect(right, boolKind, "||"); err != nil {
+			return nil, err
+		}
+		l, r := left.eval, right.eval
+		left = &expression{kind: boolKind, eval: func(e env) (value, error) {
+			lv, err := l(e)
+			if err != nil || lv.b {
+				return lv, err
+			}
+			return r(e)
+		}}
+	}
+}
+
+func (p *parser) and() (*expression, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("&&"); !ok {
+			return left, nil
+		}
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		if err := expect(left, boolKind, "&&"); err != nil {
+			return nil, err
+		}
+		if err := expect(right, boolKind, "&&"); err != nil {
+			return nil, err
+		}
+		l, r := left.eval, right.eval
+		left = &expression{kind: boolKind, eval: func(e env) (value, error) {
+			lv, err := l(e)
+			if err != nil || !lv.b {
+				return lv, err
+			}
+			return r(e)
+		}}
+	}
+}
+
+func (p *parser) not() (*expression, error) {
+	if _, ok := p.accept("!"); !ok {
+		return p.comparison()
+	}
+	operand, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	if err := expect(operand, boolKind, "!"); err != nil {
+		return nil, err
+	}
+	return &expression{kind: boolKind, eval: func(e env) (value, error) {
+		v, err := operand.eval(e)
+		return value{b: !v.b}, err
+	}}, nil
+}
+
+func (p *parser) comparison() (*expression, error) {
+	left, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("==", "!=", "<=", ">=", "<", ">")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if left.kind != right.kind {
+		return nil, errors.Errorf("Operator %s compares %s with %s", op, left.kind, right.kind)
+	}
+	if left.kind == boolKind && op != "==" && op != "!=" {
+		return nil, errors.Errorf("Operator %s can't compare bool", op)
+	}
+	l, r := left.eval, right.eval
+	return &expression{kind: boolKind, eval: func(e env) (value, error) {
+		lv, err := l(e)
+		if err != nil {
+			return value{}, err
+		}
+		rv, err := r(e)
+		if err != nil {
+			return value{}, err
+		}
+		c := compare(lv, rv)
+		switch op {
+		case "==":
+			return value{b: c == 0}, nil
+		case "!=":
+			return value{b: c != 0}, nil
+		case "<":
+			return value{b: c < 0}, nil
+		case "<=":
+			return value{b: c <= 0}, nil
+		case ">":
+			return value{b: c > 0}, nil
+		default:
+			return value{b: c >= 0}, nil
+		}
+	}}, nil
+}
+
+// compare works for values of the same kind only, which the parser makes
+// sure of.
+func compare(a, b value) int {
+	switch {
+	case a.i != b.i:
+		if a.i < b.i {
+			return -1
+		}
+		return 1
+	case a.b != b.b:
+		if !a.b {
+			return -1
+		}
+		return 1
+	default:
+		return strings.Compare(a.s, b.s)
+	}
+}
+
+func (p *parser) sum() (*expression, error) {
+	left, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = arithmetic(op, left, right); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) product() (*expression, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("*", "/", "%")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = arithmetic(op, left, right); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func arithmetic(op string, left, right *expression) (*expression, error) {
+	if err := expect(left, intKind, op); err != nil {
+		return nil, err
+	}
+	if err := expect(right, intKind, op); err != nil {
+		return nil, err
+	}
+	l, r := left.eval, right.eval
+	return &expression{kind: intKind, eval: func(e env) (value, error) {
+		lv, err := l(e)
+		if err != nil {
+			return value{}, err
+		}
+		rv, err := r(e)
+		if err != nil {
+			return value{}, err
+		}
+		switch op {
+		case "+":
+			return value{i: lv.i + rv.i}, nil
+		case "-":
+			return value{i: lv.i - rv.i}, nil
+		case "*":
+			return value{i: lv.i * rv.i}, nil
+		}
+		if rv.i == 0 {
+			return value{}, errors.New("Division by zero")
+		}
+		if op == "/" {
+			return value{i: lv.i / rv.i}, nil
+		}
+		return value{i: lv.i % rv.i}, nil
+	}}, nil
+}
+
+func (p *parser) unary() (*expression, error) {
+	if _, ok := p.accept("-"); !ok {
+		return p.primary()
+	}
+	operand, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	if err := expect(operand, intKind, "-"); err != nil {
+		return nil, err
+	}
+	return &expression{kind: intKind, eval: func(e env) (value, error) {
+		v, err := operand.eval(e)
+		return value{i: -v.i}, err
+	}}, nil
+}
+
+func constant(k kind, v value) *expression {
+	return &expression{kind: k, eval: func(env) (value, error) {
+		return v, nil
+	}}
+}
+
+func (p *parser) primary() (*expression, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, errors.New("Unexpected end of expression")
+	}
+	p.pos++
+	switch {
+	case t.str:
+		return constant(stringKind, value{s: t.text}), nil
+	case t.text == "(":
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.accept(")"); !ok {
+			return nil, errors.Errorf("Missing ) for ( at %d", t.offset)
+		}
+		return e, nil
+	case t.text == "true" || t.text == "false":
+		return constant(boolKind, value{b: t.text == "true"}), nil
+	case unicode.IsDigit(rune(t.text[0])):
+		i, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("Invalid number %s at %d", t.text, t.offset)
+		}
+		return constant(intKind, value{i: i}), nil
+	case unicode.IsLetter(rune(t.text[0])) || t.text[0] == '_':
+		k, ok := p.variables[t.text]
+		if !ok {
+			return nil, errors.Errorf("Unknown variable %s at %d", t.text, t.offset)
+		}
+		name := t.text
+		return &expression{kind: k, eval: func(e env) (value, error) {
+			return e[name], nil
+		}}, nil
+	default:
+		return nil, errors.Errorf("Unexpected %q at %d", t.text, t.offset)
+	}
+}
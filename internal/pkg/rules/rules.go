@@ -0,0 +1,232 @@
+package rules
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/hooks"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Kinds of transactions as seen by the kind variable.
+const (
+	Base          = "base"
+	Certification = "certification"
+	Evidence      = "evidence"
+	Guardianship  = "guardianship"
+	Recovery      = "recovery"
+	Stake         = "stake"
+	Payout        = "payout"
+	Vote          = "vote"
+	Withdrawal    = "withdrawal"
+)
+
+var variables = map[string]kind{
+	"kind":      stringKind,
+	"timestamp": intKind,
+	"year":      intKind,
+	"month":     intKind,
+	"day":       intKind,
+	"weekday":   intKind,
+	"hour":      intKind,
+	"minute":    intKind,
+	"date":      stringKind,
+	"inputs":    intKind,
+	"outputs":   intKind,
+	"value":     intKind,
+}
+
+type rule struct {
+	name       string
+	expression *expression
+}
+
+// Rules are election rules every transaction has to satisfy. Rules only see
+// the transaction itself and compute with integers, so every node reaches
+// the same verdict on the same transaction.
+type Rules struct {
+	source []byte
+	rules  []rule
+}
+
+// Parse parses rules written one per line as name: expression. Lines
+// starting with # are comments.
+func Parse(source []byte) (*Rules, error) {
+	result := &Rules{source: source}
+	names := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(source))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("Line %d is not a rule of the form name: expression", line)
+		}
+		name := strings.TrimSpace(parts[0])
+		switch {
+		case !validName.MatchString(name):
+			return nil, errors.Errorf("Rule name %q on line %d may only contain lowercase letters, digits and dashes", name, line)
+		case names[name]:
+			return nil, errors.Errorf("Rule %s on line %d is defined more than once", name, line)
+		}
+		names[name] = true
+		e, err := compile(parts[1], variables)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to parse rule %s on line %d", name, line)
+		}
+		if e.kind != boolKind {
+			return nil, errors.Errorf("Rule %s on line %d is %s instead of bool", name, line, e.kind)
+		}
+		result.rules = append(result.rules, rule{name: name, expression: e})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "Failed to read rules")
+	}
+	if len(result.rules) == 0 {
+		return nil, errors.New("No rules are defined")
+	}
+	return result, nil
+}
+
+func Read(fileName string) (*Rules, error) {
+	raw, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read rules %s", fileName)
+	}
+	r, err := Parse(raw)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse rules %s", fileName)
+	}
+	return r, nil
+}
+
+// Hash identifies the rules of the election. Nil rules have no hash.
+func (r *Rules) Hash() []byte {
+	if r == nil {
+		return nil
+	}
+	hash := sha256.Sum256(r.source)
+	return hash[:]
+}
+
+func kindOf(t transaction.Transaction, alfaKeyHash []byte) string {
+	switch {
+	case t.IsCertification():
+		return Certification
+	case t.IsEvidence():
+		return Evidence
+	case t.IsGuardianship():
+		return Guardianship
+	case t.IsRecovery():
+		return Recovery
+	case t.IsWithdrawal():
+		return Withdrawal
+	case len(t.Inputs) > 0 && t.Inputs[0].Vout == -1:
+		return Base
+	}
+	if _, ok := transaction.StakeOutput(t, alfaKeyHash); ok {
+		return Stake
+	}
+	if t.AreInputsFrom(alfaKeyHash) {
+		return Payout
+	}
+	return Vote
+}
+
+func newEnv(t transaction.Transaction, alfaKeyHash []byte) env {
+	at := time.Unix(t.Timestamp, 0).UTC()
+	value := 0
+	for _, out := range t.Outputs {
+		value += out.Value
+	}
+	return env{
+		"kind":      {s: kindOf(t, alfaKeyHash)},
+		"timestamp": {i: t.Timestamp},
+		"year":      {i: int64(at.Year())},
+		"month":     {i: int64(at.Month())},
+		"day":       {i: int64(at.Day())},
+		"weekday":   {i: int64(at.Weekday())},
+		"hour":      {i: int64(at.Hour())},
+		"minute":    {i: int64(at.Minute())},
+		"date":      {s: at.Format("2006-01-02")},
+		"inputs":    {i: int64(len(t.Inputs))},
+		"outputs":   {i: int64(len(t.Outputs))},
+		"value":     {i: int64(value)},
+	}
+}
+
+// Validator vetoes transactions which break a rule. The alfa key hash tells
+// stakes and payouts of the alfa node apart from votes. Nil rules accept
+// every transaction.
+func (r *Rules) Validator(alfaKeyHash []byte) transaction.ValidateFn {
+	return func(t transaction.Transaction) error {
+		if r == nil {
+			return nil
+		}
+		e := newEnv(t, alfaKeyHash)
+		for _, rule := range r.rules {
+			v, err := rule.expression.eval(e)
+			switch {
+			case err != nil:
+				return errors.Wrapf(hooks.ErrRejected, "Transaction %x rejected by rule %s: %s", t.ID, rule.name, err)
+			case !v.b:
+				return errors.Wrapf(hooks.ErrRejected, "Transaction %x rejected by rule %s", t.ID, rule.name)
+			}
+		}
+		return nil
+	}
+}
+
+// NewCommitment creates the transaction which commits the genesis block to
+// the rules. Its only output holds the hash of the rules and no value, no
+// key hashes to it so it can never be spent.
+func NewCommitment(algorithm digest.Algorithm, hash []byte) (*transaction.Transaction, error) {
+	return transaction.NewTransaction(algorithm, nil, transaction.Outputs{{PublicKeyHash: hash}})
+}
+
+// Committed returns the hash of the rules the genesis block commits to, nil
+// if the election has no rules.
+func Committed(genesis blockchain.Block) []byte {
+	for _, t := range genesis.Body.Transactions {
+		if len(t.Inputs) == 0 && len(t.Outputs) == 1 && t.Outputs[0].Value == 0 && !t.IsCertification() && !t.IsEvidence() {
+			return t.Outputs[0].PublicKeyHash
+		}
+	}
+	return nil
+}
+
+// VerifyGenesis makes sure the node enforces the same rules the election
+// was started with. There is nothing to verify before the blockchain has a
+// genesis block.
+func VerifyGenesis(findBlock blockchain.FindBlockFn, r *Rules) error {
+	genesis, ok, err := findBlock(func(b blockchain.Block) bool {
+		return len(b.Header.Prev) == 0
+	})
+	switch {
+	case err != nil:
+		return errors.Wrap(err, "Failed to find genesis block")
+	case !ok:
+		return nil
+	}
+	switch committed := Committed(genesis); {
+	case committed == nil && r != nil:
+		return errors.Errorf("Election has no rules but rules %x are loaded", r.Hash())
+	case committed != nil && r == nil:
+		return errors.Errorf("Election has rules %x which are not loaded", committed)
+	case !bytes.Equal(committed, r.Hash()):
+		return errors.Errorf("Election has rules %x but rules %x are loaded", committed, r.Hash())
+	}
+	return nil
+}
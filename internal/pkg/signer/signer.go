@@ -0,0 +1,313 @@
+package signer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+const (
+	PurposeTransaction = "transaction"
+	PurposeCertificate = "certificate"
+	PurposeMessage     = "message"
+	purposePublicKey   = "public-key"
+
+	maxClockSkew = 30 * time.Second
+)
+
+var ErrUnauthenticated = errors.New("Request is not authenticated")
+
+// Request asks the signer to sign the payload. Every request is
+// authenticated with the secret shared by the signer and its clients, and
+// its nonce can't be used twice.
+type Request struct {
+	Purpose   string `json:"purpose"`
+	Payload   []byte `json:"payload"`
+	Nonce     []byte `json:"nonce"`
+	Timestamp int64  `json:"timestamp"`
+	MAC       []byte `json:"mac"`
+}
+
+type Response struct {
+	Nonce     []byte `json:"nonce"`
+	Signature []byte `json:"signature,omitempty"`
+	PublicKey []byte `json:"publicKey,omitempty"`
+	Error     string `json:"error,omitempty"`
+	MAC       []byte `json:"mac"`
+}
+
+func mac(secret []byte, parts ...[]byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	for _, part := range parts {
+		length := make([]byte, 8)
+		binary.BigEndian.PutUint64(length, uint64(len(part)))
+		h.Write(length)
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+func timestampBytes(timestamp int64) []byte {
+	raw := make([]byte, 8)
+	binary.BigEndian.PutUint64(raw, uint64(timestamp))
+	return raw
+}
+
+func (r Request) mac(secret []byte) []byte {
+	return mac(secret, []byte(r.Purpose), r.Payload, r.Nonce, timestampBytes(r.Timestamp))
+}
+
+func (r Response) mac(secret []byte) []byte {
+	return mac(secret, r.Nonce, r.Signature, r.PublicKey, []byte(r.Error))
+}
+
+// LoadOrGenerateSecret reads the hex encoded shared secret from path and
+// creates it if the file doesn't exist.
+func LoadOrGenerateSecret(path string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		return hex.DecodeString(strings.TrimSpace(string(content)))
+	case !os.IsNotExist(err):
+		return nil, errors.Wrapf(err, "Failed to read secret %s", path)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, errors.Wrap(err, "Failed to generate secret")
+	}
+	if err := ioutil.WriteFile(path, []byte(hex.EncodeToString(secret)), 0600); err != nil {
+		return nil, errors.Wrapf(err, "Failed to write secret %s", path)
+	}
+	return secret, nil
+}
+
+type rawSignable []byte
+
+func (r rawSignable) Signable() ([]byte, error) {
+	return r, nil
+}
+
+type Server struct {
+	wallet   wallet.Wallet
+	secret   []byte
+	purposes map[string]bool
+	lock     *sync.Mutex
+	seen     map[string]time.Time
+}
+
+func NewServer(w wallet.Wallet, secret []byte, purposes []string) *Server {
+	allowed := make(map[string]bool)
+	for _, p := range purposes {
+		allowed[p] = true
+	}
+	return &Server{
+		wallet:   w,
+		secret:   secret,
+		purposes: allowed,
+		lock:     &sync.Mutex{},
+		seen:     make(map[string]time.Time),
+	}
+}
+
+func (s *Server) isReplayed(nonce []byte, now time.Time) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for n, at := range s.seen {
+		if now.Sub(at) > 2*maxClockSkew {
+			delete(s.seen, n)
+		}
+	}
+	if _, ok := s.seen[string(nonce)]; ok {
+		return true
+	}
+	s.seen[string(nonce)] = now
+	return false
+}
+
+func (s *Server) handle(r Request) Response {
+	now := time.Now()
+	timestamp := time.Unix(r.Timestamp, 0)
+	switch {
+	case !hmac.Equal(r.MAC, r.mac(s.secret)) || len(r.Nonce) == 0:
+		return Response{Error: ErrUnauthenticated.Error()}
+	case timestamp.Before(now.Add(-maxClockSkew)) || timestamp.After(now.Add(maxClockSkew)):
+		return Response{Error: "Request has expired"}
+	case s.isReplayed(r.Nonce, now):
+		return Response{Error: "Request has already been served"}
+	case r.Purpose == purposePublicKey:
+		return Response{PublicKey: s.wallet.PublicKey}
+	case !s.purposes[r.Purpose]:
+		log.Printf("Refused to sign for purpose %s", r.Purpose)
+		return Response{Error: "Purpose is not allowed"}
+	}
+	signature, err := wallet.Sign(rawSignable(r.Payload), s.wallet.PrivateKey)
+	if err != nil {
+		return Response{Error: "Failed to sign payload"}
+	}
+	log.Printf("Signed %s %x", r.Purpose, sha256.Sum256(r.Payload))
+	return Response{Signature: signature}
+}
+
+func (s *Server) serveConnection(conn net.Conn) {
+	defer conn.Close()
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	encoder := json.NewEncoder(conn)
+	for {
+		var r Request
+		if err := decoder.Decode(&r); err != nil {
+			return
+		}
+		response := s.handle(r)
+		response.Nonce = r.Nonce
+		response.MAC = response.mac(s.secret)
+		if err := encoder.Encode(response); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return errors.Wrap(err, "Failed to accept connection")
+		}
+		go s.serveConnection(conn)
+	}
+}
+
+// Client requests signatures from the signer over a unix socket. A single
+// connection is used and reopened whenever it breaks.
+type Client struct {
+	path    string
+	secret  []byte
+	lock    *sync.Mutex
+	conn    net.Conn
+	decoder *json.Decoder
+}
+
+func NewClient(path string, secret []byte) *Client {
+	return &Client{
+		path:   path,
+		secret: secret,
+		lock:   &sync.Mutex{},
+	}
+}
+
+func (c *Client) send(r Request) (*Response, error) {
+	if c.conn == nil {
+		conn, err := net.DialTimeout("unix", c.path, 5*time.Second)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to connect to signer %s", c.path)
+		}
+		c.conn = conn
+		c.decoder = json.NewDecoder(bufio.NewReader(conn))
+	}
+	c.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := json.NewEncoder(c.conn).Encode(r); err != nil {
+		c.conn.Close()
+		c.conn = nil
+		return nil, errors.Wrap(err, "Failed to send sign request")
+	}
+	var response Response
+	if err := c.decoder.Decode(&response); err != nil {
+		c.conn.Close()
+		c.conn = nil
+		return nil, errors.Wrap(err, "Failed to read sign response")
+	}
+	return &response, nil
+}
+
+func (c *Client) call(purpose string, payload []byte) (*Response, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "Failed to generate nonce")
+	}
+	r := Request{
+		Purpose:   purpose,
+		Payload:   payload,
+		Nonce:     nonce,
+		Timestamp: time.Now().Unix(),
+	}
+	r.MAC = r.mac(c.secret)
+	response, err := c.send(r)
+	if err != nil && c.conn == nil {
+		response, err = c.send(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(response.Nonce, nonce) || !hmac.Equal(response.MAC, response.mac(c.secret)) {
+		return nil, errors.New("Signer response is not authenticated")
+	}
+	if response.Error != "" {
+		return nil, errors.Errorf("Signer refused to sign: %s", response.Error)
+	}
+	return response, nil
+}
+
+func (c *Client) PublicKey() ([]byte, error) {
+	response, err := c.call(purposePublicKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	return response.PublicKey, nil
+}
+
+// Signer returns a wallet.Signer which signs through the signer for the given
+// purpose.
+func (c *Client) Signer(purpose string, publicKey []byte) wallet.Signer {
+	return remoteSigner{
+		client:    c,
+		purpose:   purpose,
+		publicKey: publicKey,
+	}
+}
+
+type remoteSigner struct {
+	client    *Client
+	purpose   string
+	publicKey []byte
+}
+
+func (r remoteSigner) SignRaw(signable wallet.Signable) ([]byte, error) {
+	payload, err := signable.Signable()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to convert to signable %#v", signable)
+	}
+	response, err := r.client.call(r.purpose, payload)
+	if err != nil {
+		return nil, err
+	}
+	return response.Signature, nil
+}
+
+func (r remoteSigner) Sign(signable wallet.Signable) (string, error) {
+	signature, err := r.SignRaw(signable)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+func (r remoteSigner) Verifier() string {
+	return base64.StdEncoding.EncodeToString(r.publicKey)
+}
@@ -0,0 +1,104 @@
+package sortition
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/round"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/vrf"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrStale    = errors.New("Sortition proof is of an earlier round")
+	ErrMismatch = errors.New("Sortition proof doesn't match the round")
+)
+
+// ProveFn returns the proof that the node may forge in the sortition round,
+// or nil if it may not.
+type ProveFn func(round.Round) (*transaction.Sortition, error)
+
+// Prover evaluates the VRF of the node with its key, the node may forge if
+// the output falls under its share of the weights of the round.
+func Prover(key ecdsa.PrivateKey, nodeID string) ProveFn {
+	return func(r round.Round) (*transaction.Sortition, error) {
+		output, proof, err := vrf.Prove(key, r.Alpha())
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to evaluate VRF in round %d", r.Number)
+		}
+		weight, total := r.Weights[nodeID], r.Total()
+		if !round.Eligible(output, weight, total) {
+			return nil, nil
+		}
+		return &transaction.Sortition{
+			Round:  r.Number,
+			Prev:   r.Prev,
+			Proof:  proof,
+			Weight: weight,
+			Total:  total,
+		}, nil
+	}
+}
+
+// Verify checks the proof under the raw public key of the forger and that
+// its output lets the forger forge with the weight it claims. The seed of
+// the round is recomputed from Prev.
+func Verify(s transaction.Sortition, publicKey []byte) bool {
+	if s.Round == 0 {
+		return false
+	}
+	r := round.Round{
+		Number: s.Round,
+		Seed:   round.Seed(s.Prev, s.Round-1),
+	}
+	output, ok := vrf.Verify(publicKey, r.Alpha(), s.Proof)
+	return ok && round.Eligible(output, s.Weight, s.Total)
+}
+
+// VerifyBlock rejects blocks whose stake transaction carries a sortition
+// proof which doesn't verify under the key of the forger or whose round
+// started at a block which isn't known. Only alfa knows the weights of a
+// round, it checks them against the ones the proof claims by Match. Blocks
+// without a proof were forged in rounds in which alfa selected the forger.
+func VerifyBlock(getBlock blockchain.GetBlockFn, verifyBlock blockchain.VerifyBlockFn) blockchain.VerifyBlockFn {
+	return func(block blockchain.Block, hashedSender []byte) bool {
+		if !verifyBlock(block, hashedSender) {
+			return false
+		}
+		stake := block.Body.Transactions[0]
+		if stake.Sortition == nil {
+			return true
+		}
+		if len(stake.Inputs) == 0 {
+			return false
+		}
+		verifier := stake.Inputs[0].Verifier
+		if hashed, err := wallet.HashedPublicKey(verifier); err != nil || !bytes.Equal(hashed, hashedSender) {
+			return false
+		}
+		if prev, err := getBlock(stake.Sortition.Prev); err != nil || prev == nil {
+			return false
+		}
+		return Verify(*stake.Sortition, verifier)
+	}
+}
+
+// Match checks the proof a node forged with against the latest round. A
+// proof of an earlier round lost the race to a block which started a new
+// round, which is no fault of the forger.
+func Match(latest round.Round, nodeID string, s *transaction.Sortition) error {
+	switch {
+	case s == nil:
+		return errors.Wrapf(ErrMismatch, "Block of node %s carries no proof in round %d", nodeID, latest.Number)
+	case s.Round < latest.Number:
+		return errors.Wrapf(ErrStale, "Proof of node %s is of round %d, round %d started", nodeID, s.Round, latest.Number)
+	case s.Round != latest.Number || !bytes.Equal(s.Prev, latest.Prev):
+		return errors.Wrapf(ErrMismatch, "Proof of node %s is of round %d, the latest one is %d", nodeID, s.Round, latest.Number)
+	case s.Weight != latest.Weights[nodeID] || s.Total != latest.Total():
+		return errors.Wrapf(ErrMismatch, "Node %s claims weight %d out of %d in round %d", nodeID, s.Weight, s.Total, latest.Number)
+	}
+	return nil
+}
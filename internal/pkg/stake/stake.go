@@ -0,0 +1,30 @@
+package stake
+
+// SaveNodeFn remembers the key of a registered node, so its stake can be
+// returned after the node has gone.
+type SaveNodeFn func(nodeID string, keyHash []byte) error
+
+type GetNodesFn func() (map[string][]byte, error)
+
+// ReleaseFn returns every stake of the stakeholder that hasn't been returned
+// yet and returns the number of returned stakes.
+type ReleaseFn func(stakeholder []byte) (int, error)
+
+// Account is what a node needs to build its stake and key rotation
+// transactions without an index of its own. Transactions carry no nonce, so
+// the newest transaction spending the node's outputs, on chain or pending,
+// stands in for it; a new transaction should be timestamped after it.
+type Account struct {
+	Balance         int    `json:"balance"`
+	Locked          int    `json:"locked"`
+	LastTransaction []byte `json:"lastTransaction,omitempty"`
+	Nonce           int64  `json:"nonce"`
+}
+
+type GetAccountFn func(stakeholder []byte) (Account, error)
+
+// BurnFn forfeits the stake transaction of a forger whose block failed
+// verification, the alfa node never returns a burned stake.
+type BurnFn func(stakeID []byte, stakeholder []byte, reason string) error
+
+type IsBurnedFn func(stakeID []byte) (bool, error)
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/repository"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+)
+
+// boltStore is the bolt backend, it keeps the data the way the repository
+// package always did, so databases of running elections open as they are.
+type boltStore struct {
+	db                  *bolt.DB
+	getTip              blockchain.GetTipFn
+	getBlock            blockchain.GetBlockFn
+	addBlock            blockchain.AddBlockFn
+	addNewBlock         blockchain.AddNewBlockFn
+	getUTXOsByPublicKey transaction.GetUTXOsByPublicKeyFn
+	getUTXOs            transaction.GetUTXOsFn
+	getTransactionUTXO  transaction.GetTransactionUTXO
+	saveTransaction     transaction.SaveTransaction
+	getTransactions     transaction.GetTransactionsFn
+	saveParty           party.SavePartyFn
+	getParty            party.GetPartyFn
+	getParties          party.GetPartiesFn
+}
+
+func NewBolt(db *bolt.DB) Repository {
+	return boltStore{
+		db:                  db,
+		getTip:              repository.GetTip(db),
+		getBlock:            repository.GetBlock(db),
+		addBlock:            repository.AddBlock(db),
+		addNewBlock:         repository.AddNewBlock(db),
+		getUTXOsByPublicKey: repository.GetUTXOsByPublicKey(db),
+		getUTXOs:            repository.GetUTXOs(db),
+		getTransactionUTXO:  repository.GetTransactionUTXO(db),
+		saveTransaction:     repository.SaveTransaction(db),
+		getTransactions:     repository.GetTransactions(db),
+		saveParty:           repository.SaveParty(db),
+		getParty:            repository.GetParty(db),
+		getParties:          repository.GetParties(db),
+	}
+}
+
+func OpenBolt(path string, o repository.Options) (Repository, error) {
+	db, err := repository.Open(path, o)
+	if err != nil {
+		return nil, err
+	}
+	return NewBolt(db), nil
+}
+
+func (s boltStore) DB() *bolt.DB {
+	return s.db
+}
+
+func (s boltStore) GetTip() []byte {
+	return s.getTip()
+}
+
+func (s boltStore) GetBlock(hash []byte) (*blockchain.Block, error) {
+	return s.getBlock(hash)
+}
+
+func (s boltStore) AddBlock(b blockchain.Block) ([]byte, error) {
+	return s.addBlock(b)
+}
+
+func (s boltStore) AddNewBlock(b blockchain.Block) error {
+	return s.addNewBlock(b)
+}
+
+func (s boltStore) GetUTXOsByPublicKey(publicKeyHash []byte) (transaction.UTXOs, error) {
+	return s.getUTXOsByPublicKey(publicKeyHash)
+}
+
+func (s boltStore) GetUTXOs() (transaction.UTXOs, error) {
+	return s.getUTXOs()
+}
+
+func (s boltStore) GetTransactionUTXO(id []byte, vout int) (*transaction.UTXO, error) {
+	return s.getTransactionUTXO(id, vout)
+}
+
+func (s boltStore) SaveTransaction(t transaction.Transaction) error {
+	return s.saveTransaction(t)
+}
+
+func (s boltStore) GetTransactions() (transaction.Transactions, error) {
+	return s.getTransactions()
+}
+
+func (s boltStore) SaveParty(p party.Party) error {
+	return s.saveParty(p)
+}
+
+func (s boltStore) GetParty(address string) (*party.Party, error) {
+	return s.getParty(address)
+}
+
+func (s boltStore) GetParties() (party.Parties, error) {
+	return s.getParties()
+}
+
+func (s boltStore) Close() error {
+	return s.db.Close()
+}
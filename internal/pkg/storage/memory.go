@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/nebser/crypto-vote/internal/pkg/repository"
+	"github.com/pkg/errors"
+)
+
+// sharedMemory is a file system kept in memory on Linux.
+const sharedMemory = "/dev/shm"
+
+// memoryStore is the bolt backend on a temporary file which is removed on
+// Close, so blocks and transactions are validated exactly like in bolt. The
+// file is kept in memory where the system has a shared memory file system.
+type memoryStore struct {
+	boltStore
+	path string
+}
+
+// NewMemory opens an empty repository which is lost on Close. It is meant
+// for tests, tools and nodes which don't keep the election across restarts.
+func NewMemory() (Repository, error) {
+	dir := os.TempDir()
+	if info, err := os.Stat(sharedMemory); err == nil && info.IsDir() {
+		dir = sharedMemory
+	}
+	f, err := ioutil.TempFile(dir, "crypto-vote-*.db")
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create temporary database")
+	}
+	path := f.Name()
+	f.Close()
+	db, err := repository.Open(path, repository.Options{})
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	// Nothing has to survive a crash.
+	db.NoSync = true
+	return memoryStore{boltStore: NewBolt(db).(boltStore), path: path}, nil
+}
+
+func (s memoryStore) Close() error {
+	err := s.db.Close()
+	if removeErr := os.Remove(s.path); removeErr != nil && err == nil {
+		err = errors.Wrapf(removeErr, "Failed to remove temporary database %s", s.path)
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+// Package storage puts the blockchain, the utxo set, the mempool and the
+// parties behind interfaces, so the same code runs against bolt, memory or
+// any other backend registered with Register.
+package storage
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/boltdb/bolt"
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/party"
+	"github.com/nebser/crypto-vote/internal/pkg/repository"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+type BlockStore interface {
+	GetTip() []byte
+	GetBlock(hash []byte) (*blockchain.Block, error)
+	// AddBlock adds a block which is already verified, e.g. one forged
+	// locally, spending its inputs and saving its outputs.
+	AddBlock(b blockchain.Block) ([]byte, error)
+	// AddNewBlock adds a block received from another node, refusing it with
+	// blockchain.ErrInvalidBlock if it spends missing outputs or is too big.
+	AddNewBlock(b blockchain.Block) error
+}
+
+type UTXOStore interface {
+	GetUTXOsByPublicKey(publicKeyHash []byte) (transaction.UTXOs, error)
+	GetUTXOs() (transaction.UTXOs, error)
+	GetTransactionUTXO(id []byte, vout int) (*transaction.UTXO, error)
+}
+
+// TransactionStore holds the pending transactions.
+type TransactionStore interface {
+	SaveTransaction(t transaction.Transaction) error
+	GetTransactions() (transaction.Transactions, error)
+}
+
+type PartyStore interface {
+	SaveParty(p party.Party) error
+	GetParty(address string) (*party.Party, error)
+	GetParties() (party.Parties, error)
+}
+
+// Repository is everything a node keeps about the election.
+type Repository interface {
+	BlockStore
+	UTXOStore
+	TransactionStore
+	PartyStore
+	Close() error
+}
+
+// BoltBacked is implemented by backends keeping the repository in a bolt
+// database. The alfa and client nodes keep the records which are not behind
+// Repository, e.g. the audit log, the rounds and the outbox, in the same
+// database, so they run only on such backends.
+type BoltBacked interface {
+	DB() *bolt.DB
+}
+
+// DB returns the bolt database of the repository.
+func DB(r Repository) (*bolt.DB, error) {
+	b, ok := r.(BoltBacked)
+	if !ok {
+		return nil, errors.Errorf("Storage backend %T doesn't keep a bolt database", r)
+	}
+	return b.DB(), nil
+}
+
+// OpenFn opens the repository at the path, whatever the path means to the
+// backend, e.g. a file, a directory or a connection string. Backends ignore
+// the options they have no use for.
+type OpenFn func(path string, o repository.Options) (Repository, error)
+
+var (
+	lock     sync.RWMutex
+	backends = map[string]OpenFn{
+		"bolt": OpenBolt,
+		"memory": func(string, repository.Options) (Repository, error) {
+			return NewMemory()
+		},
+	}
+)
+
+// Register makes a backend, e.g. BadgerDB or SQL, available to Open.
+func Register(name string, open OpenFn) {
+	lock.Lock()
+	defer lock.Unlock()
+	backends[name] = open
+}
+
+func Backends() []string {
+	lock.RLock()
+	defer lock.RUnlock()
+	result := []string{}
+	for name := range backends {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func Open(backend, path string, o repository.Options) (Repository, error) {
+	lock.RLock()
+	open, ok := backends[backend]
+	lock.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("Unknown storage backend %s, available are %v", backend, Backends())
+	}
+	r, err := open(path, o)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open %s storage %s", backend, path)
+	}
+	return r, nil
+}
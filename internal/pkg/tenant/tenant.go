@@ -0,0 +1,105 @@
+package tenant
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Tenant is an organization running its own election on a shared alfa
+// deployment. Args are the alfa options of the tenant's election, default
+// paths of files are inside the directory named after the tenant.
+type Tenant struct {
+	ID    string   `json:"id"`
+	Hosts []string `json:"hosts"`
+	Args  []string `json:"args"`
+}
+
+type Tenants []Tenant
+
+// Read reads tenants from a JSON file.
+func Read(fileName string) (Tenants, error) {
+	raw, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read tenants %s", fileName)
+	}
+	var result Tenants
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse tenants %s", fileName)
+	}
+	ids := map[string]bool{}
+	hosts := map[string]bool{}
+	for _, t := range result {
+		switch {
+		case !validID.MatchString(t.ID):
+			return nil, errors.Errorf("Tenant id %q may only contain lowercase letters, digits and dashes", t.ID)
+		case ids[t.ID]:
+			return nil, errors.Errorf("Tenant %q is defined more than once", t.ID)
+		}
+		ids[t.ID] = true
+		for _, h := range t.Hosts {
+			h = strings.ToLower(h)
+			if hosts[h] {
+				return nil, errors.Errorf("Host %q is used by more than one tenant", h)
+			}
+			hosts[h] = true
+		}
+	}
+	if len(result) == 0 {
+		return nil, errors.New("No tenants are defined")
+	}
+	return result, nil
+}
+
+// Prefix is the path prefix under which the routes of the tenant are
+// served.
+func Prefix(id string) string {
+	return "/t/" + id
+}
+
+// Router dispatches requests to the tenant selected by the hostname of the
+// request or, failing that, by the path prefix.
+type Router struct {
+	hosts map[string]http.Handler
+	ids   map[string]http.Handler
+}
+
+func NewRouter() *Router {
+	return &Router{
+		hosts: make(map[string]http.Handler),
+		ids:   make(map[string]http.Handler),
+	}
+}
+
+func (r *Router) Add(t Tenant, h http.Handler) {
+	for _, host := range t.Hosts {
+		r.hosts[strings.ToLower(host)] = h
+	}
+	r.ids[t.ID] = http.StripPrefix(Prefix(t.ID), h)
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if h, ok := r.hosts[strings.ToLower(host)]; ok {
+		h.ServeHTTP(w, req)
+		return
+	}
+	if rest := strings.TrimPrefix(req.URL.Path, Prefix("")); rest != req.URL.Path {
+		id := strings.SplitN(rest, "/", 2)[0]
+		if h, ok := r.ids[id]; ok {
+			h.ServeHTTP(w, req)
+			return
+		}
+	}
+	http.Error(w, "Unknown tenant", http.StatusNotFound)
+}
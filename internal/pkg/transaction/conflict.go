@@ -0,0 +1,40 @@
+package transaction
+
+// Spend is a transaction seen spending an output. Block is set once the
+// transaction is included in the blockchain.
+type Spend struct {
+	Transaction []byte
+	Source      string
+	Timestamp   int64
+	SeenAt      int64
+	Block       []byte
+}
+
+// Conflict lists the transactions competing for the same output.
+type Conflict struct {
+	TransactionID []byte
+	Vout          int
+	Spends        []Spend
+}
+
+// Winner returns the spend included in the blockchain.
+func (c Conflict) Winner() (Spend, bool) {
+	for _, s := range c.Spends {
+		if len(s.Block) > 0 {
+			return s, true
+		}
+	}
+	return Spend{}, false
+}
+
+// GetConflictsFn returns the conflicts the transaction is part of.
+type GetConflictsFn func(id []byte) ([]Conflict, error)
+
+// Sources of spends.
+const (
+	SourceVote   = "vote"
+	SourceBallot = "ballot"
+	SourcePeer   = "peer"
+	SourceAlfa   = "alfa"
+	SourceBlock  = "block"
+)
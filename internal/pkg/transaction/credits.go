@@ -0,0 +1,133 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/nebser/crypto-vote/internal/pkg/codec"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+var ErrCreditsExceeded = errors.New("Voter has no credits left")
+
+// Allocation gives credits of a voter to a recipient, every credit is worth
+// a vote.
+type Allocation struct {
+	Recipient []byte `json:"recipient"`
+	Credits   int    `json:"credits"`
+}
+
+type Allocations []Allocation
+
+func (allocations Allocations) Credits() int {
+	result := 0
+	for _, a := range allocations {
+		result += a.Credits
+	}
+	return result
+}
+
+func (allocations Allocations) Value() int {
+	return allocations.Credits() * VoteValue
+}
+
+// CastAllocationsFn spends the voter's utxos giving the credits of every
+// allocation to its recipient and returns the rest to the voter.
+type CastAllocationsFn func(from []byte, allocations Allocations, signature, verifier []byte) (Transaction, error)
+
+// GetUsedCreditsFn returns the number of credits the voter gave to the
+// choices of the ballot in the blockchain, according to the voter index.
+type GetUsedCreditsFn func(voter []byte) (int, error)
+
+type allocationSignable struct {
+	Sender      []byte      `json:"sender"`
+	Allocations Allocations `json:"allocations"`
+	Value       int         `json:"value"`
+}
+
+func (s allocationSignable) Signable() ([]byte, error) {
+	w := codec.NewWriter().
+		String("allocation").
+		Bytes(s.Sender).
+		Uint(uint64(len(s.Allocations)))
+	for _, a := range s.Allocations {
+		w.Bytes(a.Recipient).Int(int64(a.Credits))
+	}
+	return w.Int(int64(s.Value)).Result(), nil
+}
+
+func (s allocationSignable) legacy() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+// NewAllocationSignable is what a voter signs to split credits across
+// recipients. Allocations are sorted by recipient so that the order of
+// outputs in the transaction doesn't matter.
+func NewAllocationSignable(from []byte, allocations Allocations) wallet.Signable {
+	sorted := append(Allocations{}, allocations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].Recipient, sorted[j].Recipient) < 0
+	})
+	return allocationSignable{
+		Sender:      from,
+		Allocations: sorted,
+		Value:       sorted.Value(),
+	}
+}
+
+// Allocations returns what the transaction gives to others than the sender,
+// false if an output isn't worth whole credits.
+func (t Transaction) Allocations(sender []byte) (Allocations, bool) {
+	var result Allocations
+	for _, out := range t.Outputs {
+		if bytes.Equal(out.PublicKeyHash, sender) {
+			continue
+		}
+		if out.Value <= 0 || out.Value%VoteValue != 0 {
+			return nil, false
+		}
+		result = append(result, Allocation{Recipient: out.PublicKeyHash, Credits: out.Value / VoteValue})
+	}
+	return result, true
+}
+
+// Credits returns the voter whose inputs the transaction spends and the
+// number of credits it gives to the choices of the ballot.
+func (t Transaction) Credits(isChoice func([]byte) bool) ([]byte, int) {
+	if len(t.Inputs) == 0 || t.IsRecovery() {
+		return nil, 0
+	}
+	sender := t.Inputs[0].PublicKeyHash
+	if !t.AreInputsFrom(sender) {
+		return nil, 0
+	}
+	given := 0
+	for _, out := range t.Outputs {
+		if !bytes.Equal(out.PublicKeyHash, sender) && isChoice(out.PublicKeyHash) {
+			given += out.Value
+		}
+	}
+	return sender, given / VoteValue
+}
+
+// CreditCap vetoes transactions which give a voter more than limit credits
+// over the whole election, counting the credits the voter already gave in
+// the blockchain. The alfa node funding and returning stakes isn't a voter.
+func CreditCap(limit int, getUsed GetUsedCreditsFn, isChoice func([]byte) bool, alfaKeyHash []byte) ValidateFn {
+	return func(t Transaction) error {
+		voter, credits := t.Credits(isChoice)
+		if credits == 0 || bytes.Equal(voter, alfaKeyHash) {
+			return nil
+		}
+		used, err := getUsed(voter)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to retrieve used credits of %x", voter)
+		}
+		if used+credits > limit {
+			return errors.Wrapf(ErrCreditsExceeded, "%d of %d credits are used, %d more are given", used, limit, credits)
+		}
+		return nil
+	}
+}
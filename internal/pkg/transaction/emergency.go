@@ -0,0 +1,96 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+type EmergencyAction string
+
+const (
+	Pause    EmergencyAction = "pause"
+	Resume   EmergencyAction = "resume"
+	Rollback EmergencyAction = "rollback"
+)
+
+// Statement is what trustees sign to pause, resume or roll back the
+// election. Election is the hash of the genesis block so a statement can't
+// be replayed in another election and IssuedAt orders statements within the
+// election. Checkpoint is the hash of the block a rollback returns to.
+type Statement struct {
+	Action     EmergencyAction `json:"action"`
+	Reason     string          `json:"reason"`
+	Election   []byte          `json:"election"`
+	IssuedAt   int64           `json:"issuedAt"`
+	Checkpoint []byte          `json:"checkpoint,omitempty"`
+}
+
+func (s Statement) Signable() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+type TrusteeSignature struct {
+	Verifier  []byte `json:"verifier"`
+	Signature []byte `json:"signature"`
+}
+
+// Emergency is a statement signed by a quorum of trustees.
+type Emergency struct {
+	Statement  Statement          `json:"statement"`
+	Signatures []TrusteeSignature `json:"signatures"`
+}
+
+// Sign adds the signature of a trustee to the emergency.
+func (e *Emergency) Sign(trustee wallet.Wallet) error {
+	signature, err := wallet.Sign(e.Statement, trustee.PrivateKey)
+	if err != nil {
+		return errors.Wrap(err, "Failed to sign emergency statement")
+	}
+	e.Signatures = append(e.Signatures, TrusteeSignature{
+		Verifier:  trustee.PublicKey,
+		Signature: signature,
+	})
+	return nil
+}
+
+// Signers returns how many of the trustees signed the statement, every
+// trustee is counted once.
+func (e Emergency) Signers(trustees [][]byte) int {
+	signed := 0
+	for _, t := range trustees {
+		for _, s := range e.Signatures {
+			if bytes.Equal(s.Verifier, t) && len(s.Signature) > 0 && wallet.Verify(e.Statement, s.Signature, s.Verifier) {
+				signed++
+				break
+			}
+		}
+	}
+	return signed
+}
+
+// NewEmergencyTransaction puts the emergency on chain. Like a certification
+// it moves no value.
+func NewEmergencyTransaction(algorithm digest.Algorithm, emergency Emergency) (*Transaction, error) {
+	id, err := hash(algorithm, hashable{Emergency: &emergency})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create id")
+	}
+	return &Transaction{
+		ID:        id,
+		Timestamp: time.Now().Unix(),
+		Emergency: &emergency,
+	}, nil
+}
+
+func (t Transaction) IsEmergency() bool {
+	return t.Emergency != nil
+}
+
+func (t Transaction) IsRollback() bool {
+	return t.Emergency != nil && t.Emergency.Statement.Action == Rollback
+}
@@ -0,0 +1,67 @@
+package transaction
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+// Evidence records on chain that a forger signed two different blocks at
+// the same height. The alfa node keeps the full fraud proof, ProofHash
+// commits to it.
+type Evidence struct {
+	Offender  []byte   `json:"offender"`
+	Height    int      `json:"height"`
+	Blocks    [][]byte `json:"blocks"`
+	ProofHash []byte   `json:"proofHash"`
+	Signer    []byte   `json:"signer"`
+	Signature []byte   `json:"signature,omitempty"`
+}
+
+type signableEvidence struct {
+	Offender  []byte   `json:"offender"`
+	Height    int      `json:"height"`
+	Blocks    [][]byte `json:"blocks"`
+	ProofHash []byte   `json:"proofHash"`
+	Signer    []byte   `json:"signer"`
+}
+
+func (e Evidence) Signable() ([]byte, error) {
+	return json.Marshal(signableEvidence{
+		Offender:  e.Offender,
+		Height:    e.Height,
+		Blocks:    e.Blocks,
+		ProofHash: e.ProofHash,
+		Signer:    e.Signer,
+	})
+}
+
+func (e Evidence) Verified() bool {
+	return len(e.Signer) > 0 && wallet.Verify(e, e.Signature, e.Signer)
+}
+
+// NewEvidenceTransaction signs the evidence and puts it on chain. Like a
+// certification it moves no value.
+func NewEvidenceTransaction(algorithm digest.Algorithm, signer wallet.Signer, evidence Evidence) (*Transaction, error) {
+	signature, err := signer.SignRaw(evidence)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to sign evidence")
+	}
+	evidence.Signature = signature
+	id, err := hash(algorithm, hashable{Evidence: &evidence})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create id")
+	}
+	return &Transaction{
+		ID:        id,
+		Timestamp: time.Now().Unix(),
+		Evidence:  &evidence,
+	}, nil
+}
+
+func (t Transaction) IsEvidence() bool {
+	return t.Evidence != nil
+}
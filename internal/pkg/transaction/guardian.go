@@ -0,0 +1,210 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidRecovery = errors.New("Invalid recovery")
+
+// FindGuardianshipFn finds the guardianship transaction of the voter given
+// by its public key hash.
+type FindGuardianshipFn func(voter []byte) (*Transaction, bool, error)
+
+// Guardianship designates the guardians of a voter. A quorum of guardians
+// can move the unspent vote credit of the voter to a new key. Voter and
+// guardians are public keys, the voter signs the designation.
+type Guardianship struct {
+	Voter     []byte   `json:"voter"`
+	Guardians [][]byte `json:"guardians"`
+	Quorum    int      `json:"quorum"`
+	Signature []byte   `json:"signature,omitempty"`
+}
+
+func (g Guardianship) Signable() ([]byte, error) {
+	return json.Marshal(Guardianship{
+		Voter:     g.Voter,
+		Guardians: g.Guardians,
+		Quorum:    g.Quorum,
+	})
+}
+
+func (g Guardianship) Verified() bool {
+	if g.Quorum < 1 || g.Quorum > len(g.Guardians) {
+		return false
+	}
+	seen := map[string]bool{}
+	for _, guardian := range g.Guardians {
+		if len(guardian) == 0 || bytes.Equal(guardian, g.Voter) || seen[string(guardian)] {
+			return false
+		}
+		seen[string(guardian)] = true
+	}
+	return len(g.Voter) > 0 && wallet.Verify(g, g.Signature, g.Voter)
+}
+
+// VoterHash returns the public key hash of the voter, nil if the voter's
+// key is invalid.
+func (g Guardianship) VoterHash() []byte {
+	hash, err := wallet.HashedPublicKey(g.Voter)
+	if err != nil {
+		return nil
+	}
+	return hash
+}
+
+// NewGuardianshipTransaction puts the designation on chain. Like a
+// certification it moves no value.
+func NewGuardianshipTransaction(algorithm digest.Algorithm, guardianship Guardianship) (*Transaction, error) {
+	id, err := hash(algorithm, hashable{Guardianship: &guardianship})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create id")
+	}
+	return &Transaction{
+		ID:           id,
+		Timestamp:    time.Now().Unix(),
+		Guardianship: &guardianship,
+	}, nil
+}
+
+func (t Transaction) IsGuardianship() bool {
+	return t.Guardianship != nil
+}
+
+// RecoveryStatement is what guardians sign to move the vote credit of the
+// voter to a new key. Guardianship is the id of the designation transaction,
+// voter and new key are public key hashes.
+type RecoveryStatement struct {
+	Guardianship []byte `json:"guardianship"`
+	Voter        []byte `json:"voter"`
+	NewKey       []byte `json:"newKey"`
+}
+
+func (s RecoveryStatement) Signable() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+type GuardianSignature struct {
+	Verifier  []byte `json:"verifier"`
+	Signature []byte `json:"signature"`
+}
+
+// Recovery is a statement signed by a quorum of the voter's guardians.
+type Recovery struct {
+	Statement  RecoveryStatement   `json:"statement"`
+	Signatures []GuardianSignature `json:"signatures"`
+}
+
+// Signers returns how many of the guardians signed the statement, every
+// guardian is counted once.
+func (r Recovery) Signers(guardians [][]byte) int {
+	signed := 0
+	for _, g := range guardians {
+		for _, s := range r.Signatures {
+			if bytes.Equal(s.Verifier, g) && len(s.Signature) > 0 && wallet.Verify(r.Statement, s.Signature, s.Verifier) {
+				signed++
+				break
+			}
+		}
+	}
+	return signed
+}
+
+// NewRecoveryTransaction spends the utxos of the voter giving their whole
+// value to the new key. Inputs are not signed by the voter, the signatures
+// of the guardians authorize them.
+func NewRecoveryTransaction(algorithm digest.Algorithm, recovery Recovery, utxos UTXOs) (*Transaction, error) {
+	if len(utxos) == 0 {
+		return nil, errors.Wrap(ErrInvalidRecovery, "Voter has no vote credit left")
+	}
+	var inputs Inputs
+	for _, u := range utxos {
+		inputs = append(inputs, Input{
+			PublicKeyHash: recovery.Statement.Voter,
+			TransactionID: u.TransactionID,
+			Vout:          u.Vout,
+		})
+	}
+	outputs := Outputs{{
+		Value:         utxos.Sum(),
+		PublicKeyHash: recovery.Statement.NewKey,
+	}}
+	id, err := hash(algorithm, hashable{Inputs: inputs, Outputs: outputs, Recovery: &recovery})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create id")
+	}
+	return &Transaction{
+		ID:        id,
+		Inputs:    inputs,
+		Outputs:   outputs,
+		Timestamp: time.Now().Unix(),
+		Recovery:  &recovery,
+	}, nil
+}
+
+func (t Transaction) IsRecovery() bool {
+	return t.Recovery != nil
+}
+
+// CheckRecovery checks the recovery against the designation it refers to.
+func CheckRecovery(findTransaction FindTransactionFn, recovery Recovery) (*Guardianship, error) {
+	statement := recovery.Statement
+	if len(statement.NewKey) == 0 || bytes.Equal(statement.Voter, statement.NewKey) {
+		return nil, errors.Wrap(ErrInvalidRecovery, "New key has to differ from the voter's key")
+	}
+	designation, found, err := findTransaction(statement.Guardianship)
+	switch {
+	case err != nil:
+		return nil, errors.Wrapf(err, "Failed to find guardianship %x", statement.Guardianship)
+	case !found || !designation.IsGuardianship():
+		return nil, errors.Wrapf(ErrInvalidRecovery, "Guardianship %x is not in the blockchain", statement.Guardianship)
+	}
+	g := designation.Guardianship
+	if !g.Verified() || !bytes.Equal(g.VoterHash(), statement.Voter) {
+		return nil, errors.Wrapf(ErrInvalidRecovery, "Guardianship %x is not the voter's", statement.Guardianship)
+	}
+	if signed := recovery.Signers(g.Guardians); signed < g.Quorum {
+		return nil, errors.Wrapf(ErrInvalidRecovery, "Only %d of %d required guardians signed", signed, g.Quorum)
+	}
+	return g, nil
+}
+
+// VerifyRecoveries accepts a recovery authorized by a quorum of guardians
+// which moves all of its inputs, owned by the voter, to the new key.
+func VerifyRecoveries(verify VerifyTransctionFn, findTransaction FindTransactionFn, getTransactionUTXO GetTransactionUTXO) VerifyTransctionFn {
+	return func(t Transaction) bool {
+		if !t.IsRecovery() {
+			return verify(t)
+		}
+		if _, err := CheckRecovery(findTransaction, *t.Recovery); err != nil {
+			log.Printf("Rejecting recovery %x %s", t.ID, err)
+			return false
+		}
+		statement := t.Recovery.Statement
+		if len(t.Inputs) == 0 || len(t.Outputs) != 1 || !bytes.Equal(t.Outputs[0].PublicKeyHash, statement.NewKey) {
+			return false
+		}
+		sum := 0
+		spent := map[string]bool{}
+		for _, in := range t.Inputs {
+			key := fmt.Sprintf("%x/%d", in.TransactionID, in.Vout)
+			if !bytes.Equal(in.PublicKeyHash, statement.Voter) || spent[key] {
+				return false
+			}
+			spent[key] = true
+			utxo, err := getTransactionUTXO(in.TransactionID, in.Vout)
+			if err != nil || utxo == nil || !bytes.Equal(utxo.PublicKeyHash, statement.Voter) {
+				return false
+			}
+			sum += utxo.Value
+		}
+		return sum == t.Outputs[0].Value
+	}
+}
@@ -1,9 +1,58 @@
 package transaction
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"sort"
+
+	"github.com/nebser/crypto-vote/internal/pkg/codec"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
 )
 
+// Inputs are signed over the canonical binary encoding of their signable, a
+// tag naming the kind of signable followed by its fields. Earlier versions
+// signed the JSON encoding, which legacy returns and which is still
+// accepted.
+type legacySignable interface {
+	wallet.Signable
+	legacy() ([]byte, error)
+}
+
+type legacyForm struct {
+	signable legacySignable
+}
+
+func (l legacyForm) Signable() ([]byte, error) {
+	return l.signable.legacy()
+}
+
+// VerifySignable tells whether the signature is made over either encoding of
+// the signable.
+func VerifySignable(data wallet.Signable, signature, publicKey []byte) bool {
+	if wallet.Verify(data, signature, publicKey) {
+		return true
+	}
+	l, ok := data.(legacySignable)
+	return ok && wallet.Verify(legacyForm{l}, signature, publicKey)
+}
+
+// AcceptLegacy makes the verifier accept signatures made over the JSON
+// encoding of signables by earlier versions.
+func AcceptLegacy(verifier wallet.VerifierFn) wallet.VerifierFn {
+	return func(data wallet.Signable, signature, publicKey string) (bool, error) {
+		ok, err := verifier(data, signature, publicKey)
+		if err != nil || ok {
+			return ok, err
+		}
+		l, legacy := data.(legacySignable)
+		if !legacy {
+			return false, nil
+		}
+		return verifier(legacyForm{l}, signature, publicKey)
+	}
+}
+
 type signable struct {
 	Sender    []byte `json:"sender"`
 	Recipient []byte `json:"recipient"`
@@ -11,5 +60,111 @@ type signable struct {
 }
 
 func (s signable) Signable() ([]byte, error) {
+	return codec.NewWriter().
+		String("vote").
+		Bytes(s.Sender).
+		Bytes(s.Recipient).
+		Int(int64(s.Value)).
+		Result(), nil
+}
+
+func (s signable) legacy() ([]byte, error) {
 	return json.Marshal(s)
 }
+
+// NewVoteSignable is what a voter signs to give one vote to the recipient.
+// It holds the public key hashes, not the addresses the API takes, so the
+// signature verifies in a block the same way it does on the API.
+func NewVoteSignable(from, to []byte) wallet.Signable {
+	return signable{
+		Sender:    from,
+		Recipient: to,
+		Value:     VoteValue,
+	}
+}
+
+// SignVote signs the input of a vote transaction the same way a voter
+// signs its ballot.
+func SignVote(signer wallet.Signer, from, to []byte) ([]byte, error) {
+	return signer.SignRaw(NewVoteSignable(from, to))
+}
+
+type ballotSignable struct {
+	Sender     []byte   `json:"sender"`
+	Recipients [][]byte `json:"recipients"`
+	Value      int      `json:"value"`
+}
+
+func (s ballotSignable) Signable() ([]byte, error) {
+	w := codec.NewWriter().
+		String("ballot").
+		Bytes(s.Sender).
+		Uint(uint64(len(s.Recipients)))
+	for _, r := range s.Recipients {
+		w.Bytes(r)
+	}
+	return w.Int(int64(s.Value)).Result(), nil
+}
+
+func (s ballotSignable) legacy() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+// NewBallotSignable is what a voter signs to give one vote to every
+// recipient at once. Recipients are sorted so that the order of outputs in
+// the transaction doesn't matter.
+func NewBallotSignable(from []byte, to [][]byte, value int) wallet.Signable {
+	recipients := append([][]byte{}, to...)
+	sort.Slice(recipients, func(i, j int) bool {
+		return bytes.Compare(recipients[i], recipients[j]) < 0
+	})
+	return ballotSignable{
+		Sender:     from,
+		Recipients: recipients,
+		Value:      value,
+	}
+}
+
+// Signed names the signable an input is signed over.
+type Signed string
+
+const (
+	SignedVote       Signed = "vote"
+	SignedBallot     Signed = "ballot"
+	SignedAllocation Signed = "allocation"
+)
+
+// SignedAs tells which signable the input at the index is signed over, value
+// being the value of the output it spends. It is empty if the signature
+// verifies as none of them or nothing goes to someone else.
+func (t Transaction) SignedAs(index, value int, verifier wallet.VerifierFn) Signed {
+	input := t.Inputs[index]
+	receiver, found := t.Outputs.Find(func(o Output) bool {
+		return !bytes.Equal(o.PublicKeyHash, input.PublicKeyHash)
+	})
+	if !found {
+		return ""
+	}
+	signature := base64.StdEncoding.EncodeToString(input.Signature)
+	pKey := base64.StdEncoding.EncodeToString(input.Verifier)
+	vote := signable{
+		Recipient: receiver.PublicKeyHash,
+		Sender:    input.PublicKeyHash,
+		Value:     value,
+	}
+	if ok, err := verifier(vote, signature, pKey); err == nil && ok {
+		return SignedVote
+	}
+	ballot := NewBallotSignable(input.PublicKeyHash, t.Recipients(input.PublicKeyHash), value)
+	if ok, err := verifier(ballot, signature, pKey); err == nil && ok {
+		return SignedBallot
+	}
+	allocations, whole := t.Allocations(input.PublicKeyHash)
+	if !whole {
+		return ""
+	}
+	if ok, err := verifier(NewAllocationSignable(input.PublicKeyHash, allocations), signature, pKey); err == nil && ok {
+		return SignedAllocation
+	}
+	return ""
+}
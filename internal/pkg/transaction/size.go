@@ -0,0 +1,297 @@
+package transaction
+
+import (
+	"sort"
+
+	"github.com/nebser/crypto-vote/internal/pkg/chain"
+	"github.com/nebser/crypto-vote/internal/pkg/codec"
+	"github.com/nebser/crypto-vote/internal/pkg/transport"
+)
+
+// PrioritizeFn orders transactions from the highest to the lowest priority.
+type PrioritizeFn func(Transactions) Transactions
+
+// Write appends the canonical binary encoding of the transaction, the same
+// one used for storing it and sending it to nodes which negotiated the
+// binary encoding.
+func (tx Transaction) Write(w *codec.Writer) {
+	w.Bytes(tx.ID)
+	tx.writeContent(w)
+}
+
+// writeContent appends everything but the id, which is the hash of it.
+func (tx Transaction) writeContent(w *codec.Writer) {
+	w.Uint(uint64(len(tx.Inputs)))
+	for _, in := range tx.Inputs {
+		w.Bytes(in.TransactionID).
+			Int(int64(in.Vout)).
+			Bytes(in.PublicKeyHash).
+			Bytes(in.Signature).
+			Bytes(in.Verifier)
+	}
+	w.Uint(uint64(len(tx.Outputs)))
+	for _, out := range tx.Outputs {
+		w.Int(int64(out.Value)).Bytes(out.PublicKeyHash)
+	}
+	w.Int(tx.Timestamp)
+	if tx.Certificate == nil {
+		w.Byte(0)
+	} else {
+		w.Byte(1).
+			String(string(tx.Certificate.Algorithm)).
+			Bytes(tx.Certificate.TransportKey).
+			Bytes(tx.Certificate.Identity).
+			Bytes(tx.Certificate.Signature)
+	}
+	if tx.Evidence == nil {
+		w.Byte(0)
+	} else {
+		w.Byte(1).
+			Bytes(tx.Evidence.Offender).
+			Int(int64(tx.Evidence.Height)).
+			Uint(uint64(len(tx.Evidence.Blocks)))
+		for _, b := range tx.Evidence.Blocks {
+			w.Bytes(b)
+		}
+		w.Bytes(tx.Evidence.ProofHash).
+			Bytes(tx.Evidence.Signer).
+			Bytes(tx.Evidence.Signature)
+	}
+	if tx.Emergency == nil {
+		w.Byte(0)
+	} else {
+		w.Byte(1).
+			String(string(tx.Emergency.Statement.Action)).
+			String(tx.Emergency.Statement.Reason).
+			Bytes(tx.Emergency.Statement.Election).
+			Int(tx.Emergency.Statement.IssuedAt)
+		// Only rollbacks carry a checkpoint, so pauses and resumes keep the
+		// encoding, and the ids, they had before rollbacks existed.
+		if tx.Emergency.Statement.Action == Rollback {
+			w.Bytes(tx.Emergency.Statement.Checkpoint)
+		}
+		w.Uint(uint64(len(tx.Emergency.Signatures)))
+		for _, s := range tx.Emergency.Signatures {
+			w.Bytes(s.Verifier).Bytes(s.Signature)
+		}
+	}
+	if tx.Guardianship == nil {
+		w.Byte(0)
+	} else {
+		w.Byte(1).
+			Bytes(tx.Guardianship.Voter).
+			Uint(uint64(len(tx.Guardianship.Guardians)))
+		for _, g := range tx.Guardianship.Guardians {
+			w.Bytes(g)
+		}
+		w.Int(int64(tx.Guardianship.Quorum)).
+			Bytes(tx.Guardianship.Signature)
+	}
+	if tx.Recovery == nil {
+		w.Byte(0)
+	} else {
+		w.Byte(1).
+			Bytes(tx.Recovery.Statement.Guardianship).
+			Bytes(tx.Recovery.Statement.Voter).
+			Bytes(tx.Recovery.Statement.NewKey).
+			Uint(uint64(len(tx.Recovery.Signatures)))
+		for _, s := range tx.Recovery.Signatures {
+			w.Bytes(s.Verifier).Bytes(s.Signature)
+		}
+	}
+	if tx.Withdrawal == nil {
+		w.Byte(0)
+	} else {
+		w.Byte(1).
+			Bytes(tx.Withdrawal.Party).
+			String(string(tx.Withdrawal.Prior)).
+			String(tx.Withdrawal.Reason).
+			Int(tx.Withdrawal.WithdrawnAt).
+			Bytes(tx.Withdrawal.Signer).
+			Bytes(tx.Withdrawal.Signature)
+	}
+	w.String(string(tx.ChainID))
+	if tx.Sortition == nil {
+		w.Byte(0)
+	} else {
+		w.Byte(1).
+			Uint(tx.Sortition.Round).
+			Bytes(tx.Sortition.Prev).
+			Bytes(tx.Sortition.Proof).
+			Int(int64(tx.Sortition.Weight)).
+			Int(int64(tx.Sortition.Total))
+	}
+}
+
+// Read reads a transaction written by Write, errors are reported by the
+// reader.
+func Read(r *codec.Reader) Transaction {
+	t := Transaction{ID: r.Bytes()}
+	inputs := r.Uint()
+	for i := uint64(0); i < inputs && r.Err() == nil; i++ {
+		t.Inputs = append(t.Inputs, Input{
+			TransactionID: r.Bytes(),
+			Vout:          int(r.Int()),
+			PublicKeyHash: r.Bytes(),
+			Signature:     r.Bytes(),
+			Verifier:      r.Bytes(),
+		})
+	}
+	outputs := r.Uint()
+	for i := uint64(0); i < outputs && r.Err() == nil; i++ {
+		t.Outputs = append(t.Outputs, Output{
+			Value:         int(r.Int()),
+			PublicKeyHash: r.Bytes(),
+		})
+	}
+	t.Timestamp = r.Int()
+	if r.Byte() == 1 {
+		t.Certificate = &transport.Certificate{
+			Algorithm:    transport.Algorithm(r.String()),
+			TransportKey: r.Bytes(),
+			Identity:     r.Bytes(),
+			Signature:    r.Bytes(),
+		}
+	}
+	if r.Byte() == 1 {
+		e := Evidence{
+			Offender: r.Bytes(),
+			Height:   int(r.Int()),
+		}
+		blocks := r.Uint()
+		for i := uint64(0); i < blocks && r.Err() == nil; i++ {
+			e.Blocks = append(e.Blocks, r.Bytes())
+		}
+		e.ProofHash = r.Bytes()
+		e.Signer = r.Bytes()
+		e.Signature = r.Bytes()
+		t.Evidence = &e
+	}
+	if r.Byte() == 1 {
+		e := Emergency{
+			Statement: Statement{
+				Action:   EmergencyAction(r.String()),
+				Reason:   r.String(),
+				Election: r.Bytes(),
+				IssuedAt: r.Int(),
+			},
+		}
+		if e.Statement.Action == Rollback {
+			e.Statement.Checkpoint = r.Bytes()
+		}
+		signatures := r.Uint()
+		for i := uint64(0); i < signatures && r.Err() == nil; i++ {
+			e.Signatures = append(e.Signatures, TrusteeSignature{
+				Verifier:  r.Bytes(),
+				Signature: r.Bytes(),
+			})
+		}
+		t.Emergency = &e
+	}
+	if r.Byte() == 1 {
+		g := Guardianship{Voter: r.Bytes()}
+		guardians := r.Uint()
+		for i := uint64(0); i < guardians && r.Err() == nil; i++ {
+			g.Guardians = append(g.Guardians, r.Bytes())
+		}
+		g.Quorum = int(r.Int())
+		g.Signature = r.Bytes()
+		t.Guardianship = &g
+	}
+	if r.Byte() == 1 {
+		recovery := Recovery{
+			Statement: RecoveryStatement{
+				Guardianship: r.Bytes(),
+				Voter:        r.Bytes(),
+				NewKey:       r.Bytes(),
+			},
+		}
+		signatures := r.Uint()
+		for i := uint64(0); i < signatures && r.Err() == nil; i++ {
+			recovery.Signatures = append(recovery.Signatures, GuardianSignature{
+				Verifier:  r.Bytes(),
+				Signature: r.Bytes(),
+			})
+		}
+		t.Recovery = &recovery
+	}
+	if r.Byte() == 1 {
+		t.Withdrawal = &Withdrawal{
+			Party:       r.Bytes(),
+			Prior:       PriorVotes(r.String()),
+			Reason:      r.String(),
+			WithdrawnAt: r.Int(),
+			Signer:      r.Bytes(),
+			Signature:   r.Bytes(),
+		}
+	}
+	t.ChainID = chain.ID(r.String())
+	if r.Byte() == 1 {
+		t.Sortition = &Sortition{
+			Round:  r.Uint(),
+			Prev:   r.Bytes(),
+			Proof:  r.Bytes(),
+			Weight: int(r.Int()),
+			Total:  int(r.Int()),
+		}
+	}
+	return t
+}
+
+// Size returns the serialized size of the transaction in bytes.
+func (tx Transaction) Size() int {
+	w := codec.NewWriter()
+	tx.Write(w)
+	return len(w.Result())
+}
+
+func (txs Transactions) Size() (size int) {
+	for _, tx := range txs {
+		size += tx.Size()
+	}
+	return
+}
+
+// Prioritize orders transactions so that lower is never ahead of a
+// transaction it has to give way to.
+func Prioritize(lower func(a, b Transaction) bool) PrioritizeFn {
+	return func(txs Transactions) Transactions {
+		result := append(Transactions{}, txs...)
+		sort.SliceStable(result, func(i, j int) bool {
+			return lower(result[j], result[i])
+		})
+		return result
+	}
+}
+
+// Pending describes a transaction waiting in the mempool.
+type Pending struct {
+	ID        []byte `json:"id"`
+	Size      int    `json:"size"`
+	Inputs    int    `json:"inputs"`
+	Outputs   int    `json:"outputs"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type Mempool struct {
+	Count        int       `json:"count"`
+	Bytes        int       `json:"bytes"`
+	Transactions []Pending `json:"transactions"`
+}
+
+func NewMempool(txs Transactions) Mempool {
+	result := Mempool{Transactions: []Pending{}}
+	for _, tx := range txs {
+		p := Pending{
+			ID:        tx.ID,
+			Size:      tx.Size(),
+			Inputs:    len(tx.Inputs),
+			Outputs:   len(tx.Outputs),
+			Timestamp: tx.Timestamp,
+		}
+		result.Count++
+		result.Bytes += p.Size
+		result.Transactions = append(result.Transactions, p)
+	}
+	return result
+}
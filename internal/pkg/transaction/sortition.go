@@ -0,0 +1,42 @@
+package transaction
+
+import (
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/pkg/errors"
+)
+
+// Sortition proves that the forger of a block was eligible to forge in a
+// sortition round. Proof is the VRF proof over the alpha of the round, whose
+// seed follows from Prev, the tip when the round started. Weight is the
+// weight of the forger out of the Total weight of the round.
+type Sortition struct {
+	Round  uint64 `json:"round"`
+	Prev   []byte `json:"prev"`
+	Proof  []byte `json:"proof"`
+	Weight int    `json:"weight"`
+	Total  int    `json:"total"`
+}
+
+// WithSortition attaches the proof of eligibility to a stake transaction.
+// The proof is part of the transaction id, so it can't be stripped from a
+// forged block.
+func (t Transaction) WithSortition(algorithm digest.Algorithm, s Sortition) (*Transaction, error) {
+	t.Sortition = &s
+	txID, err := hash(algorithm, hashable{
+		Inputs:       t.Inputs,
+		Outputs:      t.Outputs,
+		Certificate:  t.Certificate,
+		Evidence:     t.Evidence,
+		Emergency:    t.Emergency,
+		Guardianship: t.Guardianship,
+		Recovery:     t.Recovery,
+		Withdrawal:   t.Withdrawal,
+		ChainID:      t.ChainID,
+		Sortition:    &s,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create id")
+	}
+	t.ID = txID
+	return &t, nil
+}
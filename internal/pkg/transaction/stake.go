@@ -0,0 +1,63 @@
+package transaction
+
+import (
+	"bytes"
+
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+)
+
+type FindTransactionFn func(id []byte) (*Transaction, bool, error)
+
+// StakeOutput returns the index of the output staked to the alfa node if the
+// transaction is a stake transaction of another node.
+func StakeOutput(t Transaction, alfaKeyHash []byte) (int, bool) {
+	if len(t.Inputs) == 0 || len(t.Outputs) > 2 {
+		return 0, false
+	}
+	for _, in := range t.Inputs {
+		if bytes.Equal(in.PublicKeyHash, alfaKeyHash) {
+			return 0, false
+		}
+	}
+	return t.Outputs.FindIndex(func(o Output) bool {
+		return bytes.Equal(o.PublicKeyHash, alfaKeyHash)
+	})
+}
+
+// VerifyStakeReturns additionally requires a transaction of the alfa node
+// spending a stake to return the whole stake to the node that staked it, so
+// alfa can't keep or redirect a stake it returns. Evidence of a forger's
+// misbehaviour and withdrawals of parties are accepted only from the alfa
+// node.
+func VerifyStakeReturns(verify VerifyTransctionFn, alfaKeyHash []byte, findTransaction FindTransactionFn) VerifyTransctionFn {
+	isReturnStakeTransaction := IsReturnStakeTransaction(alfaKeyHash)
+	return func(t Transaction) bool {
+		if t.IsEvidence() {
+			signer, err := wallet.HashedPublicKey(t.Evidence.Signer)
+			return err == nil && bytes.Equal(signer, alfaKeyHash) && verify(t)
+		}
+		if t.IsWithdrawal() {
+			signer, err := wallet.HashedPublicKey(t.Withdrawal.Signer)
+			return err == nil && bytes.Equal(signer, alfaKeyHash) && verify(t)
+		}
+		if !isReturnStakeTransaction(t) {
+			return verify(t)
+		}
+		in := t.Inputs[0]
+		spent, found, err := findTransaction(in.TransactionID)
+		if err != nil || !found {
+			return false
+		}
+		if vout, ok := StakeOutput(*spent, alfaKeyHash); !ok || vout != in.Vout {
+			return verify(t)
+		}
+		if len(t.Outputs) != 1 {
+			return false
+		}
+		out := t.Outputs[0]
+		if !bytes.Equal(out.PublicKeyHash, spent.Inputs[0].PublicKeyHash) || out.Value != spent.Outputs[in.Vout].Value {
+			return false
+		}
+		return verify(t)
+	}
+}
@@ -2,20 +2,26 @@ package transaction
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"log"
 	"strings"
 	"time"
 
+	"github.com/nebser/crypto-vote/internal/pkg/chain"
+	"github.com/nebser/crypto-vote/internal/pkg/codec"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/redact"
+	"github.com/nebser/crypto-vote/internal/pkg/transport"
 	"github.com/nebser/crypto-vote/internal/pkg/wallet"
 	"github.com/pkg/errors"
 )
 
 type CastVote func(from, to, signature, verifier []byte) (Transaction, error)
 
+// CastBallotFn spends the voter's utxo of value giving a vote to every
+// recipient.
+type CastBallotFn func(from []byte, to [][]byte, value int, signature, verifier []byte) (Transaction, error)
+
 type SaveTransaction func(Transaction) error
 
 type GetTransactionsFn func() (Transactions, error)
@@ -26,6 +32,13 @@ type NewStakeTransactionFn func() (*Transaction, error)
 
 type VerifyTransctionFn func(Transaction) bool
 
+// ValidateFn vetoes a transaction by returning an error.
+type ValidateFn func(Transaction) error
+
+// ReleaseFn lets go of a transaction a ValidateFn admitted but which was
+// not stored in the end.
+type ReleaseFn func(Transaction)
+
 type IsStakeTransactionFn func(Transaction) bool
 
 type IsReturnStakeTransactionFn func(Transaction) bool
@@ -35,10 +48,18 @@ type NewReturnStakeTransactionFn func(Transaction) (*Transaction, error)
 const VoteValue = 10
 
 type Transaction struct {
-	ID        []byte  `json:"id"`
-	Inputs    Inputs  `json:"inputs"`
-	Outputs   Outputs `json:"outputs"`
-	Timestamp int64   `json:"timestamp"`
+	ID           []byte                 `json:"id"`
+	Inputs       Inputs                 `json:"inputs"`
+	Outputs      Outputs                `json:"outputs"`
+	Timestamp    int64                  `json:"timestamp"`
+	Certificate  *transport.Certificate `json:"certificate,omitempty"`
+	Evidence     *Evidence              `json:"evidence,omitempty"`
+	Emergency    *Emergency             `json:"emergency,omitempty"`
+	Guardianship *Guardianship          `json:"guardianship,omitempty"`
+	Recovery     *Recovery              `json:"recovery,omitempty"`
+	Withdrawal   *Withdrawal            `json:"withdrawal,omitempty"`
+	ChainID      chain.ID               `json:"chainId,omitempty"`
+	Sortition    *Sortition             `json:"sortition,omitempty"`
 }
 
 var ErrInsufficientVotes = errors.New("Not enough votes available")
@@ -48,42 +69,62 @@ func (tx Transaction) String() string {
 	builder.WriteString(fmt.Sprintf("ID: %x\n", tx.ID))
 	builder.WriteString("Inputs:\n")
 	for _, in := range tx.Inputs {
-		builder.WriteString(fmt.Sprintf("\tFrom: %x\n", in.PublicKeyHash))
-		builder.WriteString(fmt.Sprintf("\tSignature: %x\n", in.Signature))
+		builder.WriteString(fmt.Sprintf("\tFrom: %s\n", redact.Key(in.PublicKeyHash)))
+		builder.WriteString(fmt.Sprintf("\tSignature: %s\n", redact.Signature(in.Signature)))
 	}
 	builder.WriteString("Outputs:\n")
 	for _, out := range tx.Outputs {
-		builder.WriteString(fmt.Sprintf("\tTo: %x\n", out.PublicKeyHash))
+		builder.WriteString(fmt.Sprintf("\tTo: %s\n", redact.Key(out.PublicKeyHash)))
 		builder.WriteString(fmt.Sprintf("\tValue: %d\n", out.Value))
 	}
 	return builder.String()
 }
 
 type hashable struct {
-	Inputs    Inputs  `json:"inputs"`
-	Outputs   Outputs `json:"outputs"`
-	Timestamp int64   `json:"timestamp"`
+	Inputs       Inputs
+	Outputs      Outputs
+	Timestamp    int64
+	Certificate  *transport.Certificate
+	Evidence     *Evidence
+	Emergency    *Emergency
+	Guardianship *Guardianship
+	Recovery     *Recovery
+	Withdrawal   *Withdrawal
+	ChainID      chain.ID
+	Sortition    *Sortition
 }
 
-func newID(inputs Inputs, outputs Outputs) ([]byte, error) {
+func newID(algorithm digest.Algorithm, inputs Inputs, outputs Outputs) ([]byte, error) {
 	hashable := hashable{
 		Inputs:  inputs,
 		Outputs: outputs,
 	}
-	return hash(hashable)
+	return hash(algorithm, hashable)
 }
 
-func hash(data interface{}) ([]byte, error) {
-	raw, err := json.Marshal(data)
-	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to serialize data %#v", data)
-	}
-	hash := sha256.Sum256(raw)
-	return hash[:], nil
+// hash returns the hash of the canonical binary encoding of the data with
+// the algorithm of the blockchain, so ids don't depend on how an
+// implementation orders JSON fields.
+func hash(algorithm digest.Algorithm, data hashable) ([]byte, error) {
+	w := codec.NewWriter()
+	Transaction{
+		Inputs:       data.Inputs,
+		Outputs:      data.Outputs,
+		Timestamp:    data.Timestamp,
+		Certificate:  data.Certificate,
+		Evidence:     data.Evidence,
+		Emergency:    data.Emergency,
+		Guardianship: data.Guardianship,
+		Recovery:     data.Recovery,
+		Withdrawal:   data.Withdrawal,
+		ChainID:      data.ChainID,
+		Sortition:    data.Sortition,
+	}.writeContent(w)
+	return algorithm.Sum(w.Result()), nil
 }
 
-func NewTransaction(inputs Inputs, outputs Outputs) (*Transaction, error) {
-	id, err := newID(inputs, outputs)
+func NewTransaction(algorithm digest.Algorithm, inputs Inputs, outputs Outputs) (*Transaction, error) {
+	id, err := newID(algorithm, inputs, outputs)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to create id")
 	}
@@ -95,7 +136,83 @@ func NewTransaction(inputs Inputs, outputs Outputs) (*Transaction, error) {
 	}, nil
 }
 
-func NewStakeTransaction(getUTXOs GetUTXOsByPublicKeyFn, signer wallet.Signer, stakeCreator wallet.Wallet, stakeholder []byte) NewStakeTransactionFn {
+// NewCertificationTransaction puts the transport key certificate on chain.
+// It moves no value so it has neither inputs nor outputs.
+func NewCertificationTransaction(algorithm digest.Algorithm, certificate transport.Certificate) (*Transaction, error) {
+	id, err := hash(algorithm, hashable{Certificate: &certificate})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create id")
+	}
+	return &Transaction{
+		ID:          id,
+		Timestamp:   time.Now().Unix(),
+		Certificate: &certificate,
+	}, nil
+}
+
+// OnChain stamps the transaction with the id of its chain. The chain id is
+// part of the transaction id, so the same transaction gets a different id on
+// every network and can't spend outputs of another one.
+func (t Transaction) OnChain(algorithm digest.Algorithm, id chain.ID) (*Transaction, error) {
+	t.ChainID = id
+	txID, err := hash(algorithm, hashable{
+		Inputs:       t.Inputs,
+		Outputs:      t.Outputs,
+		Certificate:  t.Certificate,
+		Evidence:     t.Evidence,
+		Emergency:    t.Emergency,
+		Guardianship: t.Guardianship,
+		Recovery:     t.Recovery,
+		Withdrawal:   t.Withdrawal,
+		ChainID:      id,
+		Sortition:    t.Sortition,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create id")
+	}
+	t.ID = txID
+	return &t, nil
+}
+
+// StakeOnChain stamps the stake transactions of newStake with the chain id.
+func StakeOnChain(getAlgorithm digest.GetAlgorithmFn, getChainID chain.GetIDFn, newStake NewStakeTransactionFn) NewStakeTransactionFn {
+	return func() (*Transaction, error) {
+		t, err := newStake()
+		if err != nil {
+			return nil, err
+		}
+		return t.OnChain(getAlgorithm(), getChainID())
+	}
+}
+
+// ReturnStakeOnChain stamps the return stake transactions of newReturnStake
+// with the chain id.
+func ReturnStakeOnChain(getAlgorithm digest.GetAlgorithmFn, getChainID chain.GetIDFn, newReturnStake NewReturnStakeTransactionFn) NewReturnStakeTransactionFn {
+	return func(stake Transaction) (*Transaction, error) {
+		t, err := newReturnStake(stake)
+		if err != nil {
+			return nil, err
+		}
+		return t.OnChain(getAlgorithm(), getChainID())
+	}
+}
+
+// VerifyChain rejects transactions stamped with the id of another chain.
+func VerifyChain(getChainID chain.GetIDFn, verify VerifyTransctionFn) VerifyTransctionFn {
+	return func(t Transaction) bool {
+		if !getChainID().Accepts(t.ChainID) {
+			log.Printf("Transaction %x belongs to chain %s", t.ID, t.ChainID)
+			return false
+		}
+		return verify(t)
+	}
+}
+
+func (t Transaction) IsCertification() bool {
+	return t.Certificate != nil
+}
+
+func NewStakeTransaction(getAlgorithm digest.GetAlgorithmFn, getUTXOs GetUTXOsByPublicKeyFn, signer wallet.Signer, stakeCreator wallet.Wallet, stakeholder []byte) NewStakeTransactionFn {
 	return func() (*Transaction, error) {
 		utxos, err := getUTXOs(stakeCreator.PublicKeyHash())
 		if err != nil {
@@ -141,11 +258,55 @@ func NewStakeTransaction(getUTXOs GetUTXOsByPublicKeyFn, signer wallet.Signer, s
 				PublicKeyHash: stakeCreator.PublicKeyHash(),
 			})
 		}
-		return NewTransaction(inputs, outputs)
+		return NewTransaction(getAlgorithm(), inputs, outputs)
 	}
 }
 
-func NewReturnStakeTransaction(w wallet.Wallet) NewReturnStakeTransactionFn {
+// NewFundingTransaction gives value to every recipient out of the funder's
+// utxos and returns the rest to the funder.
+func NewFundingTransaction(algorithm digest.Algorithm, signer wallet.Signer, funder wallet.Wallet, utxos UTXOs, recipients [][]byte, value int) (*Transaction, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("No recipients to fund")
+	}
+	if utxos.Sum() < value*len(recipients) {
+		return nil, ErrInvalidTxAmount
+	}
+	outputs := Outputs{}
+	for _, r := range recipients {
+		outputs = append(outputs, Output{
+			Value:         value,
+			PublicKeyHash: r,
+		})
+	}
+	if change := utxos.Sum() - value*len(recipients); change > 0 {
+		outputs = append(outputs, Output{
+			Value:         change,
+			PublicKeyHash: funder.PublicKeyHash(),
+		})
+	}
+	inputs := Inputs{}
+	for _, utxo := range utxos {
+		signable := signable{
+			Recipient: recipients[0],
+			Sender:    funder.PublicKeyHash(),
+			Value:     utxo.Value,
+		}
+		signature, err := signer.SignRaw(signable)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to sign %#v", signable)
+		}
+		inputs = append(inputs, Input{
+			PublicKeyHash: funder.PublicKeyHash(),
+			Signature:     signature,
+			TransactionID: utxo.TransactionID,
+			Vout:          utxo.Vout,
+			Verifier:      funder.PublicKey,
+		})
+	}
+	return NewTransaction(algorithm, inputs, outputs)
+}
+
+func NewReturnStakeTransaction(getAlgorithm digest.GetAlgorithmFn, signer wallet.Signer, w wallet.Wallet) NewReturnStakeTransactionFn {
 	return func(transaction Transaction) (*Transaction, error) {
 		pKeyHash := w.PublicKeyHash()
 		index, found := transaction.Outputs.FindIndex(func(element Output) bool {
@@ -159,7 +320,7 @@ func NewReturnStakeTransaction(w wallet.Wallet) NewReturnStakeTransactionFn {
 			Sender:    pKeyHash,
 			Value:     transaction.Outputs[index].Value,
 		}
-		signature, err := wallet.Sign(signable, w.PrivateKey)
+		signature, err := signer.SignRaw(signable)
 		if err != nil {
 			return nil, errors.Wrapf(err, "Failed to sign return stake transaction")
 		}
@@ -178,18 +339,18 @@ func NewReturnStakeTransaction(w wallet.Wallet) NewReturnStakeTransactionFn {
 				PublicKeyHash: transaction.Inputs[0].PublicKeyHash,
 			},
 		}
-		return NewTransaction(inputs, outputs)
+		return NewTransaction(getAlgorithm(), inputs, outputs)
 	}
 }
 
-func NewBaseTransaction(creator wallet.Wallet, recipientAddress string, value int) (*Transaction, error) {
+func NewBaseTransaction(algorithm digest.Algorithm, signer wallet.Signer, creator wallet.Wallet, recipientAddress string, value int) (*Transaction, error) {
 	recipientKeyHash := wallet.ExtractPublicKeyHash(recipientAddress)
 	signable := signable{
 		Recipient: recipientKeyHash,
 		Sender:    creator.PublicKeyHash(),
 		Value:     VoteValue,
 	}
-	signature, err := wallet.Sign(signable, creator.PrivateKey)
+	signature, err := signer.SignRaw(signable)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to sign base transaction")
 	}
@@ -207,7 +368,7 @@ func NewBaseTransaction(creator wallet.Wallet, recipientAddress string, value in
 			Verifier:      creator.PublicKey,
 		},
 	}
-	id, err := newID(inputs, outputs)
+	id, err := newID(algorithm, inputs, outputs)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to create transaction id")
 	}
@@ -230,6 +391,18 @@ func (t Transaction) UTXOs() (utxos []UTXO) {
 	return
 }
 
+// Recipients returns the public key hashes of outputs not going back to
+// sender.
+func (t Transaction) Recipients(sender []byte) [][]byte {
+	var result [][]byte
+	for _, out := range t.Outputs {
+		if !bytes.Equal(out.PublicKeyHash, sender) {
+			result = append(result, out.PublicKeyHash)
+		}
+	}
+	return result
+}
+
 func (t Transaction) AreInputsFrom(pkeyHash []byte) bool {
 	_, found := t.Inputs.Find(func(input Input) bool {
 		return bytes.Compare(input.PublicKeyHash, pkeyHash) != 0
@@ -238,26 +411,36 @@ func (t Transaction) AreInputsFrom(pkeyHash []byte) bool {
 }
 
 func VerifyTransactions(getTransactionUTXO GetTransactionUTXO, verifier wallet.VerifierFn) VerifyTransctionFn {
+	verifier = AcceptLegacy(verifier)
 	return func(transaction Transaction) bool {
-		for _, input := range transaction.Inputs {
-			receiver, found := transaction.Outputs.Find(func(o Output) bool {
-				return bytes.Compare(o.PublicKeyHash, input.PublicKeyHash) != 0
-			})
-			if !found {
-				return false
-			}
+		if transaction.IsCertification() {
+			return len(transaction.Inputs) == 0 && len(transaction.Outputs) == 0 && transaction.Certificate.Verified()
+		}
+		if transaction.IsEvidence() {
+			return len(transaction.Inputs) == 0 && len(transaction.Outputs) == 0 && transaction.Evidence.Verified()
+		}
+		if transaction.IsEmergency() {
+			// Only trustees can tell whether an emergency is valid, see
+			// emergency.VerifyTransactions.
+			return false
+		}
+		if transaction.IsGuardianship() {
+			return len(transaction.Inputs) == 0 && len(transaction.Outputs) == 0 && transaction.Guardianship.Verified()
+		}
+		if transaction.IsWithdrawal() {
+			return len(transaction.Inputs) == 0 && len(transaction.Outputs) == 0 && transaction.Withdrawal.Verified()
+		}
+		if transaction.IsRecovery() {
+			// Recoveries are signed by guardians instead of the owner of
+			// the inputs, see VerifyRecoveries.
+			return false
+		}
+		for i, input := range transaction.Inputs {
 			utxo, err := getTransactionUTXO(input.TransactionID, input.Vout)
 			if err != nil || utxo == nil {
 				return false
 			}
-			signable := signable{
-				Recipient: receiver.PublicKeyHash,
-				Sender:    input.PublicKeyHash,
-				Value:     utxo.Value,
-			}
-			signature := base64.StdEncoding.EncodeToString(input.Signature)
-			pKey := base64.StdEncoding.EncodeToString(input.Verifier)
-			if ok, err := verifier(signable, signature, pKey); err != nil || !ok {
+			if transaction.SignedAs(i, utxo.Value, verifier) == "" {
 				return false
 			}
 		}
@@ -265,6 +448,42 @@ func VerifyTransactions(getTransactionUTXO GetTransactionUTXO, verifier wallet.V
 	}
 }
 
+// ValidateAll vetoes transactions vetoed by any of validators.
+func ValidateAll(validators ...ValidateFn) ValidateFn {
+	return func(t Transaction) error {
+		for _, validate := range validators {
+			if err := validate(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Validated additionally requires transactions to pass validate.
+func Validated(validate ValidateFn, verify VerifyTransctionFn) VerifyTransctionFn {
+	return func(t Transaction) bool {
+		if !verify(t) {
+			return false
+		}
+		if err := validate(t); err != nil {
+			log.Println(err)
+			return false
+		}
+		return true
+	}
+}
+
+// SaveValidated keeps transactions vetoed by validate out of the mempool.
+func SaveValidated(validate ValidateFn, save SaveTransaction) SaveTransaction {
+	return func(t Transaction) error {
+		if err := validate(t); err != nil {
+			return err
+		}
+		return save(t)
+	}
+}
+
 func IsStakeTransaction(alfaKeyHash []byte) IsStakeTransactionFn {
 	return func(transaction Transaction) bool {
 		if len(transaction.Outputs) > 2 {
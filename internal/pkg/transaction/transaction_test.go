@@ -0,0 +1,34 @@
+package transaction
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nebser/crypto-vote/internal/pkg/codec"
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+)
+
+func TestIDsFollowAlgorithm(t *testing.T) {
+	outputs := Outputs{{Value: VoteValue, PublicKeyHash: []byte("recipient")}}
+	for _, a := range digest.Algorithms {
+		tx, err := NewTransaction(a, nil, outputs)
+		if err != nil {
+			t.Fatalf("Failed to create transaction %s", err)
+		}
+		// The timestamp is set after the id.
+		w := codec.NewWriter()
+		Transaction{Outputs: outputs}.writeContent(w)
+		if expected := a.Sum(w.Result()); !bytes.Equal(tx.ID, expected) {
+			t.Errorf("Expected %s id %x, got %x", a, expected, tx.ID)
+		}
+		stamped, err := tx.OnChain(a, "0a1b2c3d4e5f6071")
+		if err != nil {
+			t.Fatalf("Failed to stamp transaction %s", err)
+		}
+		w = codec.NewWriter()
+		Transaction{Outputs: outputs, ChainID: stamped.ChainID}.writeContent(w)
+		if expected := a.Sum(w.Result()); !bytes.Equal(stamped.ID, expected) {
+			t.Errorf("Expected %s id %x of stamped transaction, got %x", a, expected, stamped.ID)
+		}
+	}
+}
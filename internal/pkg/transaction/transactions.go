@@ -8,6 +8,10 @@ import (
 
 type Transactions []Transaction
 
+// Hash is the hash blocks committed to their transactions with before they
+// had Merkle roots. It stays SHA-256 whatever the algorithm of the
+// blockchain, since only blocks of that version, which predate the choice,
+// are verified with it.
 func (txs Transactions) Hash() []byte {
 	var result []byte
 	for _, tx := range txs {
@@ -17,6 +21,14 @@ func (txs Transactions) Hash() []byte {
 	return hash[:]
 }
 
+func (txs Transactions) IDs() [][]byte {
+	result := make([][]byte, len(txs))
+	for i, tx := range txs {
+		result[i] = tx.ID
+	}
+	return result
+}
+
 func (txs Transactions) String() string {
 	builder := strings.Builder{}
 	builder.WriteString("-----START TRANSACTIONS-----\n")
@@ -58,3 +70,9 @@ func (txs Transactions) Less(i, j int) bool {
 func (txs Transactions) Swap(i, j int) {
 	txs[i], txs[j] = txs[j], txs[i]
 }
+
+type OrderTransactionsFn func(prev []byte, txs Transactions) Transactions
+
+func KeepOrder(_ []byte, txs Transactions) Transactions {
+	return txs
+}
@@ -40,4 +40,12 @@ type SaveUTXO func(UTXO) error
 
 type GetUTXOsByPublicKeyFn func(publicKeyHash []byte) (UTXOs, error)
 
+// GetUTXOsFn returns the whole utxo set.
+type GetUTXOsFn func() (UTXOs, error)
+
 type GetTransactionUTXO func(id []byte, vout int) (*UTXO, error)
+
+// GetUTXOsByPublicKeyAtFn returns the unspent outputs of the public key hash
+// right after the block at the height was added, together with the hash of
+// that block. Height 0 is the state before the genesis block.
+type GetUTXOsByPublicKeyAtFn func(publicKeyHash []byte, height int) (UTXOs, []byte, error)
@@ -0,0 +1,89 @@
+package transaction
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/digest"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+// PriorVotes tells what happens to the votes a party got before it withdrew.
+type PriorVotes string
+
+const (
+	// VoidPriorVotes leaves the votes out of the tally.
+	VoidPriorVotes PriorVotes = "void"
+	// KeepPriorVotes counts the votes as they were cast.
+	KeepPriorVotes PriorVotes = "keep"
+)
+
+func ParsePriorVotes(raw string) (PriorVotes, error) {
+	switch p := PriorVotes(raw); p {
+	case VoidPriorVotes, KeepPriorVotes:
+		return p, nil
+	default:
+		return "", errors.Errorf("Unknown rule for prior votes %s", raw)
+	}
+}
+
+// Withdrawal records on chain that a party left the election. It is signed
+// by the alfa node on behalf of the election administrator, Party is the
+// public key hash of the party.
+type Withdrawal struct {
+	Party       []byte     `json:"party"`
+	Prior       PriorVotes `json:"prior"`
+	Reason      string     `json:"reason,omitempty"`
+	WithdrawnAt int64      `json:"withdrawnAt"`
+	Signer      []byte     `json:"signer"`
+	Signature   []byte     `json:"signature,omitempty"`
+}
+
+type signableWithdrawal struct {
+	Party       []byte     `json:"party"`
+	Prior       PriorVotes `json:"prior"`
+	Reason      string     `json:"reason,omitempty"`
+	WithdrawnAt int64      `json:"withdrawnAt"`
+	Signer      []byte     `json:"signer"`
+}
+
+func (w Withdrawal) Signable() ([]byte, error) {
+	return json.Marshal(signableWithdrawal{
+		Party:       w.Party,
+		Prior:       w.Prior,
+		Reason:      w.Reason,
+		WithdrawnAt: w.WithdrawnAt,
+		Signer:      w.Signer,
+	})
+}
+
+func (w Withdrawal) Verified() bool {
+	if _, err := ParsePriorVotes(string(w.Prior)); err != nil || len(w.Party) == 0 {
+		return false
+	}
+	return len(w.Signer) > 0 && wallet.Verify(w, w.Signature, w.Signer)
+}
+
+// NewWithdrawalTransaction signs the withdrawal and puts it on chain. Like
+// evidence it moves no value.
+func NewWithdrawalTransaction(algorithm digest.Algorithm, signer wallet.Signer, withdrawal Withdrawal) (*Transaction, error) {
+	signature, err := signer.SignRaw(withdrawal)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to sign withdrawal")
+	}
+	withdrawal.Signature = signature
+	id, err := hash(algorithm, hashable{Withdrawal: &withdrawal})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create id")
+	}
+	return &Transaction{
+		ID:         id,
+		Timestamp:  time.Now().Unix(),
+		Withdrawal: &withdrawal,
+	}, nil
+}
+
+func (t Transaction) IsWithdrawal() bool {
+	return t.Withdrawal != nil
+}
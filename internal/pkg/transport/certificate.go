@@ -0,0 +1,168 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+// Certificate binds a transport key to the chain identity that signed it.
+type Certificate struct {
+	Algorithm    Algorithm `json:"algorithm"`
+	TransportKey []byte    `json:"transportKey"`
+	Identity     []byte    `json:"identity"`
+	Signature    []byte    `json:"signature"`
+}
+
+type signableCertificate struct {
+	Algorithm    Algorithm `json:"algorithm"`
+	TransportKey []byte    `json:"transportKey"`
+	Identity     []byte    `json:"identity"`
+}
+
+func (c Certificate) Signable() ([]byte, error) {
+	return json.Marshal(signableCertificate{
+		Algorithm:    c.Algorithm,
+		TransportKey: c.TransportKey,
+		Identity:     c.Identity,
+	})
+}
+
+// NewCertificate certifies the transport key with the chain key of identity
+// which signer holds.
+func NewCertificate(key Key, signer wallet.Signer, identity []byte) (*Certificate, error) {
+	c := Certificate{
+		Algorithm:    key.Algorithm,
+		TransportKey: key.PublicKey,
+		Identity:     identity,
+	}
+	signature, err := signer.SignRaw(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to sign transport key certificate")
+	}
+	c.Signature = signature
+	return &c, nil
+}
+
+func (c Certificate) Verified() bool {
+	if _, _, ok := ParseVerifier(c.Verifier()); !ok {
+		return false
+	}
+	return wallet.Verify(c, c.Signature, c.Identity)
+}
+
+func (c Certificate) Verifier() string {
+	return EncodeVerifier(c.Algorithm, c.TransportKey)
+}
+
+// FindCertificateFn returns the certificate currently in force for the
+// transport verifier or nil if there is none.
+type FindCertificateFn func(verifier string) (*Certificate, error)
+
+// VerifySignature accepts signatures made either by a chain key or by a
+// transport key which has been certified on chain.
+func VerifySignature(findCertificate FindCertificateFn) wallet.VerifierFn {
+	return func(data wallet.Signable, signature, verifier string) (bool, error) {
+		algorithm, publicKey, ok := ParseVerifier(verifier)
+		if !ok {
+			return wallet.VerifySignature(data, signature, verifier)
+		}
+		rawSignature, err := base64.StdEncoding.DecodeString(signature)
+		if err != nil {
+			return false, errors.Wrapf(err, "Failed to decode signature %s", signature)
+		}
+		certificate, err := findCertificate(verifier)
+		switch {
+		case err != nil:
+			return false, errors.Wrapf(err, "Failed to find certificate of %s", verifier)
+		case certificate == nil:
+			return false, nil
+		default:
+			return Verify(data, rawSignature, algorithm, publicKey), nil
+		}
+	}
+}
+
+// Identity returns the chain public key behind the verifier.
+func Identity(findCertificate FindCertificateFn, verifier string) ([]byte, error) {
+	if !IsTransportVerifier(verifier) {
+		return base64.StdEncoding.DecodeString(verifier)
+	}
+	certificate, err := findCertificate(verifier)
+	switch {
+	case err != nil:
+		return nil, errors.Wrapf(err, "Failed to find certificate of %s", verifier)
+	case certificate == nil:
+		return nil, errors.Errorf("Transport key %s is not certified", verifier)
+	default:
+		return certificate.Identity, nil
+	}
+}
+
+const certificationCheckInterval = 10 * time.Second
+
+// CertifiedSigner signs with the chain key until the certificate of the
+// transport key shows up on chain and with the transport key afterwards.
+type CertifiedSigner struct {
+	chain           wallet.Signer
+	identity        []byte
+	key             Key
+	findCertificate FindCertificateFn
+	lock            *sync.Mutex
+	certified       bool
+	lastCheck       time.Time
+}
+
+func NewCertifiedSigner(chain wallet.Signer, identity []byte, key Key, findCertificate FindCertificateFn) *CertifiedSigner {
+	return &CertifiedSigner{
+		chain:           chain,
+		identity:        identity,
+		key:             key,
+		findCertificate: findCertificate,
+		lock:            &sync.Mutex{},
+	}
+}
+
+func (s *CertifiedSigner) Certified() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.certified || time.Since(s.lastCheck) < certificationCheckInterval {
+		return s.certified
+	}
+	s.lastCheck = time.Now()
+	certificate, err := s.findCertificate(s.key.Verifier())
+	if err != nil {
+		log.Printf("Failed to check transport key certificate %s", err)
+		return false
+	}
+	if certificate != nil && bytes.Equal(certificate.Identity, s.identity) {
+		log.Printf("Transport key %s is certified, switching to it", s.key.Verifier())
+		s.certified = true
+	}
+	return s.certified
+}
+
+func (s *CertifiedSigner) Current() wallet.Signer {
+	if s.Certified() {
+		return s.key
+	}
+	return s.chain
+}
+
+func (s *CertifiedSigner) Sign(data wallet.Signable) (string, error) {
+	return s.Current().Sign(data)
+}
+
+func (s *CertifiedSigner) SignRaw(data wallet.Signable) ([]byte, error) {
+	return s.Current().SignRaw(data)
+}
+
+func (s *CertifiedSigner) Verifier() string {
+	return s.Current().Verifier()
+}
@@ -0,0 +1,199 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+type Algorithm string
+
+const (
+	P256    Algorithm = "p256"
+	Ed25519 Algorithm = "ed25519"
+)
+
+// Key is used only for signing websocket messages. It is bound to a chain
+// identity by a certificate stored on chain, so the chain key itself is
+// needed only once to sign the certificate.
+type Key struct {
+	Algorithm Algorithm
+	PublicKey []byte
+	p256      *ecdsa.PrivateKey
+	ed25519   ed25519.PrivateKey
+}
+
+func Generate(algorithm Algorithm) (*Key, error) {
+	switch algorithm {
+	case P256:
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to generate p256 key")
+		}
+		return newP256Key(privateKey), nil
+	case Ed25519:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to generate ed25519 key")
+		}
+		return newEd25519Key(privateKey), nil
+	default:
+		return nil, errors.Errorf("Unknown transport signature algorithm %s", algorithm)
+	}
+}
+
+func newP256Key(privateKey *ecdsa.PrivateKey) *Key {
+	return &Key{
+		Algorithm: P256,
+		PublicKey: append(privateKey.PublicKey.X.Bytes(), privateKey.PublicKey.Y.Bytes()...),
+		p256:      privateKey,
+	}
+}
+
+func newEd25519Key(privateKey ed25519.PrivateKey) *Key {
+	return &Key{
+		Algorithm: Ed25519,
+		PublicKey: []byte(privateKey.Public().(ed25519.PublicKey)),
+		ed25519:   privateKey,
+	}
+}
+
+func Import(fileName string) (*Key, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read transport key %s", fileName)
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.Errorf("Transport key file %s is not PEM encoded", fileName)
+	}
+	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse transport key")
+	}
+	switch k := privateKey.(type) {
+	case *ecdsa.PrivateKey:
+		return newP256Key(k), nil
+	case ed25519.PrivateKey:
+		return newEd25519Key(k), nil
+	default:
+		return nil, errors.Errorf("Unsupported transport key type %T", privateKey)
+	}
+}
+
+// LoadOrGenerate imports the key from the file or, if the file does not
+// exist yet, generates a new key and exports it to the file.
+func LoadOrGenerate(fileName string, algorithm Algorithm) (*Key, error) {
+	switch _, err := os.Stat(fileName); {
+	case err == nil:
+		return Import(fileName)
+	case !os.IsNotExist(err):
+		return nil, errors.Wrapf(err, "Failed to read stat for file %s", fileName)
+	}
+	key, err := Generate(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if err := key.Export(fileName); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (k Key) Export(fileName string) error {
+	var privateKey interface{} = k.ed25519
+	if k.Algorithm == P256 {
+		privateKey = k.p256
+	}
+	encoded, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return errors.Wrap(err, "Failed to encode transport key")
+	}
+	pemEncoded := pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: encoded,
+	})
+	if err := ioutil.WriteFile(fileName, pemEncoded, 0600); err != nil {
+		return errors.Wrap(err, "Failed to export transport key")
+	}
+	return nil
+}
+
+func (k Key) SignRaw(data wallet.Signable) ([]byte, error) {
+	if k.Algorithm == P256 {
+		return wallet.Sign(data, *k.p256)
+	}
+	signable, err := data.Signable()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to convert to signable %#v", data)
+	}
+	return ed25519.Sign(k.ed25519, signable), nil
+}
+
+func (k Key) Sign(data wallet.Signable) (string, error) {
+	signature, err := k.SignRaw(data)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to create signature for %#v", data)
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+func (k Key) Verifier() string {
+	return EncodeVerifier(k.Algorithm, k.PublicKey)
+}
+
+// EncodeVerifier prefixes the public key with its algorithm so transport
+// keys can't be mistaken for chain keys, which are sent as plain base64.
+func EncodeVerifier(algorithm Algorithm, publicKey []byte) string {
+	return string(algorithm) + ":" + base64.StdEncoding.EncodeToString(publicKey)
+}
+
+func ParseVerifier(verifier string) (Algorithm, []byte, bool) {
+	parts := strings.SplitN(verifier, ":", 2)
+	if len(parts) != 2 {
+		return "", nil, false
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, false
+	}
+	switch algorithm := Algorithm(parts[0]); algorithm {
+	case P256, Ed25519:
+		return algorithm, publicKey, true
+	default:
+		return "", nil, false
+	}
+}
+
+func IsTransportVerifier(verifier string) bool {
+	_, _, ok := ParseVerifier(verifier)
+	return ok
+}
+
+func Verify(data wallet.Signable, signature []byte, algorithm Algorithm, publicKey []byte) bool {
+	switch algorithm {
+	case P256:
+		return wallet.Verify(data, signature, publicKey)
+	case Ed25519:
+		if len(publicKey) != ed25519.PublicKeySize {
+			return false
+		}
+		signable, err := data.Signable()
+		if err != nil {
+			return false
+		}
+		return ed25519.Verify(ed25519.PublicKey(publicKey), signable, signature)
+	default:
+		return false
+	}
+}
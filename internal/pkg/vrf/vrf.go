@@ -0,0 +1,184 @@
+package vrf
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/sha512"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// The verifiable random function is ECVRF-P256-SHA256-TAI of RFC 9381, over
+// the keys wallets already have. Only the nonce is derived differently, out
+// of the private key and the hashed point, which doesn't change the proofs
+// other implementations of the suite accept.
+const (
+	suite           byte = 0x01
+	hashToCurve     byte = 0x01
+	challengeDomain byte = 0x02
+	outputDomain    byte = 0x03
+
+	pointSize     = 33
+	challengeSize = 16
+	scalarSize    = 32
+
+	ProofSize  = pointSize + challengeSize + scalarSize
+	OutputSize = sha256.Size
+)
+
+var curve = elliptic.P256()
+
+// Prove returns the output of the function on alpha under the key and the
+// proof anyone holding the public key can check it with.
+func Prove(key ecdsa.PrivateKey, alpha []byte) ([]byte, []byte, error) {
+	if key.D == nil {
+		return nil, nil, errors.New("Private key is required to prove")
+	}
+	pk := compress(key.PublicKey.X, key.PublicKey.Y)
+	hx, hy, ok := hashPoint(pk, alpha)
+	if !ok {
+		return nil, nil, errors.New("Failed to hash alpha to a point")
+	}
+	params := curve.Params()
+	gx, gy := curve.ScalarMult(hx, hy, key.D.Bytes())
+	k := nonce(key.D, compress(hx, hy))
+	if k.Sign() == 0 {
+		return nil, nil, errors.New("Failed to derive nonce")
+	}
+	ux, uy := curve.ScalarBaseMult(k.Bytes())
+	vx, vy := curve.ScalarMult(hx, hy, k.Bytes())
+	c := challenge(pk, compress(hx, hy), compress(gx, gy), compress(ux, uy), compress(vx, vy))
+	s := new(big.Int).Mul(c, key.D)
+	s.Add(s, k).Mod(s, params.N)
+	proof := make([]byte, 0, ProofSize)
+	proof = append(proof, compress(gx, gy)...)
+	proof = append(proof, pad(c, challengeSize)...)
+	proof = append(proof, pad(s, scalarSize)...)
+	return output(gx, gy), proof, nil
+}
+
+// Verify checks the proof of alpha under the raw public key of a wallet and
+// returns the output of the function.
+func Verify(publicKey, alpha, proof []byte) ([]byte, bool) {
+	if len(publicKey) == 0 || len(proof) != ProofSize {
+		return nil, false
+	}
+	yx := new(big.Int).SetBytes(publicKey[:len(publicKey)/2])
+	yy := new(big.Int).SetBytes(publicKey[len(publicKey)/2:])
+	if !curve.IsOnCurve(yx, yy) {
+		return nil, false
+	}
+	gx, gy, ok := decompress(proof[:pointSize])
+	if !ok {
+		return nil, false
+	}
+	params := curve.Params()
+	c := new(big.Int).SetBytes(proof[pointSize : pointSize+challengeSize])
+	s := new(big.Int).SetBytes(proof[pointSize+challengeSize:])
+	if s.Cmp(params.N) >= 0 {
+		return nil, false
+	}
+	pk := compress(yx, yy)
+	hx, hy, ok := hashPoint(pk, alpha)
+	if !ok {
+		return nil, false
+	}
+	// U = s*B - c*Y and V = s*H - c*Gamma
+	sbx, sby := curve.ScalarBaseMult(s.Bytes())
+	cyx, cyy := multiply(yx, yy, c)
+	ux, uy := subtract(sbx, sby, cyx, cyy)
+	shx, shy := multiply(hx, hy, s)
+	cgx, cgy := multiply(gx, gy, c)
+	vx, vy := subtract(shx, shy, cgx, cgy)
+	expected := challenge(pk, compress(hx, hy), proof[:pointSize], compress(ux, uy), compress(vx, vy))
+	if expected.Cmp(c) != 0 {
+		return nil, false
+	}
+	return output(gx, gy), true
+}
+
+func multiply(x, y, k *big.Int) (*big.Int, *big.Int) {
+	return curve.ScalarMult(x, y, k.Bytes())
+}
+
+func subtract(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	return curve.Add(x1, y1, x2, new(big.Int).Sub(curve.Params().P, y2))
+}
+
+// hashPoint is the try and increment hash to curve of the suite.
+func hashPoint(pk, alpha []byte) (*big.Int, *big.Int, bool) {
+	for counter := 0; counter < 256; counter++ {
+		data := make([]byte, 0, 2+len(pk)+len(alpha)+2)
+		data = append(data, suite, hashToCurve)
+		data = append(data, pk...)
+		data = append(data, alpha...)
+		data = append(data, byte(counter), 0x00)
+		hash := sha256.Sum256(data)
+		if x, y, ok := decompress(append([]byte{0x02}, hash[:]...)); ok {
+			return x, y, true
+		}
+	}
+	return nil, nil, false
+}
+
+func nonce(d *big.Int, h []byte) *big.Int {
+	hash := sha512.Sum512(append(pad(d, scalarSize), h...))
+	return new(big.Int).Mod(new(big.Int).SetBytes(hash[:]), curve.Params().N)
+}
+
+func challenge(points ...[]byte) *big.Int {
+	data := []byte{suite, challengeDomain}
+	for _, p := range points {
+		data = append(data, p...)
+	}
+	hash := sha256.Sum256(append(data, 0x00))
+	return new(big.Int).SetBytes(hash[:challengeSize])
+}
+
+func output(gx, gy *big.Int) []byte {
+	data := append([]byte{suite, outputDomain}, compress(gx, gy)...)
+	hash := sha256.Sum256(append(data, 0x00))
+	return hash[:]
+}
+
+func pad(i *big.Int, size int) []byte {
+	raw := i.Bytes()
+	if len(raw) >= size {
+		return raw[len(raw)-size:]
+	}
+	return append(bytes.Repeat([]byte{0}, size-len(raw)), raw...)
+}
+
+func compress(x, y *big.Int) []byte {
+	return append([]byte{0x02 + byte(y.Bit(0))}, pad(x, pointSize-1)...)
+}
+
+// decompress returns the point of the compressed encoding, solving
+// y² = x³ - 3x + b.
+func decompress(raw []byte) (*big.Int, *big.Int, bool) {
+	if len(raw) != pointSize || raw[0] != 0x02 && raw[0] != 0x03 {
+		return nil, nil, false
+	}
+	params := curve.Params()
+	x := new(big.Int).SetBytes(raw[1:])
+	if x.Cmp(params.P) >= 0 {
+		return nil, nil, false
+	}
+	y2 := new(big.Int).Exp(x, big.NewInt(3), params.P)
+	threeX := new(big.Int).Mul(x, big.NewInt(3))
+	y2.Sub(y2, threeX).Add(y2, params.B).Mod(y2, params.P)
+	y := new(big.Int).ModSqrt(y2, params.P)
+	if y == nil {
+		return nil, nil, false
+	}
+	if byte(y.Bit(0)) != raw[0]&1 {
+		y.Sub(params.P, y)
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, nil, false
+	}
+	return x, y, true
+}
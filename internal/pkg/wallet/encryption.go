@@ -0,0 +1,123 @@
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	encryptedPrivateKeyType = "ENCRYPTED PRIVATE KEY"
+	kdf                     = "scrypt"
+	scryptN                 = 1 << 15
+	scryptR                 = 8
+	scryptP                 = 1
+)
+
+var (
+	ErrEncryptedKey     = errors.New("Private key is encrypted and no passphrase is given")
+	ErrWrongPassphrase  = errors.New("Passphrase does not open the private key")
+	errUnknownKeyFormat = errors.New("Unknown format of encrypted private key")
+)
+
+func privateKeyAEAD(passphrase string, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, n, r, p, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to derive key")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create cipher")
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptPrivateKey encodes the private key as PKCS#8 and encrypts it with
+// AES-256-GCM under a key derived from the passphrase with scrypt. The
+// result is a PEM block whose headers carry the parameters of scrypt, the
+// salt and the nonce.
+func EncryptPrivateKey(private ecdsa.PrivateKey, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New("Passphrase is empty")
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, errors.Wrap(err, "Failed to generate salt")
+	}
+	aead, err := privateKeyAEAD(passphrase, salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "Failed to generate nonce")
+	}
+	plain, err := x509.MarshalPKCS8PrivateKey(&private)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to encode private key")
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type: encryptedPrivateKeyType,
+		Headers: map[string]string{
+			"KDF":   kdf,
+			"N":     strconv.Itoa(scryptN),
+			"R":     strconv.Itoa(scryptR),
+			"P":     strconv.Itoa(scryptP),
+			"Salt":  hex.EncodeToString(salt),
+			"Nonce": hex.EncodeToString(nonce),
+		},
+		Bytes: aead.Seal(nil, nonce, plain, []byte(encryptedPrivateKeyType)),
+	}), nil
+}
+
+func decryptPrivateKey(block *pem.Block, passphrase string) (*ecdsa.PrivateKey, error) {
+	if block.Headers["KDF"] != kdf {
+		return nil, errUnknownKeyFormat
+	}
+	var params [3]int
+	for i, name := range []string{"N", "R", "P"} {
+		value, err := strconv.Atoi(block.Headers[name])
+		if err != nil {
+			return nil, errUnknownKeyFormat
+		}
+		params[i] = value
+	}
+	salt, err := hex.DecodeString(block.Headers["Salt"])
+	if err != nil {
+		return nil, errUnknownKeyFormat
+	}
+	nonce, err := hex.DecodeString(block.Headers["Nonce"])
+	if err != nil {
+		return nil, errUnknownKeyFormat
+	}
+	aead, err := privateKeyAEAD(passphrase, salt, params[0], params[1], params[2])
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errUnknownKeyFormat
+	}
+	plain, err := aead.Open(nil, nonce, block.Bytes, []byte(encryptedPrivateKeyType))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(plain)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse private key")
+	}
+	private, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.Errorf("Private key is %T instead of an ECDSA key", parsed)
+	}
+	private.Curve = elliptic.P256()
+	return private, nil
+}
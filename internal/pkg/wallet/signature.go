@@ -52,6 +52,9 @@ func Sign(data Signable, privateKey ecdsa.PrivateKey) ([]byte, error) {
 	return append(r.Bytes(), s.Bytes()...), nil
 }
 
+// hash is the digest ECDSA signs. It stays SHA-256 whatever the algorithm
+// of the blockchain, since voters, trustees and guardians sign without
+// knowing the blockchain.
 func hash(data []byte) []byte {
 	hashed := sha256.Sum256(data)
 	return hashed[:]
@@ -63,6 +66,20 @@ type Signer interface {
 	SignRaw(Signable) ([]byte, error)
 }
 
+// DynamicSigner is a signer whose key can change over time. Current has to
+// be used to sign a message and announce its verifier with the same key.
+type DynamicSigner interface {
+	Signer
+	Current() Signer
+}
+
+func Current(signer Signer) Signer {
+	if dynamic, ok := signer.(DynamicSigner); ok {
+		return dynamic.Current()
+	}
+	return signer
+}
+
 type walletSigner struct {
 	wallet Wallet
 }
@@ -1,6 +1,7 @@
 package wallet
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -8,6 +9,7 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"io/ioutil"
+	"os"
 
 	"github.com/nebser/crypto-vote/internal/pkg/keyfiles"
 
@@ -32,16 +34,38 @@ func (w Wallet) PublicKeyHash() []byte {
 	return ExtractPublicKeyHash(w.Address)
 }
 
-func (w Wallet) Export(filePrefix string) error {
+// PrivateKeyPEM returns the unencrypted private key the way it is exported.
+func (w Wallet) PrivateKeyPEM() ([]byte, error) {
 	encodedPrivateKey, err := x509.MarshalECPrivateKey(&w.PrivateKey)
 	if err != nil {
-		return errors.Wrap(err, "Failed to encode wallet private key")
+		return nil, errors.Wrap(err, "Failed to encode wallet private key")
 	}
-	pemEncodedPrivateKey := pem.EncodeToMemory(&pem.Block{
+	return pem.EncodeToMemory(&pem.Block{
 		Type:  "PRIVATE KEY",
 		Bytes: encodedPrivateKey,
-	})
-	if err := ioutil.WriteFile(filePrefix+".pem", pemEncodedPrivateKey, 0644); err != nil {
+	}), nil
+}
+
+func (w Wallet) Export(filePrefix string) error {
+	pemEncodedPrivateKey, err := w.PrivateKeyPEM()
+	if err != nil {
+		return err
+	}
+	return w.export(filePrefix, pemEncodedPrivateKey, 0644)
+}
+
+// ExportEncrypted exports the wallet like Export with the private key
+// encrypted under the passphrase, see EncryptPrivateKey.
+func (w Wallet) ExportEncrypted(filePrefix, passphrase string) error {
+	pemEncodedPrivateKey, err := EncryptPrivateKey(w.PrivateKey, passphrase)
+	if err != nil {
+		return err
+	}
+	return w.export(filePrefix, pemEncodedPrivateKey, 0600)
+}
+
+func (w Wallet) export(filePrefix string, pemEncodedPrivateKey []byte, mode os.FileMode) error {
+	if err := ioutil.WriteFile(filePrefix+".pem", pemEncodedPrivateKey, mode); err != nil {
 		return errors.Wrap(err, "Failed to export private key")
 	}
 
@@ -81,25 +105,33 @@ func LoadPublicKey(fileName string) ([]byte, error) {
 	return append(publicKey.X.Bytes(), publicKey.Y.Bytes()...), nil
 }
 
-func Import(keyfiles keyfiles.KeyFiles) (*Wallet, error) {
-	publicKeyContent, err := ioutil.ReadFile(keyfiles.PublicKeyFile)
+func loadPublicKey(fileName string) (*ecdsa.PublicKey, []byte, string, error) {
+	publicKeyContent, err := ioutil.ReadFile(fileName)
 	if err != nil {
-		return nil, errors.Wrap(err, "Failed to read public key")
+		return nil, nil, "", errors.Wrap(err, "Failed to read public key")
 	}
 	publicKeyBlock, _ := pem.Decode([]byte(publicKeyContent))
 	rawPublicKey, err := x509.ParsePKIXPublicKey(publicKeyBlock.Bytes)
 	if err != nil {
-		return nil, errors.Wrap(err, "Failed to parse public key")
+		return nil, nil, "", errors.Wrap(err, "Failed to parse public key")
 	}
 	publicKey, ok := rawPublicKey.(*ecdsa.PublicKey)
 	if !ok {
-		return nil, errors.Errorf("Failed to case %#v to public key", rawPublicKey)
+		return nil, nil, "", errors.Errorf("Failed to case %#v to public key", rawPublicKey)
 	}
 	publicKey.Curve = elliptic.P256()
 	pk := append(publicKey.X.Bytes(), publicKey.Y.Bytes()...)
 	address, err := ExtractAddress(pk)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to extract address from %s", pk)
+		return nil, nil, "", errors.Wrapf(err, "Failed to extract address from %s", pk)
+	}
+	return publicKey, pk, address, nil
+}
+
+func Import(keyfiles keyfiles.KeyFiles) (*Wallet, error) {
+	publicKey, pk, address, err := loadPublicKey(keyfiles.PublicKeyFile)
+	if err != nil {
+		return nil, err
 	}
 
 	privateKeyContent, err := ioutil.ReadFile(keyfiles.PrivateKeyFile)
@@ -107,9 +139,30 @@ func Import(keyfiles keyfiles.KeyFiles) (*Wallet, error) {
 		return nil, errors.Wrap(err, "Failed to read private key")
 	}
 	privateKeyBlock, _ := pem.Decode([]byte(privateKeyContent))
-	privateKey, err := x509.ParseECPrivateKey(privateKeyBlock.Bytes)
-	if err != nil {
-		return nil, errors.Wrap(err, "Failed to parse private key")
+	if privateKeyBlock == nil {
+		return nil, errors.Errorf("Private key file %s holds no PEM block", keyfiles.PrivateKeyFile)
+	}
+	var privateKey *ecdsa.PrivateKey
+	if privateKeyBlock.Type == encryptedPrivateKeyType {
+		if keyfiles.Passphrase == nil {
+			return nil, errors.Wrapf(ErrEncryptedKey, "Failed to import %s", keyfiles.PrivateKeyFile)
+		}
+		passphrase, err := keyfiles.Passphrase(keyfiles.PrivateKeyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to get passphrase of %s", keyfiles.PrivateKeyFile)
+		}
+		privateKey, err = decryptPrivateKey(privateKeyBlock, passphrase)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to decrypt %s", keyfiles.PrivateKeyFile)
+		}
+	} else {
+		privateKey, err = x509.ParseECPrivateKey(privateKeyBlock.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to parse private key")
+		}
+	}
+	if privateKey.PublicKey.X.Cmp(publicKey.X) != 0 || privateKey.PublicKey.Y.Cmp(publicKey.Y) != 0 {
+		return nil, errors.Errorf("Private key %s does not match public key %s", keyfiles.PrivateKeyFile, keyfiles.PublicKeyFile)
 	}
 	privateKey.PublicKey = *publicKey
 	return &Wallet{
@@ -119,22 +172,58 @@ func Import(keyfiles keyfiles.KeyFiles) (*Wallet, error) {
 	}, nil
 }
 
+// ImportPublic returns a wallet without the private key, for processes that
+// sign through a signer holding the key elsewhere.
+func ImportPublic(publicKeyFile string) (*Wallet, error) {
+	publicKey, pk, address, err := loadPublicKey(publicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Wallet{
+		PublicKey:  pk,
+		PrivateKey: ecdsa.PrivateKey{PublicKey: *publicKey},
+		Address:    address,
+	}, nil
+}
+
 func ExtractAddress(publicKey []byte) (string, error) {
-	publicSHA256 := sha256.Sum256(publicKey)
-	RIPEMD160Hasher := ripemd160.New()
-	if _, err := RIPEMD160Hasher.Write(publicSHA256[:]); err != nil {
-		return "", errors.Wrap(err, "Failed to write to RIPEMD")
+	publicRIPEMD160, err := HashedPublicKey(publicKey)
+	if err != nil {
+		return "", err
 	}
+	return EncodeAddress(publicRIPEMD160), nil
+}
 
-	publicRIPEMD160 := RIPEMD160Hasher.Sum(nil)
-	versionedPublicKey := append([]byte{version}, publicRIPEMD160...)
+// EncodeAddress encodes the public key hash as a Base58Check address.
+func EncodeAddress(publicKeyHash []byte) string {
+	versionedPublicKey := append([]byte{version}, publicKeyHash...)
 	checksum := getChecksum(versionedPublicKey)
 
 	payload := append(versionedPublicKey, checksum...)
-	encoded := base58.Encode(payload)
-	return encoded, nil
+	return base58.Encode(payload)
 }
 
+// DecodeAddress returns the public key hash of the Base58Check address,
+// making sure the version and checksum are right.
+func DecodeAddress(address string) ([]byte, error) {
+	decoded := base58.Decode(address)
+	switch {
+	case len(decoded) <= 1+addressLength:
+		return nil, errors.Errorf("Address %q is too short", address)
+	case decoded[0] != version:
+		return nil, errors.Errorf("Address %q has unknown version %d", address, decoded[0])
+	}
+	payload, checksum := decoded[:len(decoded)-addressLength], decoded[len(decoded)-addressLength:]
+	if !bytes.Equal(checksum, getChecksum(payload)) {
+		return nil, errors.Errorf("Address %q has invalid checksum", address)
+	}
+	return payload[1:], nil
+}
+
+// HashedPublicKey is the hash addresses are made of. It stays SHA-256 and
+// RIPEMD-160 whatever the algorithm of the blockchain, since keys and their
+// addresses are generated before there is a blockchain and are used across
+// elections.
 func HashedPublicKey(publicKey []byte) ([]byte, error) {
 	publicSHA256 := sha256.Sum256(publicKey)
 	RIPEMD160Hasher := ripemd160.New()
@@ -164,6 +253,11 @@ func New() (*Wallet, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to generate private key")
 	}
+	return FromPrivateKey(private)
+}
+
+// FromPrivateKey returns the wallet holding the private key.
+func FromPrivateKey(private *ecdsa.PrivateKey) (*Wallet, error) {
 	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
 	address, err := ExtractAddress(pubKey)
 	if err != nil {
@@ -0,0 +1,112 @@
+package watchtower
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Notifier delivers alerts to whoever looks after the voters.
+type Notifier interface {
+	Name() string
+	Notify(Alert) error
+}
+
+type webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook posts every alert as JSON to the url.
+func NewWebhook(url string) Notifier {
+	return webhook{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (w webhook) Name() string {
+	return fmt.Sprintf("webhook:%s", w.url)
+}
+
+func (w webhook) Notify(a Alert) error {
+	raw, err := json.Marshal(a)
+	if err != nil {
+		return errors.Wrap(err, "Failed to marshal alert")
+	}
+	response, err := w.client.Post(w.url, "application/json", bytes.NewReader(raw))
+	if err != nil {
+		return errors.Wrapf(err, "Failed to post alert to %s", w.url)
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(response.Body)
+		return errors.Errorf("Webhook %s responded with status %d: %s", w.url, response.StatusCode, body)
+	}
+	return nil
+}
+
+type command struct {
+	args []string
+}
+
+// NewCommand runs the command for every alert with the alert as JSON on its
+// standard input, e.g. a script sending an email.
+func NewCommand(line string) Notifier {
+	return command{args: strings.Fields(line)}
+}
+
+func (c command) Name() string {
+	return fmt.Sprintf("command:%s", strings.Join(c.args, " "))
+}
+
+func (c command) Notify(a Alert) error {
+	if len(c.args) == 0 {
+		return errors.New("Command is empty")
+	}
+	raw, err := json.Marshal(a)
+	if err != nil {
+		return errors.Wrap(err, "Failed to marshal alert")
+	}
+	cmd := exec.Command(c.args[0], c.args[1:]...)
+	cmd.Stdin = bytes.NewReader(raw)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "Command %s failed: %s", c.args[0], output)
+	}
+	return nil
+}
+
+type notifiers []Notifier
+
+// All notifies every notifier, alerts are only logged if there is none.
+func All(list ...Notifier) Notifier {
+	return notifiers(list)
+}
+
+func (n notifiers) Name() string {
+	names := []string{}
+	for _, notifier := range n {
+		names = append(names, notifier.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (n notifiers) Notify(a Alert) error {
+	var failed []string
+	for _, notifier := range n {
+		if err := notifier.Notify(a); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package watchtower
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/merkle"
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
+	"github.com/pkg/errors"
+)
+
+var (
+	watched = metrics.NewGauge("watchtower_receipts", "Number of vote receipts the watchtower watches")
+	alerts  = metrics.NewCounter("watchtower_alerts_total", "Number of alerts raised by the watchtower")
+)
+
+type Status string
+
+const (
+	// Pending votes haven't been seen in a block yet.
+	Pending Status = "pending"
+	// Included votes are in a block and their inclusion proof verifies.
+	Included Status = "included"
+	// Dropped votes were in a block which is not in the blockchain anymore.
+	Dropped Status = "dropped"
+	// Unverifiable votes are in a block whose inclusion proof doesn't verify.
+	Unverifiable Status = "unverifiable"
+)
+
+// Receipt is the state of a watched vote, Block and Height are the ones it
+// was last seen in.
+type Receipt struct {
+	Transaction []byte `json:"transaction"`
+	Status      Status `json:"status"`
+	Block       []byte `json:"block,omitempty"`
+	Height      int    `json:"height,omitempty"`
+	ChangedAt   int64  `json:"changedAt"`
+}
+
+// Alert tells that a watched vote changed from one status to another.
+type Alert struct {
+	Transaction []byte `json:"transaction"`
+	From        Status `json:"from"`
+	To          Status `json:"to"`
+	Block       []byte `json:"block,omitempty"`
+	Height      int    `json:"height,omitempty"`
+	At          int64  `json:"at"`
+}
+
+// ReadReceiptsFn returns the ids of the watched vote transactions.
+type ReadReceiptsFn func() ([][]byte, error)
+
+// ReadReceipts reads hex encoded transaction ids, one per line, from the
+// file. Blank lines and lines starting with # are skipped. The file is read
+// on every check, so receipts can be added while the watchtower runs.
+func ReadReceipts(path string) ReadReceiptsFn {
+	return func() ([][]byte, error) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to open receipts file %s", path)
+		}
+		defer file.Close()
+		var result [][]byte
+		scanner := bufio.NewScanner(file)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			id, err := hex.DecodeString(text)
+			if err != nil || len(id) == 0 {
+				return nil, errors.Errorf("Invalid receipt on line %d of %s", line, path)
+			}
+			result = append(result, id)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, errors.Wrapf(err, "Failed to read receipts file %s", path)
+		}
+		return result, nil
+	}
+}
+
+type location struct {
+	block  blockchain.Block
+	index  int
+	height int
+}
+
+// Tower watches vote receipts against the blockchain and alerts whenever a
+// vote which was in a block is dropped by a reorganization, its inclusion
+// proof stops verifying or it is back in the blockchain. Receipts are kept
+// in memory, so changes while the watchtower doesn't run aren't alerted.
+type Tower struct {
+	lock     *sync.RWMutex
+	receipts map[string]Receipt
+}
+
+func New() *Tower {
+	return &Tower{
+		lock:     &sync.RWMutex{},
+		receipts: make(map[string]Receipt),
+	}
+}
+
+// locate walks the blockchain once and finds the blocks of the watched
+// transactions.
+func locate(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, ids map[string]bool) (map[string]location, error) {
+	result := map[string]location{}
+	depth := 0
+	for current := getTip(); len(current) > 0; depth++ {
+		block, err := getBlock(current)
+		switch {
+		case err != nil:
+			return nil, errors.Wrapf(err, "Failed to get block %x", current)
+		case block == nil:
+			return nil, errors.Errorf("Block %x is missing", current)
+		}
+		for i, t := range block.Body.Transactions {
+			if ids[string(t.ID)] {
+				// Height is counted from the tip until the walk ends.
+				result[string(t.ID)] = location{block: *block, index: i, height: -depth}
+			}
+		}
+		current = block.Header.Prev
+	}
+	for id, l := range result {
+		l.height += depth
+		result[id] = l
+	}
+	return result, nil
+}
+
+// verify proves the inclusion of the transaction in its block the way a
+// voter does. Blocks forged before Merkle trees have no proofs and are
+// trusted.
+func verify(id []byte, l location) bool {
+	if l.block.Header.Version < blockchain.MerkleVersion {
+		return true
+	}
+	path, ok := merkle.Prove(l.block.HashAlgorithm(), l.block.Body.Transactions.IDs(), l.index)
+	if !ok {
+		return false
+	}
+	return blockchain.InclusionProof{
+		Transaction: id,
+		Index:       l.index,
+		Path:        path,
+		Header:      l.block.CompactHeader(l.height),
+	}.Verify()
+}
+
+func next(previous Status, found, valid bool) Status {
+	switch {
+	case found && valid:
+		return Included
+	case found:
+		return Unverifiable
+	case previous == Pending:
+		return Pending
+	default:
+		return Dropped
+	}
+}
+
+// Check updates the receipts from the blockchain and notifies every change
+// of a status, or of the block of an included vote, except a pending vote
+// being included.
+func (t *Tower) Check(getTip blockchain.GetTipFn, getBlock blockchain.GetBlockFn, readReceipts ReadReceiptsFn, notifier Notifier) func() error {
+	return func() error {
+		receipts, err := readReceipts()
+		if err != nil {
+			return err
+		}
+		ids := map[string]bool{}
+		for _, id := range receipts {
+			ids[string(id)] = true
+		}
+		locations, err := locate(getTip, getBlock, ids)
+		if err != nil {
+			return err
+		}
+		now := time.Now().Unix()
+		var changes []Alert
+		t.lock.Lock()
+		for id := range t.receipts {
+			if !ids[id] {
+				delete(t.receipts, id)
+			}
+		}
+		for _, id := range receipts {
+			r, ok := t.receipts[string(id)]
+			if !ok {
+				r = Receipt{Transaction: id, Status: Pending, ChangedAt: now}
+			}
+			l, found := locations[string(id)]
+			status := next(r.Status, found, found && verify(id, l))
+			moved := found && !bytes.Equal(l.block.Header.Hash, r.Block)
+			if found {
+				r.Block = l.block.Header.Hash
+				r.Height = l.height
+			}
+			if status != r.Status || moved {
+				if r.Status != Pending || status != Included {
+					changes = append(changes, Alert{
+						Transaction: id,
+						From:        r.Status,
+						To:          status,
+						Block:       r.Block,
+						Height:      r.Height,
+						At:          now,
+					})
+				}
+				r.Status = status
+				r.ChangedAt = now
+			}
+			t.receipts[string(id)] = r
+		}
+		watched.Set(float64(len(t.receipts)))
+		t.lock.Unlock()
+		for _, a := range changes {
+			alerts.Inc()
+			log.Printf("ALERT: vote %x went from %s to %s", a.Transaction, a.From, a.To)
+			if err := notifier.Notify(a); err != nil {
+				log.Printf("Failed to notify %s of vote %x %s", notifier.Name(), a.Transaction, err)
+			}
+		}
+		return nil
+	}
+}
+
+// Receipts returns the watched receipts, the ones needing attention first.
+func (t *Tower) Receipts() []Receipt {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	result := []Receipt{}
+	for _, r := range t.receipts {
+		result = append(result, r)
+	}
+	rank := map[Status]int{Dropped: 0, Unverifiable: 1, Pending: 2, Included: 3}
+	sort.Slice(result, func(i, j int) bool {
+		if rank[result[i].Status] != rank[result[j].Status] {
+			return rank[result[i].Status] < rank[result[j].Status]
+		}
+		return bytes.Compare(result[i].Transaction, result[j].Transaction) < 0
+	})
+	return result
+}
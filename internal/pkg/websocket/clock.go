@@ -0,0 +1,108 @@
+package websocket
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Heartbeat pings carry the time they were sent at, the other end answers
+// with a pong carrying that time and its own clock. Half of the round trip
+// is taken for the way back, so the offset of the clock of the other end is
+// its time less the time the pong is expected to have left at. Older nodes
+// echo the ping, their clocks are not measured.
+
+func pingPayload(now time.Time) []byte {
+	return []byte(strconv.FormatInt(now.UnixNano(), 10))
+}
+
+// answerPing sends the pong the way the default ping handler does, adding
+// the local time to pings which carry the time they were sent at.
+func answerPing(conn Conn, appData string) error {
+	message := appData
+	if _, err := strconv.ParseInt(appData, 10, 64); err == nil {
+		message = appData + "," + strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	err := conn.WriteControl(websocket.PongMessage, []byte(message), time.Now().Add(time.Second))
+	if err == websocket.ErrCloseSent {
+		return nil
+	}
+	if e, ok := err.(net.Error); ok && e.Temporary() {
+		return nil
+	}
+	return err
+}
+
+// clockSample returns the offset of the clock of the other end and the round
+// trip of the pong, false if the pong doesn't carry both times.
+func clockSample(appData string, received time.Time) (time.Duration, time.Duration, bool) {
+	parts := strings.Split(appData, ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	sent, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	remote, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	roundTrip := received.Sub(time.Unix(0, sent))
+	if roundTrip < 0 {
+		return 0, 0, false
+	}
+	return time.Unix(0, remote).Sub(time.Unix(0, sent).Add(roundTrip / 2)), roundTrip, true
+}
+
+// measureClock adds an offset to the average offset, weighing it by a fifth
+// like the latency.
+func (h *health) measureClock(offset time.Duration) {
+	if !h.clocked {
+		h.offset, h.clocked = offset, true
+		return
+	}
+	h.offset = (4*h.offset + offset) / 5
+}
+
+// MeasureClock records the offset of the clock of the other end carried by a
+// heartbeat pong.
+func (h *Hub) MeasureClock(internalID, appData string) {
+	offset, _, ok := clockSample(appData, time.Now())
+	if !ok {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, nodes := range []map[string]node{h.pending, h.receivers} {
+		if n, ok := nodes[internalID]; ok {
+			n.health.measureClock(offset)
+		}
+	}
+}
+
+// ClockOffsetsFn returns the offsets of the clocks of registered nodes, by
+// node id, whose clocks were measured. A positive offset is a clock ahead.
+type ClockOffsetsFn func() map[string]time.Duration
+
+func (h *Hub) ClockOffsets() map[string]time.Duration {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	result := map[string]time.Duration{}
+	for _, n := range h.receivers {
+		if n.health.clocked {
+			result[n.nodeID] = n.health.offset
+		}
+	}
+	return result
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
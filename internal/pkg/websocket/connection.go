@@ -1,49 +1,135 @@
 package websocket
 
 import (
-	"io"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"log"
+	"net"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/nebser/crypto-vote/internal/pkg/wallet"
 	"github.com/pkg/errors"
 )
 
+// MaxMessageBytes limits the size of a received message. A block of the
+// maximum size fits in it with the overhead of JSON and base64, which the
+// binary encoding doesn't have.
+const MaxMessageBytes = 4 << 20
+
+// seenDeliveries is how many delivery ids a reader remembers to drop
+// deliveries sent again after their acknowledgement got lost.
+const seenDeliveries = 1000
+
+// sendQueue is how many messages wait for the writer of a connection, a
+// connection with a full queue doesn't keep up and is closed by the hub.
+const sendQueue = 64
+
+// writeWait bounds a single write, a peer which doesn't read its messages
+// fails the write instead of stalling the writer forever.
+const writeWait = 10 * time.Second
+
 type Connection func(resp http.ResponseWriter, request *http.Request) error
 
+// Conn is the part of a websocket connection the reader and the writer use,
+// so a connection can be served without a socket.
+type Conn interface {
+	ReadMessage() (int, []byte, error)
+	WriteMessage(kind int, data []byte) error
+	WriteJSON(v interface{}) error
+	WriteControl(kind int, data []byte, deadline time.Time) error
+	SetWriteDeadline(deadline time.Time) error
+	SetReadLimit(limit int64)
+	SetPongHandler(h func(appData string) error)
+	SetPingHandler(h func(appData string) error)
+	RemoteAddr() net.Addr
+	Close() error
+}
+
 func (c Connection) ServeHTTP(resp http.ResponseWriter, request *http.Request) {
 	if err := c(resp, request); err != nil {
 		log.Printf("Error occurred %s\n", err)
 	}
 }
 
-func reader(conn *websocket.Conn, id string, hub *Hub, router Router, responseChan chan Pong, wg *sync.WaitGroup) {
+// DecodeFrame reads the message of a binary or a JSON text frame.
+func DecodeFrame(kind int, raw []byte) (Ping, error) {
+	if kind == websocket.BinaryMessage {
+		return decodeBinary(raw)
+	}
+	var ping Ping
+	err := json.Unmarshal(raw, &ping)
+	return ping, err
+}
+
+func reader(conn Conn, id string, hub *Hub, router Router, responseChan chan Pong, wg *sync.WaitGroup) {
 	defer wg.Done()
-	defer close(responseChan)
 	defer hub.Unregister(id)
+	conn.SetReadLimit(MaxMessageBytes)
+	conn.SetPongHandler(func(appData string) error {
+		hub.Seen(id)
+		hub.MeasureClock(id, appData)
+		return nil
+	})
+	conn.SetPingHandler(func(appData string) error {
+		return answerPing(conn, appData)
+	})
+	delivered := map[string]bool{}
+	dispatch := newDispatcher(router, hub.Deadlines(), func(pong Pong) {
+		hub.Respond(id, pong)
+	})
 	for {
-		var ping Ping
-		if err := conn.ReadJSON(&ping); err != nil {
-			if err != io.ErrUnexpectedEOF {
-				log.Println("Closing reader")
+		kind, raw, err := conn.ReadMessage()
+		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				log.Printf("Closing connection %s, message exceeds %d bytes", id, MaxMessageBytes)
 				return
 			}
-			log.Printf("Failed to parse message %+v, %t\n", err, errors.Is(err, io.ErrUnexpectedEOF))
+			log.Println("Closing reader")
+			return
+		}
+		ping, err := DecodeFrame(kind, raw)
+		if err != nil {
+			log.Printf("Failed to parse message %+v\n", err)
 			responseChan <- Pong{
 				Message: ErrorMessage,
 			}
 			continue
 		}
+		hub.Seen(id)
 		if ping.Message == CloseConnectionMessage {
 			return
 		}
+		if ping.Message == DisconnectMessage {
+			log.Printf("Closing connection %s, the other end is shutting down", id)
+			return
+		}
+		if !hub.Chain().Accepts(ping.Chain) {
+			log.Printf("Closing connection %s, message is from chain %s", id, ping.Chain)
+			return
+		}
+		if ping.Message == AcknowledgeMessage {
+			hub.Acknowledge(id, ping.Delivery)
+			continue
+		}
+		if ping.Delivery != "" {
+			responseChan <- *NewAcknowledgePong(ping.Delivery)
+			if delivered[ping.Delivery] {
+				continue
+			}
+			if len(delivered) >= seenDeliveries {
+				delivered = map[string]bool{}
+			}
+			delivered[ping.Delivery] = true
+		}
 		if ping.Message == ErrorMessage {
 			log.Printf("Received error message %s\n", ping.Body)
 			continue
 		}
-		pong := router.Route(ping, id)
+		pong := dispatch.route(ping, id)
 		switch {
 		case pong == nil || pong.Message == NoActionMessage:
 			continue
@@ -55,18 +141,60 @@ func reader(conn *websocket.Conn, id string, hub *Hub, router Router, responseCh
 	}
 }
 
-func writer(conn *websocket.Conn, responseChan chan Pong, signer wallet.Signer, wg *sync.WaitGroup) {
+func writer(conn Conn, hub *Hub, responseChan chan Pong, signer wallet.Signer, encoding Encoding, wg *sync.WaitGroup) {
 	defer wg.Done()
 	for pong := range responseChan {
-		signed, err := pong.Signed(signer)
-		if err != nil {
-			log.Printf("Failed to sign message %#v", pong)
-			continue
+		pong.Chain = hub.Chain()
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if encoding == BinaryEncoding {
+			frame, err := pong.encodeBinary(signer)
+			if err != nil {
+				log.Printf("Failed to encode message %s %s", pong.Message, err)
+				continue
+			}
+			conn.WriteMessage(websocket.BinaryMessage, frame)
+		} else {
+			signed, err := pong.Signed(signer)
+			if err != nil {
+				log.Printf("Failed to sign message %s %s", pong.Message, err)
+				continue
+			}
+			conn.WriteJSON(signed)
+		}
+		if pong.switchTo != "" {
+			encoding = pong.switchTo
+		}
+	}
+}
+
+// heartbeat pings the other end until done is closed, the other end answers
+// with a pong which keeps the connection alive and measures its clock.
+func heartbeat(conn Conn, interval time.Duration, done chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, pingPayload(time.Now()), time.Now().Add(interval)); err != nil {
+				return
+			}
 		}
-		conn.WriteJSON(signed)
 	}
 }
 
+func fingerprint(request *http.Request) string {
+	if request.TLS == nil || len(request.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(request.TLS.PeerCertificates[0].Raw)
+	return hex.EncodeToString(sum[:])
+}
+
 func PingPongConnection(router Router, hub *Hub, signer wallet.Signer) Connection {
 	return func(resp http.ResponseWriter, request *http.Request) error {
 		upgrader := websocket.Upgrader{}
@@ -76,29 +204,58 @@ func PingPongConnection(router Router, hub *Hub, signer wallet.Signer) Connectio
 		}
 		defer conn.Close()
 
-		responseChan := make(chan Pong, 5)
-		id := hub.Add(responseChan)
+		responseChan := make(chan Pong, sendQueue)
+		peer := Peer{
+			RemoteAddr:  request.RemoteAddr,
+			Fingerprint: fingerprint(request),
+		}
+		id, err := hub.Add(responseChan, peer, conn.Close)
+		switch {
+		case errors.Is(err, ErrShuttingDown):
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "Shutting down"))
+			return err
+		case err != nil:
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Too many connections"))
+			return err
+		}
 		wg := sync.WaitGroup{}
 		wg.Add(2)
+		done := make(chan struct{})
 		go reader(conn, id, hub, router, responseChan, &wg)
-		go writer(conn, responseChan, signer, &wg)
+		go writer(conn, hub, responseChan, signer, JSONEncoding, &wg)
+		go heartbeat(conn, hub.Heartbeat().Interval, done)
 
 		wg.Wait()
+		close(done)
 
 		return nil
 	}
 }
 
-func MaintainConnection(conn *websocket.Conn, router Router, hub *Hub, nodeID string, signer wallet.Signer) {
+// MaintainConnection serves the connection over which the node registered
+// as nodeID, sending messages in the encoding negotiated at registration.
+func MaintainConnection(conn Conn, router Router, hub *Hub, nodeID string, signer wallet.Signer, encoding Encoding) {
 	defer conn.Close()
 
-	responseChan := make(chan Pong, 5)
-	id := hub.Add(responseChan)
+	responseChan := make(chan Pong, sendQueue)
+	peer := Peer{
+		RemoteAddr: conn.RemoteAddr().String(),
+		Outbound:   true,
+		Encoding:   encoding,
+	}
+	id, err := hub.Add(responseChan, peer, conn.Close)
+	if err != nil {
+		log.Printf("Failed to track connection %s", err)
+		return
+	}
 	hub.Register(id, nodeID)
 	wg := sync.WaitGroup{}
 	wg.Add(2)
+	done := make(chan struct{})
 	go reader(conn, id, hub, router, responseChan, &wg)
-	go writer(conn, responseChan, signer, &wg)
+	go writer(conn, hub, responseChan, signer, encoding, &wg)
+	go heartbeat(conn, hub.Heartbeat().Interval, done)
 
 	wg.Wait()
+	close(done)
 }
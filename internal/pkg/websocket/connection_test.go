@@ -0,0 +1,73 @@
+package websocket_test
+
+import (
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket/websockettest"
+)
+
+func newWallet(t *testing.T) wallet.Wallet {
+	w, err := wallet.New()
+	if err != nil {
+		t.Fatalf("Failed to create wallet %s", err)
+	}
+	return *w
+}
+
+func TestMaintainConnectionAnswersInNegotiatedEncoding(t *testing.T) {
+	hub := websocket.NewHub()
+	router := websocket.Router{
+		websocket.GetBlockchainHeightMessage: func(websocket.Ping, string) (*websocket.Pong, error) {
+			return websocket.NewResponsePong(map[string]int{"height": 7}), nil
+		},
+	}
+	conn := websockettest.NewConn("127.0.0.1:10001")
+	peer := websockettest.NewPeer("", newWallet(t)).
+		Then(websocket.GetBlockchainHeightMessage, nil).
+		Then(websocket.GetBlockchainHeightMessage, nil)
+	if err := peer.Play(conn); err != nil {
+		t.Fatalf("Failed to play script %s", err)
+	}
+	signer := wallet.NewSigner(newWallet(t))
+	done := make(chan struct{})
+	go func() {
+		websocket.MaintainConnection(conn, router, hub, "n1", signer, websocket.BinaryEncoding)
+		close(done)
+	}()
+
+	written, err := conn.WaitWritten(2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range written {
+		if f.Kind != gorilla.BinaryMessage {
+			t.Errorf("Expected binary frames, got kind %d", f.Kind)
+		}
+	}
+	messages, err := conn.Messages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range messages {
+		if m.Message != websocket.ResponseMessage || m.Sender != signer.Verifier() {
+			t.Errorf("Expected response signed by %s, got %s signed by %s", signer.Verifier(), m.Message, m.Sender)
+		}
+	}
+	if nodes := hub.RegisteredNodes(); len(nodes) != 1 || nodes[0] != "n1" {
+		t.Errorf("Expected the connection to be registered as n1, got %v", nodes)
+	}
+
+	conn.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the connection to stop once it is closed")
+	}
+	if nodes := hub.RegisteredNodes(); len(nodes) != 0 {
+		t.Errorf("Expected the closed connection to be unregistered, got %v", nodes)
+	}
+}
@@ -0,0 +1,179 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
+	"github.com/pkg/errors"
+)
+
+var (
+	handlerTimeouts = metrics.NewCounter("websocket_handler_timeouts_total", "Number of websocket messages whose handler didn't finish within its deadline")
+	busyHandlers    = metrics.NewCounter("websocket_handlers_busy_total", "Number of websocket messages refused because all handlers of their connection were busy")
+)
+
+// Deadlines bound how long the handler of a read-only message may run, so
+// a handler blocked on a slow scan of the database doesn't stall the
+// connection. Read-only messages are handled next to the reader in at most
+// Workers goroutines per connection, a message arriving while all of them
+// are busy is refused at once. Messages without a timeout of their own are
+// bounded by Timeout, a zero Timeout handles them in the reader without a
+// deadline. Messages which change the state of the node, e.g. forged blocks,
+// transactions and registrations, are always handled in the reader, in the
+// order they arrived and without a deadline, so they are never cut short
+// half way.
+type Deadlines struct {
+	Timeout  time.Duration
+	Messages Timeouts
+	Workers  int
+}
+
+// readOnly are the messages whose handlers only read the blockchain or the
+// hub.
+var readOnly = map[Message]bool{
+	GetBlockchainHeightMessage:  true,
+	GetMissingBlocksMessage:     true,
+	GetBlockMessage:             true,
+	GetNodesMessage:             true,
+	GetAccountMessage:           true,
+	GetBlocksRangeMessage:       true,
+	GetBlockTransactionsMessage: true,
+}
+
+// Timeouts are the deadlines of single messages.
+type Timeouts map[Message]time.Duration
+
+// DefaultTimeouts leave more time to the messages of nodes catching up,
+// which walk the blockchain.
+var DefaultTimeouts = Timeouts{GetMissingBlocksMessage: 30 * time.Second, GetBlocksRangeMessage: 30 * time.Second}
+
+func (d Deadlines) timeout(m Message) time.Duration {
+	if !readOnly[m] {
+		return 0
+	}
+	if timeout, ok := d.Messages[m]; ok {
+		return timeout
+	}
+	return d.Timeout
+}
+
+// ParseTimeouts parses comma separated message=timeout pairs, e.g.
+// get-missing-blocks=30s.
+func ParseTimeouts(raw string) (Timeouts, error) {
+	result := Timeouts{}
+	for _, pair := range strings.Split(raw, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("Invalid timeout %s", pair)
+		}
+		m, err := ParseMessage(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, err
+		}
+		if !readOnly[m] {
+			return nil, errors.Errorf("Message %s changes state and can't have a deadline", m)
+		}
+		timeout, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil || timeout < 0 {
+			return nil, errors.Errorf("Invalid timeout of message %s", m)
+		}
+		result[m] = timeout
+	}
+	return result, nil
+}
+
+func (t Timeouts) String() string {
+	parts := []string{}
+	for m := GetBlockchainHeightMessage; m <= BlockTransactionsMessage; m++ {
+		if timeout, ok := t[m]; ok {
+			parts = append(parts, fmt.Sprintf("%s=%s", m, timeout))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// SetDeadlines configures connections opened from now on.
+func (h *Hub) SetDeadlines(deadlines Deadlines) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.deadlines = deadlines
+}
+
+func (h *Hub) Deadlines() Deadlines {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.deadlines
+}
+
+// dispatcher routes the messages of a single connection. Slots holds a
+// token for every handler running next to the reader, handlers which missed
+// their deadline keep theirs until they return. Respond sends the answers of
+// these handlers.
+type dispatcher struct {
+	router    Router
+	deadlines Deadlines
+	slots     chan struct{}
+	respond   func(Pong)
+}
+
+func newDispatcher(router Router, deadlines Deadlines, respond func(Pong)) dispatcher {
+	workers := deadlines.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	return dispatcher{
+		router:    router,
+		deadlines: deadlines,
+		slots:     make(chan struct{}, workers),
+		respond:   respond,
+	}
+}
+
+// route returns the answer of a message handled in the reader. A message
+// with a deadline is handed to a free slot, which answers it, and nil is
+// returned; without a free slot it is refused with a busy error.
+func (d dispatcher) route(ping Ping, id string) *Pong {
+	timeout := d.deadlines.timeout(ping.Message)
+	if timeout <= 0 {
+		return d.router.Route(ping, id)
+	}
+	select {
+	case d.slots <- struct{}{}:
+	default:
+		busyHandlers.Inc()
+		log.Printf("Refusing message %s on connection %s, all %d handlers are busy", ping.Message, id, cap(d.slots))
+		return NewErrorPong(NewBusyError(ping.Message))
+	}
+	go d.handle(ping, id, timeout)
+	return nil
+}
+
+// handle answers with a timeout error once the deadline of the message
+// passes and cancels the context of the ping, the late answer of the
+// handler is dropped.
+func (d dispatcher) handle(ping Ping, id string, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	result := make(chan *Pong, 1)
+	go func() {
+		defer func() { <-d.slots }()
+		result <- d.router.Route(ping.WithContext(ctx), id)
+	}()
+	select {
+	case pong := <-result:
+		if pong != nil && pong.Message != NoActionMessage {
+			d.respond(*pong)
+		}
+	case <-ctx.Done():
+		handlerTimeouts.Inc()
+		log.Printf("Handler of message %s on connection %s exceeded %s", ping.Message, id, timeout)
+		d.respond(*NewErrorPong(NewTimeoutError(ping.Message, timeout)))
+	}
+}
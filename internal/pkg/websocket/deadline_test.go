@@ -0,0 +1,132 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+// answers collects the answers a dispatcher sends next to the reader.
+func answers() (chan Pong, func(Pong)) {
+	ch := make(chan Pong, 10)
+	return ch, func(p Pong) { ch <- p }
+}
+
+func errorName(p *Pong) string {
+	if p == nil || p.Message != ErrorMessage {
+		return ""
+	}
+	if e, ok := p.Body.(Error); ok {
+		return e.Name
+	}
+	return ""
+}
+
+func TestDispatcherDoesNotCutStateChangesShort(t *testing.T) {
+	router := Router{
+		BlockForgedMessage: func(Ping, string) (*Pong, error) {
+			time.Sleep(50 * time.Millisecond)
+			return NewResponsePong("added"), nil
+		},
+	}
+	ch, respond := answers()
+	d := newDispatcher(router, Deadlines{Timeout: 10 * time.Millisecond, Workers: 1}, respond)
+	pong := d.route(Ping{Message: BlockForgedMessage}, "1")
+	if pong == nil || pong.Message != ResponseMessage {
+		t.Fatalf("Expected the block to be handled to the end, got %+v", pong)
+	}
+	select {
+	case p := <-ch:
+		t.Errorf("Expected the answer to be returned to the reader, got %+v", p)
+	default:
+	}
+}
+
+func TestDispatcherAnswersReadOnlyMessagesAside(t *testing.T) {
+	cancelled := make(chan struct{})
+	router := Router{
+		GetMissingBlocksMessage: func(ping Ping, _ string) (*Pong, error) {
+			<-ping.Context().Done()
+			close(cancelled)
+			return NewResponsePong("late"), nil
+		},
+		GetBlockchainHeightMessage: func(Ping, string) (*Pong, error) {
+			return NewResponsePong("height"), nil
+		},
+	}
+	ch, respond := answers()
+	d := newDispatcher(router, Deadlines{Timeout: 20 * time.Millisecond, Workers: 2}, respond)
+
+	done := make(chan *Pong)
+	go func() { done <- d.route(Ping{Message: GetMissingBlocksMessage}, "1") }()
+	select {
+	case pong := <-done:
+		if pong != nil {
+			t.Errorf("Expected the message to be answered aside, got %+v", pong)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Reader blocked on a read-only handler")
+	}
+	if pong := d.route(Ping{Message: GetBlockchainHeightMessage}, "1"); pong != nil {
+		t.Errorf("Expected the message to be answered aside, got %+v", pong)
+	}
+
+	received := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case p := <-ch:
+			if name := errorName(&p); name != "" {
+				received[name] = true
+			} else {
+				received[p.Body.(string)] = true
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected 2 answers, got %v", received)
+		}
+	}
+	if !received["height"] || !received[TimeoutErrorName] {
+		t.Errorf("Expected the height and a timeout, got %v", received)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("Expected the handler past its deadline to be cancelled")
+	}
+}
+
+func TestDispatcherRefusesWhenBusy(t *testing.T) {
+	release := make(chan struct{})
+	router := Router{
+		GetBlocksRangeMessage: func(Ping, string) (*Pong, error) {
+			<-release
+			return NewResponsePong("range"), nil
+		},
+	}
+	_, respond := answers()
+	d := newDispatcher(router, Deadlines{Timeout: 10 * time.Millisecond, Workers: 1}, respond)
+	defer close(release)
+	if pong := d.route(Ping{Message: GetBlocksRangeMessage}, "1"); pong != nil {
+		t.Fatalf("Expected the message to be answered aside, got %+v", pong)
+	}
+	// The handler ignores its deadline and keeps the only slot.
+	time.Sleep(30 * time.Millisecond)
+	pong := d.route(Ping{Message: GetBlocksRangeMessage}, "1")
+	if name := errorName(pong); name != BusyErrorName {
+		t.Errorf("Expected a busy error, got %+v", pong)
+	}
+}
+
+func TestParseTimeouts(t *testing.T) {
+	timeouts, err := ParseTimeouts("get-missing-blocks=30s, get-blocks-range=1m")
+	if err != nil {
+		t.Fatalf("Failed to parse timeouts %s", err)
+	}
+	if timeouts[GetMissingBlocksMessage] != 30*time.Second || timeouts[GetBlocksRangeMessage] != time.Minute {
+		t.Errorf("Unexpected timeouts %v", timeouts)
+	}
+	if _, err := ParseTimeouts(BlockForgedMessage.String() + "=1s"); err == nil {
+		t.Error("Expected a deadline of a message changing state to be refused")
+	}
+	if timeout := (Deadlines{Timeout: time.Second}).timeout(TransactionReceivedMessage); timeout != 0 {
+		t.Errorf("Expected no deadline of a message changing state, got %s", timeout)
+	}
+}
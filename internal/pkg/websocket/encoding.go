@@ -0,0 +1,220 @@
+package websocket
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/nebser/crypto-vote/internal/pkg/chain"
+	"github.com/nebser/crypto-vote/internal/pkg/codec"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/pkg/errors"
+)
+
+// Encoding is how messages are sent over a connection. Every connection
+// starts with JSON text frames, the binary encoding is negotiated when a
+// node registers. Received messages are read in either encoding.
+type Encoding string
+
+const (
+	JSONEncoding   Encoding = "json"
+	BinaryEncoding Encoding = "binary"
+)
+
+func ParseEncoding(raw string) (Encoding, error) {
+	switch e := Encoding(raw); e {
+	case JSONEncoding, BinaryEncoding:
+		return e, nil
+	default:
+		return "", errors.Errorf("Unknown encoding %s", raw)
+	}
+}
+
+// PreferEncoding sets the encoding negotiated with nodes registering from
+// now on which offer it.
+func (h *Hub) PreferEncoding(encoding Encoding) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.encoding = encoding
+}
+
+// Negotiate picks the encoding of the connection out of the ones the other
+// end offers, the preferred one if it is offered and JSON otherwise.
+func (h *Hub) Negotiate(internalID string, offered []Encoding) Encoding {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	result := JSONEncoding
+	for _, e := range offered {
+		if e == h.encoding {
+			result = e
+		}
+	}
+	for _, nodes := range []map[string]node{h.pending, h.receivers} {
+		if n, ok := nodes[internalID]; ok {
+			n.peer.Encoding = result
+			nodes[internalID] = n
+		}
+	}
+	return result
+}
+
+// binaryVersion starts every binary frame, so the layout can change.
+const binaryVersion byte = 1
+
+// Body formats of a binary frame. Bodies of messages without a codec are
+// carried as JSON.
+const (
+	jsonBody   byte = 0
+	binaryBody byte = 1
+)
+
+// BodyCodec translates the JSON body of a message to its canonical binary
+// encoding and back. Decoding and encoding again has to give the same bytes,
+// since the signature covers the binary body.
+type BodyCodec struct {
+	Encode func(json.RawMessage) ([]byte, error)
+	Decode func([]byte) (json.RawMessage, error)
+}
+
+var bodyCodecs = map[Message]BodyCodec{
+	TransactionReceivedMessage: {
+		Encode: func(raw json.RawMessage) ([]byte, error) {
+			var body SaveTransactionBody
+			if err := json.Unmarshal(raw, &body); err != nil {
+				return nil, errors.Wrapf(err, "Failed to unmarshal transaction body %s", raw)
+			}
+			w := codec.NewWriter()
+			body.Transaction.Write(w)
+			return w.Result(), nil
+		},
+		Decode: func(raw []byte) (json.RawMessage, error) {
+			r := codec.NewReader(raw)
+			t := transaction.Read(r)
+			if r.Err() != nil {
+				return nil, errors.Wrap(r.Err(), "Failed to decode binary transaction")
+			}
+			return json.Marshal(SaveTransactionBody{Transaction: t})
+		},
+	},
+}
+
+// RegisterBodyCodec sends bodies of the message in their binary encoding. It
+// is called by packages defining bodies this one can't, before any
+// connection is opened.
+func RegisterBodyCodec(message Message, c BodyCodec) {
+	bodyCodecs[message] = c
+}
+
+func encodeBody(message Message, body json.RawMessage) (byte, []byte, error) {
+	c, ok := bodyCodecs[message]
+	if !ok {
+		return jsonBody, body, nil
+	}
+	encoded, err := c.Encode(body)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "Failed to encode body of %s", message)
+	}
+	return binaryBody, encoded, nil
+}
+
+func decodeBody(message Message, format byte, body []byte) (json.RawMessage, error) {
+	switch format {
+	case jsonBody:
+		return body, nil
+	case binaryBody:
+		c, ok := bodyCodecs[message]
+		if !ok {
+			return nil, errors.Errorf("Binary body of %s can't be decoded", message)
+		}
+		return c.Decode(body)
+	default:
+		return nil, errors.Errorf("Unknown body format %d", format)
+	}
+}
+
+type signableBytes []byte
+
+func (s signableBytes) Signable() ([]byte, error) {
+	return s, nil
+}
+
+// binarySignable is what the sender of a binary frame signs, everything but
+// the signature and the delivery id.
+func binarySignable(message Message, chainID chain.ID, sender string, format byte, body []byte) signableBytes {
+	return codec.NewWriter().
+		Byte(binaryVersion).
+		Uint(uint64(message)).
+		String(string(chainID)).
+		String(sender).
+		Byte(format).
+		Bytes(body).
+		Result()
+}
+
+// binarySignable returns the signable of a message received in a binary
+// frame from its JSON body, the binary body is encoded again so a body
+// which doesn't match the signature can't be slipped into a fraud proof.
+func (p Ping) binarySignable() ([]byte, error) {
+	format, body, err := encodeBody(p.Message, p.Body)
+	if err != nil {
+		return nil, err
+	}
+	return binarySignable(p.Message, p.Chain, p.Sender, format, body), nil
+}
+
+// encodeBinary signs the pong and returns its binary frame.
+func (p Pong) encodeBinary(signer wallet.Signer) ([]byte, error) {
+	raw, err := json.Marshal(p.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to marshal body of %s", p.Message)
+	}
+	format, body, err := encodeBody(p.Message, raw)
+	if err != nil {
+		return nil, err
+	}
+	signer = wallet.Current(signer)
+	sender := signer.Verifier()
+	signature, err := signer.SignRaw(binarySignable(p.Message, p.Chain, sender, format, body))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to sign %s", p.Message)
+	}
+	return codec.NewWriter().
+		Byte(binaryVersion).
+		Uint(uint64(p.Message)).
+		String(string(p.Chain)).
+		String(sender).
+		Bytes(signature).
+		String(p.Delivery).
+		Byte(format).
+		Bytes(body).
+		Result(), nil
+}
+
+func decodeBinary(raw []byte) (Ping, error) {
+	r := codec.NewReader(raw)
+	if version := r.Byte(); r.Err() == nil && version != binaryVersion {
+		return Ping{}, errors.Errorf("Unknown binary frame version %d", version)
+	}
+	ping := Ping{
+		Message: Message(r.Uint()),
+		Chain:   chain.ID(r.String()),
+		Sender:  r.String(),
+		Binary:  true,
+	}
+	signature := r.Bytes()
+	ping.Delivery = r.String()
+	format := r.Byte()
+	body := r.Bytes()
+	if r.Err() != nil {
+		return Ping{}, errors.Wrap(r.Err(), "Failed to decode binary frame")
+	}
+	if len(signature) > 0 {
+		ping.Signature = base64.StdEncoding.EncodeToString(signature)
+	}
+	decoded, err := decodeBody(ping.Message, format, body)
+	if err != nil {
+		return Ping{}, err
+	}
+	ping.Body = decoded
+	return ping, nil
+}
@@ -2,6 +2,7 @@ package websocket
 
 import (
 	"fmt"
+	"time"
 )
 
 const (
@@ -11,6 +12,8 @@ const (
 	BlockNotFoundErrorName      = "block-not-found"
 	InvalidDataErrorName        = "invalid-data"
 	InvalidTransactionErrorName = "invalid-transaction"
+	TimeoutErrorName            = "timeout"
+	BusyErrorName               = "busy"
 )
 
 type Error struct {
@@ -59,3 +62,17 @@ func NewInvalidTransactionError() Error {
 		Message: "Invalid transaction signature",
 	}
 }
+
+func NewTimeoutError(message Message, timeout time.Duration) Error {
+	return Error{
+		Name:    TimeoutErrorName,
+		Message: fmt.Sprintf("Handling of message %s exceeded %s", message, timeout),
+	}
+}
+
+func NewBusyError(message Message) Error {
+	return Error{
+		Name:    BusyErrorName,
+		Message: fmt.Sprintf("All handlers are busy, message %s is refused", message),
+	}
+}
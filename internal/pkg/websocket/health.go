@@ -0,0 +1,193 @@
+package websocket
+
+import (
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Heartbeat configures how connections are kept alive. Every Interval a
+// websocket ping is sent over every connection, the connection of a
+// registered node over which nothing came for Timeout is closed, which
+// deregisters the node. Deliveries not acknowledged within Interval are sent
+// again, Retries times at most. A zero Interval disables heartbeats, a zero
+// Timeout keeps silent nodes registered.
+type Heartbeat struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	Retries  int
+}
+
+type delivery struct {
+	message  Pong
+	sentAt   time.Time
+	attempts int
+}
+
+// health is shared by the copies of a node in the hub's maps. Acks tells
+// that the node acknowledged a delivery before, nodes which never did are
+// older versions whose deliveries aren't retried. Latency is the moving
+// average of the round trips of deliveries acknowledged on the first
+// attempt. Offset is the moving average of the offsets of the clock of
+// the other end measured by heartbeats, clocked tells it was measured.
+type health struct {
+	seen       int64
+	acks       bool
+	deliveries map[string]*delivery
+	latency    time.Duration
+	offset     time.Duration
+	clocked    bool
+}
+
+func newHealth() *health {
+	return &health{
+		seen:       time.Now().UnixNano(),
+		deliveries: map[string]*delivery{},
+	}
+}
+
+func (h *health) report(peer Peer) Peer {
+	peer.LastSeen = atomic.LoadInt64(&h.seen) / int64(time.Second)
+	peer.Unacknowledged = len(h.deliveries)
+	peer.Latency = int64(h.latency / time.Millisecond)
+	if h.clocked {
+		offset := int64(h.offset / time.Millisecond)
+		peer.ClockOffset = &offset
+	}
+	return peer
+}
+
+// SetHeartbeat configures connections opened from now on.
+func (h *Hub) SetHeartbeat(heartbeat Heartbeat) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.heartbeat = heartbeat
+}
+
+type HeartbeatFn func() Heartbeat
+
+func (h *Hub) Heartbeat() Heartbeat {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.heartbeat
+}
+
+// Seen records that something came over the connection.
+func (h *Hub) Seen(internalID string) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for _, nodes := range []map[string]node{h.pending, h.receivers} {
+		if n, ok := nodes[internalID]; ok {
+			atomic.StoreInt64(&n.health.seen, time.Now().UnixNano())
+		}
+	}
+}
+
+// Deliver broadcasts the message like Broadcast, every receiver has to
+// acknowledge it or it is sent again.
+func (h *Hub) Deliver(message Pong) int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	sent := 0
+	for id, n := range h.receivers {
+		h.sequence++
+		message.Delivery = strconv.FormatUint(h.sequence, 10)
+		if !n.offer(id, message) {
+			continue
+		}
+		n.health.deliveries[message.Delivery] = &delivery{
+			message:  message,
+			sentAt:   time.Now(),
+			attempts: 1,
+		}
+		sent++
+	}
+	return sent
+}
+
+// Acknowledge records that the other end of the connection received the
+// delivery.
+func (h *Hub) Acknowledge(internalID, deliveryID string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	n, ok := h.receivers[internalID]
+	if !ok {
+		return
+	}
+	n.health.acks = true
+	if d, ok := n.health.deliveries[deliveryID]; ok && d.attempts == 1 {
+		n.health.measure(time.Since(d.sentAt))
+	}
+	delete(n.health.deliveries, deliveryID)
+}
+
+// measure adds a round trip to the average latency, weighing it by a
+// fifth so a single slow delivery doesn't make a node look slow.
+func (h *health) measure(roundTrip time.Duration) {
+	if h.latency == 0 {
+		h.latency = roundTrip
+		return
+	}
+	h.latency = (4*h.latency + roundTrip) / 5
+}
+
+// retry sends deliveries of the node which weren't acknowledged in time
+// again. A node which doesn't acknowledge a delivery sent Retries times is
+// unresponsive and its connection is closed.
+func (h *Hub) retry(id string, n node, now time.Time) {
+	for deliveryID, d := range n.health.deliveries {
+		switch {
+		case now.Sub(d.sentAt) < h.heartbeat.Interval:
+		case !n.health.acks:
+			delete(n.health.deliveries, deliveryID)
+		case d.attempts > h.heartbeat.Retries:
+			log.Printf("Closing connection %s with %s, delivery %s of %s isn't acknowledged", id, n.peer, deliveryID, d.message.Message)
+			n.close()
+			return
+		default:
+			d.attempts++
+			d.sentAt = now
+			if !n.offer(id, d.message) {
+				return
+			}
+		}
+	}
+}
+
+// check evicts silent nodes and retries deliveries of the others.
+func (h *Hub) check() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	now := time.Now()
+	for id, n := range h.receivers {
+		silent := now.Sub(time.Unix(0, atomic.LoadInt64(&n.health.seen)))
+		if h.heartbeat.Timeout > 0 && silent > h.heartbeat.Timeout {
+			log.Printf("Closing connection %s with %s, nothing came over it for %s", id, n.peer, silent.Truncate(time.Second))
+			n.close()
+			continue
+		}
+		h.retry(id, n, now)
+	}
+}
+
+// Monitor evicts silent nodes and retries unacknowledged deliveries every
+// heartbeat interval until the hub shuts down. Pending connections, e.g. of
+// a node catching up before it registers, are never evicted.
+func (h *Hub) Monitor() {
+	interval := h.Heartbeat().Interval
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.lock.RLock()
+		closing := h.closing
+		h.lock.RUnlock()
+		if closing {
+			return
+		}
+		h.check()
+	}
+}
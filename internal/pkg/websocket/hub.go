@@ -1,73 +1,399 @@
 package websocket
 
 import (
-	"math/rand"
+	"context"
+	"log"
+	"net"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/nebser/crypto-vote/internal/pkg/chain"
+	"github.com/nebser/crypto-vote/internal/pkg/metrics"
 	"github.com/pkg/errors"
 )
 
 type node struct {
 	ch     chan Pong
 	nodeID string
+	peer   Peer
+	close  func() error
+	health *health
 }
 
+// Peer identifies the other end of a connection. Fingerprint is the SHA-256
+// of the TLS client certificate, Key is the address of the node key once the
+// node has proven it.
+type Peer struct {
+	Internal    string `json:"internal"`
+	NodeID      string `json:"nodeId,omitempty"`
+	Key         string `json:"key,omitempty"`
+	RemoteAddr  string `json:"remoteAddr"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Outbound    bool   `json:"outbound"`
+	ConnectedAt int64  `json:"connectedAt"`
+	// Encoding is the one messages are sent in, negotiated when the node
+	// registered.
+	Encoding Encoding `json:"encoding,omitempty"`
+	// LastSeen is when the last message or heartbeat came over the
+	// connection, Unacknowledged how many deliveries wait for an
+	// acknowledgement.
+	LastSeen       int64 `json:"lastSeen"`
+	Registered     bool  `json:"registered"`
+	Unacknowledged int   `json:"unacknowledged"`
+	// Latency is the average round trip of acknowledged deliveries in
+	// milliseconds, FailedAt when the node was last reported failing.
+	Latency  int64 `json:"latency,omitempty"`
+	FailedAt int64 `json:"failedAt,omitempty"`
+	// ClockOffset is how far the clock of the other end is ahead in
+	// milliseconds, nil until a heartbeat measured it.
+	ClockOffset *int64 `json:"clockOffset,omitempty"`
+}
+
+func (p Peer) ip() string {
+	host, _, err := net.SplitHostPort(p.RemoteAddr)
+	if err != nil {
+		return p.RemoteAddr
+	}
+	return host
+}
+
+func (p Peer) String() string {
+	result := p.RemoteAddr
+	if p.NodeID != "" {
+		result = "node " + p.NodeID + " at " + result
+	}
+	if p.Key != "" {
+		result += " with key " + p.Key
+	}
+	if p.Fingerprint != "" {
+		result += " with TLS fingerprint " + p.Fingerprint
+	}
+	return result
+}
+
+// Hub keeps the channels of all open connections. It is used concurrently
+// from connection readers, scheduled jobs and http handlers.
+//
+// Invariants:
+//   - pending, receivers, lastReceiver and failures are accessed only while
+//     holding lock.
+//   - a connection channel is closed only by Unregister, under the write lock,
+//     so a sender holding the read lock never sends on a closed channel.
+//   - messages are offered to connection channels while holding the lock and
+//     never block: a connection whose channel is full is too slow, it is
+//     closed and the message dropped, so a slow peer or a slow signer can't
+//     stall the hub. Connection writers drain their channels without taking
+//     the lock, the chain id they stamp is kept outside of it for that reason.
+//   - membership is returned as a copy, callers never see the hub's maps.
+//   - once closing is set no connection is added anymore.
+//   - deliveries, acks, latency and offset of a node's health are written
+//     only while holding the write lock, by Deliver, Acknowledge, check and
+//     MeasureClock, and read while holding at least the read lock, as Peers
+//     does. Its seen time is accessed atomically, Seen stores it under the
+//     read lock.
 type Hub struct {
+	lock         *sync.RWMutex
 	pending      map[string]node
 	receivers    map[string]node
-	registerLock *sync.Mutex
-	lastReceiver int
+	lastReceiver string
+	maxPerIP     int
+	chain        *atomic.Value
+	closing      bool
+	heartbeat    Heartbeat
+	deadlines    Deadlines
+	sequence     uint64
+	encoding     Encoding
+	failures     map[string]time.Time
 }
 
 type BroadcastFn func(Pong) int
 
 type RegisteredNodesFn func() []string
 
-type RandomUnicastFn func(Pong) error
+type RandomUnicastFn func(Pong, Constraints) (Selection, error)
+
+type UnicastFn func(nodeID string, message Pong) error
+
+type NodeIDFn func(internalID string) (string, bool)
+
+type DisconnectFn func(nodeOrKey string) int
+
+type PeersFn func() []Peer
+
+// Registrar is the part of the hub which registers nodes, the handlers of
+// the register message take it.
+type Registrar interface {
+	Identify(internalID, key string)
+	RegisterAtomically(internalID, externalID string) []string
+	Negotiate(internalID string, offered []Encoding) Encoding
+}
+
+var ErrNoReceivers = errors.New("There are no registered receivers")
+
+var ErrTooManyConnections = errors.New("Too many connections from the address")
+
+var ErrShuttingDown = errors.New("Hub is shutting down")
+
+var ErrSlowReceiver = errors.New("Receiver doesn't keep up with its messages")
+
+var slowPeers = metrics.NewCounter("websocket_slow_peers_evicted_total", "Number of connections closed because they didn't keep up with the messages sent to them")
+
+// offer sends the message unless the channel of the connection is full, in
+// which case the connection is closed.
+func (n node) offer(id string, message Pong) bool {
+	select {
+	case n.ch <- message:
+		return true
+	default:
+		slowPeers.Inc()
+		log.Printf("Closing connection %s with %s, it doesn't keep up with its messages, dropping %s", id, n.peer, message.Message)
+		n.close()
+		return false
+	}
+}
 
 func NewHub() *Hub {
 	return &Hub{
-		receivers:    make(map[string]node),
-		pending:      make(map[string]node),
-		registerLock: &sync.Mutex{},
-		lastReceiver: -1,
+		lock:      &sync.RWMutex{},
+		receivers: make(map[string]node),
+		pending:   make(map[string]node),
+		chain:     &atomic.Value{},
+		encoding:  JSONEncoding,
+		failures:  make(map[string]time.Time),
 	}
 }
 
-func (h Hub) Add(ch chan Pong) string {
-	id := uuid.New().String()
-	h.pending[id] = node{ch: ch}
+// LimitPerIP caps the number of inbound connections from a single IP
+// address, 0 means no limit.
+func (h *Hub) LimitPerIP(max int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.maxPerIP = max
+}
+
+// SetChain sets the id of the chain stamped on sent messages, messages from
+// other chains are dropped from then on.
+func (h *Hub) SetChain(id chain.ID) {
+	h.chain.Store(id)
+}
+
+func (h *Hub) Chain() chain.ID {
+	id, _ := h.chain.Load().(chain.ID)
 	return id
 }
 
-func (h Hub) Register(internalID, externalID string) {
-	temp := h.pending[internalID]
+func (h *Hub) connectionsFrom(ip string) int {
+	result := 0
+	for _, nodes := range []map[string]node{h.pending, h.receivers} {
+		for _, n := range nodes {
+			if !n.peer.Outbound && n.peer.ip() == ip {
+				result++
+			}
+		}
+	}
+	return result
+}
+
+// Add tracks the channel of a new connection. The channel is owned by the hub
+// from now on and is closed when the connection is unregistered. Closing the
+// connection makes its reader unregister it.
+func (h *Hub) Add(ch chan Pong, peer Peer, closeConnection func() error) (string, error) {
+	id := uuid.New().String()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.closing {
+		return "", errors.Wrapf(ErrShuttingDown, "Refusing connection from %s", peer)
+	}
+	if !peer.Outbound && h.maxPerIP > 0 && h.connectionsFrom(peer.ip()) >= h.maxPerIP {
+		return "", errors.Wrapf(ErrTooManyConnections, "Refusing connection from %s", peer)
+	}
+	peer.Internal = id
+	peer.ConnectedAt = time.Now().Unix()
+	if peer.Encoding == "" {
+		peer.Encoding = JSONEncoding
+	}
+	h.pending[id] = node{ch: ch, peer: peer, close: closeConnection, health: newHealth()}
+	log.Printf("Connection %s opened with %s", id, peer)
+	return id, nil
+}
+
+// register replaces an earlier connection of the same node, whose old
+// connection is closed.
+func (h *Hub) register(internalID, externalID string) {
+	temp, ok := h.pending[internalID]
+	if !ok {
+		return
+	}
+	for id, n := range h.receivers {
+		if n.nodeID == externalID {
+			log.Printf("Node %s connected again from %s, closing its connection %s with %s", externalID, temp.peer.RemoteAddr, id, n.peer)
+			n.close()
+		}
+	}
 	temp.nodeID = externalID
+	temp.peer.NodeID = externalID
+	temp.peer.Registered = true
 	h.receivers[internalID] = temp
 	delete(h.pending, internalID)
+	log.Printf("Connection %s registered as %s", internalID, temp.peer)
 }
 
-func (h Hub) RegisterAtomically(internalID, externalID string) []string {
-	h.registerLock.Lock()
-	defer h.registerLock.Unlock()
-	nodes := h.RegisteredNodes()
-	h.Register(internalID, externalID)
+func (h *Hub) Register(internalID, externalID string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.register(internalID, externalID)
+}
+
+// RegisterAtomically registers the connection and returns the nodes that
+// were registered before it.
+func (h *Hub) RegisterAtomically(internalID, externalID string) []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	nodes := h.registeredNodes()
+	h.register(internalID, externalID)
 	return nodes
 }
 
-func (h Hub) Unregister(internalID string) {
-	h.registerLock.Lock()
-	defer h.registerLock.Unlock()
-	delete(h.receivers, internalID)
-	delete(h.pending, internalID)
+// Identify records the node key the connection proved to hold.
+func (h *Hub) Identify(internalID, key string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, nodes := range []map[string]node{h.pending, h.receivers} {
+		if n, ok := nodes[internalID]; ok {
+			n.peer.Key = key
+			nodes[internalID] = n
+			log.Printf("Connection %s identified as %s", internalID, n.peer)
+		}
+	}
 }
 
-func (h Hub) Broadcast(message Pong) int {
-	for _, node := range h.receivers {
-		node.ch <- message
+func (h *Hub) Unregister(internalID string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if n, ok := h.receivers[internalID]; ok {
+		close(n.ch)
+		delete(h.receivers, internalID)
+		log.Printf("Connection %s closed with %s", internalID, n.peer)
+	}
+	if n, ok := h.pending[internalID]; ok {
+		close(n.ch)
+		delete(h.pending, internalID)
+		log.Printf("Connection %s closed with %s", internalID, n.peer)
+	}
+}
+
+// Respond sends the answer to a message handled next to the reader of the
+// connection, a disconnect message closes the connection. Answers to
+// connections closed in the meantime are dropped.
+func (h *Hub) Respond(internalID string, message Pong) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for _, nodes := range []map[string]node{h.pending, h.receivers} {
+		n, ok := nodes[internalID]
+		if !ok {
+			continue
+		}
+		if message.Message == DisconnectMessage {
+			n.close()
+			return
+		}
+		n.offer(internalID, message)
+		return
+	}
+}
+
+// Disconnect closes every connection of the node given by its id or key and
+// returns how many were closed.
+func (h *Hub) Disconnect(nodeOrKey string) int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	closed := 0
+	for _, nodes := range []map[string]node{h.pending, h.receivers} {
+		for id, n := range nodes {
+			if n.peer.NodeID != nodeOrKey && n.peer.Key != nodeOrKey {
+				continue
+			}
+			log.Printf("Disconnecting connection %s with %s", id, n.peer)
+			n.close()
+			closed++
+		}
+	}
+	return closed
+}
+
+// Peers returns the peers of all open connections.
+func (h *Hub) Peers() []Peer {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	peers := make([]Peer, 0, len(h.pending)+len(h.receivers))
+	for _, nodes := range []map[string]node{h.pending, h.receivers} {
+		for _, n := range nodes {
+			p := n.health.report(n.peer)
+			if at, ok := h.failures[n.nodeID]; ok && n.nodeID != "" {
+				p.FailedAt = at.Unix()
+			}
+			peers = append(peers, p)
+		}
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].ConnectedAt < peers[j].ConnectedAt
+	})
+	return peers
+}
+
+func (h *Hub) connections() int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return len(h.pending) + len(h.receivers)
+}
+
+// Shutdown refuses new connections, asks the other end of every connection
+// to disconnect and waits until all of them are closed. Connections still
+// open when ctx is done are closed by the hub.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.lock.Lock()
+	h.closing = true
+	h.lock.Unlock()
+	h.lock.RLock()
+	for _, nodes := range []map[string]node{h.pending, h.receivers} {
+		for id, n := range nodes {
+			n.offer(id, *NewDisconnectPong())
+		}
+	}
+	h.lock.RUnlock()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for h.connections() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			h.lock.RLock()
+			for _, nodes := range []map[string]node{h.pending, h.receivers} {
+				for id, n := range nodes {
+					log.Printf("Closing connection %s with %s, it didn't disconnect in time", id, n.peer)
+					n.close()
+				}
+			}
+			h.lock.RUnlock()
+			return errors.Wrap(ctx.Err(), "Failed to wait for connections to disconnect")
+		}
+	}
+	return nil
+}
+
+func (h *Hub) Broadcast(message Pong) int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	sent := 0
+	for id, node := range h.receivers {
+		if node.offer(id, message) {
+			sent++
+		}
 	}
-	return len(h.receivers)
+	return sent
 }
 
 func arrayContains(array []string, target string) bool {
@@ -79,43 +405,58 @@ func arrayContains(array []string, target string) bool {
 	return false
 }
 
-func (h Hub) Multicast(message Pong, receiveCount int, blacklist []string) int {
+func (h *Hub) Multicast(message Pong, receiveCount int, blacklist []string) int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
 	sentCount := 0
-	for _, node := range h.receivers {
+	for id, node := range h.receivers {
+		if sentCount == receiveCount {
+			break
+		}
 		if arrayContains(blacklist, node.nodeID) {
 			continue
 		}
-		node.ch <- message
-		sentCount++
-		if sentCount == receiveCount {
-			return sentCount
+		if node.offer(id, message) {
+			sentCount++
 		}
 	}
 	return sentCount
 }
 
-func (h *Hub) RandomUnicast(message Pong) error {
-	h.registerLock.Lock()
-	defer h.registerLock.Unlock()
-	receiverNum := rand.Intn(len(h.receivers))
-	for receiverNum == h.lastReceiver {
-		receiverNum = rand.Intn(len(h.receivers))
-	}
-	h.lastReceiver = receiverNum
-	num := 0
-	for _, receiver := range h.receivers {
-		if num == receiverNum {
-			receiver.ch <- message
+// Unicast sends the message to the receiver registered as nodeID.
+func (h *Hub) Unicast(nodeID string, message Pong) error {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for id, receiver := range h.receivers {
+		if receiver.nodeID == nodeID {
+			if !receiver.offer(id, message) {
+				return errors.Wrapf(ErrSlowReceiver, "Node %s", nodeID)
+			}
 			return nil
 		}
-		num++
 	}
-	return errors.Errorf("Receiver number (%d) is greater than the number of registered receivers (%d)", receiverNum, len(h.receivers))
+	return errors.Errorf("Node %s is not registered", nodeID)
 }
 
-func (h Hub) RegisteredNodes() (nodes []string) {
+// NodeID returns the id under which the connection has been registered.
+func (h *Hub) NodeID(internalID string) (string, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	receiver, ok := h.receivers[internalID]
+	return receiver.nodeID, ok
+}
+
+func (h *Hub) registeredNodes() []string {
+	nodes := make([]string, 0, len(h.receivers))
 	for _, node := range h.receivers {
 		nodes = append(nodes, node.nodeID)
 	}
-	return
+	return nodes
+}
+
+// RegisteredNodes returns a snapshot of the registered node ids.
+func (h *Hub) RegisteredNodes() []string {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.registeredNodes()
 }
@@ -0,0 +1,128 @@
+package websocket
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// connect adds a connection whose writer drains its channel until the hub
+// closes it.
+func connect(t *testing.T, hub *Hub, remoteAddr string) string {
+	ch := make(chan Pong, sendQueue)
+	id, err := hub.Add(ch, Peer{RemoteAddr: remoteAddr, Outbound: true}, func() error { return nil })
+	if err != nil {
+		t.Errorf("Failed to add connection %s", err)
+		return ""
+	}
+	go func() {
+		for range ch {
+		}
+	}()
+	return id
+}
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func TestHubConcurrentAccess(t *testing.T) {
+	hub := NewHub()
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	run := func(f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+					f(i)
+				}
+			}
+		}()
+	}
+	for w := 0; w < 4; w++ {
+		w := w
+		run(func(i int) {
+			id := connect(t, hub, fmt.Sprintf("10.0.0.%d:%d", w, i))
+			hub.Register(id, fmt.Sprintf("%d-%d", w, i%3))
+			hub.Identify(id, fmt.Sprintf("key-%d", w))
+			if i%2 == 0 {
+				hub.Unregister(id)
+			}
+		})
+	}
+	run(func(int) { hub.Broadcast(*NewNoActionPong()) })
+	run(func(int) { hub.Deliver(*NewNoActionPong()) })
+	run(func(i int) { hub.Unicast(fmt.Sprintf("0-%d", i%3), *NewNoActionPong()) })
+	run(func(int) { hub.Multicast(*NewNoActionPong(), 2, []string{"1-0"}) })
+	run(func(int) {
+		hub.RandomUnicast(*NewNoActionPong(), Constraints{ExcludeUnhealthy: true, PreferLowLatency: true})
+	})
+	run(func(int) { hub.RegisteredNodes() })
+	run(func(int) { hub.Peers() })
+	run(func(i int) { hub.Disconnect(fmt.Sprintf("2-%d", i%3)) })
+	run(func(int) { hub.check() })
+	time.Sleep(500 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+}
+
+func TestHubSlowReceiverDoesNotBlock(t *testing.T) {
+	hub := NewHub()
+	closed := int32(0)
+	slow, err := hub.Add(make(chan Pong, 1), Peer{RemoteAddr: "10.0.0.1:1", Outbound: true}, func() error {
+		atomic.AddInt32(&closed, 1)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to add connection %s", err)
+	}
+	hub.Register(slow, "slow")
+	fast := connect(t, hub, "10.0.0.2:1")
+	hub.Register(fast, "fast")
+
+	done := make(chan int)
+	go func() {
+		sent := 0
+		for i := 0; i < 10; i++ {
+			sent += hub.Broadcast(*NewNoActionPong())
+		}
+		done <- sent
+	}()
+	select {
+	case sent := <-done:
+		if sent != 11 {
+			t.Errorf("Expected 11 messages to be sent, got %d", sent)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Broadcast blocked on a receiver which doesn't drain its channel")
+	}
+	if atomic.LoadInt32(&closed) == 0 {
+		t.Error("Expected the slow connection to be closed")
+	}
+	if err := hub.Unicast("slow", *NewNoActionPong()); err == nil {
+		t.Error("Expected unicast to the slow receiver to fail")
+	}
+
+	registered := make(chan struct{})
+	go func() {
+		hub.Unregister(slow)
+		hub.Register(connect(t, hub, "10.0.0.3:1"), "late")
+		close(registered)
+	}()
+	select {
+	case <-registered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Registration blocked after a slow receiver filled its channel")
+	}
+}
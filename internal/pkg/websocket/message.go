@@ -1,10 +1,13 @@
 package websocket
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 
+	"github.com/nebser/crypto-vote/internal/pkg/chain"
+	"github.com/nebser/crypto-vote/internal/pkg/round"
 	"github.com/nebser/crypto-vote/internal/pkg/transaction"
 	"github.com/nebser/crypto-vote/internal/pkg/wallet"
 	"github.com/pkg/errors"
@@ -25,6 +28,18 @@ const (
 	ForgeBlockMessage
 	BlockForgedMessage
 	DisconnectMessage
+	GetNodesMessage
+	DeregisterMessage
+	CosignFinalizationMessage
+	FinalizationCosignedMessage
+	FraudProofMessage
+	GetAccountMessage
+	PeerExchangeMessage
+	GetBlocksRangeMessage
+	AcknowledgeMessage
+	CompactBlockMessage
+	GetBlockTransactionsMessage
+	BlockTransactionsMessage
 )
 
 func (m Message) String() string {
@@ -53,13 +68,50 @@ func (m Message) String() string {
 		return "block-forged"
 	case DisconnectMessage:
 		return "disconnect"
+	case GetNodesMessage:
+		return "get-nodes"
+	case DeregisterMessage:
+		return "deregister"
+	case CosignFinalizationMessage:
+		return "cosign-finalization"
+	case FinalizationCosignedMessage:
+		return "finalization-cosigned"
+	case FraudProofMessage:
+		return "fraud-proof"
+	case GetAccountMessage:
+		return "get-account"
+	case PeerExchangeMessage:
+		return "peer-exchange"
+	case GetBlocksRangeMessage:
+		return "get-blocks-range"
+	case AcknowledgeMessage:
+		return "acknowledge"
+	case CompactBlockMessage:
+		return "compact-block"
+	case GetBlockTransactionsMessage:
+		return "get-block-transactions"
+	case BlockTransactionsMessage:
+		return "block-transactions"
 	default:
 		return fmt.Sprintf("Unknown message %d", m)
 	}
 }
 
+func ParseMessage(raw string) (Message, error) {
+	for m := GetBlockchainHeightMessage; m <= BlockTransactionsMessage; m++ {
+		if m.String() == raw {
+			return m, nil
+		}
+	}
+	return 0, errors.Errorf("Unknown message %s", raw)
+}
+
+// ForgeBlockBody carries the round the node was selected in, so it can
+// verify the selection. Sortition rounds are sent to every node, which
+// forges only if its VRF lets it.
 type ForgeBlockBody struct {
-	Height int `json:"height"`
+	Height int          `json:"height"`
+	Round  *round.Round `json:"round,omitempty"`
 }
 
 type BlockForgedBody struct {
@@ -71,24 +123,52 @@ type SaveTransactionBody struct {
 	Transaction transaction.Transaction `json:"transaction"`
 }
 
+// Ping is received from the other end. A message with a Delivery id has to
+// be acknowledged with it. The id isn't signed, so nodes which don't know
+// deliveries still verify the message. Binary tells that the message came in
+// a binary frame, whose signature covers the binary encoding. The context of
+// a ping is cancelled once its handler misses the deadline.
 type Ping struct {
 	Message   Message         `json:"message"`
 	Body      json.RawMessage `json:"body"`
 	Signature string          `json:"signature,omitempty"`
 	Sender    string          `json:"sender,omitempty"`
+	Chain     chain.ID        `json:"chain,omitempty"`
+	Delivery  string          `json:"delivery,omitempty"`
+	Binary    bool            `json:"binary,omitempty"`
+	ctx       context.Context
+}
+
+// Context is never nil, pings routed without a deadline have the background
+// context.
+func (p Ping) Context() context.Context {
+	if p.ctx == nil {
+		return context.Background()
+	}
+	return p.ctx
+}
+
+func (p Ping) WithContext(ctx context.Context) Ping {
+	p.ctx = ctx
+	return p
 }
 
 type signablePing struct {
 	Body    json.RawMessage `json:"body"`
 	Sender  string          `json:"sender,omitempty"`
 	Message Message         `json:"message,omitempty"`
+	Chain   chain.ID        `json:"chain,omitempty"`
 }
 
 func (p Ping) Signable() ([]byte, error) {
+	if p.Binary {
+		return p.binarySignable()
+	}
 	s := signablePing{
 		Body:    p.Body,
 		Message: p.Message,
 		Sender:  p.Sender,
+		Chain:   p.Chain,
 	}
 	return json.Marshal(s)
 }
@@ -105,17 +185,30 @@ func (p Ping) Verified() bool {
 	return wallet.Verify(p, signature, senderPKey)
 }
 
+// Pong is sent to the other end, Chain is stamped by the connection writer
+// so the other end can drop messages from another network.
 type Pong struct {
 	Message   Message     `json:"message"`
 	Body      interface{} `json:"body"`
 	Signature string      `json:"signature,omitempty"`
 	Sender    string      `json:"sender,omitempty"`
+	Chain     chain.ID    `json:"chain,omitempty"`
+	Delivery  string      `json:"delivery,omitempty"`
+	switchTo  Encoding
+}
+
+// SwitchingTo makes the connection writer send the following messages in
+// the encoding, the pong itself is still sent in the current one.
+func (p *Pong) SwitchingTo(encoding Encoding) *Pong {
+	p.switchTo = encoding
+	return p
 }
 
 type signablePong struct {
 	Body    interface{} `json:"body"`
 	Sender  string      `json:"sender,omitempty"`
 	Message Message     `json:"message"`
+	Chain   chain.ID    `json:"chain,omitempty"`
 }
 
 func (p Pong) Signable() ([]byte, error) {
@@ -123,11 +216,13 @@ func (p Pong) Signable() ([]byte, error) {
 		Body:    p.Body,
 		Message: p.Message,
 		Sender:  p.Sender,
+		Chain:   p.Chain,
 	}
 	return json.Marshal(s)
 }
 
 func (p Pong) Signed(signer wallet.Signer) (Pong, error) {
+	signer = wallet.Current(signer)
 	p.Sender = signer.Verifier()
 	signature, err := signer.Sign(p)
 	if err != nil {
@@ -138,6 +233,8 @@ func (p Pong) Signed(signer wallet.Signer) (Pong, error) {
 		Message:   p.Message,
 		Sender:    p.Sender,
 		Signature: signature,
+		Chain:     p.Chain,
+		Delivery:  p.Delivery,
 	}, nil
 }
 
@@ -155,10 +252,23 @@ func NewResponsePong(body interface{}) *Pong {
 	}
 }
 
+func NewTransactionReceivedPong(t transaction.Transaction) Pong {
+	return Pong{
+		Message: TransactionReceivedMessage,
+		Body: SaveTransactionBody{
+			Transaction: t,
+		},
+	}
+}
+
 func NewNoActionPong() *Pong {
 	return &Pong{Message: NoActionMessage}
 }
 
+func NewAcknowledgePong(delivery string) *Pong {
+	return &Pong{Message: AcknowledgeMessage, Delivery: delivery}
+}
+
 func NewDisconnectPong() *Pong {
 	return &Pong{Message: DisconnectMessage}
 }
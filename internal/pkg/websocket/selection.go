@@ -0,0 +1,153 @@
+package websocket
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Constraints narrow the receivers RandomUnicast chooses from. Unhealthy
+// receivers are late, silent for longer than two heartbeats, or have a
+// delivery which had to be sent again. Failed receivers were reported by
+// MarkFailed within FailedWithin. Receivers whose stake in Stakes, by node
+// id, is below MinStake are excluded when MinStake is positive. With
+// PreferLowLatency the choice is made among the receivers at most twice as
+// slow as the fastest measured one. Receivers whose clock is off by more
+// than MaxClockOffset are excluded when it is positive.
+type Constraints struct {
+	ExcludeUnhealthy bool
+	FailedWithin     time.Duration
+	MinStake         int
+	Stakes           map[string]int
+	PreferLowLatency bool
+	MaxClockOffset   time.Duration
+}
+
+// Selection tells which receiver RandomUnicast chose and why, Excluded
+// holds the reason every other registered node was left out for.
+type Selection struct {
+	NodeID     string            `json:"nodeId"`
+	Internal   string            `json:"internal"`
+	Reason     string            `json:"reason"`
+	Latency    int64             `json:"latency,omitempty"`
+	Candidates int               `json:"candidates"`
+	Excluded   map[string]string `json:"excluded,omitempty"`
+}
+
+// MarkFailed records that the node failed, e.g. didn't forge a block it was
+// selected for, so it can be left out for a while.
+func (h *Hub) MarkFailed(nodeID string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.failures[nodeID] = time.Now()
+}
+
+// exclusion returns why the receiver doesn't meet the constraints, empty if
+// it does.
+func (h *Hub) exclusion(n node, c Constraints, now time.Time) string {
+	if c.ExcludeUnhealthy {
+		silent := now.Sub(time.Unix(0, atomic.LoadInt64(&n.health.seen)))
+		if h.heartbeat.Interval > 0 && silent > 2*h.heartbeat.Interval {
+			return fmt.Sprintf("silent for %s", silent.Truncate(time.Second))
+		}
+		for deliveryID, d := range n.health.deliveries {
+			if d.attempts > 1 {
+				return fmt.Sprintf("delivery %s was sent %d times", deliveryID, d.attempts)
+			}
+		}
+	}
+	if at, ok := h.failures[n.nodeID]; ok && c.FailedWithin > 0 && now.Sub(at) < c.FailedWithin {
+		return fmt.Sprintf("failed %s ago", now.Sub(at).Truncate(time.Second))
+	}
+	if stake := c.Stakes[n.nodeID]; c.MinStake > 0 && stake < c.MinStake {
+		return fmt.Sprintf("stake %d is below %d", stake, c.MinStake)
+	}
+	if c.MaxClockOffset > 0 && n.health.clocked && abs(n.health.offset) > c.MaxClockOffset {
+		return fmt.Sprintf("clock is off by %s", n.health.offset.Truncate(time.Millisecond))
+	}
+	return ""
+}
+
+// fastest keeps the receivers at most twice as slow as the fastest measured
+// one, all of them if none is measured yet.
+func fastest(ids []string, receivers map[string]node) []string {
+	var best time.Duration
+	for _, id := range ids {
+		if l := receivers[id].health.latency; l > 0 && (best == 0 || l < best) {
+			best = l
+		}
+	}
+	if best == 0 {
+		return ids
+	}
+	var result []string
+	for _, id := range ids {
+		if l := receivers[id].health.latency; l > 0 && l <= 2*best {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// RandomUnicast sends the message to a random receiver meeting the
+// constraints, avoiding the previous one whenever there is another receiver
+// to choose.
+func (h *Hub) RandomUnicast(message Pong, c Constraints) (Selection, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.receivers) == 0 {
+		return Selection{}, ErrNoReceivers
+	}
+	now := time.Now()
+	result := Selection{Excluded: map[string]string{}}
+	var ids []string
+	for id, n := range h.receivers {
+		if reason := h.exclusion(n, c, now); reason != "" {
+			result.Excluded[n.nodeID] = reason
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return result, errors.Wrapf(ErrNoReceivers, "All %d receivers are excluded", len(h.receivers))
+	}
+	result.Candidates = len(ids)
+	if len(ids) > 1 {
+		for i, id := range ids {
+			if id == h.lastReceiver {
+				result.Excluded[h.receivers[id].nodeID] = "chosen the last time"
+				ids = append(ids[:i], ids[i+1:]...)
+				break
+			}
+		}
+	}
+	reason := fmt.Sprintf("random among %d of %d receivers", len(ids), len(h.receivers))
+	if c.PreferLowLatency {
+		fast := fastest(ids, h.receivers)
+		reason = fmt.Sprintf("random among %d of %d receivers within twice the lowest latency", len(fast), len(h.receivers))
+		for _, id := range ids {
+			switch l := h.receivers[id].health.latency; {
+			case arrayContains(fast, id):
+			case l == 0:
+				result.Excluded[h.receivers[id].nodeID] = "latency isn't measured yet"
+			default:
+				result.Excluded[h.receivers[id].nodeID] = fmt.Sprintf("latency %s", l.Truncate(time.Millisecond))
+			}
+		}
+		ids = fast
+	}
+	sort.Strings(ids)
+	chosen := ids[rand.Intn(len(ids))]
+	n := h.receivers[chosen]
+	h.lastReceiver = chosen
+	if !n.offer(chosen, message) {
+		return result, errors.Wrapf(ErrSlowReceiver, "Node %s", n.nodeID)
+	}
+	result.NodeID, result.Internal, result.Reason = n.nodeID, chosen, reason
+	result.Latency = int64(n.health.latency / time.Millisecond)
+	return result, nil
+}
@@ -0,0 +1,218 @@
+package websockettest
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+var ErrClosed = errors.New("Connection is closed")
+
+// Frame is a data or control frame of a connection.
+type Frame struct {
+	Kind int
+	Data []byte
+}
+
+type addr string
+
+func (a addr) Network() string { return "websockettest" }
+
+func (a addr) String() string { return string(a) }
+
+// Conn stands in for a websocket connection, served e.g. by
+// websocket.MaintainConnection. The reader reads the frames given to
+// Receive in order and blocks until the next one comes or the connection is
+// closed; frames written to the connection are captured. Control frames are
+// captured apart, pings are answered with the handler the reader set.
+type Conn struct {
+	lock      *sync.Mutex
+	inbound   chan Frame
+	closed    chan struct{}
+	once      *sync.Once
+	written   []Frame
+	controls  []Frame
+	notify    chan struct{}
+	readLimit int64
+	onPing    func(string) error
+	onPong    func(string) error
+	remote    addr
+}
+
+var _ websocket.Conn = &Conn{}
+
+func NewConn(remoteAddr string) *Conn {
+	return &Conn{
+		lock:    &sync.Mutex{},
+		inbound: make(chan Frame, 100),
+		closed:  make(chan struct{}),
+		once:    &sync.Once{},
+		notify:  make(chan struct{}, 1),
+		remote:  addr(remoteAddr),
+	}
+}
+
+// Receive queues a frame for the reader.
+func (c *Conn) Receive(kind int, data []byte) {
+	c.inbound <- Frame{Kind: kind, Data: data}
+}
+
+// ReceivePing queues the message in a JSON text frame.
+func (c *Conn) ReceivePing(ping websocket.Ping) error {
+	raw, err := json.Marshal(ping)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to marshal message %s", ping.Message)
+	}
+	c.Receive(gorilla.TextMessage, raw)
+	return nil
+}
+
+// ReceiveControl hands a ping or pong frame to the handler the reader set.
+func (c *Conn) ReceiveControl(kind int, appData string) error {
+	c.lock.Lock()
+	onPing, onPong := c.onPing, c.onPong
+	c.lock.Unlock()
+	switch {
+	case kind == gorilla.PingMessage && onPing != nil:
+		return onPing(appData)
+	case kind == gorilla.PongMessage && onPong != nil:
+		return onPong(appData)
+	}
+	return nil
+}
+
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	select {
+	case <-c.closed:
+		return 0, nil, ErrClosed
+	case f := <-c.inbound:
+		c.lock.Lock()
+		limit := c.readLimit
+		c.lock.Unlock()
+		if limit > 0 && int64(len(f.Data)) > limit {
+			return 0, nil, gorilla.ErrReadLimit
+		}
+		return f.Kind, f.Data, nil
+	}
+}
+
+func (c *Conn) write(f Frame, control bool) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	select {
+	case <-c.closed:
+		return ErrClosed
+	default:
+	}
+	if control {
+		c.controls = append(c.controls, f)
+		return nil
+	}
+	c.written = append(c.written, f)
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (c *Conn) WriteMessage(kind int, data []byte) error {
+	return c.write(Frame{Kind: kind, Data: data}, false)
+}
+
+func (c *Conn) WriteJSON(v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.write(Frame{Kind: gorilla.TextMessage, Data: raw}, false)
+}
+
+func (c *Conn) WriteControl(kind int, data []byte, _ time.Time) error {
+	return c.write(Frame{Kind: kind, Data: data}, true)
+}
+
+func (c *Conn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+func (c *Conn) SetReadLimit(limit int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.readLimit = limit
+}
+
+func (c *Conn) SetPingHandler(h func(appData string) error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.onPing = h
+}
+
+func (c *Conn) SetPongHandler(h func(appData string) error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.onPong = h
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// Close makes the reader stop, closing it again does nothing.
+func (c *Conn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+// Written returns the data frames written so far.
+func (c *Conn) Written() []Frame {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]Frame{}, c.written...)
+}
+
+// Controls returns the control frames written so far, e.g. heartbeat pings
+// and the pongs answering pings.
+func (c *Conn) Controls() []Frame {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]Frame{}, c.controls...)
+}
+
+// Messages decodes the data frames written so far, in either encoding.
+func (c *Conn) Messages() ([]websocket.Ping, error) {
+	var result []websocket.Ping
+	for _, f := range c.Written() {
+		ping, err := websocket.DecodeFrame(f.Kind, f.Data)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to decode written frame")
+		}
+		result = append(result, ping)
+	}
+	return result, nil
+}
+
+// WaitWritten waits until at least n data frames were written, the writer
+// of a connection sends them after the reader handled the message. It
+// returns the frames written until then.
+func (c *Conn) WaitWritten(n int, timeout time.Duration) ([]Frame, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		if written := c.Written(); len(written) >= n {
+			return written, nil
+		}
+		select {
+		case <-c.notify:
+		case <-deadline.C:
+			return c.Written(), errors.Errorf("Only %d of %d frames were written within %s", len(c.Written()), n, timeout)
+		}
+	}
+}
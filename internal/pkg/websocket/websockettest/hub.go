@@ -0,0 +1,285 @@
+// Package websockettest provides stand-ins for the websocket layer, so
+// handlers can be tested without sockets: a hub capturing what it sends, a
+// connection serving scripted frames and peers signing the messages they
+// send.
+package websockettest
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+// Sent is a message the hub sent to a single node.
+type Sent struct {
+	NodeID  string
+	Message websocket.Pong
+}
+
+// Hub stands in for websocket.Hub. Its methods have the signatures of the
+// methods of websocket.Hub, so they can be passed to handlers as the same
+// functions. Nothing is sent, broadcasts and messages to single nodes are
+// captured instead. Receivers are the connections registered by a handler
+// or with AddNode.
+//
+// RandomUnicast is deterministic, it chooses the first receiver meeting the
+// constraints after the one chosen the last time in the order of node ids.
+type Hub struct {
+	lock       *sync.Mutex
+	nodes      map[string]string
+	keys       map[string]string
+	encoding   websocket.Encoding
+	last       string
+	failing    map[string]error
+	broadcasts []websocket.Pong
+	sent       []Sent
+}
+
+var _ websocket.Registrar = &Hub{}
+
+func NewHub() *Hub {
+	return &Hub{
+		lock:     &sync.Mutex{},
+		nodes:    map[string]string{},
+		keys:     map[string]string{},
+		encoding: websocket.JSONEncoding,
+		failing:  map[string]error{},
+	}
+}
+
+// AddNode registers the connection as nodeID, as if the node had sent the
+// register message.
+func (h *Hub) AddNode(internalID, nodeID string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.nodes[internalID] = nodeID
+}
+
+func (h *Hub) registeredNodes() []string {
+	result := make([]string, 0, len(h.nodes))
+	for _, nodeID := range h.nodes {
+		result = append(result, nodeID)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// RegisteredNodes returns the registered node ids in order.
+func (h *Hub) RegisteredNodes() []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.registeredNodes()
+}
+
+func (h *Hub) Register(internalID, externalID string) {
+	h.AddNode(internalID, externalID)
+}
+
+func (h *Hub) RegisterAtomically(internalID, externalID string) []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	nodes := h.registeredNodes()
+	h.nodes[internalID] = externalID
+	return nodes
+}
+
+func (h *Hub) Unregister(internalID string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.nodes, internalID)
+	delete(h.keys, internalID)
+}
+
+func (h *Hub) Identify(internalID, key string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.keys[internalID] = key
+}
+
+// Key returns the node key the connection was identified with.
+func (h *Hub) Key(internalID string) string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.keys[internalID]
+}
+
+func (h *Hub) PreferEncoding(encoding websocket.Encoding) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.encoding = encoding
+}
+
+func (h *Hub) Negotiate(_ string, offered []websocket.Encoding) websocket.Encoding {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, e := range offered {
+		if e == h.encoding {
+			return e
+		}
+	}
+	return websocket.JSONEncoding
+}
+
+func (h *Hub) NodeID(internalID string) (string, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	nodeID, ok := h.nodes[internalID]
+	return nodeID, ok
+}
+
+// Disconnect unregisters the connections of the node given by its id or
+// key.
+func (h *Hub) Disconnect(nodeOrKey string) int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	result := 0
+	for internalID, nodeID := range h.nodes {
+		if nodeID == nodeOrKey || h.keys[internalID] == nodeOrKey {
+			delete(h.nodes, internalID)
+			result++
+		}
+	}
+	return result
+}
+
+// FailUnicast makes messages sent to the node fail with the error, nil
+// makes them succeed again.
+func (h *Hub) FailUnicast(nodeID string, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if err == nil {
+		delete(h.failing, nodeID)
+		return
+	}
+	h.failing[nodeID] = err
+}
+
+func (h *Hub) Broadcast(message websocket.Pong) int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.broadcasts = append(h.broadcasts, message)
+	return len(h.nodes)
+}
+
+func (h *Hub) send(nodeID string, message websocket.Pong) error {
+	if err, ok := h.failing[nodeID]; ok {
+		return err
+	}
+	h.sent = append(h.sent, Sent{NodeID: nodeID, Message: message})
+	return nil
+}
+
+func (h *Hub) Unicast(nodeID string, message websocket.Pong) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, id := range h.nodes {
+		if id == nodeID {
+			return h.send(nodeID, message)
+		}
+	}
+	return errors.Errorf("Node %s is not registered", nodeID)
+}
+
+// Multicast sends the message to the first receiveCount receivers in the
+// order of node ids.
+func (h *Hub) Multicast(message websocket.Pong, receiveCount int, blacklist []string) int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	result := 0
+	for _, nodeID := range h.registeredNodes() {
+		if result == receiveCount {
+			break
+		}
+		if contains(blacklist, nodeID) {
+			continue
+		}
+		if h.send(nodeID, message) == nil {
+			result++
+		}
+	}
+	return result
+}
+
+// RandomUnicast honours the stakes of the constraints, the other
+// constraints need the health of real connections.
+func (h *Hub) RandomUnicast(message websocket.Pong, c websocket.Constraints) (websocket.Selection, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	nodes := h.registeredNodes()
+	if len(nodes) == 0 {
+		return websocket.Selection{}, websocket.ErrNoReceivers
+	}
+	result := websocket.Selection{Excluded: map[string]string{}}
+	var candidates []string
+	for _, nodeID := range nodes {
+		if stake := c.Stakes[nodeID]; c.MinStake > 0 && stake < c.MinStake {
+			result.Excluded[nodeID] = fmt.Sprintf("stake %d is below %d", stake, c.MinStake)
+			continue
+		}
+		candidates = append(candidates, nodeID)
+	}
+	if len(candidates) == 0 {
+		return result, errors.Wrapf(websocket.ErrNoReceivers, "All %d receivers are excluded", len(nodes))
+	}
+	chosen := candidates[0]
+	for _, nodeID := range candidates {
+		if nodeID > h.last {
+			chosen = nodeID
+			break
+		}
+	}
+	if err := h.send(chosen, message); err != nil {
+		return result, err
+	}
+	h.last = chosen
+	result.NodeID, result.Candidates, result.Reason = chosen, len(candidates), "next in order of node ids"
+	for internalID, nodeID := range h.nodes {
+		if nodeID == chosen {
+			result.Internal = internalID
+		}
+	}
+	return result, nil
+}
+
+// Broadcasts returns the messages broadcast so far.
+func (h *Hub) Broadcasts() []websocket.Pong {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return append([]websocket.Pong{}, h.broadcasts...)
+}
+
+// Sent returns the messages sent to single nodes so far, by Unicast,
+// Multicast and RandomUnicast.
+func (h *Hub) Sent() []Sent {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return append([]Sent{}, h.sent...)
+}
+
+// Reset forgets the captured messages, registered nodes stay.
+func (h *Hub) Reset() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.broadcasts, h.sent = nil, nil
+}
+
+func contains(array []string, target string) bool {
+	for _, elem := range array {
+		if elem == target {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	_ websocket.BroadcastFn       = (&Hub{}).Broadcast
+	_ websocket.UnicastFn         = (&Hub{}).Unicast
+	_ websocket.RandomUnicastFn   = (&Hub{}).RandomUnicast
+	_ websocket.RegisteredNodesFn = (&Hub{}).RegisteredNodes
+	_ websocket.NodeIDFn          = (&Hub{}).NodeID
+	_ websocket.DisconnectFn      = (&Hub{}).Disconnect
+)
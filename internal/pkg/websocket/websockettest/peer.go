@@ -0,0 +1,103 @@
+package websockettest
+
+import (
+	"encoding/json"
+
+	"github.com/nebser/crypto-vote/internal/pkg/chain"
+	"github.com/nebser/crypto-vote/internal/pkg/wallet"
+	"github.com/nebser/crypto-vote/internal/pkg/websocket"
+	"github.com/pkg/errors"
+)
+
+// Peer is a node on the other end of a connection. Its messages are signed
+// like the messages of a real node, so handlers behind an authorizer verify
+// them. InternalID is the id the hub gave its connection, handlers get it
+// along with every message.
+type Peer struct {
+	InternalID string
+	Signer     wallet.Signer
+	Chain      chain.ID
+	script     []step
+}
+
+type step struct {
+	message websocket.Message
+	body    interface{}
+}
+
+func NewPeer(internalID string, w wallet.Wallet) *Peer {
+	return &Peer{
+		InternalID: internalID,
+		Signer:     wallet.NewSigner(w),
+	}
+}
+
+// OnChain stamps the messages of the peer with the chain id.
+func (p *Peer) OnChain(id chain.ID) *Peer {
+	p.Chain = id
+	return p
+}
+
+// Ping returns the message with the body as the peer sends it.
+func (p *Peer) Ping(message websocket.Message, body interface{}) (websocket.Ping, error) {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return websocket.Ping{}, errors.Wrapf(err, "Failed to marshal body of message %s", message)
+	}
+	signed, err := websocket.Pong{Message: message, Body: json.RawMessage(raw), Chain: p.Chain}.Signed(p.Signer)
+	if err != nil {
+		return websocket.Ping{}, err
+	}
+	return websocket.Ping{
+		Message:   signed.Message,
+		Body:      raw,
+		Signature: signed.Signature,
+		Sender:    signed.Sender,
+		Chain:     signed.Chain,
+	}, nil
+}
+
+// Send routes the message to its handler in the router and returns the
+// answer.
+func (p *Peer) Send(router websocket.Router, message websocket.Message, body interface{}) (*websocket.Pong, error) {
+	ping, err := p.Ping(message, body)
+	if err != nil {
+		return nil, err
+	}
+	return router.Route(ping, p.InternalID), nil
+}
+
+// Then adds the message to the script of the peer.
+func (p *Peer) Then(message websocket.Message, body interface{}) *Peer {
+	p.script = append(p.script, step{message: message, body: body})
+	return p
+}
+
+// Run sends the messages of the script in order and returns their answers.
+// The script is kept, so it can be run again, e.g. against another router.
+func (p *Peer) Run(router websocket.Router) ([]*websocket.Pong, error) {
+	var result []*websocket.Pong
+	for _, s := range p.script {
+		pong, err := p.Send(router, s.message, s.body)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, pong)
+	}
+	return result, nil
+}
+
+// Play queues the messages of the script on the connection in order, for
+// the reader of a connection served by the websocket package.
+func (p *Peer) Play(conn *Conn) error {
+	for _, s := range p.script {
+		ping, err := p.Ping(s.message, s.body)
+		if err != nil {
+			return err
+		}
+		if err := conn.ReceivePing(ping); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package withdrawal
+
+import (
+	"bytes"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/nebser/crypto-vote/internal/pkg/blockchain"
+	"github.com/nebser/crypto-vote/internal/pkg/redact"
+	"github.com/nebser/crypto-vote/internal/pkg/transaction"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrPartyWithdrawn    = errors.New("Party withdrew from the election")
+	ErrInvalidWithdrawal = errors.New("Invalid withdrawal")
+)
+
+// Withdrawn describes a party which left the election.
+type Withdrawn struct {
+	Party       []byte                 `json:"party"`
+	Prior       transaction.PriorVotes `json:"prior"`
+	Reason      string                 `json:"reason,omitempty"`
+	WithdrawnAt int64                  `json:"withdrawnAt"`
+	Transaction []byte                 `json:"transaction"`
+}
+
+// Voided tells whether the votes the party got are left out of the tally.
+func (w Withdrawn) Voided() bool {
+	return w.Prior == transaction.VoidPriorVotes
+}
+
+type GetFn func(party []byte) (Withdrawn, bool)
+
+type ListFn func() []Withdrawn
+
+// SubmitFn withdraws the party, prior decides what happens to the votes it
+// already got.
+type SubmitFn func(party []byte, prior transaction.PriorVotes, reason string) (Withdrawn, error)
+
+// Registry follows withdrawals of parties as blocks are added. Only the
+// first withdrawal of a party counts.
+type Registry struct {
+	lock      sync.RWMutex
+	withdrawn map[string]Withdrawn
+}
+
+// Load restores the withdrawals from the blockchain.
+func Load(findBlock blockchain.FindBlockFn) (*Registry, error) {
+	r := &Registry{withdrawn: map[string]Withdrawn{}}
+	var blocks []blockchain.Block
+	_, _, err := findBlock(func(b blockchain.Block) bool {
+		if _, found := b.Body.Transactions.Find(transaction.Transaction.IsWithdrawal); found {
+			blocks = append(blocks, b)
+		}
+		return false
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to find withdrawals")
+	}
+	for i := len(blocks) - 1; i >= 0; i-- {
+		r.apply(blocks[i])
+	}
+	return r, nil
+}
+
+func (r *Registry) apply(b blockchain.Block) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, t := range b.Body.Transactions {
+		if !t.IsWithdrawal() {
+			continue
+		}
+		w := t.Withdrawal
+		if _, ok := r.withdrawn[string(w.Party)]; ok {
+			continue
+		}
+		r.withdrawn[string(w.Party)] = Withdrawn{
+			Party:       w.Party,
+			Prior:       w.Prior,
+			Reason:      w.Reason,
+			WithdrawnAt: w.WithdrawnAt,
+			Transaction: t.ID,
+		}
+		log.Printf("Party %x withdrew from the election, prior votes are %s: %s", w.Party, w.Prior, w.Reason)
+	}
+}
+
+func (r *Registry) Get(party []byte) (Withdrawn, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	w, ok := r.withdrawn[string(party)]
+	return w, ok
+}
+
+// List returns the withdrawals in the order they happened.
+func (r *Registry) List() []Withdrawn {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	result := []Withdrawn{}
+	for _, w := range r.withdrawn {
+		result = append(result, w)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].WithdrawnAt != result[j].WithdrawnAt {
+			return result[i].WithdrawnAt < result[j].WithdrawnAt
+		}
+		return bytes.Compare(result[i].Party, result[j].Party) < 0
+	})
+	return result
+}
+
+// Validate vetoes transactions giving votes to a withdrawn party.
+func (r *Registry) Validate() transaction.ValidateFn {
+	return func(t transaction.Transaction) error {
+		for _, out := range t.Outputs {
+			if _, ok := r.Get(out.PublicKeyHash); ok {
+				return errors.Wrapf(ErrPartyWithdrawn, "Transaction %x gives votes to %s", t.ID, redact.Key(out.PublicKeyHash))
+			}
+		}
+		return nil
+	}
+}
+
+func (r *Registry) AddBlock(add blockchain.AddBlockFn) blockchain.AddBlockFn {
+	return func(b blockchain.Block) ([]byte, error) {
+		tip, err := add(b)
+		if err != nil {
+			return nil, err
+		}
+		r.apply(b)
+		return tip, nil
+	}
+}
+
+func (r *Registry) AddNewBlock(add blockchain.AddNewBlockFn) blockchain.AddNewBlockFn {
+	return func(b blockchain.Block) error {
+		if err := add(b); err != nil {
+			return err
+		}
+		r.apply(b)
+		return nil
+	}
+}